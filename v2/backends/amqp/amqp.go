@@ -15,15 +15,13 @@ package amqp
 // It is important to consume the queue exclusively to avoid race conditions.
 
 import (
-	"bytes"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 
 	"github.com/RichardKnop/machinery/v2/backends/iface"
 	"github.com/RichardKnop/machinery/v2/common"
 	"github.com/RichardKnop/machinery/v2/config"
-	"github.com/RichardKnop/machinery/v2/log"
 	"github.com/RichardKnop/machinery/v2/tasks"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
@@ -54,7 +52,7 @@ func (b *Backend) GroupCompleted(groupUUID string, groupTaskCount int) (bool, er
 	}
 	defer b.Close(channel, conn)
 
-	queueState, err := b.InspectQueue(channel, groupUUID)
+	queueState, err := b.InspectQueue(channel, b.queueName(groupUUID))
 	if err != nil {
 		return false, nil
 	}
@@ -70,7 +68,7 @@ func (b *Backend) GroupTaskStates(groupUUID string, groupTaskCount int) ([]*task
 	}
 	defer b.Close(channel, conn)
 
-	queueState, err := b.InspectQueue(channel, groupUUID)
+	queueState, err := b.InspectQueue(channel, b.queueName(groupUUID))
 	if err != nil {
 		return nil, err
 	}
@@ -80,13 +78,13 @@ func (b *Backend) GroupTaskStates(groupUUID string, groupTaskCount int) ([]*task
 	}
 
 	deliveries, err := channel.Consume(
-		groupUUID, // queue name
-		"",        // consumer tag
-		false,     // auto-ack
-		true,      // exclusive
-		false,     // no-local
-		false,     // no-wait
-		nil,       // arguments
+		b.queueName(groupUUID), // queue name
+		"",                     // consumer tag
+		false,                  // auto-ack
+		true,                   // exclusive
+		false,                  // no-local
+		false,                  // no-wait
+		nil,                    // arguments
 	)
 	if err != nil {
 		return nil, fmt.Errorf("Queue consume error: %s", err)
@@ -96,10 +94,8 @@ func (b *Backend) GroupTaskStates(groupUUID string, groupTaskCount int) ([]*task
 	for i := 0; i < groupTaskCount; i++ {
 		d := <-deliveries
 
-		state := new(tasks.TaskState)
-		decoder := json.NewDecoder(bytes.NewReader([]byte(d.Body)))
-		decoder.UseNumber()
-		if err := decoder.Decode(state); err != nil {
+		state, err := b.DecodeTaskState(d.Body)
+		if err != nil {
 			d.Nack(false, false) // multiple, requeue
 			return nil, err
 		}
@@ -123,7 +119,7 @@ func (b *Backend) TriggerChord(groupUUID string) (bool, error) {
 	}
 	defer b.Close(channel, conn)
 
-	_, err = b.InspectQueue(channel, amqmChordTriggeredQueue(groupUUID))
+	_, err = b.InspectQueue(channel, b.queueName(amqmChordTriggeredQueue(groupUUID)))
 	if err != nil {
 		return true, nil
 	}
@@ -134,32 +130,32 @@ func (b *Backend) TriggerChord(groupUUID string) (bool, error) {
 // SetStatePending updates task state to PENDING
 func (b *Backend) SetStatePending(signature *tasks.Signature) error {
 	taskState := tasks.NewPendingTaskState(signature)
-	return b.updateState(taskState)
+	return b.updateState(signature, taskState)
 }
 
 // SetStateReceived updates task state to RECEIVED
 func (b *Backend) SetStateReceived(signature *tasks.Signature) error {
 	taskState := tasks.NewReceivedTaskState(signature)
-	return b.updateState(taskState)
+	return b.updateState(signature, taskState)
 }
 
 // SetStateStarted updates task state to STARTED
 func (b *Backend) SetStateStarted(signature *tasks.Signature) error {
 	taskState := tasks.NewStartedTaskState(signature)
-	return b.updateState(taskState)
+	return b.updateState(signature, taskState)
 }
 
 // SetStateRetry updates task state to RETRY
 func (b *Backend) SetStateRetry(signature *tasks.Signature) error {
 	state := tasks.NewRetryTaskState(signature)
-	return b.updateState(state)
+	return b.updateState(signature, state)
 }
 
 // SetStateSuccess updates task state to SUCCESS
 func (b *Backend) SetStateSuccess(signature *tasks.Signature, results []*tasks.TaskResult) error {
 	taskState := tasks.NewSuccessTaskState(signature, results)
 
-	if err := b.updateState(taskState); err != nil {
+	if err := b.updateState(signature, taskState); err != nil {
 		return err
 	}
 
@@ -174,7 +170,7 @@ func (b *Backend) SetStateSuccess(signature *tasks.Signature, results []*tasks.T
 func (b *Backend) SetStateFailure(signature *tasks.Signature, err string) error {
 	taskState := tasks.NewFailureTaskState(signature, err)
 
-	if err := b.updateState(taskState); err != nil {
+	if err := b.updateState(signature, taskState); err != nil {
 		return err
 	}
 
@@ -191,21 +187,21 @@ func (b *Backend) GetState(taskUUID string) (*tasks.TaskState, error) {
 	declareQueueArgs := amqp.Table{
 		// Time in milliseconds
 		// after that message will expire
-		"x-message-ttl": int32(b.getExpiresIn()),
+		"x-message-ttl": int32(b.getExpiresIn(nil)),
 		// Time after that the queue will be deleted.
-		"x-expires": int32(b.getExpiresIn()),
+		"x-expires": int32(b.getExpiresIn(nil)),
 	}
 	conn, channel, _, _, _, err := b.Connect(
 		b.GetConfig().ResultBackend,
 		"",
 		b.GetConfig().TLSConfig,
-		b.GetConfig().AMQP.Exchange,     // exchange name
+		b.exchange(),                    // exchange name
 		b.GetConfig().AMQP.ExchangeType, // exchange type
-		taskUUID,                        // queue name
+		b.queueName(taskUUID),           // queue name
 		false,                           // queue durable
 		true,                            // queue delete when unused
-		taskUUID,                        // queue binding key
-		nil,                             // exchange declare args
+		b.queueName(taskUUID),           // queue binding key
+		b.exchangeDeclareArgs(),         // exchange declare args
 		declareQueueArgs,                // queue declare args
 		nil,                             // queue binding args
 	)
@@ -215,8 +211,8 @@ func (b *Backend) GetState(taskUUID string) (*tasks.TaskState, error) {
 	defer b.Close(channel, conn)
 
 	d, ok, err := channel.Get(
-		taskUUID, // queue name
-		false,    // multiple
+		b.queueName(taskUUID), // queue name
+		false,                 // multiple
 	)
 	if err != nil {
 		return nil, err
@@ -227,12 +223,10 @@ func (b *Backend) GetState(taskUUID string) (*tasks.TaskState, error) {
 
 	d.Ack(false)
 
-	state := new(tasks.TaskState)
-	decoder := json.NewDecoder(bytes.NewReader([]byte(d.Body)))
-	decoder.UseNumber()
-	if err := decoder.Decode(state); err != nil {
-		log.ERROR.Printf("Failed to unmarshal task state: %s", string(d.Body))
-		log.ERROR.Print(err)
+	state, err := b.DecodeTaskState(d.Body)
+	if err != nil {
+		b.Logger().ERROR.Printf("Failed to unmarshal task state: %s", string(d.Body))
+		b.Logger().ERROR.Print(err)
 		return nil, err
 	}
 
@@ -247,7 +241,7 @@ func (b *Backend) PurgeState(taskUUID string) error {
 	}
 	defer b.Close(channel, conn)
 
-	return b.DeleteQueue(channel, taskUUID)
+	return b.DeleteQueue(channel, b.queueName(taskUUID))
 }
 
 // PurgeGroupMeta deletes stored group meta data
@@ -258,36 +252,36 @@ func (b *Backend) PurgeGroupMeta(groupUUID string) error {
 	}
 	defer b.Close(channel, conn)
 
-	b.DeleteQueue(channel, amqmChordTriggeredQueue(groupUUID))
+	b.DeleteQueue(channel, b.queueName(amqmChordTriggeredQueue(groupUUID)))
 
-	return b.DeleteQueue(channel, groupUUID)
+	return b.DeleteQueue(channel, b.queueName(groupUUID))
 }
 
 // updateState saves current task state
-func (b *Backend) updateState(taskState *tasks.TaskState) error {
-	message, err := json.Marshal(taskState)
+func (b *Backend) updateState(signature *tasks.Signature, taskState *tasks.TaskState) error {
+	message, err := b.EncodeTaskState(taskState)
 	if err != nil {
-		return fmt.Errorf("JSON marshal error: %s", err)
+		return fmt.Errorf("Encode task state error: %s", err)
 	}
 
 	declareQueueArgs := amqp.Table{
 		// Time in milliseconds
 		// after that message will expire
-		"x-message-ttl": int32(b.getExpiresIn()),
+		"x-message-ttl": int32(b.getExpiresIn(nil)),
 		// Time after that the queue will be deleted.
-		"x-expires": int32(b.getExpiresIn()),
+		"x-expires": int32(b.getExpiresIn(nil)),
 	}
 	conn, channel, queue, confirmsChan, _, err := b.Connect(
 		b.GetConfig().ResultBackend,
 		"",
 		b.GetConfig().TLSConfig,
-		b.GetConfig().AMQP.Exchange,     // exchange name
+		b.exchange(),                    // exchange name
 		b.GetConfig().AMQP.ExchangeType, // exchange type
-		taskState.TaskUUID,              // queue name
+		b.queueName(taskState.TaskUUID), // queue name
 		false,                           // queue durable
 		true,                            // queue delete when unused
-		taskState.TaskUUID,              // queue binding key
-		nil,                             // exchange declare args
+		b.queueName(taskState.TaskUUID), // queue binding key
+		b.exchangeDeclareArgs(),         // exchange declare args
 		declareQueueArgs,                // queue declare args
 		nil,                             // queue binding args
 	)
@@ -297,14 +291,17 @@ func (b *Backend) updateState(taskState *tasks.TaskState) error {
 	defer b.Close(channel, conn)
 
 	if err := channel.Publish(
-		b.GetConfig().AMQP.Exchange, // exchange
-		queue.Name,                  // routing key
-		false,                       // mandatory
-		false,                       // immediate
+		b.exchange(), // exchange
+		queue.Name,   // routing key
+		false,        // mandatory
+		false,        // immediate
 		amqp.Publishing{
-			ContentType:  "application/json",
+			ContentType:  b.ContentType(),
 			Body:         message,
 			DeliveryMode: amqp.Persistent, // Persistent // Transient
+			// Per-message TTL can only shorten the TTL of the queue, queue
+			// arguments must stay the same for every declaration
+			Expiration: strconv.Itoa(b.getExpiresIn(signature)),
 		},
 	); err != nil {
 		return err
@@ -320,13 +317,13 @@ func (b *Backend) updateState(taskState *tasks.TaskState) error {
 }
 
 // getExpiresIn returns expiration time
-func (b *Backend) getExpiresIn() int {
-	resultsExpireIn := b.GetConfig().ResultsExpireIn * 1000
-	if resultsExpireIn == 0 {
-		// // expire results after 1 hour by default
-		resultsExpireIn = config.DefaultResultsExpireIn * 1000
-	}
-	return resultsExpireIn
+func (b *Backend) getExpiresIn(signature *tasks.Signature) int {
+	return b.ResultsExpireIn(signature) * 1000
+}
+
+// getGroupMetaExpiresIn returns expiration time of group meta data
+func (b *Backend) getGroupMetaExpiresIn() int {
+	return b.GroupMetaExpireIn() * 1000
 }
 
 // markTaskCompleted marks task as completed in either groupdUUID_success
@@ -337,31 +334,31 @@ func (b *Backend) markTaskCompleted(signature *tasks.Signature, taskState *tasks
 		return nil
 	}
 
-	message, err := json.Marshal(taskState)
+	message, err := b.EncodeTaskState(taskState)
 	if err != nil {
-		return fmt.Errorf("JSON marshal error: %s", err)
+		return fmt.Errorf("Encode task state error: %s", err)
 	}
 
 	declareQueueArgs := amqp.Table{
 		// Time in milliseconds
 		// after that message will expire
-		"x-message-ttl": int32(b.getExpiresIn()),
+		"x-message-ttl": int32(b.getGroupMetaExpiresIn()),
 		// Time after that the queue will be deleted.
-		"x-expires": int32(b.getExpiresIn()),
+		"x-expires": int32(b.getGroupMetaExpiresIn()),
 	}
 	conn, channel, queue, confirmsChan, _, err := b.Connect(
 		b.GetConfig().ResultBackend,
 		"",
 		b.GetConfig().TLSConfig,
-		b.GetConfig().AMQP.Exchange,     // exchange name
-		b.GetConfig().AMQP.ExchangeType, // exchange type
-		signature.GroupUUID,             // queue name
-		false,                           // queue durable
-		true,                            // queue delete when unused
-		signature.GroupUUID,             // queue binding key
-		nil,                             // exchange declare args
-		declareQueueArgs,                // queue declare args
-		nil,                             // queue binding args
+		b.exchange(),                     // exchange name
+		b.GetConfig().AMQP.ExchangeType,  // exchange type
+		b.queueName(signature.GroupUUID), // queue name
+		false,                            // queue durable
+		true,                             // queue delete when unused
+		b.queueName(signature.GroupUUID), // queue binding key
+		b.exchangeDeclareArgs(),          // exchange declare args
+		declareQueueArgs,                 // queue declare args
+		nil,                              // queue binding args
 	)
 	if err != nil {
 		return err
@@ -369,12 +366,12 @@ func (b *Backend) markTaskCompleted(signature *tasks.Signature, taskState *tasks
 	defer b.Close(channel, conn)
 
 	if err := channel.Publish(
-		b.GetConfig().AMQP.Exchange, // exchange
-		queue.Name,                  // routing key
-		false,                       // mandatory
-		false,                       // immediate
+		b.exchange(), // exchange
+		queue.Name,   // routing key
+		false,        // mandatory
+		false,        // immediate
 		amqp.Publishing{
-			ContentType:  "application/json",
+			ContentType:  b.ContentType(),
 			Body:         message,
 			DeliveryMode: amqp.Persistent, // Persistent // Transient
 		},
@@ -391,6 +388,23 @@ func (b *Backend) markTaskCompleted(signature *tasks.Signature, taskState *tasks
 	return nil
 }
 
+// exchange returns the name of the exchange in the namespace
+func (b *Backend) exchange() string {
+	return b.GetConfig().Namespaced(b.GetConfig().AMQP.Exchange)
+}
+
+// exchangeDeclareArgs returns the arguments the exchange is declared with,
+// the same as the broker's
+func (b *Backend) exchangeDeclareArgs() amqp.Table {
+	return common.AMQPExchangeDeclareArgs(b.GetConfig())
+}
+
+// queueName returns the name of the queue holding states of the task or
+// group in the namespace
+func (b *Backend) queueName(uuid string) string {
+	return b.GetConfig().Namespaced(uuid)
+}
+
 func amqmChordTriggeredQueue(groupUUID string) string {
 	return fmt.Sprintf("%s_chord_triggered", groupUUID)
 }
@@ -1,9 +1,13 @@
+//go:build !machinery_no_aws
+// +build !machinery_no_aws
+
 package dynamodb
 
 import (
 	"errors"
 	"fmt"
 	"math"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -11,7 +15,6 @@ import (
 	"github.com/RichardKnop/machinery/v2/backends/iface"
 	"github.com/RichardKnop/machinery/v2/common"
 	"github.com/RichardKnop/machinery/v2/config"
-	"github.com/RichardKnop/machinery/v2/log"
 	"github.com/RichardKnop/machinery/v2/tasks"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
@@ -20,8 +23,11 @@ import (
 )
 
 const (
-	BatchItemsLimit  = 99
-	MaxFetchAttempts = 3
+	BatchItemsLimit       = 99
+	MaxFetchAttempts      = 3
+	BatchWriteItemsLimit  = 25
+	MaxBatchWriteAttempts = 5
+	DefaultTTLAttribute   = "TTL"
 )
 
 // Backend ...
@@ -31,6 +37,12 @@ type Backend struct {
 	client dynamodbiface.DynamoDBAPI
 }
 
+// batchWriteItem is an item to put into a table with BatchWriteItem
+type batchWriteItem struct {
+	table string
+	av    map[string]*dynamodb.AttributeValue
+}
+
 // New creates a Backend instance
 func New(cnf *config.Config) iface.Backend {
 	backend := &Backend{Backend: common.NewBackend(cnf), cnf: cnf}
@@ -47,7 +59,13 @@ func New(cnf *config.Config) iface.Backend {
 	// Check if needed tables exist
 	err := backend.checkRequiredTablesIfExist()
 	if err != nil {
-		log.FATAL.Printf("Failed to prepare tables. Error: %v", err)
+		backend.Logger().FATAL.Printf("Failed to prepare tables. Error: %v", err)
+	}
+
+	if cnf.DynamoDB.EnableTTL {
+		if err := backend.enableTTL(); err != nil {
+			backend.Logger().ERROR.Printf("Failed to enable TTL. Error: %v", err)
+		}
 	}
 	return backend
 }
@@ -58,26 +76,69 @@ func (b *Backend) InitGroup(groupUUID string, taskUUIDs []string) error {
 		GroupUUID: groupUUID,
 		TaskUUIDs: taskUUIDs,
 		CreatedAt: time.Now().UTC(),
-		TTL:       b.getExpirationTime(),
+		TTL:       b.getGroupMetaExpirationTime(),
 	}
-	av, err := dynamodbattribute.MarshalMap(meta)
+	av, err := b.marshalItem(meta)
 	if err != nil {
-		log.ERROR.Printf("Error when marshaling Dynamodb attributes. Err: %v", err)
+		b.Logger().ERROR.Printf("Error when marshaling Dynamodb attributes. Err: %v", err)
 		return err
 	}
 	input := &dynamodb.PutItemInput{
 		Item:      av,
-		TableName: aws.String(b.cnf.DynamoDB.GroupMetasTable),
+		TableName: aws.String(b.groupMetasTable()),
 	}
 	_, err = b.client.PutItem(input)
 
 	if err != nil {
-		log.ERROR.Printf("Got error when calling PutItem: %v; Error: %v", input, err)
+		b.Logger().ERROR.Printf("Got error when calling PutItem: %v; Error: %v", input, err)
 		return err
 	}
 	return nil
 }
 
+// InitGroupPending saves the group meta data and the pending states of all
+// its tasks with as few BatchWriteItem calls as possible
+func (b *Backend) InitGroupPending(groupUUID string, signatures []*tasks.Signature) error {
+	taskUUIDs := make([]string, len(signatures))
+	for i, signature := range signatures {
+		taskUUIDs[i] = signature.UUID
+	}
+
+	meta := tasks.GroupMeta{
+		GroupUUID: groupUUID,
+		TaskUUIDs: taskUUIDs,
+		CreatedAt: time.Now().UTC(),
+		TTL:       b.getGroupMetaExpirationTime(),
+	}
+	av, err := b.marshalItem(meta)
+	if err != nil {
+		b.Logger().ERROR.Printf("Error when marshaling Dynamodb attributes. Err: %v", err)
+		return err
+	}
+
+	items := []batchWriteItem{{b.groupMetasTable(), av}}
+	for _, signature := range signatures {
+		taskState := tasks.NewPendingTaskState(signature)
+		taskState.TTL = b.getExpirationTime(signature)
+		av, err := b.marshalItem(taskState)
+		if err != nil {
+			b.Logger().ERROR.Printf("Error when marshaling Dynamodb attributes. Err: %v", err)
+			return err
+		}
+		items = append(items, batchWriteItem{b.taskStatesTable(), av})
+	}
+
+	for len(items) > 0 {
+		size := min(len(items), BatchWriteItemsLimit)
+		if err := b.batchWrite(items[:size]); err != nil {
+			return err
+		}
+		items = items[size:]
+	}
+
+	return nil
+}
+
 // GroupCompleted ...
 func (b *Backend) GroupCompleted(groupUUID string, groupTaskCount int) (bool, error) {
 	groupMeta, err := b.getGroupMeta(groupUUID)
@@ -126,7 +187,7 @@ func (b *Backend) TriggerChord(groupUUID string) (bool, error) {
 	// If group meta is locked, wait until it's unlocked
 	for groupMeta.Lock {
 		groupMeta, _ = b.getGroupMeta(groupUUID)
-		log.WARNING.Printf("Group [%s] locked, waiting", groupUUID)
+		b.Logger().WARNING.Printf("Group [%s] locked, waiting", groupUUID)
 		time.Sleep(time.Millisecond * 5)
 	}
 
@@ -147,6 +208,8 @@ func (b *Backend) TriggerChord(groupUUID string) (bool, error) {
 // SetStatePending ...
 func (b *Backend) SetStatePending(signature *tasks.Signature) error {
 	taskState := tasks.NewPendingTaskState(signature)
+	// expire tasks which never finish as well
+	taskState.TTL = b.getExpirationTime(signature)
 	// taskUUID is the primary key of the table, so a new task need to be created first, instead of using dynamodb.UpdateItemInput directly
 	return b.initTaskState(taskState)
 }
@@ -172,21 +235,21 @@ func (b *Backend) SetStateRetry(signature *tasks.Signature) error {
 // SetStateSuccess ...
 func (b *Backend) SetStateSuccess(signature *tasks.Signature, results []*tasks.TaskResult) error {
 	taskState := tasks.NewSuccessTaskState(signature, results)
-	taskState.TTL = b.getExpirationTime()
+	taskState.TTL = b.getExpirationTime(signature)
 	return b.setTaskState(taskState)
 }
 
 // SetStateFailure ...
 func (b *Backend) SetStateFailure(signature *tasks.Signature, err string) error {
 	taskState := tasks.NewFailureTaskState(signature, err)
-	taskState.TTL = b.getExpirationTime()
+	taskState.TTL = b.getExpirationTime(signature)
 	return b.updateToFailureStateWithError(taskState)
 }
 
 // GetState ...
 func (b *Backend) GetState(taskUUID string) (*tasks.TaskState, error) {
 	result, err := b.client.GetItem(&dynamodb.GetItemInput{
-		TableName: aws.String(b.cnf.DynamoDB.TaskStatesTable),
+		TableName: aws.String(b.taskStatesTable()),
 		Key: map[string]*dynamodb.AttributeValue{
 			"TaskUUID": {
 				S: aws.String(taskUUID),
@@ -222,7 +285,7 @@ func (b *Backend) getStates(tasksToFetch []string) ([]*tasks.TaskState, error) {
 		// Check if there were any tasks that were not fetched. If so, retry with exponential backoff.
 		if len(unfetchedTaskIDs) > 0 {
 			backoffDuration := time.Duration(math.Pow(2, float64(attempt))) * time.Second
-			log.DEBUG.Printf("Unable to fetch [%d] keys on attempt [%d]. Sleeping for [%s]", len(unfetchedTaskIDs), attempt+1, backoffDuration)
+			b.Logger().DEBUG.Printf("Unable to fetch [%d] keys on attempt [%d]. Sleeping for [%s]", len(unfetchedTaskIDs), attempt+1, backoffDuration)
 			time.Sleep(backoffDuration)
 		}
 	}
@@ -239,7 +302,7 @@ func (b *Backend) getStates(tasksToFetch []string) ([]*tasks.TaskState, error) {
 // return value so that the caller can retry those keys.
 // https://docs.aws.amazon.com/sdk-for-go/api/service/dynamodb/#DynamoDB.BatchGetItem
 func (b *Backend) batchFetchTaskStates(taskUUIDs []string) ([]*tasks.TaskState, []string, error) {
-	tableName := b.cnf.DynamoDB.TaskStatesTable
+	tableName := b.taskStatesTable()
 	keys := make([]map[string]*dynamodb.AttributeValue, len(taskUUIDs))
 	for i, tid := range taskUUIDs {
 		keys[i] = map[string]*dynamodb.AttributeValue{
@@ -293,7 +356,7 @@ func (b *Backend) PurgeState(taskUUID string) error {
 				N: aws.String(taskUUID),
 			},
 		},
-		TableName: aws.String(b.cnf.DynamoDB.TaskStatesTable),
+		TableName: aws.String(b.taskStatesTable()),
 	}
 	_, err := b.client.DeleteItem(input)
 
@@ -311,7 +374,7 @@ func (b *Backend) PurgeGroupMeta(groupUUID string) error {
 				N: aws.String(groupUUID),
 			},
 		},
-		TableName: aws.String(b.cnf.DynamoDB.GroupMetasTable),
+		TableName: aws.String(b.groupMetasTable()),
 	}
 	_, err := b.client.DeleteItem(input)
 
@@ -323,7 +386,7 @@ func (b *Backend) PurgeGroupMeta(groupUUID string) error {
 
 func (b *Backend) getGroupMeta(groupUUID string) (*tasks.GroupMeta, error) {
 	result, err := b.client.GetItem(&dynamodb.GetItemInput{
-		TableName: aws.String(b.cnf.DynamoDB.GroupMetasTable),
+		TableName: aws.String(b.groupMetasTable()),
 		Key: map[string]*dynamodb.AttributeValue{
 			"GroupUUID": {
 				S: aws.String(groupUUID),
@@ -332,12 +395,12 @@ func (b *Backend) getGroupMeta(groupUUID string) (*tasks.GroupMeta, error) {
 		ConsistentRead: aws.Bool(true),
 	})
 	if err != nil {
-		log.ERROR.Printf("Error when getting group [%s]. Error: [%s]", groupUUID, err)
+		b.Logger().ERROR.Printf("Error when getting group [%s]. Error: [%s]", groupUUID, err)
 		return nil, err
 	}
 	item, err := b.unmarshalGroupMetaGetItemResult(result)
 	if err != nil {
-		log.ERROR.Printf("Failed to unmarshal item. Error: [%s], Result: [%+v]", err, result)
+		b.Logger().ERROR.Printf("Failed to unmarshal item. Error: [%s], Result: [%+v]", err, result)
 		return nil, err
 	}
 	return item, nil
@@ -375,7 +438,7 @@ func (b *Backend) updateGroupMetaLock(groupUUID string, status bool) error {
 			},
 		},
 		ReturnValues:     aws.String("UPDATED_NEW"),
-		TableName:        aws.String(b.cnf.DynamoDB.GroupMetasTable),
+		TableName:        aws.String(b.groupMetasTable()),
 		UpdateExpression: aws.String("SET #L = :l"),
 	}
 
@@ -403,7 +466,7 @@ func (b *Backend) chordTriggered(groupUUID string) error {
 			},
 		},
 		ReturnValues:     aws.String("UPDATED_NEW"),
-		TableName:        aws.String(b.cnf.DynamoDB.GroupMetasTable),
+		TableName:        aws.String(b.groupMetasTable()),
 		UpdateExpression: aws.String("SET #CT = :ct"),
 	}
 
@@ -438,7 +501,7 @@ func (b *Backend) setTaskState(taskState *tasks.TaskState) error {
 		exp += ", #C = :c"
 	}
 	if taskState.TTL > 0 {
-		expAttributeNames["#T"] = aws.String("TTL")
+		expAttributeNames["#T"] = aws.String(b.ttlAttribute())
 		expAttributeValues[":t"] = &dynamodb.AttributeValue{
 			N: aws.String(fmt.Sprintf("%d", taskState.TTL)),
 		}
@@ -466,12 +529,13 @@ func (b *Backend) setTaskState(taskState *tasks.TaskState) error {
 		}
 		exp += ", #R = :r"
 	}
+	exp += setTiming(taskState, expAttributeNames, expAttributeValues)
 	input := &dynamodb.UpdateItemInput{
 		ExpressionAttributeNames:  expAttributeNames,
 		ExpressionAttributeValues: expAttributeValues,
 		Key:                       keyAttributeValues,
 		ReturnValues:              aws.String("UPDATED_NEW"),
-		TableName:                 aws.String(b.cnf.DynamoDB.TaskStatesTable),
+		TableName:                 aws.String(b.taskStatesTable()),
 		UpdateExpression:          aws.String(exp),
 	}
 
@@ -484,10 +548,10 @@ func (b *Backend) setTaskState(taskState *tasks.TaskState) error {
 }
 
 func (b *Backend) initTaskState(taskState *tasks.TaskState) error {
-	av, err := dynamodbattribute.MarshalMap(taskState)
+	av, err := b.marshalItem(taskState)
 	input := &dynamodb.PutItemInput{
 		Item:      av,
-		TableName: aws.String(b.cnf.DynamoDB.TaskStatesTable),
+		TableName: aws.String(b.taskStatesTable()),
 	}
 	if err != nil {
 		return err
@@ -520,17 +584,18 @@ func (b *Backend) updateToFailureStateWithError(taskState *tasks.TaskState) erro
 			},
 		},
 		ReturnValues:     aws.String("UPDATED_NEW"),
-		TableName:        aws.String(b.cnf.DynamoDB.TaskStatesTable),
+		TableName:        aws.String(b.taskStatesTable()),
 		UpdateExpression: aws.String("SET #S = :s, #E = :e"),
 	}
 
 	if taskState.TTL > 0 {
-		input.ExpressionAttributeNames["#T"] = aws.String("TTL")
+		input.ExpressionAttributeNames["#T"] = aws.String(b.ttlAttribute())
 		input.ExpressionAttributeValues[":t"] = &dynamodb.AttributeValue{
 			N: aws.String(fmt.Sprintf("%d", taskState.TTL)),
 		}
 		input.UpdateExpression = aws.String(aws.StringValue(input.UpdateExpression) + ", #T = :t")
 	}
+	input.UpdateExpression = aws.String(aws.StringValue(input.UpdateExpression) + setTiming(taskState, input.ExpressionAttributeNames, input.ExpressionAttributeValues))
 
 	_, err := b.client.UpdateItem(input)
 
@@ -540,16 +605,55 @@ func (b *Backend) updateToFailureStateWithError(taskState *tasks.TaskState) erro
 	return nil
 }
 
+// setTiming adds the timing fields of the task state known to the update
+// and returns the update expression setting them
+func setTiming(taskState *tasks.TaskState, names map[string]*string, values map[string]*dynamodb.AttributeValue) string {
+	exp := ""
+	times := []struct {
+		key, attribute string
+		value          *time.Time
+	}{
+		{"EQ", "EnqueuedAt", taskState.EnqueuedAt},
+		{"SA", "StartedAt", taskState.StartedAt},
+		{"FA", "FinishedAt", taskState.FinishedAt},
+	}
+	for _, t := range times {
+		if t.value == nil {
+			continue
+		}
+		names["#"+t.key] = aws.String(t.attribute)
+		values[":"+strings.ToLower(t.key)] = &dynamodb.AttributeValue{S: aws.String(t.value.Format(time.RFC3339Nano))}
+		exp += fmt.Sprintf(", #%s = :%s", t.key, strings.ToLower(t.key))
+	}
+
+	durations := []struct {
+		key, attribute string
+		value          time.Duration
+	}{
+		{"QW", "QueueWait", taskState.QueueWait},
+		{"DU", "Duration", taskState.Duration},
+	}
+	for _, d := range durations {
+		if d.value == 0 {
+			continue
+		}
+		names["#"+d.key] = aws.String(d.attribute)
+		values[":"+strings.ToLower(d.key)] = &dynamodb.AttributeValue{N: aws.String(fmt.Sprintf("%d", d.value))}
+		exp += fmt.Sprintf(", #%s = :%s", d.key, strings.ToLower(d.key))
+	}
+	return exp
+}
+
 func (b *Backend) unmarshalGroupMetaGetItemResult(result *dynamodb.GetItemOutput) (*tasks.GroupMeta, error) {
 	if result == nil {
 		err := errors.New("task state is nil")
-		log.ERROR.Printf("Got error when unmarshal map. Error: %v", err)
+		b.Logger().ERROR.Printf("Got error when unmarshal map. Error: %v", err)
 		return nil, err
 	}
 	item := tasks.GroupMeta{}
 	err := dynamodbattribute.UnmarshalMap(result.Item, &item)
 	if err != nil {
-		log.ERROR.Printf("Got error when unmarshal map. Error: %v", err)
+		b.Logger().ERROR.Printf("Got error when unmarshal map. Error: %v", err)
 		return nil, err
 	}
 	return &item, err
@@ -558,13 +662,13 @@ func (b *Backend) unmarshalGroupMetaGetItemResult(result *dynamodb.GetItemOutput
 func (b *Backend) unmarshalTaskStateGetItemResult(result *dynamodb.GetItemOutput) (*tasks.TaskState, error) {
 	if result == nil {
 		err := errors.New("task state is nil")
-		log.ERROR.Printf("Got error when unmarshal map. Error: %v", err)
+		b.Logger().ERROR.Printf("Got error when unmarshal map. Error: %v", err)
 		return nil, err
 	}
 	state := tasks.TaskState{}
 	err := dynamodbattribute.UnmarshalMap(result.Item, &state)
 	if err != nil {
-		log.ERROR.Printf("Got error when unmarshal map. Error: %v", err)
+		b.Logger().ERROR.Printf("Got error when unmarshal map. Error: %v", err)
 		return nil, err
 	}
 	return &state, nil
@@ -572,8 +676,8 @@ func (b *Backend) unmarshalTaskStateGetItemResult(result *dynamodb.GetItemOutput
 
 func (b *Backend) checkRequiredTablesIfExist() error {
 	var (
-		taskTableName  = b.cnf.DynamoDB.TaskStatesTable
-		groupTableName = b.cnf.DynamoDB.GroupMetasTable
+		taskTableName  = b.taskStatesTable()
+		groupTableName = b.groupMetasTable()
 		tableNames     []*string
 		startFromTable *string
 	)
@@ -609,15 +713,113 @@ func (b *Backend) tableExists(tableName string, tableNames []*string) bool {
 	return false
 }
 
-func (b *Backend) getExpirationTime() int64 {
-	expiresIn := b.GetConfig().ResultsExpireIn
-	if expiresIn == 0 {
-		// expire results after 1 hour by default
-		expiresIn = config.DefaultResultsExpireIn
-	}
+func (b *Backend) getExpirationTime(signature *tasks.Signature) int64 {
+	expiresIn := b.ResultsExpireIn(signature)
 	return time.Now().Add(time.Second * time.Duration(expiresIn)).Unix()
 }
 
+func (b *Backend) getGroupMetaExpirationTime() int64 {
+	expiresIn := b.GroupMetaExpireIn()
+	return time.Now().Add(time.Second * time.Duration(expiresIn)).Unix()
+}
+
+// taskStatesTable returns the name of the task states table in the namespace
+func (b *Backend) taskStatesTable() string {
+	return b.cnf.Namespaced(b.cnf.DynamoDB.TaskStatesTable)
+}
+
+// groupMetasTable returns the name of the group metas table in the namespace
+func (b *Backend) groupMetasTable() string {
+	return b.cnf.Namespaced(b.cnf.DynamoDB.GroupMetasTable)
+}
+
+func (b *Backend) ttlAttribute() string {
+	if b.cnf.DynamoDB.TTLAttribute != "" {
+		return b.cnf.DynamoDB.TTLAttribute
+	}
+	return DefaultTTLAttribute
+}
+
+// marshalItem marshals a task state or group meta, storing its expiration
+// time under the configured TTL attribute. Items without one never expire.
+func (b *Backend) marshalItem(in interface{}) (map[string]*dynamodb.AttributeValue, error) {
+	av, err := dynamodbattribute.MarshalMap(in)
+	if err != nil {
+		return nil, err
+	}
+
+	if ttl, ok := av["TTL"]; ok {
+		delete(av, "TTL")
+		if aws.StringValue(ttl.N) != "0" {
+			av[b.ttlAttribute()] = ttl
+		}
+	}
+	return av, nil
+}
+
+// enableTTL turns on TTL for the task states and group metas tables unless
+// it is on already
+func (b *Backend) enableTTL() error {
+	for _, tableName := range []string{b.taskStatesTable(), b.groupMetasTable()} {
+		result, err := b.client.DescribeTimeToLive(&dynamodb.DescribeTimeToLiveInput{
+			TableName: aws.String(tableName),
+		})
+		if err != nil {
+			return err
+		}
+
+		if description := result.TimeToLiveDescription; description != nil {
+			switch aws.StringValue(description.TimeToLiveStatus) {
+			case dynamodb.TimeToLiveStatusEnabled, dynamodb.TimeToLiveStatusEnabling:
+				if attribute := aws.StringValue(description.AttributeName); attribute != b.ttlAttribute() {
+					b.Logger().WARNING.Printf("Table %s expires items by %s instead of %s", tableName, attribute, b.ttlAttribute())
+				}
+				continue
+			}
+		}
+
+		_, err = b.client.UpdateTimeToLive(&dynamodb.UpdateTimeToLiveInput{
+			TableName: aws.String(tableName),
+			TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+				AttributeName: aws.String(b.ttlAttribute()),
+				Enabled:       aws.Bool(true),
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batchWrite puts up to BatchWriteItemsLimit items with a single
+// BatchWriteItem call, retrying items DynamoDB left unprocessed
+func (b *Backend) batchWrite(items []batchWriteItem) error {
+	requestItems := make(map[string][]*dynamodb.WriteRequest)
+	for _, item := range items {
+		requestItems[item.table] = append(requestItems[item.table], &dynamodb.WriteRequest{
+			PutRequest: &dynamodb.PutRequest{Item: item.av},
+		})
+	}
+
+	for attempt := 0; len(requestItems) > 0; attempt++ {
+		if attempt == MaxBatchWriteAttempts {
+			return fmt.Errorf("BatchWriteItem left items unprocessed after %d attempts", MaxBatchWriteAttempts)
+		}
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt*attempt) * 50 * time.Millisecond)
+		}
+
+		result, err := b.client.BatchWriteItem(&dynamodb.BatchWriteItemInput{RequestItems: requestItems})
+		if err != nil {
+			return fmt.Errorf("BatchWriteItem failed. Error: [%s]", err)
+		}
+		requestItems = result.UnprocessedItems
+	}
+
+	return nil
+}
+
 // getUnfetchedKeys returns keys that were not fetched in a batch request.
 func getUnfetchedKeys(unprocessed *dynamodb.KeysAndAttributes) ([]string, error) {
 	states := []*tasks.TaskState{}
@@ -1,9 +1,13 @@
+//go:build !machinery_no_aws
+// +build !machinery_no_aws
+
 package dynamodb
 
 import (
 	"errors"
 	"os"
 
+	"github.com/RichardKnop/machinery/v2/common"
 	"github.com/RichardKnop/machinery/v2/config"
 	"github.com/RichardKnop/machinery/v2/tasks"
 	"github.com/aws/aws-sdk-go/aws"
@@ -25,16 +29,23 @@ var (
 
 type TestDynamoDBClient struct {
 	dynamodbiface.DynamoDBAPI
-	PutItemOverride      func(*dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error)
-	UpdateItemOverride   func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error)
-	GetItemOverride      func(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error)
-	BatchGetItemOverride func(*dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error)
+	PutItemOverride        func(*dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error)
+	UpdateItemOverride     func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error)
+	GetItemOverride        func(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error)
+	BatchGetItemOverride   func(*dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error)
+	BatchWriteItemOverride func(*dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error)
+	DescribeTTLOverride    func(*dynamodb.DescribeTimeToLiveInput) (*dynamodb.DescribeTimeToLiveOutput, error)
+	UpdateTTLOverride      func(*dynamodb.UpdateTimeToLiveInput) (*dynamodb.UpdateTimeToLiveOutput, error)
 }
 
 func (t *TestDynamoDBClient) ResetOverrides() {
 	t.PutItemOverride = nil
+	t.GetItemOverride = nil
 	t.UpdateItemOverride = nil
 	t.BatchGetItemOverride = nil
+	t.BatchWriteItemOverride = nil
+	t.DescribeTTLOverride = nil
+	t.UpdateTTLOverride = nil
 }
 
 func (t *TestDynamoDBClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
@@ -50,6 +61,13 @@ func (t *TestDynamoDBClient) BatchGetItem(input *dynamodb.BatchGetItemInput) (*d
 	return &dynamodb.BatchGetItemOutput{}, nil
 }
 
+func (t *TestDynamoDBClient) BatchWriteItem(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+	if t.BatchWriteItemOverride != nil {
+		return t.BatchWriteItemOverride(input)
+	}
+	return &dynamodb.BatchWriteItemOutput{}, nil
+}
+
 func (t *TestDynamoDBClient) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
 	if t.GetItemOverride != nil {
 		return t.GetItemOverride(input)
@@ -133,6 +151,20 @@ func (t *TestDynamoDBClient) UpdateItem(input *dynamodb.UpdateItemInput) (*dynam
 	return &dynamodb.UpdateItemOutput{}, nil
 }
 
+func (t *TestDynamoDBClient) DescribeTimeToLive(input *dynamodb.DescribeTimeToLiveInput) (*dynamodb.DescribeTimeToLiveOutput, error) {
+	if t.DescribeTTLOverride != nil {
+		return t.DescribeTTLOverride(input)
+	}
+	return &dynamodb.DescribeTimeToLiveOutput{}, nil
+}
+
+func (t *TestDynamoDBClient) UpdateTimeToLive(input *dynamodb.UpdateTimeToLiveInput) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	if t.UpdateTTLOverride != nil {
+		return t.UpdateTTLOverride(input)
+	}
+	return &dynamodb.UpdateTimeToLiveOutput{}, nil
+}
+
 func (t *TestDynamoDBClient) ListTables(*dynamodb.ListTablesInput) (*dynamodb.ListTablesOutput, error) {
 	return &dynamodb.ListTablesOutput{
 		TableNames: []*string{
@@ -181,10 +213,10 @@ func init() {
 		},
 	}
 	TestDBClient = new(TestDynamoDBClient)
-	TestDynamoDBBackend = &Backend{cnf: TestCnf, client: TestDBClient}
+	TestDynamoDBBackend = &Backend{Backend: common.NewBackend(TestCnf), cnf: TestCnf, client: TestDBClient}
 
 	TestErrDBClient = new(TestErrDynamoDBClient)
-	TestErrDynamoDBBackend = &Backend{cnf: TestCnf, client: TestErrDBClient}
+	TestErrDynamoDBBackend = &Backend{Backend: common.NewBackend(TestCnf), cnf: TestCnf, client: TestErrDBClient}
 
 	TestGroupMeta = &tasks.GroupMeta{
 		GroupUUID: "testGroupUUID",
@@ -247,3 +279,7 @@ func (b *Backend) TableExistsForTest(tableName string, tableNames []*string) boo
 func (b *Backend) CheckRequiredTablesIfExistForTest() error {
 	return b.checkRequiredTablesIfExist()
 }
+
+func (b *Backend) EnableTTLForTest() error {
+	return b.enableTTL()
+}
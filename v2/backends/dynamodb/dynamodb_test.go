@@ -1,3 +1,6 @@
+//go:build !machinery_no_aws
+// +build !machinery_no_aws
+
 package dynamodb_test
 
 import (
@@ -53,6 +56,135 @@ func TestInitGroup(t *testing.T) {
 	client.ResetOverrides()
 }
 
+func TestInitGroupPending(t *testing.T) {
+	signatures := make([]*tasks.Signature, 30)
+	for i := range signatures {
+		signatures[i] = &tasks.Signature{UUID: fmt.Sprintf("testTaskUUID%d", i), Name: "foo"}
+	}
+
+	client := dynamodb.TestDynamoDBBackend.GetClient().(*dynamodb.TestDynamoDBClient)
+	var (
+		batchSizes []int
+		retried    bool
+	)
+	client.BatchWriteItemOverride = func(input *awsdynamodb.BatchWriteItemInput) (*awsdynamodb.BatchWriteItemOutput, error) {
+		size := 0
+		for _, requests := range input.RequestItems {
+			size += len(requests)
+		}
+		batchSizes = append(batchSizes, size)
+
+		// Leave the group meta unprocessed once
+		output := &awsdynamodb.BatchWriteItemOutput{}
+		if requests, ok := input.RequestItems["group_metas"]; ok && !retried {
+			retried = true
+			output.UnprocessedItems = map[string][]*awsdynamodb.WriteRequest{"group_metas": requests}
+		}
+		return output, nil
+	}
+	defer client.ResetOverrides()
+
+	err := dynamodb.TestDynamoDBBackend.InitGroupPending("testGroupUUID", signatures)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{25, 1, 6}, batchSizes)
+
+	client.BatchWriteItemOverride = func(input *awsdynamodb.BatchWriteItemInput) (*awsdynamodb.BatchWriteItemOutput, error) {
+		return &awsdynamodb.BatchWriteItemOutput{UnprocessedItems: input.RequestItems}, nil
+	}
+	err = dynamodb.TestDynamoDBBackend.InitGroupPending("testGroupUUID", signatures[:1])
+	assert.Error(t, err)
+}
+
+func TestTTLAttribute(t *testing.T) {
+	dynamodb.TestDynamoDBBackend.GetConfig().DynamoDB.TTLAttribute = "ExpiresAt"
+	defer func() { dynamodb.TestDynamoDBBackend.GetConfig().DynamoDB.TTLAttribute = "" }()
+	dynamodb.TestDynamoDBBackend.GetConfig().ResultsExpireIn = 3600
+
+	client := dynamodb.TestDynamoDBBackend.GetClient().(*dynamodb.TestDynamoDBClient)
+	defer client.ResetOverrides()
+
+	// assert pending states expire as well, by the configured attribute
+	var isPutItemCalled bool
+	client.PutItemOverride = func(input *awsdynamodb.PutItemInput) (*awsdynamodb.PutItemOutput, error) {
+		isPutItemCalled = true
+		assert.NotContains(t, input.Item, "TTL")
+		if assert.Contains(t, input.Item, "ExpiresAt") {
+			assertTTLValue(t, time.Now().Add(time.Hour), *input.Item["ExpiresAt"].N)
+		}
+		return &awsdynamodb.PutItemOutput{}, nil
+	}
+	err := dynamodb.TestDynamoDBBackend.SetStatePending(&tasks.Signature{UUID: "testTaskUUID"})
+	assert.NoError(t, err)
+	assert.True(t, isPutItemCalled)
+
+	var isUpdateItemCalled bool
+	client.UpdateItemOverride = func(input *awsdynamodb.UpdateItemInput) (*awsdynamodb.UpdateItemOutput, error) {
+		isUpdateItemCalled = true
+		assert.Equal(t, "ExpiresAt", *input.ExpressionAttributeNames["#T"])
+		return &awsdynamodb.UpdateItemOutput{}, nil
+	}
+	err = dynamodb.TestDynamoDBBackend.SetStateSuccess(&tasks.Signature{UUID: "testTaskUUID"}, nil)
+	assert.NoError(t, err)
+	assert.True(t, isUpdateItemCalled)
+}
+
+func TestNamespace(t *testing.T) {
+	dynamodb.TestDynamoDBBackend.GetConfig().Namespace = "staging"
+	defer func() { dynamodb.TestDynamoDBBackend.GetConfig().Namespace = "" }()
+
+	client := dynamodb.TestDynamoDBBackend.GetClient().(*dynamodb.TestDynamoDBClient)
+	defer client.ResetOverrides()
+
+	var isPutItemCalled bool
+	client.PutItemOverride = func(input *awsdynamodb.PutItemInput) (*awsdynamodb.PutItemOutput, error) {
+		isPutItemCalled = true
+		assert.Equal(t, "staging_task_states", *input.TableName)
+		return &awsdynamodb.PutItemOutput{}, nil
+	}
+	err := dynamodb.TestDynamoDBBackend.SetStatePending(&tasks.Signature{UUID: "testTaskUUID"})
+	assert.NoError(t, err)
+	assert.True(t, isPutItemCalled)
+
+	var isGetItemCalled bool
+	client.GetItemOverride = func(input *awsdynamodb.GetItemInput) (*awsdynamodb.GetItemOutput, error) {
+		isGetItemCalled = true
+		assert.Equal(t, "staging_group_metas", *input.TableName)
+		return &awsdynamodb.GetItemOutput{}, nil
+	}
+	dynamodb.TestDynamoDBBackend.GetGroupMetaForTest("testGroupUUID")
+	assert.True(t, isGetItemCalled)
+}
+
+func TestEnableTTL(t *testing.T) {
+	client := dynamodb.TestDynamoDBBackend.GetClient().(*dynamodb.TestDynamoDBClient)
+	defer client.ResetOverrides()
+
+	// group metas already expire, only task states need TTL turned on
+	client.DescribeTTLOverride = func(input *awsdynamodb.DescribeTimeToLiveInput) (*awsdynamodb.DescribeTimeToLiveOutput, error) {
+		status := awsdynamodb.TimeToLiveStatusDisabled
+		if *input.TableName == "group_metas" {
+			status = awsdynamodb.TimeToLiveStatusEnabled
+		}
+		return &awsdynamodb.DescribeTimeToLiveOutput{
+			TimeToLiveDescription: &awsdynamodb.TimeToLiveDescription{
+				AttributeName:    aws.String("TTL"),
+				TimeToLiveStatus: aws.String(status),
+			},
+		}, nil
+	}
+	var updated []string
+	client.UpdateTTLOverride = func(input *awsdynamodb.UpdateTimeToLiveInput) (*awsdynamodb.UpdateTimeToLiveOutput, error) {
+		updated = append(updated, *input.TableName)
+		assert.Equal(t, "TTL", *input.TimeToLiveSpecification.AttributeName)
+		assert.True(t, *input.TimeToLiveSpecification.Enabled)
+		return &awsdynamodb.UpdateTimeToLiveOutput{}, nil
+	}
+
+	err := dynamodb.TestDynamoDBBackend.EnableTTLForTest()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"task_states"}, updated)
+}
+
 func assertTTLValue(t *testing.T, expectedTTLTime time.Time, actualEncodedTTLValue string) {
 	actualTTLTimestamp, err := strconv.ParseInt(actualEncodedTTLValue, 10, 64)
 	assert.Nil(t, err)
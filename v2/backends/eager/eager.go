@@ -1,8 +1,6 @@
 package eager
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
 	"sync"
 
@@ -155,6 +153,12 @@ func (b *Backend) SetStateFailure(signature *tasks.Signature, err string) error
 	return b.updateState(state)
 }
 
+// SetStateTimedOut updates task state to TIMED_OUT
+func (b *Backend) SetStateTimedOut(signature *tasks.Signature) error {
+	state := tasks.NewTimedOutTaskState(signature)
+	return b.updateState(state)
+}
+
 // GetState returns the latest task state
 func (b *Backend) GetState(taskUUID string) (*tasks.TaskState, error) {
 	tasktStateBytes, ok := b.tasks[taskUUID]
@@ -162,10 +166,8 @@ func (b *Backend) GetState(taskUUID string) (*tasks.TaskState, error) {
 		return nil, NewErrTasknotFound(taskUUID)
 	}
 
-	state := new(tasks.TaskState)
-	decoder := json.NewDecoder(bytes.NewReader(tasktStateBytes))
-	decoder.UseNumber()
-	if err := decoder.Decode(state); err != nil {
+	state, err := b.DecodeTaskState(tasktStateBytes)
+	if err != nil {
 		return nil, fmt.Errorf("Failed to unmarshal task state %v", b)
 	}
 
@@ -195,10 +197,10 @@ func (b *Backend) PurgeGroupMeta(groupUUID string) error {
 }
 
 func (b *Backend) updateState(s *tasks.TaskState) error {
-	// simulate the behavior of json marshal/unmarshal
+	// simulate the behavior of encoding and decoding
 	b.stateMutex.Lock()
 	defer b.stateMutex.Unlock()
-	msg, err := json.Marshal(s)
+	msg, err := b.EncodeTaskState(s)
 	if err != nil {
 		return fmt.Errorf("Marshal task state error: %v", err)
 	}
@@ -0,0 +1,142 @@
+package encrypted
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/RichardKnop/machinery/v2/backends/iface"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+// Backend encrypts task results before they are written to the wrapped
+// backend and decrypts them when task states are read back
+type Backend struct {
+	iface.Backend
+	encryptor *tasks.Encryptor
+	policy    func(*tasks.Signature) *tasks.Encryptor
+}
+
+// New wraps the backend so results are stored encrypted by the encryptor
+func New(backend iface.Backend, encryptor *tasks.Encryptor) iface.Backend {
+	return &Backend{
+		Backend:   backend,
+		encryptor: encryptor,
+	}
+}
+
+// NewWithPolicy wraps the backend so results are stored encrypted by the
+// encryptor the policy returns for the task, or unencrypted if it returns
+// nil. The decryptor has to be able to decrypt results of every task.
+func NewWithPolicy(backend iface.Backend, decryptor *tasks.Encryptor, policy func(*tasks.Signature) *tasks.Encryptor) iface.Backend {
+	return &Backend{
+		Backend:   backend,
+		encryptor: decryptor,
+		policy:    policy,
+	}
+}
+
+// Unwrap returns the wrapped backend
+func (b *Backend) Unwrap() iface.Backend {
+	return b.Backend
+}
+
+// SetStateSuccess updates task state to SUCCESS with encrypted results
+func (b *Backend) SetStateSuccess(signature *tasks.Signature, results []*tasks.TaskResult) error {
+	encryptor := b.encryptor
+	if b.policy != nil {
+		if encryptor = b.policy(signature); encryptor == nil {
+			return b.Backend.SetStateSuccess(signature, results)
+		}
+	}
+
+	encrypted, err := encryptor.EncryptResults(results)
+	if err != nil {
+		return fmt.Errorf("Encrypt results of task %s error: %s", signature.UUID, err)
+	}
+
+	return b.Backend.SetStateSuccess(signature, encrypted)
+}
+
+// GetState returns the latest task state with decrypted results
+func (b *Backend) GetState(taskUUID string) (*tasks.TaskState, error) {
+	taskState, err := b.Backend.GetState(taskUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.decryptResults(taskState)
+}
+
+// WaitCompleted waits for the task to complete if the wrapped backend can
+// push state changes and returns its state with decrypted results
+func (b *Backend) WaitCompleted(ctx context.Context, taskUUID string) (*tasks.TaskState, error) {
+	watcher, ok := b.Backend.(iface.WatchBackend)
+	if !ok {
+		return nil, errors.New("Wrapped backend does not support watching task states")
+	}
+
+	taskState, err := watcher.WaitCompleted(ctx, taskUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.decryptResults(taskState)
+}
+
+// ListStates lists task states matching the filter if the wrapped backend
+// supports queries and returns them with decrypted results
+func (b *Backend) ListStates(filter *tasks.StateFilter) ([]*tasks.TaskState, error) {
+	query, ok := b.Backend.(iface.QueryBackend)
+	if !ok {
+		return nil, errors.New("Wrapped backend does not support listing task states")
+	}
+
+	taskStates, err := query.ListStates(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted := make([]*tasks.TaskState, len(taskStates))
+	for i, taskState := range taskStates {
+		if decrypted[i], err = b.decryptResults(taskState); err != nil {
+			return nil, err
+		}
+	}
+	return decrypted, nil
+}
+
+// GroupTaskStates returns states of all tasks in the group with decrypted
+// results
+func (b *Backend) GroupTaskStates(groupUUID string, groupTaskCount int) ([]*tasks.TaskState, error) {
+	taskStates, err := b.Backend.GroupTaskStates(groupUUID, groupTaskCount)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted := make([]*tasks.TaskState, len(taskStates))
+	for i, taskState := range taskStates {
+		if decrypted[i], err = b.decryptResults(taskState); err != nil {
+			return nil, err
+		}
+	}
+
+	return decrypted, nil
+}
+
+// decryptResults returns a copy of the state with decrypted results, the
+// state itself may be shared with the wrapped backend
+func (b *Backend) decryptResults(taskState *tasks.TaskState) (*tasks.TaskState, error) {
+	if taskState == nil {
+		return nil, nil
+	}
+
+	results, err := b.encryptor.DecryptResults(taskState.Results)
+	if err != nil {
+		return nil, fmt.Errorf("Decrypt results of task %s error: %s", taskState.TaskUUID, err)
+	}
+
+	decrypted := *taskState
+	decrypted.Results = results
+	return &decrypted, nil
+}
@@ -0,0 +1,89 @@
+package encrypted_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/backends/eager"
+	"github.com/RichardKnop/machinery/v2/backends/encrypted"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestEncryptedResults(t *testing.T) {
+	t.Parallel()
+
+	keys, err := tasks.NewStaticKeyProvider("k1", map[string][]byte{"k1": make([]byte, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inner := eager.New()
+	backend := encrypted.New(inner, &tasks.Encryptor{Keys: keys})
+
+	task1 := &tasks.Signature{UUID: "task_1", GroupUUID: "group_1"}
+	task2 := &tasks.Signature{UUID: "task_2", GroupUUID: "group_1"}
+	assert.NoError(t, backend.InitGroup("group_1", []string{"task_1", "task_2"}))
+	assert.NoError(t, backend.SetStateSuccess(task1, []*tasks.TaskResult{{Type: "string", Value: "secret"}}))
+	assert.NoError(t, backend.SetStateSuccess(task2, []*tasks.TaskResult{{Type: "int64", Value: 2}}))
+
+	// The wrapped backend only ever sees ciphertext
+	stored, err := inner.GetState("task_1")
+	assert.NoError(t, err)
+	encoded, err := json.Marshal(stored)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(encoded), "secret")
+
+	state, err := backend.GetState("task_1")
+	if assert.NoError(t, err) && assert.Len(t, state.Results, 1) {
+		assert.Equal(t, "secret", state.Results[0].Value)
+	}
+
+	states, err := backend.GroupTaskStates("group_1", 2)
+	if assert.NoError(t, err) && assert.Len(t, states, 2) {
+		assert.Equal(t, "secret", states[0].Results[0].Value)
+		assert.Equal(t, "2", states[1].Results[0].Value.(json.Number).String())
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	t.Parallel()
+
+	keys, err := tasks.NewStaticKeyProvider("k1", map[string][]byte{"k1": make([]byte, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptor := &tasks.Encryptor{Keys: keys}
+
+	inner := eager.New()
+	backend := encrypted.NewWithPolicy(inner, encryptor, func(signature *tasks.Signature) *tasks.Encryptor {
+		if signature.RoutingKey == "secure" {
+			return encryptor
+		}
+		return nil
+	})
+
+	secure := &tasks.Signature{UUID: "task_1", RoutingKey: "secure"}
+	legacy := &tasks.Signature{UUID: "task_2", RoutingKey: "legacy"}
+	assert.NoError(t, backend.SetStateSuccess(secure, []*tasks.TaskResult{{Type: "string", Value: "secret"}}))
+	assert.NoError(t, backend.SetStateSuccess(legacy, []*tasks.TaskResult{{Type: "string", Value: "public"}}))
+
+	stored, err := inner.GetState("task_1")
+	assert.NoError(t, err)
+	encoded, err := json.Marshal(stored)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(encoded), "secret")
+
+	stored, err = inner.GetState("task_2")
+	if assert.NoError(t, err) && assert.Len(t, stored.Results, 1) {
+		assert.Equal(t, "public", stored.Results[0].Value)
+	}
+
+	for uuid, value := range map[string]string{"task_1": "secret", "task_2": "public"} {
+		state, err := backend.GetState(uuid)
+		if assert.NoError(t, err) && assert.Len(t, state.Results, 1) {
+			assert.Equal(t, value, state.Results[0].Value)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/RichardKnop/machinery/v2/backends/iface"
+	"github.com/RichardKnop/machinery/v2/common"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+// rootKey is the directory of all keys, prefixed with the namespace
+const rootKey = "machinery"
+
+// ErrKeyNotFound is returned when a task state or group meta does not exist
+var ErrKeyNotFound = errors.New("etcd: key not found")
+
+// Backend represents an etcd result backend. Every stored key is attached to
+// a lease of ResultsExpireIn (or GroupMetaExpireIn) seconds, so etcd expires
+// task states and group meta data on its own.
+type Backend struct {
+	common.Backend
+	client *clientv3.Client
+}
+
+// New creates Backend instance
+func New(cnf *config.Config, endpoints []string) (iface.Backend, error) {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: 10 * time.Second,
+		TLS:         cnf.TLSConfig,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Backend{
+		Backend: common.NewBackend(cnf),
+		client:  client,
+	}, nil
+}
+
+// InitGroup creates and saves a group meta data object
+func (b *Backend) InitGroup(groupUUID string, taskUUIDs []string) error {
+	groupMeta := &tasks.GroupMeta{
+		GroupUUID: groupUUID,
+		TaskUUIDs: taskUUIDs,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	encoded, err := json.Marshal(groupMeta)
+	if err != nil {
+		return err
+	}
+
+	return b.put(b.groupKey(groupUUID), encoded, b.GroupMetaExpireIn())
+}
+
+// GroupCompleted returns true if all tasks in a group finished
+func (b *Backend) GroupCompleted(groupUUID string, groupTaskCount int) (bool, error) {
+	groupMeta, _, err := b.getGroupMeta(groupUUID)
+	if err != nil {
+		return false, err
+	}
+
+	taskStates, err := b.getStates(groupMeta.TaskUUIDs...)
+	if err != nil {
+		return false, err
+	}
+
+	var countSuccessTasks = 0
+	for _, taskState := range taskStates {
+		if taskState.IsCompleted() {
+			countSuccessTasks++
+		}
+	}
+
+	return countSuccessTasks == groupTaskCount, nil
+}
+
+// GroupTaskStates returns states of all tasks in the group
+func (b *Backend) GroupTaskStates(groupUUID string, groupTaskCount int) ([]*tasks.TaskState, error) {
+	groupMeta, _, err := b.getGroupMeta(groupUUID)
+	if err != nil {
+		return []*tasks.TaskState{}, err
+	}
+
+	return b.getStates(groupMeta.TaskUUIDs...)
+}
+
+// TriggerChord flags chord as triggered in the backend storage to make sure
+// chord is never triggered multiple times. Returns a boolean flag to indicate
+// whether the worker should trigger chord (true) or no if it has been triggered
+// already (false)
+func (b *Backend) TriggerChord(groupUUID string) (bool, error) {
+	key := b.groupKey(groupUUID)
+
+	groupMeta, revision, err := b.getGroupMeta(groupUUID)
+	if err != nil {
+		return false, err
+	}
+
+	// Chord has already been triggered, return false (should not trigger again)
+	if groupMeta.ChordTriggered {
+		return false, nil
+	}
+
+	// Set flag to true
+	groupMeta.ChordTriggered = true
+
+	encoded, err := json.Marshal(groupMeta)
+	if err != nil {
+		return false, err
+	}
+
+	leaseID, err := b.grantLease(b.GroupMetaExpireIn())
+	if err != nil {
+		return false, err
+	}
+
+	// Only update the group meta if nobody else did in the meantime
+	resp, err := b.client.Txn(context.Background()).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", revision)).
+		Then(clientv3.OpPut(key, string(encoded), clientv3.WithLease(leaseID))).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+
+	if !resp.Succeeded {
+		b.Logger().WARNING.Printf("Chord already triggered for group %s", groupUUID)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func (b *Backend) mergeNewTaskState(newState *tasks.TaskState) {
+	state, err := b.GetState(newState.TaskUUID)
+	if err == nil {
+		newState.CreatedAt = state.CreatedAt
+		newState.TaskName = state.TaskName
+	}
+}
+
+// SetStatePending updates task state to PENDING
+func (b *Backend) SetStatePending(signature *tasks.Signature) error {
+	taskState := tasks.NewPendingTaskState(signature)
+	return b.updateState(signature, taskState)
+}
+
+// SetStateReceived updates task state to RECEIVED
+func (b *Backend) SetStateReceived(signature *tasks.Signature) error {
+	taskState := tasks.NewReceivedTaskState(signature)
+	b.mergeNewTaskState(taskState)
+	return b.updateState(signature, taskState)
+}
+
+// SetStateStarted updates task state to STARTED
+func (b *Backend) SetStateStarted(signature *tasks.Signature) error {
+	taskState := tasks.NewStartedTaskState(signature)
+	b.mergeNewTaskState(taskState)
+	return b.updateState(signature, taskState)
+}
+
+// SetStateRetry updates task state to RETRY
+func (b *Backend) SetStateRetry(signature *tasks.Signature) error {
+	taskState := tasks.NewRetryTaskState(signature)
+	b.mergeNewTaskState(taskState)
+	return b.updateState(signature, taskState)
+}
+
+// SetStateSuccess updates task state to SUCCESS
+func (b *Backend) SetStateSuccess(signature *tasks.Signature, results []*tasks.TaskResult) error {
+	taskState := tasks.NewSuccessTaskState(signature, results)
+	b.mergeNewTaskState(taskState)
+	return b.updateState(signature, taskState)
+}
+
+// SetStateFailure updates task state to FAILURE
+func (b *Backend) SetStateFailure(signature *tasks.Signature, err string) error {
+	taskState := tasks.NewFailureTaskState(signature, err)
+	b.mergeNewTaskState(taskState)
+	return b.updateState(signature, taskState)
+}
+
+// SetStateTimedOut updates task state to TIMED_OUT
+func (b *Backend) SetStateTimedOut(signature *tasks.Signature) error {
+	taskState := tasks.NewTimedOutTaskState(signature)
+	b.mergeNewTaskState(taskState)
+	return b.updateState(signature, taskState)
+}
+
+// GetState returns the latest task state
+func (b *Backend) GetState(taskUUID string) (*tasks.TaskState, error) {
+	resp, err := b.client.Get(context.Background(), b.taskKey(taskUUID))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Kvs) == 0 {
+		return nil, ErrKeyNotFound
+	}
+
+	return b.DecodeTaskState(resp.Kvs[0].Value)
+}
+
+// WatchState streams every state change of a task until the task completes
+// or the context is cancelled. The current state, if any, is sent first.
+func (b *Backend) WatchState(ctx context.Context, taskUUID string) <-chan *tasks.TaskState {
+	states := make(chan *tasks.TaskState)
+
+	go func() {
+		defer close(states)
+
+		resp, err := b.client.Get(ctx, b.taskKey(taskUUID))
+		if err != nil {
+			b.Logger().ERROR.Printf("Failed to get state of task %s: %s", taskUUID, err)
+			return
+		}
+
+		watchOpts := []clientv3.OpOption{clientv3.WithFilterDelete()}
+		if len(resp.Kvs) > 0 {
+			state, err := b.DecodeTaskState(resp.Kvs[0].Value)
+			if err == nil {
+				select {
+				case states <- state:
+				case <-ctx.Done():
+					return
+				}
+				if state.IsCompleted() {
+					return
+				}
+			}
+		}
+		watchOpts = append(watchOpts, clientv3.WithRev(resp.Header.Revision+1))
+
+		for watchResp := range b.client.Watch(ctx, b.taskKey(taskUUID), watchOpts...) {
+			for _, event := range watchResp.Events {
+				state, err := b.DecodeTaskState(event.Kv.Value)
+				if err != nil {
+					b.Logger().ERROR.Print(err)
+					continue
+				}
+
+				select {
+				case states <- state:
+				case <-ctx.Done():
+					return
+				}
+
+				if state.IsCompleted() {
+					return
+				}
+			}
+		}
+	}()
+
+	return states
+}
+
+// PurgeState deletes stored task state
+func (b *Backend) PurgeState(taskUUID string) error {
+	_, err := b.client.Delete(context.Background(), b.taskKey(taskUUID))
+	return err
+}
+
+// PurgeGroupMeta deletes stored group meta data
+func (b *Backend) PurgeGroupMeta(groupUUID string) error {
+	_, err := b.client.Delete(context.Background(), b.groupKey(groupUUID))
+	return err
+}
+
+// getGroupMeta retrieves group meta data together with its mod revision
+func (b *Backend) getGroupMeta(groupUUID string) (*tasks.GroupMeta, int64, error) {
+	resp, err := b.client.Get(context.Background(), b.groupKey(groupUUID))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(resp.Kvs) == 0 {
+		return nil, 0, ErrKeyNotFound
+	}
+
+	groupMeta := new(tasks.GroupMeta)
+	decoder := json.NewDecoder(bytes.NewReader(resp.Kvs[0].Value))
+	decoder.UseNumber()
+	if err := decoder.Decode(groupMeta); err != nil {
+		return nil, 0, err
+	}
+
+	return groupMeta, resp.Kvs[0].ModRevision, nil
+}
+
+// getStates returns multiple task states in a single transaction
+func (b *Backend) getStates(taskUUIDs ...string) ([]*tasks.TaskState, error) {
+	ops := make([]clientv3.Op, len(taskUUIDs))
+	for i, taskUUID := range taskUUIDs {
+		ops[i] = clientv3.OpGet(b.taskKey(taskUUID))
+	}
+
+	resp, err := b.client.Txn(context.Background()).Then(ops...).Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	taskStates := make([]*tasks.TaskState, len(taskUUIDs))
+	for i, opResp := range resp.Responses {
+		kvs := opResp.GetResponseRange().Kvs
+		if len(kvs) == 0 {
+			return nil, fmt.Errorf("State of task %s: %s", taskUUIDs[i], ErrKeyNotFound)
+		}
+
+		taskState, err := b.DecodeTaskState(kvs[0].Value)
+		if err != nil {
+			return nil, err
+		}
+		taskStates[i] = taskState
+	}
+
+	return taskStates, nil
+}
+
+// updateState saves current task state
+func (b *Backend) updateState(signature *tasks.Signature, taskState *tasks.TaskState) error {
+	encoded, err := b.EncodeTaskState(taskState)
+	if err != nil {
+		return err
+	}
+
+	return b.put(b.taskKey(taskState.TaskUUID), encoded, b.ResultsExpireIn(signature))
+}
+
+// put stores the value under a fresh lease of expiresIn seconds
+func (b *Backend) put(key string, value []byte, expiresIn int) error {
+	leaseID, err := b.grantLease(expiresIn)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.client.Put(context.Background(), key, string(value), clientv3.WithLease(leaseID))
+	return err
+}
+
+// taskKey returns the key of the task state
+func (b *Backend) taskKey(taskUUID string) string {
+	return "/" + b.GetConfig().Namespaced(rootKey) + "/tasks/" + taskUUID
+}
+
+// groupKey returns the key of the group meta data
+func (b *Backend) groupKey(groupUUID string) string {
+	return "/" + b.GetConfig().Namespaced(rootKey) + "/groups/" + groupUUID
+}
+
+// grantLease returns a new lease expiring after expiresIn seconds
+func (b *Backend) grantLease(expiresIn int) (clientv3.LeaseID, error) {
+	lease, err := b.client.Grant(context.Background(), int64(expiresIn))
+	if err != nil {
+		return clientv3.NoLease, err
+	}
+
+	return lease.ID, nil
+}
@@ -0,0 +1,101 @@
+package etcd_test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/backends/etcd"
+	"github.com/RichardKnop/machinery/v2/backends/iface"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func getEtcd(t *testing.T) iface.Backend {
+	// host1:port1,host2:port2
+	etcdURL := os.Getenv("ETCD_URL")
+	if etcdURL == "" {
+		t.Skip()
+	}
+
+	backend, err := etcd.New(new(config.Config), strings.Split(etcdURL, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+func TestGroupCompleted(t *testing.T) {
+	backend := getEtcd(t)
+
+	groupUUID := "testGroupUUID"
+	task1 := &tasks.Signature{UUID: "testTaskUUID1", GroupUUID: groupUUID}
+	task2 := &tasks.Signature{UUID: "testTaskUUID2", GroupUUID: groupUUID}
+
+	// Cleanup before the test
+	backend.PurgeState(task1.UUID)
+	backend.PurgeState(task2.UUID)
+	backend.PurgeGroupMeta(groupUUID)
+
+	_, err := backend.GroupCompleted(groupUUID, 2)
+	assert.Equal(t, etcd.ErrKeyNotFound, err)
+
+	assert.NoError(t, backend.InitGroup(groupUUID, []string{task1.UUID, task2.UUID}))
+	assert.NoError(t, backend.SetStatePending(task1))
+	assert.NoError(t, backend.SetStateStarted(task2))
+
+	groupCompleted, err := backend.GroupCompleted(groupUUID, 2)
+	if assert.NoError(t, err) {
+		assert.False(t, groupCompleted)
+	}
+
+	assert.NoError(t, backend.SetStateSuccess(task1, []*tasks.TaskResult{{Type: "int64", Value: 1}}))
+	assert.NoError(t, backend.SetStateFailure(task2, "Some error"))
+
+	groupCompleted, err = backend.GroupCompleted(groupUUID, 2)
+	if assert.NoError(t, err) {
+		assert.True(t, groupCompleted)
+	}
+}
+
+func TestTriggerChord(t *testing.T) {
+	backend := getEtcd(t)
+
+	groupUUID := "testTriggerChordGroupUUID"
+	backend.PurgeGroupMeta(groupUUID)
+	assert.NoError(t, backend.InitGroup(groupUUID, []string{"testTaskUUID1"}))
+
+	shouldTrigger, err := backend.TriggerChord(groupUUID)
+	if assert.NoError(t, err) {
+		assert.True(t, shouldTrigger)
+	}
+
+	shouldTrigger, err = backend.TriggerChord(groupUUID)
+	if assert.NoError(t, err) {
+		assert.False(t, shouldTrigger)
+	}
+}
+
+func TestWatchState(t *testing.T) {
+	backend := getEtcd(t).(*etcd.Backend)
+
+	signature := &tasks.Signature{UUID: "testWatchTaskUUID"}
+	backend.PurgeState(signature.UUID)
+	assert.NoError(t, backend.SetStatePending(signature))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	states := backend.WatchState(ctx, signature.UUID)
+
+	assert.Equal(t, tasks.StatePending, (<-states).State)
+
+	assert.NoError(t, backend.SetStateSuccess(signature, nil))
+	assert.Equal(t, tasks.StateSuccess, (<-states).State)
+
+	_, open := <-states
+	assert.False(t, open)
+}
@@ -0,0 +1,221 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/RichardKnop/machinery/v2/backends/iface"
+	"github.com/RichardKnop/machinery/v2/log"
+	"github.com/RichardKnop/machinery/v2/tasks"
+
+	brokersiface "github.com/RichardKnop/machinery/v2/brokers/iface"
+)
+
+// Backend publishes an event through the broker to the events queue every
+// time it stores a task state in the wrapped backend, so listeners can follow
+// state changes without polling the backend. Events are best effort, a
+// state is stored even if publishing its event fails.
+type Backend struct {
+	iface.Backend
+	broker brokersiface.Broker
+	queue  string
+	logger *log.Logger
+}
+
+// New wraps the backend so state changes are published to the queue
+func New(backend iface.Backend, broker brokersiface.Broker, queue string) *Backend {
+	return &Backend{
+		Backend: backend,
+		broker:  broker,
+		queue:   queue,
+	}
+}
+
+// Unwrap returns the wrapped backend
+func (b *Backend) Unwrap() iface.Backend {
+	return b.Backend
+}
+
+// SetLogger makes the backend and the wrapped one, if it supports loggers,
+// log to the logger instead of the package level loggers
+func (b *Backend) SetLogger(logger *log.Logger) {
+	b.logger = logger
+	if loggerBackend, ok := b.Backend.(iface.LoggerBackend); ok {
+		loggerBackend.SetLogger(logger)
+	}
+}
+
+// log returns the logger set with SetLogger, the package level loggers
+// otherwise
+func (b *Backend) log() *log.Logger {
+	if b.logger != nil {
+		return b.logger
+	}
+	return log.Default()
+}
+
+// NewEvent creates the signature of the event of the state change
+func NewEvent(queue string, taskState *tasks.TaskState) (*tasks.Signature, error) {
+	encoded, err := json.Marshal(taskState)
+	if err != nil {
+		return nil, fmt.Errorf("JSON marshal error: %s", err)
+	}
+
+	return &tasks.Signature{
+		UUID:       fmt.Sprintf("event_%v", uuid.New().String()),
+		Name:       tasks.StateEventTaskName,
+		RoutingKey: queue,
+		Args:       []tasks.Arg{{Type: "string", Value: string(encoded)}},
+	}, nil
+}
+
+// DecodeEvent returns the task state of the event
+func DecodeEvent(event *tasks.Signature) (*tasks.TaskState, error) {
+	if event.Name != tasks.StateEventTaskName || len(event.Args) != 1 {
+		return nil, fmt.Errorf("Task %s is not a state event", event.UUID)
+	}
+	encoded, ok := event.Args[0].Value.(string)
+	if !ok {
+		return nil, fmt.Errorf("State event %s is malformed", event.UUID)
+	}
+
+	taskState := new(tasks.TaskState)
+	if err := json.Unmarshal([]byte(encoded), taskState); err != nil {
+		return nil, fmt.Errorf("JSON unmarshal error: %s", err)
+	}
+	return taskState, nil
+}
+
+// SetStatePending updates task state to PENDING
+func (b *Backend) SetStatePending(signature *tasks.Signature) error {
+	if err := b.Backend.SetStatePending(signature); err != nil {
+		return err
+	}
+	b.publish(signature, tasks.NewPendingTaskState(signature))
+	return nil
+}
+
+// SetStateReceived updates task state to RECEIVED
+func (b *Backend) SetStateReceived(signature *tasks.Signature) error {
+	if err := b.Backend.SetStateReceived(signature); err != nil {
+		return err
+	}
+	b.publish(signature, tasks.NewReceivedTaskState(signature))
+	return nil
+}
+
+// SetStateStarted updates task state to STARTED
+func (b *Backend) SetStateStarted(signature *tasks.Signature) error {
+	if err := b.Backend.SetStateStarted(signature); err != nil {
+		return err
+	}
+	b.publish(signature, tasks.NewStartedTaskState(signature))
+	return nil
+}
+
+// SetStateRetry updates task state to RETRY
+func (b *Backend) SetStateRetry(signature *tasks.Signature) error {
+	if err := b.Backend.SetStateRetry(signature); err != nil {
+		return err
+	}
+	b.publish(signature, tasks.NewRetryTaskState(signature))
+	return nil
+}
+
+// SetStateSuccess updates task state to SUCCESS
+func (b *Backend) SetStateSuccess(signature *tasks.Signature, results []*tasks.TaskResult) error {
+	if err := b.Backend.SetStateSuccess(signature, results); err != nil {
+		return err
+	}
+	b.publish(signature, tasks.NewSuccessTaskState(signature, results))
+	return nil
+}
+
+// SetStateFailure updates task state to FAILURE
+func (b *Backend) SetStateFailure(signature *tasks.Signature, err string) error {
+	if err := b.Backend.SetStateFailure(signature, err); err != nil {
+		return err
+	}
+	b.publish(signature, tasks.NewFailureTaskState(signature, err))
+	return nil
+}
+
+// SetStateTimedOut updates task state to TIMED_OUT, or to FAILURE if the
+// wrapped backend is unable to store it
+func (b *Backend) SetStateTimedOut(signature *tasks.Signature) error {
+	timeoutBackend, ok := b.Backend.(iface.TimeoutBackend)
+	if !ok {
+		return b.SetStateFailure(signature, tasks.ErrGroupTimedOut.Error())
+	}
+
+	if err := timeoutBackend.SetStateTimedOut(signature); err != nil {
+		return err
+	}
+	b.publish(signature, tasks.NewTimedOutTaskState(signature))
+	return nil
+}
+
+// InitGroupPending stores the group meta data and pending states of its tasks,
+// in a single call if the wrapped backend supports batching
+func (b *Backend) InitGroupPending(groupUUID string, signatures []*tasks.Signature) error {
+	if batchBackend, ok := b.Backend.(iface.BatchBackend); ok {
+		if err := batchBackend.InitGroupPending(groupUUID, signatures); err != nil {
+			return err
+		}
+		for _, signature := range signatures {
+			b.publish(signature, tasks.NewPendingTaskState(signature))
+		}
+		return nil
+	}
+
+	taskUUIDs := make([]string, len(signatures))
+	for i, signature := range signatures {
+		taskUUIDs[i] = signature.UUID
+	}
+	if err := b.Backend.InitGroup(groupUUID, taskUUIDs); err != nil {
+		return err
+	}
+	for _, signature := range signatures {
+		if err := b.SetStatePending(signature); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WaitCompleted waits for the task to complete if the wrapped backend can
+// push state changes
+func (b *Backend) WaitCompleted(ctx context.Context, taskUUID string) (*tasks.TaskState, error) {
+	watcher, ok := b.Backend.(iface.WatchBackend)
+	if !ok {
+		return nil, errors.New("Wrapped backend does not support watching task states")
+	}
+	return watcher.WaitCompleted(ctx, taskUUID)
+}
+
+// ListStates lists task states matching the filter if the wrapped backend
+// supports queries
+func (b *Backend) ListStates(filter *tasks.StateFilter) ([]*tasks.TaskState, error) {
+	query, ok := b.Backend.(iface.QueryBackend)
+	if !ok {
+		return nil, errors.New("Wrapped backend does not support listing task states")
+	}
+	return query.ListStates(filter)
+}
+
+// publish publishes the event of the state change, with the name of the task
+// which only pending states keep
+func (b *Backend) publish(signature *tasks.Signature, taskState *tasks.TaskState) {
+	taskState.TaskName = signature.Name
+	event, err := NewEvent(b.queue, taskState)
+	if err == nil {
+		err = b.broker.Publish(context.Background(), event)
+	}
+	if err != nil {
+		b.log().WARNING.Printf("Failed to publish %s event of task %s: %s", taskState.State, taskState.TaskUUID, err)
+	}
+}
@@ -0,0 +1,112 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/backends/eager"
+	"github.com/RichardKnop/machinery/v2/backends/events"
+	"github.com/RichardKnop/machinery/v2/backends/iface"
+	"github.com/RichardKnop/machinery/v2/common"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/tasks"
+
+	brokersiface "github.com/RichardKnop/machinery/v2/brokers/iface"
+)
+
+// publishingBroker records published events, or fails to publish them
+type publishingBroker struct {
+	common.Broker
+	published []*tasks.Signature
+	err       error
+}
+
+func (b *publishingBroker) StartConsuming(consumerTag string, concurrency int, p brokersiface.TaskProcessor) (bool, error) {
+	return false, nil
+}
+
+func (b *publishingBroker) StopConsuming() {}
+
+func (b *publishingBroker) Publish(ctx context.Context, signature *tasks.Signature) error {
+	if b.err != nil {
+		return b.err
+	}
+	b.published = append(b.published, signature)
+	return nil
+}
+
+func TestStateEvents(t *testing.T) {
+	t.Parallel()
+
+	broker := &publishingBroker{Broker: common.NewBroker(new(config.Config))}
+	backend := events.New(eager.New(), broker, "events")
+	var _ iface.TimeoutBackend = backend
+	var _ iface.BatchBackend = backend
+
+	task := &tasks.Signature{UUID: "task_1", Name: "foo"}
+	assert.NoError(t, backend.SetStatePending(task))
+	assert.NoError(t, backend.SetStateReceived(task))
+	assert.NoError(t, backend.SetStateStarted(task))
+	assert.NoError(t, backend.SetStateRetry(task))
+	assert.NoError(t, backend.SetStateSuccess(task, []*tasks.TaskResult{{Type: "string", Value: "bar"}}))
+
+	var states []string
+	for _, event := range broker.published {
+		assert.Equal(t, tasks.StateEventTaskName, event.Name)
+		assert.Equal(t, "events", event.RoutingKey)
+
+		taskState, err := events.DecodeEvent(event)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "task_1", taskState.TaskUUID)
+			assert.Equal(t, "foo", taskState.TaskName)
+			states = append(states, taskState.State)
+		}
+	}
+	assert.Equal(t, []string{tasks.StatePending, tasks.StateReceived, tasks.StateStarted, tasks.StateRetry, tasks.StateSuccess}, states)
+
+	taskState, err := events.DecodeEvent(broker.published[4])
+	if assert.NoError(t, err) && assert.Len(t, taskState.Results, 1) {
+		assert.Equal(t, "bar", taskState.Results[0].Value)
+	}
+
+	assert.NoError(t, backend.SetStateTimedOut(task))
+	taskState, err = events.DecodeEvent(broker.published[5])
+	if assert.NoError(t, err) {
+		assert.True(t, taskState.IsTimedOut())
+	}
+
+	group := []*tasks.Signature{{UUID: "task_2"}, {UUID: "task_3"}}
+	assert.NoError(t, backend.InitGroupPending("group_1", group))
+	assert.Len(t, broker.published, 8)
+}
+
+func TestStateEventsPublishFailure(t *testing.T) {
+	t.Parallel()
+
+	broker := &publishingBroker{Broker: common.NewBroker(new(config.Config)), err: errors.New("broker down")}
+	backend := events.New(eager.New(), broker, "events")
+
+	// The state is stored all the same
+	task := &tasks.Signature{UUID: "task_1", Name: "foo"}
+	assert.NoError(t, backend.SetStateFailure(task, "oops"))
+	taskState, err := backend.GetState("task_1")
+	if assert.NoError(t, err) {
+		assert.True(t, taskState.IsFailure())
+	}
+}
+
+func TestDecodeEvent(t *testing.T) {
+	t.Parallel()
+
+	_, err := events.DecodeEvent(&tasks.Signature{Name: "foo"})
+	assert.Error(t, err)
+
+	_, err = events.DecodeEvent(&tasks.Signature{
+		Name: tasks.StateEventTaskName,
+		Args: []tasks.Arg{{Type: "int64", Value: 1}},
+	})
+	assert.Error(t, err)
+}
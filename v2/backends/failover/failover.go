@@ -0,0 +1,703 @@
+package failover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/RichardKnop/machinery/v2/backends/iface"
+	"github.com/RichardKnop/machinery/v2/log"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+// Backend writes to a primary and a secondary result backend and reads from
+// whichever has the most recent state, so an outage of one of them doesn't
+// break chords or result retrieval of tasks already in flight
+type Backend struct {
+	primary   iface.Backend
+	secondary iface.Backend
+	logger    *log.Logger
+}
+
+// New creates Backend instance writing to both backends. The primary backend
+// decides whether a chord is triggered as long as it is available.
+func New(primary, secondary iface.Backend) iface.Backend {
+	return &Backend{
+		primary:   primary,
+		secondary: secondary,
+	}
+}
+
+// Primary returns the primary backend
+func (b *Backend) Primary() iface.Backend {
+	return b.primary
+}
+
+// Secondary returns the secondary backend
+func (b *Backend) Secondary() iface.Backend {
+	return b.secondary
+}
+
+// SetCodec sets the codec of both backends, if they support codecs
+func (b *Backend) SetCodec(codec tasks.Codec) {
+	for _, backend := range []iface.Backend{b.primary, b.secondary} {
+		if codecBackend, ok := backend.(iface.CodecBackend); ok {
+			codecBackend.SetCodec(codec)
+		}
+	}
+}
+
+// SetLogger makes the backend and both backends, if they support loggers,
+// log to the logger instead of the package level loggers
+func (b *Backend) SetLogger(logger *log.Logger) {
+	b.logger = logger
+	for _, backend := range []iface.Backend{b.primary, b.secondary} {
+		if loggerBackend, ok := backend.(iface.LoggerBackend); ok {
+			loggerBackend.SetLogger(logger)
+		}
+	}
+}
+
+// log returns the logger set with SetLogger, the package level loggers
+// otherwise
+func (b *Backend) log() *log.Logger {
+	if b.logger != nil {
+		return b.logger
+	}
+	return log.Default()
+}
+
+// InitGroup creates and saves a group meta data object in both backends
+func (b *Backend) InitGroup(groupUUID string, taskUUIDs []string) error {
+	return b.write("init group "+groupUUID, func(backend iface.Backend) error {
+		return backend.InitGroup(groupUUID, taskUUIDs)
+	})
+}
+
+// InitGroupPending saves the group meta data and pending states of its tasks
+// in both backends, batched if the backend supports it
+func (b *Backend) InitGroupPending(groupUUID string, signatures []*tasks.Signature) error {
+	return b.write("init group "+groupUUID, func(backend iface.Backend) error {
+		if batchBackend, ok := backend.(iface.BatchBackend); ok {
+			return batchBackend.InitGroupPending(groupUUID, signatures)
+		}
+
+		taskUUIDs := make([]string, len(signatures))
+		for i, signature := range signatures {
+			taskUUIDs[i] = signature.UUID
+		}
+		if err := backend.InitGroup(groupUUID, taskUUIDs); err != nil {
+			return err
+		}
+		for _, signature := range signatures {
+			if err := backend.SetStatePending(signature); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GroupCompleted returns true if either backend has all tasks of the group
+// completed, a backend which was down missed some of the states
+func (b *Backend) GroupCompleted(groupUUID string, groupTaskCount int) (bool, error) {
+	completed, err := b.primary.GroupCompleted(groupUUID, groupTaskCount)
+	if err == nil && completed {
+		return true, nil
+	}
+
+	secondaryCompleted, secondaryErr := b.secondary.GroupCompleted(groupUUID, groupTaskCount)
+	if secondaryErr != nil {
+		if err != nil {
+			return false, fmt.Errorf("Group completed error: primary: %s, secondary: %s", err, secondaryErr)
+		}
+		return completed, nil
+	}
+	return secondaryCompleted, nil
+}
+
+// GroupTaskStates returns the most recent state of every task in the group
+// found in either backend
+func (b *Backend) GroupTaskStates(groupUUID string, groupTaskCount int) ([]*tasks.TaskState, error) {
+	taskStates, err := b.primary.GroupTaskStates(groupUUID, groupTaskCount)
+	if err == nil && allCompleted(taskStates) {
+		return taskStates, nil
+	}
+
+	secondaryTaskStates, secondaryErr := b.secondary.GroupTaskStates(groupUUID, groupTaskCount)
+	switch {
+	case secondaryErr != nil && err != nil:
+		return nil, fmt.Errorf("Group task states error: primary: %s, secondary: %s", err, secondaryErr)
+	case secondaryErr != nil:
+		return taskStates, nil
+	case err != nil || len(taskStates) != len(secondaryTaskStates):
+		return secondaryTaskStates, nil
+	}
+
+	merged := make([]*tasks.TaskState, len(taskStates))
+	for i, taskState := range taskStates {
+		merged[i] = latest(taskState, secondaryTaskStates[i])
+	}
+	return merged, nil
+}
+
+// TriggerChord flags the chord as triggered in both backends and returns
+// true if it should be triggered. The primary backend decides as long as it
+// is available. The secondary backend is only asked after the primary
+// backend agreed, it refuses if the chord was triggered while the primary
+// backend was down.
+func (b *Backend) TriggerChord(groupUUID string) (bool, error) {
+	shouldTrigger, err := b.primary.TriggerChord(groupUUID)
+	if err != nil {
+		b.log().WARNING.Printf("Primary backend failed to trigger chord of group %s, using secondary: %s", groupUUID, err)
+		return b.secondary.TriggerChord(groupUUID)
+	}
+	if !shouldTrigger {
+		return false, nil
+	}
+
+	secondaryShouldTrigger, err := b.secondary.TriggerChord(groupUUID)
+	if err != nil {
+		b.log().WARNING.Printf("Secondary backend failed to trigger chord of group %s: %s", groupUUID, err)
+		return true, nil
+	}
+	return secondaryShouldTrigger, nil
+}
+
+// SetStatePending updates task state to PENDING in both backends
+func (b *Backend) SetStatePending(signature *tasks.Signature) error {
+	return b.write("set state of task "+signature.UUID, func(backend iface.Backend) error {
+		return backend.SetStatePending(signature)
+	})
+}
+
+// SetStateReceived updates task state to RECEIVED in both backends
+func (b *Backend) SetStateReceived(signature *tasks.Signature) error {
+	return b.write("set state of task "+signature.UUID, func(backend iface.Backend) error {
+		return backend.SetStateReceived(signature)
+	})
+}
+
+// SetStateStarted updates task state to STARTED in both backends
+func (b *Backend) SetStateStarted(signature *tasks.Signature) error {
+	return b.write("set state of task "+signature.UUID, func(backend iface.Backend) error {
+		return backend.SetStateStarted(signature)
+	})
+}
+
+// SetStateRetry updates task state to RETRY in both backends
+func (b *Backend) SetStateRetry(signature *tasks.Signature) error {
+	return b.write("set state of task "+signature.UUID, func(backend iface.Backend) error {
+		return backend.SetStateRetry(signature)
+	})
+}
+
+// SetStateSuccess updates task state to SUCCESS in both backends
+func (b *Backend) SetStateSuccess(signature *tasks.Signature, results []*tasks.TaskResult) error {
+	return b.write("set state of task "+signature.UUID, func(backend iface.Backend) error {
+		return backend.SetStateSuccess(signature, results)
+	})
+}
+
+// SetStateFailure updates task state to FAILURE in both backends
+func (b *Backend) SetStateFailure(signature *tasks.Signature, err string) error {
+	return b.write("set state of task "+signature.UUID, func(backend iface.Backend) error {
+		return backend.SetStateFailure(signature, err)
+	})
+}
+
+// SetStateTimedOut updates task state to TIMED_OUT in both backends, or to
+// FAILURE in a backend unable to store it
+func (b *Backend) SetStateTimedOut(signature *tasks.Signature) error {
+	return b.write("set state of task "+signature.UUID, func(backend iface.Backend) error {
+		if timeoutBackend, ok := backend.(iface.TimeoutBackend); ok {
+			return timeoutBackend.SetStateTimedOut(signature)
+		}
+		return backend.SetStateFailure(signature, tasks.ErrGroupTimedOut.Error())
+	})
+}
+
+// GetState returns the most recent task state found in either backend
+func (b *Backend) GetState(taskUUID string) (*tasks.TaskState, error) {
+	taskState, err := b.primary.GetState(taskUUID)
+	if err == nil && taskState.IsCompleted() {
+		return taskState, nil
+	}
+
+	secondaryTaskState, secondaryErr := b.secondary.GetState(taskUUID)
+	switch {
+	case secondaryErr != nil && err != nil:
+		return nil, fmt.Errorf("Get state error: primary: %s, secondary: %s", err, secondaryErr)
+	case secondaryErr != nil:
+		return taskState, nil
+	case err != nil:
+		return secondaryTaskState, nil
+	}
+	return latest(taskState, secondaryTaskState), nil
+}
+
+// WaitCompleted waits for the task to complete on the primary backend if it
+// can push state changes
+func (b *Backend) WaitCompleted(ctx context.Context, taskUUID string) (*tasks.TaskState, error) {
+	watcher, ok := b.primary.(iface.WatchBackend)
+	if !ok {
+		return nil, errors.New("Primary backend does not support watching task states")
+	}
+	return watcher.WaitCompleted(ctx, taskUUID)
+}
+
+// ListStates lists task states matching the filter on the primary backend,
+// or on the secondary backend if the primary one fails or can't be queried
+func (b *Backend) ListStates(filter *tasks.StateFilter) ([]*tasks.TaskState, error) {
+	var err error
+	if query, ok := b.primary.(iface.QueryBackend); ok {
+		taskStates, primaryErr := query.ListStates(filter)
+		if primaryErr == nil {
+			return taskStates, nil
+		}
+		err = primaryErr
+		b.log().WARNING.Printf("Primary backend failed to list task states, using secondary: %s", err)
+	}
+
+	query, ok := b.secondary.(iface.QueryBackend)
+	switch {
+	case !ok && err != nil:
+		return nil, err
+	case !ok:
+		return nil, errors.New("Neither backend supports listing task states")
+	}
+	return query.ListStates(filter)
+}
+
+// PutDeadLetter stores the dead letter in both backends, if they keep dead
+// letters
+func (b *Backend) PutDeadLetter(deadLetter *tasks.DeadLetter) error {
+	return b.write("put dead letter "+deadLetter.ID, func(backend iface.Backend) error {
+		deadLetterBackend, ok := backend.(iface.DeadLetterBackend)
+		if !ok {
+			return errors.New("Backend does not support dead letters")
+		}
+		return deadLetterBackend.PutDeadLetter(deadLetter)
+	})
+}
+
+// ListDeadLetters lists dead letters matching the filter on the primary
+// backend, or on the secondary backend if the primary one fails or doesn't
+// keep dead letters
+func (b *Backend) ListDeadLetters(filter *tasks.DeadLetterFilter) ([]*tasks.DeadLetter, error) {
+	var err error
+	if deadLetterBackend, ok := b.primary.(iface.DeadLetterBackend); ok {
+		deadLetters, primaryErr := deadLetterBackend.ListDeadLetters(filter)
+		if primaryErr == nil {
+			return deadLetters, nil
+		}
+		err = primaryErr
+		b.log().WARNING.Printf("Primary backend failed to list dead letters, using secondary: %s", err)
+	}
+
+	deadLetterBackend, ok := b.secondary.(iface.DeadLetterBackend)
+	switch {
+	case !ok && err != nil:
+		return nil, err
+	case !ok:
+		return nil, errors.New("Neither backend supports dead letters")
+	}
+	return deadLetterBackend.ListDeadLetters(filter)
+}
+
+// DeleteDeadLetter removes the dead letter from both backends
+func (b *Backend) DeleteDeadLetter(id string) error {
+	return b.write("delete dead letter "+id, func(backend iface.Backend) error {
+		deadLetterBackend, ok := backend.(iface.DeadLetterBackend)
+		if !ok {
+			return errors.New("Backend does not support dead letters")
+		}
+		return deadLetterBackend.DeleteDeadLetter(id)
+	})
+}
+
+// SetLastRun stores when the periodic task or workflow last ran in both
+// backends, if they store it
+func (b *Backend) SetLastRun(name string, lastRun time.Time) error {
+	return b.write("set last run of "+name, func(backend iface.Backend) error {
+		scheduleBackend, ok := backend.(iface.ScheduleBackend)
+		if !ok {
+			return errors.New("Backend does not support storing last runs")
+		}
+		return scheduleBackend.SetLastRun(name, lastRun)
+	})
+}
+
+// LastRun returns when the periodic task or workflow last ran from the
+// primary backend, or from the secondary backend if the primary one fails or
+// doesn't store it
+func (b *Backend) LastRun(name string) (time.Time, error) {
+	var err error
+	if scheduleBackend, ok := b.primary.(iface.ScheduleBackend); ok {
+		lastRun, primaryErr := scheduleBackend.LastRun(name)
+		if primaryErr == nil {
+			return lastRun, nil
+		}
+		err = primaryErr
+		b.log().WARNING.Printf("Primary backend failed to get last run, using secondary: %s", err)
+	}
+
+	scheduleBackend, ok := b.secondary.(iface.ScheduleBackend)
+	switch {
+	case !ok && err != nil:
+		return time.Time{}, err
+	case !ok:
+		return time.Time{}, errors.New("Neither backend supports storing last runs")
+	}
+	return scheduleBackend.LastRun(name)
+}
+
+// RevokeTask marks the task revoked in both backends, if they store
+// revocations
+func (b *Backend) RevokeTask(taskUUID string) error {
+	return b.write("revoke task "+taskUUID, func(backend iface.Backend) error {
+		revokeBackend, ok := backend.(iface.RevokeBackend)
+		if !ok {
+			return errors.New("Backend does not support revoking tasks")
+		}
+		return revokeBackend.RevokeTask(taskUUID)
+	})
+}
+
+// IsRevoked returns true if either backend has the task revoked, a backend
+// which was down missed the revocation
+func (b *Backend) IsRevoked(taskUUID string) (bool, error) {
+	var err error
+	if revokeBackend, ok := b.primary.(iface.RevokeBackend); ok {
+		revoked, primaryErr := revokeBackend.IsRevoked(taskUUID)
+		if primaryErr == nil && revoked {
+			return true, nil
+		}
+		err = primaryErr
+	}
+
+	revokeBackend, ok := b.secondary.(iface.RevokeBackend)
+	if !ok {
+		return false, err
+	}
+	revoked, secondaryErr := revokeBackend.IsRevoked(taskUUID)
+	if secondaryErr != nil {
+		if err != nil {
+			return false, fmt.Errorf("Is revoked error: primary: %s, secondary: %s", err, secondaryErr)
+		}
+		return false, nil
+	}
+	return revoked, nil
+}
+
+// SetProgress stores the progress of the task in both backends, if they
+// store progress
+func (b *Backend) SetProgress(signature *tasks.Signature, progress *tasks.Progress) error {
+	return b.write("set progress of task "+signature.UUID, func(backend iface.Backend) error {
+		progressBackend, ok := backend.(iface.ProgressBackend)
+		if !ok {
+			return errors.New("Backend does not support progress")
+		}
+		return progressBackend.SetProgress(signature, progress)
+	})
+}
+
+// SetDiagnostics stores the diagnostics of the task in both backends, if
+// they store diagnostics
+func (b *Backend) SetDiagnostics(signature *tasks.Signature, diagnostics *tasks.Diagnostics) error {
+	return b.write("set diagnostics of task "+signature.UUID, func(backend iface.Backend) error {
+		diagnosticsBackend, ok := backend.(iface.DiagnosticsBackend)
+		if !ok {
+			return errors.New("Backend does not support diagnostics")
+		}
+		return diagnosticsBackend.SetDiagnostics(signature, diagnostics)
+	})
+}
+
+// ClaimIdempotencyKey claims the idempotency key of the task in both
+// backends and returns the UUID of the task owning it. The primary backend
+// decides as long as it is available. The secondary backend is only asked
+// after the primary backend let the task claim the key, its owner wins if
+// the key was claimed while the primary backend was down, the claim of the
+// task is then released on the primary backend.
+func (b *Backend) ClaimIdempotencyKey(signature *tasks.Signature) (string, error) {
+	primary, primaryOK := b.primary.(iface.IdempotencyBackend)
+	secondary, secondaryOK := b.secondary.(iface.IdempotencyBackend)
+	if !primaryOK && !secondaryOK {
+		return "", errors.New("Neither backend supports idempotency keys")
+	}
+	if !primaryOK {
+		return secondary.ClaimIdempotencyKey(signature)
+	}
+
+	owner, err := primary.ClaimIdempotencyKey(signature)
+	if err != nil {
+		if !secondaryOK {
+			return "", err
+		}
+		b.log().WARNING.Printf("Primary backend failed to claim idempotency key of task %s, using secondary: %s", signature.UUID, err)
+		return secondary.ClaimIdempotencyKey(signature)
+	}
+	if owner != signature.UUID || !secondaryOK {
+		return owner, nil
+	}
+
+	secondaryOwner, err := secondary.ClaimIdempotencyKey(signature)
+	if err != nil {
+		b.log().WARNING.Printf("Secondary backend failed to claim idempotency key of task %s: %s", signature.UUID, err)
+		return owner, nil
+	}
+	if secondaryOwner != owner {
+		if err := primary.ReleaseIdempotencyKey(signature.IdempotencyKey, signature.UUID); err != nil {
+			b.log().WARNING.Printf("Primary backend failed to release idempotency key of task %s: %s", signature.UUID, err)
+		}
+	}
+	return secondaryOwner, nil
+}
+
+// IdempotencyKeyOwner returns the UUID of the task owning the key from the
+// primary backend, or from the secondary backend if the primary one fails,
+// doesn't know an owner or doesn't keep idempotency keys
+func (b *Backend) IdempotencyKeyOwner(key string) (string, error) {
+	var err error
+	if idempotencyBackend, ok := b.primary.(iface.IdempotencyBackend); ok {
+		owner, primaryErr := idempotencyBackend.IdempotencyKeyOwner(key)
+		if primaryErr == nil && owner != "" {
+			return owner, nil
+		}
+		err = primaryErr
+		if err != nil {
+			b.log().WARNING.Printf("Primary backend failed to get owner of idempotency key %s, using secondary: %s", key, err)
+		}
+	}
+
+	idempotencyBackend, ok := b.secondary.(iface.IdempotencyBackend)
+	switch {
+	case !ok && err != nil:
+		return "", err
+	case !ok:
+		return "", nil
+	}
+	return idempotencyBackend.IdempotencyKeyOwner(key)
+}
+
+// ReleaseIdempotencyKey removes the key from both backends if the task
+// still owns it
+func (b *Backend) ReleaseIdempotencyKey(key, taskUUID string) error {
+	return b.write("release idempotency key "+key, func(backend iface.Backend) error {
+		idempotencyBackend, ok := backend.(iface.IdempotencyBackend)
+		if !ok {
+			return errors.New("Backend does not support idempotency keys")
+		}
+		return idempotencyBackend.ReleaseIdempotencyKey(key, taskUUID)
+	})
+}
+
+// ReportInFlight stores the numbers of tasks in flight of the worker in both
+// backends, if they sum them
+func (b *Backend) ReportInFlight(workerID string, counts map[string]int, ttl time.Duration) error {
+	return b.write("report tasks in flight of worker "+workerID, func(backend iface.Backend) error {
+		inFlightBackend, ok := backend.(iface.InFlightBackend)
+		if !ok {
+			return errors.New("Backend does not support tasks in flight")
+		}
+		return inFlightBackend.ReportInFlight(workerID, counts, ttl)
+	})
+}
+
+// InFlight sums the tasks in flight on the primary backend, or on the
+// secondary backend if the primary one fails or doesn't sum them
+func (b *Backend) InFlight() (map[string]int, error) {
+	var err error
+	if inFlightBackend, ok := b.primary.(iface.InFlightBackend); ok {
+		counts, primaryErr := inFlightBackend.InFlight()
+		if primaryErr == nil {
+			return counts, nil
+		}
+		err = primaryErr
+		b.log().WARNING.Printf("Primary backend failed to sum tasks in flight, using secondary: %s", err)
+	}
+
+	inFlightBackend, ok := b.secondary.(iface.InFlightBackend)
+	switch {
+	case !ok && err != nil:
+		return nil, err
+	case !ok:
+		return nil, errors.New("Neither backend supports tasks in flight")
+	}
+	return inFlightBackend.InFlight()
+}
+
+// ReportWorker stores the heartbeat of the worker in both backends, if they
+// keep heartbeats
+func (b *Backend) ReportWorker(workerID string, worker *tasks.WorkerInfo, ttl time.Duration) error {
+	return b.write("report heartbeat of worker "+workerID, func(backend iface.Backend) error {
+		presenceBackend, ok := backend.(iface.PresenceBackend)
+		if !ok {
+			return errors.New("Backend does not support worker heartbeats")
+		}
+		return presenceBackend.ReportWorker(workerID, worker, ttl)
+	})
+}
+
+// Workers returns the heartbeats from the primary backend, or from the
+// secondary backend if the primary one fails or doesn't keep heartbeats
+func (b *Backend) Workers() ([]*tasks.WorkerInfo, error) {
+	var err error
+	if presenceBackend, ok := b.primary.(iface.PresenceBackend); ok {
+		workers, primaryErr := presenceBackend.Workers()
+		if primaryErr == nil {
+			return workers, nil
+		}
+		err = primaryErr
+		b.log().WARNING.Printf("Primary backend failed to list workers, using secondary: %s", err)
+	}
+
+	presenceBackend, ok := b.secondary.(iface.PresenceBackend)
+	switch {
+	case !ok && err != nil:
+		return nil, err
+	case !ok:
+		return nil, errors.New("Neither backend supports worker heartbeats")
+	}
+	return presenceBackend.Workers()
+}
+
+// PublishControl publishes the control message on the primary backend, or on
+// the secondary backend if the primary one fails or doesn't broadcast
+// control messages. Both backends number messages on their own, so it isn't
+// written to both.
+func (b *Backend) PublishControl(message *tasks.ControlMessage, ttl time.Duration) error {
+	var err error
+	if controlBackend, ok := b.primary.(iface.ControlBackend); ok {
+		primaryErr := controlBackend.PublishControl(message, ttl)
+		if primaryErr == nil {
+			return nil
+		}
+		err = primaryErr
+		b.log().WARNING.Printf("Primary backend failed to publish control message, using secondary: %s", err)
+	}
+
+	controlBackend, ok := b.secondary.(iface.ControlBackend)
+	switch {
+	case !ok && err != nil:
+		return err
+	case !ok:
+		return errors.New("Neither backend supports control messages")
+	}
+	return controlBackend.PublishControl(message, ttl)
+}
+
+// ControlMessages returns the control messages from the primary backend, or
+// from the secondary backend if the primary one fails or doesn't broadcast
+// control messages
+func (b *Backend) ControlMessages(afterID int64) ([]*tasks.ControlMessage, error) {
+	var err error
+	if controlBackend, ok := b.primary.(iface.ControlBackend); ok {
+		messages, primaryErr := controlBackend.ControlMessages(afterID)
+		if primaryErr == nil {
+			return messages, nil
+		}
+		err = primaryErr
+		b.log().WARNING.Printf("Primary backend failed to list control messages, using secondary: %s", err)
+	}
+
+	controlBackend, ok := b.secondary.(iface.ControlBackend)
+	switch {
+	case !ok && err != nil:
+		return nil, err
+	case !ok:
+		return nil, errors.New("Neither backend supports control messages")
+	}
+	return controlBackend.ControlMessages(afterID)
+}
+
+// ReplyControl stores the reply of the worker in both backends, if they
+// broadcast control messages
+func (b *Backend) ReplyControl(reply *tasks.ControlReply, ttl time.Duration) error {
+	return b.write(fmt.Sprintf("reply to control message %d", reply.MessageID), func(backend iface.Backend) error {
+		controlBackend, ok := backend.(iface.ControlBackend)
+		if !ok {
+			return errors.New("Backend does not support control messages")
+		}
+		return controlBackend.ReplyControl(reply, ttl)
+	})
+}
+
+// ControlReplies returns the replies to the control message from the
+// primary backend, or from the secondary backend if the primary one fails
+// or doesn't broadcast control messages
+func (b *Backend) ControlReplies(messageID int64) ([]*tasks.ControlReply, error) {
+	var err error
+	if controlBackend, ok := b.primary.(iface.ControlBackend); ok {
+		replies, primaryErr := controlBackend.ControlReplies(messageID)
+		if primaryErr == nil {
+			return replies, nil
+		}
+		err = primaryErr
+		b.log().WARNING.Printf("Primary backend failed to list control replies, using secondary: %s", err)
+	}
+
+	controlBackend, ok := b.secondary.(iface.ControlBackend)
+	switch {
+	case !ok && err != nil:
+		return nil, err
+	case !ok:
+		return nil, errors.New("Neither backend supports control messages")
+	}
+	return controlBackend.ControlReplies(messageID)
+}
+
+// IsAMQP returns true if the primary backend is AMQP
+func (b *Backend) IsAMQP() bool {
+	return b.primary.IsAMQP()
+}
+
+// PurgeState deletes stored task state from both backends
+func (b *Backend) PurgeState(taskUUID string) error {
+	return b.write("purge state of task "+taskUUID, func(backend iface.Backend) error {
+		return backend.PurgeState(taskUUID)
+	})
+}
+
+// PurgeGroupMeta deletes stored group meta data from both backends
+func (b *Backend) PurgeGroupMeta(groupUUID string) error {
+	return b.write("purge group "+groupUUID, func(backend iface.Backend) error {
+		return backend.PurgeGroupMeta(groupUUID)
+	})
+}
+
+// write applies the operation to both backends, it only fails if both do
+func (b *Backend) write(operation string, apply func(iface.Backend) error) error {
+	err := apply(b.primary)
+	secondaryErr := apply(b.secondary)
+
+	switch {
+	case err != nil && secondaryErr != nil:
+		return fmt.Errorf("Failed to %s: primary: %s, secondary: %s", operation, err, secondaryErr)
+	case err != nil:
+		b.log().WARNING.Printf("Primary backend failed to %s: %s", operation, err)
+	case secondaryErr != nil:
+		b.log().WARNING.Printf("Secondary backend failed to %s: %s", operation, secondaryErr)
+	}
+	return nil
+}
+
+// latest returns the completed one of two states of a task, preferring the
+// primary state
+func latest(primary, secondary *tasks.TaskState) *tasks.TaskState {
+	if primary == nil || (!primary.IsCompleted() && secondary != nil && secondary.IsCompleted()) {
+		return secondary
+	}
+	return primary
+}
+
+func allCompleted(taskStates []*tasks.TaskState) bool {
+	for _, taskState := range taskStates {
+		if taskState == nil || !taskState.IsCompleted() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,404 @@
+package failover_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/backends/failover"
+	"github.com/RichardKnop/machinery/v2/backends/iface"
+	"github.com/RichardKnop/machinery/v2/backends/memory"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/conformance"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+var errDown = errors.New("backend is down")
+
+// flakyBackend fails every call while it is down
+type flakyBackend struct {
+	iface.Backend
+	down bool
+}
+
+func newFlakyBackend() *flakyBackend {
+	return &flakyBackend{Backend: memory.New(new(config.Config))}
+}
+
+func (b *flakyBackend) InitGroup(groupUUID string, taskUUIDs []string) error {
+	if b.down {
+		return errDown
+	}
+	return b.Backend.InitGroup(groupUUID, taskUUIDs)
+}
+
+func (b *flakyBackend) GroupCompleted(groupUUID string, groupTaskCount int) (bool, error) {
+	if b.down {
+		return false, errDown
+	}
+	return b.Backend.GroupCompleted(groupUUID, groupTaskCount)
+}
+
+func (b *flakyBackend) GroupTaskStates(groupUUID string, groupTaskCount int) ([]*tasks.TaskState, error) {
+	if b.down {
+		return nil, errDown
+	}
+	return b.Backend.GroupTaskStates(groupUUID, groupTaskCount)
+}
+
+func (b *flakyBackend) TriggerChord(groupUUID string) (bool, error) {
+	if b.down {
+		return false, errDown
+	}
+	return b.Backend.TriggerChord(groupUUID)
+}
+
+func (b *flakyBackend) SetStatePending(signature *tasks.Signature) error {
+	if b.down {
+		return errDown
+	}
+	return b.Backend.SetStatePending(signature)
+}
+
+func (b *flakyBackend) SetStateSuccess(signature *tasks.Signature, results []*tasks.TaskResult) error {
+	if b.down {
+		return errDown
+	}
+	return b.Backend.SetStateSuccess(signature, results)
+}
+
+func (b *flakyBackend) GetState(taskUUID string) (*tasks.TaskState, error) {
+	if b.down {
+		return nil, errDown
+	}
+	return b.Backend.GetState(taskUUID)
+}
+
+func (b *flakyBackend) ListStates(filter *tasks.StateFilter) ([]*tasks.TaskState, error) {
+	if b.down {
+		return nil, errDown
+	}
+	return b.Backend.(iface.QueryBackend).ListStates(filter)
+}
+
+func (b *flakyBackend) PutDeadLetter(deadLetter *tasks.DeadLetter) error {
+	if b.down {
+		return errDown
+	}
+	return b.Backend.(iface.DeadLetterBackend).PutDeadLetter(deadLetter)
+}
+
+func (b *flakyBackend) ListDeadLetters(filter *tasks.DeadLetterFilter) ([]*tasks.DeadLetter, error) {
+	if b.down {
+		return nil, errDown
+	}
+	return b.Backend.(iface.DeadLetterBackend).ListDeadLetters(filter)
+}
+
+func (b *flakyBackend) DeleteDeadLetter(id string) error {
+	if b.down {
+		return errDown
+	}
+	return b.Backend.(iface.DeadLetterBackend).DeleteDeadLetter(id)
+}
+
+func (b *flakyBackend) RevokeTask(taskUUID string) error {
+	if b.down {
+		return errDown
+	}
+	return b.Backend.(iface.RevokeBackend).RevokeTask(taskUUID)
+}
+
+func (b *flakyBackend) IsRevoked(taskUUID string) (bool, error) {
+	if b.down {
+		return false, errDown
+	}
+	return b.Backend.(iface.RevokeBackend).IsRevoked(taskUUID)
+}
+
+func (b *flakyBackend) ClaimIdempotencyKey(signature *tasks.Signature) (string, error) {
+	if b.down {
+		return "", errDown
+	}
+	return b.Backend.(iface.IdempotencyBackend).ClaimIdempotencyKey(signature)
+}
+
+func (b *flakyBackend) IdempotencyKeyOwner(key string) (string, error) {
+	if b.down {
+		return "", errDown
+	}
+	return b.Backend.(iface.IdempotencyBackend).IdempotencyKeyOwner(key)
+}
+
+func (b *flakyBackend) ReleaseIdempotencyKey(key, taskUUID string) error {
+	if b.down {
+		return errDown
+	}
+	return b.Backend.(iface.IdempotencyBackend).ReleaseIdempotencyKey(key, taskUUID)
+}
+
+func (b *flakyBackend) ReportWorker(workerID string, worker *tasks.WorkerInfo, ttl time.Duration) error {
+	if b.down {
+		return errDown
+	}
+	return b.Backend.(iface.PresenceBackend).ReportWorker(workerID, worker, ttl)
+}
+
+func (b *flakyBackend) Workers() ([]*tasks.WorkerInfo, error) {
+	if b.down {
+		return nil, errDown
+	}
+	return b.Backend.(iface.PresenceBackend).Workers()
+}
+
+func (b *flakyBackend) PublishControl(message *tasks.ControlMessage, ttl time.Duration) error {
+	if b.down {
+		return errDown
+	}
+	return b.Backend.(iface.ControlBackend).PublishControl(message, ttl)
+}
+
+func (b *flakyBackend) ControlMessages(afterID int64) ([]*tasks.ControlMessage, error) {
+	if b.down {
+		return nil, errDown
+	}
+	return b.Backend.(iface.ControlBackend).ControlMessages(afterID)
+}
+
+func (b *flakyBackend) ReplyControl(reply *tasks.ControlReply, ttl time.Duration) error {
+	if b.down {
+		return errDown
+	}
+	return b.Backend.(iface.ControlBackend).ReplyControl(reply, ttl)
+}
+
+func (b *flakyBackend) ControlReplies(messageID int64) ([]*tasks.ControlReply, error) {
+	if b.down {
+		return nil, errDown
+	}
+	return b.Backend.(iface.ControlBackend).ControlReplies(messageID)
+}
+
+func TestConformance(t *testing.T) {
+	conformance.TestBackend(t, func(t *testing.T) iface.Backend {
+		return failover.New(memory.New(new(config.Config)), memory.New(new(config.Config)))
+	})
+}
+
+func TestWriteFailsOnlyIfBothBackendsFail(t *testing.T) {
+	t.Parallel()
+
+	primary, secondary := newFlakyBackend(), newFlakyBackend()
+	backend := failover.New(primary, secondary)
+	signature := &tasks.Signature{UUID: "task_1"}
+
+	primary.down = true
+	assert.NoError(t, backend.SetStatePending(signature))
+
+	secondary.down = true
+	assert.Error(t, backend.SetStatePending(signature))
+}
+
+func TestReadFallsBackToSecondary(t *testing.T) {
+	t.Parallel()
+
+	primary, secondary := newFlakyBackend(), newFlakyBackend()
+	backend := failover.New(primary, secondary)
+	signature := &tasks.Signature{UUID: "task_1"}
+	assert.NoError(t, backend.SetStatePending(signature))
+
+	// The primary backend misses the success while it is down and has a
+	// stale state once it is back
+	primary.down = true
+	assert.NoError(t, backend.SetStateSuccess(signature, nil))
+
+	taskState, err := backend.GetState(signature.UUID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, tasks.StateSuccess, taskState.State)
+	}
+
+	primary.down = false
+	taskState, err = backend.GetState(signature.UUID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, tasks.StateSuccess, taskState.State)
+	}
+}
+
+func TestListStatesFallsBackToSecondary(t *testing.T) {
+	t.Parallel()
+
+	primary, secondary := newFlakyBackend(), newFlakyBackend()
+	backend := failover.New(primary, secondary).(iface.QueryBackend)
+	assert.NoError(t, secondary.SetStatePending(&tasks.Signature{UUID: "task_1"}))
+
+	taskStates, err := backend.ListStates(new(tasks.StateFilter))
+	assert.NoError(t, err)
+	assert.Empty(t, taskStates)
+
+	primary.down = true
+	taskStates, err = backend.ListStates(new(tasks.StateFilter))
+	if assert.NoError(t, err) && assert.Len(t, taskStates, 1) {
+		assert.Equal(t, "task_1", taskStates[0].TaskUUID)
+	}
+
+	secondary.down = true
+	_, err = backend.ListStates(new(tasks.StateFilter))
+	assert.Error(t, err)
+}
+
+func TestDeadLettersSurviveOutage(t *testing.T) {
+	t.Parallel()
+
+	primary, secondary := newFlakyBackend(), newFlakyBackend()
+	backend := failover.New(primary, secondary).(iface.DeadLetterBackend)
+	primary.down = true
+	assert.NoError(t, backend.PutDeadLetter(&tasks.DeadLetter{ID: "task_1", Reason: tasks.DeadLetterExhausted}))
+
+	deadLetters, err := backend.ListDeadLetters(new(tasks.DeadLetterFilter))
+	if assert.NoError(t, err) && assert.Len(t, deadLetters, 1) {
+		assert.Equal(t, "task_1", deadLetters[0].ID)
+	}
+
+	secondary.down = true
+	assert.Error(t, backend.PutDeadLetter(&tasks.DeadLetter{ID: "task_2"}))
+}
+
+func TestGroupSurvivesOutage(t *testing.T) {
+	t.Parallel()
+
+	primary, secondary := newFlakyBackend(), newFlakyBackend()
+	backend := failover.New(primary, secondary)
+	signatures := []*tasks.Signature{{UUID: "task_1"}, {UUID: "task_2"}}
+	assert.NoError(t, backend.InitGroup("group_1", []string{"task_1", "task_2"}))
+	assert.NoError(t, backend.SetStateSuccess(signatures[0], nil))
+
+	primary.down = true
+	assert.NoError(t, backend.SetStateSuccess(signatures[1], nil))
+	primary.down = false
+
+	completed, err := backend.GroupCompleted("group_1", 2)
+	assert.NoError(t, err)
+	assert.True(t, completed)
+
+	taskStates, err := backend.GroupTaskStates("group_1", 2)
+	if assert.NoError(t, err) && assert.Len(t, taskStates, 2) {
+		for _, taskState := range taskStates {
+			assert.Equal(t, tasks.StateSuccess, taskState.State)
+		}
+	}
+}
+
+func TestTriggerChordOnce(t *testing.T) {
+	t.Parallel()
+
+	primary, secondary := newFlakyBackend(), newFlakyBackend()
+	backend := failover.New(primary, secondary)
+	assert.NoError(t, backend.InitGroup("group_1", []string{"task_1"}))
+
+	// Triggered by the secondary backend while the primary one is down
+	primary.down = true
+	shouldTrigger, err := backend.TriggerChord("group_1")
+	assert.NoError(t, err)
+	assert.True(t, shouldTrigger)
+
+	// The primary backend doesn't know, the secondary one refuses
+	primary.down = false
+	shouldTrigger, err = backend.TriggerChord("group_1")
+	assert.NoError(t, err)
+	assert.False(t, shouldTrigger)
+
+	secondary.down = true
+	shouldTrigger, err = backend.TriggerChord("group_1")
+	assert.NoError(t, err)
+	assert.False(t, shouldTrigger)
+}
+
+func TestRevocationSurvivesOutage(t *testing.T) {
+	t.Parallel()
+
+	primary, secondary := newFlakyBackend(), newFlakyBackend()
+	backend := failover.New(primary, secondary).(iface.RevokeBackend)
+
+	// The primary backend misses the revocation while it is down
+	primary.down = true
+	assert.NoError(t, backend.RevokeTask("task_1"))
+	primary.down = false
+
+	revoked, err := backend.IsRevoked("task_1")
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	revoked, err = backend.IsRevoked("task_2")
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	primary.down, secondary.down = true, true
+	assert.Error(t, backend.RevokeTask("task_1"))
+	_, err = backend.IsRevoked("task_1")
+	assert.Error(t, err)
+}
+
+func TestIdempotencyKeyClaimedOnce(t *testing.T) {
+	t.Parallel()
+
+	primary, secondary := newFlakyBackend(), newFlakyBackend()
+	backend := failover.New(primary, secondary).(iface.IdempotencyBackend)
+
+	// Claimed on the secondary backend while the primary one is down
+	primary.down = true
+	owner, err := backend.ClaimIdempotencyKey(&tasks.Signature{UUID: "task_1", IdempotencyKey: "key"})
+	assert.NoError(t, err)
+	assert.Equal(t, "task_1", owner)
+
+	// The primary backend doesn't know, the secondary one refuses
+	primary.down = false
+	owner, err = backend.ClaimIdempotencyKey(&tasks.Signature{UUID: "task_2", IdempotencyKey: "key"})
+	assert.NoError(t, err)
+	assert.Equal(t, "task_1", owner)
+
+	owner, err = backend.IdempotencyKeyOwner("key")
+	assert.NoError(t, err)
+	assert.Equal(t, "task_1", owner)
+
+	assert.NoError(t, backend.ReleaseIdempotencyKey("key", "task_1"))
+	owner, err = backend.ClaimIdempotencyKey(&tasks.Signature{UUID: "task_3", IdempotencyKey: "key"})
+	assert.NoError(t, err)
+	assert.Equal(t, "task_3", owner)
+}
+
+func TestHeartbeatsFallBackToSecondary(t *testing.T) {
+	t.Parallel()
+
+	primary, secondary := newFlakyBackend(), newFlakyBackend()
+	backend := failover.New(primary, secondary).(iface.PresenceBackend)
+	assert.NoError(t, backend.ReportWorker("worker_1", &tasks.WorkerInfo{ID: "worker_1"}, time.Minute))
+
+	primary.down = true
+	workers, err := backend.Workers()
+	if assert.NoError(t, err) && assert.Len(t, workers, 1) {
+		assert.Equal(t, "worker_1", workers[0].ID)
+	}
+
+	secondary.down = true
+	_, err = backend.Workers()
+	assert.Error(t, err)
+}
+
+func TestControlMessagesFallBackToSecondary(t *testing.T) {
+	t.Parallel()
+
+	primary, secondary := newFlakyBackend(), newFlakyBackend()
+	backend := failover.New(primary, secondary).(iface.ControlBackend)
+
+	primary.down = true
+	assert.NoError(t, backend.PublishControl(&tasks.ControlMessage{Command: tasks.ControlPause}, time.Minute))
+	messages, err := backend.ControlMessages(0)
+	if assert.NoError(t, err) && assert.Len(t, messages, 1) {
+		assert.Equal(t, tasks.ControlPause, messages[0].Command)
+	}
+
+	secondary.down = true
+	assert.Error(t, backend.PublishControl(&tasks.ControlMessage{Command: tasks.ControlResume}, time.Minute))
+}
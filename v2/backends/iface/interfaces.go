@@ -1,6 +1,10 @@
 package iface
 
 import (
+	"context"
+	"time"
+
+	"github.com/RichardKnop/machinery/v2/log"
 	"github.com/RichardKnop/machinery/v2/tasks"
 )
 
@@ -26,3 +30,136 @@ type Backend interface {
 	PurgeState(taskUUID string) error
 	PurgeGroupMeta(groupUUID string) error
 }
+
+// BatchBackend is implemented by backends able to store the group meta data
+// and the pending states of all tasks of a group in a single batched call
+type BatchBackend interface {
+	InitGroupPending(groupUUID string, signatures []*tasks.Signature) error
+}
+
+// WatchBackend is implemented by backends able to push state changes to
+// callers waiting for a task to complete, instead of being polled
+type WatchBackend interface {
+	// WaitCompleted blocks until the task succeeds or fails and returns its
+	// final state. It returns an error when ctx is done or watching fails.
+	WaitCompleted(ctx context.Context, taskUUID string) (*tasks.TaskState, error)
+}
+
+// TimeoutBackend is implemented by backends able to store the TIMED_OUT state
+// of tasks which didn't complete before their group timed out
+type TimeoutBackend interface {
+	SetStateTimedOut(signature *tasks.Signature) error
+}
+
+// QueryBackend is implemented by backends able to list stored task states,
+// e.g. to find which tasks failed in the last hour
+type QueryBackend interface {
+	ListStates(filter *tasks.StateFilter) ([]*tasks.TaskState, error)
+}
+
+// InFlightBackend is implemented by backends able to sum the numbers of tasks
+// in flight which the workers of a fleet report with heartbeats
+type InFlightBackend interface {
+	// ReportInFlight stores the numbers of tasks in flight per task name of
+	// the worker until ttl passes, no counts remove the worker's report
+	ReportInFlight(workerID string, counts map[string]int, ttl time.Duration) error
+	// InFlight sums the reports which didn't expire per task name
+	InFlight() (map[string]int, error)
+}
+
+// PresenceBackend is implemented by backends able to keep the heartbeats of
+// the workers of a fleet, so operators can see which workers are alive
+type PresenceBackend interface {
+	// ReportWorker stores the heartbeat of the worker until ttl passes, a nil
+	// heartbeat removes the worker
+	ReportWorker(workerID string, worker *tasks.WorkerInfo, ttl time.Duration) error
+	// Workers returns the heartbeats which didn't expire, sorted by ID
+	Workers() ([]*tasks.WorkerInfo, error)
+}
+
+// ControlBackend is implemented by backends able to broadcast control
+// messages to the workers of a fleet and keep their replies
+type ControlBackend interface {
+	// PublishControl sets the ID of the message to the next one and keeps the
+	// message until ttl passes
+	PublishControl(message *tasks.ControlMessage, ttl time.Duration) error
+	// ControlMessages returns the messages whose ID is greater than afterID,
+	// oldest first
+	ControlMessages(afterID int64) ([]*tasks.ControlMessage, error)
+	// ReplyControl keeps the reply of a worker until ttl passes
+	ReplyControl(reply *tasks.ControlReply, ttl time.Duration) error
+	// ControlReplies returns the replies to the message, sorted by worker ID
+	ControlReplies(messageID int64) ([]*tasks.ControlReply, error)
+}
+
+// RevokeBackend is implemented by backends able to store which tasks were
+// revoked, so workers skip them or cancel them while running
+type RevokeBackend interface {
+	// RevokeTask marks the task revoked until results expire
+	RevokeTask(taskUUID string) error
+	// IsRevoked returns true if the task was revoked
+	IsRevoked(taskUUID string) (bool, error)
+}
+
+// ProgressBackend is implemented by backends able to store the progress a
+// running task reports along with its state
+type ProgressBackend interface {
+	SetProgress(signature *tasks.Signature, progress *tasks.Progress) error
+}
+
+// DiagnosticsBackend is implemented by backends able to store the
+// diagnostics of a failed task along with its state
+type DiagnosticsBackend interface {
+	SetDiagnostics(signature *tasks.Signature, diagnostics *tasks.Diagnostics) error
+}
+
+// IdempotencyBackend is implemented by backends able to deduplicate tasks
+// sent with the same idempotency key
+type IdempotencyBackend interface {
+	// ClaimIdempotencyKey stores the UUID of the task under its idempotency
+	// key unless another task owns the key, and returns the UUID of the task
+	// owning the key. Keys are owned until results expire.
+	ClaimIdempotencyKey(signature *tasks.Signature) (string, error)
+	// IdempotencyKeyOwner returns the UUID of the task owning the key, empty
+	// if no task owns it
+	IdempotencyKeyOwner(key string) (string, error)
+	// ReleaseIdempotencyKey removes the key if the task still owns it
+	ReleaseIdempotencyKey(key, taskUUID string) error
+}
+
+// ScheduleBackend is implemented by backends able to store when periodic
+// tasks and workflows last ran, so runs missed while no server was running
+// are caught up
+type ScheduleBackend interface {
+	// SetLastRun stores when the periodic task or workflow last ran, it is
+	// kept until it is replaced
+	SetLastRun(name string, lastRun time.Time) error
+	// LastRun returns when the periodic task or workflow last ran, the zero
+	// time if it didn't run yet
+	LastRun(name string) (time.Time, error)
+}
+
+// CodecBackend is implemented by backends able to store task states encoded
+// with another codec than the one of the configured wire format
+type CodecBackend interface {
+	SetCodec(codec tasks.Codec)
+}
+
+// LoggerBackend is implemented by backends able to log to another logger
+// than the package level loggers of the log package
+type LoggerBackend interface {
+	SetLogger(logger *log.Logger)
+}
+
+// DeadLetterBackend is implemented by backends able to keep dead letters, the
+// messages of tasks which failed for good, in a dead-letter queue
+type DeadLetterBackend interface {
+	// PutDeadLetter stores the dead letter, replacing one with the same ID.
+	// Dead letters are kept until they are deleted.
+	PutDeadLetter(deadLetter *tasks.DeadLetter) error
+	// ListDeadLetters returns the dead letters matching the filter, newest
+	// first
+	ListDeadLetters(filter *tasks.DeadLetterFilter) ([]*tasks.DeadLetter, error)
+	// DeleteDeadLetter removes the dead letter, e.g. once it was redriven
+	DeleteDeadLetter(id string) error
+}
@@ -8,7 +8,6 @@ import (
 	"github.com/RichardKnop/machinery/v2/backends/iface"
 	"github.com/RichardKnop/machinery/v2/common"
 	"github.com/RichardKnop/machinery/v2/config"
-	"github.com/RichardKnop/machinery/v2/log"
 	"github.com/RichardKnop/machinery/v2/tasks"
 
 	gomemcache "github.com/bradfitz/gomemcache/memcache"
@@ -43,9 +42,9 @@ func (b *Backend) InitGroup(groupUUID string, taskUUIDs []string) error {
 	}
 
 	return b.getClient().Set(&gomemcache.Item{
-		Key:        groupUUID,
+		Key:        b.GetConfig().Namespaced(groupUUID),
 		Value:      encoded,
-		Expiration: b.getExpirationTimestamp(),
+		Expiration: b.getGroupMetaExpirationTimestamp(),
 	})
 }
 
@@ -99,7 +98,7 @@ func (b *Backend) TriggerChord(groupUUID string) (bool, error) {
 	// If group meta is locked, wait until it's unlocked
 	for groupMeta.Lock {
 		groupMeta, _ = b.getGroupMeta(groupUUID)
-		log.WARNING.Print("Group meta locked, waiting")
+		b.Logger().WARNING.Print("Group meta locked, waiting")
 		time.Sleep(time.Millisecond * 5)
 	}
 
@@ -116,9 +115,9 @@ func (b *Backend) TriggerChord(groupUUID string) (bool, error) {
 		return false, err
 	}
 	if err = b.getClient().Replace(&gomemcache.Item{
-		Key:        groupUUID,
+		Key:        b.GetConfig().Namespaced(groupUUID),
 		Value:      encoded,
-		Expiration: b.getExpirationTimestamp(),
+		Expiration: b.getGroupMetaExpirationTimestamp(),
 	}); err != nil {
 		return false, err
 	}
@@ -129,50 +128,54 @@ func (b *Backend) TriggerChord(groupUUID string) (bool, error) {
 // SetStatePending updates task state to PENDING
 func (b *Backend) SetStatePending(signature *tasks.Signature) error {
 	taskState := tasks.NewPendingTaskState(signature)
-	return b.updateState(taskState)
+	return b.updateState(signature, taskState)
 }
 
 // SetStateReceived updates task state to RECEIVED
 func (b *Backend) SetStateReceived(signature *tasks.Signature) error {
 	taskState := tasks.NewReceivedTaskState(signature)
-	return b.updateState(taskState)
+	return b.updateState(signature, taskState)
 }
 
 // SetStateStarted updates task state to STARTED
 func (b *Backend) SetStateStarted(signature *tasks.Signature) error {
 	taskState := tasks.NewStartedTaskState(signature)
-	return b.updateState(taskState)
+	return b.updateState(signature, taskState)
 }
 
 // SetStateRetry updates task state to RETRY
 func (b *Backend) SetStateRetry(signature *tasks.Signature) error {
 	state := tasks.NewRetryTaskState(signature)
-	return b.updateState(state)
+	return b.updateState(signature, state)
 }
 
 // SetStateSuccess updates task state to SUCCESS
 func (b *Backend) SetStateSuccess(signature *tasks.Signature, results []*tasks.TaskResult) error {
 	taskState := tasks.NewSuccessTaskState(signature, results)
-	return b.updateState(taskState)
+	return b.updateState(signature, taskState)
 }
 
 // SetStateFailure updates task state to FAILURE
 func (b *Backend) SetStateFailure(signature *tasks.Signature, err string) error {
 	taskState := tasks.NewFailureTaskState(signature, err)
-	return b.updateState(taskState)
+	return b.updateState(signature, taskState)
+}
+
+// SetStateTimedOut updates task state to TIMED_OUT
+func (b *Backend) SetStateTimedOut(signature *tasks.Signature) error {
+	taskState := tasks.NewTimedOutTaskState(signature)
+	return b.updateState(signature, taskState)
 }
 
 // GetState returns the latest task state
 func (b *Backend) GetState(taskUUID string) (*tasks.TaskState, error) {
-	item, err := b.getClient().Get(taskUUID)
+	item, err := b.getClient().Get(b.GetConfig().Namespaced(taskUUID))
 	if err != nil {
 		return nil, err
 	}
 
-	state := new(tasks.TaskState)
-	decoder := json.NewDecoder(bytes.NewReader(item.Value))
-	decoder.UseNumber()
-	if err := decoder.Decode(state); err != nil {
+	state, err := b.DecodeTaskState(item.Value)
+	if err != nil {
 		return nil, err
 	}
 
@@ -181,25 +184,25 @@ func (b *Backend) GetState(taskUUID string) (*tasks.TaskState, error) {
 
 // PurgeState deletes stored task state
 func (b *Backend) PurgeState(taskUUID string) error {
-	return b.getClient().Delete(taskUUID)
+	return b.getClient().Delete(b.GetConfig().Namespaced(taskUUID))
 }
 
 // PurgeGroupMeta deletes stored group meta data
 func (b *Backend) PurgeGroupMeta(groupUUID string) error {
-	return b.getClient().Delete(groupUUID)
+	return b.getClient().Delete(b.GetConfig().Namespaced(groupUUID))
 }
 
 // updateState saves current task state
-func (b *Backend) updateState(taskState *tasks.TaskState) error {
-	encoded, err := json.Marshal(taskState)
+func (b *Backend) updateState(signature *tasks.Signature, taskState *tasks.TaskState) error {
+	encoded, err := b.EncodeTaskState(taskState)
 	if err != nil {
 		return err
 	}
 
 	return b.getClient().Set(&gomemcache.Item{
-		Key:        taskState.TaskUUID,
+		Key:        b.GetConfig().Namespaced(taskState.TaskUUID),
 		Value:      encoded,
-		Expiration: b.getExpirationTimestamp(),
+		Expiration: b.getExpirationTimestamp(signature),
 	})
 }
 
@@ -212,9 +215,9 @@ func (b *Backend) lockGroupMeta(groupMeta *tasks.GroupMeta) error {
 	}
 
 	return b.getClient().Set(&gomemcache.Item{
-		Key:        groupMeta.GroupUUID,
+		Key:        b.GetConfig().Namespaced(groupMeta.GroupUUID),
 		Value:      encoded,
-		Expiration: b.getExpirationTimestamp(),
+		Expiration: b.getGroupMetaExpirationTimestamp(),
 	})
 }
 
@@ -227,15 +230,15 @@ func (b *Backend) unlockGroupMeta(groupMeta *tasks.GroupMeta) error {
 	}
 
 	return b.getClient().Set(&gomemcache.Item{
-		Key:        groupMeta.GroupUUID,
+		Key:        b.GetConfig().Namespaced(groupMeta.GroupUUID),
 		Value:      encoded,
-		Expiration: b.getExpirationTimestamp(),
+		Expiration: b.getGroupMetaExpirationTimestamp(),
 	})
 }
 
 // getGroupMeta retrieves group meta data, convenience function to avoid repetition
 func (b *Backend) getGroupMeta(groupUUID string) (*tasks.GroupMeta, error) {
-	item, err := b.getClient().Get(groupUUID)
+	item, err := b.getClient().Get(b.GetConfig().Namespaced(groupUUID))
 	if err != nil {
 		return nil, err
 	}
@@ -255,15 +258,13 @@ func (b *Backend) getStates(taskUUIDs ...string) ([]*tasks.TaskState, error) {
 	states := make([]*tasks.TaskState, len(taskUUIDs))
 
 	for i, taskUUID := range taskUUIDs {
-		item, err := b.getClient().Get(taskUUID)
+		item, err := b.getClient().Get(b.GetConfig().Namespaced(taskUUID))
 		if err != nil {
 			return nil, err
 		}
 
-		state := new(tasks.TaskState)
-		decoder := json.NewDecoder(bytes.NewReader(item.Value))
-		decoder.UseNumber()
-		if err := decoder.Decode(state); err != nil {
+		state, err := b.DecodeTaskState(item.Value)
+		if err != nil {
 			return nil, err
 		}
 
@@ -274,12 +275,15 @@ func (b *Backend) getStates(taskUUIDs ...string) ([]*tasks.TaskState, error) {
 }
 
 // getExpirationTimestamp returns expiration timestamp
-func (b *Backend) getExpirationTimestamp() int32 {
-	expiresIn := b.GetConfig().ResultsExpireIn
-	if expiresIn == 0 {
-		// // expire results after 1 hour by default
-		expiresIn = config.DefaultResultsExpireIn
-	}
+func (b *Backend) getExpirationTimestamp(signature *tasks.Signature) int32 {
+	expiresIn := b.ResultsExpireIn(signature)
+	return int32(time.Now().Unix() + int64(expiresIn))
+}
+
+// getGroupMetaExpirationTimestamp returns expiration timestamp of group meta
+// data
+func (b *Backend) getGroupMetaExpirationTimestamp() int32 {
+	expiresIn := b.GroupMetaExpireIn()
 	return int32(time.Now().Unix() + int64(expiresIn))
 }
 
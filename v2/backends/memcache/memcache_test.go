@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/RichardKnop/machinery/v2/backends/iface"
 	"github.com/RichardKnop/machinery/v2/backends/memcache"
 	"github.com/RichardKnop/machinery/v2/config"
 	"github.com/RichardKnop/machinery/v2/tasks"
@@ -17,6 +18,19 @@ func TestGroupCompleted(t *testing.T) {
 		t.Skip("MEMCACHE_URL is not defined")
 	}
 
+	testGroupCompleted(t, memcache.New(new(config.Config), []string{memcacheURL}))
+}
+
+func TestGroupCompletedProtobuf(t *testing.T) {
+	memcacheURL := os.Getenv("MEMCACHE_URL")
+	if memcacheURL == "" {
+		t.Skip("MEMCACHE_URL is not defined")
+	}
+
+	testGroupCompleted(t, memcache.New(&config.Config{WireFormat: "protobuf"}, []string{memcacheURL}))
+}
+
+func testGroupCompleted(t *testing.T, backend iface.Backend) {
 	groupUUID := "testGroupUUID"
 	task1 := &tasks.Signature{
 		UUID:      "testTaskUUID1",
@@ -27,8 +41,6 @@ func TestGroupCompleted(t *testing.T) {
 		GroupUUID: groupUUID,
 	}
 
-	backend := memcache.New(new(config.Config), []string{memcacheURL})
-
 	// Cleanup before the test
 	backend.PurgeState(task1.UUID)
 	backend.PurgeState(task2.UUID)
@@ -68,6 +80,12 @@ func TestGroupCompleted(t *testing.T) {
 	if assert.NoError(t, err) {
 		assert.True(t, groupCompleted)
 	}
+
+	states, err := backend.GroupTaskStates(groupUUID, 2)
+	if assert.NoError(t, err) && assert.Len(t, states, 2) {
+		assert.Equal(t, tasks.StateFailure, states[0].State)
+		assert.Equal(t, tasks.StateSuccess, states[1].State)
+	}
 }
 
 func TestGetState(t *testing.T) {
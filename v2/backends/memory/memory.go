@@ -0,0 +1,723 @@
+package memory
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/RichardKnop/machinery/v2/backends/iface"
+	"github.com/RichardKnop/machinery/v2/common"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+// ErrGroupNotFound ...
+type ErrGroupNotFound struct {
+	groupUUID string
+}
+
+// NewErrGroupNotFound returns new instance of ErrGroupNotFound
+func NewErrGroupNotFound(groupUUID string) ErrGroupNotFound {
+	return ErrGroupNotFound{groupUUID: groupUUID}
+}
+
+// Error implements error interface
+func (e ErrGroupNotFound) Error() string {
+	return fmt.Sprintf("Group not found: %v", e.groupUUID)
+}
+
+// ErrTaskNotFound ...
+type ErrTaskNotFound struct {
+	taskUUID string
+}
+
+// NewErrTaskNotFound returns new instance of ErrTaskNotFound
+func NewErrTaskNotFound(taskUUID string) ErrTaskNotFound {
+	return ErrTaskNotFound{taskUUID: taskUUID}
+}
+
+// Error implements error interface
+func (e ErrTaskNotFound) Error() string {
+	return fmt.Sprintf("Task not found: %v", e.taskUUID)
+}
+
+type item struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func (i item) expired(now time.Time) bool {
+	return !i.expiresAt.IsZero() && now.After(i.expiresAt)
+}
+
+// controlItem is a stored control message
+type controlItem struct {
+	item
+	id int64
+}
+
+// Backend represents a thread-safe in-process result backend. Unlike the
+// eager backend it can be shared by any number of goroutines, so it works
+// with real workers running in the same binary. Task states and group meta
+// data expire after ResultsExpireIn seconds.
+type Backend struct {
+	common.Backend
+	mu     sync.RWMutex
+	groups map[string]item
+	tasks  map[string]item
+	// inFlight holds the encoded in-flight counts reported per worker
+	inFlight map[string]item
+	// workers holds the encoded heartbeats of workers
+	workers map[string]item
+	// controlMessages holds the encoded control messages, oldest first, and
+	// controlReplies the encoded replies per message and worker
+	controlMessages []controlItem
+	controlReplies  map[int64]map[string]item
+	lastControlID   int64
+	// revoked holds the UUIDs of revoked tasks
+	revoked map[string]item
+	// idempotencyKeys holds the UUIDs of the tasks owning the keys
+	idempotencyKeys map[string]item
+	// deadLetters holds the encoded dead letters, they never expire
+	deadLetters map[string][]byte
+	// lastRuns holds when periodic tasks last ran, they never expire
+	lastRuns map[string]time.Time
+}
+
+// New creates Backend instance
+func New(cnf *config.Config) iface.Backend {
+	if cnf == nil {
+		cnf = new(config.Config)
+	}
+
+	return &Backend{
+		Backend:  common.NewBackend(cnf),
+		groups:   make(map[string]item),
+		tasks:    make(map[string]item),
+		inFlight: make(map[string]item),
+		workers:  make(map[string]item),
+		revoked:  make(map[string]item),
+
+		idempotencyKeys: make(map[string]item),
+		controlReplies:  make(map[int64]map[string]item),
+		deadLetters:     make(map[string][]byte),
+		lastRuns:        make(map[string]time.Time),
+	}
+}
+
+// InitGroup creates and saves a group meta data object
+func (b *Backend) InitGroup(groupUUID string, taskUUIDs []string) error {
+	groupMeta := &tasks.GroupMeta{
+		GroupUUID: groupUUID,
+		TaskUUIDs: taskUUIDs,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	encoded, err := json.Marshal(groupMeta)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.groups[groupUUID] = b.newGroupItem(encoded)
+	return nil
+}
+
+// GroupCompleted returns true if all tasks in a group finished
+func (b *Backend) GroupCompleted(groupUUID string, groupTaskCount int) (bool, error) {
+	taskStates, err := b.GroupTaskStates(groupUUID, groupTaskCount)
+	if err != nil {
+		return false, err
+	}
+
+	var countSuccessTasks = 0
+	for _, taskState := range taskStates {
+		if taskState.IsCompleted() {
+			countSuccessTasks++
+		}
+	}
+
+	return countSuccessTasks == groupTaskCount, nil
+}
+
+// GroupTaskStates returns states of all tasks in the group
+func (b *Backend) GroupTaskStates(groupUUID string, groupTaskCount int) ([]*tasks.TaskState, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	groupMeta, err := b.getGroupMeta(groupUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	taskStates := make([]*tasks.TaskState, 0, len(groupMeta.TaskUUIDs))
+	for _, taskUUID := range groupMeta.TaskUUIDs {
+		taskState, err := b.getState(taskUUID)
+		if err != nil {
+			return nil, err
+		}
+		taskStates = append(taskStates, taskState)
+	}
+
+	return taskStates, nil
+}
+
+// TriggerChord flags chord as triggered in the backend storage to make sure
+// chord is never triggered multiple times. Returns a boolean flag to indicate
+// whether the worker should trigger chord (true) or no if it has been triggered
+// already (false)
+func (b *Backend) TriggerChord(groupUUID string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	groupMeta, err := b.getGroupMeta(groupUUID)
+	if err != nil {
+		return false, err
+	}
+
+	// Chord has already been triggered, return false (should not trigger again)
+	if groupMeta.ChordTriggered {
+		return false, nil
+	}
+
+	groupMeta.ChordTriggered = true
+
+	encoded, err := json.Marshal(groupMeta)
+	if err != nil {
+		return false, err
+	}
+
+	b.groups[groupUUID] = item{value: encoded, expiresAt: b.groups[groupUUID].expiresAt}
+	return true, nil
+}
+
+// SetStatePending updates task state to PENDING
+func (b *Backend) SetStatePending(signature *tasks.Signature) error {
+	taskState := tasks.NewPendingTaskState(signature)
+	return b.updateState(signature, taskState)
+}
+
+// SetStateReceived updates task state to RECEIVED
+func (b *Backend) SetStateReceived(signature *tasks.Signature) error {
+	taskState := tasks.NewReceivedTaskState(signature)
+	return b.updateState(signature, taskState)
+}
+
+// SetStateStarted updates task state to STARTED
+func (b *Backend) SetStateStarted(signature *tasks.Signature) error {
+	taskState := tasks.NewStartedTaskState(signature)
+	return b.updateState(signature, taskState)
+}
+
+// SetStateRetry updates task state to RETRY
+func (b *Backend) SetStateRetry(signature *tasks.Signature) error {
+	taskState := tasks.NewRetryTaskState(signature)
+	return b.updateState(signature, taskState)
+}
+
+// SetStateSuccess updates task state to SUCCESS
+func (b *Backend) SetStateSuccess(signature *tasks.Signature, results []*tasks.TaskResult) error {
+	taskState := tasks.NewSuccessTaskState(signature, results)
+	return b.updateState(signature, taskState)
+}
+
+// SetStateFailure updates task state to FAILURE
+func (b *Backend) SetStateFailure(signature *tasks.Signature, err string) error {
+	taskState := tasks.NewFailureTaskState(signature, err)
+	return b.updateState(signature, taskState)
+}
+
+// SetStateTimedOut updates task state to TIMED_OUT
+func (b *Backend) SetStateTimedOut(signature *tasks.Signature) error {
+	taskState := tasks.NewTimedOutTaskState(signature)
+	return b.updateState(signature, taskState)
+}
+
+// SetProgress stores the progress along with the latest task state
+func (b *Backend) SetProgress(signature *tasks.Signature, progress *tasks.Progress) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	taskState, err := b.getState(signature.UUID)
+	if err != nil {
+		return err
+	}
+	taskState.Progress = progress
+
+	encoded, err := b.EncodeTaskState(taskState)
+	if err != nil {
+		return fmt.Errorf("Marshal task state error: %v", err)
+	}
+
+	b.tasks[signature.UUID] = b.newItem(encoded, signature)
+	return nil
+}
+
+// SetDiagnostics stores the diagnostics along with the latest task state
+func (b *Backend) SetDiagnostics(signature *tasks.Signature, diagnostics *tasks.Diagnostics) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	taskState, err := b.getState(signature.UUID)
+	if err != nil {
+		return err
+	}
+	taskState.Diagnostics = diagnostics
+
+	encoded, err := b.EncodeTaskState(taskState)
+	if err != nil {
+		return fmt.Errorf("Marshal task state error: %v", err)
+	}
+
+	b.tasks[signature.UUID] = b.newItem(encoded, signature)
+	return nil
+}
+
+// GetState returns the latest task state
+func (b *Backend) GetState(taskUUID string) (*tasks.TaskState, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.getState(taskUUID)
+}
+
+// ListStates returns the stored task states matching the filter, newest
+// first
+func (b *Backend) ListStates(filter *tasks.StateFilter) ([]*tasks.TaskState, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	taskStates := make([]*tasks.TaskState, 0)
+	for taskUUID := range b.tasks {
+		taskState, err := b.getState(taskUUID)
+		if err != nil {
+			continue
+		}
+		if filter.Match(taskState) {
+			taskStates = append(taskStates, taskState)
+		}
+	}
+
+	return filter.Page(taskStates), nil
+}
+
+// PurgeState deletes stored task state
+func (b *Backend) PurgeState(taskUUID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.tasks[taskUUID]; !ok {
+		return NewErrTaskNotFound(taskUUID)
+	}
+
+	delete(b.tasks, taskUUID)
+	return nil
+}
+
+// PurgeGroupMeta deletes stored group meta data
+func (b *Backend) PurgeGroupMeta(groupUUID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.groups[groupUUID]; !ok {
+		return NewErrGroupNotFound(groupUUID)
+	}
+
+	delete(b.groups, groupUUID)
+	return nil
+}
+
+// ReportInFlight stores the numbers of tasks in flight of the worker
+func (b *Backend) ReportInFlight(workerID string, counts map[string]int, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(counts) == 0 {
+		delete(b.inFlight, workerID)
+		return nil
+	}
+
+	encoded, err := json.Marshal(counts)
+	if err != nil {
+		return err
+	}
+	b.inFlight[workerID] = item{value: encoded, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+// InFlight sums the numbers of tasks in flight of the workers whose reports
+// didn't expire
+func (b *Backend) InFlight() (map[string]int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	now := time.Now()
+	total := make(map[string]int)
+	for workerID, stored := range b.inFlight {
+		if stored.expired(now) {
+			continue
+		}
+		var counts map[string]int
+		if err := json.Unmarshal(stored.value, &counts); err != nil {
+			return nil, fmt.Errorf("Failed to unmarshal in-flight counts of worker %v: %v", workerID, err)
+		}
+		for name, count := range counts {
+			total[name] += count
+		}
+	}
+	return total, nil
+}
+
+// ReportWorker stores the heartbeat of the worker
+func (b *Backend) ReportWorker(workerID string, worker *tasks.WorkerInfo, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if worker == nil {
+		delete(b.workers, workerID)
+		return nil
+	}
+
+	encoded, err := json.Marshal(worker)
+	if err != nil {
+		return err
+	}
+	b.workers[workerID] = item{value: encoded, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+// Workers returns the heartbeats of the workers which didn't expire
+func (b *Backend) Workers() ([]*tasks.WorkerInfo, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	now := time.Now()
+	workers := make([]*tasks.WorkerInfo, 0, len(b.workers))
+	for workerID, stored := range b.workers {
+		if stored.expired(now) {
+			continue
+		}
+		worker := new(tasks.WorkerInfo)
+		if err := json.Unmarshal(stored.value, worker); err != nil {
+			return nil, fmt.Errorf("Failed to unmarshal heartbeat of worker %v: %v", workerID, err)
+		}
+		workers = append(workers, worker)
+	}
+	sort.Slice(workers, func(i, j int) bool { return workers[i].ID < workers[j].ID })
+	return workers, nil
+}
+
+// PublishControl stores the control message with the next ID, expired
+// messages and their replies are removed
+func (b *Backend) PublishControl(message *tasks.ControlMessage, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	for len(b.controlMessages) > 0 && b.controlMessages[0].expired(now) {
+		delete(b.controlReplies, b.controlMessages[0].id)
+		b.controlMessages = b.controlMessages[1:]
+	}
+
+	message.ID = b.lastControlID + 1
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	b.lastControlID = message.ID
+	b.controlMessages = append(b.controlMessages, controlItem{
+		item: item{value: encoded, expiresAt: now.Add(ttl)},
+		id:   message.ID,
+	})
+	return nil
+}
+
+// ControlMessages returns the control messages which didn't expire and
+// whose ID is greater than afterID
+func (b *Backend) ControlMessages(afterID int64) ([]*tasks.ControlMessage, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	now := time.Now()
+	var messages []*tasks.ControlMessage
+	for _, stored := range b.controlMessages {
+		if stored.id <= afterID || stored.expired(now) {
+			continue
+		}
+		message := new(tasks.ControlMessage)
+		if err := json.Unmarshal(stored.value, message); err != nil {
+			return nil, fmt.Errorf("Failed to unmarshal control message %v: %v", stored.id, err)
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// ReplyControl stores the reply of the worker to the control message
+func (b *Backend) ReplyControl(reply *tasks.ControlReply, ttl time.Duration) error {
+	if reply.Worker == nil {
+		return errors.New("Control reply has no worker")
+	}
+	encoded, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	replies, ok := b.controlReplies[reply.MessageID]
+	if !ok {
+		replies = make(map[string]item)
+		b.controlReplies[reply.MessageID] = replies
+	}
+	replies[reply.Worker.ID] = item{value: encoded, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+// ControlReplies returns the replies to the control message which didn't
+// expire
+func (b *Backend) ControlReplies(messageID int64) ([]*tasks.ControlReply, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	now := time.Now()
+	replies := make([]*tasks.ControlReply, 0, len(b.controlReplies[messageID]))
+	for workerID, stored := range b.controlReplies[messageID] {
+		if stored.expired(now) {
+			continue
+		}
+		reply := new(tasks.ControlReply)
+		if err := json.Unmarshal(stored.value, reply); err != nil {
+			return nil, fmt.Errorf("Failed to unmarshal control reply of worker %v: %v", workerID, err)
+		}
+		replies = append(replies, reply)
+	}
+	sort.Slice(replies, func(i, j int) bool { return replies[i].Worker.ID < replies[j].Worker.ID })
+	return replies, nil
+}
+
+// RevokeTask marks the task revoked until results expire
+func (b *Backend) RevokeTask(taskUUID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.revoked[taskUUID] = b.newItem(nil, nil)
+	return nil
+}
+
+// IsRevoked returns true if the task was revoked
+func (b *Backend) IsRevoked(taskUUID string) (bool, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	stored, ok := b.revoked[taskUUID]
+	return ok && !stored.expired(time.Now()), nil
+}
+
+// ClaimIdempotencyKey stores the UUID of the task under its idempotency key
+// unless another task owns the key, and returns the UUID of the owner
+func (b *Backend) ClaimIdempotencyKey(signature *tasks.Signature) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if stored, ok := b.idempotencyKeys[signature.IdempotencyKey]; ok && !stored.expired(time.Now()) {
+		return string(stored.value), nil
+	}
+	b.idempotencyKeys[signature.IdempotencyKey] = b.newItem([]byte(signature.UUID), signature)
+	return signature.UUID, nil
+}
+
+// IdempotencyKeyOwner returns the UUID of the task owning the key
+func (b *Backend) IdempotencyKeyOwner(key string) (string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	stored, ok := b.idempotencyKeys[key]
+	if !ok || stored.expired(time.Now()) {
+		return "", nil
+	}
+	return string(stored.value), nil
+}
+
+// ReleaseIdempotencyKey removes the key if the task still owns it
+func (b *Backend) ReleaseIdempotencyKey(key, taskUUID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if stored, ok := b.idempotencyKeys[key]; ok && string(stored.value) == taskUUID {
+		delete(b.idempotencyKeys, key)
+	}
+	return nil
+}
+
+// PutDeadLetter stores the dead letter, replacing one with the same ID
+func (b *Backend) PutDeadLetter(deadLetter *tasks.DeadLetter) error {
+	encoded, err := json.Marshal(deadLetter)
+	if err != nil {
+		return fmt.Errorf("Marshal dead letter error: %v", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.deadLetters[deadLetter.ID] = encoded
+	return nil
+}
+
+// ListDeadLetters returns the dead letters matching the filter, newest first
+func (b *Backend) ListDeadLetters(filter *tasks.DeadLetterFilter) ([]*tasks.DeadLetter, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	deadLetters := make([]*tasks.DeadLetter, 0)
+	for id, encoded := range b.deadLetters {
+		deadLetter := new(tasks.DeadLetter)
+		if err := json.Unmarshal(encoded, deadLetter); err != nil {
+			return nil, fmt.Errorf("Failed to unmarshal dead letter %v: %v", id, err)
+		}
+		if filter.Match(deadLetter) {
+			deadLetters = append(deadLetters, deadLetter)
+		}
+	}
+
+	return filter.Page(deadLetters), nil
+}
+
+// DeleteDeadLetter removes the dead letter
+func (b *Backend) DeleteDeadLetter(id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.deadLetters, id)
+	return nil
+}
+
+// SetLastRun stores when the periodic task or workflow last ran
+func (b *Backend) SetLastRun(name string, lastRun time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastRuns[name] = lastRun
+	return nil
+}
+
+// LastRun returns when the periodic task or workflow last ran
+func (b *Backend) LastRun(name string) (time.Time, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.lastRuns[name], nil
+}
+
+// PurgeExpired deletes all expired task states and group meta data. Expired
+// entries are never returned, this only releases their memory, so long
+// running processes should call it periodically.
+func (b *Backend) PurgeExpired() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	for key, value := range b.tasks {
+		if value.expired(now) {
+			delete(b.tasks, key)
+		}
+	}
+	for key, value := range b.groups {
+		if value.expired(now) {
+			delete(b.groups, key)
+		}
+	}
+	for key, value := range b.inFlight {
+		if value.expired(now) {
+			delete(b.inFlight, key)
+		}
+	}
+	for key, value := range b.revoked {
+		if value.expired(now) {
+			delete(b.revoked, key)
+		}
+	}
+	for key, value := range b.idempotencyKeys {
+		if value.expired(now) {
+			delete(b.idempotencyKeys, key)
+		}
+	}
+}
+
+// getGroupMeta must be called with the mutex held
+func (b *Backend) getGroupMeta(groupUUID string) (*tasks.GroupMeta, error) {
+	stored, ok := b.groups[groupUUID]
+	if !ok || stored.expired(time.Now()) {
+		return nil, NewErrGroupNotFound(groupUUID)
+	}
+
+	groupMeta := new(tasks.GroupMeta)
+	decoder := json.NewDecoder(bytes.NewReader(stored.value))
+	decoder.UseNumber()
+	if err := decoder.Decode(groupMeta); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal group meta %v: %v", groupUUID, err)
+	}
+
+	return groupMeta, nil
+}
+
+// getState must be called with the mutex held
+func (b *Backend) getState(taskUUID string) (*tasks.TaskState, error) {
+	stored, ok := b.tasks[taskUUID]
+	if !ok || stored.expired(time.Now()) {
+		return nil, NewErrTaskNotFound(taskUUID)
+	}
+
+	// Task states are stored encoded so callers never share (and mutate)
+	// the stored value, results also decode the same way as in real backends
+	state, err := b.DecodeTaskState(stored.value)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal task state %v: %v", taskUUID, err)
+	}
+
+	return state, nil
+}
+
+// updateState saves current task state, keeping the task name and creation
+// time of the previous state
+func (b *Backend) updateState(signature *tasks.Signature, taskState *tasks.TaskState) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if previous, err := b.getState(taskState.TaskUUID); err == nil && taskState.State != tasks.StatePending {
+		taskState.CreatedAt = previous.CreatedAt
+		taskState.TaskName = previous.TaskName
+	}
+
+	encoded, err := b.EncodeTaskState(taskState)
+	if err != nil {
+		return fmt.Errorf("Marshal task state error: %v", err)
+	}
+
+	b.tasks[taskState.TaskUUID] = b.newItem(encoded, signature)
+	return nil
+}
+
+// newItem wraps the value with expiration based on ResultsExpireIn
+func (b *Backend) newItem(value []byte, signature *tasks.Signature) item {
+	expiresIn := b.ResultsExpireIn(signature)
+	return item{
+		value:     value,
+		expiresAt: time.Now().Add(time.Duration(expiresIn) * time.Second),
+	}
+}
+
+// newGroupItem wraps the group meta data with expiration based on
+// GroupMetaExpireIn
+func (b *Backend) newGroupItem(value []byte) item {
+	expiresIn := b.GroupMetaExpireIn()
+	return item{
+		value:     value,
+		expiresAt: time.Now().Add(time.Duration(expiresIn) * time.Second),
+	}
+}
@@ -0,0 +1,408 @@
+package memory_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/backends/iface"
+	"github.com/RichardKnop/machinery/v2/backends/memory"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestGroupCompleted(t *testing.T) {
+	t.Parallel()
+
+	backend := memory.New(nil)
+	task1 := &tasks.Signature{UUID: "task_1", GroupUUID: "group_1"}
+	task2 := &tasks.Signature{UUID: "task_2", GroupUUID: "group_1"}
+
+	_, err := backend.GroupCompleted("group_1", 2)
+	assert.Equal(t, memory.NewErrGroupNotFound("group_1"), err)
+
+	assert.NoError(t, backend.InitGroup("group_1", []string{task1.UUID, task2.UUID}))
+	assert.NoError(t, backend.SetStatePending(task1))
+	assert.NoError(t, backend.SetStatePending(task2))
+
+	completed, err := backend.GroupCompleted("group_1", 2)
+	assert.NoError(t, err)
+	assert.False(t, completed)
+
+	assert.NoError(t, backend.SetStateSuccess(task1, []*tasks.TaskResult{{Type: "int64", Value: 2}}))
+	assert.NoError(t, backend.SetStateFailure(task2, "some error"))
+
+	completed, err = backend.GroupCompleted("group_1", 2)
+	assert.NoError(t, err)
+	assert.True(t, completed)
+
+	states, err := backend.GroupTaskStates("group_1", 2)
+	if assert.NoError(t, err) && assert.Len(t, states, 2) {
+		assert.True(t, states[0].IsSuccess())
+		assert.True(t, states[1].IsFailure())
+		assert.Equal(t, "some error", states[1].Error)
+	}
+}
+
+func TestTriggerChord(t *testing.T) {
+	t.Parallel()
+
+	backend := memory.New(nil)
+	assert.NoError(t, backend.InitGroup("group_1", []string{"task_1"}))
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		triggered int
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			shouldTrigger, err := backend.TriggerChord("group_1")
+			assert.NoError(t, err)
+			if shouldTrigger {
+				mu.Lock()
+				triggered++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, triggered)
+}
+
+func TestStateKeepsNameAndCreatedAt(t *testing.T) {
+	t.Parallel()
+
+	backend := memory.New(nil)
+	signature := &tasks.Signature{UUID: "task_1", Name: "add"}
+
+	assert.NoError(t, backend.SetStatePending(signature))
+	pending, err := backend.GetState(signature.UUID)
+	assert.NoError(t, err)
+
+	assert.NoError(t, backend.SetStateStarted(signature))
+	started, err := backend.GetState(signature.UUID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, tasks.StateStarted, started.State)
+		assert.Equal(t, "add", started.TaskName)
+		assert.Equal(t, pending.CreatedAt, started.CreatedAt)
+	}
+}
+
+func TestListStates(t *testing.T) {
+	t.Parallel()
+
+	backend := memory.New(nil)
+	query := backend.(iface.QueryBackend)
+
+	since := time.Now()
+	for i, name := range []string{"add", "add", "multiply"} {
+		signature := &tasks.Signature{UUID: fmt.Sprintf("task_%d", i), Name: name}
+		assert.NoError(t, backend.SetStatePending(signature))
+		time.Sleep(time.Millisecond)
+	}
+	assert.NoError(t, backend.SetStateFailure(&tasks.Signature{UUID: "task_0"}, "boom"))
+	assert.NoError(t, backend.SetStateFailure(&tasks.Signature{UUID: "task_2"}, "boom"))
+
+	taskStates, err := query.ListStates(&tasks.StateFilter{States: []string{tasks.StateFailure}, CreatedAfter: since})
+	if assert.NoError(t, err) && assert.Len(t, taskStates, 2) {
+		assert.Equal(t, "task_2", taskStates[0].TaskUUID)
+		assert.Equal(t, "task_0", taskStates[1].TaskUUID)
+		assert.Equal(t, "add", taskStates[1].TaskName)
+	}
+
+	taskStates, err = query.ListStates(&tasks.StateFilter{TaskName: "add", Offset: 1, Limit: 1})
+	if assert.NoError(t, err) && assert.Len(t, taskStates, 1) {
+		assert.Equal(t, "task_0", taskStates[0].TaskUUID)
+	}
+
+	taskStates, err = query.ListStates(&tasks.StateFilter{CreatedBefore: since})
+	assert.NoError(t, err)
+	assert.Empty(t, taskStates)
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	t.Parallel()
+
+	backend := memory.New(nil)
+	taskUUIDs := make([]string, 100)
+	for i := range taskUUIDs {
+		taskUUIDs[i] = fmt.Sprintf("task_%d", i)
+	}
+	assert.NoError(t, backend.InitGroup("group_1", taskUUIDs))
+
+	var wg sync.WaitGroup
+	for _, taskUUID := range taskUUIDs {
+		wg.Add(1)
+		go func(signature *tasks.Signature) {
+			defer wg.Done()
+			assert.NoError(t, backend.SetStatePending(signature))
+			assert.NoError(t, backend.SetStateStarted(signature))
+			assert.NoError(t, backend.SetStateSuccess(signature, nil))
+			backend.GroupCompleted("group_1", len(taskUUIDs))
+		}(&tasks.Signature{UUID: taskUUID, GroupUUID: "group_1"})
+	}
+	wg.Wait()
+
+	completed, err := backend.GroupCompleted("group_1", len(taskUUIDs))
+	assert.NoError(t, err)
+	assert.True(t, completed)
+}
+
+func TestExpiration(t *testing.T) {
+	t.Parallel()
+
+	backend := memory.New(&config.Config{ResultsExpireIn: 1})
+	signature := &tasks.Signature{UUID: "task_1"}
+
+	assert.NoError(t, backend.SetStatePending(signature))
+	assert.NoError(t, backend.InitGroup("group_1", []string{signature.UUID}))
+
+	time.Sleep(1100 * time.Millisecond)
+
+	_, err := backend.GetState(signature.UUID)
+	assert.Equal(t, memory.NewErrTaskNotFound(signature.UUID), err)
+	_, err = backend.GroupTaskStates("group_1", 1)
+	assert.Equal(t, memory.NewErrGroupNotFound("group_1"), err)
+
+	backend.(*memory.Backend).PurgeExpired()
+	assert.Error(t, backend.PurgeState(signature.UUID))
+}
+
+func TestGroupMetaExpiration(t *testing.T) {
+	t.Parallel()
+
+	backend := memory.New(&config.Config{ResultsExpireIn: 1, GroupMetaExpireIn: 3600})
+	single := &tasks.Signature{UUID: "task_1"}
+	grouped := &tasks.Signature{UUID: "task_2", GroupUUID: "group_1"}
+
+	assert.NoError(t, backend.SetStateSuccess(single, nil))
+	assert.NoError(t, backend.SetStateSuccess(grouped, nil))
+	assert.NoError(t, backend.InitGroup("group_1", []string{grouped.UUID}))
+
+	time.Sleep(1100 * time.Millisecond)
+
+	_, err := backend.GetState(single.UUID)
+	assert.Equal(t, memory.NewErrTaskNotFound(single.UUID), err)
+	completed, err := backend.GroupCompleted("group_1", 1)
+	assert.NoError(t, err)
+	assert.True(t, completed)
+}
+
+func TestSignatureExpiration(t *testing.T) {
+	t.Parallel()
+
+	backend := memory.New(nil)
+	shortLived := &tasks.Signature{UUID: "task_1", ResultsExpireIn: 1}
+	longLived := &tasks.Signature{UUID: "task_2"}
+
+	assert.NoError(t, backend.SetStateSuccess(shortLived, nil))
+	assert.NoError(t, backend.SetStateSuccess(longLived, nil))
+
+	time.Sleep(1100 * time.Millisecond)
+
+	_, err := backend.GetState(shortLived.UUID)
+	assert.Equal(t, memory.NewErrTaskNotFound(shortLived.UUID), err)
+	_, err = backend.GetState(longLived.UUID)
+	assert.NoError(t, err)
+}
+
+func TestInFlight(t *testing.T) {
+	t.Parallel()
+
+	backend := memory.New(nil).(iface.InFlightBackend)
+	assert.NoError(t, backend.ReportInFlight("worker_1", map[string]int{"add": 2, "mul": 1}, time.Minute))
+	assert.NoError(t, backend.ReportInFlight("worker_2", map[string]int{"add": 3}, time.Minute))
+	assert.NoError(t, backend.ReportInFlight("worker_3", map[string]int{"add": 5}, time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+
+	// The report of worker_3 expired
+	inFlight, err := backend.InFlight()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"add": 5, "mul": 1}, inFlight)
+
+	assert.NoError(t, backend.ReportInFlight("worker_1", nil, time.Minute))
+	inFlight, err = backend.InFlight()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"add": 3}, inFlight)
+}
+
+func TestWorkers(t *testing.T) {
+	t.Parallel()
+
+	backend := memory.New(nil).(iface.PresenceBackend)
+	assert.NoError(t, backend.ReportWorker("worker_2", &tasks.WorkerInfo{ID: "worker_2", Concurrency: 2}, time.Minute))
+	assert.NoError(t, backend.ReportWorker("worker_1", &tasks.WorkerInfo{ID: "worker_1", Concurrency: 1}, time.Minute))
+	assert.NoError(t, backend.ReportWorker("worker_3", &tasks.WorkerInfo{ID: "worker_3"}, time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+
+	// The heartbeat of worker_3 expired
+	workers, err := backend.Workers()
+	assert.NoError(t, err)
+	assert.Equal(t, []*tasks.WorkerInfo{{ID: "worker_1", Concurrency: 1}, {ID: "worker_2", Concurrency: 2}}, workers)
+
+	assert.NoError(t, backend.ReportWorker("worker_1", nil, time.Minute))
+	workers, err = backend.Workers()
+	assert.NoError(t, err)
+	assert.Equal(t, []*tasks.WorkerInfo{{ID: "worker_2", Concurrency: 2}}, workers)
+}
+
+func TestControl(t *testing.T) {
+	t.Parallel()
+
+	backend := memory.New(nil).(iface.ControlBackend)
+	messages, err := backend.ControlMessages(0)
+	assert.NoError(t, err)
+	var lastID int64
+	if len(messages) > 0 {
+		lastID = messages[len(messages)-1].ID
+	}
+
+	pause := &tasks.ControlMessage{Command: tasks.ControlPause, Destination: []string{"worker_1"}}
+	stats := &tasks.ControlMessage{Command: tasks.ControlStats}
+	assert.NoError(t, backend.PublishControl(pause, time.Minute))
+	assert.NoError(t, backend.PublishControl(stats, time.Minute))
+	assert.Equal(t, pause.ID+1, stats.ID)
+
+	messages, err = backend.ControlMessages(lastID)
+	assert.NoError(t, err)
+	assert.Equal(t, []*tasks.ControlMessage{pause, stats}, messages)
+	messages, err = backend.ControlMessages(pause.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []*tasks.ControlMessage{stats}, messages)
+
+	assert.Error(t, backend.ReplyControl(&tasks.ControlReply{MessageID: stats.ID}, time.Minute))
+	reply1 := &tasks.ControlReply{MessageID: stats.ID, Worker: &tasks.WorkerInfo{ID: "worker_1", Paused: true}}
+	reply2 := &tasks.ControlReply{MessageID: stats.ID, Worker: &tasks.WorkerInfo{ID: "worker_2"}, Error: "failed"}
+	assert.NoError(t, backend.ReplyControl(reply2, time.Minute))
+	assert.NoError(t, backend.ReplyControl(reply1, time.Minute))
+	replies, err := backend.ControlReplies(stats.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []*tasks.ControlReply{reply1, reply2}, replies)
+
+	replies, err = backend.ControlReplies(pause.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, replies)
+}
+
+func TestRevokeTask(t *testing.T) {
+	t.Parallel()
+
+	backend := memory.New(nil).(iface.RevokeBackend)
+	revoked, err := backend.IsRevoked("task_1")
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	assert.NoError(t, backend.RevokeTask("task_1"))
+	revoked, err = backend.IsRevoked("task_1")
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+}
+
+func TestSetProgress(t *testing.T) {
+	t.Parallel()
+
+	backend := memory.New(nil)
+	signature := &tasks.Signature{UUID: "task_1", Name: "import"}
+	progress := &tasks.Progress{Percent: 30, Message: "importing"}
+	assert.Error(t, backend.(iface.ProgressBackend).SetProgress(signature, progress))
+
+	assert.NoError(t, backend.SetStateStarted(signature))
+	assert.NoError(t, backend.(iface.ProgressBackend).SetProgress(signature, progress))
+	taskState, err := backend.GetState(signature.UUID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, tasks.StateStarted, taskState.State)
+		assert.Equal(t, progress, taskState.Progress)
+	}
+
+	// Progress is of running tasks
+	assert.NoError(t, backend.SetStateSuccess(signature, nil))
+	taskState, err = backend.GetState(signature.UUID)
+	if assert.NoError(t, err) {
+		assert.Nil(t, taskState.Progress)
+	}
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	t.Parallel()
+
+	backend := memory.New(nil).(iface.IdempotencyBackend)
+	first := &tasks.Signature{UUID: "task_1", IdempotencyKey: "welcome_email_42"}
+	second := &tasks.Signature{UUID: "task_2", IdempotencyKey: "welcome_email_42"}
+
+	owner, err := backend.ClaimIdempotencyKey(first)
+	assert.NoError(t, err)
+	assert.Equal(t, "task_1", owner)
+	owner, err = backend.ClaimIdempotencyKey(second)
+	assert.NoError(t, err)
+	assert.Equal(t, "task_1", owner)
+
+	// Only the owner releases the key
+	assert.NoError(t, backend.ReleaseIdempotencyKey("welcome_email_42", "task_2"))
+	owner, err = backend.IdempotencyKeyOwner("welcome_email_42")
+	assert.NoError(t, err)
+	assert.Equal(t, "task_1", owner)
+
+	assert.NoError(t, backend.ReleaseIdempotencyKey("welcome_email_42", "task_1"))
+	owner, err = backend.IdempotencyKeyOwner("welcome_email_42")
+	assert.NoError(t, err)
+	assert.Empty(t, owner)
+}
+
+func TestDeadLetters(t *testing.T) {
+	t.Parallel()
+
+	backend := memory.New(nil).(iface.DeadLetterBackend)
+	now := time.Now().UTC()
+	exhausted := &tasks.DeadLetter{
+		ID:       "task_1",
+		TaskName: "add",
+		Reason:   tasks.DeadLetterExhausted,
+		Error:    "boom",
+		FailedAt: now.Add(-time.Minute),
+		Message:  []byte(`{"UUID":"task_1","Name":"add"}`),
+	}
+	undecodable := &tasks.DeadLetter{
+		ID:       "message_1",
+		Reason:   tasks.DeadLetterUndecodable,
+		FailedAt: now,
+		Message:  []byte("garbage"),
+	}
+	assert.NoError(t, backend.PutDeadLetter(exhausted))
+	assert.NoError(t, backend.PutDeadLetter(undecodable))
+
+	deadLetters, err := backend.ListDeadLetters(new(tasks.DeadLetterFilter))
+	assert.NoError(t, err)
+	assert.Equal(t, []*tasks.DeadLetter{undecodable, exhausted}, deadLetters)
+
+	deadLetters, err = backend.ListDeadLetters(&tasks.DeadLetterFilter{TaskName: "add"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*tasks.DeadLetter{exhausted}, deadLetters)
+
+	assert.NoError(t, backend.DeleteDeadLetter("task_1"))
+	deadLetters, err = backend.ListDeadLetters(new(tasks.DeadLetterFilter))
+	assert.NoError(t, err)
+	assert.Equal(t, []*tasks.DeadLetter{undecodable}, deadLetters)
+}
+
+func TestLastRuns(t *testing.T) {
+	t.Parallel()
+
+	backend := memory.New(nil).(iface.ScheduleBackend)
+
+	lastRun, err := backend.LastRun("nightly")
+	assert.NoError(t, err)
+	assert.True(t, lastRun.IsZero())
+
+	now := time.Now()
+	assert.NoError(t, backend.SetLastRun("nightly", now))
+	lastRun, err = backend.LastRun("nightly")
+	assert.NoError(t, err)
+	assert.True(t, now.Equal(lastRun))
+}
@@ -1,3 +1,6 @@
+//go:build !machinery_no_mongodb
+// +build !machinery_no_mongodb
+
 package mongo
 
 import (
@@ -16,17 +19,18 @@ import (
 	"github.com/RichardKnop/machinery/v2/backends/iface"
 	"github.com/RichardKnop/machinery/v2/common"
 	"github.com/RichardKnop/machinery/v2/config"
-	"github.com/RichardKnop/machinery/v2/log"
 	"github.com/RichardKnop/machinery/v2/tasks"
 )
 
 // Backend represents a MongoDB result backend
 type Backend struct {
 	common.Backend
-	client *mongo.Client
-	tc     *mongo.Collection
-	gmc    *mongo.Collection
-	once   sync.Once
+	client  *mongo.Client
+	tc      *mongo.Collection
+	gmc     *mongo.Collection
+	dlc     *mongo.Collection
+	once    sync.Once
+	watcher watcher
 }
 
 // New creates Backend instance
@@ -50,6 +54,35 @@ func (b *Backend) InitGroup(groupUUID string, taskUUIDs []string) error {
 	return err
 }
 
+// InitGroupPending saves UUIDs of all tasks in a group and upserts their
+// pending states with a single bulk write
+func (b *Backend) InitGroupPending(groupUUID string, signatures []*tasks.Signature) error {
+	taskUUIDs := make([]string, len(signatures))
+	models := make([]mongo.WriteModel, len(signatures))
+	for i, signature := range signatures {
+		taskUUIDs[i] = signature.UUID
+		models[i] = mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": signature.UUID}).
+			SetUpdate(bson.M{"$set": bson.M{
+				"state":      tasks.StatePending,
+				"task_name":  signature.Name,
+				"created_at": time.Now().UTC(),
+			}}).
+			SetUpsert(true)
+	}
+
+	if err := b.InitGroup(groupUUID, taskUUIDs); err != nil {
+		return err
+	}
+
+	if len(models) == 0 {
+		return nil
+	}
+
+	_, err := b.tasksCollection().BulkWrite(context.Background(), models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
 // GroupCompleted returns true if all tasks in a group finished
 func (b *Backend) GroupCompleted(groupUUID string, groupTaskCount int) (bool, error) {
 	groupMeta, err := b.getGroupMeta(groupUUID)
@@ -101,7 +134,7 @@ func (b *Backend) TriggerChord(groupUUID string) (bool, error) {
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			log.WARNING.Printf("Chord already triggered for group %s", groupUUID)
+			b.Logger().WARNING.Printf("Chord already triggered for group %s", groupUUID)
 			return false, nil
 		}
 		return false, err
@@ -116,36 +149,36 @@ func (b *Backend) SetStatePending(signature *tasks.Signature) error {
 		"task_name":  signature.Name,
 		"created_at": time.Now().UTC(),
 	}
-	return b.updateState(signature, update)
+	return b.updateState(signature, withTiming(update, tasks.NewPendingTaskState(signature)))
 }
 
 // SetStateReceived updates task state to RECEIVED
 func (b *Backend) SetStateReceived(signature *tasks.Signature) error {
 	update := bson.M{"state": tasks.StateReceived}
-	return b.updateState(signature, update)
+	return b.updateState(signature, withTiming(update, tasks.NewReceivedTaskState(signature)))
 }
 
 // SetStateStarted updates task state to STARTED
 func (b *Backend) SetStateStarted(signature *tasks.Signature) error {
 	update := bson.M{"state": tasks.StateStarted}
-	return b.updateState(signature, update)
+	return b.updateState(signature, withTiming(update, tasks.NewStartedTaskState(signature)))
 }
 
 // SetStateRetry updates task state to RETRY
 func (b *Backend) SetStateRetry(signature *tasks.Signature) error {
 	update := bson.M{"state": tasks.StateRetry}
-	return b.updateState(signature, update)
+	return b.updateState(signature, withTiming(update, tasks.NewRetryTaskState(signature)))
 }
 
 // SetStateSuccess updates task state to SUCCESS
 func (b *Backend) SetStateSuccess(signature *tasks.Signature, results []*tasks.TaskResult) error {
 	decodedResults := b.decodeResults(results)
 	update := bson.M{
-		"state":   tasks.StateSuccess,
-		"results": decodedResults,
-		"delete_at":     time.Now().Add(time.Duration(b.GetConfig().ResultsExpireIn) * time.Second),
+		"state":     tasks.StateSuccess,
+		"results":   decodedResults,
+		"delete_at": time.Now().Add(time.Duration(b.ResultsExpireIn(signature)) * time.Second),
 	}
-	return b.updateState(signature, update)
+	return b.updateState(signature, withTiming(update, tasks.NewSuccessTaskState(signature, results)))
 }
 
 // decodeResults detects & decodes json strings in TaskResult.Value and returns a new slice
@@ -173,10 +206,16 @@ func (b *Backend) decodeResults(results []*tasks.TaskResult) []*tasks.TaskResult
 // SetStateFailure updates task state to FAILURE
 func (b *Backend) SetStateFailure(signature *tasks.Signature, err string) error {
 	update := bson.M{
-		"state": tasks.StateFailure,
-		"error": err,
-		"delete_at":   time.Now().Add(time.Duration(b.GetConfig().ResultsExpireIn) * time.Second),
+		"state":     tasks.StateFailure,
+		"error":     err,
+		"delete_at": time.Now().Add(time.Duration(b.ResultsExpireIn(signature)) * time.Second),
 	}
+	return b.updateState(signature, withTiming(update, tasks.NewFailureTaskState(signature, err)))
+}
+
+// SetDiagnostics stores the diagnostics along with the latest task state
+func (b *Backend) SetDiagnostics(signature *tasks.Signature, diagnostics *tasks.Diagnostics) error {
+	update := bson.M{"diagnostics": diagnostics}
 	return b.updateState(signature, update)
 }
 
@@ -191,6 +230,102 @@ func (b *Backend) GetState(taskUUID string) (*tasks.TaskState, error) {
 	return state, nil
 }
 
+// ListStates returns the stored task states matching the filter, newest
+// first
+func (b *Backend) ListStates(filter *tasks.StateFilter) ([]*tasks.TaskState, error) {
+	query := bson.M{}
+	if len(filter.States) > 0 {
+		query["state"] = bson.M{"$in": filter.States}
+	}
+	if filter.TaskName != "" {
+		query["task_name"] = filter.TaskName
+	}
+	createdAt := bson.M{}
+	if !filter.CreatedAfter.IsZero() {
+		createdAt["$gt"] = filter.CreatedAfter
+	}
+	if !filter.CreatedBefore.IsZero() {
+		createdAt["$lt"] = filter.CreatedBefore
+	}
+	if len(createdAt) > 0 {
+		query["created_at"] = createdAt
+	}
+	if filter.MinDuration > 0 {
+		query["duration"] = bson.M{"$gte": filter.MinDuration}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: 1}}).SetSkip(int64(filter.Offset))
+	if filter.Limit > 0 {
+		opts.SetLimit(int64(filter.Limit))
+	}
+
+	cur, err := b.tasksCollection().Find(context.Background(), query, opts)
+	if err != nil {
+		return nil, fmt.Errorf("List task states error: %s", err)
+	}
+	defer cur.Close(context.Background())
+
+	taskStates := make([]*tasks.TaskState, 0)
+	if err := cur.All(context.Background(), &taskStates); err != nil {
+		return nil, fmt.Errorf("List task states error: %s", err)
+	}
+	return taskStates, nil
+}
+
+// PutDeadLetter stores the dead letter in the dead-letter collection,
+// replacing one with the same ID
+func (b *Backend) PutDeadLetter(deadLetter *tasks.DeadLetter) error {
+	_, err := b.deadLettersCollection().ReplaceOne(context.Background(), bson.M{"_id": deadLetter.ID}, deadLetter, options.Replace().SetUpsert(true))
+	return err
+}
+
+// ListDeadLetters returns the dead letters matching the filter, newest first
+func (b *Backend) ListDeadLetters(filter *tasks.DeadLetterFilter) ([]*tasks.DeadLetter, error) {
+	query := bson.M{}
+	if filter.Reason != "" {
+		query["reason"] = filter.Reason
+	}
+	if filter.TaskName != "" {
+		query["task_name"] = filter.TaskName
+	}
+	if filter.RoutingKey != "" {
+		query["routing_key"] = filter.RoutingKey
+	}
+	failedAt := bson.M{}
+	if !filter.FailedAfter.IsZero() {
+		failedAt["$gt"] = filter.FailedAfter
+	}
+	if !filter.FailedBefore.IsZero() {
+		failedAt["$lt"] = filter.FailedBefore
+	}
+	if len(failedAt) > 0 {
+		query["failed_at"] = failedAt
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "failed_at", Value: -1}, {Key: "_id", Value: 1}}).SetSkip(int64(filter.Offset))
+	if filter.Limit > 0 {
+		opts.SetLimit(int64(filter.Limit))
+	}
+
+	cur, err := b.deadLettersCollection().Find(context.Background(), query, opts)
+	if err != nil {
+		return nil, fmt.Errorf("List dead letters error: %s", err)
+	}
+	defer cur.Close(context.Background())
+
+	deadLetters := make([]*tasks.DeadLetter, 0)
+	if err := cur.All(context.Background(), &deadLetters); err != nil {
+		return nil, fmt.Errorf("List dead letters error: %s", err)
+	}
+	return deadLetters, nil
+}
+
+// DeleteDeadLetter removes the dead letter from the dead-letter collection
+func (b *Backend) DeleteDeadLetter(id string) error {
+	_, err := b.deadLettersCollection().DeleteOne(context.Background(), bson.M{"_id": id})
+	return err
+}
+
 // PurgeState deletes stored task state
 func (b *Backend) PurgeState(taskUUID string) error {
 	_, err := b.tasksCollection().DeleteOne(context.Background(), bson.M{"_id": taskUUID})
@@ -262,6 +397,26 @@ func (b *Backend) getStates(taskUUIDs ...string) ([]*tasks.TaskState, error) {
 }
 
 // updateState saves current task state
+// withTiming adds the timing fields of the task state known to the update
+func withTiming(update bson.M, taskState *tasks.TaskState) bson.M {
+	if taskState.EnqueuedAt != nil {
+		update["enqueued_at"] = *taskState.EnqueuedAt
+	}
+	if taskState.StartedAt != nil {
+		update["started_at"] = *taskState.StartedAt
+	}
+	if taskState.FinishedAt != nil {
+		update["finished_at"] = *taskState.FinishedAt
+	}
+	if taskState.QueueWait > 0 {
+		update["queue_wait"] = taskState.QueueWait
+	}
+	if taskState.Duration > 0 {
+		update["duration"] = taskState.Duration
+	}
+	return update
+}
+
 func (b *Backend) updateState(signature *tasks.Signature, update bson.M) error {
 	update = bson.M{"$set": update}
 	_, err := b.tasksCollection().UpdateOne(context.Background(), bson.M{"_id": signature.UUID}, update, options.Update().SetUpsert(true))
@@ -284,6 +439,14 @@ func (b *Backend) groupMetasCollection() *mongo.Collection {
 	return b.gmc
 }
 
+func (b *Backend) deadLettersCollection() *mongo.Collection {
+	b.once.Do(func() {
+		b.connect()
+	})
+
+	return b.dlc
+}
+
 // connect creates the underlying mgo connection if it doesn't exist
 // creates required indexes for our collections
 func (b *Backend) connect() error {
@@ -299,8 +462,9 @@ func (b *Backend) connect() error {
 		database = b.GetConfig().MongoDB.Database
 	}
 
-	b.tc = b.client.Database(database).Collection("tasks")
-	b.gmc = b.client.Database(database).Collection("group_metas")
+	b.tc = b.client.Database(database).Collection(b.GetConfig().Namespaced("tasks"))
+	b.gmc = b.client.Database(database).Collection(b.GetConfig().Namespaced("group_metas"))
+	b.dlc = b.client.Database(database).Collection(b.DeadLetterQueue())
 
 	err = b.createMongoIndexes(database)
 	if err != nil {
@@ -341,7 +505,7 @@ func (b *Backend) dial() (*mongo.Client, error) {
 // createMongoIndexes ensures all indexes are in place
 func (b *Backend) createMongoIndexes(database string) error {
 
-	tasksCollection := b.client.Database(database).Collection("tasks")
+	tasksCollection := b.client.Database(database).Collection(b.GetConfig().Namespaced("tasks"))
 
 	_, err := tasksCollection.Indexes().CreateMany(
 		context.Background(), []mongo.IndexModel{
@@ -349,6 +513,10 @@ func (b *Backend) createMongoIndexes(database string) error {
 				Keys:    bson.M{"delete_at": 1},
 				Options: options.Index().SetBackground(true).SetExpireAfterSeconds(0),
 			},
+			{
+				Keys:    bson.D{{Key: "created_at", Value: -1}},
+				Options: options.Index().SetBackground(true),
+			},
 		},
 	)
 	if err != nil {
@@ -1,8 +1,14 @@
+//go:build !machinery_no_mongodb
+// +build !machinery_no_mongodb
+
 package mongo_test
 
 import (
+	"context"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/RichardKnop/machinery/v2/backends/iface"
 	"github.com/RichardKnop/machinery/v2/backends/mongo"
@@ -245,3 +251,101 @@ func TestGroupStates(t *testing.T) {
 		assert.Equal(t, taskUUIDs[i], taskState.TaskUUID)
 	}
 }
+
+func TestWaitCompleted(t *testing.T) {
+	if os.Getenv("MONGODB_URL") == "" {
+		t.Skip("MONGODB_URL is not defined")
+	}
+
+	backend, err := newBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature := &tasks.Signature{UUID: taskUUIDs[0]}
+	assert.NoError(t, backend.SetStatePending(signature))
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		backend.SetStateFailure(signature, "boom")
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	taskState, err := backend.(iface.WatchBackend).WaitCompleted(ctx, signature.UUID)
+	if err != nil && strings.Contains(err.Error(), "replica set") {
+		t.Skip("Change streams need MongoDB to run as a replica set")
+	}
+	if assert.NoError(t, err) {
+		assert.Equal(t, tasks.StateFailure, taskState.State)
+		assert.Equal(t, "boom", taskState.Error)
+	}
+}
+
+func TestListStates(t *testing.T) {
+	if os.Getenv("MONGODB_URL") == "" {
+		t.Skip("MONGODB_URL is not defined")
+	}
+
+	backend, err := newBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := backend.(iface.QueryBackend)
+
+	since := time.Now().UTC().Add(-time.Second)
+	for _, taskUUID := range taskUUIDs {
+		assert.NoError(t, backend.SetStatePending(&tasks.Signature{UUID: taskUUID, Name: "list_states"}))
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NoError(t, backend.SetStateFailure(&tasks.Signature{UUID: taskUUIDs[0]}, "boom"))
+	assert.NoError(t, backend.SetStateFailure(&tasks.Signature{UUID: taskUUIDs[2]}, "boom"))
+
+	taskStates, err := query.ListStates(&tasks.StateFilter{
+		States:       []string{tasks.StateFailure},
+		TaskName:     "list_states",
+		CreatedAfter: since,
+	})
+	if assert.NoError(t, err) && assert.Len(t, taskStates, 2) {
+		assert.Equal(t, taskUUIDs[2], taskStates[0].TaskUUID)
+		assert.Equal(t, taskUUIDs[0], taskStates[1].TaskUUID)
+	}
+
+	taskStates, err = query.ListStates(&tasks.StateFilter{TaskName: "list_states", Offset: 1, Limit: 1})
+	if assert.NoError(t, err) && assert.Len(t, taskStates, 1) {
+		assert.Equal(t, taskUUIDs[1], taskStates[0].TaskUUID)
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	if os.Getenv("MONGODB_URL") == "" {
+		t.Skip("MONGODB_URL is not defined")
+	}
+
+	backend, err := newBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadLetterBackend := backend.(iface.DeadLetterBackend)
+
+	deadLetter := &tasks.DeadLetter{
+		ID:       taskUUIDs[0],
+		TaskName: "dead_letters",
+		Reason:   tasks.DeadLetterExhausted,
+		Error:    "boom",
+		FailedAt: time.Now().UTC().Truncate(time.Millisecond),
+		Message:  []byte(`{"UUID":"1","Name":"dead_letters"}`),
+	}
+	assert.NoError(t, deadLetterBackend.PutDeadLetter(deadLetter))
+
+	deadLetters, err := deadLetterBackend.ListDeadLetters(&tasks.DeadLetterFilter{TaskName: "dead_letters"})
+	if assert.NoError(t, err) && assert.Len(t, deadLetters, 1) {
+		assert.Equal(t, deadLetter.Message, deadLetters[0].Message)
+		assert.True(t, deadLetter.FailedAt.Equal(deadLetters[0].FailedAt))
+	}
+
+	assert.NoError(t, deadLetterBackend.DeleteDeadLetter(deadLetter.ID))
+	deadLetters, err = deadLetterBackend.ListDeadLetters(&tasks.DeadLetterFilter{TaskName: "dead_letters"})
+	assert.NoError(t, err)
+	assert.Empty(t, deadLetters)
+}
@@ -0,0 +1,169 @@
+//go:build !machinery_no_mongodb
+// +build !machinery_no_mongodb
+
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/RichardKnop/machinery/v2/log"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+// completedStates matches changes leaving a task succeeded or failed
+var completedStates = mongo.Pipeline{
+	{{Key: "$match", Value: bson.M{
+		"operationType":      bson.M{"$in": bson.A{"insert", "update", "replace"}},
+		"fullDocument.state": bson.M{"$in": bson.A{tasks.StateSuccess, tasks.StateFailure}},
+	}}},
+}
+
+// changeEvent is the part of a change stream event the watcher needs
+type changeEvent struct {
+	FullDocument *tasks.TaskState `bson:"fullDocument"`
+}
+
+// watcher shares a single change stream of completed task states between
+// all callers waiting on the backend. The stream is opened by the first
+// waiter and closed once the last one is gone.
+type watcher struct {
+	mu         sync.Mutex
+	waiters    map[string][]chan *tasks.TaskState
+	cancel     context.CancelFunc
+	generation int
+}
+
+// WaitCompleted waits for the task to succeed or fail without polling.
+// Change streams need MongoDB to run as a replica set or sharded cluster,
+// an error is returned otherwise.
+func (b *Backend) WaitCompleted(ctx context.Context, taskUUID string) (*tasks.TaskState, error) {
+	ch, err := b.watcher.subscribe(b.tasksCollection(), taskUUID, b.Logger())
+	if err != nil {
+		return nil, err
+	}
+	defer b.watcher.unsubscribe(taskUUID, ch)
+
+	// The stream is open already, so the task can't complete unnoticed
+	// between reading its state and waiting for changes
+	if state, err := b.GetState(taskUUID); err == nil && state.IsCompleted() {
+		return state, nil
+	}
+
+	select {
+	case state, ok := <-ch:
+		if !ok {
+			return nil, errors.New("Task states change stream closed")
+		}
+		return state, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// subscribe opens the change stream unless it is open and registers a
+// channel receiving the completed state of the task, the stream logs its
+// errors to the logger
+func (w *watcher) subscribe(collection *mongo.Collection, taskUUID string, logger *log.Logger) (chan *tasks.TaskState, error) {
+	if collection == nil {
+		return nil, errors.New("MongoDB is not connected")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.cancel == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		stream, err := collection.Watch(ctx, completedStates, options.ChangeStream().SetFullDocument(options.UpdateLookup))
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("Watch task states error: %s", err)
+		}
+
+		w.cancel = cancel
+		w.generation++
+		w.waiters = make(map[string][]chan *tasks.TaskState)
+		go w.run(ctx, stream, w.generation, logger)
+	}
+
+	ch := make(chan *tasks.TaskState, 1)
+	w.waiters[taskUUID] = append(w.waiters[taskUUID], ch)
+	return ch, nil
+}
+
+// unsubscribe removes the channel and closes the stream if nobody waits
+func (w *watcher) unsubscribe(taskUUID string, ch chan *tasks.TaskState) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	waiters := w.waiters[taskUUID]
+	for i, waiter := range waiters {
+		if waiter == ch {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(w.waiters, taskUUID)
+	} else {
+		w.waiters[taskUUID] = waiters
+	}
+
+	if len(w.waiters) == 0 && w.cancel != nil {
+		w.cancel()
+		w.cancel = nil
+	}
+}
+
+// run hands completed states to their waiters until the stream is closed
+func (w *watcher) run(ctx context.Context, stream *mongo.ChangeStream, generation int, logger *log.Logger) {
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		event := new(changeEvent)
+		if err := stream.Decode(event); err != nil {
+			logger.WARNING.Printf("Decode task state change error: %s", err)
+			continue
+		}
+		if event.FullDocument != nil {
+			w.notify(event.FullDocument)
+		}
+	}
+
+	if err := stream.Err(); err != nil && ctx.Err() == nil {
+		logger.WARNING.Printf("Task states change stream error: %s", err)
+	}
+
+	// The stream failed, let the waiters know unless a new one replaced it
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.generation != generation || w.cancel == nil {
+		return
+	}
+	for _, waiters := range w.waiters {
+		for _, waiter := range waiters {
+			close(waiter)
+		}
+	}
+	w.waiters = make(map[string][]chan *tasks.TaskState)
+	w.cancel()
+	w.cancel = nil
+}
+
+func (w *watcher) notify(state *tasks.TaskState) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, waiter := range w.waiters[state.TaskUUID] {
+		select {
+		case waiter <- state:
+		default:
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package null
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/RichardKnop/machinery/v2/backends/iface"
 	"github.com/RichardKnop/machinery/v2/common"
@@ -9,6 +11,10 @@ import (
 	"github.com/RichardKnop/machinery/v2/tasks"
 )
 
+// ErrChordsNotSupported is returned from group completion checks when the
+// backend was created without a chord counter
+var ErrChordsNotSupported = errors.New("null backend does not track group completion, use NewWithChordCounter to run chords")
+
 // ErrGroupNotFound ...
 type ErrGroupNotFound struct {
 	groupUUID string
@@ -39,49 +45,116 @@ func (e ErrTasknotFound) Error() string {
 	return fmt.Sprintf("Task not found: %v", e.taskUUID)
 }
 
-// Backend represents an "null" result backend
+// Backend represents an "null" result backend. It discards task states and
+// results, which makes it suitable for fire-and-forget workloads. Chords
+// need to know when all tasks in a group have finished, so by default group
+// completion checks fail with ErrChordsNotSupported. A backend created with
+// NewWithChordCounter keeps a lightweight in-memory counter of finished group
+// tasks instead; the counter is local to the process, so all tasks of a chord
+// must be processed by workers sharing the same backend instance.
 type Backend struct {
 	common.Backend
-	groups map[string]struct{}
+	countChords bool
+	mu          sync.Mutex
+	groups      map[string]*group
+}
+
+// group keeps the final states of tasks in a group, without their results
+type group struct {
+	taskUUIDs      []string
+	states         map[string]string
+	chordTriggered bool
 }
 
 // New creates NullBackend instance
 func New() iface.Backend {
 	return &Backend{
 		Backend: common.NewBackend(new(config.Config)),
-		groups:  make(map[string]struct{}),
+		groups:  make(map[string]*group),
+	}
+}
+
+// NewWithChordCounter creates NullBackend instance which counts finished
+// group tasks so chords can be triggered
+func NewWithChordCounter() iface.Backend {
+	return &Backend{
+		Backend:     common.NewBackend(new(config.Config)),
+		countChords: true,
+		groups:      make(map[string]*group),
 	}
 }
 
 // InitGroup creates and saves a group meta data object
 func (b *Backend) InitGroup(groupUUID string, taskUUIDs []string) error {
-	b.groups[groupUUID] = struct{}{}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	g := new(group)
+	if b.countChords {
+		g.taskUUIDs = taskUUIDs
+		g.states = make(map[string]string, len(taskUUIDs))
+	}
+	b.groups[groupUUID] = g
 	return nil
 }
 
-// GroupCompleted returns true (always)
+// GroupCompleted returns true if all tasks in a group finished
 func (b *Backend) GroupCompleted(groupUUID string, groupTaskCount int) (bool, error) {
-	_, ok := b.groups[groupUUID]
-	if !ok {
-		return false, NewErrGroupNotFound(groupUUID)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	g, err := b.getGroup(groupUUID)
+	if err != nil {
+		return false, err
 	}
 
-	return true, nil
+	return len(g.states) == groupTaskCount, nil
 }
 
-// GroupTaskStates returns null states of all tasks in the group
+// GroupTaskStates returns final states of all tasks in the group. Results are
+// never stored, so the states do not contain any. Once the chord has been
+// triggered the group is forgotten.
 func (b *Backend) GroupTaskStates(groupUUID string, groupTaskCount int) ([]*tasks.TaskState, error) {
-	_, ok := b.groups[groupUUID]
-	if !ok {
-		return nil, NewErrGroupNotFound(groupUUID)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	g, err := b.getGroup(groupUUID)
+	if err != nil {
+		return nil, err
 	}
 
 	ret := make([]*tasks.TaskState, 0, groupTaskCount)
+	for _, taskUUID := range g.taskUUIDs {
+		state, ok := g.states[taskUUID]
+		if !ok {
+			state = tasks.StatePending
+		}
+		ret = append(ret, &tasks.TaskState{TaskUUID: taskUUID, State: state})
+	}
+
+	if g.chordTriggered {
+		delete(b.groups, groupUUID)
+	}
+
 	return ret, nil
 }
 
-// TriggerChord returns true (always)
+// TriggerChord flags chord as triggered to make sure chord is never triggered
+// multiple times
 func (b *Backend) TriggerChord(groupUUID string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	g, err := b.getGroup(groupUUID)
+	if err != nil {
+		return false, err
+	}
+
+	if g.chordTriggered {
+		return false, nil
+	}
+
+	g.chordTriggered = true
 	return true, nil
 }
 
@@ -112,12 +185,14 @@ func (b *Backend) SetStateRetry(signature *tasks.Signature) error {
 // SetStateSuccess updates task state to SUCCESS
 func (b *Backend) SetStateSuccess(signature *tasks.Signature, results []*tasks.TaskResult) error {
 	state := tasks.NewSuccessTaskState(signature, results)
+	b.countFinished(signature, state)
 	return b.updateState(state)
 }
 
 // SetStateFailure updates task state to FAILURE
 func (b *Backend) SetStateFailure(signature *tasks.Signature, err string) error {
 	state := tasks.NewFailureTaskState(signature, err)
+	b.countFinished(signature, state)
 	return b.updateState(state)
 }
 
@@ -133,14 +208,47 @@ func (b *Backend) PurgeState(taskUUID string) error {
 
 // PurgeGroupMeta deletes stored group meta data
 func (b *Backend) PurgeGroupMeta(groupUUID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	_, ok := b.groups[groupUUID]
 	if !ok {
 		return NewErrGroupNotFound(groupUUID)
 	}
 
+	delete(b.groups, groupUUID)
 	return nil
 }
 
+// getGroup must be called with the mutex held
+func (b *Backend) getGroup(groupUUID string) (*group, error) {
+	if !b.countChords {
+		return nil, ErrChordsNotSupported
+	}
+
+	g, ok := b.groups[groupUUID]
+	if !ok {
+		return nil, NewErrGroupNotFound(groupUUID)
+	}
+
+	return g, nil
+}
+
+// countFinished records the final state of a group task when chords are
+// counted
+func (b *Backend) countFinished(signature *tasks.Signature, s *tasks.TaskState) {
+	if !b.countChords || signature.GroupUUID == "" {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if g, ok := b.groups[signature.GroupUUID]; ok {
+		g.states[s.TaskUUID] = s.State
+	}
+}
+
 func (b *Backend) updateState(s *tasks.TaskState) error {
 	return nil
 }
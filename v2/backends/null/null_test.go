@@ -0,0 +1,67 @@
+package null_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/backends/null"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestChordsNotSupported(t *testing.T) {
+	t.Parallel()
+
+	backend := null.New()
+	assert.NoError(t, backend.InitGroup("group_1", []string{"task_1"}))
+	assert.NoError(t, backend.SetStateSuccess(&tasks.Signature{UUID: "task_1", GroupUUID: "group_1"}, nil))
+
+	_, err := backend.GroupCompleted("group_1", 1)
+	assert.Equal(t, null.ErrChordsNotSupported, err)
+	_, err = backend.TriggerChord("group_1")
+	assert.Equal(t, null.ErrChordsNotSupported, err)
+}
+
+func TestChordCounter(t *testing.T) {
+	t.Parallel()
+
+	backend := null.NewWithChordCounter()
+	task1 := &tasks.Signature{UUID: "task_1", GroupUUID: "group_1"}
+	task2 := &tasks.Signature{UUID: "task_2", GroupUUID: "group_1"}
+
+	_, err := backend.GroupCompleted("group_1", 2)
+	assert.Equal(t, null.NewErrGroupNotFound("group_1"), err)
+
+	assert.NoError(t, backend.InitGroup("group_1", []string{task1.UUID, task2.UUID}))
+	assert.NoError(t, backend.SetStateStarted(task1))
+	assert.NoError(t, backend.SetStateSuccess(task1, []*tasks.TaskResult{{Type: "int64", Value: 1}}))
+	assert.NoError(t, backend.SetStateSuccess(task1, nil))
+
+	completed, err := backend.GroupCompleted("group_1", 2)
+	assert.NoError(t, err)
+	assert.False(t, completed)
+
+	assert.NoError(t, backend.SetStateFailure(task2, "some error"))
+
+	completed, err = backend.GroupCompleted("group_1", 2)
+	assert.NoError(t, err)
+	assert.True(t, completed)
+
+	shouldTrigger, err := backend.TriggerChord("group_1")
+	assert.NoError(t, err)
+	assert.True(t, shouldTrigger)
+	shouldTrigger, err = backend.TriggerChord("group_1")
+	assert.NoError(t, err)
+	assert.False(t, shouldTrigger)
+
+	states, err := backend.GroupTaskStates("group_1", 2)
+	if assert.NoError(t, err) && assert.Len(t, states, 2) {
+		assert.True(t, states[0].IsSuccess())
+		assert.Empty(t, states[0].Results)
+		assert.True(t, states[1].IsFailure())
+	}
+
+	// The group is released once the chord has been triggered
+	_, err = backend.GroupCompleted("group_1", 2)
+	assert.Equal(t, null.NewErrGroupNotFound("group_1"), err)
+}
@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
 	"strings"
 	"sync"
 	"time"
@@ -15,28 +18,59 @@ import (
 	"github.com/RichardKnop/machinery/v2/backends/iface"
 	"github.com/RichardKnop/machinery/v2/common"
 	"github.com/RichardKnop/machinery/v2/config"
-	"github.com/RichardKnop/machinery/v2/log"
 	"github.com/RichardKnop/machinery/v2/tasks"
 )
 
+var (
+	claimIdempotencyKeyScriptGR   = redis.NewScript(claimIdempotencyKeySource)
+	releaseIdempotencyKeyScriptGR = redis.NewScript(releaseIdempotencyKeySource)
+)
+
 // BackendGR represents a Redis result backend
 type BackendGR struct {
 	common.Backend
-	rclient  redis.UniversalClient
+	shards   []*shardGR
 	host     string
 	password string
 	db       int
 	// If set, path to a socket file overrides hostname
 	socketPath string
-	redsync    *redsync.Redsync
 	redisOnce  sync.Once
 }
 
+// shardGR is a single Redis deployment holding a part of the keys
+type shardGR struct {
+	rclient redis.UniversalClient
+	redsync *redsync.Redsync
+}
+
 // NewGR creates Backend instance
 func NewGR(cnf *config.Config, addrs []string, db int) iface.Backend {
+	return NewShardedGR(cnf, [][]string{addrs}, db)
+}
+
+// NewShardedGR creates Backend instance spreading task states and group meta
+// data across multiple Redis deployments. Each element of shards holds the
+// addresses of one deployment (a single node, sentinel or cluster) in the
+// format accepted by NewGR. Keys are routed by a hash of the task or group
+// UUID, so every worker and client must be configured with the same shards
+// in the same order.
+func NewShardedGR(cnf *config.Config, shards [][]string, db int) iface.Backend {
 	b := &BackendGR{
 		Backend: common.NewBackend(cnf),
+		shards:  make([]*shardGR, len(shards)),
 	}
+	for i, addrs := range shards {
+		rclient := newUniversalClient(cnf, addrs, db)
+		b.shards[i] = &shardGR{
+			rclient: rclient,
+			redsync: redsync.New(redsyncgoredis.NewPool(rclient)),
+		}
+	}
+	return b
+}
+
+func newUniversalClient(cnf *config.Config, addrs []string, db int) redis.UniversalClient {
 	var password string
 	var username string
 	parts := strings.Split(addrs[0], "@")
@@ -71,12 +105,9 @@ func NewGR(cnf *config.Config, addrs []string, db int) iface.Backend {
 	}
 
 	if cnf.Redis != nil && cnf.Redis.ClusterEnabled {
-		b.rclient = redis.NewClusterClient(ropt.Cluster())
-	} else {
-		b.rclient = redis.NewUniversalClient(ropt)
+		return redis.NewClusterClient(ropt.Cluster())
 	}
-	b.redsync = redsync.New(redsyncgoredis.NewPool(b.rclient))
-	return b
+	return redis.NewUniversalClient(ropt)
 }
 
 // InitGroup creates and saves a group meta data object
@@ -92,12 +123,59 @@ func (b *BackendGR) InitGroup(groupUUID string, taskUUIDs []string) error {
 		return err
 	}
 
-	expiration := b.getExpiration()
-	err = b.rclient.Set(context.Background(), groupUUID, encoded, expiration).Err()
+	expiration := b.getGroupMetaExpiration()
+	err = b.shard(groupUUID).rclient.Set(context.Background(), b.GetConfig().Namespaced(groupUUID), encoded, expiration).Err()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InitGroupPending saves UUIDs of all tasks in a group and their pending
+// states with a single pipeline per shard
+func (b *BackendGR) InitGroupPending(groupUUID string, signatures []*tasks.Signature) error {
+	taskUUIDs := make([]string, len(signatures))
+	for i, signature := range signatures {
+		taskUUIDs[i] = signature.UUID
+	}
+
+	groupMeta := &tasks.GroupMeta{
+		GroupUUID: groupUUID,
+		TaskUUIDs: taskUUIDs,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	encoded, err := json.Marshal(groupMeta)
 	if err != nil {
 		return err
 	}
 
+	ctx := context.Background()
+	pipeliners := make(map[*shardGR]redis.Pipeliner)
+	pipeliner := func(key string) redis.Pipeliner {
+		shard := b.shard(key)
+		if _, ok := pipeliners[shard]; !ok {
+			pipeliners[shard] = shard.rclient.Pipeline()
+		}
+		return pipeliners[shard]
+	}
+
+	pipeliner(groupUUID).Set(ctx, b.GetConfig().Namespaced(groupUUID), encoded, b.getGroupMetaExpiration())
+	for _, signature := range signatures {
+		encoded, err := b.EncodeTaskState(tasks.NewPendingTaskState(signature))
+		if err != nil {
+			return err
+		}
+		pipeliner(signature.UUID).Set(ctx, b.GetConfig().Namespaced(signature.UUID), encoded, b.getExpiration(signature))
+	}
+
+	for _, pipe := range pipeliners {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -138,7 +216,8 @@ func (b *BackendGR) GroupTaskStates(groupUUID string, groupTaskCount int) ([]*ta
 // whether the worker should trigger chord (true) or no if it has been triggered
 // already (false)
 func (b *BackendGR) TriggerChord(groupUUID string) (bool, error) {
-	m := b.redsync.NewMutex("TriggerChordMutex")
+	shard := b.shard(groupUUID)
+	m := shard.redsync.NewMutex(b.GetConfig().Namespaced("TriggerChordMutex"))
 	if err := m.Lock(); err != nil {
 		return false, err
 	}
@@ -163,8 +242,8 @@ func (b *BackendGR) TriggerChord(groupUUID string) (bool, error) {
 		return false, err
 	}
 
-	expiration := b.getExpiration()
-	err = b.rclient.Set(context.Background(), groupUUID, encoded, expiration).Err()
+	expiration := b.getGroupMetaExpiration()
+	err = shard.rclient.Set(context.Background(), b.GetConfig().Namespaced(groupUUID), encoded, expiration).Err()
 	if err != nil {
 		return false, err
 	}
@@ -183,64 +262,348 @@ func (b *BackendGR) mergeNewTaskState(newState *tasks.TaskState) {
 // SetStatePending updates task state to PENDING
 func (b *BackendGR) SetStatePending(signature *tasks.Signature) error {
 	taskState := tasks.NewPendingTaskState(signature)
-	return b.updateState(taskState)
+	return b.updateState(signature, taskState)
 }
 
 // SetStateReceived updates task state to RECEIVED
 func (b *BackendGR) SetStateReceived(signature *tasks.Signature) error {
 	taskState := tasks.NewReceivedTaskState(signature)
 	b.mergeNewTaskState(taskState)
-	return b.updateState(taskState)
+	return b.updateState(signature, taskState)
 }
 
 // SetStateStarted updates task state to STARTED
 func (b *BackendGR) SetStateStarted(signature *tasks.Signature) error {
 	taskState := tasks.NewStartedTaskState(signature)
 	b.mergeNewTaskState(taskState)
-	return b.updateState(taskState)
+	return b.updateState(signature, taskState)
 }
 
 // SetStateRetry updates task state to RETRY
 func (b *BackendGR) SetStateRetry(signature *tasks.Signature) error {
 	taskState := tasks.NewRetryTaskState(signature)
 	b.mergeNewTaskState(taskState)
-	return b.updateState(taskState)
+	return b.updateState(signature, taskState)
 }
 
 // SetStateSuccess updates task state to SUCCESS
 func (b *BackendGR) SetStateSuccess(signature *tasks.Signature, results []*tasks.TaskResult) error {
 	taskState := tasks.NewSuccessTaskState(signature, results)
 	b.mergeNewTaskState(taskState)
-	return b.updateState(taskState)
+	return b.updateState(signature, taskState)
 }
 
 // SetStateFailure updates task state to FAILURE
 func (b *BackendGR) SetStateFailure(signature *tasks.Signature, err string) error {
 	taskState := tasks.NewFailureTaskState(signature, err)
 	b.mergeNewTaskState(taskState)
-	return b.updateState(taskState)
+	return b.updateState(signature, taskState)
+}
+
+// SetStateTimedOut updates task state to TIMED_OUT
+func (b *BackendGR) SetStateTimedOut(signature *tasks.Signature) error {
+	taskState := tasks.NewTimedOutTaskState(signature)
+	b.mergeNewTaskState(taskState)
+	return b.updateState(signature, taskState)
+}
+
+// SetProgress stores the progress along with the latest task state
+func (b *BackendGR) SetProgress(signature *tasks.Signature, progress *tasks.Progress) error {
+	taskState, err := b.GetState(signature.UUID)
+	if err != nil {
+		return err
+	}
+	taskState.Progress = progress
+	return b.updateState(signature, taskState)
+}
+
+// SetDiagnostics stores the diagnostics along with the latest task state
+func (b *BackendGR) SetDiagnostics(signature *tasks.Signature, diagnostics *tasks.Diagnostics) error {
+	taskState, err := b.GetState(signature.UUID)
+	if err != nil {
+		return err
+	}
+	taskState.Diagnostics = diagnostics
+	return b.updateState(signature, taskState)
 }
 
 // GetState returns the latest task state
 func (b *BackendGR) GetState(taskUUID string) (*tasks.TaskState, error) {
 
-	item, err := b.rclient.Get(context.Background(), taskUUID).Bytes()
+	item, err := b.shard(taskUUID).rclient.Get(context.Background(), b.GetConfig().Namespaced(taskUUID)).Bytes()
 	if err != nil {
 		return nil, err
 	}
-	state := new(tasks.TaskState)
-	decoder := json.NewDecoder(bytes.NewReader(item))
-	decoder.UseNumber()
-	if err := decoder.Decode(state); err != nil {
+	state, err := b.DecodeTaskState(item)
+	if err != nil {
 		return nil, err
 	}
 
 	return state, nil
 }
 
+// ReportInFlight stores the report of the worker in a hash shared by the
+// fleet, which expires along with the latest report
+func (b *BackendGR) ReportInFlight(workerID string, counts map[string]int, ttl time.Duration) error {
+	ctx := context.Background()
+	key := b.GetConfig().Namespaced(inFlightKey)
+	rclient := b.shard(inFlightKey).rclient
+	if len(counts) == 0 {
+		return rclient.HDel(ctx, key, workerID).Err()
+	}
+
+	encoded, err := json.Marshal(&inFlightReport{Counts: counts, ExpiresAt: time.Now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+
+	_, err = rclient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, workerID, encoded)
+		pipe.PExpire(ctx, key, ttl)
+		return nil
+	})
+	return err
+}
+
+// InFlight sums the reports of the workers which didn't expire, expired
+// reports are removed
+func (b *BackendGR) InFlight() (map[string]int, error) {
+	ctx := context.Background()
+	key := b.GetConfig().Namespaced(inFlightKey)
+	rclient := b.shard(inFlightKey).rclient
+
+	reports, err := rclient.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	total, expired, err := sumInFlight(reports)
+	if err != nil {
+		return nil, err
+	}
+	if len(expired) > 0 {
+		if err := rclient.HDel(ctx, key, expired...).Err(); err != nil {
+			b.Logger().WARNING.Printf("Failed to remove expired in-flight reports: %s", err)
+		}
+	}
+	return total, nil
+}
+
+// ReportWorker stores the heartbeat of the worker in a hash shared by the
+// fleet, which expires along with the latest heartbeat
+func (b *BackendGR) ReportWorker(workerID string, worker *tasks.WorkerInfo, ttl time.Duration) error {
+	ctx := context.Background()
+	key := b.GetConfig().Namespaced(workersKey)
+	rclient := b.shard(workersKey).rclient
+	if worker == nil {
+		return rclient.HDel(ctx, key, workerID).Err()
+	}
+
+	encoded, err := json.Marshal(&workerHeartbeat{Worker: worker, ExpiresAt: time.Now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+
+	_, err = rclient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, workerID, encoded)
+		pipe.PExpire(ctx, key, ttl)
+		return nil
+	})
+	return err
+}
+
+// Workers returns the heartbeats of the workers which didn't expire, expired
+// heartbeats are removed
+func (b *BackendGR) Workers() ([]*tasks.WorkerInfo, error) {
+	ctx := context.Background()
+	key := b.GetConfig().Namespaced(workersKey)
+	rclient := b.shard(workersKey).rclient
+
+	heartbeats, err := rclient.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	workers, expired, err := decodeWorkers(heartbeats)
+	if err != nil {
+		return nil, err
+	}
+	if len(expired) > 0 {
+		if err := rclient.HDel(ctx, key, expired...).Err(); err != nil {
+			b.Logger().WARNING.Printf("Failed to remove expired heartbeats of workers: %s", err)
+		}
+	}
+	return workers, nil
+}
+
+// PublishControl stores the control message in a sorted set shared by the
+// fleet, which keeps the latest messages and expires along with the latest
+// one
+func (b *BackendGR) PublishControl(message *tasks.ControlMessage, ttl time.Duration) error {
+	ctx := context.Background()
+	id, err := b.shard(controlIDKey).rclient.Incr(ctx, b.GetConfig().Namespaced(controlIDKey)).Result()
+	if err != nil {
+		return err
+	}
+	message.ID = id
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	key := b.GetConfig().Namespaced(controlKey)
+	_, err = b.shard(controlKey).rclient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(id), Member: encoded})
+		pipe.ZRemRangeByRank(ctx, key, 0, -maxControlMessages-1)
+		pipe.PExpire(ctx, key, ttl)
+		return nil
+	})
+	return err
+}
+
+// ControlMessages returns the control messages whose ID is greater than
+// afterID
+func (b *BackendGR) ControlMessages(afterID int64) ([]*tasks.ControlMessage, error) {
+	values, err := b.shard(controlKey).rclient.ZRangeByScore(context.Background(), b.GetConfig().Namespaced(controlKey), &redis.ZRangeBy{
+		Min: fmt.Sprintf("(%d", afterID),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodeControlMessages(values)
+}
+
+// ReplyControl stores the reply of the worker in the hash of the replies to
+// the control message
+func (b *BackendGR) ReplyControl(reply *tasks.ControlReply, ttl time.Duration) error {
+	if reply.Worker == nil {
+		return errors.New("Control reply has no worker")
+	}
+	encoded, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	key := fmt.Sprintf("%s%d", controlRepliesKeyPrefix, reply.MessageID)
+	_, err = b.shard(key).rclient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, b.GetConfig().Namespaced(key), reply.Worker.ID, encoded)
+		pipe.PExpire(ctx, b.GetConfig().Namespaced(key), ttl)
+		return nil
+	})
+	return err
+}
+
+// ControlReplies returns the replies to the control message
+func (b *BackendGR) ControlReplies(messageID int64) ([]*tasks.ControlReply, error) {
+	key := fmt.Sprintf("%s%d", controlRepliesKeyPrefix, messageID)
+	values, err := b.shard(key).rclient.HGetAll(context.Background(), b.GetConfig().Namespaced(key)).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodeControlReplies(values)
+}
+
+// RevokeTask marks the task revoked until results expire
+func (b *BackendGR) RevokeTask(taskUUID string) error {
+	key := revokedKeyPrefix + taskUUID
+	return b.shard(key).rclient.Set(context.Background(), b.GetConfig().Namespaced(key), 1, b.getExpiration(nil)).Err()
+}
+
+// IsRevoked returns true if the task was revoked
+func (b *BackendGR) IsRevoked(taskUUID string) (bool, error) {
+	key := revokedKeyPrefix + taskUUID
+	exists, err := b.shard(key).rclient.Exists(context.Background(), b.GetConfig().Namespaced(key)).Result()
+	if err != nil {
+		return false, err
+	}
+	return exists > 0, nil
+}
+
+// ClaimIdempotencyKey stores the UUID of the task under its idempotency key
+// unless another task owns the key, and returns the UUID of the owner
+func (b *BackendGR) ClaimIdempotencyKey(signature *tasks.Signature) (string, error) {
+	key := idempotencyKeyPrefix + signature.IdempotencyKey
+	expiration := int64(b.getExpiration(signature).Seconds())
+	return claimIdempotencyKeyScriptGR.Run(context.Background(), b.shard(key).rclient,
+		[]string{b.GetConfig().Namespaced(key)}, signature.UUID, expiration).Text()
+}
+
+// IdempotencyKeyOwner returns the UUID of the task owning the key
+func (b *BackendGR) IdempotencyKeyOwner(key string) (string, error) {
+	key = idempotencyKeyPrefix + key
+	owner, err := b.shard(key).rclient.Get(context.Background(), b.GetConfig().Namespaced(key)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return owner, err
+}
+
+// ReleaseIdempotencyKey removes the key if the task still owns it
+func (b *BackendGR) ReleaseIdempotencyKey(key, taskUUID string) error {
+	key = idempotencyKeyPrefix + key
+	return releaseIdempotencyKeyScriptGR.Run(context.Background(), b.shard(key).rclient,
+		[]string{b.GetConfig().Namespaced(key)}, taskUUID).Err()
+}
+
+// PutDeadLetter stores the dead letter in the hash of the dead-letter queue,
+// replacing one with the same ID
+func (b *BackendGR) PutDeadLetter(deadLetter *tasks.DeadLetter) error {
+	encoded, err := json.Marshal(deadLetter)
+	if err != nil {
+		return err
+	}
+
+	key := b.DeadLetterQueue()
+	return b.shard(key).rclient.HSet(context.Background(), key, deadLetter.ID, encoded).Err()
+}
+
+// ListDeadLetters returns the dead letters matching the filter, newest first
+func (b *BackendGR) ListDeadLetters(filter *tasks.DeadLetterFilter) ([]*tasks.DeadLetter, error) {
+	key := b.DeadLetterQueue()
+	stored, err := b.shard(key).rclient.HVals(context.Background(), key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([][]byte, len(stored))
+	for i, value := range stored {
+		values[i] = []byte(value)
+	}
+	return filterDeadLetters(filter, values)
+}
+
+// DeleteDeadLetter removes the dead letter from the hash of the dead-letter
+// queue
+func (b *BackendGR) DeleteDeadLetter(id string) error {
+	key := b.DeadLetterQueue()
+	return b.shard(key).rclient.HDel(context.Background(), key, id).Err()
+}
+
+// SetLastRun stores when the periodic task or workflow last ran in a hash
+// shared by the servers
+func (b *BackendGR) SetLastRun(name string, lastRun time.Time) error {
+	key := b.GetConfig().Namespaced(lastRunsKey)
+	return b.shard(lastRunsKey).rclient.HSet(context.Background(), key, name, lastRun.UnixNano()).Err()
+}
+
+// LastRun returns when the periodic task or workflow last ran
+func (b *BackendGR) LastRun(name string) (time.Time, error) {
+	key := b.GetConfig().Namespaced(lastRunsKey)
+	lastRun, err := b.shard(lastRunsKey).rclient.HGet(context.Background(), key, name).Int64()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, lastRun), nil
+}
+
 // PurgeState deletes stored task state
 func (b *BackendGR) PurgeState(taskUUID string) error {
-	err := b.rclient.Del(context.Background(), taskUUID).Err()
+	err := b.shard(taskUUID).rclient.Del(context.Background(), b.GetConfig().Namespaced(taskUUID)).Err()
 	if err != nil {
 		return err
 	}
@@ -250,7 +613,7 @@ func (b *BackendGR) PurgeState(taskUUID string) error {
 
 // PurgeGroupMeta deletes stored group meta data
 func (b *BackendGR) PurgeGroupMeta(groupUUID string) error {
-	err := b.rclient.Del(context.Background(), groupUUID).Err()
+	err := b.shard(groupUUID).rclient.Del(context.Background(), b.GetConfig().Namespaced(groupUUID)).Err()
 	if err != nil {
 		return err
 	}
@@ -260,7 +623,7 @@ func (b *BackendGR) PurgeGroupMeta(groupUUID string) error {
 
 // getGroupMeta retrieves group meta data, convenience function to avoid repetition
 func (b *BackendGR) getGroupMeta(groupUUID string) (*tasks.GroupMeta, error) {
-	item, err := b.rclient.Get(context.Background(), groupUUID).Bytes()
+	item, err := b.shard(groupUUID).rclient.Get(context.Background(), b.GetConfig().Namespaced(groupUUID)).Bytes()
 	if err != nil {
 		return nil, err
 	}
@@ -278,26 +641,36 @@ func (b *BackendGR) getGroupMeta(groupUUID string) (*tasks.GroupMeta, error) {
 // getStates returns multiple task states
 func (b *BackendGR) getStates(taskUUIDs ...string) ([]*tasks.TaskState, error) {
 	taskStates := make([]*tasks.TaskState, len(taskUUIDs))
-	// to avoid CROSSSLOT error, use pipeline
-	cmders, err := b.rclient.Pipelined(context.Background(), func(pipeliner redis.Pipeliner) error {
-		for _, uuid := range taskUUIDs {
-			pipeliner.Get(context.Background(), uuid)
+
+	// group the task UUIDs by shard, keeping their position in the result
+	positions := make(map[*shardGR][]int)
+	for i, uuid := range taskUUIDs {
+		shard := b.shard(uuid)
+		positions[shard] = append(positions[shard], i)
+	}
+
+	cmds := make([]*redis.StringCmd, len(taskUUIDs))
+	for shard, indexes := range positions {
+		// to avoid CROSSSLOT error, use pipeline
+		_, err := shard.rclient.Pipelined(context.Background(), func(pipeliner redis.Pipeliner) error {
+			for _, i := range indexes {
+				cmds[i] = pipeliner.Get(context.Background(), b.GetConfig().Namespaced(taskUUIDs[i]))
+			}
+			return nil
+		})
+		if err != nil {
+			return taskStates, err
 		}
-		return nil
-	})
-	if err != nil {
-		return taskStates, err
 	}
-	for i, cmder := range cmders {
-		stateBytes, err1 := cmder.(*redis.StringCmd).Bytes()
+
+	for i, cmd := range cmds {
+		stateBytes, err1 := cmd.Bytes()
 		if err1 != nil {
 			return taskStates, err1
 		}
-		taskState := new(tasks.TaskState)
-		decoder := json.NewDecoder(bytes.NewReader(stateBytes))
-		decoder.UseNumber()
-		if err1 = decoder.Decode(taskState); err1 != nil {
-			log.ERROR.Print(err1)
+		taskState, err1 := b.DecodeTaskState(stateBytes)
+		if err1 != nil {
+			b.Logger().ERROR.Print(err1)
 			return taskStates, err1
 		}
 		taskStates[i] = taskState
@@ -307,14 +680,14 @@ func (b *BackendGR) getStates(taskUUIDs ...string) ([]*tasks.TaskState, error) {
 }
 
 // updateState saves current task state
-func (b *BackendGR) updateState(taskState *tasks.TaskState) error {
-	encoded, err := json.Marshal(taskState)
+func (b *BackendGR) updateState(signature *tasks.Signature, taskState *tasks.TaskState) error {
+	encoded, err := b.EncodeTaskState(taskState)
 	if err != nil {
 		return err
 	}
 
-	expiration := b.getExpiration()
-	_, err = b.rclient.Set(context.Background(), taskState.TaskUUID, encoded, expiration).Result()
+	expiration := b.getExpiration(signature)
+	_, err = b.shard(taskState.TaskUUID).rclient.Set(context.Background(), b.GetConfig().Namespaced(taskState.TaskUUID), encoded, expiration).Result()
 	if err != nil {
 		return err
 	}
@@ -323,12 +696,22 @@ func (b *BackendGR) updateState(taskState *tasks.TaskState) error {
 }
 
 // getExpiration returns expiration for a stored task state
-func (b *BackendGR) getExpiration() time.Duration {
-	expiresIn := b.GetConfig().ResultsExpireIn
-	if expiresIn == 0 {
-		// expire results after 1 hour by default
-		expiresIn = config.DefaultResultsExpireIn
+func (b *BackendGR) getExpiration(signature *tasks.Signature) time.Duration {
+	return time.Duration(b.ResultsExpireIn(signature)) * time.Second
+}
+
+// getGroupMetaExpiration returns expiration for stored group meta data
+func (b *BackendGR) getGroupMetaExpiration() time.Duration {
+	return time.Duration(b.GroupMetaExpireIn()) * time.Second
+}
+
+// shard returns the Redis deployment responsible for the given key
+func (b *BackendGR) shard(key string) *shardGR {
+	if len(b.shards) == 1 {
+		return b.shards[0]
 	}
 
-	return time.Duration(expiresIn) * time.Second
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return b.shards[h.Sum32()%uint32(len(b.shards))]
 }
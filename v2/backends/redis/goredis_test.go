@@ -2,9 +2,11 @@ package redis_test
 
 import (
 	"github.com/RichardKnop/machinery/v2/backends/iface"
+	"github.com/google/uuid"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/RichardKnop/machinery/v2/backends/redis"
 	"github.com/RichardKnop/machinery/v2/config"
@@ -159,3 +161,342 @@ func TestPurgeStateGR(t *testing.T) {
 	assert.Nil(t, taskState)
 	assert.Error(t, err)
 }
+
+func TestShardedGroupCompletedGR(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL_GR")
+	if redisURL == "" {
+		t.Skip()
+	}
+	backend := redis.NewShardedGR(new(config.Config), [][]string{{redisURL}, {redisURL}}, 0)
+
+	groupUUID := "testShardedGroupUUID"
+	taskUUIDs := []string{"testShardedTaskUUID1", "testShardedTaskUUID2", "testShardedTaskUUID3"}
+
+	// Cleanup before the test
+	backend.PurgeGroupMeta(groupUUID)
+	for _, taskUUID := range taskUUIDs {
+		backend.PurgeState(taskUUID)
+	}
+
+	assert.NoError(t, backend.InitGroup(groupUUID, taskUUIDs))
+	for _, taskUUID := range taskUUIDs {
+		signature := &tasks.Signature{UUID: taskUUID, GroupUUID: groupUUID}
+		assert.NoError(t, backend.SetStateSuccess(signature, []*tasks.TaskResult{{Type: "string", Value: taskUUID}}))
+	}
+
+	groupCompleted, err := backend.GroupCompleted(groupUUID, len(taskUUIDs))
+	if assert.NoError(t, err) {
+		assert.True(t, groupCompleted)
+	}
+
+	taskStates, err := backend.GroupTaskStates(groupUUID, len(taskUUIDs))
+	if assert.NoError(t, err) && assert.Len(t, taskStates, len(taskUUIDs)) {
+		for i, taskState := range taskStates {
+			assert.Equal(t, taskUUIDs[i], taskState.TaskUUID)
+		}
+	}
+}
+
+func TestInitGroupPendingGR(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL_GR")
+	if redisURL == "" {
+		t.Skip()
+	}
+	backend := redis.NewShardedGR(new(config.Config), [][]string{{redisURL}, {redisURL}}, 0)
+
+	groupUUID := "testBatchGroupUUID"
+	signatures := []*tasks.Signature{
+		{UUID: "testBatchTaskUUID1", GroupUUID: groupUUID, Name: "foo"},
+		{UUID: "testBatchTaskUUID2", GroupUUID: groupUUID, Name: "bar"},
+	}
+	assert.NoError(t, backend.(iface.BatchBackend).InitGroupPending(groupUUID, signatures))
+
+	taskStates, err := backend.GroupTaskStates(groupUUID, len(signatures))
+	if assert.NoError(t, err) && assert.Len(t, taskStates, len(signatures)) {
+		for i, taskState := range taskStates {
+			assert.Equal(t, signatures[i].UUID, taskState.TaskUUID)
+			assert.Equal(t, signatures[i].Name, taskState.TaskName)
+			assert.Equal(t, tasks.StatePending, taskState.State)
+		}
+	}
+}
+
+func TestNamespaceGR(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL_GR")
+	if redisURL == "" {
+		t.Skip()
+	}
+
+	staging := redis.NewGR(&config.Config{Namespace: "staging"}, strings.Split(redisURL, ","), 0)
+	production := redis.NewGR(&config.Config{Namespace: "production"}, strings.Split(redisURL, ","), 0)
+
+	signature := &tasks.Signature{UUID: "testNamespaceTaskUUID"}
+	assert.NoError(t, staging.SetStateSuccess(signature, nil))
+	defer staging.PurgeState(signature.UUID)
+
+	taskState, err := staging.GetState(signature.UUID)
+	if assert.NoError(t, err) {
+		assert.True(t, taskState.IsSuccess())
+	}
+	_, err = production.GetState(signature.UUID)
+	assert.Error(t, err, "task states must not leak between namespaces")
+}
+
+func TestInFlightGR(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL_GR")
+	if redisURL == "" {
+		t.Skip()
+	}
+
+	backend := redis.NewGR(&config.Config{Namespace: "test_in_flight_gr"}, strings.Split(redisURL, ","), 0).(iface.InFlightBackend)
+	testInFlight(t, backend)
+}
+
+func testInFlight(t *testing.T, backend iface.InFlightBackend) {
+	for _, workerID := range []string{"worker_1", "worker_2", "worker_3"} {
+		assert.NoError(t, backend.ReportInFlight(workerID, nil, time.Minute))
+	}
+
+	assert.NoError(t, backend.ReportInFlight("worker_1", map[string]int{"add": 2, "mul": 1}, time.Minute))
+	assert.NoError(t, backend.ReportInFlight("worker_2", map[string]int{"add": 3}, time.Minute))
+	assert.NoError(t, backend.ReportInFlight("worker_3", map[string]int{"add": 5}, time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+
+	// The report of worker_3 expired
+	inFlight, err := backend.InFlight()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"add": 5, "mul": 1}, inFlight)
+
+	assert.NoError(t, backend.ReportInFlight("worker_1", nil, time.Minute))
+	inFlight, err = backend.InFlight()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"add": 3}, inFlight)
+}
+
+func TestWorkersGR(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL_GR")
+	if redisURL == "" {
+		t.Skip()
+	}
+
+	backend := redis.NewGR(&config.Config{Namespace: "test_workers_gr"}, strings.Split(redisURL, ","), 0).(iface.PresenceBackend)
+	testWorkers(t, backend)
+}
+
+func testWorkers(t *testing.T, backend iface.PresenceBackend) {
+	for _, workerID := range []string{"worker_1", "worker_2", "worker_3"} {
+		assert.NoError(t, backend.ReportWorker(workerID, nil, time.Minute))
+	}
+
+	startedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	worker1 := &tasks.WorkerInfo{
+		ID:          "worker_1",
+		ConsumerTag: "worker",
+		Queues:      []string{"default"},
+		Concurrency: 1,
+		Running:     []*tasks.RunningTask{{UUID: "task_1", Name: "add", StartedAt: startedAt}},
+		StartedAt:   startedAt,
+		HeartbeatAt: startedAt,
+	}
+	worker2 := &tasks.WorkerInfo{ID: "worker_2", Concurrency: 2, StartedAt: startedAt, HeartbeatAt: startedAt}
+	assert.NoError(t, backend.ReportWorker("worker_2", worker2, time.Minute))
+	assert.NoError(t, backend.ReportWorker("worker_1", worker1, time.Minute))
+	assert.NoError(t, backend.ReportWorker("worker_3", &tasks.WorkerInfo{ID: "worker_3"}, time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+
+	// The heartbeat of worker_3 expired
+	workers, err := backend.Workers()
+	assert.NoError(t, err)
+	assert.Equal(t, []*tasks.WorkerInfo{worker1, worker2}, workers)
+
+	assert.NoError(t, backend.ReportWorker("worker_1", nil, time.Minute))
+	workers, err = backend.Workers()
+	assert.NoError(t, err)
+	assert.Equal(t, []*tasks.WorkerInfo{worker2}, workers)
+}
+
+func TestControlGR(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL_GR")
+	if redisURL == "" {
+		t.Skip()
+	}
+
+	backend := redis.NewGR(&config.Config{Namespace: "test_control_gr"}, strings.Split(redisURL, ","), 0).(iface.ControlBackend)
+	testControl(t, backend)
+}
+
+func testControl(t *testing.T, backend iface.ControlBackend) {
+	messages, err := backend.ControlMessages(0)
+	assert.NoError(t, err)
+	var lastID int64
+	if len(messages) > 0 {
+		lastID = messages[len(messages)-1].ID
+	}
+
+	pause := &tasks.ControlMessage{Command: tasks.ControlPause, Destination: []string{"worker_1"}}
+	stats := &tasks.ControlMessage{Command: tasks.ControlStats}
+	assert.NoError(t, backend.PublishControl(pause, time.Minute))
+	assert.NoError(t, backend.PublishControl(stats, time.Minute))
+	assert.Equal(t, pause.ID+1, stats.ID)
+
+	messages, err = backend.ControlMessages(lastID)
+	assert.NoError(t, err)
+	assert.Equal(t, []*tasks.ControlMessage{pause, stats}, messages)
+	messages, err = backend.ControlMessages(pause.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []*tasks.ControlMessage{stats}, messages)
+
+	assert.Error(t, backend.ReplyControl(&tasks.ControlReply{MessageID: stats.ID}, time.Minute))
+	reply1 := &tasks.ControlReply{MessageID: stats.ID, Worker: &tasks.WorkerInfo{ID: "worker_1", Paused: true}}
+	reply2 := &tasks.ControlReply{MessageID: stats.ID, Worker: &tasks.WorkerInfo{ID: "worker_2"}, Error: "failed"}
+	assert.NoError(t, backend.ReplyControl(reply2, time.Minute))
+	assert.NoError(t, backend.ReplyControl(reply1, time.Minute))
+	replies, err := backend.ControlReplies(stats.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []*tasks.ControlReply{reply1, reply2}, replies)
+
+	replies, err = backend.ControlReplies(pause.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, replies)
+}
+
+func TestRevokeTaskGR(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL_GR")
+	if redisURL == "" {
+		t.Skip()
+	}
+
+	backend := redis.NewGR(&config.Config{Namespace: "test_revoke_gr"}, strings.Split(redisURL, ","), 0).(iface.RevokeBackend)
+	testRevokeTask(t, backend)
+}
+
+func testRevokeTask(t *testing.T, backend iface.RevokeBackend) {
+	taskUUID := "task_" + uuid.New().String()
+
+	revoked, err := backend.IsRevoked(taskUUID)
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	assert.NoError(t, backend.RevokeTask(taskUUID))
+	revoked, err = backend.IsRevoked(taskUUID)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+}
+
+func TestSetProgressGR(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL_GR")
+	if redisURL == "" {
+		t.Skip()
+	}
+
+	testSetProgress(t, redis.NewGR(&config.Config{Namespace: "test_progress_gr"}, strings.Split(redisURL, ","), 0))
+}
+
+func testSetProgress(t *testing.T, backend iface.Backend) {
+	signature := &tasks.Signature{UUID: "task_" + uuid.New().String(), Name: "import"}
+	assert.NoError(t, backend.SetStateStarted(signature))
+
+	progress, err := tasks.NewProgress(30, "importing")
+	assert.NoError(t, err)
+	assert.NoError(t, backend.(iface.ProgressBackend).SetProgress(signature, progress))
+
+	taskState, err := backend.GetState(signature.UUID)
+	if assert.NoError(t, err) && assert.NotNil(t, taskState.Progress) {
+		assert.Equal(t, tasks.StateStarted, taskState.State)
+		assert.Equal(t, "importing", taskState.Progress.Message)
+		assert.Equal(t, 30.0, taskState.Progress.Percent)
+	}
+}
+
+func TestIdempotencyKeysGR(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL_GR")
+	if redisURL == "" {
+		t.Skip()
+	}
+
+	backend := redis.NewGR(&config.Config{Namespace: "test_idempotency_gr"}, strings.Split(redisURL, ","), 0).(iface.IdempotencyBackend)
+	testIdempotencyKeys(t, backend)
+}
+
+func testIdempotencyKeys(t *testing.T, backend iface.IdempotencyBackend) {
+	key := "welcome_email_" + uuid.New().String()
+	first := &tasks.Signature{UUID: "task_1", IdempotencyKey: key}
+	second := &tasks.Signature{UUID: "task_2", IdempotencyKey: key}
+
+	owner, err := backend.IdempotencyKeyOwner(key)
+	assert.NoError(t, err)
+	assert.Empty(t, owner)
+
+	owner, err = backend.ClaimIdempotencyKey(first)
+	assert.NoError(t, err)
+	assert.Equal(t, "task_1", owner)
+	owner, err = backend.ClaimIdempotencyKey(second)
+	assert.NoError(t, err)
+	assert.Equal(t, "task_1", owner)
+
+	// Only the owner releases the key
+	assert.NoError(t, backend.ReleaseIdempotencyKey(key, "task_2"))
+	owner, err = backend.IdempotencyKeyOwner(key)
+	assert.NoError(t, err)
+	assert.Equal(t, "task_1", owner)
+
+	assert.NoError(t, backend.ReleaseIdempotencyKey(key, "task_1"))
+	owner, err = backend.IdempotencyKeyOwner(key)
+	assert.NoError(t, err)
+	assert.Empty(t, owner)
+}
+
+func TestDeadLettersGR(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL_GR")
+	if redisURL == "" {
+		t.Skip()
+	}
+
+	backend := redis.NewGR(&config.Config{Namespace: "test_dead_letters_gr"}, strings.Split(redisURL, ","), 0).(iface.DeadLetterBackend)
+	testDeadLetters(t, backend)
+}
+
+func TestLastRunsGR(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL_GR")
+	if redisURL == "" {
+		t.Skip()
+	}
+
+	backend := redis.NewGR(&config.Config{Namespace: "test_last_runs_gr"}, strings.Split(redisURL, ","), 0).(iface.ScheduleBackend)
+	testLastRuns(t, backend)
+}
+
+func testLastRuns(t *testing.T, backend iface.ScheduleBackend) {
+	name := "periodic_" + uuid.New().String()
+	lastRun, err := backend.LastRun(name)
+	assert.NoError(t, err)
+	assert.True(t, lastRun.IsZero())
+
+	now := time.Now()
+	assert.NoError(t, backend.SetLastRun(name, now))
+	lastRun, err = backend.LastRun(name)
+	assert.NoError(t, err)
+	assert.True(t, now.Equal(lastRun))
+}
+
+func testDeadLetters(t *testing.T, backend iface.DeadLetterBackend) {
+	taskName := "task_" + uuid.New().String()
+	deadLetter := &tasks.DeadLetter{
+		ID:       uuid.New().String(),
+		TaskName: taskName,
+		Reason:   tasks.DeadLetterExhausted,
+		Error:    "boom",
+		FailedAt: time.Now().UTC(),
+		Message:  []byte(`{"Name":"` + taskName + `"}`),
+	}
+	assert.NoError(t, backend.PutDeadLetter(deadLetter))
+
+	deadLetters, err := backend.ListDeadLetters(&tasks.DeadLetterFilter{TaskName: taskName})
+	assert.NoError(t, err)
+	assert.Equal(t, []*tasks.DeadLetter{deadLetter}, deadLetters)
+
+	assert.NoError(t, backend.DeleteDeadLetter(deadLetter.ID))
+	deadLetters, err = backend.ListDeadLetters(&tasks.DeadLetterFilter{TaskName: taskName})
+	assert.NoError(t, err)
+	assert.Empty(t, deadLetters)
+}
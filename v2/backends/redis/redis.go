@@ -3,7 +3,9 @@ package redis
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -14,7 +16,6 @@ import (
 	"github.com/RichardKnop/machinery/v2/backends/iface"
 	"github.com/RichardKnop/machinery/v2/common"
 	"github.com/RichardKnop/machinery/v2/config"
-	"github.com/RichardKnop/machinery/v2/log"
 	"github.com/RichardKnop/machinery/v2/tasks"
 )
 
@@ -61,8 +62,8 @@ func (b *Backend) InitGroup(groupUUID string, taskUUIDs []string) error {
 	conn := b.open()
 	defer conn.Close()
 
-	expiration := int64(b.getExpiration().Seconds())
-	_, err = conn.Do("SET", groupUUID, encoded, "EX", expiration)
+	expiration := int64(b.getGroupMetaExpiration().Seconds())
+	_, err = conn.Do("SET", b.GetConfig().Namespaced(groupUUID), encoded, "EX", expiration)
 	if err != nil {
 		return err
 	}
@@ -70,6 +71,53 @@ func (b *Backend) InitGroup(groupUUID string, taskUUIDs []string) error {
 	return nil
 }
 
+// InitGroupPending saves UUIDs of all tasks in a group and their pending
+// states with a single pipeline
+func (b *Backend) InitGroupPending(groupUUID string, signatures []*tasks.Signature) error {
+	taskUUIDs := make([]string, len(signatures))
+	for i, signature := range signatures {
+		taskUUIDs[i] = signature.UUID
+	}
+
+	groupMeta := &tasks.GroupMeta{
+		GroupUUID: groupUUID,
+		TaskUUIDs: taskUUIDs,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	encoded, err := json.Marshal(groupMeta)
+	if err != nil {
+		return err
+	}
+
+	conn := b.open()
+	defer conn.Close()
+
+	if err := conn.Send("SET", b.GetConfig().Namespaced(groupUUID), encoded, "EX", int64(b.getGroupMetaExpiration().Seconds())); err != nil {
+		return err
+	}
+	for _, signature := range signatures {
+		encoded, err := b.EncodeTaskState(tasks.NewPendingTaskState(signature))
+		if err != nil {
+			return err
+		}
+		if err := conn.Send("SET", b.GetConfig().Namespaced(signature.UUID), encoded, "EX", int64(b.getExpiration(signature).Seconds())); err != nil {
+			return err
+		}
+	}
+
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	for i := 0; i <= len(signatures); i++ {
+		if _, err := conn.Receive(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // GroupCompleted returns true if all tasks in a group finished
 func (b *Backend) GroupCompleted(groupUUID string, groupTaskCount int) (bool, error) {
 	conn := b.open()
@@ -116,7 +164,7 @@ func (b *Backend) TriggerChord(groupUUID string) (bool, error) {
 	conn := b.open()
 	defer conn.Close()
 
-	m := b.redsync.NewMutex("TriggerChordMutex")
+	m := b.redsync.NewMutex(b.GetConfig().Namespaced("TriggerChordMutex"))
 	if err := m.Lock(); err != nil {
 		return false, err
 	}
@@ -141,8 +189,8 @@ func (b *Backend) TriggerChord(groupUUID string) (bool, error) {
 		return false, err
 	}
 
-	expiration := int64(b.getExpiration().Seconds())
-	_, err = conn.Do("SET", groupUUID, encoded, "EX", expiration)
+	expiration := int64(b.getGroupMetaExpiration().Seconds())
+	_, err = conn.Do("SET", b.GetConfig().Namespaced(groupUUID), encoded, "EX", expiration)
 	if err != nil {
 		return false, err
 	}
@@ -164,7 +212,7 @@ func (b *Backend) SetStatePending(signature *tasks.Signature) error {
 	defer conn.Close()
 
 	taskState := tasks.NewPendingTaskState(signature)
-	return b.updateState(conn, taskState)
+	return b.updateState(conn, signature, taskState)
 }
 
 // SetStateReceived updates task state to RECEIVED
@@ -174,7 +222,7 @@ func (b *Backend) SetStateReceived(signature *tasks.Signature) error {
 
 	taskState := tasks.NewReceivedTaskState(signature)
 	b.mergeNewTaskState(conn, taskState)
-	return b.updateState(conn, taskState)
+	return b.updateState(conn, signature, taskState)
 }
 
 // SetStateStarted updates task state to STARTED
@@ -184,7 +232,7 @@ func (b *Backend) SetStateStarted(signature *tasks.Signature) error {
 
 	taskState := tasks.NewStartedTaskState(signature)
 	b.mergeNewTaskState(conn, taskState)
-	return b.updateState(conn, taskState)
+	return b.updateState(conn, signature, taskState)
 }
 
 // SetStateRetry updates task state to RETRY
@@ -194,7 +242,7 @@ func (b *Backend) SetStateRetry(signature *tasks.Signature) error {
 
 	taskState := tasks.NewRetryTaskState(signature)
 	b.mergeNewTaskState(conn, taskState)
-	return b.updateState(conn, taskState)
+	return b.updateState(conn, signature, taskState)
 }
 
 // SetStateSuccess updates task state to SUCCESS
@@ -204,7 +252,7 @@ func (b *Backend) SetStateSuccess(signature *tasks.Signature, results []*tasks.T
 
 	taskState := tasks.NewSuccessTaskState(signature, results)
 	b.mergeNewTaskState(conn, taskState)
-	return b.updateState(conn, taskState)
+	return b.updateState(conn, signature, taskState)
 }
 
 // SetStateFailure updates task state to FAILURE
@@ -214,7 +262,43 @@ func (b *Backend) SetStateFailure(signature *tasks.Signature, err string) error
 
 	taskState := tasks.NewFailureTaskState(signature, err)
 	b.mergeNewTaskState(conn, taskState)
-	return b.updateState(conn, taskState)
+	return b.updateState(conn, signature, taskState)
+}
+
+// SetStateTimedOut updates task state to TIMED_OUT
+func (b *Backend) SetStateTimedOut(signature *tasks.Signature) error {
+	conn := b.open()
+	defer conn.Close()
+
+	taskState := tasks.NewTimedOutTaskState(signature)
+	b.mergeNewTaskState(conn, taskState)
+	return b.updateState(conn, signature, taskState)
+}
+
+// SetProgress stores the progress along with the latest task state
+func (b *Backend) SetProgress(signature *tasks.Signature, progress *tasks.Progress) error {
+	conn := b.open()
+	defer conn.Close()
+
+	taskState, err := b.getState(conn, signature.UUID)
+	if err != nil {
+		return err
+	}
+	taskState.Progress = progress
+	return b.updateState(conn, signature, taskState)
+}
+
+// SetDiagnostics stores the diagnostics along with the latest task state
+func (b *Backend) SetDiagnostics(signature *tasks.Signature, diagnostics *tasks.Diagnostics) error {
+	conn := b.open()
+	defer conn.Close()
+
+	taskState, err := b.getState(conn, signature.UUID)
+	if err != nil {
+		return err
+	}
+	taskState.Diagnostics = diagnostics
+	return b.updateState(conn, signature, taskState)
 }
 
 // GetState returns the latest task state
@@ -226,14 +310,12 @@ func (b *Backend) GetState(taskUUID string) (*tasks.TaskState, error) {
 }
 
 func (b *Backend) getState(conn redis.Conn, taskUUID string) (*tasks.TaskState, error) {
-	item, err := redis.Bytes(conn.Do("GET", taskUUID))
+	item, err := redis.Bytes(conn.Do("GET", b.GetConfig().Namespaced(taskUUID)))
 	if err != nil {
 		return nil, err
 	}
-	state := new(tasks.TaskState)
-	decoder := json.NewDecoder(bytes.NewReader(item))
-	decoder.UseNumber()
-	if err := decoder.Decode(state); err != nil {
+	state, err := b.DecodeTaskState(item)
+	if err != nil {
 		return nil, err
 	}
 
@@ -245,7 +327,7 @@ func (b *Backend) PurgeState(taskUUID string) error {
 	conn := b.open()
 	defer conn.Close()
 
-	_, err := conn.Do("DEL", taskUUID)
+	_, err := conn.Do("DEL", b.GetConfig().Namespaced(taskUUID))
 	if err != nil {
 		return err
 	}
@@ -258,7 +340,7 @@ func (b *Backend) PurgeGroupMeta(groupUUID string) error {
 	conn := b.open()
 	defer conn.Close()
 
-	_, err := conn.Do("DEL", groupUUID)
+	_, err := conn.Do("DEL", b.GetConfig().Namespaced(groupUUID))
 	if err != nil {
 		return err
 	}
@@ -266,10 +348,292 @@ func (b *Backend) PurgeGroupMeta(groupUUID string) error {
 	return nil
 }
 
+// ReportInFlight stores the report of the worker in a hash shared by the
+// fleet, which expires along with the latest report
+func (b *Backend) ReportInFlight(workerID string, counts map[string]int, ttl time.Duration) error {
+	conn := b.open()
+	defer conn.Close()
+
+	key := b.GetConfig().Namespaced(inFlightKey)
+	if len(counts) == 0 {
+		_, err := conn.Do("HDEL", key, workerID)
+		return err
+	}
+
+	encoded, err := json.Marshal(&inFlightReport{Counts: counts, ExpiresAt: time.Now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+
+	conn.Send("MULTI")
+	conn.Send("HSET", key, workerID, encoded)
+	conn.Send("PEXPIRE", key, ttl.Milliseconds())
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+// InFlight sums the reports of the workers which didn't expire, expired
+// reports are removed
+func (b *Backend) InFlight() (map[string]int, error) {
+	conn := b.open()
+	defer conn.Close()
+
+	key := b.GetConfig().Namespaced(inFlightKey)
+	reports, err := redis.StringMap(conn.Do("HGETALL", key))
+	if err != nil {
+		return nil, err
+	}
+
+	total, expired, err := sumInFlight(reports)
+	if err != nil {
+		return nil, err
+	}
+	if len(expired) > 0 {
+		if _, err := conn.Do("HDEL", redis.Args{key}.AddFlat(expired)...); err != nil {
+			b.Logger().WARNING.Printf("Failed to remove expired in-flight reports: %s", err)
+		}
+	}
+	return total, nil
+}
+
+// ReportWorker stores the heartbeat of the worker in a hash shared by the
+// fleet, which expires along with the latest heartbeat
+func (b *Backend) ReportWorker(workerID string, worker *tasks.WorkerInfo, ttl time.Duration) error {
+	conn := b.open()
+	defer conn.Close()
+
+	key := b.GetConfig().Namespaced(workersKey)
+	if worker == nil {
+		_, err := conn.Do("HDEL", key, workerID)
+		return err
+	}
+
+	encoded, err := json.Marshal(&workerHeartbeat{Worker: worker, ExpiresAt: time.Now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+
+	conn.Send("MULTI")
+	conn.Send("HSET", key, workerID, encoded)
+	conn.Send("PEXPIRE", key, ttl.Milliseconds())
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+// Workers returns the heartbeats of the workers which didn't expire, expired
+// heartbeats are removed
+func (b *Backend) Workers() ([]*tasks.WorkerInfo, error) {
+	conn := b.open()
+	defer conn.Close()
+
+	key := b.GetConfig().Namespaced(workersKey)
+	heartbeats, err := redis.StringMap(conn.Do("HGETALL", key))
+	if err != nil {
+		return nil, err
+	}
+
+	workers, expired, err := decodeWorkers(heartbeats)
+	if err != nil {
+		return nil, err
+	}
+	if len(expired) > 0 {
+		if _, err := conn.Do("HDEL", redis.Args{key}.AddFlat(expired)...); err != nil {
+			b.Logger().WARNING.Printf("Failed to remove expired heartbeats of workers: %s", err)
+		}
+	}
+	return workers, nil
+}
+
+// PublishControl stores the control message in a sorted set shared by the
+// fleet, which keeps the latest messages and expires along with the latest
+// one
+func (b *Backend) PublishControl(message *tasks.ControlMessage, ttl time.Duration) error {
+	conn := b.open()
+	defer conn.Close()
+
+	id, err := redis.Int64(conn.Do("INCR", b.GetConfig().Namespaced(controlIDKey)))
+	if err != nil {
+		return err
+	}
+	message.ID = id
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	key := b.GetConfig().Namespaced(controlKey)
+	conn.Send("MULTI")
+	conn.Send("ZADD", key, id, encoded)
+	conn.Send("ZREMRANGEBYRANK", key, 0, -maxControlMessages-1)
+	conn.Send("PEXPIRE", key, ttl.Milliseconds())
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+// ControlMessages returns the control messages whose ID is greater than
+// afterID
+func (b *Backend) ControlMessages(afterID int64) ([]*tasks.ControlMessage, error) {
+	conn := b.open()
+	defer conn.Close()
+
+	values, err := redis.Strings(conn.Do("ZRANGEBYSCORE", b.GetConfig().Namespaced(controlKey), fmt.Sprintf("(%d", afterID), "+inf"))
+	if err != nil {
+		return nil, err
+	}
+	return decodeControlMessages(values)
+}
+
+// ReplyControl stores the reply of the worker in the hash of the replies to
+// the control message
+func (b *Backend) ReplyControl(reply *tasks.ControlReply, ttl time.Duration) error {
+	if reply.Worker == nil {
+		return errors.New("Control reply has no worker")
+	}
+	encoded, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+
+	conn := b.open()
+	defer conn.Close()
+
+	key := b.GetConfig().Namespaced(fmt.Sprintf("%s%d", controlRepliesKeyPrefix, reply.MessageID))
+	conn.Send("MULTI")
+	conn.Send("HSET", key, reply.Worker.ID, encoded)
+	conn.Send("PEXPIRE", key, ttl.Milliseconds())
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+// ControlReplies returns the replies to the control message
+func (b *Backend) ControlReplies(messageID int64) ([]*tasks.ControlReply, error) {
+	conn := b.open()
+	defer conn.Close()
+
+	key := b.GetConfig().Namespaced(fmt.Sprintf("%s%d", controlRepliesKeyPrefix, messageID))
+	values, err := redis.StringMap(conn.Do("HGETALL", key))
+	if err != nil {
+		return nil, err
+	}
+	return decodeControlReplies(values)
+}
+
+// RevokeTask marks the task revoked until results expire
+func (b *Backend) RevokeTask(taskUUID string) error {
+	conn := b.open()
+	defer conn.Close()
+
+	expiration := int64(b.getExpiration(nil).Seconds())
+	_, err := conn.Do("SET", b.GetConfig().Namespaced(revokedKeyPrefix+taskUUID), 1, "EX", expiration)
+	return err
+}
+
+// IsRevoked returns true if the task was revoked
+func (b *Backend) IsRevoked(taskUUID string) (bool, error) {
+	conn := b.open()
+	defer conn.Close()
+
+	return redis.Bool(conn.Do("EXISTS", b.GetConfig().Namespaced(revokedKeyPrefix+taskUUID)))
+}
+
+// ClaimIdempotencyKey stores the UUID of the task under its idempotency key
+// unless another task owns the key, and returns the UUID of the owner
+func (b *Backend) ClaimIdempotencyKey(signature *tasks.Signature) (string, error) {
+	conn := b.open()
+	defer conn.Close()
+
+	key := b.GetConfig().Namespaced(idempotencyKeyPrefix + signature.IdempotencyKey)
+	expiration := int64(b.getExpiration(signature).Seconds())
+	return redis.String(claimIdempotencyKeyScript.Do(conn, key, signature.UUID, expiration))
+}
+
+// IdempotencyKeyOwner returns the UUID of the task owning the key
+func (b *Backend) IdempotencyKeyOwner(key string) (string, error) {
+	conn := b.open()
+	defer conn.Close()
+
+	owner, err := redis.String(conn.Do("GET", b.GetConfig().Namespaced(idempotencyKeyPrefix+key)))
+	if err == redis.ErrNil {
+		return "", nil
+	}
+	return owner, err
+}
+
+// ReleaseIdempotencyKey removes the key if the task still owns it
+func (b *Backend) ReleaseIdempotencyKey(key, taskUUID string) error {
+	conn := b.open()
+	defer conn.Close()
+
+	_, err := releaseIdempotencyKeyScript.Do(conn, b.GetConfig().Namespaced(idempotencyKeyPrefix+key), taskUUID)
+	return err
+}
+
+// PutDeadLetter stores the dead letter in the hash of the dead-letter queue,
+// replacing one with the same ID
+func (b *Backend) PutDeadLetter(deadLetter *tasks.DeadLetter) error {
+	encoded, err := json.Marshal(deadLetter)
+	if err != nil {
+		return err
+	}
+
+	conn := b.open()
+	defer conn.Close()
+
+	_, err = conn.Do("HSET", b.DeadLetterQueue(), deadLetter.ID, encoded)
+	return err
+}
+
+// ListDeadLetters returns the dead letters matching the filter, newest first
+func (b *Backend) ListDeadLetters(filter *tasks.DeadLetterFilter) ([]*tasks.DeadLetter, error) {
+	conn := b.open()
+	defer conn.Close()
+
+	values, err := redis.ByteSlices(conn.Do("HVALS", b.DeadLetterQueue()))
+	if err != nil {
+		return nil, err
+	}
+	return filterDeadLetters(filter, values)
+}
+
+// DeleteDeadLetter removes the dead letter from the hash of the dead-letter
+// queue
+func (b *Backend) DeleteDeadLetter(id string) error {
+	conn := b.open()
+	defer conn.Close()
+
+	_, err := conn.Do("HDEL", b.DeadLetterQueue(), id)
+	return err
+}
+
+// SetLastRun stores when the periodic task or workflow last ran in a hash
+// shared by the servers
+func (b *Backend) SetLastRun(name string, lastRun time.Time) error {
+	conn := b.open()
+	defer conn.Close()
+
+	_, err := conn.Do("HSET", b.GetConfig().Namespaced(lastRunsKey), name, lastRun.UnixNano())
+	return err
+}
+
+// LastRun returns when the periodic task or workflow last ran
+func (b *Backend) LastRun(name string) (time.Time, error) {
+	conn := b.open()
+	defer conn.Close()
+
+	lastRun, err := redis.Int64(conn.Do("HGET", b.GetConfig().Namespaced(lastRunsKey), name))
+	if err == redis.ErrNil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, lastRun), nil
+}
+
 // getGroupMeta retrieves group meta data, convenience function to avoid repetition
 func (b *Backend) getGroupMeta(conn redis.Conn, groupUUID string) (*tasks.GroupMeta, error) {
 
-	item, err := redis.Bytes(conn.Do("GET", groupUUID))
+	item, err := redis.Bytes(conn.Do("GET", b.GetConfig().Namespaced(groupUUID)))
 	if err != nil {
 		return nil, err
 	}
@@ -291,7 +655,7 @@ func (b *Backend) getStates(conn redis.Conn, taskUUIDs ...string) ([]*tasks.Task
 	// conn.Do requires []interface{}... can't pass []string unfortunately
 	taskUUIDInterfaces := make([]interface{}, len(taskUUIDs))
 	for i, taskUUID := range taskUUIDs {
-		taskUUIDInterfaces[i] = interface{}(taskUUID)
+		taskUUIDInterfaces[i] = interface{}(b.GetConfig().Namespaced(taskUUID))
 	}
 
 	reply, err := redis.Values(conn.Do("MGET", taskUUIDInterfaces...))
@@ -305,11 +669,9 @@ func (b *Backend) getStates(conn redis.Conn, taskUUIDs ...string) ([]*tasks.Task
 			return taskStates, fmt.Errorf("Expected byte array, instead got: %v", value)
 		}
 
-		taskState := new(tasks.TaskState)
-		decoder := json.NewDecoder(bytes.NewReader(stateBytes))
-		decoder.UseNumber()
-		if err := decoder.Decode(taskState); err != nil {
-			log.ERROR.Print(err)
+		taskState, err := b.DecodeTaskState(stateBytes)
+		if err != nil {
+			b.Logger().ERROR.Print(err)
 			return taskStates, err
 		}
 
@@ -320,14 +682,14 @@ func (b *Backend) getStates(conn redis.Conn, taskUUIDs ...string) ([]*tasks.Task
 }
 
 // updateState saves current task state
-func (b *Backend) updateState(conn redis.Conn, taskState *tasks.TaskState) error {
-	encoded, err := json.Marshal(taskState)
+func (b *Backend) updateState(conn redis.Conn, signature *tasks.Signature, taskState *tasks.TaskState) error {
+	encoded, err := b.EncodeTaskState(taskState)
 	if err != nil {
 		return err
 	}
 
-	expiration := int64(b.getExpiration().Seconds())
-	_, err = conn.Do("SET", taskState.TaskUUID, encoded, "EX", expiration)
+	expiration := int64(b.getExpiration(signature).Seconds())
+	_, err = conn.Do("SET", b.GetConfig().Namespaced(taskState.TaskUUID), encoded, "EX", expiration)
 	if err != nil {
 		return err
 	}
@@ -335,15 +697,165 @@ func (b *Backend) updateState(conn redis.Conn, taskState *tasks.TaskState) error
 	return nil
 }
 
-// getExpiration returns expiration for a stored task state
-func (b *Backend) getExpiration() time.Duration {
-	expiresIn := b.GetConfig().ResultsExpireIn
-	if expiresIn == 0 {
-		// expire results after 1 hour by default
-		expiresIn = config.DefaultResultsExpireIn
+// idempotencyKeyPrefix prefixes the keys holding the UUIDs of the tasks
+// owning idempotency keys
+const idempotencyKeyPrefix = "machinery_idempotency_"
+
+// claimIdempotencyKeySource sets the owner of the key unless it has one, and
+// returns the owner
+const claimIdempotencyKeySource = `
+local owner = redis.call("get", KEYS[1])
+if owner then
+	return owner
+end
+redis.call("set", KEYS[1], ARGV[1], "EX", ARGV[2])
+return ARGV[1]
+`
+
+// releaseIdempotencyKeySource deletes the key only if the task still owns it
+const releaseIdempotencyKeySource = `
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`
+
+var (
+	claimIdempotencyKeyScript   = redis.NewScript(1, claimIdempotencyKeySource)
+	releaseIdempotencyKeyScript = redis.NewScript(1, releaseIdempotencyKeySource)
+)
+
+// revokedKeyPrefix prefixes the keys marking tasks revoked
+const revokedKeyPrefix = "machinery_revoked_"
+
+// inFlightKey is the hash of the in-flight reports of the workers
+const inFlightKey = "machinery_in_flight"
+
+// lastRunsKey is the hash of the times periodic tasks last ran
+const lastRunsKey = "machinery_periodic_last_runs"
+
+// workersKey is the hash of the heartbeats of the workers
+const workersKey = "machinery_workers"
+
+const (
+	// controlKey is the sorted set of the control messages scored by ID
+	controlKey = "machinery_control"
+	// controlIDKey is the counter of the IDs of control messages, it never
+	// expires so IDs keep increasing
+	controlIDKey = "machinery_control_id"
+	// controlRepliesKeyPrefix prefixes the hashes of the replies to control
+	// messages
+	controlRepliesKeyPrefix = "machinery_control_replies_"
+	// maxControlMessages is how many of the latest control messages are kept
+	maxControlMessages = 100
+)
+
+// inFlightReport is the report of a worker stored in the hash
+type inFlightReport struct {
+	Counts    map[string]int `json:"counts"`
+	ExpiresAt time.Time      `json:"expires_at"`
+}
+
+// workerHeartbeat is the heartbeat of a worker stored in the hash
+type workerHeartbeat struct {
+	Worker    *tasks.WorkerInfo `json:"worker"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// filterDeadLetters decodes the stored dead letters and returns the ones
+// matching the filter, newest first
+func filterDeadLetters(filter *tasks.DeadLetterFilter, values [][]byte) ([]*tasks.DeadLetter, error) {
+	deadLetters := make([]*tasks.DeadLetter, 0, len(values))
+	for _, value := range values {
+		deadLetter := new(tasks.DeadLetter)
+		if err := json.Unmarshal(value, deadLetter); err != nil {
+			return nil, fmt.Errorf("Failed to unmarshal dead letter: %s", err)
+		}
+		if filter.Match(deadLetter) {
+			deadLetters = append(deadLetters, deadLetter)
+		}
+	}
+	return filter.Page(deadLetters), nil
+}
+
+// sumInFlight sums the counts of the reports which didn't expire and returns
+// the workers whose reports expired
+func sumInFlight(reports map[string]string) (map[string]int, []string, error) {
+	now := time.Now()
+	total := make(map[string]int)
+	var expired []string
+	for workerID, encoded := range reports {
+		report := new(inFlightReport)
+		if err := json.Unmarshal([]byte(encoded), report); err != nil {
+			return nil, nil, fmt.Errorf("Failed to unmarshal in-flight report of worker %s: %s", workerID, err)
+		}
+		if now.After(report.ExpiresAt) {
+			expired = append(expired, workerID)
+			continue
+		}
+		for name, count := range report.Counts {
+			total[name] += count
+		}
+	}
+	return total, expired, nil
+}
+
+// decodeWorkers decodes the heartbeats which didn't expire, sorted by ID,
+// and returns the workers whose heartbeats expired
+func decodeWorkers(heartbeats map[string]string) ([]*tasks.WorkerInfo, []string, error) {
+	now := time.Now()
+	workers := make([]*tasks.WorkerInfo, 0, len(heartbeats))
+	var expired []string
+	for workerID, encoded := range heartbeats {
+		heartbeat := new(workerHeartbeat)
+		if err := json.Unmarshal([]byte(encoded), heartbeat); err != nil {
+			return nil, nil, fmt.Errorf("Failed to unmarshal heartbeat of worker %s: %s", workerID, err)
+		}
+		if now.After(heartbeat.ExpiresAt) || heartbeat.Worker == nil {
+			expired = append(expired, workerID)
+			continue
+		}
+		workers = append(workers, heartbeat.Worker)
+	}
+	sort.Slice(workers, func(i, j int) bool { return workers[i].ID < workers[j].ID })
+	return workers, expired, nil
+}
+
+// decodeControlMessages decodes the stored control messages
+func decodeControlMessages(values []string) ([]*tasks.ControlMessage, error) {
+	messages := make([]*tasks.ControlMessage, 0, len(values))
+	for _, encoded := range values {
+		message := new(tasks.ControlMessage)
+		if err := json.Unmarshal([]byte(encoded), message); err != nil {
+			return nil, fmt.Errorf("Failed to unmarshal control message: %s", err)
+		}
+		messages = append(messages, message)
 	}
+	return messages, nil
+}
+
+// decodeControlReplies decodes the stored replies, sorted by worker ID
+func decodeControlReplies(values map[string]string) ([]*tasks.ControlReply, error) {
+	replies := make([]*tasks.ControlReply, 0, len(values))
+	for workerID, encoded := range values {
+		reply := new(tasks.ControlReply)
+		if err := json.Unmarshal([]byte(encoded), reply); err != nil {
+			return nil, fmt.Errorf("Failed to unmarshal control reply of worker %s: %s", workerID, err)
+		}
+		replies = append(replies, reply)
+	}
+	sort.Slice(replies, func(i, j int) bool { return replies[i].Worker.ID < replies[j].Worker.ID })
+	return replies, nil
+}
+
+// getExpiration returns expiration for a stored task state
+func (b *Backend) getExpiration(signature *tasks.Signature) time.Duration {
+	return time.Duration(b.ResultsExpireIn(signature)) * time.Second
+}
 
-	return time.Duration(expiresIn) * time.Second
+// getGroupMetaExpiration returns expiration for stored group meta data
+func (b *Backend) getGroupMetaExpiration() time.Duration {
+	return time.Duration(b.GroupMetaExpireIn()) * time.Second
 }
 
 // open returns or creates instance of Redis connection
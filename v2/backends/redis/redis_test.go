@@ -4,6 +4,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/RichardKnop/machinery/v2/backends/iface"
 	"github.com/RichardKnop/machinery/v2/backends/redis"
 	"github.com/RichardKnop/machinery/v2/config"
 	"github.com/RichardKnop/machinery/v2/tasks"
@@ -158,3 +159,124 @@ func TestPurgeState(t *testing.T) {
 	assert.Nil(t, taskState)
 	assert.Error(t, err)
 }
+
+func TestInitGroupPending(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL")
+	redisUsername := os.Getenv("REDIS_USER")
+	redisPassword := os.Getenv("REDIS_PASSWORD")
+	if redisURL == "" {
+		t.Skip("REDIS_URL is not defined")
+	}
+
+	backend := redis.New(new(config.Config), redisURL, redisUsername, redisPassword, "", 0)
+
+	groupUUID := "testBatchGroupUUID"
+	signatures := []*tasks.Signature{
+		{UUID: "testBatchTaskUUID1", GroupUUID: groupUUID, Name: "foo"},
+		{UUID: "testBatchTaskUUID2", GroupUUID: groupUUID, Name: "bar"},
+	}
+	assert.NoError(t, backend.(iface.BatchBackend).InitGroupPending(groupUUID, signatures))
+
+	taskStates, err := backend.GroupTaskStates(groupUUID, len(signatures))
+	if assert.NoError(t, err) && assert.Len(t, taskStates, len(signatures)) {
+		for i, taskState := range taskStates {
+			assert.Equal(t, signatures[i].UUID, taskState.TaskUUID)
+			assert.Equal(t, tasks.StatePending, taskState.State)
+		}
+	}
+}
+
+func TestInFlight(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL")
+	redisUsername := os.Getenv("REDIS_USER")
+	redisPassword := os.Getenv("REDIS_PASSWORD")
+	if redisURL == "" {
+		t.Skip("REDIS_URL is not defined")
+	}
+
+	backend := redis.New(&config.Config{Namespace: "test_in_flight"}, redisURL, redisUsername, redisPassword, "", 0).(iface.InFlightBackend)
+	testInFlight(t, backend)
+}
+
+func TestWorkers(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL")
+	redisUsername := os.Getenv("REDIS_USER")
+	redisPassword := os.Getenv("REDIS_PASSWORD")
+	if redisURL == "" {
+		t.Skip("REDIS_URL is not defined")
+	}
+
+	backend := redis.New(&config.Config{Namespace: "test_workers"}, redisURL, redisUsername, redisPassword, "", 0).(iface.PresenceBackend)
+	testWorkers(t, backend)
+}
+
+func TestControl(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL")
+	redisUsername := os.Getenv("REDIS_USER")
+	redisPassword := os.Getenv("REDIS_PASSWORD")
+	if redisURL == "" {
+		t.Skip("REDIS_URL is not defined")
+	}
+
+	backend := redis.New(&config.Config{Namespace: "test_control"}, redisURL, redisUsername, redisPassword, "", 0).(iface.ControlBackend)
+	testControl(t, backend)
+}
+
+func TestRevokeTask(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL")
+	redisUsername := os.Getenv("REDIS_USER")
+	redisPassword := os.Getenv("REDIS_PASSWORD")
+	if redisURL == "" {
+		t.Skip("REDIS_URL is not defined")
+	}
+
+	backend := redis.New(&config.Config{Namespace: "test_revoke"}, redisURL, redisUsername, redisPassword, "", 0).(iface.RevokeBackend)
+	testRevokeTask(t, backend)
+}
+
+func TestSetProgress(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL")
+	redisUsername := os.Getenv("REDIS_USER")
+	redisPassword := os.Getenv("REDIS_PASSWORD")
+	if redisURL == "" {
+		t.Skip("REDIS_URL is not defined")
+	}
+
+	testSetProgress(t, redis.New(&config.Config{Namespace: "test_progress"}, redisURL, redisUsername, redisPassword, "", 0))
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL")
+	redisUsername := os.Getenv("REDIS_USER")
+	redisPassword := os.Getenv("REDIS_PASSWORD")
+	if redisURL == "" {
+		t.Skip("REDIS_URL is not defined")
+	}
+
+	backend := redis.New(&config.Config{Namespace: "test_idempotency"}, redisURL, redisUsername, redisPassword, "", 0).(iface.IdempotencyBackend)
+	testIdempotencyKeys(t, backend)
+}
+
+func TestDeadLetters(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL")
+	redisUsername := os.Getenv("REDIS_USER")
+	redisPassword := os.Getenv("REDIS_PASSWORD")
+	if redisURL == "" {
+		t.Skip("REDIS_URL is not defined")
+	}
+
+	backend := redis.New(&config.Config{Namespace: "test_dead_letters"}, redisURL, redisUsername, redisPassword, "", 0).(iface.DeadLetterBackend)
+	testDeadLetters(t, backend)
+}
+
+func TestLastRuns(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL")
+	redisUsername := os.Getenv("REDIS_USER")
+	redisPassword := os.Getenv("REDIS_PASSWORD")
+	if redisURL == "" {
+		t.Skip("REDIS_URL is not defined")
+	}
+
+	backend := redis.New(&config.Config{Namespace: "test_last_runs"}, redisURL, redisUsername, redisPassword, "", 0).(iface.ScheduleBackend)
+	testLastRuns(t, backend)
+}
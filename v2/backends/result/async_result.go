@@ -1,6 +1,7 @@
 package result
 
 import (
+	"context"
 	"errors"
 	"reflect"
 	"time"
@@ -45,17 +46,22 @@ func NewAsyncResult(signature *tasks.Signature, backend iface.Backend) *AsyncRes
 	}
 }
 
-// NewChordAsyncResult creates ChordAsyncResult instance
+// NewChordAsyncResult creates ChordAsyncResult instance. The chord callback
+// may be nil for a workflow ending with a group, Get then only waits for
+// the group tasks to finish and returns no results.
 func NewChordAsyncResult(groupTasks []*tasks.Signature, chordCallback *tasks.Signature, backend iface.Backend) *ChordAsyncResult {
 	asyncResults := make([]*AsyncResult, len(groupTasks))
 	for i, task := range groupTasks {
 		asyncResults[i] = NewAsyncResult(task, backend)
 	}
-	return &ChordAsyncResult{
+	chordAsyncResult := &ChordAsyncResult{
 		groupAsyncResults: asyncResults,
-		chordAsyncResult:  NewAsyncResult(chordCallback, backend),
 		backend:           backend,
 	}
+	if chordCallback != nil {
+		chordAsyncResult.chordAsyncResult = NewAsyncResult(chordCallback, backend)
+	}
+	return chordAsyncResult
 }
 
 // NewChainAsyncResult creates ChainAsyncResult instance
@@ -87,6 +93,10 @@ func (asyncResult *AsyncResult) Touch() ([]reflect.Value, error) {
 		return nil, errors.New(asyncResult.taskState.Error)
 	}
 
+	if asyncResult.taskState.IsTimedOut() {
+		return nil, tasks.ErrGroupTimedOut
+	}
+
 	if asyncResult.taskState.IsSuccess() {
 		return tasks.ReflectTaskResults(asyncResult.taskState.Results)
 	}
@@ -94,8 +104,13 @@ func (asyncResult *AsyncResult) Touch() ([]reflect.Value, error) {
 	return nil, nil
 }
 
-// Get returns task results (synchronous blocking call)
+// Get returns task results (synchronous blocking call). Backends pushing
+// state changes are waited on, others are polled every sleepDuration.
 func (asyncResult *AsyncResult) Get(sleepDuration time.Duration) ([]reflect.Value, error) {
+	if ok, results, err := asyncResult.watch(context.Background()); ok {
+		return results, err
+	}
+
 	for {
 		results, err := asyncResult.Touch()
 
@@ -111,6 +126,12 @@ func (asyncResult *AsyncResult) Get(sleepDuration time.Duration) ([]reflect.Valu
 func (asyncResult *AsyncResult) GetWithTimeout(timeoutDuration, sleepDuration time.Duration) ([]reflect.Value, error) {
 	timeout := time.NewTimer(timeoutDuration)
 
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutDuration)
+	defer cancel()
+	if ok, results, err := asyncResult.watch(ctx); ok {
+		return results, err
+	}
+
 	for {
 		select {
 		case <-timeout.C:
@@ -127,6 +148,51 @@ func (asyncResult *AsyncResult) GetWithTimeout(timeoutDuration, sleepDuration ti
 	}
 }
 
+// GetInto waits for the task to complete and decodes its results into the
+// value v points to, e.g. a struct the task returned, see
+// tasks.DecodeResults (synchronous blocking call)
+func (asyncResult *AsyncResult) GetInto(v interface{}, sleepDuration time.Duration) error {
+	results, err := asyncResult.Get(sleepDuration)
+	if err != nil {
+		return err
+	}
+	return tasks.DecodeResults(results, v)
+}
+
+// GetIntoWithTimeout is GetInto with a timeout (synchronous blocking call)
+func (asyncResult *AsyncResult) GetIntoWithTimeout(v interface{}, timeoutDuration, sleepDuration time.Duration) error {
+	results, err := asyncResult.GetWithTimeout(timeoutDuration, sleepDuration)
+	if err != nil {
+		return err
+	}
+	return tasks.DecodeResults(results, v)
+}
+
+// watch waits for the task to complete if the backend can push state
+// changes. It returns false if the caller should poll the state instead.
+func (asyncResult *AsyncResult) watch(ctx context.Context) (bool, []reflect.Value, error) {
+	if asyncResult.backend == nil || asyncResult.backend.IsAMQP() || asyncResult.taskState.IsCompleted() {
+		return false, nil, nil
+	}
+	watcher, ok := asyncResult.backend.(iface.WatchBackend)
+	if !ok {
+		return false, nil, nil
+	}
+
+	taskState, err := watcher.WaitCompleted(ctx, asyncResult.Signature.UUID)
+	if err != nil {
+		if ctx.Err() != nil {
+			return true, nil, ErrTimeoutReached
+		}
+		// e.g. MongoDB not running as a replica set, fall back to polling
+		return false, nil, nil
+	}
+
+	asyncResult.taskState = taskState
+	results, err := asyncResult.Touch()
+	return true, results, err
+}
+
 // GetState returns latest task state
 func (asyncResult *AsyncResult) GetState() *tasks.TaskState {
 	if asyncResult.taskState.IsCompleted() {
@@ -141,6 +207,31 @@ func (asyncResult *AsyncResult) GetState() *tasks.TaskState {
 	return asyncResult.taskState
 }
 
+// Progress returns the progress the task reported last, nil if it reported
+// none or it is no longer running
+func (asyncResult *AsyncResult) Progress() (*tasks.Progress, error) {
+	if asyncResult.backend == nil {
+		return nil, ErrBackendNotConfigured
+	}
+
+	taskState, err := asyncResult.backend.GetState(asyncResult.Signature.UUID)
+	if err != nil {
+		return nil, err
+	}
+	return taskState.Progress, nil
+}
+
+// Durations returns how long the task waited to be started and how long it
+// ran, zero while it is not known yet
+func (asyncResult *AsyncResult) Durations() (queueWait, duration time.Duration, err error) {
+	if asyncResult.backend == nil {
+		return 0, 0, ErrBackendNotConfigured
+	}
+
+	taskState := asyncResult.GetState()
+	return taskState.QueueWait, taskState.Duration, nil
+}
+
 // Get returns results of a chain of tasks (synchronous blocking call)
 func (chainAsyncResult *ChainAsyncResult) Get(sleepDuration time.Duration) ([]reflect.Value, error) {
 	if chainAsyncResult.backend == nil {
@@ -176,6 +267,10 @@ func (chordAsyncResult *ChordAsyncResult) Get(sleepDuration time.Duration) ([]re
 		}
 	}
 
+	if chordAsyncResult.chordAsyncResult == nil {
+		return nil, nil
+	}
+
 	return chordAsyncResult.chordAsyncResult.Get(sleepDuration)
 }
 
@@ -236,11 +331,21 @@ func (chordAsyncResult *ChordAsyncResult) GetWithTimeout(timeoutDuration, sleepD
 		case <-timeout.C:
 			return nil, ErrTimeoutReached
 		default:
+			groupCompleted := true
 			for _, asyncResult := range chordAsyncResult.groupAsyncResults {
 				_, errcur := asyncResult.Touch()
 				if errcur != nil {
 					return nil, err
 				}
+				groupCompleted = groupCompleted && asyncResult.GetState().IsCompleted()
+			}
+
+			if chordAsyncResult.chordAsyncResult == nil {
+				if groupCompleted {
+					return nil, nil
+				}
+				time.Sleep(sleepDuration)
+				continue
 			}
 
 			results, err = chordAsyncResult.chordAsyncResult.Touch()
@@ -0,0 +1,128 @@
+package result_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/backends/iface"
+	"github.com/RichardKnop/machinery/v2/backends/memory"
+	"github.com/RichardKnop/machinery/v2/backends/result"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+// watchingBackend pushes the states sent to it to waiting callers
+type watchingBackend struct {
+	iface.Backend
+	states chan *tasks.TaskState
+	err    error
+}
+
+func (b *watchingBackend) WaitCompleted(ctx context.Context, taskUUID string) (*tasks.TaskState, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	select {
+	case state := <-b.states:
+		return state, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func newWatchingBackend() *watchingBackend {
+	return &watchingBackend{
+		Backend: memory.New(new(config.Config)),
+		states:  make(chan *tasks.TaskState, 1),
+	}
+}
+
+func TestGetWaitsForPushedState(t *testing.T) {
+	t.Parallel()
+
+	backend := newWatchingBackend()
+	signature := &tasks.Signature{UUID: "task_1"}
+	backend.states <- &tasks.TaskState{
+		TaskUUID: signature.UUID,
+		State:    tasks.StateSuccess,
+		Results:  []*tasks.TaskResult{{Type: "int64", Value: int64(2)}},
+	}
+
+	// Polling would sleep for an hour
+	results, err := result.NewAsyncResult(signature, backend).Get(time.Hour)
+	if assert.NoError(t, err) && assert.Len(t, results, 1) {
+		assert.Equal(t, int64(2), results[0].Interface())
+	}
+}
+
+func TestGetWithTimeoutWaitsForPushedState(t *testing.T) {
+	t.Parallel()
+
+	backend := newWatchingBackend()
+	signature := &tasks.Signature{UUID: "task_1"}
+
+	_, err := result.NewAsyncResult(signature, backend).GetWithTimeout(50*time.Millisecond, time.Hour)
+	assert.Equal(t, result.ErrTimeoutReached, err)
+
+	backend.states <- &tasks.TaskState{TaskUUID: signature.UUID, State: tasks.StateFailure, Error: "boom"}
+	_, err = result.NewAsyncResult(signature, backend).GetWithTimeout(time.Second, time.Hour)
+	assert.EqualError(t, err, "boom")
+}
+
+func TestGetFallsBackToPolling(t *testing.T) {
+	t.Parallel()
+
+	backend := newWatchingBackend()
+	backend.err = errors.New("change streams are not supported")
+
+	signature := &tasks.Signature{UUID: "task_1"}
+	assert.NoError(t, backend.SetStateSuccess(signature, []*tasks.TaskResult{{Type: "string", Value: "foo"}}))
+
+	results, err := result.NewAsyncResult(signature, backend).GetWithTimeout(time.Second, 10*time.Millisecond)
+	if assert.NoError(t, err) && assert.Len(t, results, 1) {
+		assert.Equal(t, "foo", results[0].Interface())
+	}
+}
+
+func TestGetTimedOut(t *testing.T) {
+	t.Parallel()
+
+	backend := memory.New(new(config.Config))
+	signature := &tasks.Signature{UUID: "task_1"}
+	assert.NoError(t, backend.(iface.TimeoutBackend).SetStateTimedOut(signature))
+
+	_, err := result.NewAsyncResult(signature, backend).Get(time.Hour)
+	assert.Equal(t, tasks.ErrGroupTimedOut, err)
+}
+
+func TestGetInto(t *testing.T) {
+	t.Parallel()
+
+	backend := memory.New(new(config.Config))
+	signature := &tasks.Signature{UUID: "task_1"}
+	taskResult, err := tasks.NewTaskResult(struct {
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	}{640, 480})
+	assert.NoError(t, err)
+	assert.NoError(t, backend.SetStateSuccess(signature, []*tasks.TaskResult{taskResult}))
+
+	var dimensions struct {
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	}
+	err = result.NewAsyncResult(signature, backend).GetIntoWithTimeout(&dimensions, time.Second, time.Millisecond)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 640, dimensions.Width)
+		assert.Equal(t, 480, dimensions.Height)
+	}
+
+	failed := &tasks.Signature{UUID: "task_2"}
+	assert.NoError(t, backend.SetStateFailure(failed, "boom"))
+	assert.EqualError(t, result.NewAsyncResult(failed, backend).GetInto(&dimensions, time.Millisecond), "boom")
+}
@@ -0,0 +1,212 @@
+//go:build !machinery_no_aws
+// +build !machinery_no_aws
+
+package s3
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+
+	"github.com/RichardKnop/machinery/v2/backends/iface"
+	"github.com/RichardKnop/machinery/v2/common"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/tasks"
+
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Backend stores task results as S3 objects and keeps everything else
+// (task states without results, group meta data) in an index backend.
+// The index is usually Redis, it only ever holds small documents so large
+// results no longer count against its memory or item size limits.
+//
+// Objects are not expired by the backend, configure a lifecycle rule on
+// the bucket (or the prefix) matching ResultsExpireIn instead.
+type Backend struct {
+	common.Backend
+	index  iface.Backend
+	client s3iface.S3API
+	bucket string
+	prefix string
+}
+
+// New creates Backend instance
+func New(cnf *config.Config, index iface.Backend) iface.Backend {
+	backend := &Backend{
+		Backend: common.NewBackend(cnf),
+		index:   index,
+	}
+
+	if cnf.S3 != nil {
+		backend.bucket = cnf.S3.Bucket
+		backend.prefix = cnf.S3.Prefix
+	}
+
+	if cnf.S3 != nil && cnf.S3.Client != nil {
+		backend.client = cnf.S3.Client
+	} else {
+		sess := session.Must(session.NewSessionWithOptions(session.Options{
+			SharedConfigState: session.SharedConfigEnable,
+		}))
+		backend.client = awss3.New(sess)
+	}
+
+	return backend
+}
+
+// InitGroup creates and saves a group meta data object
+func (b *Backend) InitGroup(groupUUID string, taskUUIDs []string) error {
+	return b.index.InitGroup(groupUUID, taskUUIDs)
+}
+
+// GroupCompleted returns true if all tasks in a group finished
+func (b *Backend) GroupCompleted(groupUUID string, groupTaskCount int) (bool, error) {
+	return b.index.GroupCompleted(groupUUID, groupTaskCount)
+}
+
+// GroupTaskStates returns states of all tasks in the group
+func (b *Backend) GroupTaskStates(groupUUID string, groupTaskCount int) ([]*tasks.TaskState, error) {
+	taskStates, err := b.index.GroupTaskStates(groupUUID, groupTaskCount)
+	if err != nil {
+		return taskStates, err
+	}
+
+	for _, taskState := range taskStates {
+		if err := b.loadResults(taskState); err != nil {
+			return taskStates, err
+		}
+	}
+
+	return taskStates, nil
+}
+
+// TriggerChord flags chord as triggered in the index backend
+func (b *Backend) TriggerChord(groupUUID string) (bool, error) {
+	return b.index.TriggerChord(groupUUID)
+}
+
+// SetStatePending updates task state to PENDING
+func (b *Backend) SetStatePending(signature *tasks.Signature) error {
+	return b.index.SetStatePending(signature)
+}
+
+// SetStateReceived updates task state to RECEIVED
+func (b *Backend) SetStateReceived(signature *tasks.Signature) error {
+	return b.index.SetStateReceived(signature)
+}
+
+// SetStateStarted updates task state to STARTED
+func (b *Backend) SetStateStarted(signature *tasks.Signature) error {
+	return b.index.SetStateStarted(signature)
+}
+
+// SetStateRetry updates task state to RETRY
+func (b *Backend) SetStateRetry(signature *tasks.Signature) error {
+	return b.index.SetStateRetry(signature)
+}
+
+// SetStateSuccess uploads the results to S3 first and only then updates
+// task state to SUCCESS in the index, so a reader never sees a successful
+// task whose results are missing
+func (b *Backend) SetStateSuccess(signature *tasks.Signature, results []*tasks.TaskResult) error {
+	encoded, err := json.Marshal(results)
+	if err != nil {
+		return fmt.Errorf("Marshal task results error: %v", err)
+	}
+
+	_, err = b.client.PutObject(&awss3.PutObjectInput{
+		Bucket:      aws.String(b.bucket),
+		Key:         aws.String(b.objectKey(signature.UUID)),
+		Body:        bytes.NewReader(encoded),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("Upload task results error: %v", err)
+	}
+
+	return b.index.SetStateSuccess(signature, nil)
+}
+
+// SetStateFailure updates task state to FAILURE
+func (b *Backend) SetStateFailure(signature *tasks.Signature, err string) error {
+	return b.index.SetStateFailure(signature, err)
+}
+
+// GetState returns the latest task state, results of successful tasks
+// are downloaded from S3
+func (b *Backend) GetState(taskUUID string) (*tasks.TaskState, error) {
+	taskState, err := b.index.GetState(taskUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.loadResults(taskState); err != nil {
+		return nil, err
+	}
+
+	return taskState, nil
+}
+
+// IsAMQP returns true if the index backend is AMQP
+func (b *Backend) IsAMQP() bool {
+	return b.index.IsAMQP()
+}
+
+// PurgeState deletes stored task state and its results object
+func (b *Backend) PurgeState(taskUUID string) error {
+	_, err := b.client.DeleteObject(&awss3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.objectKey(taskUUID)),
+	})
+	if err != nil {
+		return err
+	}
+
+	return b.index.PurgeState(taskUUID)
+}
+
+// PurgeGroupMeta deletes stored group meta data
+func (b *Backend) PurgeGroupMeta(groupUUID string) error {
+	return b.index.PurgeGroupMeta(groupUUID)
+}
+
+// loadResults downloads results of a successful task into its state
+func (b *Backend) loadResults(taskState *tasks.TaskState) error {
+	if taskState == nil || !taskState.IsSuccess() {
+		return nil
+	}
+
+	output, err := b.client.GetObject(&awss3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.objectKey(taskState.TaskUUID)),
+	})
+	if err != nil {
+		// Results expired by a lifecycle rule before the index did
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == awss3.ErrCodeNoSuchKey {
+			return nil
+		}
+		return fmt.Errorf("Download task results error: %v", err)
+	}
+	defer output.Body.Close()
+
+	var results []*tasks.TaskResult
+	decoder := json.NewDecoder(output.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&results); err != nil {
+		return fmt.Errorf("Unmarshal task results error: %v", err)
+	}
+
+	taskState.Results = results
+	return nil
+}
+
+// objectKey returns the S3 object key holding results of a task
+func (b *Backend) objectKey(taskUUID string) string {
+	return b.GetConfig().Namespaced(b.prefix + taskUUID)
+}
@@ -0,0 +1,145 @@
+//go:build !machinery_no_aws
+// +build !machinery_no_aws
+
+package s3
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/backends/eager"
+	"github.com/RichardKnop/machinery/v2/common"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/tasks"
+
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+)
+
+type testS3Client struct {
+	s3iface.S3API
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (c *testS3Client) PutObject(input *awss3.PutObjectInput) (*awss3.PutObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	body, _ := ioutil.ReadAll(input.Body)
+	c.objects[*input.Bucket+"/"+*input.Key] = body
+	return &awss3.PutObjectOutput{}, nil
+}
+
+func (c *testS3Client) GetObject(input *awss3.GetObjectInput) (*awss3.GetObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	body, ok := c.objects[*input.Bucket+"/"+*input.Key]
+	if !ok {
+		return nil, awserr.New(awss3.ErrCodeNoSuchKey, "not found", nil)
+	}
+	return &awss3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(body))}, nil
+}
+
+func (c *testS3Client) DeleteObject(input *awss3.DeleteObjectInput) (*awss3.DeleteObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.objects, *input.Bucket+"/"+*input.Key)
+	return &awss3.DeleteObjectOutput{}, nil
+}
+
+func newTestBackend() (*Backend, *testS3Client) {
+	client := &testS3Client{objects: make(map[string][]byte)}
+	return &Backend{
+		Backend: common.NewBackend(new(config.Config)),
+		index:   eager.New(),
+		client:  client,
+		bucket:  "results",
+		prefix:  "machinery/",
+	}, client
+}
+
+func TestSetStateSuccess(t *testing.T) {
+	backend, client := newTestBackend()
+	signature := &tasks.Signature{UUID: "task_1", Name: "large"}
+
+	assert.NoError(t, backend.SetStatePending(signature))
+	assert.NoError(t, backend.SetStateSuccess(signature, []*tasks.TaskResult{
+		{Type: "string", Value: "payload"},
+	}))
+	assert.Contains(t, client.objects, "results/machinery/task_1")
+
+	state, err := backend.GetState(signature.UUID)
+	if assert.NoError(t, err) {
+		assert.True(t, state.IsSuccess())
+		if assert.Len(t, state.Results, 1) {
+			assert.Equal(t, "payload", state.Results[0].Value)
+		}
+	}
+
+	// The index must not hold the results itself
+	indexState, err := backend.index.GetState(signature.UUID)
+	if assert.NoError(t, err) {
+		assert.Empty(t, indexState.Results)
+	}
+}
+
+func TestGetStateMissingObject(t *testing.T) {
+	backend, client := newTestBackend()
+	signature := &tasks.Signature{UUID: "task_2"}
+
+	assert.NoError(t, backend.SetStateSuccess(signature, []*tasks.TaskResult{{Type: "int64", Value: 1}}))
+	client.objects = make(map[string][]byte)
+
+	state, err := backend.GetState(signature.UUID)
+	if assert.NoError(t, err) {
+		assert.True(t, state.IsSuccess())
+		assert.Empty(t, state.Results)
+	}
+}
+
+func TestGroupTaskStates(t *testing.T) {
+	backend, _ := newTestBackend()
+	group := []*tasks.Signature{{UUID: "task_a"}, {UUID: "task_b"}}
+
+	assert.NoError(t, backend.InitGroup("group_1", []string{"task_a", "task_b"}))
+	for _, signature := range group {
+		assert.NoError(t, backend.SetStateSuccess(signature, []*tasks.TaskResult{
+			{Type: "string", Value: signature.UUID},
+		}))
+	}
+
+	states, err := backend.GroupTaskStates("group_1", len(group))
+	if assert.NoError(t, err) && assert.Len(t, states, 2) {
+		assert.Equal(t, "task_a", states[0].Results[0].Value)
+		assert.Equal(t, "task_b", states[1].Results[0].Value)
+	}
+}
+
+func TestPurgeState(t *testing.T) {
+	backend, client := newTestBackend()
+	signature := &tasks.Signature{UUID: "task_3"}
+
+	assert.NoError(t, backend.SetStateSuccess(signature, nil))
+	assert.NoError(t, backend.PurgeState(signature.UUID))
+	assert.NotContains(t, client.objects, "results/machinery/task_3")
+
+	_, err := backend.GetState(signature.UUID)
+	assert.Error(t, err)
+}
+
+func TestNamespace(t *testing.T) {
+	backend, client := newTestBackend()
+	backend.GetConfig().Namespace = "staging"
+	signature := &tasks.Signature{UUID: "task_5"}
+
+	assert.NoError(t, backend.SetStatePending(signature))
+	assert.NoError(t, backend.SetStateSuccess(signature, []*tasks.TaskResult{
+		{Type: "string", Value: "payload"},
+	}))
+	assert.Contains(t, client.objects, "results/staging_machinery/task_5")
+}
@@ -0,0 +1,131 @@
+package machinery
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+const (
+	defaultBatchMaxSize = 100
+	defaultBatchMaxWait = time.Second
+)
+
+// ErrBatcherClosed is returned when items are added to a closed batcher
+var ErrBatcherClosed = errors.New("Batcher is closed")
+
+// BatchPolicy says when a batcher sends the items it accumulated
+type BatchPolicy struct {
+	// MaxSize sends the batch once it holds that many items, defaults to 100
+	MaxSize int
+	// MaxWait sends the batch that long after its first item was added,
+	// defaults to 1 second
+	MaxWait time.Duration
+}
+
+// Batcher accumulates small payloads, e.g. events, into batch tasks, so
+// sending a lot of them doesn't cost a message each. A batch task is a copy
+// of the signature of the batcher with the items appended as a single slice
+// argument, its task takes a slice of them. It is safe for concurrent use.
+type Batcher struct {
+	server    *Server
+	signature *tasks.Signature
+	itemType  string
+	policy    BatchPolicy
+
+	mu     sync.Mutex
+	items  []interface{}
+	timer  *time.Timer
+	closed bool
+}
+
+// NewBatcher returns a batcher sending the items added to it, of the type
+// itemType, e.g. "string" or a type registered with tasks.RegisterType, as
+// batch tasks of the signature. The task gets them as a "[]"+itemType
+// argument after the arguments of the signature.
+func (server *Server) NewBatcher(signature *tasks.Signature, itemType string, policy *BatchPolicy) *Batcher {
+	batcher := &Batcher{
+		server:    server,
+		signature: tasks.CopySignature(signature),
+		itemType:  itemType,
+	}
+	if policy != nil {
+		batcher.policy = *policy
+	}
+	if batcher.policy.MaxSize <= 0 {
+		batcher.policy.MaxSize = defaultBatchMaxSize
+	}
+	if batcher.policy.MaxWait <= 0 {
+		batcher.policy.MaxWait = defaultBatchMaxWait
+	}
+	return batcher
+}
+
+// Add adds an item to the batch, sending the batch if it is full
+func (batcher *Batcher) Add(item interface{}) error {
+	batcher.mu.Lock()
+	defer batcher.mu.Unlock()
+	if batcher.closed {
+		return ErrBatcherClosed
+	}
+
+	batcher.items = append(batcher.items, item)
+	if len(batcher.items) >= batcher.policy.MaxSize {
+		return batcher.flush()
+	}
+	if batcher.timer == nil {
+		batcher.timer = time.AfterFunc(batcher.policy.MaxWait, batcher.flushTimedOut)
+	}
+	return nil
+}
+
+// Flush sends the batch right away, unless it is empty
+func (batcher *Batcher) Flush() error {
+	batcher.mu.Lock()
+	defer batcher.mu.Unlock()
+	return batcher.flush()
+}
+
+// Close sends the batch and stops accepting items
+func (batcher *Batcher) Close() error {
+	batcher.mu.Lock()
+	defer batcher.mu.Unlock()
+	batcher.closed = true
+	return batcher.flush()
+}
+
+// flushTimedOut sends the batch once its first item waited MaxWait
+func (batcher *Batcher) flushTimedOut() {
+	batcher.mu.Lock()
+	defer batcher.mu.Unlock()
+	batcher.timer = nil
+	if err := batcher.flush(); err != nil {
+		batcher.server.log().ERROR.Printf("Failed to send batch of task %s: %s", batcher.signature.Name, err)
+	}
+}
+
+// flush sends the items as a batch task. Items of a batch which couldn't be
+// sent are kept for the next one.
+func (batcher *Batcher) flush() error {
+	if batcher.timer != nil {
+		batcher.timer.Stop()
+		batcher.timer = nil
+	}
+	if len(batcher.items) == 0 {
+		return nil
+	}
+
+	signature := tasks.CopySignature(batcher.signature)
+	signature.Args = append(signature.Args, tasks.Arg{Type: "[]" + batcher.itemType, Value: batcher.items})
+	if _, err := batcher.server.SendTaskWithContext(context.Background(), signature); err != nil {
+		if !batcher.closed {
+			batcher.timer = time.AfterFunc(batcher.policy.MaxWait, batcher.flushTimedOut)
+		}
+		return err
+	}
+	batcher.items = nil
+	return nil
+}
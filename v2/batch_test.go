@@ -0,0 +1,68 @@
+package machinery_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestBatcher(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	var batches [][]int64
+	err := server.RegisterTask("track", func(source string, events []int64) error {
+		assert.Equal(t, "web", source)
+		batches = append(batches, events)
+		return nil
+	})
+	assert.NoError(t, err)
+	worker := server.NewWorker("test_worker", 0)
+
+	signature := &tasks.Signature{Name: "track", Args: []tasks.Arg{{Type: "string", Value: "web"}}}
+	batcher := server.NewBatcher(signature, "int64", &machinery.BatchPolicy{MaxSize: 3, MaxWait: time.Hour})
+
+	// Full batches are sent right away
+	for i := int64(1); i <= 7; i++ {
+		assert.NoError(t, batcher.Add(i))
+	}
+	drain(t, worker, broker)
+	assert.Equal(t, [][]int64{{1, 2, 3}, {4, 5, 6}}, batches)
+
+	// The rest once flushed
+	assert.NoError(t, batcher.Flush())
+	assert.NoError(t, batcher.Flush(), "empty batches are not sent")
+	drain(t, worker, broker)
+	assert.Equal(t, [][]int64{{1, 2, 3}, {4, 5, 6}, {7}}, batches)
+
+	// Closed batchers send the rest and don't accept items
+	assert.NoError(t, batcher.Add(int64(8)))
+	assert.NoError(t, batcher.Close())
+	assert.Equal(t, machinery.ErrBatcherClosed, batcher.Add(int64(9)))
+	drain(t, worker, broker)
+	assert.Equal(t, [][]int64{{1, 2, 3}, {4, 5, 6}, {7}, {8}}, batches)
+	assert.Len(t, signature.Args, 1, "the signature of the batcher is left alone")
+}
+
+func TestBatcherMaxWait(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	batcher := server.NewBatcher(&tasks.Signature{Name: "track"}, "string", &machinery.BatchPolicy{MaxWait: 20 * time.Millisecond})
+
+	assert.NoError(t, batcher.Add("signup"))
+	assert.NoError(t, batcher.Add("login"))
+	assert.Nil(t, broker.peek())
+
+	// Batches are sent once their first item waited long enough
+	time.Sleep(100 * time.Millisecond)
+	if batch := broker.next(); assert.NotNil(t, batch) && assert.Len(t, batch.Args, 1) {
+		assert.Equal(t, "[]string", batch.Args[0].Type)
+		assert.Equal(t, []interface{}{"signup", "login"}, batch.Args[0].Value)
+	}
+	assert.Nil(t, broker.next())
+}
@@ -0,0 +1,282 @@
+package machinery
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+	"github.com/RichardKnop/machinery/v2/tracing"
+)
+
+const (
+	defaultBreakerFailureRate = 0.5
+	defaultBreakerMinRuns     = 10
+	defaultBreakerWindow      = time.Minute
+	defaultBreakerCooldown    = 30 * time.Second
+
+	// breakerBuckets is how many buckets the window of a circuit is split
+	// into, runs expire from the window one bucket at a time
+	breakerBuckets = 10
+)
+
+// CircuitBreakerPolicy stops a worker from running a task while most of its
+// runs fail, e.g. because the API it calls is down, so the task doesn't tie
+// up the worker's slots. Once at least MinRuns runs completed within Window
+// and FailureRate of them failed, the circuit of the task opens for
+// Cooldown: the task is sent back to the queue to run once the circuit may
+// close, without counting as a retry. Then a single trial run is let
+// through, the circuit closes if it succeeds and opens again otherwise.
+// Circuits are kept by each worker, they aren't shared across a fleet.
+type CircuitBreakerPolicy struct {
+	// FailureRate between 0 and 1 opens the circuit, defaults to 0.5
+	FailureRate float64
+	// MinRuns is how many runs must complete within Window before the
+	// circuit opens, defaults to 10
+	MinRuns int
+	// Window is how long runs are counted, defaults to 1 minute
+	Window time.Duration
+	// Cooldown is how long the circuit stays open, defaults to 30 seconds
+	Cooldown time.Duration
+}
+
+// breakerBucket counts the runs which completed in a slice of the window
+type breakerBucket struct {
+	start    time.Time
+	runs     int
+	failures int
+}
+
+// circuit tracks the runs of a task and whether they may run
+type circuit struct {
+	policy CircuitBreakerPolicy
+
+	mu        sync.Mutex
+	buckets   [breakerBuckets]breakerBucket
+	openUntil time.Time
+	// trial is set while the trial run of a half-open circuit is running
+	trial bool
+}
+
+// circuitRun is a run let through by a circuit, it must be released once it
+// completed
+type circuitRun struct {
+	circuit *circuit
+	trial   bool
+	done    bool
+}
+
+// breakers keeps the circuits of the tasks of a worker
+type breakers struct {
+	mu       sync.Mutex
+	policies map[string]*CircuitBreakerPolicy
+	fallback *CircuitBreakerPolicy
+	circuits map[string]*circuit
+}
+
+// SetCircuitBreaker makes the worker break the circuits of the tasks with the
+// names as the policy says, of every task if no names are given. A nil
+// policy turns it off. Must be called before Launch.
+func (worker *Worker) SetCircuitBreaker(policy *CircuitBreakerPolicy, names ...string) {
+	if worker.breakers == nil {
+		worker.breakers = &breakers{
+			policies: make(map[string]*CircuitBreakerPolicy),
+			circuits: make(map[string]*circuit),
+		}
+	}
+
+	var normalized *CircuitBreakerPolicy
+	if policy != nil {
+		normalized = policy.withDefaults()
+	}
+
+	b := worker.breakers
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(names) == 0 {
+		b.fallback = normalized
+		b.circuits = make(map[string]*circuit)
+		return
+	}
+	for _, name := range names {
+		b.policies[name] = normalized
+		delete(b.circuits, name)
+	}
+}
+
+// OpenCircuits returns the names of the tasks whose circuit is open, or
+// half-open waiting for a trial run to complete
+func (worker *Worker) OpenCircuits() []string {
+	if worker.breakers == nil {
+		return nil
+	}
+
+	b := worker.breakers
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var names []string
+	for name, c := range b.circuits {
+		c.mu.Lock()
+		if !c.openUntil.IsZero() {
+			names = append(names, name)
+		}
+		c.mu.Unlock()
+	}
+	sort.Strings(names)
+	return names
+}
+
+// withDefaults returns a copy of the policy with defaults for unset fields
+func (p *CircuitBreakerPolicy) withDefaults() *CircuitBreakerPolicy {
+	normalized := *p
+	if normalized.FailureRate <= 0 {
+		normalized.FailureRate = defaultBreakerFailureRate
+	}
+	if normalized.MinRuns <= 0 {
+		normalized.MinRuns = defaultBreakerMinRuns
+	}
+	if normalized.Window <= 0 {
+		normalized.Window = defaultBreakerWindow
+	}
+	if normalized.Cooldown <= 0 {
+		normalized.Cooldown = defaultBreakerCooldown
+	}
+	return &normalized
+}
+
+// circuit returns the circuit of the task, nil if it has no policy
+func (b *breakers) circuit(name string) *circuit {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c, ok := b.circuits[name]; ok {
+		return c
+	}
+	policy, ok := b.policies[name]
+	if !ok {
+		policy = b.fallback
+	}
+	if policy == nil {
+		return nil
+	}
+	c := &circuit{policy: *policy}
+	b.circuits[name] = c
+	return c
+}
+
+// begin lets the run of the task through unless its circuit is open, in
+// which case it returns when the task may run again
+func (b *breakers) begin(name string, now time.Time) (*circuitRun, time.Time) {
+	c := b.circuit(name)
+	if c == nil {
+		return nil, time.Time{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.openUntil.IsZero() {
+		return &circuitRun{circuit: c}, time.Time{}
+	}
+	if now.Before(c.openUntil) {
+		return nil, c.openUntil
+	}
+	// Half-open, a single trial run decides whether the circuit closes
+	if c.trial {
+		return nil, now.Add(c.policy.Cooldown)
+	}
+	c.trial = true
+	return &circuitRun{circuit: c, trial: true}, time.Time{}
+}
+
+// record counts the completed run, opening or closing the circuit
+func (r *circuitRun) record(failed bool, now time.Time) {
+	if r == nil || r.done {
+		return
+	}
+	r.done = true
+
+	c := r.circuit
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r.trial {
+		c.trial = false
+		c.buckets = [breakerBuckets]breakerBucket{}
+		c.openUntil = time.Time{}
+		if failed {
+			c.openUntil = now.Add(c.policy.Cooldown)
+		}
+		return
+	}
+	if !c.openUntil.IsZero() {
+		// Runs started before the circuit opened don't count
+		return
+	}
+
+	bucketLength := c.policy.Window / breakerBuckets
+	if bucketLength <= 0 {
+		bucketLength = 1
+	}
+	start := now.Truncate(bucketLength)
+	bucket := &c.buckets[(start.UnixNano()/int64(bucketLength))%breakerBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = breakerBucket{start: start}
+	}
+	bucket.runs++
+	if failed {
+		bucket.failures++
+	}
+
+	runs, failures := 0, 0
+	for _, counted := range c.buckets {
+		if now.Sub(counted.start) < c.policy.Window {
+			runs += counted.runs
+			failures += counted.failures
+		}
+	}
+	if runs >= c.policy.MinRuns && float64(failures) >= c.policy.FailureRate*float64(runs) {
+		c.openUntil = now.Add(c.policy.Cooldown)
+		c.buckets = [breakerBuckets]breakerBucket{}
+	}
+}
+
+// release lets another trial run through if the run was a trial which
+// completed without being recorded, e.g. because it was preempted
+func (r *circuitRun) release() {
+	if r == nil || r.done || !r.trial {
+		return
+	}
+	r.done = true
+
+	r.circuit.mu.Lock()
+	r.circuit.trial = false
+	r.circuit.mu.Unlock()
+}
+
+// shortCircuit sends a task whose circuit is open back to the queue to run
+// at retryAt, it doesn't count as a retry
+func (worker *Worker) shortCircuit(span opentracing.Span, signature *tasks.Signature, retryAt time.Time) error {
+	worker.server.log().WARNING.Printf("Circuit of task %s is open, task %s is going to run in %.0f seconds", signature.Name, signature.UUID, time.Until(retryAt).Seconds())
+	return worker.sendBack(span, signature, retryAt)
+}
+
+// sendBack sends a task which isn't run now back to the queue to run at
+// retryAt, without counting as a retry
+func (worker *Worker) sendBack(span opentracing.Span, signature *tasks.Signature, retryAt time.Time) error {
+	if err := worker.server.GetBackend().SetStateRetry(signature); err != nil {
+		return fmt.Errorf("Set state to 'retry' for task %s returned error: %s", signature.UUID, err)
+	}
+	tracing.LogStateTransition(span, tasks.StateRetry)
+
+	eta := retryAt.UTC()
+	signature.ETA = &eta
+	tracing.LogRetry(span, signature, time.Until(retryAt))
+
+	_, err := worker.server.SendTask(signature)
+	return err
+}
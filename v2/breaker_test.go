@@ -0,0 +1,74 @@
+package machinery_test
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	var calls, failing int32 = 0, 1
+	err := server.RegisterTasks(map[string]interface{}{
+		"charge": func() error {
+			atomic.AddInt32(&calls, 1)
+			if atomic.LoadInt32(&failing) == 1 {
+				return errors.New("payment API is down")
+			}
+			return nil
+		},
+		"notify": func() error { return errors.New("mail server is down") },
+	})
+	assert.NoError(t, err)
+
+	worker := server.NewWorker("test_worker", 0)
+	worker.SetCircuitBreaker(&machinery.CircuitBreakerPolicy{MinRuns: 2, Cooldown: 100 * time.Millisecond}, "charge")
+
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, worker.Process(&tasks.Signature{UUID: "task_charge", Name: "charge"}))
+		assert.NoError(t, worker.Process(&tasks.Signature{UUID: "task_notify", Name: "notify"}))
+	}
+	assert.Equal(t, []string{"charge"}, worker.OpenCircuits(), "only tasks with a policy break")
+
+	// Runs are sent back without being called or counted as a retry
+	signature := &tasks.Signature{UUID: "task_1", Name: "charge", RetryCount: 3}
+	assert.NoError(t, worker.Process(signature))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	requeued := broker.next()
+	if assert.NotNil(t, requeued) {
+		assert.Equal(t, 3, requeued.RetryCount)
+		assert.WithinDuration(t, time.Now().Add(100*time.Millisecond), *requeued.ETA, 100*time.Millisecond)
+	}
+	state, err := server.GetBackend().GetState(signature.UUID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, tasks.StatePending, state.State)
+	}
+
+	// A failed trial run opens the circuit again
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, worker.Process(&tasks.Signature{UUID: "task_2", Name: "charge"}))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	assert.Equal(t, []string{"charge"}, worker.OpenCircuits())
+
+	// A successful one closes it
+	time.Sleep(100 * time.Millisecond)
+	atomic.StoreInt32(&failing, 0)
+	assert.NoError(t, worker.Process(&tasks.Signature{UUID: "task_3", Name: "charge"}))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+	assert.Empty(t, worker.OpenCircuits())
+
+	// Turning the policy off closes the circuits
+	worker.SetCircuitBreaker(&machinery.CircuitBreakerPolicy{MinRuns: 1})
+	assert.NoError(t, worker.Process(&tasks.Signature{UUID: "task_notify", Name: "notify"}))
+	assert.Equal(t, []string{"notify"}, worker.OpenCircuits())
+	worker.SetCircuitBreaker(nil)
+	assert.Empty(t, worker.OpenCircuits())
+}
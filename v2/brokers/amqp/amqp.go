@@ -1,9 +1,7 @@
 package amqp
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"sync"
 	"time"
@@ -12,12 +10,25 @@ import (
 	"github.com/RichardKnop/machinery/v2/brokers/iface"
 	"github.com/RichardKnop/machinery/v2/common"
 	"github.com/RichardKnop/machinery/v2/config"
-	"github.com/RichardKnop/machinery/v2/log"
 	"github.com/RichardKnop/machinery/v2/tasks"
 	"github.com/pkg/errors"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+var (
+	// ErrNoDeadLetterQueue is returned if no alternate exchange is
+	// configured
+	ErrNoDeadLetterQueue = errors.New("Broker has no alternate exchange")
+)
+
+// DeadLetterQueue is the queue keeping the tasks the alternate exchange
+// received as no queue was bound for them
+type DeadLetterQueue struct {
+	Name string
+	// Depth is the number of tasks in the queue
+	Depth int
+}
+
 type AMQPConnection struct {
 	queueName    string
 	connection   *amqp.Connection
@@ -51,18 +62,19 @@ func (b *Broker) StartConsuming(consumerTag string, concurrency int, taskProcess
 	if queueName == "" {
 		queueName = b.GetConfig().DefaultQueue
 	}
+	queueName = b.GetConfig().Namespaced(queueName)
 
 	conn, channel, queue, _, amqpCloseChan, err := b.Connect(
 		b.GetConfig().Broker,
 		b.GetConfig().MultipleBrokerSeparator,
 		b.GetConfig().TLSConfig,
-		b.GetConfig().AMQP.Exchange,     // exchange name
+		b.exchange(),                    // exchange name
 		b.GetConfig().AMQP.ExchangeType, // exchange type
 		queueName,                       // queue name
 		true,                            // queue durable
 		false,                           // queue delete when unused
 		b.GetConfig().AMQP.BindingKey,   // queue binding key
-		nil,                             // exchange declare args
+		b.exchangeDeclareArgs(),         // exchange declare args
 		amqp.Table(b.GetConfig().AMQP.QueueDeclareArgs), // queue declare args
 		amqp.Table(b.GetConfig().AMQP.QueueBindingArgs), // queue binding args
 	)
@@ -72,8 +84,13 @@ func (b *Broker) StartConsuming(consumerTag string, concurrency int, taskProcess
 	}
 	defer b.Close(channel, conn)
 
+	if err = b.declareAlternateExchange(channel); err != nil {
+		b.GetRetryFunc()(b.GetRetryStopChan())
+		return b.GetRetry(), err
+	}
+
 	if err = channel.Qos(
-		b.GetConfig().AMQP.PrefetchCount,
+		b.qos(concurrency, taskProcessor),
 		0,     // prefetch size
 		false, // global
 	); err != nil {
@@ -93,7 +110,7 @@ func (b *Broker) StartConsuming(consumerTag string, concurrency int, taskProcess
 		return b.GetRetry(), fmt.Errorf("Queue consume error: %s", err)
 	}
 
-	log.INFO.Print("[*] Waiting for messages. To exit press CTRL+C")
+	b.Logger().INFO.Print("[*] Waiting for messages. To exit press CTRL+C")
 
 	if err := b.consume(deliveries, concurrency, taskProcessor, amqpCloseChan); err != nil {
 		return b.GetRetry(), err
@@ -105,6 +122,28 @@ func (b *Broker) StartConsuming(consumerTag string, concurrency int, taskProcess
 	return b.GetRetry(), nil
 }
 
+// SupportsPrefetch returns true, consumers hold the prefetch count of their
+// processor waiting for a free slot, the QoS prefetch count being the
+// concurrency plus the prefetch count. It overrides the configured prefetch
+// count.
+func (b *Broker) SupportsPrefetch() bool {
+	return true
+}
+
+// qos returns the QoS prefetch count of a consumer for the task processor
+// with the concurrency
+func (b *Broker) qos(concurrency int, taskProcessor iface.TaskProcessor) int {
+	prefetchCount, prefetch := b.PrefetchCount(taskProcessor)
+	if !prefetch {
+		return b.GetConfig().AMQP.PrefetchCount
+	}
+	if concurrency < 1 {
+		// Consumers without a limit process the tasks as they arrive
+		return 0
+	}
+	return concurrency + prefetchCount
+}
+
 // StopConsuming quits the loop
 func (b *Broker) StopConsuming() {
 	b.Broker.StopConsuming()
@@ -131,7 +170,7 @@ func (b *Broker) GetOrOpenConnection(queueName string, queueBindingKey string, e
 			b.GetConfig().Broker,
 			b.GetConfig().MultipleBrokerSeparator,
 			b.GetConfig().TLSConfig,
-			b.GetConfig().AMQP.Exchange,     // exchange name
+			b.exchange(),                    // exchange name
 			b.GetConfig().AMQP.ExchangeType, // exchange type
 			queueName,                       // queue name
 			true,                            // queue durable
@@ -144,18 +183,22 @@ func (b *Broker) GetOrOpenConnection(queueName string, queueBindingKey string, e
 		if err != nil {
 			return nil, errors.Wrapf(err, "Failed to connect to queue %s", queueName)
 		}
+		if err = b.declareAlternateExchange(conn.channel); err != nil {
+			b.Close(conn.channel, conn.connection)
+			return nil, errors.Wrapf(err, "Failed to connect to queue %s", queueName)
+		}
 
 		// Reconnect to the channel if it disconnects/errors out
 		go func() {
 			select {
 			case err = <-conn.errorchan:
-				log.INFO.Printf("Error occurred on queue: %s. Reconnecting", queueName)
+				b.Logger().INFO.Printf("Error occurred on queue: %s. Reconnecting", queueName)
 				b.connectionsMutex.Lock()
 				delete(b.connections, queueName)
 				b.connectionsMutex.Unlock()
 				_, err := b.GetOrOpenConnection(queueName, queueBindingKey, exchangeDeclareArgs, queueDeclareArgs, queueBindingArgs)
 				if err != nil {
-					log.ERROR.Printf("Failed to reopen queue: %s.", queueName)
+					b.Logger().ERROR.Printf("Failed to reopen queue: %s.", queueName)
 				}
 			case <-conn.cleanup:
 				return
@@ -172,7 +215,7 @@ func (b *Broker) CloseConnections() error {
 
 	for key, conn := range b.connections {
 		if err := b.Close(conn.channel, conn.connection); err != nil {
-			log.ERROR.Print("Failed to close channel")
+			b.Logger().ERROR.Print("Failed to close channel")
 			return nil
 		}
 		close(conn.cleanup)
@@ -186,9 +229,9 @@ func (b *Broker) Publish(ctx context.Context, signature *tasks.Signature) error
 	// Adjust routing key (this decides which queue the message will be published to)
 	b.AdjustRoutingKey(signature)
 
-	msg, err := json.Marshal(signature)
+	msg, err := b.EncodeSignature(signature)
 	if err != nil {
-		return fmt.Errorf("JSON marshal error: %s", err)
+		return fmt.Errorf("Encode signature error: %s", err)
 	}
 
 	// Check the ETA signature field, if it is set and it is in the future,
@@ -203,17 +246,17 @@ func (b *Broker) Publish(ctx context.Context, signature *tasks.Signature) error
 		}
 	}
 
-	queue := b.GetConfig().DefaultQueue
+	queue := b.GetConfig().Namespaced(b.GetConfig().DefaultQueue)
 	bindingKey := b.GetConfig().AMQP.BindingKey // queue binding key
 	if b.isDirectExchange() {
-		queue = signature.RoutingKey
+		queue = b.GetConfig().Namespaced(signature.RoutingKey)
 		bindingKey = signature.RoutingKey
 	}
 
 	connection, err := b.GetOrOpenConnection(
 		queue,
-		bindingKey, // queue binding key
-		nil,        // exchange declare args
+		bindingKey,              // queue binding key
+		b.exchangeDeclareArgs(), // exchange declare args
 		amqp.Table(b.GetConfig().AMQP.QueueDeclareArgs), // queue declare args
 		amqp.Table(b.GetConfig().AMQP.QueueBindingArgs), // queue binding args
 	)
@@ -225,13 +268,13 @@ func (b *Broker) Publish(ctx context.Context, signature *tasks.Signature) error
 	confirmsChan := connection.confirmation
 
 	if err := channel.Publish(
-		b.GetConfig().AMQP.Exchange, // exchange name
-		signature.RoutingKey,        // routing key
-		false,                       // mandatory
-		false,                       // immediate
+		b.exchange(),         // exchange name
+		signature.RoutingKey, // routing key
+		false,                // mandatory
+		false,                // immediate
 		amqp.Publishing{
 			Headers:      amqp.Table(signature.Headers),
-			ContentType:  "application/json",
+			ContentType:  b.ContentType(),
 			Body:         msg,
 			Priority:     signature.Priority,
 			DeliveryMode: amqp.Persistent,
@@ -310,10 +353,9 @@ func (b *Broker) consumeOne(delivery amqp.Delivery, taskProcessor iface.TaskProc
 	var multiple, requeue = false, false
 
 	// Unmarshal message body into signature struct
-	signature := new(tasks.Signature)
-	decoder := json.NewDecoder(bytes.NewReader(delivery.Body))
-	decoder.UseNumber()
-	if err := decoder.Decode(signature); err != nil {
+	signature, err := b.DecodeSignature(delivery.Body)
+	if err != nil {
+		b.DeadLetterMessage(taskProcessor, delivery.Body, err)
 		delivery.Nack(multiple, requeue)
 		return errs.NewErrCouldNotUnmarshalTaskSignature(delivery.Body, err)
 	}
@@ -324,14 +366,14 @@ func (b *Broker) consumeOne(delivery amqp.Delivery, taskProcessor iface.TaskProc
 		if !signature.IgnoreWhenTaskNotRegistered {
 			requeue = true
 		}
-		log.INFO.Printf("Task not registered with this worker. Requeuing: %t with message: %s", requeue, delivery.Body)
+		b.Logger().INFO.Printf("Task not registered with this worker. Requeuing: %t with message: %s", requeue, delivery.Body)
 		delivery.Nack(multiple, requeue)
 		return nil
 	}
 
-	log.DEBUG.Printf("Received new message: %s", delivery.Body)
+	b.Logger().DEBUG.Printf("Received new message: %s", delivery.Body)
 
-	err := taskProcessor.Process(signature)
+	err = taskProcessor.Process(signature)
 	if ack {
 		delivery.Ack(multiple)
 	}
@@ -347,21 +389,21 @@ func (b *Broker) delay(signature *tasks.Signature, delayMs int64) error {
 		return errors.New("Cannot delay task by 0ms")
 	}
 
-	message, err := json.Marshal(signature)
+	message, err := b.EncodeSignature(signature)
 	if err != nil {
-		return fmt.Errorf("JSON marshal error: %s", err)
+		return fmt.Errorf("Encode signature error: %s", err)
 	}
 
-	queueName := b.GetConfig().AMQP.DelayedQueue
+	queueName := b.GetConfig().Namespaced(b.GetConfig().AMQP.DelayedQueue)
 	declareQueueArgs := amqp.Table{
 		// Exchange where to send messages after TTL expiration.
-		"x-dead-letter-exchange": b.GetConfig().AMQP.Exchange,
+		"x-dead-letter-exchange": b.exchange(),
 		// Routing key which use when resending expired messages.
 		"x-dead-letter-routing-key": signature.RoutingKey,
 	}
 	messageProperties := amqp.Publishing{
 		Headers:      amqp.Table(signature.Headers),
-		ContentType:  "application/json",
+		ContentType:  b.ContentType(),
 		Body:         message,
 		DeliveryMode: amqp.Persistent,
 		Expiration:   fmt.Sprint(delayMs),
@@ -372,12 +414,12 @@ func (b *Broker) delay(signature *tasks.Signature, delayMs int64) error {
 		queueName = fmt.Sprintf(
 			"delay.%d.%s.%s",
 			delayMs, // delay duration in mileseconds
-			b.GetConfig().AMQP.Exchange,
+			b.exchange(),
 			signature.RoutingKey, // routing key
 		)
 		declareQueueArgs = amqp.Table{
 			// Exchange where to send messages after TTL expiration.
-			"x-dead-letter-exchange": b.GetConfig().AMQP.Exchange,
+			"x-dead-letter-exchange": b.exchange(),
 			// Routing key which use when resending expired messages.
 			"x-dead-letter-routing-key": signature.RoutingKey,
 			// Time in milliseconds
@@ -388,7 +430,7 @@ func (b *Broker) delay(signature *tasks.Signature, delayMs int64) error {
 		}
 		messageProperties = amqp.Publishing{
 			Headers:      amqp.Table(signature.Headers),
-			ContentType:  "application/json",
+			ContentType:  b.ContentType(),
 			Body:         message,
 			DeliveryMode: amqp.Persistent,
 		}
@@ -398,13 +440,13 @@ func (b *Broker) delay(signature *tasks.Signature, delayMs int64) error {
 		b.GetConfig().Broker,
 		b.GetConfig().MultipleBrokerSeparator,
 		b.GetConfig().TLSConfig,
-		b.GetConfig().AMQP.Exchange,     // exchange name
+		b.exchange(),                    // exchange name
 		b.GetConfig().AMQP.ExchangeType, // exchange type
 		queueName,                       // queue name
 		true,                            // queue durable
 		b.GetConfig().AMQP.AutoDelete,   // queue delete when unused
 		queueName,                       // queue binding key
-		nil,                             // exchange declare args
+		b.exchangeDeclareArgs(),         // exchange declare args
 		declareQueueArgs,                // queue declare args
 		amqp.Table(b.GetConfig().AMQP.QueueBindingArgs), // queue binding args
 	)
@@ -414,11 +456,15 @@ func (b *Broker) delay(signature *tasks.Signature, delayMs int64) error {
 
 	defer b.Close(channel, conn)
 
+	if err := b.declareAlternateExchange(channel); err != nil {
+		return err
+	}
+
 	if err := channel.Publish(
-		b.GetConfig().AMQP.Exchange, // exchange
-		queueName,                   // routing key
-		false,                       // mandatory
-		false,                       // immediate
+		b.exchange(), // exchange
+		queueName,    // routing key
+		false,        // mandatory
+		false,        // immediate
 		messageProperties,
 	); err != nil {
 		return err
@@ -427,6 +473,63 @@ func (b *Broker) delay(signature *tasks.Signature, delayMs int64) error {
 	return nil
 }
 
+// exchange returns the name of the exchange in the namespace
+func (b *Broker) exchange() string {
+	return b.GetConfig().Namespaced(b.GetConfig().AMQP.Exchange)
+}
+
+// alternateExchange returns the name of the alternate exchange in the
+// namespace, empty if there is none
+func (b *Broker) alternateExchange() string {
+	return common.AMQPAlternateExchange(b.GetConfig())
+}
+
+// exchangeDeclareArgs returns the arguments the exchange is declared with
+func (b *Broker) exchangeDeclareArgs() amqp.Table {
+	return common.AMQPExchangeDeclareArgs(b.GetConfig())
+}
+
+// declareAlternateExchange declares the alternate exchange, if there is one,
+// along with the queue keeping the tasks it receives
+func (b *Broker) declareAlternateExchange(channel *amqp.Channel) error {
+	alternateExchange := b.alternateExchange()
+	if alternateExchange == "" {
+		return nil
+	}
+
+	if err := channel.ExchangeDeclare(
+		alternateExchange, // name of the exchange
+		"fanout",          // type
+		true,              // durable
+		false,             // delete when complete
+		false,             // internal
+		false,             // noWait
+		nil,               // arguments
+	); err != nil {
+		return fmt.Errorf("Alternate exchange declare error: %s", err)
+	}
+	if _, err := channel.QueueDeclare(
+		alternateExchange, // name
+		true,              // durable
+		false,             // delete when unused
+		false,             // exclusive
+		false,             // no-wait
+		nil,               // arguments
+	); err != nil {
+		return fmt.Errorf("Alternate queue declare error: %s", err)
+	}
+	if err := channel.QueueBind(
+		alternateExchange, // name of the queue
+		"",                // binding key
+		alternateExchange, // source exchange
+		false,             // noWait
+		nil,               // arguments
+	); err != nil {
+		return fmt.Errorf("Alternate queue bind error: %s", err)
+	}
+	return nil
+}
+
 func (b *Broker) isDirectExchange() bool {
 	return b.GetConfig().AMQP != nil && b.GetConfig().AMQP.ExchangeType == "direct"
 }
@@ -470,26 +573,9 @@ func (_ *sigDumper) PreConsumeHandler() bool {
 }
 
 func (b *Broker) GetPendingTasks(queue string) ([]*tasks.Signature, error) {
-	if queue == "" {
-		queue = b.GetConfig().DefaultQueue
-	}
-
-	bindingKey := b.GetConfig().AMQP.BindingKey // queue binding key
-	conn, err := b.GetOrOpenConnection(
-		queue,
-		bindingKey, // queue binding key
-		nil,        // exchange declare args
-		amqp.Table(b.GetConfig().AMQP.QueueDeclareArgs), // queue declare args
-		amqp.Table(b.GetConfig().AMQP.QueueBindingArgs), // queue binding args
-	)
-	if err != nil {
-		return nil, errors.Wrapf(err, "Failed to get a connection for queue %s", queue)
-	}
-
-	channel := conn.channel
-	queueInfo, err := channel.QueueInspect(queue)
+	channel, queue, queueInfo, err := b.inspectQueue(queue)
 	if err != nil {
-		return nil, errors.Wrapf(err, "Failed to get info for queue %s", queue)
+		return nil, err
 	}
 
 	var tag uint64
@@ -507,3 +593,127 @@ func (b *Broker) GetPendingTasks(queue string) ([]*tasks.Signature, error) {
 
 	return dumper.Signatures, nil
 }
+
+// DeadLetterQueue returns the queue keeping the unroutable tasks, or
+// ErrNoDeadLetterQueue if no alternate exchange is configured
+func (b *Broker) DeadLetterQueue() (*DeadLetterQueue, error) {
+	_, queueInfo, err := b.inspectDeadLetterQueue()
+	if err != nil {
+		return nil, err
+	}
+	return &DeadLetterQueue{Name: queueInfo.Name, Depth: queueInfo.Messages}, nil
+}
+
+// DeadLetterDepth returns the number of unroutable tasks, 0 if no alternate
+// exchange is configured. The queue is ignored, as unroutable tasks have no
+// queue they belong to.
+func (b *Broker) DeadLetterDepth(queue string) (int, error) {
+	deadLetterQueue, err := b.DeadLetterQueue()
+	if err == ErrNoDeadLetterQueue {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return deadLetterQueue.Depth, nil
+}
+
+// DeadLetterTasks returns the unroutable tasks without removing them from
+// the queue keeping them
+func (b *Broker) DeadLetterTasks() ([]*tasks.Signature, error) {
+	channel, queueInfo, err := b.inspectDeadLetterQueue()
+	if err != nil {
+		return nil, err
+	}
+
+	// Every task got is requeued once done
+	var tag uint64
+	defer func() {
+		if tag != 0 {
+			channel.Nack(tag, true, true) // multiple, requeue
+		}
+	}()
+
+	signatures := make([]*tasks.Signature, 0, queueInfo.Messages)
+	for i := 0; i < queueInfo.Messages; i++ {
+		d, ok, err := channel.Get(queueInfo.Name, false)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to get from queue")
+		}
+		if !ok {
+			break
+		}
+		tag = d.DeliveryTag
+		signature, err := b.DecodeSignature(d.Body)
+		if err != nil {
+			return nil, errs.NewErrCouldNotUnmarshalTaskSignature(d.Body, err)
+		}
+		signatures = append(signatures, signature)
+	}
+	return signatures, nil
+}
+
+// inspectDeadLetterQueue returns a channel to the queue keeping the
+// unroutable tasks along with its state
+func (b *Broker) inspectDeadLetterQueue() (*amqp.Channel, amqp.Queue, error) {
+	alternateExchange := b.alternateExchange()
+	if alternateExchange == "" {
+		return nil, amqp.Queue{}, ErrNoDeadLetterQueue
+	}
+
+	// Connecting to the default queue declares the alternate exchange and
+	// its queue
+	channel, _, _, err := b.inspectQueue("")
+	if err != nil {
+		return nil, amqp.Queue{}, err
+	}
+	queueInfo, err := channel.QueueInspect(alternateExchange)
+	if err != nil {
+		return nil, amqp.Queue{}, errors.Wrapf(err, "Failed to get info for queue %s", alternateExchange)
+	}
+	return channel, queueInfo, nil
+}
+
+// MaxDeliveryDelay returns no limit, delayed tasks wait in a queue whose
+// messages expire at their ETA into the exchange of the task
+func (b *Broker) MaxDeliveryDelay(signature *tasks.Signature) (time.Duration, bool) {
+	return 0, true
+}
+
+// QueueDepth returns the number of messages waiting in the queue, the default
+// queue if it is empty
+func (b *Broker) QueueDepth(queue string) (int, error) {
+	_, _, queueInfo, err := b.inspectQueue(queue)
+	if err != nil {
+		return 0, err
+	}
+	return queueInfo.Messages, nil
+}
+
+// inspectQueue returns a channel to the queue, the default queue if it is
+// empty, along with its namespaced name and state
+func (b *Broker) inspectQueue(queue string) (*amqp.Channel, string, amqp.Queue, error) {
+	if queue == "" {
+		queue = b.GetConfig().DefaultQueue
+	}
+	queue = b.GetConfig().Namespaced(queue)
+
+	bindingKey := b.GetConfig().AMQP.BindingKey // queue binding key
+	conn, err := b.GetOrOpenConnection(
+		queue,
+		bindingKey,              // queue binding key
+		b.exchangeDeclareArgs(), // exchange declare args
+		amqp.Table(b.GetConfig().AMQP.QueueDeclareArgs), // queue declare args
+		amqp.Table(b.GetConfig().AMQP.QueueBindingArgs), // queue binding args
+	)
+	if err != nil {
+		return nil, queue, amqp.Queue{}, errors.Wrapf(err, "Failed to get a connection for queue %s", queue)
+	}
+
+	channel := conn.channel
+	queueInfo, err := channel.QueueInspect(queue)
+	if err != nil {
+		return nil, queue, amqp.Queue{}, errors.Wrapf(err, "Failed to get info for queue %s", queue)
+	}
+	return channel, queue, queueInfo, nil
+}
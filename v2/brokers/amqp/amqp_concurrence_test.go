@@ -58,3 +58,35 @@ func TestConsume(t *testing.T) {
 		}
 	})
 }
+
+// prefetchProcessor is a processor with a prefetch count
+type prefetchProcessor struct {
+	doNothingProcessor
+	count int
+}
+
+func (p prefetchProcessor) PrefetchCount() (int, bool) {
+	return p.count, true
+}
+
+func TestQos(t *testing.T) {
+	broker := New(&config.Config{AMQP: &config.AMQPConfig{PrefetchCount: 3}}).(*Broker)
+	if qos := broker.qos(10, doNothingProcessor{}); qos != 3 {
+		t.Errorf("expected the configured prefetch count, got %d", qos)
+	}
+
+	if qos := broker.qos(10, prefetchProcessor{count: 0}); qos != 10 {
+		t.Errorf("expected a prefetch count of 10, got %d", qos)
+	}
+	if qos := broker.qos(0, prefetchProcessor{count: 0}); qos != 0 {
+		t.Errorf("expected no prefetch limit without concurrency, got %d", qos)
+	}
+
+	// Every consumer of the broker has its own prefetch count
+	if qos := broker.qos(10, prefetchProcessor{count: 2}); qos != 12 {
+		t.Errorf("expected a prefetch count of 12, got %d", qos)
+	}
+	if qos := broker.qos(10, doNothingProcessor{}); qos != 3 {
+		t.Errorf("expected the configured prefetch count, got %d", qos)
+	}
+}
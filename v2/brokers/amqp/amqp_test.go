@@ -44,3 +44,20 @@ func TestAdjustRoutingKey(t *testing.T) {
 		assert.Equal(t, "binding_key", s.RoutingKey)
 	})
 }
+
+func TestDeadLetterQueueWithoutAlternateExchange(t *testing.T) {
+	t.Parallel()
+
+	broker := amqp.New(&config.Config{
+		DefaultQueue: "queue",
+		AMQP:         &config.AMQPConfig{Exchange: "exchange", ExchangeType: "direct"},
+	}).(*amqp.Broker)
+
+	_, err := broker.DeadLetterQueue()
+	assert.Equal(t, amqp.ErrNoDeadLetterQueue, err)
+	_, err = broker.DeadLetterTasks()
+	assert.Equal(t, amqp.ErrNoDeadLetterQueue, err)
+	depth, err := broker.DeadLetterDepth("queue")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, depth)
+}
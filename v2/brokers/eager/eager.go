@@ -1,9 +1,7 @@
 package eager
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 
@@ -44,18 +42,15 @@ func (eagerBroker *Broker) Publish(ctx context.Context, task *tasks.Signature) e
 		return errors.New("worker is not assigned in eager-mode")
 	}
 
-	// faking the behavior to marshal input into json
-	// and unmarshal it back
-	message, err := json.Marshal(task)
+	// faking the behavior to encode the task and decode it back
+	message, err := eagerBroker.EncodeSignature(task)
 	if err != nil {
-		return fmt.Errorf("JSON marshal error: %s", err)
+		return fmt.Errorf("Encode signature error: %s", err)
 	}
 
-	signature := new(tasks.Signature)
-	decoder := json.NewDecoder(bytes.NewReader(message))
-	decoder.UseNumber()
-	if err := decoder.Decode(signature); err != nil {
-		return fmt.Errorf("JSON unmarshal error: %s", err)
+	signature, err := eagerBroker.DecodeSignature(message)
+	if err != nil {
+		return fmt.Errorf("Decode signature error: %s", err)
 	}
 
 	// blocking call to the task directly
@@ -24,5 +24,8 @@ func NewErrCouldNotUnmarshalTaskSignature(msg []byte, err error) ErrCouldNotUnma
 // ErrConsumerStopped indicates that the operation is now illegal because of the consumer being stopped.
 var ErrConsumerStopped = errors.New("the server has been stopped")
 
+// ErrDelayedTaskNotFound indicates that the broker doesn't hold the task until its ETA, e.g. because it was delivered already
+var ErrDelayedTaskNotFound = errors.New("delayed task not found")
+
 // ErrStopTaskDeletion indicates that the task should not be deleted from source after task failure
 var ErrStopTaskDeletion = errors.New("task should not be deleted")
@@ -1,9 +1,10 @@
+//go:build !machinery_no_gcp
+// +build !machinery_no_gcp
+
 package gcppubsub
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"time"
 
@@ -11,7 +12,6 @@ import (
 	"github.com/RichardKnop/machinery/v2/brokers/iface"
 	"github.com/RichardKnop/machinery/v2/common"
 	"github.com/RichardKnop/machinery/v2/config"
-	"github.com/RichardKnop/machinery/v2/log"
 	"github.com/RichardKnop/machinery/v2/tasks"
 )
 
@@ -29,7 +29,7 @@ type Broker struct {
 // New creates new Broker instance
 func New(cnf *config.Config, projectID, subscriptionName string) (iface.Broker, error) {
 	b := &Broker{Broker: common.NewBroker(cnf), stopDone: make(chan struct{})}
-	b.subscriptionName = subscriptionName
+	b.subscriptionName = cnf.Namespaced(subscriptionName)
 
 	ctx := context.Background()
 
@@ -51,7 +51,7 @@ func New(cnf *config.Config, projectID, subscriptionName string) (iface.Broker,
 	}
 
 	// Validate topic exists
-	defaultQueue := b.GetConfig().DefaultQueue
+	defaultQueue := b.GetConfig().Namespaced(b.GetConfig().DefaultQueue)
 	topic := b.service.Topic(defaultQueue)
 	defer topic.Stop()
 
@@ -92,7 +92,7 @@ func (b *Broker) StartConsuming(consumerTag string, concurrency int, taskProcess
 	}
 
 	sub.ReceiveSettings.NumGoroutines = concurrency
-	log.INFO.Print("[*] Waiting for messages. To exit press CTRL+C")
+	b.Logger().INFO.Print("[*] Waiting for messages. To exit press CTRL+C")
 
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
@@ -102,13 +102,13 @@ func (b *Broker) StartConsuming(consumerTag string, concurrency int, taskProcess
 
 	for {
 		err := sub.Receive(ctx, func(_ctx context.Context, msg *pubsub.Message) {
-			b.consumeOne(msg, taskProcessor)
+			b.consumeOne(_ctx, msg, taskProcessor)
 		})
 		if err == nil {
 			break
 		}
 
-		log.ERROR.Printf("Error when receiving messages. Error: %v", err)
+		b.Logger().ERROR.Printf("Error when receiving messages. Error: %v", err)
 		continue
 	}
 
@@ -131,64 +131,104 @@ func (b *Broker) Publish(ctx context.Context, signature *tasks.Signature) error
 	// Adjust routing key (this decides which queue the message will be published to)
 	b.AdjustRoutingKey(signature)
 
-	msg, err := json.Marshal(signature)
+	msg, err := b.EncodeSignature(signature)
 	if err != nil {
-		return fmt.Errorf("JSON marshal error: %s", err)
+		return fmt.Errorf("Encode signature error: %s", err)
 	}
 
-	topic := b.service.Topic(signature.RoutingKey)
+	// Pub/Sub can't delay messages, tasks with an ETA in the future are held
+	// by the worker receiving them until their ETA
+	topic := b.service.Topic(b.GetConfig().Namespaced(signature.RoutingKey))
 	defer topic.Stop()
 
-	// Check the ETA signature field, if it is set and it is in the future,
-	// delay the task
-	if signature.ETA != nil {
-		now := time.Now().UTC()
-
-		if signature.ETA.After(now) {
-			topic.PublishSettings.DelayThreshold = signature.ETA.Sub(now)
-		}
-	}
-
 	result := topic.Publish(ctx, &pubsub.Message{
 		Data: msg,
 	})
 
 	id, err := result.Get(ctx)
 	if err != nil {
-		log.ERROR.Printf("Error when sending a message: %v", err)
+		b.Logger().ERROR.Printf("Error when sending a message: %v", err)
 		return err
 	}
 
-	log.INFO.Printf("Sending a message successfully, server-generated message ID %v", id)
+	b.Logger().INFO.Printf("Sending a message successfully, server-generated message ID %v", id)
 	return nil
 }
 
+// MaxDeliveryDelay returns how long the worker receiving a task holds it
+// until its ETA, the lease of the message is extended meanwhile
+func (b *Broker) MaxDeliveryDelay(signature *tasks.Signature) (time.Duration, bool) {
+	maxHold := b.maxHold()
+	return maxHold, maxHold > 0
+}
+
+// maxHold returns how long a message may be held, a tenth shorter than its
+// lease is extended for so Pub/Sub doesn't deliver it again meanwhile
+func (b *Broker) maxHold() time.Duration {
+	maxExtension := b.MaxExtension
+	if maxExtension == 0 {
+		maxExtension = pubsub.DefaultReceiveSettings.MaxExtension
+	}
+	return maxExtension - maxExtension/10
+}
+
+// holdUntilETA waits until the ETA of the task, or as long as the message
+// may be held, and returns false if consuming stopped meanwhile
+func (b *Broker) holdUntilETA(ctx context.Context, signature *tasks.Signature) bool {
+	if signature.ETA == nil {
+		return true
+	}
+	delay := time.Until(*signature.ETA)
+	if maxHold := b.maxHold(); delay > maxHold {
+		delay = maxHold
+	}
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
 // consumeOne processes a single message using TaskProcessor
-func (b *Broker) consumeOne(delivery *pubsub.Message, taskProcessor iface.TaskProcessor) {
+func (b *Broker) consumeOne(ctx context.Context, delivery *pubsub.Message, taskProcessor iface.TaskProcessor) {
 	if len(delivery.Data) == 0 {
 		delivery.Nack()
-		log.ERROR.Printf("received an empty message, the delivery was %v", delivery)
+		b.Logger().ERROR.Printf("received an empty message, the delivery was %v", delivery)
 	}
 
-	sig := new(tasks.Signature)
-	decoder := json.NewDecoder(bytes.NewBuffer(delivery.Data))
-	decoder.UseNumber()
-	if err := decoder.Decode(sig); err != nil {
+	sig, err := b.DecodeSignature(delivery.Data)
+	if err != nil {
 		delivery.Nack()
-		log.ERROR.Printf("unmarshal error. the delivery is %v", delivery)
+		b.Logger().ERROR.Printf("unmarshal error: %s. the delivery is %v", err, delivery)
+		return
 	}
 
 	// If the task is not registered return an error
 	// and leave the message in the queue
 	if !b.IsTaskRegistered(sig.Name) {
 		delivery.Nack()
-		log.ERROR.Printf("task %s is not registered", sig.Name)
+		b.Logger().ERROR.Printf("task %s is not registered", sig.Name)
+		return
+	}
+
+	// Tasks delivered before their ETA are held, tasks whose ETA is further
+	// away than the message may be held are published again by the worker
+	if !b.holdUntilETA(ctx, sig) {
+		delivery.Nack()
+		return
 	}
 
-	err := taskProcessor.Process(sig)
+	err = taskProcessor.Process(sig)
 	if err != nil {
 		delivery.Nack()
-		log.ERROR.Printf("Failed process of task", err)
+		b.Logger().ERROR.Printf("Failed process of task", err)
 	}
 
 	// Call Ack() after successfully consuming and processing the message
@@ -0,0 +1,94 @@
+//go:build !machinery_no_gcp
+// +build !machinery_no_gcp
+
+package gcppubsub_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+
+	"github.com/RichardKnop/machinery/v2/brokers/gcppubsub"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+type taskProcessor struct {
+	processed chan time.Time
+}
+
+func (p *taskProcessor) Process(signature *tasks.Signature) error {
+	p.processed <- time.Now()
+	return nil
+}
+
+func (p *taskProcessor) CustomQueue() string {
+	return ""
+}
+
+func (p *taskProcessor) PreConsumeHandler() bool {
+	return true
+}
+
+func newBroker(t *testing.T, maxExtension time.Duration) *gcppubsub.Broker {
+	ctx := context.Background()
+	server := pstest.NewServer()
+	t.Cleanup(func() { server.Close() })
+
+	conn, err := grpc.Dial(server.Addr, grpc.WithInsecure())
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	client, err := pubsub.NewClient(ctx, "project", option.WithGRPCConn(conn))
+	assert.NoError(t, err)
+
+	topic, err := client.CreateTopic(ctx, "machinery_tasks")
+	assert.NoError(t, err)
+	_, err = client.CreateSubscription(ctx, "machinery_worker", pubsub.SubscriptionConfig{Topic: topic})
+	assert.NoError(t, err)
+
+	broker, err := gcppubsub.New(&config.Config{
+		DefaultQueue: "machinery_tasks",
+		GCPPubSub:    &config.GCPPubSubConfig{Client: client, MaxExtension: maxExtension},
+	}, "project", "machinery_worker")
+	assert.NoError(t, err)
+	broker.SetRegisteredTaskNames([]string{"add"})
+	return broker.(*gcppubsub.Broker)
+}
+
+func TestTaskIsHeldUntilETA(t *testing.T) {
+	broker := newBroker(t, 0)
+
+	eta := time.Now().Add(time.Second)
+	assert.NoError(t, broker.Publish(context.Background(), &tasks.Signature{UUID: "task_1", Name: "add", ETA: &eta}))
+
+	processor := &taskProcessor{processed: make(chan time.Time, 1)}
+	go broker.StartConsuming("worker", 1, processor)
+	defer broker.StopConsuming()
+
+	select {
+	case processed := <-processor.processed:
+		assert.False(t, processed.Before(eta))
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the task")
+	}
+}
+
+func TestMaxDeliveryDelay(t *testing.T) {
+	maxDelay, ok := newBroker(t, 0).MaxDeliveryDelay(&tasks.Signature{})
+	assert.True(t, ok)
+	assert.Equal(t, 54*time.Minute, maxDelay)
+
+	maxDelay, ok = newBroker(t, 10*time.Second).MaxDeliveryDelay(&tasks.Signature{})
+	assert.True(t, ok)
+	assert.Equal(t, 9*time.Second, maxDelay)
+
+	// Leases which aren't extended can't hold tasks
+	_, ok = newBroker(t, -1).MaxDeliveryDelay(&tasks.Signature{})
+	assert.False(t, ok)
+}
@@ -0,0 +1,339 @@
+package http
+
+// NOTE: The HTTP broker is meant for edge workers which are not allowed to
+// connect to a message broker. Workers pull tasks from an endpoint owned by
+// the user instead, using the following protocol. All requests are POSTs
+// with a JSON body, paths are relative to the Broker URL:
+//
+// 1) /lease with LeaseRequest, responds with LeaseResponse. Leased tasks must
+//    not be handed out again until they are acknowledged, rejected or
+//    LeaseTimeout seconds pass. Tasks with ETA in the future must not be
+//    leased before their ETA.
+// 2) /ack with AckRequest removes a processed task for good.
+// 3) /nack with AckRequest makes a task available for leasing again.
+// 4) /publish with a tasks.Signature adds a task to the queue named by the
+//    signature's RoutingKey, workers publish retries and callbacks this way.
+//
+// Any non 2xx status code is treated as an error.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RichardKnop/machinery/v2/brokers/errs"
+	"github.com/RichardKnop/machinery/v2/brokers/iface"
+	"github.com/RichardKnop/machinery/v2/common"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+// Paths of the HTTP task source protocol
+const (
+	LeasePath   = "/lease"
+	AckPath     = "/ack"
+	NackPath    = "/nack"
+	PublishPath = "/publish"
+)
+
+const (
+	defaultLeaseTimeout = 30
+	defaultPollInterval = 1
+)
+
+// LeaseRequest asks for up to Max tasks from a queue
+type LeaseRequest struct {
+	Queue        string `json:"queue"`
+	ConsumerTag  string `json:"consumer_tag"`
+	Max          int    `json:"max"`
+	LeaseTimeout int    `json:"lease_timeout"`
+}
+
+// Lease is a task handed out to a single worker
+type Lease struct {
+	ID        string           `json:"id"`
+	Signature *tasks.Signature `json:"signature"`
+}
+
+// LeaseResponse holds leased tasks, it is empty if the queue has none
+type LeaseResponse struct {
+	Leases []*Lease `json:"leases"`
+}
+
+// AckRequest acknowledges or rejects a lease
+type AckRequest struct {
+	ID string `json:"id"`
+}
+
+// Broker represents a broker pulling tasks from an HTTP endpoint
+type Broker struct {
+	common.Broker
+	client       *http.Client
+	endpoint     string
+	leaseTimeout int
+	pollInterval time.Duration
+	processingWG sync.WaitGroup // use wait group to make sure task processing completes on interrupt signal
+}
+
+// New creates new Broker instance
+func New(cnf *config.Config) iface.Broker {
+	b := &Broker{
+		Broker:       common.NewBroker(cnf),
+		client:       http.DefaultClient,
+		endpoint:     strings.TrimRight(cnf.Broker, "/"),
+		leaseTimeout: defaultLeaseTimeout,
+		pollInterval: defaultPollInterval * time.Second,
+	}
+
+	if cnf.HTTP != nil {
+		if cnf.HTTP.Client != nil {
+			b.client = cnf.HTTP.Client
+		}
+		if cnf.HTTP.LeaseTimeout > 0 {
+			b.leaseTimeout = cnf.HTTP.LeaseTimeout
+		}
+		if cnf.HTTP.PollInterval > 0 {
+			b.pollInterval = time.Duration(cnf.HTTP.PollInterval) * time.Second
+		}
+	}
+
+	return b
+}
+
+// StartConsuming enters a loop and leases tasks until stopped
+func (b *Broker) StartConsuming(consumerTag string, concurrency int, taskProcessor iface.TaskProcessor) (bool, error) {
+	b.Broker.StartConsuming(consumerTag, concurrency, taskProcessor)
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	queue := b.GetConfig().DefaultQueue
+	if taskProcessor.CustomQueue() != "" {
+		queue = taskProcessor.CustomQueue()
+	}
+
+	// initialize worker pool with maxWorkers workers
+	pool := make(chan struct{}, concurrency)
+	for i := 0; i < concurrency; i++ {
+		pool <- struct{}{}
+	}
+
+	b.Logger().INFO.Printf("[*] Waiting for tasks from %s on queue: %s. To exit press CTRL+C", b.endpoint, queue)
+
+	for {
+		select {
+		case <-b.GetStopChan():
+			return b.GetRetry(), nil
+		case <-pool:
+		}
+
+		// lease as many tasks as there are idle workers
+		idle := 1
+	collect:
+		for idle < concurrency {
+			select {
+			case <-pool:
+				idle++
+			default:
+				break collect
+			}
+		}
+
+		leases, err := b.lease(consumerTag, queue, idle)
+		if err != nil {
+			b.Logger().ERROR.Printf("Lease tasks error: %s", err)
+		}
+
+		select {
+		case <-b.GetStopChan():
+			for _, lease := range leases {
+				b.release(lease)
+			}
+			return b.GetRetry(), nil
+		default:
+		}
+
+		for _, lease := range leases {
+			b.processingWG.Add(1)
+
+			// Consume the task inside a goroutine so multiple tasks
+			// can be processed concurrently
+			go func(lease *Lease) {
+				defer b.processingWG.Done()
+
+				if err := b.consumeOne(lease, taskProcessor); err != nil {
+					b.Logger().ERROR.Printf("Failed to process task %s: %s", lease.ID, err)
+				}
+
+				// give worker back to pool
+				pool <- struct{}{}
+			}(lease)
+		}
+
+		for i := len(leases); i < idle; i++ {
+			pool <- struct{}{}
+		}
+
+		// Nothing to do, wait before polling again
+		if len(leases) == 0 {
+			select {
+			case <-b.GetStopChan():
+				return b.GetRetry(), nil
+			case <-time.After(b.pollInterval):
+			}
+		}
+	}
+}
+
+// StopConsuming quits the loop
+func (b *Broker) StopConsuming() {
+	b.Broker.StopConsuming()
+
+	// Waiting for any tasks being processed to finish
+	b.processingWG.Wait()
+}
+
+// Publish sends a new task to the endpoint
+func (b *Broker) Publish(ctx context.Context, signature *tasks.Signature) error {
+	// Check that signature.RoutingKey is set, if not switch to DefaultQueue
+	b.AdjustRoutingKey(signature)
+
+	resp, err := b.post(ctx, PublishPath, signature)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// MaxDeliveryDelay returns no limit, the endpoint doesn't lease tasks before
+// their ETA
+func (b *Broker) MaxDeliveryDelay(signature *tasks.Signature) (time.Duration, bool) {
+	return 0, true
+}
+
+// consumeOne processes a leased task and acknowledges it afterwards. Tasks
+// which could not be processed are rejected so they are leased again.
+func (b *Broker) consumeOne(lease *Lease, taskProcessor iface.TaskProcessor) error {
+	if lease.Signature == nil {
+		// Nothing to process, remove the lease from the queue
+		if err := b.ack(lease.ID); err != nil {
+			b.Logger().ERROR.Printf("Failed to acknowledge lease %s: %s", lease.ID, err)
+		}
+		return fmt.Errorf("lease %s has no signature", lease.ID)
+	}
+
+	// The lease is decoded along with the response, only validate it
+	if b.GetConfig().StrictDecoding {
+		if err := lease.Signature.Validate(); err != nil {
+			if ackErr := b.ack(lease.ID); ackErr != nil {
+				b.Logger().ERROR.Printf("Failed to acknowledge lease %s: %s", lease.ID, ackErr)
+			}
+			return fmt.Errorf("lease %s has an invalid signature: %s", lease.ID, err)
+		}
+	}
+
+	// If the task is not registered return an error
+	// and leave the task in the queue
+	if !b.IsTaskRegistered(lease.Signature.Name) {
+		if lease.Signature.IgnoreWhenTaskNotRegistered {
+			return b.ack(lease.ID)
+		}
+		b.release(lease)
+		return fmt.Errorf("task %s is not registered", lease.Signature.Name)
+	}
+
+	if err := taskProcessor.Process(lease.Signature); err != nil {
+		// let the lease time out instead of acknowledging the task
+		if err == errs.ErrStopTaskDeletion {
+			return nil
+		}
+		b.release(lease)
+		return err
+	}
+
+	return b.ack(lease.ID)
+}
+
+// lease asks the endpoint for up to max tasks
+func (b *Broker) lease(consumerTag, queue string, max int) ([]*Lease, error) {
+	resp, err := b.post(context.Background(), LeasePath, &LeaseRequest{
+		Queue:        queue,
+		ConsumerTag:  consumerTag,
+		Max:          max,
+		LeaseTimeout: b.leaseTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+
+	leaseResp := new(LeaseResponse)
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(leaseResp); err != nil {
+		return nil, fmt.Errorf("Decode lease response error: %s", err)
+	}
+
+	return leaseResp.Leases, nil
+}
+
+// ack removes the task from the queue
+func (b *Broker) ack(leaseID string) error {
+	resp, err := b.post(context.Background(), AckPath, &AckRequest{ID: leaseID})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// release makes the task available to other workers, failing to do so is
+// not fatal as the lease times out eventually
+func (b *Broker) release(lease *Lease) {
+	resp, err := b.post(context.Background(), NackPath, &AckRequest{ID: lease.ID})
+	if err != nil {
+		b.Logger().ERROR.Printf("Failed to release lease %s: %s", lease.ID, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// post sends a JSON encoded body to the endpoint, the caller must close the
+// body of the returned response
+func (b *Broker) post(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("JSON marshal error: %s", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.endpoint+path, bytes.NewReader(encoded))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("POST %s returned %s", path, resp.Status)
+	}
+
+	return resp, nil
+}
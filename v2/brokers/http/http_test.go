@@ -0,0 +1,188 @@
+package http_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	httpbroker "github.com/RichardKnop/machinery/v2/brokers/http"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+// taskSource is a minimal in-memory implementation of the protocol
+type taskSource struct {
+	mu      sync.Mutex
+	queues  map[string][]*tasks.Signature
+	leased  map[string]*tasks.Signature
+	acked   []string
+	nacked  []string
+	counter int
+}
+
+func newTaskSource() *taskSource {
+	return &taskSource{
+		queues: make(map[string][]*tasks.Signature),
+		leased: make(map[string]*tasks.Signature),
+	}
+}
+
+func (s *taskSource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.URL.Path {
+	case httpbroker.PublishPath:
+		signature := new(tasks.Signature)
+		if err := json.NewDecoder(r.Body).Decode(signature); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.queues[signature.RoutingKey] = append(s.queues[signature.RoutingKey], signature)
+	case httpbroker.LeasePath:
+		req := new(httpbroker.LeaseRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		queue := s.queues[req.Queue]
+		if len(queue) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if len(queue) > req.Max {
+			queue = queue[:req.Max]
+		}
+		s.queues[req.Queue] = s.queues[req.Queue][len(queue):]
+
+		resp := new(httpbroker.LeaseResponse)
+		for _, signature := range queue {
+			s.counter++
+			id := fmt.Sprintf("lease_%d", s.counter)
+			s.leased[id] = signature
+			resp.Leases = append(resp.Leases, &httpbroker.Lease{ID: id, Signature: signature})
+		}
+		json.NewEncoder(w).Encode(resp)
+	case httpbroker.AckPath, httpbroker.NackPath:
+		req := new(httpbroker.AckRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if _, ok := s.leased[req.ID]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Path == httpbroker.AckPath {
+			s.acked = append(s.acked, req.ID)
+		} else {
+			s.nacked = append(s.nacked, req.ID)
+		}
+		delete(s.leased, req.ID)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+type taskProcessor struct {
+	processed chan *tasks.Signature
+	err       error
+}
+
+func (p *taskProcessor) Process(signature *tasks.Signature) error {
+	p.processed <- signature
+	return p.err
+}
+
+func (p *taskProcessor) CustomQueue() string {
+	return ""
+}
+
+func (p *taskProcessor) PreConsumeHandler() bool {
+	return true
+}
+
+func newBroker(t *testing.T, source *taskSource) *httpbroker.Broker {
+	server := httptest.NewServer(source)
+	t.Cleanup(server.Close)
+
+	broker := httpbroker.New(&config.Config{
+		Broker:       server.URL,
+		DefaultQueue: "machinery_tasks",
+		HTTP:         &config.HTTPConfig{PollInterval: 1},
+	}).(*httpbroker.Broker)
+	broker.SetRegisteredTaskNames([]string{"add"})
+	return broker
+}
+
+func TestPublishAndConsume(t *testing.T) {
+	t.Parallel()
+
+	source := newTaskSource()
+	broker := newBroker(t, source)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, broker.Publish(context.Background(), &tasks.Signature{UUID: fmt.Sprintf("task_%d", i), Name: "add"}))
+	}
+
+	processor := &taskProcessor{processed: make(chan *tasks.Signature, 3)}
+	go broker.StartConsuming("worker", 2, processor)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case signature := <-processor.processed:
+			assert.Equal(t, "machinery_tasks", signature.RoutingKey)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for tasks")
+		}
+	}
+	broker.StopConsuming()
+
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	assert.Len(t, source.acked, 3)
+	assert.Empty(t, source.leased)
+}
+
+func TestFailedTaskIsReleased(t *testing.T) {
+	t.Parallel()
+
+	source := newTaskSource()
+	broker := newBroker(t, source)
+
+	assert.NoError(t, broker.Publish(context.Background(), &tasks.Signature{UUID: "task_1", Name: "add"}))
+
+	processor := &taskProcessor{processed: make(chan *tasks.Signature, 1), err: errors.New("backend unavailable")}
+	go broker.StartConsuming("worker", 1, processor)
+
+	select {
+	case <-processor.processed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for task")
+	}
+	broker.StopConsuming()
+
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	assert.Empty(t, source.acked)
+	assert.Len(t, source.nacked, 1)
+}
+
+func TestPublishError(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	broker := httpbroker.New(&config.Config{Broker: server.URL, DefaultQueue: "machinery_tasks"})
+	err := broker.Publish(context.Background(), &tasks.Signature{UUID: "task_1", Name: "add"})
+	assert.EqualError(t, err, "POST /publish returned 404 Not Found")
+}
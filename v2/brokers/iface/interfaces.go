@@ -2,8 +2,10 @@ package iface
 
 import (
 	"context"
+	"time"
 
 	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/log"
 	"github.com/RichardKnop/machinery/v2/tasks"
 )
 
@@ -27,3 +29,100 @@ type TaskProcessor interface {
 	CustomQueue() string
 	PreConsumeHandler() bool
 }
+
+// DeadLetterProcessor - a task processor able to keep messages which
+// couldn't be decoded into a task signature as dead letters, so brokers don't
+// drop them
+type DeadLetterProcessor interface {
+	DeadLetterMessage(queue string, message []byte, reason error) error
+}
+
+// QueueDepthBroker - a broker able to count the tasks waiting in a queue
+// without reading them
+type QueueDepthBroker interface {
+	// QueueDepth returns the (approximate) number of tasks waiting in the
+	// queue, the default queue if it is empty
+	QueueDepth(queue string) (int, error)
+}
+
+// DeadLetterBroker - a broker moving messages which were delivered too many
+// times to a dead-letter queue, e.g. SQS with a redrive policy, or which
+// couldn't be routed, e.g. AMQP with an alternate exchange
+type DeadLetterBroker interface {
+	// DeadLetterDepth returns the approximate number of messages in the
+	// dead-letter queue of the queue, the default queue if it is empty
+	DeadLetterDepth(queue string) (int, error)
+}
+
+// DelayedDeliveryBroker - a broker able to hold tasks published with an ETA
+// in the future until the ETA by itself, e.g. SQS with DelaySeconds, so
+// workers don't receive them early
+type DelayedDeliveryBroker interface {
+	// MaxDeliveryDelay returns the longest delay the broker holds the task
+	// for, zero if there is no limit, and false if it can't delay it. Tasks
+	// whose ETA is further away are delivered early, workers publish them
+	// again until their ETA.
+	MaxDeliveryDelay(signature *tasks.Signature) (time.Duration, bool)
+}
+
+// DelayedTaskBroker - a broker able to remove tasks it holds until their ETA,
+// e.g. Redis keeping them in a sorted set, so they can be cancelled or
+// rescheduled
+type DelayedTaskBroker interface {
+	// RemoveDelayedTask removes the task from the delayed tasks and returns
+	// its signature, or errs.ErrDelayedTaskNotFound if the broker doesn't
+	// hold it
+	RemoveDelayedTask(taskUUID string) (*tasks.Signature, error)
+}
+
+// MultiQueueBroker - a broker able to consume several queues at once, e.g.
+// Redis popping from several lists
+type MultiQueueBroker interface {
+	// StartConsumingQueues is StartConsuming for several queues, queues
+	// without a concurrency of their own share concurrency
+	StartConsumingQueues(consumerTag string, concurrency int, queues []ConsumedQueue, p TaskProcessor) (bool, error)
+}
+
+// ConsumedQueue is a queue consumed by a MultiQueueBroker
+type ConsumedQueue struct {
+	// Name is the name of the queue, the default queue if it is empty
+	Name string
+	// Concurrency caps the tasks of the queue processed at once, zero
+	// shares the concurrency of the consumer with other such queues
+	Concurrency int
+	// Weight weighs the queue against the other queues sharing the
+	// concurrency of the consumer, a queue weighing 3 times more than
+	// another one is consumed first 3 times more often. Zero weighs 1.
+	Weight int
+}
+
+// PrefetchBroker - a broker able to take a given number of tasks from the
+// queue ahead of the ones the consumer is processing, e.g. AMQP with a QoS
+// prefetch count. Every consumer takes as many as its PrefetchProcessor asks
+// for.
+type PrefetchBroker interface {
+	// SupportsPrefetch returns true if StartConsuming and
+	// StartConsumingQueues honour the prefetch count of the processor
+	SupportsPrefetch() bool
+}
+
+// PrefetchProcessor - a task processor taking a given number of tasks from
+// the queue ahead of the ones it is processing, see PrefetchBroker
+type PrefetchProcessor interface {
+	// PrefetchCount returns how many tasks the consumer holds waiting for a
+	// free slot, zero taking a task once a slot is free only. False leaves
+	// the default of the broker.
+	PrefetchCount() (int, bool)
+}
+
+// LoggerBroker is implemented by brokers able to log to another logger than
+// the package level loggers of the log package
+type LoggerBroker interface {
+	SetLogger(logger *log.Logger)
+}
+
+// CodecBroker is implemented by brokers able to publish tasks encoded with
+// another codec than the one of the configured wire format
+type CodecBroker interface {
+	SetCodec(codec tasks.Codec)
+}
@@ -1,10 +1,9 @@
 package redis
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
+	"math/rand"
 	"runtime"
 	"strconv"
 	"strings"
@@ -18,7 +17,6 @@ import (
 	"github.com/RichardKnop/machinery/v2/brokers/iface"
 	"github.com/RichardKnop/machinery/v2/common"
 	"github.com/RichardKnop/machinery/v2/config"
-	"github.com/RichardKnop/machinery/v2/log"
 	"github.com/RichardKnop/machinery/v2/tasks"
 )
 
@@ -79,15 +77,22 @@ func NewGR(cnf *config.Config, addrs []string, db int) iface.Broker {
 		b.rclient = redis.NewUniversalClient(ropt)
 	}
 	if cnf.Redis != nil && cnf.Redis.DelayedTasksKey != "" {
-		b.redisDelayedTasksKey = cnf.Redis.DelayedTasksKey
+		b.redisDelayedTasksKey = cnf.Namespaced(cnf.Redis.DelayedTasksKey)
 	} else {
-		b.redisDelayedTasksKey = defaultRedisDelayedTasksKey
+		b.redisDelayedTasksKey = cnf.Namespaced(defaultRedisDelayedTasksKey)
 	}
 	return b
 }
 
 // StartConsuming enters a loop and waits for incoming messages
 func (b *BrokerGR) StartConsuming(consumerTag string, concurrency int, taskProcessor iface.TaskProcessor) (bool, error) {
+	return b.StartConsumingQueues(consumerTag, concurrency, []iface.ConsumedQueue{{Name: taskProcessor.CustomQueue()}}, taskProcessor)
+}
+
+// StartConsumingQueues enters a loop and waits for incoming messages from
+// the queues, queues with a concurrency of their own are consumed on their
+// own
+func (b *BrokerGR) StartConsumingQueues(consumerTag string, concurrency int, queues []iface.ConsumedQueue, taskProcessor iface.TaskProcessor) (bool, error) {
 	b.consumingWG.Add(1)
 	defer b.consumingWG.Done()
 
@@ -112,42 +117,15 @@ func (b *BrokerGR) StartConsuming(consumerTag string, concurrency int, taskProce
 		return b.GetRetry(), errs.ErrConsumerStopped
 	}
 
-	// Channel to which we will push tasks ready for processing by worker
-	deliveries := make(chan []byte, concurrency)
-	pool := make(chan struct{}, concurrency)
+	// The first group failing stops the other groups of the consumer
+	stopConsumer := make(chan struct{})
+	var stopOnce sync.Once
+	stop := func() { stopOnce.Do(func() { close(stopConsumer) }) }
+	defer stop()
 
-	// initialize worker pool with maxWorkers workers
-	for i := 0; i < concurrency; i++ {
-		pool <- struct{}{}
-	}
+	b.Logger().INFO.Print("[*] Waiting for messages. To exit press CTRL+C")
 
-	// A receiving goroutine keeps popping messages from the queue by BLPOP
-	// If the message is valid and can be unmarshaled into a proper structure
-	// we send it to the deliveries channel
-	go func() {
-
-		log.INFO.Print("[*] Waiting for messages. To exit press CTRL+C")
-
-		for {
-			select {
-			// A way to stop this goroutine from b.StopConsuming
-			case <-b.GetStopChan():
-				close(deliveries)
-				return
-			case <-pool:
-				task, _ := b.nextTask(getQueueGR(b.GetConfig(), taskProcessor))
-				//TODO: should this error be ignored?
-				if len(task) > 0 {
-					deliveries <- task
-				}
-
-				pool <- struct{}{}
-			}
-		}
-	}()
-
-	// A goroutine to watch for delayed tasks and push them to deliveries
-	// channel for consumption by the worker
+	// A goroutine to watch for delayed tasks and publish them to their queue
 	b.delayedWG.Add(1)
 	go func() {
 		defer b.delayedWG.Done()
@@ -157,27 +135,41 @@ func (b *BrokerGR) StartConsuming(consumerTag string, concurrency int, taskProce
 			// A way to stop this goroutine from b.StopConsuming
 			case <-b.GetStopChan():
 				return
+			case <-stopConsumer:
+				return
 			default:
 				task, err := b.nextDelayedTask(b.redisDelayedTasksKey)
 				if err != nil {
 					continue
 				}
 
-				signature := new(tasks.Signature)
-				decoder := json.NewDecoder(bytes.NewReader(task))
-				decoder.UseNumber()
-				if err := decoder.Decode(signature); err != nil {
-					log.ERROR.Print(errs.NewErrCouldNotUnmarshalTaskSignature(task, err))
+				signature, err := b.Codec().DecodeSignature(task, false)
+				if err != nil {
+					b.Logger().ERROR.Print(errs.NewErrCouldNotUnmarshalTaskSignature(task, err))
+					continue
 				}
 
 				if err := b.Publish(context.Background(), signature); err != nil {
-					log.ERROR.Print(err)
+					b.Logger().ERROR.Print(err)
 				}
 			}
 		}
 	}()
 
-	if err := b.consume(deliveries, concurrency, taskProcessor); err != nil {
+	groups := groupQueues(b.GetConfig(), concurrency, queues)
+	errorsChan := make(chan error, len(groups))
+	for _, group := range groups {
+		go func(group queueGroup) {
+			errorsChan <- b.consumeGroup(group, taskProcessor, stopConsumer, stop)
+		}(group)
+	}
+
+	for range groups {
+		if groupErr := <-errorsChan; groupErr != nil && err == nil {
+			err = groupErr
+		}
+	}
+	if err != nil {
 		return b.GetRetry(), err
 	}
 
@@ -187,6 +179,87 @@ func (b *BrokerGR) StartConsuming(consumerTag string, concurrency int, taskProce
 	return b.GetRetry(), nil
 }
 
+// consumeGroup pops messages from the queues of the group while a slot of
+// the group is free and processes them
+func (b *BrokerGR) consumeGroup(group queueGroup, taskProcessor iface.TaskProcessor, stopConsumer chan struct{}, stop func()) error {
+	// Channel to which we will push tasks ready for processing by worker
+	deliveries := make(chan delivery, group.concurrency)
+
+	// With a prefetch count the group holds at most its slots plus the
+	// prefetch count of popped messages, consume gives a slot back once its
+	// message is processed
+	slots := group.concurrency
+	var fetched chan struct{}
+	prefetchCount, prefetch := b.PrefetchCount(taskProcessor)
+	if prefetch {
+		slots += prefetchCount
+	}
+	pool := make(chan struct{}, slots)
+	if prefetch {
+		fetched = pool
+	}
+
+	// initialize worker pool with maxWorkers workers
+	for i := 0; i < slots; i++ {
+		pool <- struct{}{}
+	}
+
+	// A receiving goroutine keeps popping messages from the queues by BLPOP
+	// If the message is valid and can be unmarshaled into a proper structure
+	// we send it to the deliveries channel
+	go func() {
+		random := rand.New(rand.NewSource(time.Now().UnixNano()))
+		for {
+			select {
+			// A way to stop this goroutine from b.StopConsuming
+			case <-b.GetStopChan():
+				close(deliveries)
+				return
+			case <-stopConsumer:
+				close(deliveries)
+				return
+			case <-pool:
+				// Both channels may be ready with the slot, don't pop again
+				// once the consumer is stopping
+				select {
+				case <-b.GetStopChan():
+					close(deliveries)
+					return
+				case <-stopConsumer:
+					close(deliveries)
+					return
+				default:
+				}
+
+				if taskProcessor.PreConsumeHandler() {
+					task, _ := b.nextTask(group.order(random)...)
+					//TODO: should this error be ignored?
+					if len(task.body) > 0 {
+						deliveries <- task
+						if fetched != nil {
+							continue
+						}
+					}
+				} else {
+					// Don't ask again right away, e.g. while the worker is paused
+					time.Sleep(b.normalTasksPollPeriod())
+				}
+
+				pool <- struct{}{}
+			}
+		}
+	}()
+
+	return b.consume(deliveries, group.concurrency, taskProcessor, stopConsumer, stop, fetched)
+}
+
+// SupportsPrefetch returns true, consumers pop at most the prefetch count of
+// their processor ahead of the messages they are processing, without one
+// every slot pops one message ahead
+func (b *BrokerGR) SupportsPrefetch() bool {
+	return true
+}
+
 // StopConsuming quits the loop
 func (b *BrokerGR) StopConsuming() {
 	b.Broker.StopConsuming()
@@ -203,9 +276,9 @@ func (b *BrokerGR) Publish(ctx context.Context, signature *tasks.Signature) erro
 	// Adjust routing key (this decides which queue the message will be published to)
 	b.Broker.AdjustRoutingKey(signature)
 
-	msg, err := json.Marshal(signature)
+	msg, err := b.EncodeSignature(signature)
 	if err != nil {
-		return fmt.Errorf("JSON marshal error: %s", err)
+		return fmt.Errorf("Encode signature error: %s", err)
 	}
 
 	// Check the ETA signature field, if it is set and it is in the future,
@@ -220,16 +293,33 @@ func (b *BrokerGR) Publish(ctx context.Context, signature *tasks.Signature) erro
 		}
 	}
 
-	err = b.rclient.RPush(context.Background(), signature.RoutingKey, msg).Err()
+	err = b.rclient.RPush(context.Background(), b.GetConfig().Namespaced(signature.RoutingKey), msg).Err()
 	return err
 }
 
+// MaxDeliveryDelay returns no limit, delayed tasks are kept in a sorted set
+// until their ETA
+func (b *BrokerGR) MaxDeliveryDelay(signature *tasks.Signature) (time.Duration, bool) {
+	return 0, true
+}
+
+// QueueDepth returns the number of tasks waiting in the queue, the default
+// queue if it is empty
+func (b *BrokerGR) QueueDepth(queue string) (int, error) {
+	if queue == "" {
+		queue = b.GetConfig().DefaultQueue
+	}
+	depth, err := b.rclient.LLen(context.Background(), b.GetConfig().Namespaced(queue)).Result()
+	return int(depth), err
+}
+
 // GetPendingTasks returns a slice of task signatures waiting in the queue
 func (b *BrokerGR) GetPendingTasks(queue string) ([]*tasks.Signature, error) {
 
 	if queue == "" {
 		queue = b.GetConfig().DefaultQueue
 	}
+	queue = b.GetConfig().Namespaced(queue)
 	results, err := b.rclient.LRange(context.Background(), queue, 0, -1).Result()
 	if err != nil {
 		return nil, err
@@ -237,10 +327,8 @@ func (b *BrokerGR) GetPendingTasks(queue string) ([]*tasks.Signature, error) {
 
 	taskSignatures := make([]*tasks.Signature, len(results))
 	for i, result := range results {
-		signature := new(tasks.Signature)
-		decoder := json.NewDecoder(strings.NewReader(result))
-		decoder.UseNumber()
-		if err := decoder.Decode(signature); err != nil {
+		signature, err := b.Codec().DecodeSignature([]byte(result), false)
+		if err != nil {
 			return nil, err
 		}
 		taskSignatures[i] = signature
@@ -257,10 +345,8 @@ func (b *BrokerGR) GetDelayedTasks() ([]*tasks.Signature, error) {
 
 	taskSignatures := make([]*tasks.Signature, len(results))
 	for i, result := range results {
-		signature := new(tasks.Signature)
-		decoder := json.NewDecoder(strings.NewReader(result))
-		decoder.UseNumber()
-		if err := decoder.Decode(signature); err != nil {
+		signature, err := b.Codec().DecodeSignature([]byte(result), false)
+		if err != nil {
 			return nil, err
 		}
 		taskSignatures[i] = signature
@@ -268,9 +354,35 @@ func (b *BrokerGR) GetDelayedTasks() ([]*tasks.Signature, error) {
 	return taskSignatures, nil
 }
 
+// RemoveDelayedTask removes the task from the sorted set of delayed tasks and
+// returns its signature. Delayed tasks are searched one by one.
+func (b *BrokerGR) RemoveDelayedTask(taskUUID string) (*tasks.Signature, error) {
+	results, err := b.rclient.ZRange(context.Background(), b.redisDelayedTasksKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		signature, err := b.Codec().DecodeSignature([]byte(result), false)
+		if err != nil || signature.UUID != taskUUID {
+			continue
+		}
+		// The task may have been moved to its queue in the meantime
+		removed, err := b.rclient.ZRem(context.Background(), b.redisDelayedTasksKey, result).Result()
+		if err != nil {
+			return nil, err
+		}
+		if removed == 0 {
+			break
+		}
+		return signature, nil
+	}
+	return nil, errs.ErrDelayedTaskNotFound
+}
+
 // consume takes delivered messages from the channel and manages a worker pool
-// to process tasks concurrently
-func (b *BrokerGR) consume(deliveries <-chan []byte, concurrency int, taskProcessor iface.TaskProcessor) error {
+// to process tasks concurrently, giving a slot back to fetched, unless nil,
+// for every processed message
+func (b *BrokerGR) consume(deliveries <-chan delivery, concurrency int, taskProcessor iface.TaskProcessor, stopConsumer chan struct{}, stop func(), fetched chan<- struct{}) error {
 	errorsChan := make(chan error, concurrency*2)
 	pool := make(chan struct{}, concurrency)
 
@@ -284,6 +396,12 @@ func (b *BrokerGR) consume(deliveries <-chan []byte, concurrency int, taskProces
 	for {
 		select {
 		case err := <-errorsChan:
+			// Stop popping messages for every group of the consumer and
+			// push the ones popped but not processed back
+			stop()
+			for v := range deliveries {
+				b.requeueMessage(v)
+			}
 			return err
 		case d, open := <-deliveries:
 			if !open {
@@ -291,7 +409,12 @@ func (b *BrokerGR) consume(deliveries <-chan []byte, concurrency int, taskProces
 			}
 			if concurrency > 0 {
 				// get execution slot from pool (blocks until one is available)
-				<-pool
+				select {
+				case <-stopConsumer:
+					b.requeueMessage(d)
+					continue
+				case <-pool:
+				}
 			}
 
 			b.processingWG.Add(1)
@@ -309,18 +432,20 @@ func (b *BrokerGR) consume(deliveries <-chan []byte, concurrency int, taskProces
 					// give slot back to pool
 					pool <- struct{}{}
 				}
+				if fetched != nil {
+					fetched <- struct{}{}
+				}
 			}()
 		}
 	}
 }
 
 // consumeOne processes a single message using TaskProcessor
-func (b *BrokerGR) consumeOne(delivery []byte, taskProcessor iface.TaskProcessor) error {
-	signature := new(tasks.Signature)
-	decoder := json.NewDecoder(bytes.NewReader(delivery))
-	decoder.UseNumber()
-	if err := decoder.Decode(signature); err != nil {
-		return errs.NewErrCouldNotUnmarshalTaskSignature(delivery, err)
+func (b *BrokerGR) consumeOne(delivery delivery, taskProcessor iface.TaskProcessor) error {
+	signature, err := b.DecodeSignature(delivery.body)
+	if err != nil {
+		b.DeadLetterMessage(taskProcessor, delivery.body, err)
+		return errs.NewErrCouldNotUnmarshalTaskSignature(delivery.body, err)
 	}
 
 	// If the task is not registered, we requeue it,
@@ -329,20 +454,18 @@ func (b *BrokerGR) consumeOne(delivery []byte, taskProcessor iface.TaskProcessor
 		if signature.IgnoreWhenTaskNotRegistered {
 			return nil
 		}
-		log.INFO.Printf("Task not registered with this worker. Requeuing message: %s", delivery)
-
-		b.rclient.RPush(context.Background(), getQueueGR(b.GetConfig(), taskProcessor), delivery)
+		b.Logger().INFO.Printf("Task not registered with this worker. Requeuing message: %s", delivery.body)
+		b.requeueMessage(delivery)
 		return nil
 	}
 
-	log.DEBUG.Printf("Received new message: %s", delivery)
+	b.Logger().DEBUG.Printf("Received new message: %s", delivery.body)
 
 	return taskProcessor.Process(signature)
 }
 
-// nextTask pops next available task from the default queue
-func (b *BrokerGR) nextTask(queue string) (result []byte, err error) {
-
+// normalTasksPollPeriod returns how long to wait for normal tasks at once
+func (b *BrokerGR) normalTasksPollPeriod() time.Duration {
 	pollPeriodMilliseconds := 1000 // default poll period for normal tasks
 	if b.GetConfig().Redis != nil {
 		configuredPollPeriod := b.GetConfig().Redis.NormalTasksPollPeriod
@@ -350,20 +473,27 @@ func (b *BrokerGR) nextTask(queue string) (result []byte, err error) {
 			pollPeriodMilliseconds = configuredPollPeriod
 		}
 	}
-	pollPeriod := time.Duration(pollPeriodMilliseconds) * time.Millisecond
+	return time.Duration(pollPeriodMilliseconds) * time.Millisecond
+}
 
-	items, err := b.rclient.BLPop(context.Background(), pollPeriod, queue).Result()
+// nextTask pops the next available task from the first of the queues which
+// isn't empty
+func (b *BrokerGR) nextTask(queues ...string) (result delivery, err error) {
+
+	pollPeriod := b.normalTasksPollPeriod()
+
+	items, err := b.rclient.BLPop(context.Background(), pollPeriod, queues...).Result()
 	if err != nil {
-		return []byte{}, err
+		return delivery{}, err
 	}
 
 	// items[0] - the name of the key where an element was popped
 	// items[1] - the value of the popped element
 	if len(items) != 2 {
-		return []byte{}, redis.Nil
+		return delivery{}, redis.Nil
 	}
 
-	result = []byte(items[1])
+	result = delivery{queue: items[0], body: []byte(items[1])}
 
 	return result, nil
 }
@@ -436,10 +566,7 @@ func (b *BrokerGR) nextDelayedTask(key string) (result []byte, err error) {
 	return
 }
 
-func getQueueGR(config *config.Config, taskProcessor iface.TaskProcessor) string {
-	customQueue := taskProcessor.CustomQueue()
-	if customQueue == "" {
-		return config.DefaultQueue
-	}
-	return customQueue
+// requeueMessage pushes the message back to the queue it was popped from
+func (b *BrokerGR) requeueMessage(delivery delivery) {
+	b.rclient.RPush(context.Background(), delivery.queue, delivery.body)
 }
@@ -1,11 +1,10 @@
 package redis
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"math"
+	"math/rand"
 	"runtime"
 	"sync"
 	"time"
@@ -18,7 +17,6 @@ import (
 	"github.com/RichardKnop/machinery/v2/brokers/iface"
 	"github.com/RichardKnop/machinery/v2/common"
 	"github.com/RichardKnop/machinery/v2/config"
-	"github.com/RichardKnop/machinery/v2/log"
 	"github.com/RichardKnop/machinery/v2/tasks"
 )
 
@@ -53,9 +51,9 @@ func New(cnf *config.Config, host, username, password, socketPath string, db int
 	b.socketPath = socketPath
 
 	if cnf.Redis != nil && cnf.Redis.DelayedTasksKey != "" {
-		b.redisDelayedTasksKey = cnf.Redis.DelayedTasksKey
+		b.redisDelayedTasksKey = cnf.Namespaced(cnf.Redis.DelayedTasksKey)
 	} else {
-		b.redisDelayedTasksKey = defaultRedisDelayedTasksKey
+		b.redisDelayedTasksKey = cnf.Namespaced(defaultRedisDelayedTasksKey)
 	}
 
 	return b
@@ -63,6 +61,13 @@ func New(cnf *config.Config, host, username, password, socketPath string, db int
 
 // StartConsuming enters a loop and waits for incoming messages
 func (b *Broker) StartConsuming(consumerTag string, concurrency int, taskProcessor iface.TaskProcessor) (bool, error) {
+	return b.StartConsumingQueues(consumerTag, concurrency, []iface.ConsumedQueue{{Name: taskProcessor.CustomQueue()}}, taskProcessor)
+}
+
+// StartConsumingQueues enters a loop and waits for incoming messages from
+// the queues, queues with a concurrency of their own are consumed on their
+// own
+func (b *Broker) StartConsumingQueues(consumerTag string, concurrency int, queues []iface.ConsumedQueue, taskProcessor iface.TaskProcessor) (bool, error) {
 	b.consumingWG.Add(1)
 	defer b.consumingWG.Done()
 
@@ -90,95 +95,147 @@ func (b *Broker) StartConsuming(consumerTag string, concurrency int, taskProcess
 		return b.GetRetry(), errs.ErrConsumerStopped
 	}
 
-	// Channel to which we will push tasks ready for processing by worker
-	deliveries := make(chan []byte, concurrency)
-	pool := make(chan struct{}, concurrency)
+	// The first group failing stops the other groups of the consumer
 	stopConsumer := make(chan struct{})
+	var stopOnce sync.Once
+	stop := func() { stopOnce.Do(func() { close(stopConsumer) }) }
+	defer stop()
 
-	// initialize worker pool with maxWorkers workers
-	for i := 0; i < concurrency; i++ {
-		pool <- struct{}{}
-	}
+	b.Logger().INFO.Print("[*] Waiting for messages. To exit press CTRL+C")
 
-	// A receiving goroutine keeps popping messages from the queue by BLPOP
-	// If the message is valid and can be unmarshaled into a proper structure
-	// we send it to the deliveries channel
+	// A goroutine to watch for delayed tasks and publish them to their queue
+	b.delayedWG.Add(1)
 	go func() {
-
-		log.INFO.Print("[*] Waiting for messages. To exit press CTRL+C")
+		defer b.delayedWG.Done()
 
 		for {
 			select {
 			// A way to stop this goroutine from b.StopConsuming
 			case <-b.GetStopChan():
-				close(deliveries)
 				return
 			case <-stopConsumer:
-				close(deliveries)
 				return
-			case <-pool:
-				select {
-				case <-b.GetStopChan():
-					close(deliveries)
-					return
-				default:
+			default:
+				task, err := b.nextDelayedTask(b.redisDelayedTasksKey)
+				if err != nil {
+					continue
 				}
 
-				if taskProcessor.PreConsumeHandler() {
-					task, _ := b.nextTask(getQueue(b.GetConfig(), taskProcessor))
-					//TODO: should this error be ignored?
-					if len(task) > 0 {
-						deliveries <- task
-					}
+				signature, err := b.Codec().DecodeSignature(task, false)
+				if err != nil {
+					b.Logger().ERROR.Print(errs.NewErrCouldNotUnmarshalTaskSignature(task, err))
+					continue
 				}
 
-				pool <- struct{}{}
+				if err := b.Publish(context.Background(), signature); err != nil {
+					b.Logger().ERROR.Print(err)
+				}
 			}
 		}
 	}()
 
-	// A goroutine to watch for delayed tasks and push them to deliveries
-	// channel for consumption by the worker
-	b.delayedWG.Add(1)
-	go func() {
-		defer b.delayedWG.Done()
+	groups := groupQueues(b.GetConfig(), concurrency, queues)
+	errorsChan := make(chan error, len(groups))
+	for _, group := range groups {
+		go func(group queueGroup) {
+			errorsChan <- b.consumeGroup(group, taskProcessor, stopConsumer, stop)
+		}(group)
+	}
+
+	for range groups {
+		if groupErr := <-errorsChan; groupErr != nil && err == nil {
+			err = groupErr
+		}
+	}
+	if err != nil {
+		return b.GetRetry(), err
+	}
+
+	// Waiting for any tasks being processed to finish
+	b.processingWG.Wait()
 
+	return b.GetRetry(), nil
+}
+
+// consumeGroup pops messages from the queues of the group while a slot of
+// the group is free and processes them
+func (b *Broker) consumeGroup(group queueGroup, taskProcessor iface.TaskProcessor, stopConsumer chan struct{}, stop func()) error {
+	// Channel to which we will push tasks ready for processing by worker
+	deliveries := make(chan delivery, group.concurrency)
+
+	// With a prefetch count the group holds at most its slots plus the
+	// prefetch count of popped messages, consume gives a slot back once its
+	// message is processed
+	slots := group.concurrency
+	var fetched chan struct{}
+	prefetchCount, prefetch := b.PrefetchCount(taskProcessor)
+	if prefetch {
+		slots += prefetchCount
+	}
+	pool := make(chan struct{}, slots)
+	if prefetch {
+		fetched = pool
+	}
+
+	// initialize worker pool with maxWorkers workers
+	for i := 0; i < slots; i++ {
+		pool <- struct{}{}
+	}
+
+	// A receiving goroutine keeps popping messages from the queues by BLPOP
+	// If the message is valid and can be unmarshaled into a proper structure
+	// we send it to the deliveries channel
+	go func() {
+		random := rand.New(rand.NewSource(time.Now().UnixNano()))
 		for {
 			select {
 			// A way to stop this goroutine from b.StopConsuming
 			case <-b.GetStopChan():
+				close(deliveries)
 				return
 			case <-stopConsumer:
 				close(deliveries)
 				return
-			default:
-				task, err := b.nextDelayedTask(b.redisDelayedTasksKey)
-				if err != nil {
-					continue
+			case <-pool:
+				// Both channels may be ready with the slot, don't pop again
+				// once the consumer is stopping
+				select {
+				case <-b.GetStopChan():
+					close(deliveries)
+					return
+				case <-stopConsumer:
+					close(deliveries)
+					return
+				default:
 				}
 
-				signature := new(tasks.Signature)
-				decoder := json.NewDecoder(bytes.NewReader(task))
-				decoder.UseNumber()
-				if err := decoder.Decode(signature); err != nil {
-					log.ERROR.Print(errs.NewErrCouldNotUnmarshalTaskSignature(task, err))
+				if taskProcessor.PreConsumeHandler() {
+					task, _ := b.nextTask(group.order(random)...)
+					//TODO: should this error be ignored?
+					if len(task.body) > 0 {
+						deliveries <- task
+						if fetched != nil {
+							continue
+						}
+					}
+				} else {
+					// Don't ask again right away, e.g. while the worker is paused
+					time.Sleep(b.normalTasksPollPeriod())
 				}
 
-				if err := b.Publish(context.Background(), signature); err != nil {
-					log.ERROR.Print(err)
-				}
+				pool <- struct{}{}
 			}
 		}
 	}()
 
-	if err := b.consume(deliveries, concurrency, taskProcessor, stopConsumer); err != nil {
-		return b.GetRetry(), err
-	}
-
-	// Waiting for any tasks being processed to finish
-	b.processingWG.Wait()
+	return b.consume(deliveries, group.concurrency, taskProcessor, stopConsumer, stop, fetched)
+}
 
-	return b.GetRetry(), nil
+// SupportsPrefetch returns true, consumers pop at most the prefetch count of
+// their processor ahead of the messages they are processing, without one
+// every slot pops one message ahead
+func (b *Broker) SupportsPrefetch() bool {
+	return true
 }
 
 // StopConsuming quits the loop
@@ -201,9 +258,9 @@ func (b *Broker) Publish(ctx context.Context, signature *tasks.Signature) error
 	// Adjust routing key (this decides which queue the message will be published to)
 	b.Broker.AdjustRoutingKey(signature)
 
-	msg, err := json.Marshal(signature)
+	msg, err := b.EncodeSignature(signature)
 	if err != nil {
-		return fmt.Errorf("JSON marshal error: %s", err)
+		return fmt.Errorf("Encode signature error: %s", err)
 	}
 
 	conn := b.open()
@@ -221,10 +278,28 @@ func (b *Broker) Publish(ctx context.Context, signature *tasks.Signature) error
 		}
 	}
 
-	_, err = conn.Do("RPUSH", signature.RoutingKey, msg)
+	_, err = conn.Do("RPUSH", b.GetConfig().Namespaced(signature.RoutingKey), msg)
 	return err
 }
 
+// MaxDeliveryDelay returns no limit, delayed tasks are kept in a sorted set
+// until their ETA
+func (b *Broker) MaxDeliveryDelay(signature *tasks.Signature) (time.Duration, bool) {
+	return 0, true
+}
+
+// QueueDepth returns the number of tasks waiting in the queue, the default
+// queue if it is empty
+func (b *Broker) QueueDepth(queue string) (int, error) {
+	conn := b.open()
+	defer conn.Close()
+
+	if queue == "" {
+		queue = b.GetConfig().DefaultQueue
+	}
+	return redis.Int(conn.Do("LLEN", b.GetConfig().Namespaced(queue)))
+}
+
 // GetPendingTasks returns a slice of task signatures waiting in the queue
 func (b *Broker) GetPendingTasks(queue string) ([]*tasks.Signature, error) {
 	conn := b.open()
@@ -233,6 +308,7 @@ func (b *Broker) GetPendingTasks(queue string) ([]*tasks.Signature, error) {
 	if queue == "" {
 		queue = b.GetConfig().DefaultQueue
 	}
+	queue = b.GetConfig().Namespaced(queue)
 	dataBytes, err := conn.Do("LRANGE", queue, 0, -1)
 	if err != nil {
 		return nil, err
@@ -244,10 +320,8 @@ func (b *Broker) GetPendingTasks(queue string) ([]*tasks.Signature, error) {
 
 	taskSignatures := make([]*tasks.Signature, len(results))
 	for i, result := range results {
-		signature := new(tasks.Signature)
-		decoder := json.NewDecoder(bytes.NewReader(result))
-		decoder.UseNumber()
-		if err := decoder.Decode(signature); err != nil {
+		signature, err := b.Codec().DecodeSignature(result, false)
+		if err != nil {
 			return nil, err
 		}
 		taskSignatures[i] = signature
@@ -271,10 +345,8 @@ func (b *Broker) GetDelayedTasks() ([]*tasks.Signature, error) {
 
 	taskSignatures := make([]*tasks.Signature, len(results))
 	for i, result := range results {
-		signature := new(tasks.Signature)
-		decoder := json.NewDecoder(bytes.NewReader(result))
-		decoder.UseNumber()
-		if err := decoder.Decode(signature); err != nil {
+		signature, err := b.Codec().DecodeSignature(result, false)
+		if err != nil {
 			return nil, err
 		}
 		taskSignatures[i] = signature
@@ -282,9 +354,38 @@ func (b *Broker) GetDelayedTasks() ([]*tasks.Signature, error) {
 	return taskSignatures, nil
 }
 
+// RemoveDelayedTask removes the task from the sorted set of delayed tasks and
+// returns its signature. Delayed tasks are searched one by one.
+func (b *Broker) RemoveDelayedTask(taskUUID string) (*tasks.Signature, error) {
+	conn := b.open()
+	defer conn.Close()
+
+	results, err := redis.ByteSlices(conn.Do("ZRANGE", b.redisDelayedTasksKey, 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		signature, err := b.Codec().DecodeSignature(result, false)
+		if err != nil || signature.UUID != taskUUID {
+			continue
+		}
+		// The task may have been moved to its queue in the meantime
+		removed, err := redis.Int(conn.Do("ZREM", b.redisDelayedTasksKey, result))
+		if err != nil {
+			return nil, err
+		}
+		if removed == 0 {
+			break
+		}
+		return signature, nil
+	}
+	return nil, errs.ErrDelayedTaskNotFound
+}
+
 // consume takes delivered messages from the channel and manages a worker pool
-// to process tasks concurrently
-func (b *Broker) consume(deliveries <-chan []byte, concurrency int, taskProcessor iface.TaskProcessor, stopConsumer chan struct{}) error {
+// to process tasks concurrently, giving a slot back to fetched, unless nil,
+// for every processed message
+func (b *Broker) consume(deliveries <-chan delivery, concurrency int, taskProcessor iface.TaskProcessor, stopConsumer chan struct{}, stop func(), fetched chan<- struct{}) error {
 	errorsChan := make(chan error, concurrency*2)
 	pool := make(chan struct{}, concurrency)
 
@@ -298,8 +399,11 @@ func (b *Broker) consume(deliveries <-chan []byte, concurrency int, taskProcesso
 	for {
 		select {
 		case err := <-errorsChan:
+			// Stop popping messages for every group of the consumer and
+			// push the ones popped but not processed back
+			stop()
 			for v := range deliveries {
-				b.requeueMessage(v, taskProcessor)
+				b.requeueMessage(v)
 			}
 			return err
 		case d, open := <-deliveries:
@@ -310,7 +414,10 @@ func (b *Broker) consume(deliveries <-chan []byte, concurrency int, taskProcesso
 				// get execution slot from pool (blocks until one is available)
 				select {
 				case <-b.GetStopChan():
-					b.requeueMessage(d, taskProcessor)
+					b.requeueMessage(d)
+					continue
+				case <-stopConsumer:
+					b.requeueMessage(d)
 					continue
 				case <-pool:
 				}
@@ -331,18 +438,20 @@ func (b *Broker) consume(deliveries <-chan []byte, concurrency int, taskProcesso
 					// give slot back to pool
 					pool <- struct{}{}
 				}
+				if fetched != nil {
+					fetched <- struct{}{}
+				}
 			}()
 		}
 	}
 }
 
 // consumeOne processes a single message using TaskProcessor
-func (b *Broker) consumeOne(delivery []byte, taskProcessor iface.TaskProcessor) error {
-	signature := new(tasks.Signature)
-	decoder := json.NewDecoder(bytes.NewReader(delivery))
-	decoder.UseNumber()
-	if err := decoder.Decode(signature); err != nil {
-		return errs.NewErrCouldNotUnmarshalTaskSignature(delivery, err)
+func (b *Broker) consumeOne(delivery delivery, taskProcessor iface.TaskProcessor) error {
+	signature, err := b.DecodeSignature(delivery.body)
+	if err != nil {
+		b.DeadLetterMessage(taskProcessor, delivery.body, err)
+		return errs.NewErrCouldNotUnmarshalTaskSignature(delivery.body, err)
 	}
 
 	// If the task is not registered, we requeue it,
@@ -351,21 +460,18 @@ func (b *Broker) consumeOne(delivery []byte, taskProcessor iface.TaskProcessor)
 		if signature.IgnoreWhenTaskNotRegistered {
 			return nil
 		}
-		log.INFO.Printf("Task not registered with this worker. Requeuing message: %s", delivery)
-		b.requeueMessage(delivery, taskProcessor)
+		b.Logger().INFO.Printf("Task not registered with this worker. Requeuing message: %s", delivery.body)
+		b.requeueMessage(delivery)
 		return nil
 	}
 
-	log.DEBUG.Printf("Received new message: %s", delivery)
+	b.Logger().DEBUG.Printf("Received new message: %s", delivery.body)
 
 	return taskProcessor.Process(signature)
 }
 
-// nextTask pops next available task from the default queue
-func (b *Broker) nextTask(queue string) (result []byte, err error) {
-	conn := b.open()
-	defer conn.Close()
-
+// normalTasksPollPeriod returns how long to wait for normal tasks at once
+func (b *Broker) normalTasksPollPeriod() time.Duration {
 	pollPeriodMilliseconds := 1000 // default poll period for normal tasks
 	if b.GetConfig().Redis != nil {
 		configuredPollPeriod := b.GetConfig().Redis.NormalTasksPollPeriod
@@ -373,7 +479,16 @@ func (b *Broker) nextTask(queue string) (result []byte, err error) {
 			pollPeriodMilliseconds = configuredPollPeriod
 		}
 	}
-	pollPeriod := time.Duration(pollPeriodMilliseconds) * time.Millisecond
+	return time.Duration(pollPeriodMilliseconds) * time.Millisecond
+}
+
+// nextTask pops the next available task from the first of the queues which
+// isn't empty
+func (b *Broker) nextTask(queues ...string) (result delivery, err error) {
+	conn := b.open()
+	defer conn.Close()
+
+	pollPeriod := b.normalTasksPollPeriod()
 
 	// Issue 548: BLPOP expects an integer timeout expresses in seconds.
 	// The call will if the value is a float. Convert to integer using
@@ -382,18 +497,18 @@ func (b *Broker) nextTask(queue string) (result []byte, err error) {
 	//   math.Ceil(0.2) --> 1 (timeout after 1 second)
 	pollPeriodSeconds := math.Ceil(pollPeriod.Seconds())
 
-	items, err := redis.ByteSlices(conn.Do("BLPOP", queue, pollPeriodSeconds))
+	items, err := redis.ByteSlices(conn.Do("BLPOP", redis.Args{}.AddFlat(queues).Add(pollPeriodSeconds)...))
 	if err != nil {
-		return []byte{}, err
+		return delivery{}, err
 	}
 
 	// items[0] - the name of the key where an element was popped
 	// items[1] - the value of the popped element
 	if len(items) != 2 {
-		return []byte{}, redis.ErrNil
+		return delivery{}, redis.ErrNil
 	}
 
-	result = items[1]
+	result = delivery{queue: string(items[0]), body: items[1]}
 
 	return result, nil
 }
@@ -484,16 +599,82 @@ func (b *Broker) open() redis.Conn {
 	return b.pool.Get()
 }
 
-func getQueue(config *config.Config, taskProcessor iface.TaskProcessor) string {
-	customQueue := taskProcessor.CustomQueue()
-	if customQueue == "" {
-		return config.DefaultQueue
+// delivery is a message popped from a queue
+type delivery struct {
+	queue string
+	body  []byte
+}
+
+// queueGroup are queues whose messages are processed sharing the same
+// number of slots
+type queueGroup struct {
+	keys        []string
+	weights     []int
+	concurrency int
+}
+
+// order returns the keys of the group in the order to pop from them, keys
+// are drawn by weight so heavier queues usually come first, yet the others
+// keep being consumed while they don't
+func (g queueGroup) order(random *rand.Rand) []string {
+	if len(g.keys) < 2 {
+		return g.keys
+	}
+
+	keys := append([]string(nil), g.keys...)
+	weights := append([]int(nil), g.weights...)
+	total := 0
+	for _, weight := range weights {
+		total += weight
+	}
+
+	ordered := make([]string, 0, len(keys))
+	for len(keys) > 0 {
+		draw := random.Intn(total)
+		i := 0
+		for draw >= weights[i] {
+			draw -= weights[i]
+			i++
+		}
+		ordered = append(ordered, keys[i])
+		total -= weights[i]
+		keys = append(keys[:i], keys[i+1:]...)
+		weights = append(weights[:i], weights[i+1:]...)
+	}
+	return ordered
+}
+
+// groupQueues returns a group for every queue with a concurrency of its own,
+// and one for the other queues sharing the concurrency of the consumer,
+// weighted against each other. The empty name is the default queue.
+func groupQueues(config *config.Config, concurrency int, queues []iface.ConsumedQueue) []queueGroup {
+	var groups []queueGroup
+	shared := queueGroup{concurrency: concurrency}
+	for _, queue := range queues {
+		name := queue.Name
+		if name == "" {
+			name = config.DefaultQueue
+		}
+		weight := queue.Weight
+		if weight < 1 {
+			weight = 1
+		}
+		if queue.Concurrency > 0 {
+			groups = append(groups, queueGroup{keys: []string{config.Namespaced(name)}, weights: []int{weight}, concurrency: queue.Concurrency})
+			continue
+		}
+		shared.keys = append(shared.keys, config.Namespaced(name))
+		shared.weights = append(shared.weights, weight)
+	}
+	if len(shared.keys) > 0 {
+		groups = append(groups, shared)
 	}
-	return customQueue
+	return groups
 }
 
-func (b *Broker) requeueMessage(delivery []byte, taskProcessor iface.TaskProcessor) {
+// requeueMessage pushes the message back to the queue it was popped from
+func (b *Broker) requeueMessage(delivery delivery) {
 	conn := b.open()
 	defer conn.Close()
-	conn.Do("RPUSH", getQueue(b.GetConfig(), taskProcessor), delivery)
+	conn.Do("RPUSH", delivery.queue, delivery.body)
 }
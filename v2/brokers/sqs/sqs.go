@@ -1,10 +1,15 @@
+//go:build !machinery_no_aws
+// +build !machinery_no_aws
+
 package sqs
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -13,7 +18,6 @@ import (
 	"github.com/RichardKnop/machinery/v2/brokers/iface"
 	"github.com/RichardKnop/machinery/v2/common"
 	"github.com/RichardKnop/machinery/v2/config"
-	"github.com/RichardKnop/machinery/v2/log"
 	"github.com/RichardKnop/machinery/v2/tasks"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -23,9 +27,32 @@ import (
 )
 
 const (
-	maxAWSSQSDelay = time.Minute * 15 // Max supported SQS delay is 15 min: https://docs.aws.amazon.com/AWSSimpleQueueService/latest/APIReference/API_SendMessage.html
+	maxAWSSQSDelay   = time.Minute * 15 // Max supported SQS delay is 15 min: https://docs.aws.amazon.com/AWSSimpleQueueService/latest/APIReference/API_SendMessage.html
+	maxAWSSQSBatch   = 10               // Max number of messages received at once: https://docs.aws.amazon.com/AWSSimpleQueueService/latest/APIReference/API_ReceiveMessage.html
+	preConsumePeriod = time.Second      // How long to wait before asking the pre-consume handler again
+)
+
+var (
+	// ErrNoDeadLetterQueue is returned for queues without a redrive policy
+	ErrNoDeadLetterQueue = errors.New("Queue has no dead-letter queue")
 )
 
+// DeadLetterQueue is the queue SQS moves messages of a source queue to once
+// they were received MaxReceiveCount times, as set by its redrive policy
+type DeadLetterQueue struct {
+	ARN             string
+	URL             string
+	MaxReceiveCount int
+	// Depth is the approximate number of messages in the dead-letter queue
+	Depth int
+}
+
+// redrivePolicy is the RedrivePolicy attribute of a source queue
+type redrivePolicy struct {
+	DeadLetterTargetArn string      `json:"deadLetterTargetArn"`
+	MaxReceiveCount     json.Number `json:"maxReceiveCount"`
+}
+
 // Broker represents a AWS SQS broker
 // There are examples on: https://docs.aws.amazon.com/sdk-for-go/v1/developer-guide/sqs-example-create-queue.html
 type Broker struct {
@@ -36,6 +63,10 @@ type Broker struct {
 	sess              *session.Session
 	service           sqsiface.SQSAPI
 	queueUrl          *string
+
+	// running holds a slot for every task being processed by a consumer
+	// with a prefetch count
+	running chan struct{}
 }
 
 // New creates new Broker instance
@@ -64,11 +95,20 @@ func (b *Broker) StartConsuming(consumerTag string, concurrency int, taskProcess
 	//save it so that it can be used later when attempting to delete task
 	b.queueUrl = qURL
 
-	deliveries := make(chan *awssqs.ReceiveMessageOutput, concurrency)
-	pool := make(chan struct{}, concurrency)
+	// With a prefetch count the pool holds the messages received and not
+	// processed yet, at most concurrency of them being processed at once
+	slots := concurrency
+	b.running = nil
+	if prefetchCount, prefetch := b.PrefetchCount(taskProcessor); prefetch && concurrency > 0 {
+		slots += prefetchCount
+		b.running = make(chan struct{}, concurrency)
+	}
+
+	deliveries := make(chan *awssqs.ReceiveMessageOutput, slots)
+	pool := make(chan struct{}, slots)
 
 	// initialize worker pool with maxWorkers workers
-	for i := 0; i < concurrency; i++ {
+	for i := 0; i < slots; i++ {
 		pool <- struct{}{}
 	}
 	b.stopReceivingChan = make(chan int)
@@ -77,7 +117,7 @@ func (b *Broker) StartConsuming(consumerTag string, concurrency int, taskProcess
 	go func() {
 		defer b.receivingWG.Done()
 
-		log.INFO.Printf("[*] Waiting for messages on queue: %s. To exit press CTRL+C\n", *qURL)
+		b.Logger().INFO.Printf("[*] Waiting for messages on queue: %s. To exit press CTRL+C\n", *qURL)
 
 		for {
 			select {
@@ -86,17 +126,34 @@ func (b *Broker) StartConsuming(consumerTag string, concurrency int, taskProcess
 				close(deliveries)
 				return
 			case <-pool:
-				output, err := b.receiveMessage(qURL)
-				if err == nil && len(output.Messages) > 0 {
-					deliveries <- output
-
-				} else {
-					//return back to pool right away
+				if !taskProcessor.PreConsumeHandler() {
+					// Don't receive messages, e.g. while the worker is paused
 					pool <- struct{}{}
-					if err != nil {
-						log.ERROR.Printf("Queue consume error: %s", err)
-					}
+					time.Sleep(preConsumePeriod)
+					continue
+				}
 
+				// Receive as many messages as there are free slots
+				free := 1
+				for free < maxAWSSQSBatch && len(pool) > 0 {
+					<-pool
+					free++
+				}
+
+				output, err := b.receiveMessages(qURL, free)
+				if err != nil {
+					b.Logger().ERROR.Printf("Queue consume error: %s", err)
+				}
+				received := 0
+				if err == nil {
+					for _, message := range output.Messages {
+						deliveries <- &awssqs.ReceiveMessageOutput{Messages: []*awssqs.Message{message}}
+						received++
+					}
+				}
+				//return the unused slots back to pool right away
+				for ; received < free; received++ {
+					pool <- struct{}{}
 				}
 			}
 
@@ -110,6 +167,14 @@ func (b *Broker) StartConsuming(consumerTag string, concurrency int, taskProcess
 	return b.GetRetry(), nil
 }
 
+// SupportsPrefetch returns true, consumers receive at most the prefetch
+// count of their processor ahead of the messages they are processing,
+// without one they receive a message only once a slot is free. Prefetched
+// messages count towards their visibility timeout.
+func (b *Broker) SupportsPrefetch() bool {
+	return true
+}
+
 // StopConsuming quits the loop
 func (b *Broker) StopConsuming() {
 	b.Broker.StopConsuming()
@@ -125,17 +190,17 @@ func (b *Broker) StopConsuming() {
 
 // Publish places a new message on the default queue
 func (b *Broker) Publish(ctx context.Context, signature *tasks.Signature) error {
-	msg, err := json.Marshal(signature)
+	msg, err := b.EncodeSignature(signature)
 	if err != nil {
-		return fmt.Errorf("JSON marshal error: %s", err)
+		return fmt.Errorf("Encode signature error: %s", err)
 	}
 
 	// Check that signature.RoutingKey is set, if not switch to DefaultQueue
 	b.AdjustRoutingKey(signature)
 
 	MsgInput := &awssqs.SendMessageInput{
-		MessageBody: aws.String(string(msg)),
-		QueueUrl:    aws.String(b.GetConfig().Broker + "/" + signature.RoutingKey),
+		MessageBody: aws.String(encodeBody(msg)),
+		QueueUrl:    aws.String(b.GetConfig().Broker + "/" + b.GetConfig().Namespaced(signature.RoutingKey)),
 	}
 
 	// if this is a fifo queue, there needs to be some additional parameters.
@@ -153,13 +218,14 @@ func (b *Broker) Publish(ctx context.Context, signature *tasks.Signature) error
 	}
 
 	// Check the ETA signature field, if it is set and it is in the future,
-	// and is not a fifo queue, set a delay in seconds for the task.
-	if signature.ETA != nil && !strings.HasSuffix(signature.RoutingKey, ".fifo") {
+	// and is not a fifo queue, set a delay in seconds for the task. Tasks
+	// delayed longer are published again by the worker receiving them.
+	if _, ok := b.MaxDeliveryDelay(signature); ok && signature.ETA != nil {
 		now := time.Now().UTC()
 		delay := signature.ETA.Sub(now)
 		if delay > 0 {
 			if delay > maxAWSSQSDelay {
-				return errors.New("Max AWS SQS delay exceeded")
+				delay = maxAWSSQSDelay
 			}
 			MsgInput.DelaySeconds = aws.Int64(int64(delay.Seconds()))
 		}
@@ -168,15 +234,137 @@ func (b *Broker) Publish(ctx context.Context, signature *tasks.Signature) error
 	result, err := b.service.SendMessageWithContext(ctx, MsgInput)
 
 	if err != nil {
-		log.ERROR.Printf("Error when sending a message: %v", err)
+		b.Logger().ERROR.Printf("Error when sending a message: %v", err)
 		return err
 
 	}
-	log.INFO.Printf("Sending a message successfully, the messageId is %v", *result.MessageId)
+	b.Logger().INFO.Printf("Sending a message successfully, the messageId is %v", *result.MessageId)
 	return nil
 
 }
 
+// DeadLetterQueue returns the dead-letter queue of the queue, the default
+// queue if it is empty, or ErrNoDeadLetterQueue if it has none
+func (b *Broker) DeadLetterQueue(queue string) (*DeadLetterQueue, error) {
+	attributes, err := b.service.GetQueueAttributes(&awssqs.GetQueueAttributesInput{
+		QueueUrl:       b.queueURL(queue),
+		AttributeNames: []*string{aws.String(awssqs.QueueAttributeNameRedrivePolicy)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Get queue attributes error: %s", err)
+	}
+	policyJSON, ok := attributes.Attributes[awssqs.QueueAttributeNameRedrivePolicy]
+	if !ok || policyJSON == nil || *policyJSON == "" {
+		return nil, ErrNoDeadLetterQueue
+	}
+
+	policy := new(redrivePolicy)
+	if err := json.Unmarshal([]byte(*policyJSON), policy); err != nil {
+		return nil, fmt.Errorf("JSON unmarshal error: %s", err)
+	}
+	maxReceiveCount, err := policy.MaxReceiveCount.Int64()
+	if err != nil {
+		return nil, fmt.Errorf("Redrive policy error: %s", err)
+	}
+
+	// arn:aws:sqs:region:account-id:queue-name
+	arn := strings.Split(policy.DeadLetterTargetArn, ":")
+	if len(arn) != 6 {
+		return nil, fmt.Errorf("Redrive policy error: invalid dead-letter queue ARN %s", policy.DeadLetterTargetArn)
+	}
+	queueURL, err := b.service.GetQueueUrl(&awssqs.GetQueueUrlInput{
+		QueueName:              aws.String(arn[5]),
+		QueueOwnerAWSAccountId: aws.String(arn[4]),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Get queue url error: %s", err)
+	}
+
+	depth, err := b.approximateDepth(queueURL.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeadLetterQueue{
+		ARN:             policy.DeadLetterTargetArn,
+		URL:             *queueURL.QueueUrl,
+		MaxReceiveCount: int(maxReceiveCount),
+		Depth:           depth,
+	}, nil
+}
+
+// DeadLetterDepth returns the approximate number of messages in the
+// dead-letter queue of the queue, 0 if it has none
+func (b *Broker) DeadLetterDepth(queue string) (int, error) {
+	deadLetterQueue, err := b.DeadLetterQueue(queue)
+	if err == ErrNoDeadLetterQueue {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return deadLetterQueue.Depth, nil
+}
+
+// MaxDeliveryDelay returns the longest delay SQS supports, messages of FIFO
+// queues can't be delayed one by one
+func (b *Broker) MaxDeliveryDelay(signature *tasks.Signature) (time.Duration, bool) {
+	if strings.HasSuffix(signature.RoutingKey, ".fifo") {
+		return 0, false
+	}
+	return maxAWSSQSDelay, true
+}
+
+// QueueDepth returns the approximate number of messages in the queue, the
+// default queue if it is empty
+func (b *Broker) QueueDepth(queue string) (int, error) {
+	return b.approximateDepth(b.queueURL(queue))
+}
+
+// approximateDepth returns the approximate number of messages in the queue
+// at the url
+func (b *Broker) approximateDepth(queueURL *string) (int, error) {
+	attributes, err := b.service.GetQueueAttributes(&awssqs.GetQueueAttributesInput{
+		QueueUrl:       queueURL,
+		AttributeNames: []*string{aws.String(awssqs.QueueAttributeNameApproximateNumberOfMessages)},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Get queue attributes error: %s", err)
+	}
+	depth := 0
+	if value, ok := attributes.Attributes[awssqs.QueueAttributeNameApproximateNumberOfMessages]; ok && value != nil {
+		if depth, err = strconv.Atoi(*value); err != nil {
+			return 0, fmt.Errorf("Queue depth error: %s", err)
+		}
+	}
+	return depth, nil
+}
+
+// Redrive starts moving the messages in the dead-letter queue of the queue
+// back to the queues they came from, at most maxPerSecond messages per
+// second unless it is 0. It returns the handle of the SQS message move
+// task. Workers count the redriven tasks as retried.
+func (b *Broker) Redrive(queue string, maxPerSecond int) (string, error) {
+	deadLetterQueue, err := b.DeadLetterQueue(queue)
+	if err != nil {
+		return "", err
+	}
+
+	input := &awssqs.StartMessageMoveTaskInput{
+		SourceArn: aws.String(deadLetterQueue.ARN),
+	}
+	if maxPerSecond > 0 {
+		input.MaxNumberOfMessagesPerSecond = aws.Int64(int64(maxPerSecond))
+	}
+	output, err := b.service.StartMessageMoveTask(input)
+	if err != nil {
+		return "", fmt.Errorf("Start message move task error: %s", err)
+	}
+
+	b.Logger().INFO.Printf("Redriving %d messages from dead-letter queue %s", deadLetterQueue.Depth, deadLetterQueue.ARN)
+	return aws.StringValue(output.TaskHandle), nil
+}
+
 // consume is a method which keeps consuming deliveries from a channel, until there is an error or a stop signal
 func (b *Broker) consume(deliveries <-chan *awssqs.ReceiveMessageOutput, concurrency int, taskProcessor iface.TaskProcessor, pool chan struct{}) error {
 
@@ -193,27 +381,51 @@ func (b *Broker) consume(deliveries <-chan *awssqs.ReceiveMessageOutput, concurr
 	}
 }
 
+// encodeBody base64 encodes protobuf messages, as SQS only accepts text
+func encodeBody(msg []byte) string {
+	if len(msg) > 0 && msg[0] == '{' {
+		return string(msg)
+	}
+	return base64.StdEncoding.EncodeToString(msg)
+}
+
+// decodeBody decodes a message body encoded with encodeBody
+func decodeBody(body string) []byte {
+	if strings.HasPrefix(body, "{") {
+		return []byte(body)
+	}
+	msg, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		// Leave it to the decoder to reject
+		return []byte(body)
+	}
+	return msg
+}
+
 // consumeOne is a method consumes a delivery. If a delivery was consumed successfully, it will be deleted from AWS SQS
 func (b *Broker) consumeOne(delivery *awssqs.ReceiveMessageOutput, taskProcessor iface.TaskProcessor) error {
 	if len(delivery.Messages) == 0 {
-		log.ERROR.Printf("received an empty message, the delivery was %v", delivery)
+		b.Logger().ERROR.Printf("received an empty message, the delivery was %v", delivery)
 		return errors.New("received empty message, the delivery is " + delivery.GoString())
 	}
 
-	sig := new(tasks.Signature)
-	decoder := json.NewDecoder(strings.NewReader(*delivery.Messages[0].Body))
-	decoder.UseNumber()
-	if err := decoder.Decode(sig); err != nil {
-		log.ERROR.Printf("unmarshal error. the delivery is %v", delivery)
+	body := decodeBody(*delivery.Messages[0].Body)
+	sig, err := b.DecodeSignature(body)
+	if err != nil {
+		b.Logger().ERROR.Printf("unmarshal error: %s. the delivery is %v", err, delivery)
+		b.DeadLetterMessage(taskProcessor, body, err)
 		// if the unmarshal fails, remove the delivery from the queue
 		if delErr := b.deleteOne(delivery); delErr != nil {
-			log.ERROR.Printf("error when deleting the delivery. delivery is %v, Error=%s", delivery, delErr)
+			b.Logger().ERROR.Printf("error when deleting the delivery. delivery is %v, Error=%s", delivery, delErr)
 		}
 		return err
 	}
 	if delivery.Messages[0].ReceiptHandle != nil {
 		sig.SQSReceiptHandle = *delivery.Messages[0].ReceiptHandle
 	}
+	if receiveCount, ok := delivery.Messages[0].Attributes[awssqs.MessageSystemAttributeNameApproximateReceiveCount]; ok && receiveCount != nil {
+		sig.ReceiveCount, _ = strconv.Atoi(*receiveCount)
+	}
 
 	// If the task is not registered return an error
 	// and leave the message in the queue
@@ -224,7 +436,7 @@ func (b *Broker) consumeOne(delivery *awssqs.ReceiveMessageOutput, taskProcessor
 		return fmt.Errorf("task %s is not registered", sig.Name)
 	}
 
-	err := taskProcessor.Process(sig)
+	err = taskProcessor.Process(sig)
 	if err != nil {
 		// stop task deletion in case we want to send messages to dlq in sqs
 		if err == errs.ErrStopTaskDeletion {
@@ -234,7 +446,7 @@ func (b *Broker) consumeOne(delivery *awssqs.ReceiveMessageOutput, taskProcessor
 	}
 	// Delete message after successfully consuming and processing the message
 	if err = b.deleteOne(delivery); err != nil {
-		log.ERROR.Printf("error when deleting the delivery. delivery is %v, Error=%s", delivery, err)
+		b.Logger().ERROR.Printf("error when deleting the delivery. delivery is %v, Error=%s", delivery, err)
 	}
 	return err
 }
@@ -258,13 +470,18 @@ func (b *Broker) defaultQueueURL() *string {
 	if b.queueUrl != nil {
 		return b.queueUrl
 	} else {
-		return aws.String(b.GetConfig().Broker + "/" + b.GetConfig().DefaultQueue)
+		return aws.String(b.GetConfig().Broker + "/" + b.GetConfig().Namespaced(b.GetConfig().DefaultQueue))
 	}
 
 }
 
 // receiveMessage is a method receives a message from specified queue url
 func (b *Broker) receiveMessage(qURL *string) (*awssqs.ReceiveMessageOutput, error) {
+	return b.receiveMessages(qURL, 1)
+}
+
+// receiveMessages receives at most count messages from the queue
+func (b *Broker) receiveMessages(qURL *string, count int) (*awssqs.ReceiveMessageOutput, error) {
 	var waitTimeSeconds int
 	var visibilityTimeout *int
 	if b.GetConfig().SQS != nil {
@@ -276,12 +493,13 @@ func (b *Broker) receiveMessage(qURL *string) (*awssqs.ReceiveMessageOutput, err
 	input := &awssqs.ReceiveMessageInput{
 		AttributeNames: []*string{
 			aws.String(awssqs.MessageSystemAttributeNameSentTimestamp),
+			aws.String(awssqs.MessageSystemAttributeNameApproximateReceiveCount),
 		},
 		MessageAttributeNames: []*string{
 			aws.String(awssqs.QueueAttributeNameAll),
 		},
 		QueueUrl:            qURL,
-		MaxNumberOfMessages: aws.Int64(1),
+		MaxNumberOfMessages: aws.Int64(int64(count)),
 		WaitTimeSeconds:     aws.Int64(int64(waitTimeSeconds)),
 	}
 	if visibilityTimeout != nil {
@@ -313,6 +531,11 @@ func (b *Broker) consumeDeliveries(deliveries <-chan *awssqs.ReceiveMessageOutpu
 		// Consume the task inside a goroutine so multiple tasks
 		// can be processed concurrently
 		go func() {
+			if b.running != nil {
+				// wait for a free slot, the task was prefetched
+				b.running <- struct{}{}
+				defer func() { <-b.running }()
+			}
 
 			if err := b.consumeOne(d, taskProcessor); err != nil {
 				errorsChan <- err
@@ -356,13 +579,16 @@ func (b *Broker) stopReceiving() {
 	b.stopReceivingChan <- 1
 }
 
+// queueURL is a method returns the url of the queue, the default queue if it is empty
+func (b *Broker) queueURL(queue string) *string {
+	if queue == "" {
+		queue = b.GetConfig().DefaultQueue
+	}
+	return aws.String(b.GetConfig().Broker + "/" + b.GetConfig().Namespaced(queue))
+}
+
 // getQueueURL is a method returns that returns queueURL first by checking if custom queue was set and usign it
 // otherwise using default queueName from config
 func (b *Broker) getQueueURL(taskProcessor iface.TaskProcessor) *string {
-	queueName := b.GetConfig().DefaultQueue
-	if taskProcessor.CustomQueue() != "" {
-		queueName = taskProcessor.CustomQueue()
-	}
-
-	return aws.String(b.GetConfig().Broker + "/" + queueName)
+	return b.queueURL(taskProcessor.CustomQueue())
 }
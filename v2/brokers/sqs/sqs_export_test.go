@@ -1,3 +1,6 @@
+//go:build !machinery_no_aws
+// +build !machinery_no_aws
+
 package sqs
 
 import (
@@ -43,6 +46,36 @@ func (f *FakeSQS) DeleteMessage(*awssqs.DeleteMessageInput) (*awssqs.DeleteMessa
 	return &awssqs.DeleteMessageOutput{}, nil
 }
 
+const (
+	DeadLetterQueueARN = "arn:aws:sqs:cn-foo-1:123456789012:test_queue_dlq"
+	DeadLetterQueueURL = "https://sqs.foo.amazonaws.com.cn/123456789012/test_queue_dlq"
+)
+
+// GetQueueAttributes gives test_queue a dead-letter queue holding 3 messages
+func (f *FakeSQS) GetQueueAttributes(input *awssqs.GetQueueAttributesInput) (*awssqs.GetQueueAttributesOutput, error) {
+	attributes := map[string]*string{}
+	switch *input.QueueUrl {
+	case "https://sqs.foo.amazonaws.com.cn/test_queue":
+		attributes[awssqs.QueueAttributeNameRedrivePolicy] = aws.String(`{"deadLetterTargetArn":"` + DeadLetterQueueARN + `","maxReceiveCount":"5"}`)
+	case DeadLetterQueueURL:
+		attributes[awssqs.QueueAttributeNameApproximateNumberOfMessages] = aws.String("3")
+	}
+	return &awssqs.GetQueueAttributesOutput{Attributes: attributes}, nil
+}
+
+func (f *FakeSQS) GetQueueUrl(input *awssqs.GetQueueUrlInput) (*awssqs.GetQueueUrlOutput, error) {
+	return &awssqs.GetQueueUrlOutput{
+		QueueUrl: aws.String("https://sqs.foo.amazonaws.com.cn/" + *input.QueueOwnerAWSAccountId + "/" + *input.QueueName),
+	}, nil
+}
+
+func (f *FakeSQS) StartMessageMoveTask(input *awssqs.StartMessageMoveTaskInput) (*awssqs.StartMessageMoveTaskOutput, error) {
+	if *input.SourceArn != DeadLetterQueueARN || input.DestinationArn != nil {
+		return nil, errors.New("unexpected message move task")
+	}
+	return &awssqs.StartMessageMoveTaskOutput{TaskHandle: aws.String("test-task-handle")}, nil
+}
+
 type ErrorSQS struct {
 	sqsiface.SQSAPI
 }
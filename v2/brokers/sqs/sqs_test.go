@@ -1,7 +1,11 @@
+//go:build !machinery_no_aws
+// +build !machinery_no_aws
+
 package sqs_test
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"sync"
 	"testing"
@@ -14,8 +18,12 @@ import (
 	"github.com/RichardKnop/machinery/v2/brokers/sqs"
 	"github.com/RichardKnop/machinery/v2/config"
 	"github.com/RichardKnop/machinery/v2/retry"
+	"github.com/RichardKnop/machinery/v2/tasks"
 
 	awssqs "github.com/aws/aws-sdk-go/service/sqs"
+
+	backend "github.com/RichardKnop/machinery/v2/backends/eager"
+	lock "github.com/RichardKnop/machinery/v2/locks/eager"
 )
 
 var (
@@ -28,6 +36,10 @@ func init() {
 	receiveMessageOutput = sqs.ReceiveMessageOutput
 }
 
+func newTestServer() *machinery.Server {
+	return machinery.NewServer(cnf, sqs.NewTestBroker(), backend.New(), lock.New())
+}
+
 func TestNewAWSSQSBroker(t *testing.T) {
 	t.Parallel()
 
@@ -87,10 +99,7 @@ func TestPrivateFunc_continueReceivingMessages(t *testing.T) {
 
 func TestPrivateFunc_consume(t *testing.T) {
 
-	server1, err := machinery.NewServer(cnf)
-	if err != nil {
-		t.Fatal(err)
-	}
+	server1 := newTestServer()
 	pool := make(chan struct{})
 	wk := server1.NewWorker("sms_worker", 0)
 	deliveries := make(chan *awssqs.ReceiveMessageOutput)
@@ -101,20 +110,17 @@ func TestPrivateFunc_consume(t *testing.T) {
 	broker := sqs.NewTestBroker()
 
 	// an infinite loop will be executed only when there is no error
-	err = broker.ConsumeForTest(deliveries, 0, wk, pool)
+	err := broker.ConsumeForTest(deliveries, 0, wk, pool)
 	assert.NotNil(t, err)
 }
 
 func TestPrivateFunc_consumeOne(t *testing.T) {
 
-	server1, err := machinery.NewServer(cnf)
-	if err != nil {
-		t.Fatal(err)
-	}
+	server1 := newTestServer()
 	wk := server1.NewWorker("sms_worker", 0)
 	broker := sqs.NewTestBroker()
 
-	err = broker.ConsumeOneForTest(receiveMessageOutput, wk)
+	err := broker.ConsumeOneForTest(receiveMessageOutput, wk)
 	assert.NotNil(t, err)
 
 	outputCopy := *receiveMessageOutput
@@ -143,10 +149,7 @@ func TestPrivateFunc_initializePool(t *testing.T) {
 
 func TestPrivateFunc_startConsuming(t *testing.T) {
 
-	server1, err := machinery.NewServer(cnf)
-	if err != nil {
-		t.Fatal(err)
-	}
+	server1 := newTestServer()
 
 	wk := server1.NewWorker("sms_worker", 0)
 	broker := sqs.NewTestBroker()
@@ -197,10 +200,7 @@ func TestPrivateFunc_consumeDeliveries(t *testing.T) {
 	pool := make(chan struct{}, concurrency)
 	errorsChan := make(chan error)
 	deliveries := make(chan *awssqs.ReceiveMessageOutput)
-	server1, err := machinery.NewServer(cnf)
-	if err != nil {
-		t.Fatal(err)
-	}
+	server1 := newTestServer()
 
 	wk := server1.NewWorker("sms_worker", 0)
 	broker := sqs.NewTestBroker()
@@ -265,10 +265,7 @@ func TestPrivateFunc_deleteOne(t *testing.T) {
 
 func Test_CustomQueueName(t *testing.T) {
 
-	server1, err := machinery.NewServer(cnf)
-	if err != nil {
-		t.Fatal(err)
-	}
+	server1 := newTestServer()
 
 	broker := sqs.NewTestBroker()
 
@@ -294,11 +291,8 @@ func TestPrivateFunc_consumeWithConcurrency(t *testing.T) {
 	output := make(chan string) // The output channel
 
 	cnf.ResultBackend = "eager"
-	server1, err := machinery.NewServer(cnf)
-	if err != nil {
-		t.Fatal(err)
-	}
-	err = server1.RegisterTask("test-task", func(ctx context.Context) error {
+	server1 := newTestServer()
+	err := server1.RegisterTask("test-task", func(ctx context.Context) error {
 		output <- testResp
 
 		return nil
@@ -338,3 +332,105 @@ func TestPrivateFunc_consumeWithConcurrency(t *testing.T) {
 		t.Fatal("task not processed in 10 seconds")
 	}
 }
+
+func TestDeadLetterQueue(t *testing.T) {
+	t.Parallel()
+
+	broker := sqs.NewTestBroker()
+
+	deadLetterQueue, err := broker.DeadLetterQueue("")
+	if assert.NoError(t, err) {
+		assert.Equal(t, &sqs.DeadLetterQueue{
+			ARN:             sqs.DeadLetterQueueARN,
+			URL:             sqs.DeadLetterQueueURL,
+			MaxReceiveCount: 5,
+			Depth:           3,
+		}, deadLetterQueue)
+	}
+	depth, err := broker.DeadLetterDepth("")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, depth)
+
+	_, err = broker.DeadLetterQueue("my-custom-queue")
+	assert.Equal(t, sqs.ErrNoDeadLetterQueue, err)
+	depth, err = broker.DeadLetterDepth("my-custom-queue")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, depth)
+}
+
+func TestRedrive(t *testing.T) {
+	t.Parallel()
+
+	broker := sqs.NewTestBroker()
+
+	taskHandle, err := broker.Redrive("", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "test-task-handle", taskHandle)
+
+	_, err = broker.Redrive("my-custom-queue", 10)
+	assert.Equal(t, sqs.ErrNoDeadLetterQueue, err)
+}
+
+// processorFunc processes tasks of the default queue with a function
+type processorFunc func(signature *tasks.Signature) error
+
+func (f processorFunc) Process(signature *tasks.Signature) error { return f(signature) }
+func (f processorFunc) CustomQueue() string                      { return "" }
+func (f processorFunc) PreConsumeHandler() bool                  { return true }
+
+func TestConsumeOneReceiveCount(t *testing.T) {
+	t.Parallel()
+
+	broker := sqs.NewTestBroker()
+	broker.SetRegisteredTaskNames([]string{"test-task"})
+
+	var receiveCount int
+	processor := processorFunc(func(signature *tasks.Signature) error {
+		receiveCount = signature.ReceiveCount
+		return nil
+	})
+	delivery := &awssqs.ReceiveMessageOutput{
+		Messages: []*awssqs.Message{
+			{
+				Attributes: map[string]*string{
+					awssqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String("2"),
+				},
+				Body:          aws.String(`{"UUID": "uuid-dummy-task", "Name": "test-task"}`),
+				ReceiptHandle: aws.String("test-receipt-handle"),
+			},
+		},
+	}
+
+	assert.NoError(t, broker.ConsumeOneForTest(delivery, processor))
+	assert.Equal(t, 2, receiveCount)
+}
+
+func TestConsumeOneProtobuf(t *testing.T) {
+	t.Parallel()
+
+	broker := sqs.NewTestBroker()
+	broker.SetRegisteredTaskNames([]string{"test-task"})
+
+	encoded, err := tasks.EncodeSignature(&tasks.Signature{UUID: "uuid-dummy-task", Name: "test-task"}, tasks.WireFormatProtobuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var name string
+	processor := processorFunc(func(signature *tasks.Signature) error {
+		name = signature.Name
+		return nil
+	})
+	delivery := &awssqs.ReceiveMessageOutput{
+		Messages: []*awssqs.Message{
+			{
+				// Protobuf messages travel base64 encoded
+				Body:          aws.String(base64.StdEncoding.EncodeToString(encoded)),
+				ReceiptHandle: aws.String("test-receipt-handle"),
+			},
+		},
+	}
+
+	assert.NoError(t, broker.ConsumeOneForTest(delivery, processor))
+	assert.Equal(t, "test-task", name)
+}
@@ -5,9 +5,29 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/RichardKnop/machinery/v2/config"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// AMQPAlternateExchange returns the name of the alternate exchange in the
+// namespace, empty if there is none
+func AMQPAlternateExchange(cnf *config.Config) string {
+	if cnf.AMQP == nil || cnf.AMQP.AlternateExchange == "" {
+		return ""
+	}
+	return cnf.Namespaced(cnf.AMQP.AlternateExchange)
+}
+
+// AMQPExchangeDeclareArgs returns the arguments the exchange is declared
+// with, the broker and the result backend must declare it alike
+func AMQPExchangeDeclareArgs(cnf *config.Config) amqp.Table {
+	alternateExchange := AMQPAlternateExchange(cnf)
+	if alternateExchange == "" {
+		return nil
+	}
+	return amqp.Table{"alternate-exchange": alternateExchange}
+}
+
 // AMQPConnector ...
 type AMQPConnector struct{}
 
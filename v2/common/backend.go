@@ -2,11 +2,15 @@ package common
 
 import (
 	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/log"
+	"github.com/RichardKnop/machinery/v2/tasks"
 )
 
 // Backend represents a base backend structure
 type Backend struct {
-	cnf *config.Config
+	cnf    *config.Config
+	codec  tasks.Codec
+	logger *log.Logger
 }
 
 // NewBackend creates new Backend instance
@@ -19,6 +23,97 @@ func (b *Backend) GetConfig() *config.Config {
 	return b.cnf
 }
 
+// SetCodec replaces the codec of the configured wire format, set it before
+// storing or reading task states
+func (b *Backend) SetCodec(codec tasks.Codec) {
+	b.codec = codec
+}
+
+// Codec returns the codec set with SetCodec, the one of the configured wire
+// format otherwise
+func (b *Backend) Codec() tasks.Codec {
+	if b.codec != nil {
+		return b.codec
+	}
+	if b.cnf == nil {
+		return tasks.NewWireFormatCodec(tasks.WireFormatJSON)
+	}
+	return tasks.NewWireFormatCodec(b.cnf.WireFormat)
+}
+
+// SetLogger makes the backend log to the logger instead of the package level
+// loggers of the log package, nil logs to them again
+func (b *Backend) SetLogger(logger *log.Logger) {
+	b.logger = logger
+}
+
+// Logger returns the logger set with SetLogger, the package level loggers
+// otherwise
+func (b *Backend) Logger() *log.Logger {
+	if b.logger != nil {
+		return b.logger
+	}
+	return log.Default()
+}
+
+// EncodeTaskState encodes a task state to store with the codec
+func (b *Backend) EncodeTaskState(taskState *tasks.TaskState) ([]byte, error) {
+	return b.Codec().EncodeTaskState(taskState)
+}
+
+// ContentType returns the MIME type of task states encoded with
+// EncodeTaskState
+func (b *Backend) ContentType() string {
+	return b.Codec().ContentType()
+}
+
+// DecodeTaskState decodes a stored task state with the codec
+func (b *Backend) DecodeTaskState(data []byte) (*tasks.TaskState, error) {
+	return b.Codec().DecodeTaskState(data)
+}
+
+// ResultsExpireIn returns for how many seconds states and results of a task
+// should be kept. A positive ResultsExpireIn of the signature overrides the
+// configured value, which defaults to 1 hour, and states of tasks in a group
+// are kept at least GroupMetaExpireIn seconds. Pass nil signature for data
+// not belonging to a single task.
+func (b *Backend) ResultsExpireIn(signature *tasks.Signature) int {
+	if signature != nil && signature.ResultsExpireIn > 0 {
+		return signature.ResultsExpireIn
+	}
+
+	expiresIn := b.cnf.ResultsExpireIn
+	if expiresIn == 0 {
+		// expire results after 1 hour by default
+		expiresIn = config.DefaultResultsExpireIn
+	}
+
+	// Triggering the chord of a group needs the states of its tasks, so they
+	// are kept as long as the group meta data
+	if signature != nil && signature.GroupUUID != "" && b.cnf.GroupMetaExpireIn > expiresIn {
+		expiresIn = b.cnf.GroupMetaExpireIn
+	}
+	return expiresIn
+}
+
+// GroupMetaExpireIn returns for how many seconds meta data of groups should
+// be kept, the configured GroupMetaExpireIn or ResultsExpireIn if it is zero
+func (b *Backend) GroupMetaExpireIn() int {
+	if b.cnf.GroupMetaExpireIn > 0 {
+		return b.cnf.GroupMetaExpireIn
+	}
+	return b.ResultsExpireIn(nil)
+}
+
+// DeadLetterQueue returns the namespaced name dead letters are kept under,
+// the configured DeadLetterQueue or DefaultDeadLetterQueue if it is empty
+func (b *Backend) DeadLetterQueue() string {
+	if b.cnf.DeadLetterQueue == "" {
+		return b.cnf.Namespaced(config.DefaultDeadLetterQueue)
+	}
+	return b.cnf.Namespaced(b.cnf.DeadLetterQueue)
+}
+
 // IsAMQP ...
 func (b *Backend) IsAMQP() bool {
 	return false
@@ -0,0 +1,69 @@
+package common_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/common"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestResultsExpireIn(t *testing.T) {
+	t.Parallel()
+
+	backend := common.NewBackend(new(config.Config))
+	assert.Equal(t, config.DefaultResultsExpireIn, backend.ResultsExpireIn(nil))
+	assert.Equal(t, config.DefaultResultsExpireIn, backend.ResultsExpireIn(new(tasks.Signature)))
+	assert.Equal(t, 60, backend.ResultsExpireIn(&tasks.Signature{ResultsExpireIn: 60}))
+
+	backend = common.NewBackend(&config.Config{ResultsExpireIn: 86400})
+	assert.Equal(t, 86400, backend.ResultsExpireIn(nil))
+	assert.Equal(t, 60, backend.ResultsExpireIn(&tasks.Signature{ResultsExpireIn: 60}))
+}
+
+func TestGroupMetaExpireIn(t *testing.T) {
+	t.Parallel()
+
+	backend := common.NewBackend(&config.Config{ResultsExpireIn: 60})
+	assert.Equal(t, 60, backend.GroupMetaExpireIn())
+	assert.Equal(t, 60, backend.ResultsExpireIn(&tasks.Signature{GroupUUID: "group_1"}))
+
+	backend = common.NewBackend(&config.Config{ResultsExpireIn: 60, GroupMetaExpireIn: 86400})
+	assert.Equal(t, 86400, backend.GroupMetaExpireIn())
+	assert.Equal(t, 60, backend.ResultsExpireIn(nil))
+	assert.Equal(t, 60, backend.ResultsExpireIn(new(tasks.Signature)))
+	// States of tasks in a group are kept as long as the group meta data
+	assert.Equal(t, 86400, backend.ResultsExpireIn(&tasks.Signature{GroupUUID: "group_1"}))
+	assert.Equal(t, 30, backend.ResultsExpireIn(&tasks.Signature{GroupUUID: "group_1", ResultsExpireIn: 30}))
+
+	// A shorter GroupMetaExpireIn doesn't shorten states of tasks in a group
+	backend = common.NewBackend(&config.Config{ResultsExpireIn: 60, GroupMetaExpireIn: 30})
+	assert.Equal(t, 30, backend.GroupMetaExpireIn())
+	assert.Equal(t, 60, backend.ResultsExpireIn(&tasks.Signature{GroupUUID: "group_1"}))
+}
+
+func TestEncodeTaskState(t *testing.T) {
+	t.Parallel()
+
+	taskState := &tasks.TaskState{TaskUUID: "task_1", TaskName: "foo", State: tasks.StateSuccess}
+
+	// States stored in either format are decoded after switching formats
+	jsonBackend := common.NewBackend(new(config.Config))
+	protobufBackend := common.NewBackend(&config.Config{WireFormat: tasks.WireFormatProtobuf})
+	for _, backend := range []common.Backend{jsonBackend, protobufBackend} {
+		encoded, err := backend.EncodeTaskState(taskState)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		for _, decodingBackend := range []common.Backend{jsonBackend, protobufBackend} {
+			decoded, err := decodingBackend.DecodeTaskState(encoded)
+			if assert.NoError(t, err) {
+				assert.Equal(t, taskState, decoded)
+			}
+		}
+	}
+	assert.Equal(t, "application/json", jsonBackend.ContentType())
+	assert.Equal(t, "application/x-protobuf", protobufBackend.ContentType())
+}
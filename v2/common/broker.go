@@ -24,6 +24,8 @@ type Broker struct {
 	retryFunc           func(chan int)
 	retryStopChan       chan int
 	stopChan            chan int
+	codec               tasks.Codec
+	logger              *log.Logger
 }
 
 // NewBroker creates new Broker instance
@@ -61,6 +63,64 @@ func (b *Broker) GetStopChan() chan int {
 	return b.stopChan
 }
 
+// SetCodec replaces the codec of the configured wire format, set it before
+// publishing or consuming tasks
+func (b *Broker) SetCodec(codec tasks.Codec) {
+	b.codec = codec
+}
+
+// SetLogger makes the broker log to the logger instead of the package level
+// loggers of the log package, nil logs to them again
+func (b *Broker) SetLogger(logger *log.Logger) {
+	b.logger = logger
+}
+
+// Logger returns the logger set with SetLogger, the package level loggers
+// otherwise
+func (b *Broker) Logger() *log.Logger {
+	if b.logger != nil {
+		return b.logger
+	}
+	return log.Default()
+}
+
+// Codec returns the codec set with SetCodec, the one of the configured wire
+// format otherwise
+func (b *Broker) Codec() tasks.Codec {
+	if b.codec != nil {
+		return b.codec
+	}
+	if b.cnf == nil {
+		return tasks.NewWireFormatCodec(tasks.WireFormatJSON)
+	}
+	return tasks.NewWireFormatCodec(b.cnf.WireFormat)
+}
+
+// DecodeSignature decodes a received task, strictly if the config says so
+func (b *Broker) DecodeSignature(data []byte) (*tasks.Signature, error) {
+	strict := b.cnf != nil && b.cnf.StrictDecoding
+	signature, err := b.Codec().DecodeSignature(data, strict)
+	if err != nil {
+		return nil, err
+	}
+	if strict {
+		if err := signature.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return signature, nil
+}
+
+// EncodeSignature encodes a task to publish with the codec
+func (b *Broker) EncodeSignature(signature *tasks.Signature) ([]byte, error) {
+	return b.Codec().EncodeSignature(signature)
+}
+
+// ContentType returns the MIME type of tasks encoded with EncodeSignature
+func (b *Broker) ContentType() string {
+	return b.Codec().ContentType()
+}
+
 // Publish places a new message on the default queue
 func (b *Broker) Publish(signature *tasks.Signature) error {
 	return errors.New("Not implemented")
@@ -74,7 +134,13 @@ func (b *Broker) SetRegisteredTaskNames(names []string) {
 }
 
 // IsTaskRegistered returns true if the task is registered with this broker
+// or is a task machinery sends itself
 func (b *Broker) IsTaskRegistered(name string) bool {
+	switch name {
+	case tasks.GroupTimeoutTaskName, tasks.MapTaskName, tasks.ForkTaskName, tasks.StateEventTaskName:
+		return true
+	}
+
 	b.registeredTaskNames.RLock()
 	defer b.registeredTaskNames.RUnlock()
 	for _, registeredTaskName := range b.registeredTaskNames.items {
@@ -110,12 +176,12 @@ func (b *Broker) StopConsuming() {
 	// Stop the retry closure earlier
 	select {
 	case b.retryStopChan <- 1:
-		log.WARNING.Print("Stopping retry closure.")
+		b.Logger().WARNING.Print("Stopping retry closure.")
 	default:
 	}
 	// Notifying the stop channel stops consuming of messages
 	close(b.stopChan)
-	log.WARNING.Print("Stop channel")
+	b.Logger().WARNING.Print("Stop channel")
 }
 
 // GetRegisteredTaskNames returns registered tasks names
@@ -127,6 +193,34 @@ func (b *Broker) GetRegisteredTaskNames() []string {
 }
 
 // AdjustRoutingKey makes sure the routing key is correct.
+// PrefetchCount returns the prefetch count of the task processor, false if
+// it has none, see iface.PrefetchProcessor
+func (b *Broker) PrefetchCount(taskProcessor iface.TaskProcessor) (int, bool) {
+	prefetchProcessor, ok := taskProcessor.(iface.PrefetchProcessor)
+	if !ok {
+		return 0, false
+	}
+	return prefetchProcessor.PrefetchCount()
+}
+
+// DeadLetterMessage hands a message consumed for the task processor which
+// couldn't be decoded into a task back to the processor to keep it as a dead
+// letter, if it is able to
+func (b *Broker) DeadLetterMessage(taskProcessor iface.TaskProcessor, message []byte, reason error) {
+	deadLetterProcessor, ok := taskProcessor.(iface.DeadLetterProcessor)
+	if !ok {
+		return
+	}
+
+	queue := taskProcessor.CustomQueue()
+	if queue == "" {
+		queue = b.cnf.DefaultQueue
+	}
+	if err := deadLetterProcessor.DeadLetterMessage(queue, message, reason); err != nil {
+		b.Logger().ERROR.Printf("Failed to dead-letter a message which couldn't be decoded: %s", err)
+	}
+}
+
 // If the routing key is an empty string:
 // a) set it to binding key for direct exchange type
 // b) set it to default queue name
@@ -1,6 +1,8 @@
 package common_test
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 
 	"github.com/RichardKnop/machinery/v2"
@@ -20,6 +22,91 @@ func TestIsTaskRegistered(t *testing.T) {
 	assert.False(t, broker.IsTaskRegistered("bogus"))
 }
 
+func TestDecodeSignature(t *testing.T) {
+	t.Parallel()
+
+	message := []byte(`{"UUID":"task_1","Name":"foo","Unknown":true}`)
+
+	broker := common.NewBroker(new(config.Config))
+	signature, err := broker.DecodeSignature(message)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "foo", signature.Name)
+	}
+
+	broker = common.NewBroker(&config.Config{StrictDecoding: true})
+	_, err = broker.DecodeSignature(message)
+	assert.Error(t, err)
+}
+
+func TestEncodeSignature(t *testing.T) {
+	t.Parallel()
+
+	signature := &tasks.Signature{UUID: "task_1", Name: "foo"}
+
+	broker := common.NewBroker(new(config.Config))
+	encoded, err := broker.EncodeSignature(signature)
+	if assert.NoError(t, err) {
+		assert.Equal(t, byte('{'), encoded[0])
+	}
+	assert.Equal(t, "application/json", broker.ContentType())
+
+	broker = common.NewBroker(&config.Config{WireFormat: tasks.WireFormatProtobuf, StrictDecoding: true})
+	encoded, err = broker.EncodeSignature(signature)
+	if assert.NoError(t, err) {
+		decoded, err := broker.DecodeSignature(encoded)
+		if assert.NoError(t, err) {
+			assert.Equal(t, signature, decoded)
+		}
+	}
+	assert.Equal(t, "application/x-protobuf", broker.ContentType())
+}
+
+// upperCodec stores JSON upper-cased, as a stand-in for a custom format
+type upperCodec struct {
+	tasks.Codec
+}
+
+func (upperCodec) ContentType() string {
+	return "application/x-upper"
+}
+
+func (c upperCodec) EncodeSignature(signature *tasks.Signature) ([]byte, error) {
+	encoded, err := c.Codec.EncodeSignature(signature)
+	return bytes.ToUpper(encoded), err
+}
+
+func (c upperCodec) DecodeSignature(data []byte, strict bool) (*tasks.Signature, error) {
+	signature, err := c.Codec.DecodeSignature(data, strict)
+	if err == nil {
+		signature.Name = strings.ToLower(signature.Name)
+	}
+	return signature, err
+}
+
+func TestBrokerCodec(t *testing.T) {
+	t.Parallel()
+
+	broker := common.NewBroker(&config.Config{StrictDecoding: true})
+	broker.SetCodec(upperCodec{Codec: tasks.NewWireFormatCodec(tasks.WireFormatJSON)})
+	assert.Equal(t, "application/x-upper", broker.ContentType())
+
+	encoded, err := broker.EncodeSignature(&tasks.Signature{UUID: "task_1", Name: "foo"})
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(encoded), `"NAME":"FOO"`)
+		decoded, err := broker.DecodeSignature([]byte(`{"UUID":"task_1","Name":"FOO"}`))
+		if assert.NoError(t, err) {
+			assert.Equal(t, "foo", decoded.Name)
+		}
+	}
+
+	// Signatures decoded by custom codecs are validated in strict mode
+	_, err = broker.DecodeSignature([]byte(`{"UUID":"task 1","Name":"FOO"}`))
+	assert.Error(t, err)
+
+	broker.SetCodec(nil)
+	assert.Equal(t, "application/json", broker.ContentType())
+}
+
 func TestAdjustRoutingKey(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,21 @@
+//go:build !machinery_no_aws
+// +build !machinery_no_aws
+
+package config
+
+import (
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Clients of AWS services, builds with the machinery_no_aws tag leave out
+// the AWS SDK along with the brokers and backends using it
+type (
+	// DynamoDBClient is the client of the DynamoDB backend and lock
+	DynamoDBClient = dynamodb.DynamoDB
+	// S3Client is the client of the S3 backend
+	S3Client = s3.S3
+	// SQSClient is the client of the SQS broker
+	SQSClient = sqs.SQS
+)
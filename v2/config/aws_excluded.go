@@ -0,0 +1,15 @@
+//go:build machinery_no_aws
+// +build machinery_no_aws
+
+package config
+
+// Placeholders of the clients of AWS services, which are left out of builds
+// with the machinery_no_aws tag
+type (
+	// DynamoDBClient is not available, the build excludes AWS
+	DynamoDBClient struct{}
+	// S3Client is not available, the build excludes AWS
+	S3Client struct{}
+	// SQSClient is not available, the build excludes AWS
+	SQSClient struct{}
+)
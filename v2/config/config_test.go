@@ -0,0 +1,19 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaced(t *testing.T) {
+	t.Parallel()
+
+	cnf := new(config.Config)
+	assert.Equal(t, "machinery_tasks", cnf.Namespaced("machinery_tasks"))
+
+	cnf.Namespace = "staging"
+	assert.Equal(t, "staging_machinery_tasks", cnf.Namespaced("machinery_tasks"))
+	assert.Equal(t, "", cnf.Namespaced(""))
+}
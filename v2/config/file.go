@@ -62,7 +62,7 @@ func ReadFromFile(cnfPath string) ([]byte, error) {
 
 func fromFile(cnfPath string) (*Config, error) {
 	loadedCnf, cnf := new(Config), new(Config)
-	*cnf = *defaultCnf
+	*cnf = *defaultConfig()
 
 	data, err := ReadFromFile(cnfPath)
 	if err != nil {
@@ -12,11 +12,13 @@ broker: broker
 default_queue: default_queue
 result_backend: result_backend
 results_expire_in: 123456
+group_meta_expire_in: 654321
 amqp:
   binding_key: binding_key
   exchange: exchange
   exchange_type: exchange_type
   prefetch_count: 123
+  alternate_exchange: alternate_exchange
   queue_declare_args:
     x-max-priority: 10
   queue_binding_args:
@@ -66,6 +68,7 @@ func TestNewFromYaml(t *testing.T) {
 	assert.Equal(t, "default_queue", cnf.DefaultQueue)
 	assert.Equal(t, "result_backend", cnf.ResultBackend)
 	assert.Equal(t, 123456, cnf.ResultsExpireIn)
+	assert.Equal(t, 654321, cnf.GroupMetaExpireIn)
 
 	assert.Equal(t, "exchange", cnf.AMQP.Exchange)
 	assert.Equal(t, "exchange_type", cnf.AMQP.ExchangeType)
@@ -74,6 +77,7 @@ func TestNewFromYaml(t *testing.T) {
 	assert.Equal(t, "any", cnf.AMQP.QueueBindingArgs["x-match"])
 	assert.Equal(t, "png", cnf.AMQP.QueueBindingArgs["image-type"])
 	assert.Equal(t, 123, cnf.AMQP.PrefetchCount)
+	assert.Equal(t, "alternate_exchange", cnf.AMQP.AlternateExchange)
 
 	assert.Equal(t, 123, cnf.SQS.WaitTimeSeconds)
 	assert.Equal(t, 456, *cnf.SQS.VisibilityTimeout)
@@ -95,3 +99,22 @@ func TestNewFromYaml(t *testing.T) {
 	assert.Equal(t, "task_states_table", cnf.DynamoDB.TaskStatesTable)
 	assert.Equal(t, "group_metas_table", cnf.DynamoDB.GroupMetasTable)
 }
+
+func TestNewFromYamlReturnsIndependentConfigs(t *testing.T) {
+	t.Parallel()
+
+	first, err := config.NewFromYaml("testconfig.yml", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := config.NewFromYaml("testconfig.yml", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NotSame(t, first.AMQP, second.AMQP)
+	assert.NotSame(t, first.Redis, second.Redis)
+
+	first.Redis.MaxIdle = 1
+	assert.Equal(t, 12, second.Redis.MaxIdle)
+}
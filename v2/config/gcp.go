@@ -0,0 +1,10 @@
+//go:build !machinery_no_gcp
+// +build !machinery_no_gcp
+
+package config
+
+import "cloud.google.com/go/pubsub"
+
+// GCPPubSubClient is the client of the GCP Pub/Sub broker, builds with the
+// machinery_no_gcp tag leave out the GCP SDK along with the broker
+type GCPPubSubClient = pubsub.Client
@@ -0,0 +1,7 @@
+//go:build machinery_no_gcp
+// +build machinery_no_gcp
+
+package config
+
+// GCPPubSubClient is not available, the build excludes GCP
+type GCPPubSubClient struct{}
@@ -0,0 +1,11 @@
+//go:build !machinery_no_mongodb
+// +build !machinery_no_mongodb
+
+package config
+
+import "go.mongodb.org/mongo-driver/mongo"
+
+// MongoDBClient is the client of the MongoDB backend, builds with the
+// machinery_no_mongodb tag leave out the MongoDB driver along with the
+// backend
+type MongoDBClient = mongo.Client
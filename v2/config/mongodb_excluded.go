@@ -0,0 +1,7 @@
+//go:build machinery_no_mongodb
+// +build machinery_no_mongodb
+
+package config
+
+// MongoDBClient is not available, the build excludes MongoDB
+type MongoDBClient struct{}
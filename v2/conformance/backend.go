@@ -0,0 +1,228 @@
+// Package conformance contains test suites every broker and result backend
+// implementation is expected to pass, so third-party implementations can
+// verify their behaviour against the same specification as the built-in ones.
+//
+// The suites are plain functions taking a *testing.T and can be called from
+// any test. Files built with the integration tag add a Harness starting the
+// services the built-in implementations depend on in Docker containers.
+package conformance
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/RichardKnop/machinery/v2/backends/iface"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+// TestBackend runs the result backend conformance suite against the backend
+// returned by newBackend, which is called once for every subtest. AMQP like
+// backends consuming a state once read are not supported.
+func TestBackend(t *testing.T, newBackend func(t *testing.T) iface.Backend) {
+	t.Run("StateTransitions", func(t *testing.T) {
+		testStateTransitions(t, newBackend(t))
+	})
+	t.Run("Failure", func(t *testing.T) {
+		testFailure(t, newBackend(t))
+	})
+	t.Run("Group", func(t *testing.T) {
+		testGroup(t, newBackend(t))
+	})
+	t.Run("Purge", func(t *testing.T) {
+		testPurge(t, newBackend(t))
+	})
+}
+
+func testStateTransitions(t *testing.T, backend iface.Backend) {
+	signature := newSignature("")
+
+	transitions := []struct {
+		state string
+		set   func(*tasks.Signature) error
+	}{
+		{tasks.StatePending, backend.SetStatePending},
+		{tasks.StateReceived, backend.SetStateReceived},
+		{tasks.StateStarted, backend.SetStateStarted},
+		{tasks.StateRetry, backend.SetStateRetry},
+		{tasks.StateStarted, backend.SetStateStarted},
+	}
+	for _, transition := range transitions {
+		if err := transition.set(signature); err != nil {
+			t.Fatalf("Set state %s error: %s", transition.state, err)
+		}
+		assertState(t, backend, signature.UUID, transition.state)
+	}
+
+	results := []*tasks.TaskResult{
+		{Type: "int64", Value: 2},
+		{Type: "string", Value: "foo"},
+	}
+	if err := backend.SetStateSuccess(signature, results); err != nil {
+		t.Fatalf("Set state %s error: %s", tasks.StateSuccess, err)
+	}
+
+	taskState := assertState(t, backend, signature.UUID, tasks.StateSuccess)
+	if taskState == nil {
+		return
+	}
+	if !taskState.IsCompleted() || !taskState.IsSuccess() {
+		t.Errorf("State %s should be completed and successful", taskState.State)
+	}
+	if len(taskState.Results) != len(results) {
+		t.Fatalf("Got %d results, want %d", len(taskState.Results), len(results))
+	}
+	for i, result := range results {
+		// Backends are free to decode numbers as json.Number or float64
+		got := fmt.Sprint(taskState.Results[i].Value)
+		want := fmt.Sprint(result.Value)
+		if taskState.Results[i].Type != result.Type || got != want {
+			t.Errorf("Result %d = %s(%s), want %s(%s)", i, taskState.Results[i].Type, got, result.Type, want)
+		}
+	}
+}
+
+func testFailure(t *testing.T, backend iface.Backend) {
+	signature := newSignature("")
+
+	if err := backend.SetStatePending(signature); err != nil {
+		t.Fatalf("Set state %s error: %s", tasks.StatePending, err)
+	}
+	if err := backend.SetStateFailure(signature, "boom"); err != nil {
+		t.Fatalf("Set state %s error: %s", tasks.StateFailure, err)
+	}
+
+	taskState := assertState(t, backend, signature.UUID, tasks.StateFailure)
+	if taskState == nil {
+		return
+	}
+	if !taskState.IsCompleted() || !taskState.IsFailure() {
+		t.Errorf("State %s should be completed and failed", taskState.State)
+	}
+	if taskState.Error != "boom" {
+		t.Errorf("Error = %q, want %q", taskState.Error, "boom")
+	}
+}
+
+func testGroup(t *testing.T, backend iface.Backend) {
+	groupUUID := "group_" + uuid.New().String()
+	signatures := []*tasks.Signature{newSignature(groupUUID), newSignature(groupUUID), newSignature(groupUUID)}
+	taskUUIDs := make([]string, len(signatures))
+	for i, signature := range signatures {
+		taskUUIDs[i] = signature.UUID
+		signature.GroupTaskCount = len(signatures)
+	}
+
+	if err := backend.InitGroup(groupUUID, taskUUIDs); err != nil {
+		t.Fatalf("Init group error: %s", err)
+	}
+
+	for i, signature := range signatures {
+		if err := backend.SetStatePending(signature); err != nil {
+			t.Fatalf("Set state %s error: %s", tasks.StatePending, err)
+		}
+		if i == 0 {
+			if err := backend.SetStateFailure(signature, "boom"); err != nil {
+				t.Fatalf("Set state %s error: %s", tasks.StateFailure, err)
+			}
+		}
+	}
+
+	completed, err := backend.GroupCompleted(groupUUID, len(signatures))
+	if err != nil {
+		t.Fatalf("Group completed error: %s", err)
+	}
+	if completed {
+		t.Error("Group with pending tasks should not be completed")
+	}
+
+	for _, signature := range signatures[1:] {
+		if err := backend.SetStateSuccess(signature, []*tasks.TaskResult{{Type: "string", Value: signature.UUID}}); err != nil {
+			t.Fatalf("Set state %s error: %s", tasks.StateSuccess, err)
+		}
+	}
+
+	completed, err = backend.GroupCompleted(groupUUID, len(signatures))
+	if err != nil {
+		t.Fatalf("Group completed error: %s", err)
+	}
+	if !completed {
+		t.Error("Group with only finished tasks should be completed")
+	}
+
+	taskStates, err := backend.GroupTaskStates(groupUUID, len(signatures))
+	if err != nil {
+		t.Fatalf("Group task states error: %s", err)
+	}
+	if len(taskStates) != len(signatures) {
+		t.Fatalf("Got %d task states, want %d", len(taskStates), len(signatures))
+	}
+	for i, taskState := range taskStates {
+		if taskState.TaskUUID != taskUUIDs[i] {
+			t.Errorf("Task state %d is of task %s, want %s in the order of the group", i, taskState.TaskUUID, taskUUIDs[i])
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		shouldTrigger, err := backend.TriggerChord(groupUUID)
+		if err != nil {
+			t.Fatalf("Trigger chord error: %s", err)
+		}
+		if shouldTrigger != (i == 0) {
+			t.Errorf("Trigger chord call %d returned %t, the chord must be triggered exactly once", i+1, shouldTrigger)
+		}
+	}
+}
+
+func testPurge(t *testing.T, backend iface.Backend) {
+	signature := newSignature("")
+	if err := backend.SetStateSuccess(signature, nil); err != nil {
+		t.Fatalf("Set state %s error: %s", tasks.StateSuccess, err)
+	}
+	if err := backend.PurgeState(signature.UUID); err != nil {
+		t.Fatalf("Purge state error: %s", err)
+	}
+	if taskState, err := backend.GetState(signature.UUID); err == nil && taskState != nil && taskState.State != "" {
+		t.Errorf("Purged task state should not be found, got %s", taskState.State)
+	}
+
+	groupUUID := "group_" + uuid.New().String()
+	if err := backend.InitGroup(groupUUID, []string{signature.UUID}); err != nil {
+		t.Fatalf("Init group error: %s", err)
+	}
+	if err := backend.PurgeGroupMeta(groupUUID); err != nil {
+		t.Fatalf("Purge group meta error: %s", err)
+	}
+	if _, err := backend.GroupCompleted(groupUUID, 1); err == nil {
+		t.Error("Purged group meta should not be found")
+	}
+}
+
+// assertState checks the latest state of a task and returns it
+func assertState(t *testing.T, backend iface.Backend, taskUUID, state string) *tasks.TaskState {
+	t.Helper()
+
+	taskState, err := backend.GetState(taskUUID)
+	if err != nil {
+		t.Errorf("Get state of task %s error: %s", taskUUID, err)
+		return nil
+	}
+	if taskState.TaskUUID != taskUUID {
+		t.Errorf("Got state of task %s, want %s", taskState.TaskUUID, taskUUID)
+	}
+	if taskState.State != state {
+		t.Errorf("State = %s, want %s", taskState.State, state)
+	}
+	return taskState
+}
+
+// newSignature returns a signature with a unique UUID so suites can run
+// against shared services
+func newSignature(groupUUID string) *tasks.Signature {
+	return &tasks.Signature{
+		UUID:      "task_" + uuid.New().String(),
+		Name:      "conformance",
+		GroupUUID: groupUUID,
+	}
+}
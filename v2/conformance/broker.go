@@ -0,0 +1,457 @@
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/RichardKnop/machinery/v2/brokers/iface"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+// BrokerTimeout bounds how long the broker suite waits for any delivery
+var BrokerTimeout = 30 * time.Second
+
+// QuietPeriod is how long the broker suite waits to make sure a task is not
+// delivered
+var QuietPeriod = 2 * time.Second
+
+// runHeader marks tasks published by a subtest, tasks left in a queue by
+// earlier runs are acknowledged and otherwise ignored
+const runHeader = "conformance.run"
+
+// BrokerCapabilities declares behaviour not every broker provides, subtests
+// of capabilities the broker lacks are skipped
+type BrokerCapabilities struct {
+	// Ordered brokers deliver the tasks of a queue in the order they were
+	// published when consuming with concurrency 1
+	Ordered bool
+	// Redelivery brokers deliver a task again after processing it failed
+	Redelivery bool
+	// DelayTolerance is how late after its ETA a delayed task may be
+	// delivered, defaults to 5 seconds
+	DelayTolerance time.Duration
+}
+
+// TestBroker runs the broker conformance suite. newBroker is called whenever
+// the suite needs a broker, every returned broker must publish to and consume
+// from the same default queue, which no other worker consumes. Brokers are
+// stopped at most once and never reused afterwards.
+//
+// Every broker must:
+//
+//   - deliver published signatures with their fields intact
+//   - never process more tasks at once than the requested concurrency
+//   - leave tasks which are not registered in the queue for other workers
+//   - not deliver a task again once it was processed successfully
+//   - not deliver a delayed task before its ETA
+//   - wait for tasks being processed when stopped and return from
+//     StartConsuming without asking to be retried
+func TestBroker(t *testing.T, newBroker func(t *testing.T) iface.Broker, capabilities BrokerCapabilities) {
+	if capabilities.DelayTolerance == 0 {
+		capabilities.DelayTolerance = 5 * time.Second
+	}
+
+	t.Run("Delivery", func(t *testing.T) {
+		testDelivery(t, newBroker)
+	})
+	t.Run("Concurrency", func(t *testing.T) {
+		testConcurrency(t, newBroker)
+	})
+	t.Run("Unregistered", func(t *testing.T) {
+		testUnregistered(t, newBroker)
+	})
+	t.Run("Acknowledgement", func(t *testing.T) {
+		testAcknowledgement(t, newBroker)
+	})
+	t.Run("Delay", func(t *testing.T) {
+		testDelay(t, newBroker, capabilities.DelayTolerance)
+	})
+	t.Run("Stop", func(t *testing.T) {
+		testStop(t, newBroker)
+	})
+	t.Run("Ordering", func(t *testing.T) {
+		if !capabilities.Ordered {
+			t.Skip("Broker does not guarantee ordering")
+		}
+		testOrdering(t, newBroker)
+	})
+	t.Run("Redelivery", func(t *testing.T) {
+		if !capabilities.Redelivery {
+			t.Skip("Broker does not redeliver failed tasks")
+		}
+		testRedelivery(t, newBroker)
+	})
+}
+
+func testDelivery(t *testing.T, newBroker func(t *testing.T) iface.Broker) {
+	broker := newRegisteredBroker(t, newBroker)
+	processor := newRecorder()
+
+	signature := processor.newSignature()
+	signature.Args = []tasks.Arg{
+		{Name: "a", Type: "int64", Value: 1},
+		{Name: "b", Type: "string", Value: "foo"},
+	}
+	signature.Headers["foo"] = "bar"
+	signature.RetryCount = 3
+	signature.OnSuccess = []*tasks.Signature{{UUID: "task_" + uuid.New().String(), Name: "conformance"}}
+	publish(t, broker, signature)
+
+	stop := consume(t, broker, 1, processor)
+	defer stop()
+
+	delivered := processor.next(t)
+	if delivered == nil {
+		return
+	}
+	if delivered.signature.UUID != signature.UUID || delivered.signature.Name != signature.Name {
+		t.Errorf("Delivered task %s(%s), want %s(%s)", delivered.signature.Name, delivered.signature.UUID, signature.Name, signature.UUID)
+	}
+	if len(delivered.signature.Args) != len(signature.Args) {
+		t.Fatalf("Got %d args, want %d", len(delivered.signature.Args), len(signature.Args))
+	}
+	for i, arg := range signature.Args {
+		// Brokers are free to decode numbers as json.Number or float64
+		got := delivered.signature.Args[i]
+		if got.Name != arg.Name || got.Type != arg.Type || fmt.Sprint(got.Value) != fmt.Sprint(arg.Value) {
+			t.Errorf("Arg %d = %s %s(%v), want %s %s(%v)", i, got.Name, got.Type, got.Value, arg.Name, arg.Type, arg.Value)
+		}
+	}
+	if got := delivered.signature.Headers["foo"]; got != "bar" {
+		t.Errorf("Header foo = %v, want bar", got)
+	}
+	if delivered.signature.RetryCount != signature.RetryCount {
+		t.Errorf("Retry count = %d, want %d", delivered.signature.RetryCount, signature.RetryCount)
+	}
+	if len(delivered.signature.OnSuccess) != 1 || delivered.signature.OnSuccess[0].UUID != signature.OnSuccess[0].UUID {
+		t.Error("Callbacks should be delivered with the task")
+	}
+}
+
+func testConcurrency(t *testing.T, newBroker func(t *testing.T) iface.Broker) {
+	const concurrency = 2
+
+	broker := newRegisteredBroker(t, newBroker)
+	processor := newRecorder()
+	processor.process = func(*tasks.Signature) error {
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	}
+
+	for i := 0; i < 3*concurrency; i++ {
+		publish(t, broker, processor.newSignature())
+	}
+
+	stop := consume(t, broker, concurrency, processor)
+	defer stop()
+
+	for i := 0; i < 3*concurrency; i++ {
+		if processor.next(t) == nil {
+			return
+		}
+	}
+	stop()
+
+	if max := processor.maxInFlight(); max > concurrency {
+		t.Errorf("Processed %d tasks at once, want at most %d", max, concurrency)
+	}
+}
+
+func testUnregistered(t *testing.T, newBroker func(t *testing.T) iface.Broker) {
+	broker := newRegisteredBroker(t, newBroker)
+	processor := newRecorder()
+
+	signature := processor.newSignature()
+	signature.Name = "conformance_unregistered"
+	publish(t, broker, signature)
+
+	stop := consume(t, broker, 1, processor)
+	processor.none(t)
+	stop()
+
+	// Another worker with the task registered must still receive it
+	broker = newBroker(t)
+	broker.SetRegisteredTaskNames([]string{"conformance_unregistered"})
+	stop = consume(t, broker, 1, processor)
+	defer stop()
+
+	if delivered := processor.next(t); delivered != nil && delivered.signature.UUID != signature.UUID {
+		t.Errorf("Delivered task %s, want %s", delivered.signature.UUID, signature.UUID)
+	}
+}
+
+func testAcknowledgement(t *testing.T, newBroker func(t *testing.T) iface.Broker) {
+	broker := newRegisteredBroker(t, newBroker)
+	processor := newRecorder()
+	publish(t, broker, processor.newSignature())
+
+	stop := consume(t, broker, 1, processor)
+	processor.next(t)
+	stop()
+
+	broker = newRegisteredBroker(t, newBroker)
+	stop = consume(t, broker, 1, processor)
+	defer stop()
+
+	processor.none(t)
+}
+
+func testDelay(t *testing.T, newBroker func(t *testing.T) iface.Broker, tolerance time.Duration) {
+	broker := newRegisteredBroker(t, newBroker)
+	processor := newRecorder()
+
+	eta := time.Now().UTC().Add(2 * time.Second)
+	signature := processor.newSignature()
+	signature.ETA = &eta
+	publish(t, broker, signature)
+
+	stop := consume(t, broker, 1, processor)
+	defer stop()
+
+	delivered := processor.next(t)
+	if delivered == nil {
+		return
+	}
+	if delivered.at.Before(eta) {
+		t.Errorf("Delayed task delivered %s before its ETA", eta.Sub(delivered.at))
+	}
+	if late := delivered.at.Sub(eta); late > tolerance {
+		t.Errorf("Delayed task delivered %s after its ETA, want at most %s", late, tolerance)
+	}
+}
+
+func testStop(t *testing.T, newBroker func(t *testing.T) iface.Broker) {
+	broker := newRegisteredBroker(t, newBroker)
+	processor := newRecorder()
+
+	var (
+		mu       sync.Mutex
+		finished bool
+	)
+	processor.process = func(*tasks.Signature) error {
+		time.Sleep(500 * time.Millisecond)
+		mu.Lock()
+		finished = true
+		mu.Unlock()
+		return nil
+	}
+	publish(t, broker, processor.newSignature())
+
+	stop := consume(t, broker, 1, processor)
+	processor.next(t)
+	stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !finished {
+		t.Error("Stopping should wait for tasks being processed")
+	}
+}
+
+func testOrdering(t *testing.T, newBroker func(t *testing.T) iface.Broker) {
+	broker := newRegisteredBroker(t, newBroker)
+	processor := newRecorder()
+
+	signatures := make([]*tasks.Signature, 5)
+	for i := range signatures {
+		signatures[i] = processor.newSignature()
+		publish(t, broker, signatures[i])
+	}
+
+	stop := consume(t, broker, 1, processor)
+	defer stop()
+
+	for i, signature := range signatures {
+		delivered := processor.next(t)
+		if delivered == nil {
+			return
+		}
+		if delivered.signature.UUID != signature.UUID {
+			t.Errorf("Delivery %d is task %s, want %s", i, delivered.signature.UUID, signature.UUID)
+		}
+	}
+}
+
+func testRedelivery(t *testing.T, newBroker func(t *testing.T) iface.Broker) {
+	broker := newRegisteredBroker(t, newBroker)
+	processor := newRecorder()
+
+	var (
+		mu       sync.Mutex
+		attempts int
+	)
+	processor.process = func(*tasks.Signature) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			return fmt.Errorf("attempt %d failed", attempts)
+		}
+		return nil
+	}
+	signature := processor.newSignature()
+	publish(t, broker, signature)
+
+	stop := consume(t, broker, 1, processor)
+	defer stop()
+
+	for i := 0; i < 2; i++ {
+		delivered := processor.next(t)
+		if delivered == nil {
+			return
+		}
+		if delivered.signature.UUID != signature.UUID {
+			t.Errorf("Delivered task %s, want %s", delivered.signature.UUID, signature.UUID)
+		}
+	}
+}
+
+// delivery is a task handed to the processor
+type delivery struct {
+	signature *tasks.Signature
+	at        time.Time
+}
+
+// recorder is a TaskProcessor recording the tasks of a single subtest
+type recorder struct {
+	run        string
+	process    func(*tasks.Signature) error
+	deliveries chan *delivery
+
+	mu       sync.Mutex
+	inFlight int
+	max      int
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		run:        uuid.New().String(),
+		deliveries: make(chan *delivery, 100),
+	}
+}
+
+// Process records tasks of the subtest and acknowledges any others
+func (r *recorder) Process(signature *tasks.Signature) error {
+	if run, _ := signature.Headers[runHeader].(string); run != r.run {
+		return nil
+	}
+
+	r.mu.Lock()
+	r.inFlight++
+	if r.inFlight > r.max {
+		r.max = r.inFlight
+	}
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		r.inFlight--
+		r.mu.Unlock()
+	}()
+
+	r.deliveries <- &delivery{signature: signature, at: time.Now().UTC()}
+	if r.process != nil {
+		return r.process(signature)
+	}
+	return nil
+}
+
+// CustomQueue returns an empty string so the default queue is consumed
+func (r *recorder) CustomQueue() string {
+	return ""
+}
+
+// PreConsumeHandler always allows consuming
+func (r *recorder) PreConsumeHandler() bool {
+	return true
+}
+
+// newSignature returns a signature marked as published by the subtest
+func (r *recorder) newSignature() *tasks.Signature {
+	signature := newSignature("")
+	signature.Headers = tasks.Headers{runHeader: r.run}
+	return signature
+}
+
+// next waits for the next delivery
+func (r *recorder) next(t *testing.T) *delivery {
+	t.Helper()
+
+	select {
+	case delivered := <-r.deliveries:
+		return delivered
+	case <-time.After(BrokerTimeout):
+		t.Error("Timed out waiting for a delivery")
+		return nil
+	}
+}
+
+// none makes sure nothing is delivered for QuietPeriod
+func (r *recorder) none(t *testing.T) {
+	t.Helper()
+
+	select {
+	case delivered := <-r.deliveries:
+		t.Errorf("Task %s should not be delivered", delivered.signature.UUID)
+	case <-time.After(QuietPeriod):
+	}
+}
+
+func (r *recorder) maxInFlight() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.max
+}
+
+func newRegisteredBroker(t *testing.T, newBroker func(t *testing.T) iface.Broker) iface.Broker {
+	broker := newBroker(t)
+	broker.SetRegisteredTaskNames([]string{"conformance"})
+	return broker
+}
+
+func publish(t *testing.T, broker iface.Broker, signature *tasks.Signature) {
+	t.Helper()
+
+	if err := broker.Publish(context.Background(), signature); err != nil {
+		t.Fatalf("Publish error: %s", err)
+	}
+}
+
+// consume starts consuming in the background and returns a function stopping
+// it, which is safe to call more than once
+func consume(t *testing.T, broker iface.Broker, concurrency int, processor iface.TaskProcessor) func() {
+	type result struct {
+		retry bool
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		retry, err := broker.StartConsuming("conformance", concurrency, processor)
+		done <- result{retry, err}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.Helper()
+
+			broker.StopConsuming()
+			select {
+			case res := <-done:
+				if res.err != nil {
+					t.Errorf("Start consuming error: %s", res.err)
+				}
+				if res.retry {
+					t.Error("Start consuming should not ask to be retried once stopped")
+				}
+			case <-time.After(BrokerTimeout):
+				t.Error("Start consuming did not return once stopped")
+			}
+		})
+	}
+}
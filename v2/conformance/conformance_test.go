@@ -0,0 +1,116 @@
+package conformance_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RichardKnop/machinery/v2/backends/iface"
+	"github.com/RichardKnop/machinery/v2/backends/memory"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/conformance"
+	"github.com/RichardKnop/machinery/v2/tasks"
+
+	httpbroker "github.com/RichardKnop/machinery/v2/brokers/http"
+	brokeriface "github.com/RichardKnop/machinery/v2/brokers/iface"
+)
+
+func TestMemoryBackend(t *testing.T) {
+	conformance.TestBackend(t, func(t *testing.T) iface.Backend {
+		return memory.New(new(config.Config))
+	})
+}
+
+func TestHTTPBroker(t *testing.T) {
+	server := httptest.NewServer(newTaskSource())
+	defer server.Close()
+
+	conformance.TestBroker(t, func(t *testing.T) brokeriface.Broker {
+		return httpbroker.New(&config.Config{
+			Broker:       server.URL,
+			DefaultQueue: "machinery_conformance",
+			HTTP:         &config.HTTPConfig{PollInterval: 1},
+		})
+	}, conformance.BrokerCapabilities{
+		Ordered:    true,
+		Redelivery: true,
+	})
+}
+
+// taskSource is a minimal in-memory implementation of the HTTP broker
+// protocol, lease timeouts are not implemented
+type taskSource struct {
+	mu      sync.Mutex
+	queues  map[string][]*tasks.Signature
+	leased  map[string]*tasks.Signature
+	counter int
+}
+
+func newTaskSource() *taskSource {
+	return &taskSource{
+		queues: make(map[string][]*tasks.Signature),
+		leased: make(map[string]*tasks.Signature),
+	}
+}
+
+func (s *taskSource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.URL.Path {
+	case httpbroker.PublishPath:
+		signature := new(tasks.Signature)
+		if err := json.NewDecoder(r.Body).Decode(signature); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.queues[signature.RoutingKey] = append(s.queues[signature.RoutingKey], signature)
+	case httpbroker.LeasePath:
+		req := new(httpbroker.LeaseRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		resp := new(httpbroker.LeaseResponse)
+		var remaining []*tasks.Signature
+		for _, signature := range s.queues[req.Queue] {
+			if len(resp.Leases) == req.Max || (signature.ETA != nil && signature.ETA.After(time.Now().UTC())) {
+				remaining = append(remaining, signature)
+				continue
+			}
+			s.counter++
+			id := fmt.Sprintf("lease_%d", s.counter)
+			s.leased[id] = signature
+			resp.Leases = append(resp.Leases, &httpbroker.Lease{ID: id, Signature: signature})
+		}
+		s.queues[req.Queue] = remaining
+
+		if len(resp.Leases) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		json.NewEncoder(w).Encode(resp)
+	case httpbroker.AckPath, httpbroker.NackPath:
+		req := new(httpbroker.AckRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		signature, ok := s.leased[req.ID]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(s.leased, req.ID)
+		if r.URL.Path == httpbroker.NackPath {
+			s.queues[signature.RoutingKey] = append([]*tasks.Signature{signature}, s.queues[signature.RoutingKey]...)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
@@ -0,0 +1,206 @@
+//go:build integration
+// +build integration
+
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/ory/dockertest/v3"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/RichardKnop/machinery/v2/config"
+)
+
+// ContainerExpiry is how long containers started by a Harness live if Close
+// is never called, e.g. because the tests were interrupted
+var ContainerExpiry uint = 600
+
+// Harness starts the services the built-in brokers and result backends
+// depend on in Docker containers. It talks to the Docker daemon configured by
+// the DOCKER_HOST environment variable or the default socket.
+type Harness struct {
+	pool      *dockertest.Pool
+	resources []*dockertest.Resource
+}
+
+// NewHarness creates Harness instance
+func NewHarness() (*Harness, error) {
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		return nil, fmt.Errorf("Connect to Docker error: %s", err)
+	}
+	pool.MaxWait = 2 * time.Minute
+
+	return &Harness{pool: pool}, nil
+}
+
+// Close removes all containers started by the harness
+func (h *Harness) Close() error {
+	var lastErr error
+	for _, resource := range h.resources {
+		if err := h.pool.Purge(resource); err != nil {
+			lastErr = err
+		}
+	}
+	h.resources = nil
+	return lastErr
+}
+
+// Redis starts Redis and returns its host:port address
+func (h *Harness) Redis() (string, error) {
+	resource, err := h.run(&dockertest.RunOptions{Repository: "redis", Tag: "6"})
+	if err != nil {
+		return "", err
+	}
+
+	addr := resource.GetHostPort("6379/tcp")
+	return addr, h.wait("Redis", func() error {
+		client := redis.NewClient(&redis.Options{Addr: addr})
+		defer client.Close()
+		return client.Ping(context.Background()).Err()
+	})
+}
+
+// RabbitMQ starts RabbitMQ and returns its AMQP URL
+func (h *Harness) RabbitMQ() (string, error) {
+	resource, err := h.run(&dockertest.RunOptions{Repository: "rabbitmq", Tag: "3"})
+	if err != nil {
+		return "", err
+	}
+
+	url := fmt.Sprintf("amqp://guest:guest@%s/", resource.GetHostPort("5672/tcp"))
+	return url, h.wait("RabbitMQ", func() error {
+		conn, err := amqp.Dial(url)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	})
+}
+
+// Mongo starts MongoDB and returns its URL
+func (h *Harness) Mongo() (string, error) {
+	resource, err := h.run(&dockertest.RunOptions{Repository: "mongo", Tag: "4.4"})
+	if err != nil {
+		return "", err
+	}
+
+	url := fmt.Sprintf("mongodb://%s", resource.GetHostPort("27017/tcp"))
+	return url, h.wait("MongoDB", func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI(url))
+		if err != nil {
+			return err
+		}
+		defer client.Disconnect(ctx)
+		return client.Ping(ctx, nil)
+	})
+}
+
+// DynamoDB starts DynamoDB local, creates the tables named in cnf and
+// returns a client connected to it
+func (h *Harness) DynamoDB(cnf *config.DynamoDBConfig) (*dynamodb.DynamoDB, error) {
+	resource, err := h.run(&dockertest.RunOptions{Repository: "amazon/dynamodb-local", Tag: "latest"})
+	if err != nil {
+		return nil, err
+	}
+
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:    aws.String(fmt.Sprintf("http://%s", resource.GetHostPort("8000/tcp"))),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("machinery", "machinery", ""),
+	})
+	if err != nil {
+		return nil, err
+	}
+	client := dynamodb.New(sess)
+
+	err = h.wait("DynamoDB", func() error {
+		_, err := client.ListTables(&dynamodb.ListTablesInput{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tables := map[string]string{
+		cnf.TaskStatesTable: "TaskUUID",
+		cnf.GroupMetasTable: "GroupUUID",
+	}
+	for table, key := range tables {
+		_, err := client.CreateTable(&dynamodb.CreateTableInput{
+			TableName: aws.String(table),
+			AttributeDefinitions: []*dynamodb.AttributeDefinition{
+				{AttributeName: aws.String(key), AttributeType: aws.String("S")},
+			},
+			KeySchema: []*dynamodb.KeySchemaElement{
+				{AttributeName: aws.String(key), KeyType: aws.String("HASH")},
+			},
+			BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Create table %s error: %s", table, err)
+		}
+	}
+
+	return client, nil
+}
+
+// Postgres starts PostgreSQL and returns its connection URL
+func (h *Harness) Postgres() (string, error) {
+	resource, err := h.run(&dockertest.RunOptions{
+		Repository: "postgres",
+		Tag:        "13",
+		Env:        []string{"POSTGRES_PASSWORD=machinery"},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	url := fmt.Sprintf("postgres://postgres:machinery@%s/postgres?sslmode=disable", resource.GetHostPort("5432/tcp"))
+	return url, h.wait("PostgreSQL", func() error {
+		exitCode, err := resource.Exec([]string{"pg_isready", "-h", "localhost", "-U", "postgres"}, dockertest.ExecOptions{})
+		if err != nil {
+			return err
+		}
+		if exitCode != 0 {
+			return fmt.Errorf("pg_isready exited with %d", exitCode)
+		}
+		return nil
+	})
+}
+
+// run starts a container and keeps track of it for Close
+func (h *Harness) run(opts *dockertest.RunOptions) (*dockertest.Resource, error) {
+	resource, err := h.pool.RunWithOptions(opts)
+	if err != nil {
+		return nil, fmt.Errorf("Start %s container error: %s", opts.Repository, err)
+	}
+	h.resources = append(h.resources, resource)
+
+	if err := resource.Expire(ContainerExpiry); err != nil {
+		return nil, err
+	}
+
+	return resource, nil
+}
+
+// wait retries ping until the service is ready or the pool gives up
+func (h *Harness) wait(service string, ping func() error) error {
+	if err := h.pool.Retry(ping); err != nil {
+		return fmt.Errorf("%s did not become ready: %s", service, err)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+//go:build integration
+// +build integration
+
+package conformance_test
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"github.com/RichardKnop/machinery/v2"
+	"github.com/RichardKnop/machinery/v2/backends/dynamodb"
+	"github.com/RichardKnop/machinery/v2/backends/iface"
+	"github.com/RichardKnop/machinery/v2/backends/mongo"
+	"github.com/RichardKnop/machinery/v2/backends/redis"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/conformance"
+	"github.com/RichardKnop/machinery/v2/locks/eager"
+	"github.com/RichardKnop/machinery/v2/locks/postgres"
+
+	amqpbroker "github.com/RichardKnop/machinery/v2/brokers/amqp"
+	brokeriface "github.com/RichardKnop/machinery/v2/brokers/iface"
+	redisbroker "github.com/RichardKnop/machinery/v2/brokers/redis"
+)
+
+var harness *conformance.Harness
+
+func TestMain(m *testing.M) {
+	var err error
+	if harness, err = conformance.NewHarness(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	if err := harness.Close(); err != nil {
+		fmt.Println(err)
+	}
+	os.Exit(code)
+}
+
+func newConfig() *config.Config {
+	return &config.Config{
+		DefaultQueue:    "machinery_conformance",
+		ResultsExpireIn: 3600,
+		Redis: &config.RedisConfig{
+			MaxIdle:                3,
+			IdleTimeout:            240,
+			ReadTimeout:            15,
+			WriteTimeout:           15,
+			ConnectTimeout:         15,
+			NormalTasksPollPeriod:  1000,
+			DelayedTasksPollPeriod: 500,
+		},
+		AMQP: &config.AMQPConfig{
+			Exchange:      "machinery_conformance_exchange",
+			ExchangeType:  "direct",
+			BindingKey:    "machinery_conformance",
+			PrefetchCount: 1,
+		},
+	}
+}
+
+func TestRedis(t *testing.T) {
+	addr, err := harness.Redis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("GoRedisBackend", func(t *testing.T) {
+		conformance.TestBackend(t, func(t *testing.T) iface.Backend {
+			return redis.NewGR(newConfig(), []string{addr}, 0)
+		})
+	})
+	t.Run("RedigoBackend", func(t *testing.T) {
+		conformance.TestBackend(t, func(t *testing.T) iface.Backend {
+			return redis.New(newConfig(), addr, "", "", "", 0)
+		})
+	})
+	t.Run("GoRedisBroker", func(t *testing.T) {
+		conformance.TestBroker(t, func(t *testing.T) brokeriface.Broker {
+			return redisbroker.NewGR(newConfig(), []string{addr}, 0)
+		}, conformance.BrokerCapabilities{Ordered: true})
+	})
+	t.Run("GoRedisServer", func(t *testing.T) {
+		cnf := newConfig()
+		server := machinery.NewServer(cnf, redisbroker.NewGR(cnf, []string{addr}, 0), redis.NewGR(cnf, []string{addr}, 0), eager.New())
+		conformance.TestServer(t, server)
+	})
+}
+
+func TestRabbitMQ(t *testing.T) {
+	url, err := harness.RabbitMQ()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := harness.Redis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Broker", func(t *testing.T) {
+		conformance.TestBroker(t, func(t *testing.T) brokeriface.Broker {
+			cnf := newConfig()
+			cnf.Broker = url
+			return amqpbroker.New(cnf)
+		}, conformance.BrokerCapabilities{Ordered: true})
+	})
+
+	cnf := newConfig()
+	cnf.Broker = url
+	server := machinery.NewServer(cnf, amqpbroker.New(cnf), redis.NewGR(cnf, []string{addr}, 0), eager.New())
+	conformance.TestServer(t, server)
+}
+
+func TestMongo(t *testing.T) {
+	url, err := harness.Mongo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conformance.TestBackend(t, func(t *testing.T) iface.Backend {
+		cnf := newConfig()
+		cnf.ResultBackend = url
+		backend, err := mongo.New(cnf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return backend
+	})
+}
+
+func TestDynamoDB(t *testing.T) {
+	dynamoDBConfig := &config.DynamoDBConfig{
+		TaskStatesTable: "task_states",
+		GroupMetasTable: "group_metas",
+	}
+	client, err := harness.DynamoDB(dynamoDBConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dynamoDBConfig.Client = client
+
+	conformance.TestBackend(t, func(t *testing.T) iface.Backend {
+		cnf := newConfig()
+		cnf.DynamoDB = dynamoDBConfig
+		return dynamodb.New(cnf)
+	})
+}
+
+func TestPostgres(t *testing.T) {
+	url, err := harness.Postgres()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	lock := postgres.New(db, 0)
+	if err := lock.Lock("conformance", time.Now().Add(time.Minute).UnixNano()); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Lock("conformance", time.Now().Add(time.Minute).UnixNano()); err != postgres.ErrPostgresLockFailed {
+		t.Fatalf("Second lock returned %v", err)
+	}
+}
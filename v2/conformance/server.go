@@ -0,0 +1,162 @@
+package conformance
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/RichardKnop/machinery/v2"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+// ServerTimeout bounds how long the server suite waits for any result
+var ServerTimeout = 30 * time.Second
+
+// TestServer runs the end-to-end conformance suite against a server made of
+// the broker and result backend under test. The suite registers its own
+// tasks and launches a worker consuming them, so the server should use a
+// queue no other worker consumes.
+func TestServer(t *testing.T, server *machinery.Server) {
+	err := server.RegisterTasks(map[string]interface{}{
+		"conformance_add": func(args ...int64) (int64, error) {
+			sum := int64(0)
+			for _, arg := range args {
+				sum += arg
+			}
+			return sum, nil
+		},
+		"conformance_multiply": func(args ...int64) (int64, error) {
+			product := int64(1)
+			for _, arg := range args {
+				product *= arg
+			}
+			return product, nil
+		},
+		"conformance_fail": func() error {
+			return errors.New("boom")
+		},
+	})
+	if err != nil {
+		t.Fatalf("Register tasks error: %s", err)
+	}
+
+	worker := server.NewWorker("conformance_worker", 0)
+	errorsChan := make(chan error, 1)
+	worker.LaunchAsync(errorsChan)
+	defer worker.Quit()
+
+	t.Run("SendTask", func(t *testing.T) {
+		asyncResult, err := server.SendTask(newAddTask(1, 2))
+		if err != nil {
+			t.Fatalf("Send task error: %s", err)
+		}
+		assertResult(t, asyncResult.GetWithTimeout, 3)
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		asyncResult, err := server.SendTask(&tasks.Signature{Name: "conformance_fail"})
+		if err != nil {
+			t.Fatalf("Send task error: %s", err)
+		}
+		if _, err := asyncResult.GetWithTimeout(ServerTimeout, 10*time.Millisecond); err == nil || err.Error() != "boom" {
+			t.Errorf("Error = %v, want boom", err)
+		}
+	})
+
+	t.Run("Delay", func(t *testing.T) {
+		eta := time.Now().UTC().Add(time.Second)
+		signature := newAddTask(1, 1)
+		signature.ETA = &eta
+
+		asyncResult, err := server.SendTask(signature)
+		if err != nil {
+			t.Fatalf("Send task error: %s", err)
+		}
+		assertResult(t, asyncResult.GetWithTimeout, 2)
+		if time.Now().UTC().Before(eta) {
+			t.Error("Delayed task should not run before its ETA")
+		}
+	})
+
+	t.Run("SendGroup", func(t *testing.T) {
+		group, err := tasks.NewGroup(newAddTask(1, 1), newAddTask(2, 2), newAddTask(3, 3))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		asyncResults, err := server.SendGroup(group, 0)
+		if err != nil {
+			t.Fatalf("Send group error: %s", err)
+		}
+		for i, asyncResult := range asyncResults {
+			assertResult(t, asyncResult.GetWithTimeout, int64(2*(i+1)))
+		}
+	})
+
+	t.Run("SendChord", func(t *testing.T) {
+		group, err := tasks.NewGroup(newAddTask(1, 1), newAddTask(2, 2), newAddTask(3, 3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		chord, err := tasks.NewChord(group, &tasks.Signature{Name: "conformance_multiply"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		chordAsyncResult, err := server.SendChord(chord, 0)
+		if err != nil {
+			t.Fatalf("Send chord error: %s", err)
+		}
+		assertResult(t, chordAsyncResult.GetWithTimeout, 48)
+	})
+
+	t.Run("SendChain", func(t *testing.T) {
+		chain, err := tasks.NewChain(
+			newAddTask(1, 1),
+			newAddTask(2),
+			&tasks.Signature{Name: "conformance_multiply", Args: []tasks.Arg{{Type: "int64", Value: 5}}},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		chainAsyncResult, err := server.SendChain(chain)
+		if err != nil {
+			t.Fatalf("Send chain error: %s", err)
+		}
+		assertResult(t, chainAsyncResult.GetWithTimeout, 20)
+	})
+
+	select {
+	case err := <-errorsChan:
+		t.Errorf("Worker error: %s", err)
+	default:
+	}
+}
+
+func newAddTask(args ...int64) *tasks.Signature {
+	signature := &tasks.Signature{Name: "conformance_add"}
+	for _, arg := range args {
+		signature.Args = append(signature.Args, tasks.Arg{Type: "int64", Value: arg})
+	}
+	return signature
+}
+
+// assertResult waits for a single int64 result
+func assertResult(t *testing.T, get func(timeout, sleepDuration time.Duration) ([]reflect.Value, error), want int64) {
+	t.Helper()
+
+	results, err := get(ServerTimeout, 10*time.Millisecond)
+	if err != nil {
+		t.Errorf("Get result error: %s", err)
+		return
+	}
+	if len(results) != 1 {
+		t.Errorf("Got %d results, want 1", len(results))
+		return
+	}
+	if got, ok := results[0].Interface().(int64); !ok || got != want {
+		t.Errorf("Result = %v, want %d", results[0].Interface(), want)
+	}
+}
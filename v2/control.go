@@ -0,0 +1,290 @@
+package machinery
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/RichardKnop/machinery/v2/log"
+	"github.com/RichardKnop/machinery/v2/tasks"
+
+	backendsiface "github.com/RichardKnop/machinery/v2/backends/iface"
+)
+
+// controlTTL is how long control messages and their replies are kept,
+// workers which can't read messages for longer miss them
+const controlTTL = 10 * time.Minute
+
+// controlWatch reads the control messages addressed to a worker every
+// interval, and holds back the tasks the worker runs while it is paused or
+// runs as many tasks as its concurrency limit
+type controlWatch struct {
+	interval time.Duration
+	backend  backendsiface.ControlBackend
+	logger   *log.Logger
+	// handle handles a message addressed to the worker, info returns the
+	// state of the worker to reply with
+	handle func(*tasks.ControlMessage) error
+	info   func() *tasks.WorkerInfo
+	// lastID is the ID of the last message read, messages published before
+	// the worker watched are skipped
+	lastID int64
+	ready  bool
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	paused bool
+	limit  int
+	active int
+	quit   bool
+
+	stopOnce sync.Once
+	stopChan chan struct{}
+}
+
+// Broadcast sends the control message to the workers it is addressed to,
+// which handle it once they read it (see Worker.SetControlWatch), and
+// returns their replies received within replyTimeout. Zero doesn't wait for
+// replies, they can be got later with ControlReplies.
+func (server *Server) Broadcast(message *tasks.ControlMessage, replyTimeout time.Duration) ([]*tasks.ControlReply, error) {
+	controlBackend, ok := server.baseBackend().(backendsiface.ControlBackend)
+	if !ok {
+		return nil, errors.New("Result backend does not support control messages")
+	}
+	if err := validateControl(message); err != nil {
+		return nil, err
+	}
+	if err := controlBackend.PublishControl(message, controlTTL); err != nil {
+		return nil, fmt.Errorf("Publish control message error: %s", err)
+	}
+	if replyTimeout <= 0 {
+		return nil, nil
+	}
+
+	time.Sleep(replyTimeout)
+	return controlBackend.ControlReplies(message.ID)
+}
+
+// ControlReplies returns the replies of workers to the control message
+func (server *Server) ControlReplies(messageID int64) ([]*tasks.ControlReply, error) {
+	controlBackend, ok := server.baseBackend().(backendsiface.ControlBackend)
+	if !ok {
+		return nil, errors.New("Result backend does not support control messages")
+	}
+	return controlBackend.ControlReplies(messageID)
+}
+
+// validateControl returns an error if workers couldn't handle the message
+func validateControl(message *tasks.ControlMessage) error {
+	switch message.Command {
+	case tasks.ControlPause, tasks.ControlResume, tasks.ControlStats:
+		return nil
+	case tasks.ControlSetConcurrency:
+		if message.Concurrency < 0 {
+			return fmt.Errorf("Invalid concurrency %d", message.Concurrency)
+		}
+		return nil
+	case tasks.ControlSetLogLevel:
+		_, err := log.ParseLevel(message.LogLevel)
+		return err
+	default:
+		return fmt.Errorf("Unknown control command %q", message.Command)
+	}
+}
+
+// SetControlWatch makes the worker read the control messages broadcast to
+// it every interval, see Server.Broadcast. Messages broadcast before the
+// worker launched are skipped. Zero turns watching off. Must be called
+// before Launch.
+func (worker *Worker) SetControlWatch(interval time.Duration) error {
+	if interval <= 0 {
+		worker.control = nil
+		return nil
+	}
+
+	controlBackend, ok := worker.server.baseBackend().(backendsiface.ControlBackend)
+	if !ok {
+		return errors.New("Result backend does not support control messages")
+	}
+	worker.control = &controlWatch{
+		interval: interval,
+		backend:  controlBackend,
+		logger:   worker.server.log(),
+		handle:   worker.handleControl,
+		info:     worker.info,
+		stopChan: make(chan struct{}),
+	}
+	worker.control.cond = sync.NewCond(&worker.control.mu)
+	return nil
+}
+
+// handleControl carries out the command of the message
+func (worker *Worker) handleControl(message *tasks.ControlMessage) error {
+	switch message.Command {
+	case tasks.ControlPause:
+		worker.control.pause(true)
+		worker.server.log().WARNING.Printf("Worker %s paused by control message %d", worker.id(), message.ID)
+	case tasks.ControlResume:
+		worker.control.pause(false)
+		worker.server.log().WARNING.Printf("Worker %s resumed by control message %d", worker.id(), message.ID)
+	case tasks.ControlSetConcurrency:
+		if message.Concurrency < 0 {
+			return fmt.Errorf("Invalid concurrency %d", message.Concurrency)
+		}
+		// The broker was started with the concurrency of the worker, it
+		// doesn't deliver more tasks at once
+		if worker.Concurrency > 0 && message.Concurrency > worker.Concurrency {
+			return fmt.Errorf("Concurrency %d exceeds the concurrency %d the worker was launched with", message.Concurrency, worker.Concurrency)
+		}
+		worker.control.setLimit(message.Concurrency)
+		worker.server.log().WARNING.Printf("Worker %s runs %d tasks at once by control message %d", worker.id(), worker.concurrency(), message.ID)
+	case tasks.ControlSetLogLevel:
+		level, err := log.ParseLevel(message.LogLevel)
+		if err != nil {
+			return err
+		}
+		worker.server.SetLogLevel(level)
+		worker.server.log().WARNING.Printf("Worker %s logs at %s level by control message %d", worker.id(), level, message.ID)
+	case tasks.ControlStats:
+	default:
+		return fmt.Errorf("Unknown control command %q", message.Command)
+	}
+	return nil
+}
+
+// concurrency returns how many tasks the worker runs at once, zero is
+// unlimited
+func (worker *Worker) concurrency() int {
+	if worker.control != nil {
+		worker.control.mu.Lock()
+		defer worker.control.mu.Unlock()
+		if worker.control.limit > 0 {
+			return worker.control.limit
+		}
+	}
+	return worker.Concurrency
+}
+
+// paused returns true if the worker was paused
+func (worker *Worker) paused() bool {
+	if worker.control == nil {
+		return false
+	}
+
+	worker.control.mu.Lock()
+	defer worker.control.mu.Unlock()
+	return worker.control.paused
+}
+
+// requeuePaused sends a task the paused worker received while it quit back
+// to the queue
+func (worker *Worker) requeuePaused(signature *tasks.Signature) error {
+	worker.server.log().INFO.Printf("Worker %s quit while paused, sending task %s back", worker.id(), signature.UUID)
+	_, err := worker.server.SendTask(signature)
+	return err
+}
+
+// run reads the messages every interval until stopped
+func (w *controlWatch) run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	w.check()
+	for {
+		select {
+		case <-ticker.C:
+			w.check()
+		case <-w.stopChan:
+			return
+		}
+	}
+}
+
+// stop stops reading messages and lets tasks held back by the concurrency
+// limit run, tasks held back by a pause aren't run
+func (w *controlWatch) stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopChan)
+		w.mu.Lock()
+		w.quit = true
+		w.cond.Broadcast()
+		w.mu.Unlock()
+	})
+}
+
+// check handles the messages published since the last check and replies
+// to them
+func (w *controlWatch) check() {
+	messages, err := w.backend.ControlMessages(w.lastID)
+	if err != nil {
+		w.logger.WARNING.Printf("Failed to read control messages: %s", err)
+		return
+	}
+
+	for _, message := range messages {
+		w.lastID = message.ID
+		if !w.ready {
+			continue
+		}
+		info := w.info()
+		if !message.AddressedTo(info.ConsumerTag, info.ID) {
+			continue
+		}
+
+		reply := &tasks.ControlReply{MessageID: message.ID}
+		if err := w.handle(message); err != nil {
+			w.logger.WARNING.Printf("Failed to handle control message %d: %s", message.ID, err)
+			reply.Error = err.Error()
+		}
+		reply.Worker = w.info()
+		if err := w.backend.ReplyControl(reply, controlTTL); err != nil {
+			w.logger.WARNING.Printf("Failed to reply to control message %d: %s", message.ID, err)
+		}
+	}
+	w.ready = true
+}
+
+// acquire waits until the worker may run a task, it returns false if the
+// worker quit while paused. A task which acquired must be released.
+func (w *controlWatch) acquire() bool {
+	if w == nil {
+		return true
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for !w.quit && (w.paused || (w.limit > 0 && w.active >= w.limit)) {
+		w.cond.Wait()
+	}
+	if w.paused {
+		return false
+	}
+	w.active++
+	return true
+}
+
+func (w *controlWatch) release() {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.active--
+	w.cond.Broadcast()
+}
+
+func (w *controlWatch) pause(paused bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paused = paused
+	w.cond.Broadcast()
+}
+
+func (w *controlWatch) setLimit(limit int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.limit = limit
+	w.cond.Broadcast()
+}
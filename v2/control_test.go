@@ -0,0 +1,145 @@
+package machinery_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/backends/memory"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/log"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestControl(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	release := make(chan struct{})
+	err := server.RegisterTask("wait", func() error {
+		<-release
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// The eager backend can't broadcast control messages
+	worker := server.NewWorker("test_worker", 2)
+	assert.Error(t, worker.SetControlWatch(time.Minute))
+	_, err = server.Broadcast(&tasks.ControlMessage{Command: tasks.ControlPause}, 0)
+	assert.Error(t, err)
+
+	server.SetBackend(memory.New(new(config.Config)))
+	_, err = server.Broadcast(&tasks.ControlMessage{Command: tasks.ControlPause}, 0)
+	assert.NoError(t, err)
+	_, err = server.Broadcast(&tasks.ControlMessage{Command: "reboot"}, 0)
+	assert.Error(t, err)
+	_, err = server.Broadcast(&tasks.ControlMessage{Command: tasks.ControlSetLogLevel, LogLevel: "LOUD"}, 0)
+	assert.Error(t, err)
+
+	// Messages broadcast before the worker watched are skipped
+	assert.NoError(t, worker.SetControlWatch(time.Minute))
+	worker.CheckControl()
+
+	process := func(taskUUID string) chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			assert.NoError(t, worker.Process(&tasks.Signature{UUID: taskUUID, Name: "wait"}))
+		}()
+		return done
+	}
+	started := func(taskUUID string) bool {
+		state, err := server.GetBackend().GetState(taskUUID)
+		return err == nil && state.State == tasks.StateStarted
+	}
+
+	// Messages addressed to other workers are ignored
+	_, err = server.Broadcast(&tasks.ControlMessage{Command: tasks.ControlSetConcurrency, Concurrency: 1, Destination: []string{"other_worker"}}, 0)
+	assert.NoError(t, err)
+	message := &tasks.ControlMessage{Command: tasks.ControlSetConcurrency, Concurrency: 1, Destination: []string{"test_worker"}}
+	_, err = server.Broadcast(message, 0)
+	assert.NoError(t, err)
+	worker.CheckControl()
+
+	replies, err := server.ControlReplies(message.ID)
+	if assert.NoError(t, err) && assert.Len(t, replies, 1) {
+		assert.Equal(t, "test_worker", replies[0].Worker.ConsumerTag)
+		assert.Equal(t, 1, replies[0].Worker.Concurrency)
+		assert.Empty(t, replies[0].Error)
+	}
+
+	// The concurrency can't be raised above the one the worker was launched with
+	message = &tasks.ControlMessage{Command: tasks.ControlSetConcurrency, Concurrency: 3}
+	_, err = server.Broadcast(message, 0)
+	assert.NoError(t, err)
+	worker.CheckControl()
+
+	replies, err = server.ControlReplies(message.ID)
+	if assert.NoError(t, err) && assert.Len(t, replies, 1) {
+		assert.Equal(t, 1, replies[0].Worker.Concurrency)
+		assert.NotEmpty(t, replies[0].Error)
+	}
+
+	// The worker runs one task at a time
+	first, second := process("task_1"), process("task_2")
+	assert.Eventually(t, func() bool { return started("task_1") || started("task_2") }, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, started("task_1") && started("task_2"))
+	release <- struct{}{}
+	assert.Eventually(t, func() bool { return started("task_1") || started("task_2") }, time.Second, time.Millisecond)
+	release <- struct{}{}
+	<-first
+	<-second
+
+	// Paused workers fetch and run no tasks until resumed
+	assert.True(t, worker.PreConsumeHandler())
+	_, err = server.Broadcast(&tasks.ControlMessage{Command: tasks.ControlPause}, 0)
+	assert.NoError(t, err)
+	worker.CheckControl()
+	assert.False(t, worker.PreConsumeHandler())
+	third := process("task_3")
+	time.Sleep(20 * time.Millisecond)
+	state, err := server.GetBackend().GetState("task_3")
+	assert.Error(t, err, "task_3 must not run, got %v", state)
+
+	checked := make(chan struct{})
+	go func() {
+		defer close(checked)
+		time.Sleep(20 * time.Millisecond)
+		worker.CheckControl()
+	}()
+	replies, err = server.Broadcast(&tasks.ControlMessage{Command: tasks.ControlStats}, 200*time.Millisecond)
+	<-checked
+	if assert.NoError(t, err) && assert.Len(t, replies, 1) {
+		assert.True(t, replies[0].Worker.Paused)
+		assert.Empty(t, replies[0].Worker.Running)
+	}
+
+	_, err = server.Broadcast(&tasks.ControlMessage{Command: tasks.ControlResume}, 0)
+	assert.NoError(t, err)
+	worker.CheckControl()
+	assert.True(t, worker.PreConsumeHandler())
+	assert.Eventually(t, func() bool { return started("task_3") }, time.Second, time.Millisecond)
+	release <- struct{}{}
+	<-third
+
+	// The log level of the server is switched
+	_, err = server.Broadcast(&tasks.ControlMessage{Command: tasks.ControlSetLogLevel, LogLevel: "error"}, 0)
+	assert.NoError(t, err)
+	worker.CheckControl()
+	assert.Equal(t, log.LevelError, server.LogLevel())
+
+	// Tasks held back by a pause are sent back once the worker quits
+	_, err = server.Broadcast(&tasks.ControlMessage{Command: tasks.ControlPause}, 0)
+	assert.NoError(t, err)
+	worker.CheckControl()
+	fourth := process("task_4")
+	time.Sleep(20 * time.Millisecond)
+	worker.Quit()
+	<-fourth
+	requeued := broker.next()
+	if assert.NotNil(t, requeued) {
+		assert.Equal(t, "task_4", requeued.UUID)
+	}
+}
@@ -0,0 +1,200 @@
+package machinery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/RichardKnop/machinery/v2/backends/result"
+	"github.com/RichardKnop/machinery/v2/tasks"
+
+	backendsiface "github.com/RichardKnop/machinery/v2/backends/iface"
+)
+
+// ListDeadLetters returns the dead letters matching the filter, newest first,
+// e.g. to find out why tasks failed for good. Not every result backend
+// supports it.
+func (server *Server) ListDeadLetters(filter *tasks.DeadLetterFilter) ([]*tasks.DeadLetter, error) {
+	deadLetterBackend, ok := server.baseBackend().(backendsiface.DeadLetterBackend)
+	if !ok {
+		return nil, errors.New("Result backend does not support dead letters")
+	}
+	if filter == nil {
+		filter = new(tasks.DeadLetterFilter)
+	}
+	return deadLetterBackend.ListDeadLetters(filter)
+}
+
+// RedriveDeadLetters sends the tasks of the dead letters matching the filter
+// back to the queues they were consumed from, at most limit of them or all if
+// limit is zero, e.g. once the bug which made them fail is fixed. The modify
+// functions may change each task before it is sent, e.g. to fix its arguments
+// or route it to another queue, they get its arguments decoded. Redriven
+// tasks keep their UUIDs, run as if they were sent anew and their dead
+// letters are deleted. Dead letters whose task can't be decoded, or which a
+// modify function returns an error for, are kept.
+func (server *Server) RedriveDeadLetters(ctx context.Context, filter *tasks.DeadLetterFilter, limit int, modify ...func(*tasks.Signature) error) ([]*result.AsyncResult, error) {
+	deadLetterBackend, ok := server.baseBackend().(backendsiface.DeadLetterBackend)
+	if !ok {
+		return nil, errors.New("Result backend does not support dead letters")
+	}
+
+	page := new(tasks.DeadLetterFilter)
+	if filter != nil {
+		*page = *filter
+	}
+	page.Limit = limit
+	deadLetters, err := deadLetterBackend.ListDeadLetters(page)
+	if err != nil {
+		return nil, fmt.Errorf("List dead letters error: %s", err)
+	}
+
+	asyncResults := make([]*result.AsyncResult, 0, len(deadLetters))
+	for _, deadLetter := range deadLetters {
+		if err := ctx.Err(); err != nil {
+			return asyncResults, err
+		}
+
+		signature, err := server.redrivenTask(deadLetter, modify)
+		if err != nil {
+			server.log().WARNING.Printf("Dead letter %s is not redriven: %s", deadLetter.ID, err)
+			continue
+		}
+
+		asyncResult, err := server.SendTaskWithContext(ctx, signature)
+		if err != nil {
+			return asyncResults, fmt.Errorf("Redrive dead letter %s error: %s", deadLetter.ID, err)
+		}
+		asyncResults = append(asyncResults, asyncResult)
+
+		if err := deadLetterBackend.DeleteDeadLetter(deadLetter.ID); err != nil {
+			server.log().ERROR.Printf("Failed to delete redriven dead letter %s: %s", deadLetter.ID, err)
+		}
+	}
+	return asyncResults, nil
+}
+
+// redrivenTask decodes the task of the dead letter and prepares it to be sent
+// again
+func (server *Server) redrivenTask(deadLetter *tasks.DeadLetter, modify []func(*tasks.Signature) error) (*tasks.Signature, error) {
+	signature, err := server.signatureCodec().DecodeSignature(deadLetter.Message, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// The task was consumed from the queue it is sent back to, its retries
+	// start over
+	signature.RoutingKey = deadLetter.RoutingKey
+	signature.ETA = nil
+	signature.RetryTimeout, signature.RetryAttempt = 0, 0
+	signature.ReceiveCount = 0
+	signature.SQSReceiptHandle = ""
+
+	if len(modify) == 0 {
+		return signature, nil
+	}
+	if err := server.decodeArgs(signature); err != nil {
+		return nil, err
+	}
+	for _, apply := range modify {
+		if err := apply(signature); err != nil {
+			return nil, err
+		}
+	}
+	return signature, nil
+}
+
+// deadLettering returns true if tasks which failed for good are kept as dead
+// letters
+func (server *Server) deadLettering() bool {
+	return server.GetConfig().DeadLetterQueue != ""
+}
+
+// signatureCodec returns the codec tasks are published with
+func (server *Server) signatureCodec() tasks.Codec {
+	if server.codec != nil {
+		return server.codec
+	}
+	return tasks.NewWireFormatCodec(server.GetConfig().WireFormat)
+}
+
+// putDeadLetter stores the dead letter, unless dead-lettering is off
+func (server *Server) putDeadLetter(deadLetter *tasks.DeadLetter) error {
+	if !server.deadLettering() {
+		return nil
+	}
+
+	deadLetterBackend, ok := server.baseBackend().(backendsiface.DeadLetterBackend)
+	if !ok {
+		return errors.New("Result backend does not support dead letters")
+	}
+	if err := deadLetterBackend.PutDeadLetter(deadLetter); err != nil {
+		return fmt.Errorf("Put dead letter %s error: %s", deadLetter.ID, err)
+	}
+	return nil
+}
+
+// encodeDelivered encodes the task as it was delivered, before its arguments
+// are decoded, in case it ends up as a dead letter. It returns nil if
+// dead-lettering is off.
+func (worker *Worker) encodeDelivered(signature *tasks.Signature) []byte {
+	if !worker.server.deadLettering() {
+		return nil
+	}
+
+	delivered, err := worker.server.signatureCodec().EncodeSignature(signature)
+	if err != nil {
+		worker.server.log().WARNING.Printf("Failed to encode task %s, it can't be dead-lettered: %s", signature.UUID, err)
+		return nil
+	}
+	return delivered
+}
+
+// deadLetter keeps the delivered message of the task which failed for good as
+// a dead letter
+func (worker *Worker) deadLetter(signature *tasks.Signature, delivered []byte, reason string, taskErr error) {
+	if delivered == nil {
+		return
+	}
+
+	// Brokers publishing to the default queue may leave the routing key
+	// empty, the task was consumed from the queue of the worker then
+	routingKey := signature.RoutingKey
+	if routingKey == "" {
+		routingKey = worker.CustomQueue()
+	}
+	if routingKey == "" {
+		routingKey = worker.server.GetConfig().DefaultQueue
+	}
+
+	err := worker.server.putDeadLetter(&tasks.DeadLetter{
+		ID:         signature.UUID,
+		TaskName:   signature.Name,
+		RoutingKey: routingKey,
+		Reason:     reason,
+		Error:      taskErr.Error(),
+		FailedAt:   time.Now().UTC(),
+		Message:    delivered,
+	})
+	if err != nil {
+		worker.server.log().ERROR.Printf("Failed to dead-letter task %s: %s", signature.UUID, err)
+		return
+	}
+	worker.server.log().WARNING.Printf("Task %s failed for good, it was dead-lettered", signature.UUID)
+}
+
+// DeadLetterMessage keeps a message the broker consumed from the queue but
+// couldn't decode into a task as a dead letter, if dead-lettering is on
+func (worker *Worker) DeadLetterMessage(queue string, message []byte, reason error) error {
+	return worker.server.putDeadLetter(&tasks.DeadLetter{
+		ID:         uuid.New().String(),
+		RoutingKey: queue,
+		Reason:     tasks.DeadLetterUndecodable,
+		Error:      reason.Error(),
+		FailedAt:   time.Now().UTC(),
+		Message:    message,
+	})
+}
@@ -0,0 +1,176 @@
+package machinery_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2"
+	"github.com/RichardKnop/machinery/v2/backends/memory"
+	"github.com/RichardKnop/machinery/v2/common"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/tasks"
+
+	lock "github.com/RichardKnop/machinery/v2/locks/eager"
+)
+
+// newDeadLetterServer returns a server keeping dead letters in a memory
+// backend
+func newDeadLetterServer(t *testing.T, deadLetterQueue string) (*machinery.Server, *recordingBroker) {
+	cnf := &config.Config{DefaultQueue: "machinery_tasks", DeadLetterQueue: deadLetterQueue}
+	broker := &recordingBroker{Broker: common.NewBroker(cnf)}
+	server := machinery.NewServer(cnf, broker, memory.New(cnf), lock.New())
+	err := server.RegisterTasks(map[string]interface{}{
+		"fail": func(message string) error { return errors.New(message) },
+		"divide": func(a, b int64) (int64, error) {
+			if b == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return a / b, nil
+		},
+	})
+	assert.NoError(t, err)
+	return server, broker
+}
+
+func TestDeadLetters(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newDeadLetterServer(t, "dead_letters")
+	worker := server.NewWorker("test_worker", 0)
+
+	// Tasks are dead-lettered once no retries remain
+	asyncResult, err := server.SendTask(&tasks.Signature{
+		Name:       "fail",
+		RetryCount: 1,
+		Args:       []tasks.Arg{{Type: "string", Value: "boom"}},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, worker.Process(broker.next()))
+	deadLetters, err := server.ListDeadLetters(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, deadLetters, "tasks with retries remaining are retried")
+
+	assert.NoError(t, worker.Process(broker.next()))
+	deadLetters, err = server.ListDeadLetters(nil)
+	assert.NoError(t, err)
+	if assert.Len(t, deadLetters, 1) {
+		deadLetter := deadLetters[0]
+		assert.Equal(t, asyncResult.Signature.UUID, deadLetter.ID)
+		assert.Equal(t, "fail", deadLetter.TaskName)
+		assert.Equal(t, "machinery_tasks", deadLetter.RoutingKey)
+		assert.Equal(t, tasks.DeadLetterExhausted, deadLetter.Reason)
+		assert.Equal(t, "boom", deadLetter.Error)
+
+		signature, err := tasks.DecodeSignature(deadLetter.Message, false)
+		if assert.NoError(t, err) {
+			assert.Equal(t, asyncResult.Signature.UUID, signature.UUID)
+			assert.Equal(t, "boom", signature.Args[0].Value)
+		}
+	}
+	taskState, err := server.GetBackend().GetState(asyncResult.Signature.UUID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, tasks.StateFailure, taskState.State, "dead-lettered tasks are still stored as failed")
+	}
+
+	// Tasks whose arguments can't be decoded are dead-lettered right away
+	err = worker.Process(&tasks.Signature{
+		UUID: "task_malformed",
+		Name: "divide",
+		Args: []tasks.Arg{{Type: "int64", Value: "one"}, {Type: "int64", Value: 2}},
+	})
+	assert.Error(t, err)
+	deadLetters, err = server.ListDeadLetters(&tasks.DeadLetterFilter{Reason: tasks.DeadLetterUndecodable})
+	if assert.NoError(t, err) && assert.Len(t, deadLetters, 1) {
+		assert.Equal(t, "task_malformed", deadLetters[0].ID)
+	}
+
+	// So are messages brokers can't decode into tasks
+	broker.DeadLetterMessage(worker, []byte("garbage"), errors.New("invalid character"))
+	deadLetters, err = server.ListDeadLetters(&tasks.DeadLetterFilter{Reason: tasks.DeadLetterUndecodable})
+	if assert.NoError(t, err) && assert.Len(t, deadLetters, 2) {
+		assert.Equal(t, []byte("garbage"), deadLetters[0].Message)
+		assert.Equal(t, "machinery_tasks", deadLetters[0].RoutingKey)
+		assert.Empty(t, deadLetters[0].TaskName)
+	}
+}
+
+func TestDeadLetteringOff(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newDeadLetterServer(t, "")
+	worker := server.NewWorker("test_worker", 0)
+
+	_, err := server.SendTask(&tasks.Signature{Name: "fail", Args: []tasks.Arg{{Type: "string", Value: "boom"}}})
+	assert.NoError(t, err)
+	assert.NoError(t, worker.Process(broker.next()))
+	broker.DeadLetterMessage(worker, []byte("garbage"), errors.New("invalid character"))
+
+	deadLetters, err := server.ListDeadLetters(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, deadLetters)
+}
+
+func TestRedriveDeadLetters(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newDeadLetterServer(t, "dead_letters")
+	worker := server.NewWorker("test_worker", 0)
+	for _, divisor := range []int64{0, 0} {
+		_, err := server.SendTask(&tasks.Signature{
+			Name: "divide",
+			Args: []tasks.Arg{{Type: "int64", Value: 6}, {Type: "int64", Value: divisor}},
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, worker.Process(broker.next()))
+	}
+	broker.DeadLetterMessage(worker, []byte("garbage"), errors.New("invalid character"))
+
+	// Messages which can't be decoded are kept
+	asyncResults, err := server.RedriveDeadLetters(context.Background(), &tasks.DeadLetterFilter{Reason: tasks.DeadLetterUndecodable}, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, asyncResults)
+	assert.Nil(t, broker.peek())
+
+	// Tasks are sent back to their queue with the fixed arguments
+	fixDivisor := func(signature *tasks.Signature) error {
+		signature.Args[1].Value = 3
+		return nil
+	}
+	filter := &tasks.DeadLetterFilter{Reason: tasks.DeadLetterExhausted}
+	asyncResults, err = server.RedriveDeadLetters(context.Background(), filter, 1, fixDivisor)
+	assert.NoError(t, err)
+	if !assert.Len(t, asyncResults, 1) {
+		return
+	}
+	redriven := broker.next()
+	if assert.NotNil(t, redriven) {
+		assert.Equal(t, asyncResults[0].Signature.UUID, redriven.UUID)
+		assert.Equal(t, "machinery_tasks", redriven.RoutingKey)
+		assert.NoError(t, worker.Process(redriven))
+	}
+	taskState, err := server.GetBackend().GetState(asyncResults[0].Signature.UUID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, tasks.StateSuccess, taskState.State)
+	}
+
+	deadLetters, err := server.ListDeadLetters(filter)
+	assert.NoError(t, err)
+	if assert.Len(t, deadLetters, 1, "redriven dead letters are deleted") {
+		assert.NotEqual(t, asyncResults[0].Signature.UUID, deadLetters[0].ID)
+	}
+
+	// Tasks may be routed to another queue
+	reroute := func(signature *tasks.Signature) error {
+		signature.RoutingKey = "math"
+		return nil
+	}
+	asyncResults, err = server.RedriveDeadLetters(context.Background(), filter, 0, reroute)
+	assert.NoError(t, err)
+	assert.Len(t, asyncResults, 1)
+	if redriven := broker.next(); assert.NotNil(t, redriven) {
+		assert.Equal(t, "math", redriven.RoutingKey)
+	}
+}
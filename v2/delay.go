@@ -0,0 +1,79 @@
+package machinery
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/RichardKnop/machinery/v2/backends/result"
+	"github.com/RichardKnop/machinery/v2/brokers/errs"
+	"github.com/RichardKnop/machinery/v2/tasks"
+
+	brokersiface "github.com/RichardKnop/machinery/v2/brokers/iface"
+)
+
+// earlyDeliveryTolerance is how much earlier than its ETA a task may be
+// delivered and still run, so clocks of hosts drifting apart don't make
+// workers publish tasks again
+const earlyDeliveryTolerance = time.Second
+
+// deliveredEarly returns true if the task was delivered before its ETA by a
+// broker able to delay it, i.e. its ETA was further away than the broker
+// holds tasks for. Tasks of brokers unable to delay them run once received.
+func (worker *Worker) deliveredEarly(signature *tasks.Signature) bool {
+	if signature.ETA == nil || !signature.ETA.After(time.Now().Add(earlyDeliveryTolerance)) {
+		return false
+	}
+	delayedDelivery, ok := worker.server.GetBroker().(brokersiface.DelayedDeliveryBroker)
+	if !ok {
+		return false
+	}
+	_, ok = delayedDelivery.MaxDeliveryDelay(signature)
+	return ok
+}
+
+// delayAgain publishes a task delivered before its ETA again, the broker
+// holds it for as long as it can
+func (worker *Worker) delayAgain(signature *tasks.Signature) error {
+	worker.server.log().INFO.Printf("Task %s was delivered %.0f seconds before its ETA, publishing it again", signature.UUID, time.Until(*signature.ETA).Seconds())
+	_, err := worker.server.SendTask(signature)
+	return err
+}
+
+// CancelDelayedTask withdraws a task sent with an ETA in the future, e.g. a
+// reminder which is pointless once the user acted. Brokers implementing
+// DelayedTaskBroker drop it, its state is set to FAILURE with
+// tasks.ErrTaskRevoked. Tasks other brokers hold, or which were delivered
+// already, are cancelled with CancelTask.
+func (server *Server) CancelDelayedTask(taskUUID string) error {
+	if delayedTasks, ok := server.broker.(brokersiface.DelayedTaskBroker); ok {
+		signature, err := delayedTasks.RemoveDelayedTask(taskUUID)
+		if err == nil {
+			server.releaseIdempotencyKey(signature)
+			server.releaseUniqueKey(signature)
+			return server.backend.SetStateFailure(signature, tasks.ErrTaskRevoked.Error())
+		}
+		if !errors.Is(err, errs.ErrDelayedTaskNotFound) {
+			return fmt.Errorf("Remove delayed task error: %s", err)
+		}
+	}
+	return server.CancelTask(taskUUID)
+}
+
+// RescheduleDelayedTask moves a task sent with an ETA in the future to the
+// new ETA. The broker must implement DelayedTaskBroker and still hold the
+// task, otherwise errs.ErrDelayedTaskNotFound is returned.
+func (server *Server) RescheduleDelayedTask(taskUUID string, eta time.Time) (*result.AsyncResult, error) {
+	delayedTasks, ok := server.broker.(brokersiface.DelayedTaskBroker)
+	if !ok {
+		return nil, errors.New("Broker does not support rescheduling delayed tasks")
+	}
+	signature, err := delayedTasks.RemoveDelayedTask(taskUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	eta = eta.UTC()
+	signature.ETA = &eta
+	return server.SendTask(signature)
+}
@@ -0,0 +1,153 @@
+package machinery_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2"
+	"github.com/RichardKnop/machinery/v2/backends/iface"
+	"github.com/RichardKnop/machinery/v2/backends/memory"
+	"github.com/RichardKnop/machinery/v2/brokers/errs"
+	"github.com/RichardKnop/machinery/v2/common"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/tasks"
+
+	backend "github.com/RichardKnop/machinery/v2/backends/eager"
+	lock "github.com/RichardKnop/machinery/v2/locks/eager"
+)
+
+// delayingBroker holds tasks until their ETA, up to maxDelay
+type delayingBroker struct {
+	*recordingBroker
+	maxDelay time.Duration
+}
+
+func (b *delayingBroker) MaxDeliveryDelay(signature *tasks.Signature) (time.Duration, bool) {
+	return b.maxDelay, true
+}
+
+func TestEarlyDelivery(t *testing.T) {
+	t.Parallel()
+
+	recorder := &recordingBroker{Broker: common.NewBroker(new(config.Config))}
+	broker := &delayingBroker{recordingBroker: recorder, maxDelay: 15 * time.Minute}
+	server := machinery.NewServer(new(config.Config), broker, backend.New(), lock.New())
+
+	var runs int32
+	assert.NoError(t, server.RegisterTask("delayed", func() error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	}))
+	worker := server.NewWorker("test_worker", 0)
+
+	// Delivered when the broker stopped holding it, an hour early
+	eta := time.Now().Add(time.Hour).UTC()
+	assert.NoError(t, worker.Process(&tasks.Signature{UUID: "task_1", Name: "delayed", ETA: &eta}))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&runs))
+	delayed := recorder.next()
+	if assert.NotNil(t, delayed) {
+		assert.Equal(t, "task_1", delayed.UUID)
+		assert.True(t, eta.Equal(*delayed.ETA))
+	}
+
+	// Delivered at its ETA
+	eta = time.Now().Add(100 * time.Millisecond).UTC()
+	assert.NoError(t, worker.Process(&tasks.Signature{UUID: "task_2", Name: "delayed", ETA: &eta}))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&runs))
+	assert.Nil(t, recorder.next())
+
+	// Brokers unable to delay tasks deliver them at once
+	server, recorder = newRecordingServer(t)
+	assert.NoError(t, server.RegisterTask("delayed", func() error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	}))
+	eta = time.Now().Add(time.Hour).UTC()
+	assert.NoError(t, server.NewWorker("test_worker", 0).Process(&tasks.Signature{UUID: "task_3", Name: "delayed", ETA: &eta}))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&runs))
+	assert.Nil(t, recorder.next())
+}
+
+// holdingBroker holds tasks until their ETA and lets them be removed
+type holdingBroker struct {
+	*recordingBroker
+	mu      sync.Mutex
+	delayed map[string]*tasks.Signature
+}
+
+func (b *holdingBroker) Publish(ctx context.Context, signature *tasks.Signature) error {
+	if signature.ETA == nil || !signature.ETA.After(time.Now()) {
+		return b.recordingBroker.Publish(ctx, signature)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	held := *signature
+	b.delayed[signature.UUID] = &held
+	return nil
+}
+
+func (b *holdingBroker) RemoveDelayedTask(taskUUID string) (*tasks.Signature, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	signature, ok := b.delayed[taskUUID]
+	if !ok {
+		return nil, errs.ErrDelayedTaskNotFound
+	}
+	delete(b.delayed, taskUUID)
+	return signature, nil
+}
+
+func (b *holdingBroker) held(taskUUID string) *tasks.Signature {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.delayed[taskUUID]
+}
+
+func TestCancelAndRescheduleDelayedTasks(t *testing.T) {
+	t.Parallel()
+
+	recorder := &recordingBroker{Broker: common.NewBroker(new(config.Config))}
+	broker := &holdingBroker{recordingBroker: recorder, delayed: make(map[string]*tasks.Signature)}
+	backend := memory.New(new(config.Config))
+	server := machinery.NewServer(new(config.Config), broker, backend, lock.New())
+
+	eta := time.Now().Add(24 * time.Hour).UTC()
+	_, err := server.SendTask(&tasks.Signature{UUID: "reminder", Name: "send_reminder", ETA: &eta})
+	assert.NoError(t, err)
+
+	// The user acted earlier than expected
+	newETA := time.Now().Add(time.Hour)
+	asyncResult, err := server.RescheduleDelayedTask("reminder", newETA)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "reminder", asyncResult.Signature.UUID)
+	}
+	if held := broker.held("reminder"); assert.NotNil(t, held) {
+		assert.True(t, newETA.Equal(*held.ETA))
+	}
+
+	assert.NoError(t, server.CancelDelayedTask("reminder"))
+	assert.Nil(t, broker.held("reminder"))
+	state, err := backend.GetState("reminder")
+	if assert.NoError(t, err) {
+		assert.Equal(t, tasks.StateFailure, state.State)
+		assert.Equal(t, tasks.ErrTaskRevoked.Error(), state.Error)
+	}
+
+	// Tasks the broker doesn't hold can't be rescheduled, they are revoked
+	// when cancelled
+	_, err = server.RescheduleDelayedTask("reminder", newETA)
+	assert.Equal(t, errs.ErrDelayedTaskNotFound, err)
+	assert.NoError(t, server.CancelDelayedTask("delivered"))
+	revoked, err := backend.(iface.RevokeBackend).IsRevoked("delivered")
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	plain, _ := newRecordingServer(t)
+	_, err = plain.RescheduleDelayedTask("reminder", newETA)
+	assert.Error(t, err)
+}
@@ -0,0 +1,138 @@
+package machinery
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/RichardKnop/logging"
+
+	backendsiface "github.com/RichardKnop/machinery/v2/backends/iface"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+// EnableDiagnostics makes the worker store a snapshot of the environment
+// along with the state of tasks which failed for good: the signature, worker
+// ID, hostname, Go version and the last logLines lines the task logged with
+// tasks.LoggerFromContext. Needs a result backend storing diagnostics. Must
+// be called before Launch.
+func (worker *Worker) EnableDiagnostics(logLines int) {
+	worker.diagnosticsLogLines = logLines
+	worker.diagnostics = true
+}
+
+// taskLogger logs the lines of a task with the server's logger, prefixed by
+// the task's UUID, keeping the last ones for diagnostics
+type taskLogger struct {
+	logger   logging.LoggerInterface
+	prefix   string
+	maxLines int
+
+	mu    sync.Mutex
+	lines []string
+}
+
+func (worker *Worker) newTaskLogger(signature *tasks.Signature) *taskLogger {
+	maxLines := 0
+	if worker.diagnostics {
+		maxLines = worker.diagnosticsLogLines
+	}
+	return &taskLogger{
+		logger:   worker.server.log().INFO,
+		prefix:   fmt.Sprintf("Task %s: ", signature.UUID),
+		maxLines: maxLines,
+	}
+}
+
+func (l *taskLogger) record(line string) string {
+	line = l.prefix + line
+	if l.maxLines <= 0 {
+		return line
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, line)
+	if len(l.lines) > l.maxLines {
+		l.lines = l.lines[len(l.lines)-l.maxLines:]
+	}
+	return line
+}
+
+// logs returns the lines kept for diagnostics
+func (l *taskLogger) logs() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string{}, l.lines...)
+}
+
+// Print logs the arguments
+func (l *taskLogger) Print(v ...interface{}) { l.logger.Print(l.record(fmt.Sprint(v...))) }
+
+// Printf logs the formatted arguments
+func (l *taskLogger) Printf(format string, v ...interface{}) {
+	l.logger.Print(l.record(fmt.Sprintf(format, v...)))
+}
+
+// Println logs the arguments
+func (l *taskLogger) Println(v ...interface{}) { l.logger.Print(l.record(fmt.Sprint(v...))) }
+
+// Fatal logs the arguments and exits
+func (l *taskLogger) Fatal(v ...interface{}) { l.logger.Fatal(l.record(fmt.Sprint(v...))) }
+
+// Fatalf logs the formatted arguments and exits
+func (l *taskLogger) Fatalf(format string, v ...interface{}) {
+	l.logger.Fatal(l.record(fmt.Sprintf(format, v...)))
+}
+
+// Fatalln logs the arguments and exits
+func (l *taskLogger) Fatalln(v ...interface{}) { l.logger.Fatal(l.record(fmt.Sprint(v...))) }
+
+// Panic logs the arguments and panics
+func (l *taskLogger) Panic(v ...interface{}) { l.logger.Panic(l.record(fmt.Sprint(v...))) }
+
+// Panicf logs the formatted arguments and panics
+func (l *taskLogger) Panicf(format string, v ...interface{}) {
+	l.logger.Panic(l.record(fmt.Sprintf(format, v...)))
+}
+
+// Panicln logs the arguments and panics
+func (l *taskLogger) Panicln(v ...interface{}) { l.logger.Panic(l.record(fmt.Sprint(v...))) }
+
+// captureDiagnostics stores the diagnostics of the failed task if the
+// worker captures them
+func (worker *Worker) captureDiagnostics(signature *tasks.Signature, logger *taskLogger) {
+	if !worker.diagnostics {
+		return
+	}
+
+	diagnosticsBackend, ok := worker.server.baseBackend().(backendsiface.DiagnosticsBackend)
+	if !ok {
+		worker.server.log().WARNING.Printf("Result backend does not support diagnostics, not storing them for task %s", signature.UUID)
+		return
+	}
+
+	// The arguments were decrypted for the task, they aren't stored in the
+	// clear when encryption is enabled
+	stored := signature
+	if worker.server.decryptor() != nil {
+		withoutArgs := *signature
+		withoutArgs.Args = nil
+		stored = &withoutArgs
+	}
+
+	hostname, _ := os.Hostname()
+	diagnostics := &tasks.Diagnostics{
+		Signature:  stored,
+		WorkerID:   worker.ConsumerTag,
+		Hostname:   hostname,
+		GoVersion:  runtime.Version(),
+		CapturedAt: time.Now().UTC(),
+		Logs:       logger.logs(),
+	}
+	if err := diagnosticsBackend.SetDiagnostics(signature, diagnostics); err != nil {
+		worker.server.log().ERROR.Printf("Failed to store diagnostics of task %s: %s", signature.UUID, err)
+	}
+}
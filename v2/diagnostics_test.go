@@ -0,0 +1,111 @@
+package machinery_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/backends/memory"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/log"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	server.SetBackend(memory.New(new(config.Config)))
+	logger := new(recordingLogger)
+	server.SetLogger(log.New(logger))
+
+	err := server.RegisterTask("import", func(ctx context.Context, path string) error {
+		taskLogger := tasks.LoggerFromContext(ctx)
+		taskLogger.Printf("opening %s", path)
+		taskLogger.Print("parsing rows")
+		taskLogger.Print("row 42 is malformed")
+		return errors.New("import failed")
+	})
+	assert.NoError(t, err)
+
+	send := func() *tasks.Signature {
+		signature := &tasks.Signature{
+			Name:    "import",
+			Args:    []tasks.Arg{{Type: "string", Value: "rows.csv"}},
+			Headers: tasks.Headers{"tenant": "acme"},
+		}
+		_, err := server.SendTask(signature)
+		assert.NoError(t, err)
+		return signature
+	}
+
+	// Diagnostics are only captured once enabled
+	signature := send()
+	drain(t, server.NewWorker("test_worker", 1), broker)
+	taskState, err := server.GetBackend().GetState(signature.UUID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, tasks.StateFailure, taskState.State)
+		assert.Nil(t, taskState.Diagnostics)
+	}
+	assert.True(t, logger.logged("Task "+signature.UUID+": opening rows.csv"))
+
+	signature = send()
+	worker := server.NewWorker("test_worker", 1)
+	worker.EnableDiagnostics(2)
+	drain(t, worker, broker)
+
+	taskState, err = server.GetBackend().GetState(signature.UUID)
+	if assert.NoError(t, err) && assert.NotNil(t, taskState.Diagnostics) {
+		diagnostics := taskState.Diagnostics
+		assert.Equal(t, tasks.StateFailure, taskState.State)
+		assert.Equal(t, "import", diagnostics.Signature.Name)
+		assert.Equal(t, "acme", diagnostics.Signature.Headers["tenant"])
+		assert.Equal(t, "test_worker", diagnostics.WorkerID)
+		assert.Equal(t, runtime.Version(), diagnostics.GoVersion)
+		assert.NotEmpty(t, diagnostics.Hostname)
+		assert.Equal(t, []string{
+			"Task " + signature.UUID + ": parsing rows",
+			"Task " + signature.UUID + ": row 42 is malformed",
+		}, diagnostics.Logs)
+	}
+}
+
+func TestDiagnosticsEncrypted(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	backend := memory.New(new(config.Config))
+	server.SetBackend(backend)
+	keys, err := tasks.NewStaticKeyProvider("k1", map[string][]byte{"k1": make([]byte, 32)})
+	assert.NoError(t, err)
+	server.SetEncryption(keys)
+
+	err = server.RegisterTask("import", func(path string) error {
+		return errors.New("import failed")
+	})
+	assert.NoError(t, err)
+
+	signature := &tasks.Signature{
+		Name: "import",
+		Args: []tasks.Arg{{Type: "string", Value: "secret.csv"}},
+	}
+	_, err = server.SendTask(signature)
+	assert.NoError(t, err)
+	worker := server.NewWorker("test_worker", 1)
+	worker.EnableDiagnostics(2)
+	drain(t, worker, broker)
+
+	// No plaintext argument reaches the backend
+	taskState, err := backend.GetState(signature.UUID)
+	if assert.NoError(t, err) && assert.NotNil(t, taskState.Diagnostics) {
+		assert.Equal(t, "import", taskState.Diagnostics.Signature.Name)
+		assert.Empty(t, taskState.Diagnostics.Signature.Args)
+		stored, err := json.Marshal(taskState)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(stored), "secret.csv")
+	}
+}
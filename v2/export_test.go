@@ -0,0 +1,71 @@
+package machinery
+
+import "time"
+
+// RunPeriodic runs every registered periodic task and workflow once, the
+// scheduler doesn't run them more often than every minute
+func (server *Server) RunPeriodic() {
+	for _, entry := range server.scheduler.Entries() {
+		entry.Job.Run()
+	}
+}
+
+// NextPeriodicRuns returns when every registered periodic task and workflow
+// runs next after the time, in the order they were registered
+func (server *Server) NextPeriodicRuns(after time.Time) []time.Time {
+	entries := server.scheduler.Entries()
+	runs := make([]time.Time, len(entries))
+	for i, entry := range entries {
+		runs[i] = entry.Schedule.Next(after)
+	}
+	return runs
+}
+
+// StartDelay draws how long the worker waits before it starts consuming
+func (worker *Worker) StartDelay() time.Duration {
+	return worker.startDelay()
+}
+
+// CheckPreemption preempts tasks if the worker's policy says so
+func (worker *Worker) CheckPreemption() {
+	worker.preemptor.check()
+}
+
+// ReportInFlight reports the tasks the worker is processing to the backend
+func (worker *Worker) ReportInFlight() {
+	worker.inFlight.report()
+}
+
+// StartHeartbeat starts writing the worker's heartbeats, as Launch does
+func (worker *Worker) StartHeartbeat() {
+	worker.presence.start()
+}
+
+// CheckControl handles the control messages broadcast to the worker
+func (worker *Worker) CheckControl() {
+	worker.control.check()
+}
+
+// ReportHeartbeat writes the worker's heartbeat to the backend
+func (worker *Worker) ReportHeartbeat() {
+	worker.presence.report()
+}
+
+// CheckRevocations cancels the running tasks which were revoked
+func (worker *Worker) CheckRevocations() {
+	worker.revocations.check()
+}
+
+// WatchDebugLoggingSignals toggles DEBUG logging of the server on SIGUSR2
+func (server *Server) WatchDebugLoggingSignals() {
+	server.watchDebugLoggingSignals()
+}
+
+// NextPeriodicRun returns when the periodic spec runs next after the time
+func NextPeriodicRun(spec string, after time.Time) (time.Time, error) {
+	schedule, err := parsePeriodicSpec(spec)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return schedule.Next(after), nil
+}
@@ -0,0 +1,398 @@
+package external
+
+// NOTE: The gateway lets workers written in other languages run tasks. A Go
+// worker consumes the tasks from the configured broker as usual and hands
+// them to external workers, which pull them from the gateway using the
+// following protocol. All requests are POSTs with a JSON body, paths are
+// relative to where the gateway is mounted:
+//
+// 1) /lease with LeaseRequest, responds with LeaseResponse. Each Envelope
+//    holds a task to run. Waits up to Wait seconds for tasks if there are
+//    none. A task is leased again if it is neither acknowledged, rejected
+//    nor its progress reported for LeaseTimeout seconds.
+// 2) /ack with AckRequest completes a task with its results.
+// 3) /nack with NackRequest fails a task, it is retried like Go tasks.
+// 4) /progress with ProgressRequest reports the progress of a task and
+//    extends its lease.
+//
+// Every lease of a task has an ID of its own, requests for a lease which was
+// already completed or expired fail with 404 Not Found. The Go worker stores
+// the states of the tasks and sends their callbacks, so external workers
+// never talk to brokers or backends.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/RichardKnop/machinery/v2"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+// Paths of the external worker protocol
+const (
+	LeasePath    = "/lease"
+	AckPath      = "/ack"
+	NackPath     = "/nack"
+	ProgressPath = "/progress"
+)
+
+const (
+	defaultLeaseTimeout = 30 * time.Second
+	defaultMaxWait      = 20 * time.Second
+)
+
+// LeaseRequest asks for up to Max tasks with one of the names, of any task
+// registered with the gateway if Tasks is empty
+type LeaseRequest struct {
+	WorkerID string   `json:"worker_id"`
+	Tasks    []string `json:"tasks"`
+	Max      int      `json:"max"`
+	Wait     int      `json:"wait"`
+}
+
+// Envelope is a task leased to a single external worker. The task must be
+// completed by Deadline, if set, and is leased again if it is neither
+// acknowledged, rejected nor its progress reported for LeaseTimeout seconds.
+type Envelope struct {
+	ID           string           `json:"id"`
+	Signature    *tasks.Signature `json:"signature"`
+	Deadline     *time.Time       `json:"deadline,omitempty"`
+	LeaseTimeout int              `json:"lease_timeout"`
+}
+
+// LeaseResponse holds leased tasks, it is empty if there are none
+type LeaseResponse struct {
+	Envelopes []*Envelope `json:"envelopes"`
+}
+
+// AckRequest completes a task with its results, typed like arguments
+type AckRequest struct {
+	ID      string              `json:"id"`
+	Results []*tasks.TaskResult `json:"results"`
+}
+
+// NackRequest fails a task with the error. The task is retried in RetryIn
+// seconds if set, otherwise according to the retry settings of its signature.
+type NackRequest struct {
+	ID      string `json:"id"`
+	Error   string `json:"error"`
+	RetryIn int    `json:"retry_in"`
+}
+
+// ProgressRequest reports the progress of a task
+type ProgressRequest struct {
+	ID      string  `json:"id"`
+	Percent float64 `json:"percent"`
+	Message string  `json:"message"`
+}
+
+// Gateway hands tasks consumed by a Go worker to external workers over HTTP
+type Gateway struct {
+	// LeaseTimeout is for how long leased tasks are reserved for a worker
+	// without hearing from it, defaults to 30 seconds
+	LeaseTimeout time.Duration
+	// MaxWait bounds how long lease requests wait for tasks, defaults to 20
+	// seconds
+	MaxWait time.Duration
+
+	server *machinery.Server
+	names  map[string]bool
+
+	mu      sync.Mutex
+	queued  []*delivery
+	leased  map[string]*delivery
+	arrived chan struct{}
+}
+
+// delivery is a task waiting for an external worker to run it
+type delivery struct {
+	// id is the ID of the delivery's current lease, a new one for every
+	// lease so workers whose lease expired can't complete the task
+	id        string
+	ctx       context.Context
+	signature *tasks.Signature
+	expiry    *time.Timer
+	done      chan outcome
+}
+
+type outcome struct {
+	results []*tasks.TaskResult
+	err     error
+}
+
+// New creates a gateway for the server's tasks, register the tasks external
+// workers run with Register
+func New(server *machinery.Server) *Gateway {
+	return &Gateway{
+		LeaseTimeout: defaultLeaseTimeout,
+		MaxWait:      defaultMaxWait,
+		server:       server,
+		names:        make(map[string]bool),
+		leased:       make(map[string]*delivery),
+		arrived:      make(chan struct{}),
+	}
+}
+
+// Register registers the tasks with the server, so Go workers of the server
+// hand them to external workers. The worker's concurrency bounds how many of
+// them run at once.
+func (g *Gateway) Register(names ...string) error {
+	for _, name := range names {
+		if err := g.server.RegisterTask(name, g.run); err != nil {
+			return err
+		}
+		g.mu.Lock()
+		g.names[name] = true
+		g.mu.Unlock()
+	}
+	return nil
+}
+
+// run waits for an external worker to run the task
+func (g *Gateway) run(ctx context.Context, _ ...interface{}) ([]*tasks.TaskResult, error) {
+	signature := tasks.SignatureFromContext(ctx)
+	if signature == nil {
+		return nil, errors.New("External task called without its signature")
+	}
+
+	d := &delivery{
+		ctx:       ctx,
+		signature: signature,
+		done:      make(chan outcome, 1),
+	}
+	g.mu.Lock()
+	g.queue(d)
+	g.mu.Unlock()
+
+	select {
+	case result := <-d.done:
+		return result.results, result.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		g.remove(d)
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// queue makes the delivery available for leasing, g.mu must be held
+func (g *Gateway) queue(d *delivery) {
+	g.queued = append(g.queued, d)
+	close(g.arrived)
+	g.arrived = make(chan struct{})
+}
+
+// remove forgets the delivery, g.mu must be held
+func (g *Gateway) remove(d *delivery) {
+	if d.expiry != nil {
+		d.expiry.Stop()
+	}
+	delete(g.leased, d.id)
+	for i, queued := range g.queued {
+		if queued == d {
+			g.queued = append(g.queued[:i], g.queued[i+1:]...)
+			break
+		}
+	}
+}
+
+// expire makes the delivery available again if the lease ran out
+func (g *Gateway) expire(d *delivery, id string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if d.id != id || g.leased[id] != d {
+		return
+	}
+	delete(g.leased, d.id)
+	g.queue(d)
+}
+
+// take leases up to max queued deliveries of the names, g.mu must be held
+func (g *Gateway) take(names []string, max int) []*Envelope {
+	if max < 1 {
+		max = 1
+	}
+	wanted := func(name string) bool {
+		if len(names) == 0 {
+			return g.names[name]
+		}
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	var envelopes []*Envelope
+	remaining := g.queued[:0]
+	for _, d := range g.queued {
+		if len(envelopes) == max || !wanted(d.signature.Name) {
+			remaining = append(remaining, d)
+			continue
+		}
+
+		d, id := d, uuid.New().String()
+		d.id = id
+		g.leased[id] = d
+		d.expiry = time.AfterFunc(g.LeaseTimeout, func() { g.expire(d, id) })
+		envelope := &Envelope{
+			ID:           d.id,
+			Signature:    d.signature,
+			LeaseTimeout: int(g.LeaseTimeout / time.Second),
+		}
+		if deadline, ok := d.ctx.Deadline(); ok {
+			envelope.Deadline = &deadline
+		}
+		envelopes = append(envelopes, envelope)
+	}
+	g.queued = remaining
+	return envelopes
+}
+
+// lease waits up to wait for deliveries of the names
+func (g *Gateway) lease(ctx context.Context, request *LeaseRequest) []*Envelope {
+	wait := time.Duration(request.Wait) * time.Second
+	if wait > g.MaxWait {
+		wait = g.MaxWait
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		g.mu.Lock()
+		envelopes := g.take(request.Tasks, request.Max)
+		arrived := g.arrived
+		g.mu.Unlock()
+		if len(envelopes) > 0 || wait <= 0 {
+			return envelopes
+		}
+
+		select {
+		case <-arrived:
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// complete ends the lease with the outcome, false if the lease is unknown
+func (g *Gateway) complete(id string, result outcome) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	d, ok := g.leased[id]
+	if !ok {
+		return false
+	}
+	g.remove(d)
+	d.done <- result
+	return true
+}
+
+// ServeHTTP serves the external worker protocol
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch r.URL.Path {
+	case LeasePath:
+		request := new(LeaseRequest)
+		if !decodeRequest(w, r, request) {
+			return
+		}
+		response := &LeaseResponse{Envelopes: g.lease(r.Context(), request)}
+		if response.Envelopes == nil {
+			response.Envelopes = []*Envelope{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			g.server.Logger().ERROR.Printf("Failed to send leases to worker %s: %s", request.WorkerID, err)
+		}
+	case AckPath:
+		request := new(AckRequest)
+		if !decodeRequest(w, r, request) {
+			return
+		}
+		for i, result := range request.Results {
+			if result == nil {
+				http.Error(w, fmt.Sprintf("Result %d is null", i), http.StatusBadRequest)
+				return
+			}
+			if _, err := tasks.ReflectValue(result.Type, result.Value); err != nil {
+				http.Error(w, fmt.Sprintf("Result %d: %s", i, err), http.StatusBadRequest)
+				return
+			}
+		}
+		g.respond(w, g.complete(request.ID, outcome{results: request.Results}))
+	case NackPath:
+		request := new(NackRequest)
+		if !decodeRequest(w, r, request) {
+			return
+		}
+		var err error = errors.New(request.Error)
+		if request.RetryIn > 0 {
+			err = tasks.NewErrRetryTaskLater(request.Error, time.Duration(request.RetryIn)*time.Second)
+		}
+		g.respond(w, g.complete(request.ID, outcome{err: err}))
+	case ProgressPath:
+		request := new(ProgressRequest)
+		if !decodeRequest(w, r, request) {
+			return
+		}
+		g.progress(w, request)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// progress reports the progress of a leased task and extends its lease
+func (g *Gateway) progress(w http.ResponseWriter, request *ProgressRequest) {
+	g.mu.Lock()
+	d, ok := g.leased[request.ID]
+	if ok {
+		d.expiry.Reset(g.LeaseTimeout)
+	}
+	g.mu.Unlock()
+	if !ok {
+		g.respond(w, false)
+		return
+	}
+
+	reporter := tasks.ProgressReporterFromContext(d.ctx)
+	if err := reporter.ReportProgress(request.Percent, request.Message); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	g.respond(w, true)
+}
+
+func (g *Gateway) respond(w http.ResponseWriter, found bool) {
+	if !found {
+		http.Error(w, "Lease not found, it completed or expired", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeRequest decodes the JSON body, numbers as json.Number like tasks
+// received from brokers, responding with 400 Bad Request if it is malformed
+func decodeRequest(w http.ResponseWriter, r *http.Request, request interface{}) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(request); err != nil {
+		http.Error(w, fmt.Sprintf("Decode request error: %s", err), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
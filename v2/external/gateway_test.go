@@ -0,0 +1,192 @@
+package external_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2"
+	"github.com/RichardKnop/machinery/v2/backends/memory"
+	"github.com/RichardKnop/machinery/v2/backends/result"
+	"github.com/RichardKnop/machinery/v2/brokers/eager"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/external"
+	lock "github.com/RichardKnop/machinery/v2/locks/eager"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+// newGateway returns a gateway running the tasks with the names, served by
+// an HTTP test server, whose tasks are processed as soon as they are sent
+func newGateway(t *testing.T, names ...string) (*machinery.Server, *external.Gateway, *httptest.Server) {
+	cnf := new(config.Config)
+	broker := eager.New()
+	server := machinery.NewServer(cnf, broker, memory.New(cnf), lock.New())
+	broker.(eager.Mode).AssignWorker(server.NewWorker("test_worker", 1))
+
+	gateway := external.New(server)
+	assert.NoError(t, gateway.Register(names...))
+
+	httpServer := httptest.NewServer(gateway)
+	t.Cleanup(httpServer.Close)
+	return server, gateway, httpServer
+}
+
+// send sends the task in the background, as it is processed while sending
+func send(server *machinery.Server, signature *tasks.Signature) <-chan *result.AsyncResult {
+	sent := make(chan *result.AsyncResult, 1)
+	go func() {
+		asyncResult, _ := server.SendTask(signature)
+		sent <- asyncResult
+	}()
+	return sent
+}
+
+// post posts the request to the gateway, decoding the response if given
+func post(t *testing.T, httpServer *httptest.Server, path string, request, response interface{}) int {
+	body, err := json.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(httpServer.URL+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if response != nil && resp.StatusCode == http.StatusOK {
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(response))
+	}
+	return resp.StatusCode
+}
+
+func lease(t *testing.T, httpServer *httptest.Server, request *external.LeaseRequest) []*external.Envelope {
+	response := new(external.LeaseResponse)
+	assert.Equal(t, http.StatusOK, post(t, httpServer, external.LeasePath, request, response))
+	return response.Envelopes
+}
+
+func TestGateway(t *testing.T) {
+	t.Parallel()
+
+	server, _, httpServer := newGateway(t, "add")
+
+	sent := send(server, &tasks.Signature{
+		Name:    "add",
+		Args:    []tasks.Arg{{Type: "int64", Value: 1}, {Type: "int64", Value: 2}},
+		Timeout: time.Minute,
+	})
+
+	envelopes := lease(t, httpServer, &external.LeaseRequest{WorkerID: "python", Tasks: []string{"add"}, Wait: 5})
+	if !assert.Len(t, envelopes, 1) {
+		return
+	}
+	envelope := envelopes[0]
+	assert.Equal(t, "add", envelope.Signature.Name)
+	assert.Len(t, envelope.Signature.Args, 2)
+	assert.NotNil(t, envelope.Deadline)
+	assert.Equal(t, 30, envelope.LeaseTimeout)
+
+	// The task is leased to a single worker
+	assert.Empty(t, lease(t, httpServer, &external.LeaseRequest{Tasks: []string{"add"}}))
+
+	progress := &external.ProgressRequest{ID: envelope.ID, Percent: 50, Message: "halfway"}
+	assert.Equal(t, http.StatusNoContent, post(t, httpServer, external.ProgressPath, progress, nil))
+	state, err := server.GetBackend().GetState(envelope.Signature.UUID)
+	if assert.NoError(t, err) && assert.NotNil(t, state.Progress) {
+		assert.Equal(t, "halfway", state.Progress.Message)
+	}
+
+	ack := &external.AckRequest{ID: envelope.ID, Results: []*tasks.TaskResult{{Type: "int64", Value: 3}}}
+	assert.Equal(t, http.StatusNoContent, post(t, httpServer, external.AckPath, ack, nil))
+
+	asyncResult := <-sent
+	results, err := asyncResult.Get(time.Millisecond)
+	if assert.NoError(t, err) && assert.Len(t, results, 1) {
+		assert.Equal(t, int64(3), results[0].Int())
+	}
+
+	// The lease is gone once the task completed
+	assert.Equal(t, http.StatusNotFound, post(t, httpServer, external.AckPath, ack, nil))
+	assert.Equal(t, http.StatusNotFound, post(t, httpServer, external.ProgressPath, progress, nil))
+}
+
+func TestGatewayNack(t *testing.T) {
+	t.Parallel()
+
+	server, _, httpServer := newGateway(t, "fail")
+
+	sent := send(server, &tasks.Signature{Name: "fail"})
+	envelopes := lease(t, httpServer, &external.LeaseRequest{Wait: 5})
+	if !assert.Len(t, envelopes, 1) {
+		return
+	}
+
+	nack := &external.NackRequest{ID: envelopes[0].ID, Error: "division by zero"}
+	assert.Equal(t, http.StatusNoContent, post(t, httpServer, external.NackPath, nack, nil))
+
+	asyncResult := <-sent
+	_, err := asyncResult.Get(time.Millisecond)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "division by zero")
+	}
+}
+
+func TestGatewayLeaseExpires(t *testing.T) {
+	t.Parallel()
+
+	server, gateway, httpServer := newGateway(t, "slow")
+	gateway.LeaseTimeout = 50 * time.Millisecond
+
+	sent := send(server, &tasks.Signature{Name: "slow"})
+	first := lease(t, httpServer, &external.LeaseRequest{Wait: 5})
+	if !assert.Len(t, first, 1) {
+		return
+	}
+
+	// A worker which stopped responding loses the task to another one
+	gateway.LeaseTimeout = time.Minute
+	second := lease(t, httpServer, &external.LeaseRequest{Wait: 5})
+	if !assert.Len(t, second, 1) {
+		return
+	}
+	assert.Equal(t, first[0].Signature.UUID, second[0].Signature.UUID)
+	assert.NotEqual(t, first[0].ID, second[0].ID)
+
+	// The worker whose lease expired can't complete the task any more
+	stale := &external.AckRequest{ID: first[0].ID}
+	assert.Equal(t, http.StatusNotFound, post(t, httpServer, external.AckPath, stale, nil))
+	nack := &external.NackRequest{ID: first[0].ID, Error: "stale"}
+	assert.Equal(t, http.StatusNotFound, post(t, httpServer, external.NackPath, nack, nil))
+
+	ack := &external.AckRequest{ID: second[0].ID}
+	assert.Equal(t, http.StatusNoContent, post(t, httpServer, external.AckPath, ack, nil))
+	asyncResult := <-sent
+	_, err := asyncResult.Get(time.Millisecond)
+	assert.NoError(t, err)
+}
+
+func TestGatewayBadRequests(t *testing.T) {
+	t.Parallel()
+
+	_, _, httpServer := newGateway(t, "add")
+
+	// Nothing to lease
+	assert.Empty(t, lease(t, httpServer, &external.LeaseRequest{}))
+
+	ack := &external.AckRequest{ID: "unknown", Results: []*tasks.TaskResult{{Type: "int64", Value: "three"}}}
+	assert.Equal(t, http.StatusBadRequest, post(t, httpServer, external.AckPath, ack, nil))
+	ack.Results = nil
+	assert.Equal(t, http.StatusNotFound, post(t, httpServer, external.AckPath, ack, nil))
+	assert.Equal(t, http.StatusNotFound, post(t, httpServer, "/unknown", ack, nil))
+
+	resp, err := http.Get(httpServer.URL + external.LeasePath)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
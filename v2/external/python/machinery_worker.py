@@ -0,0 +1,174 @@
+"""Reference worker running machinery tasks in Python.
+
+Talks to a machinery external.Gateway mounted at an HTTP URL, see the
+protocol described in external/gateway.go. Only uses the standard library.
+
+    from machinery_worker import Worker, RetryLater
+
+    worker = Worker("http://localhost:8080/machinery", worker_id="py-1")
+
+    @worker.task("add")
+    def add(ctx, a, b):
+        ctx.report_progress(50, "adding")
+        return a + b
+
+    worker.run()
+
+Tasks get a Context followed by the values of the signature's arguments.
+They return None, a single value, or a tuple of values, each sent as a task
+result. Raise RetryLater to retry the task after a delay, any other
+exception fails it, retried according to the signature's settings.
+"""
+
+import json
+import logging
+import time
+import urllib.error
+import urllib.request
+
+log = logging.getLogger("machinery_worker")
+
+
+class RetryLater(Exception):
+    """Retries the task in retry_in seconds."""
+
+    def __init__(self, message, retry_in):
+        super().__init__(message)
+        self.retry_in = retry_in
+
+
+class LeaseLost(Exception):
+    """The lease of the task expired or the task completed already."""
+
+
+class Context:
+    """What a task knows about its run."""
+
+    def __init__(self, worker, envelope):
+        self._worker = worker
+        self.lease_id = envelope["id"]
+        self.signature = envelope["signature"]
+        self.deadline = envelope.get("deadline")
+
+    @property
+    def uuid(self):
+        return self.signature.get("UUID")
+
+    def report_progress(self, percent, message=""):
+        """Reports the progress, between 0 and 100 percent, and extends the lease."""
+        self._worker.post("/progress", {"id": self.lease_id, "percent": percent, "message": message})
+
+
+def result_type(value):
+    """Returns the machinery type of a result value."""
+    if isinstance(value, bool):
+        return "bool"
+    if isinstance(value, int):
+        return "int64"
+    if isinstance(value, float):
+        return "float64"
+    if isinstance(value, str):
+        return "string"
+    if isinstance(value, dict):
+        return "map[string]interface{}"
+    if isinstance(value, (list, tuple)):
+        types = {result_type(item) for item in value}
+        if len(types) == 1 and next(iter(types)) in ("bool", "int64", "float64", "string"):
+            return "[]" + types.pop()
+        if not value:
+            return "[]string"
+    raise TypeError("unsupported result type %s" % type(value).__name__)
+
+
+class Worker:
+    """Leases tasks from the gateway and runs them."""
+
+    def __init__(self, url, worker_id="python", max_tasks=1, wait=20, timeout=60):
+        self.url = url.rstrip("/")
+        self.worker_id = worker_id
+        self.max_tasks = max_tasks
+        self.wait = wait
+        self.timeout = timeout
+        self.tasks = {}
+        self.running = False
+
+    def task(self, name):
+        """Decorator registering the function as the task with the name."""
+
+        def register(func):
+            self.tasks[name] = func
+            return func
+
+        return register
+
+    def post(self, path, body):
+        request = urllib.request.Request(
+            self.url + path,
+            data=json.dumps(body).encode("utf-8"),
+            headers={"Content-Type": "application/json"},
+            method="POST",
+        )
+        try:
+            with urllib.request.urlopen(request, timeout=self.timeout) as response:
+                data = response.read()
+        except urllib.error.HTTPError as e:
+            if e.code == 404:
+                raise LeaseLost(e.read().decode("utf-8", "replace")) from e
+            raise
+        return json.loads(data) if data else None
+
+    def lease(self):
+        response = self.post("/lease", {
+            "worker_id": self.worker_id,
+            "tasks": sorted(self.tasks),
+            "max": self.max_tasks,
+            "wait": self.wait,
+        })
+        return response.get("envelopes") or []
+
+    def process(self, envelope):
+        """Runs the leased task and acknowledges or rejects it."""
+        ctx = Context(self, envelope)
+        name = ctx.signature.get("Name")
+        args = [arg.get("Value") for arg in ctx.signature.get("Args") or []]
+        try:
+            returned = self.tasks[name](ctx, *args)
+            if returned is None:
+                values = []
+            elif isinstance(returned, tuple):
+                values = list(returned)
+            else:
+                values = [returned]
+            results = [{"Type": result_type(value), "Value": value} for value in values]
+        except RetryLater as e:
+            self.post("/nack", {"id": ctx.lease_id, "error": str(e), "retry_in": e.retry_in})
+            return
+        except LeaseLost:
+            log.warning("Lost the lease of task %s", ctx.uuid)
+            return
+        except Exception as e:
+            log.exception("Task %s failed", ctx.uuid)
+            self.post("/nack", {"id": ctx.lease_id, "error": "%s: %s" % (type(e).__name__, e)})
+            return
+        self.post("/ack", {"id": ctx.lease_id, "results": results})
+
+    def run(self):
+        """Leases and runs tasks until stop is called."""
+        self.running = True
+        while self.running:
+            try:
+                envelopes = self.lease()
+            except (urllib.error.URLError, OSError) as e:
+                log.warning("Lease failed: %s", e)
+                time.sleep(1)
+                continue
+            for envelope in envelopes:
+                try:
+                    self.process(envelope)
+                except LeaseLost:
+                    log.warning("Lost the lease of task %s", envelope["signature"].get("UUID"))
+                except (urllib.error.URLError, OSError) as e:
+                    log.warning("Reporting task %s failed: %s", envelope["signature"].get("UUID"), e)
+
+    def stop(self):
+        self.running = False
@@ -0,0 +1,85 @@
+package machinery
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/opentracing/opentracing-go"
+
+	"github.com/RichardKnop/machinery/v2/backends/result"
+	"github.com/RichardKnop/machinery/v2/tasks"
+	"github.com/RichardKnop/machinery/v2/tracing"
+
+	backendsiface "github.com/RichardKnop/machinery/v2/backends/iface"
+)
+
+// claimIdempotencyKey makes the task own its idempotency key unless another
+// task does, in which case it returns the result of that task so the task
+// isn't sent again. Tasks sent again, e.g. retried, keep owning their key.
+func (server *Server) claimIdempotencyKey(signature *tasks.Signature) (*result.AsyncResult, error) {
+	idempotencyBackend, ok := server.baseBackend().(backendsiface.IdempotencyBackend)
+	if !ok {
+		return nil, errors.New("Result backend does not support idempotency keys")
+	}
+
+	owner, err := idempotencyBackend.ClaimIdempotencyKey(signature)
+	if err != nil {
+		return nil, fmt.Errorf("Claim idempotency key error: %s", err)
+	}
+	if owner == signature.UUID {
+		return nil, nil
+	}
+
+	server.log().INFO.Printf("Task %s has the idempotency key of task %s, not sending it", signature.UUID, owner)
+	duplicate := *signature
+	duplicate.UUID = owner
+	return result.NewAsyncResult(&duplicate, server.backend), nil
+}
+
+// releaseIdempotencyKey lets another task own the key of a task which
+// wasn't sent
+func (server *Server) releaseIdempotencyKey(signature *tasks.Signature) {
+	idempotencyBackend, ok := server.baseBackend().(backendsiface.IdempotencyBackend)
+	if !ok || signature.IdempotencyKey == "" {
+		return
+	}
+
+	if err := idempotencyBackend.ReleaseIdempotencyKey(signature.IdempotencyKey, signature.UUID); err != nil {
+		server.log().ERROR.Printf("Failed to release idempotency key of task %s: %s", signature.UUID, err)
+	}
+}
+
+// succeededDuplicate returns the state of the task owning the idempotency
+// key of the task if it succeeded, nil otherwise
+func (server *Server) succeededDuplicate(signature *tasks.Signature) (*tasks.TaskState, error) {
+	idempotencyBackend, ok := server.baseBackend().(backendsiface.IdempotencyBackend)
+	if !ok || signature.IdempotencyKey == "" {
+		return nil, nil
+	}
+
+	owner, err := idempotencyBackend.IdempotencyKeyOwner(signature.IdempotencyKey)
+	if err != nil || owner == "" {
+		return nil, err
+	}
+	taskState, err := server.GetBackend().GetState(owner)
+	if err != nil || !taskState.IsSuccess() {
+		return nil, nil
+	}
+	return taskState, nil
+}
+
+// skipDuplicate completes the task with the results of the task owning its
+// idempotency key, which already succeeded, without running it again
+func (worker *Worker) skipDuplicate(span opentracing.Span, signature *tasks.Signature, ownerState *tasks.TaskState) error {
+	worker.server.log().WARNING.Printf("Task %s with idempotency key %s already succeeded, not running it again", ownerState.TaskUUID, signature.IdempotencyKey)
+	if ownerState.TaskUUID == signature.UUID {
+		return nil
+	}
+
+	if err := worker.server.GetBackend().SetStateSuccess(signature, ownerState.Results); err != nil {
+		return fmt.Errorf("Set state to 'success' for task %s returned error: %s", signature.UUID, err)
+	}
+	tracing.LogStateTransition(span, tasks.StateSuccess)
+	worker.server.releaseUniqueKey(signature)
+	return nil
+}
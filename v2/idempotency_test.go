@@ -0,0 +1,63 @@
+package machinery_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/backends/memory"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	server.SetBackend(memory.New(new(config.Config)))
+	sent := 0
+	err := server.RegisterTask("send_email", func(to string) (string, error) {
+		sent++
+		return "message_" + to, nil
+	})
+	assert.NoError(t, err)
+
+	newSignature := func() *tasks.Signature {
+		return &tasks.Signature{
+			Name:           "send_email",
+			Args:           []tasks.Arg{{Type: "string", Value: "alice"}},
+			IdempotencyKey: "welcome_email_alice",
+		}
+	}
+
+	first, err := server.SendTask(newSignature())
+	assert.NoError(t, err)
+	second, err := server.SendTask(newSignature())
+	assert.NoError(t, err)
+
+	// The second task isn't sent, its result is the one of the first
+	assert.Equal(t, first.Signature.UUID, second.Signature.UUID)
+	message := broker.next()
+	assert.Nil(t, broker.next())
+
+	worker := server.NewWorker("test_worker", 1)
+	assert.NoError(t, worker.Process(message))
+	results, err := second.Touch()
+	if assert.NoError(t, err) && assert.Len(t, results, 1) {
+		assert.Equal(t, "message_alice", results[0].Interface())
+	}
+
+	// Delivered again, or sent by a server not checking keys, the task
+	// isn't run again
+	assert.NoError(t, worker.Process(message))
+	duplicate := newSignature()
+	duplicate.UUID = "task_duplicate"
+	assert.NoError(t, worker.Process(duplicate))
+	assert.Equal(t, 1, sent)
+
+	state, err := server.GetBackend().GetState(duplicate.UUID)
+	if assert.NoError(t, err) && assert.Len(t, state.Results, 1) {
+		assert.Equal(t, tasks.StateSuccess, state.State)
+		assert.Equal(t, "message_alice", state.Results[0].Value)
+	}
+}
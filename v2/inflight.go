@@ -0,0 +1,145 @@
+package machinery
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/RichardKnop/machinery/v2/log"
+
+	backendsiface "github.com/RichardKnop/machinery/v2/backends/iface"
+)
+
+// inFlightTTLIntervals is how many heartbeat intervals the report of a worker
+// outlives it, so a worker which died stops counting soon after
+const inFlightTTLIntervals = 3
+
+// inFlightHeartbeat counts the tasks a worker processes per task name and
+// reports the counts to the backend every interval, so the backend can sum
+// the counts of the fleet
+type inFlightHeartbeat struct {
+	workerID string
+	interval time.Duration
+	backend  backendsiface.InFlightBackend
+	logger   *log.Logger
+
+	mu     sync.Mutex
+	counts map[string]int
+
+	stopOnce sync.Once
+	stopChan chan struct{}
+	doneChan chan struct{}
+}
+
+// InFlight returns the approximate number of tasks in flight per task name
+// across the fleet, as last reported by workers with an in-flight heartbeat
+func (server *Server) InFlight() (map[string]int, error) {
+	inFlightBackend, ok := server.baseBackend().(backendsiface.InFlightBackend)
+	if !ok {
+		return nil, errors.New("Result backend does not support in-flight counts")
+	}
+	return inFlightBackend.InFlight()
+}
+
+// SetInFlightHeartbeat makes the worker report the number of tasks it is
+// processing per task name to the backend every interval, the backend sums
+// the reports of the fleet (see Server.InFlight). Zero turns reporting off.
+// Must be called before Launch.
+func (worker *Worker) SetInFlightHeartbeat(interval time.Duration) error {
+	if interval <= 0 {
+		worker.inFlight = nil
+		return nil
+	}
+
+	inFlightBackend, ok := worker.server.baseBackend().(backendsiface.InFlightBackend)
+	if !ok {
+		return errors.New("Result backend does not support in-flight counts")
+	}
+	worker.inFlight = &inFlightHeartbeat{
+		workerID: fmt.Sprintf("%s_%s", worker.ConsumerTag, uuid.New().String()),
+		interval: interval,
+		backend:  inFlightBackend,
+		logger:   worker.server.log(),
+		counts:   make(map[string]int),
+		stopChan: make(chan struct{}),
+	}
+	return nil
+}
+
+// start starts reporting
+func (h *inFlightHeartbeat) start() {
+	h.doneChan = make(chan struct{})
+	go h.run()
+}
+
+// run reports the counts every interval until stopped, then removes the
+// report
+func (h *inFlightHeartbeat) run() {
+	defer close(h.doneChan)
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	h.report()
+	for {
+		select {
+		case <-ticker.C:
+			h.report()
+		case <-h.stopChan:
+			if err := h.backend.ReportInFlight(h.workerID, nil, 0); err != nil {
+				h.logger.WARNING.Printf("Failed to remove in-flight report of worker %s: %s", h.workerID, err)
+			}
+			return
+		}
+	}
+}
+
+// stop stops reporting and waits for the report to be removed, if it was
+// started
+func (h *inFlightHeartbeat) stop() {
+	h.stopOnce.Do(func() {
+		close(h.stopChan)
+		if h.doneChan != nil {
+			<-h.doneChan
+		}
+	})
+}
+
+func (h *inFlightHeartbeat) report() {
+	h.mu.Lock()
+	counts := make(map[string]int, len(h.counts))
+	for name, count := range h.counts {
+		counts[name] = count
+	}
+	h.mu.Unlock()
+
+	if err := h.backend.ReportInFlight(h.workerID, counts, inFlightTTLIntervals*h.interval); err != nil {
+		h.logger.WARNING.Printf("Failed to report in-flight tasks of worker %s: %s", h.workerID, err)
+	}
+}
+
+func (h *inFlightHeartbeat) begin(name string) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[name]++
+}
+
+func (h *inFlightHeartbeat) end(name string) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[name]--
+	if h.counts[name] <= 0 {
+		delete(h.counts, name)
+	}
+}
@@ -0,0 +1,62 @@
+package machinery_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2"
+	"github.com/RichardKnop/machinery/v2/backends/memory"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestInFlightHeartbeat(t *testing.T) {
+	t.Parallel()
+
+	server, _ := newRecordingServer(t)
+	release := make(chan struct{})
+	err := server.RegisterTask("wait", func() error {
+		<-release
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// The eager backend can't sum reports of a fleet
+	worker := server.NewWorker("test_worker", 2)
+	assert.Error(t, worker.SetInFlightHeartbeat(time.Minute))
+	_, err = server.InFlight()
+	assert.Error(t, err)
+
+	server.SetBackend(memory.New(new(config.Config)))
+	workers := []*machinery.Worker{server.NewWorker("test_worker", 2), server.NewWorker("test_worker", 2)}
+	var wg sync.WaitGroup
+	for i, worker := range workers {
+		assert.NoError(t, worker.SetInFlightHeartbeat(time.Minute))
+		wg.Add(1)
+		go func(worker *machinery.Worker, signature *tasks.Signature) {
+			defer wg.Done()
+			assert.NoError(t, worker.Process(signature))
+		}(worker, &tasks.Signature{UUID: fmt.Sprintf("task_%d", i), Name: "wait"})
+	}
+
+	assert.Eventually(t, func() bool {
+		for _, worker := range workers {
+			worker.ReportInFlight()
+		}
+		inFlight, err := server.InFlight()
+		return err == nil && inFlight["wait"] == 2
+	}, time.Second, time.Millisecond)
+
+	close(release)
+	wg.Wait()
+	for _, worker := range workers {
+		worker.ReportInFlight()
+	}
+	inFlight, err := server.InFlight()
+	assert.NoError(t, err)
+	assert.Empty(t, inFlight)
+}
@@ -1,14 +1,22 @@
 package integration_test
 
 import (
+	"context"
 	"os"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
 
 	"github.com/RichardKnop/machinery/v2"
 	redisbackend "github.com/RichardKnop/machinery/v2/backends/redis"
+	brokersiface "github.com/RichardKnop/machinery/v2/brokers/iface"
 	redisbroker "github.com/RichardKnop/machinery/v2/brokers/redis"
 	"github.com/RichardKnop/machinery/v2/config"
 	eagerlock "github.com/RichardKnop/machinery/v2/locks/eager"
+	"github.com/RichardKnop/machinery/v2/tasks"
 )
 
 func TestRedisRedis_GoRedis(t *testing.T) {
@@ -43,3 +51,279 @@ func TestRedisRedis_GoRedis(t *testing.T) {
 	go worker.Launch()
 	testAll(server, t)
 }
+
+func TestRedisRedis_Protobuf(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		t.Skip("REDIS_URL is not defined")
+	}
+
+	cnf := &config.Config{
+		DefaultQueue:    "machinery_tasks_protobuf",
+		ResultsExpireIn: 3600,
+		WireFormat:      tasks.WireFormatProtobuf,
+		Redis: &config.RedisConfig{
+			MaxIdle:                3,
+			IdleTimeout:            240,
+			ReadTimeout:            15,
+			WriteTimeout:           15,
+			ConnectTimeout:         15,
+			NormalTasksPollPeriod:  1000,
+			DelayedTasksPollPeriod: 500,
+		},
+	}
+
+	broker := redisbroker.NewGR(cnf, []string{redisURL}, 0)
+	backend := redisbackend.NewGR(cnf, []string{redisURL}, 0)
+	lock := eagerlock.New()
+	server := machinery.NewServer(cnf, broker, backend, lock)
+
+	registerTestTasks(server)
+
+	worker := server.NewWorker("test_worker", 0)
+	defer worker.Quit()
+	go worker.Launch()
+	testAll(server, t)
+}
+
+func TestRedisRedis_QueueConcurrency(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		t.Skip("REDIS_URL is not defined")
+	}
+
+	cnf := &config.Config{
+		DefaultQueue:    "machinery_tasks_queue_concurrency",
+		ResultsExpireIn: 3600,
+		Redis: &config.RedisConfig{
+			NormalTasksPollPeriod:  100,
+			DelayedTasksPollPeriod: 100,
+		},
+	}
+
+	brokers := map[string]brokersiface.Broker{
+		"redigo":   redisbroker.New(cnf, redisURL, "", "", "", 0),
+		"go-redis": redisbroker.NewGR(cnf, []string{redisURL}, 0),
+	}
+	for name, broker := range brokers {
+		t.Run(name, func(t *testing.T) {
+			server := machinery.NewServer(cnf, broker, redisbackend.NewGR(cnf, []string{redisURL}, 0), eagerlock.New())
+			registerTestTasks(server)
+
+			worker := server.NewWorker("test_worker", 0)
+			worker.SetQueueConcurrency(map[string]int{
+				"":                          2,
+				"machinery_exports_" + name: 1,
+				"machinery_emails_" + name:  0,
+			})
+			defer worker.Quit()
+			go worker.Launch()
+
+			for _, routingKey := range []string{"", "machinery_exports_" + name, "machinery_emails_" + name} {
+				addTask := newAddTask(1, 2)
+				addTask.RoutingKey = routingKey
+				asyncResult, err := server.SendTask(addTask)
+				if !assert.NoError(t, err) {
+					continue
+				}
+				results, err := asyncResult.GetWithTimeout(10*time.Second, 5*time.Millisecond)
+				if assert.NoError(t, err, routingKey) && assert.Len(t, results, 1) {
+					assert.Equal(t, int64(3), results[0].Interface())
+				}
+			}
+		})
+	}
+}
+
+// recordingProcessor records the tasks it processes
+type recordingProcessor struct {
+	mu        sync.Mutex
+	processed []string
+}
+
+func (p *recordingProcessor) Process(signature *tasks.Signature) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.processed = append(p.processed, signature.UUID)
+	return nil
+}
+
+func (p *recordingProcessor) CustomQueue() string     { return "" }
+func (p *recordingProcessor) PreConsumeHandler() bool { return true }
+
+func TestRedisRedis_QueueGroupError(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		t.Skip("REDIS_URL is not defined")
+	}
+
+	cnf := &config.Config{
+		DefaultQueue:    "machinery_tasks_queue_group_error",
+		ResultsExpireIn: 3600,
+		Redis: &config.RedisConfig{
+			NormalTasksPollPeriod:  100,
+			DelayedTasksPollPeriod: 100,
+		},
+	}
+	client := redis.NewClient(&redis.Options{Addr: redisURL})
+	defer client.Close()
+
+	brokers := map[string]brokersiface.Broker{
+		"redigo":   redisbroker.New(cnf, redisURL, "", "", "", 0),
+		"go-redis": redisbroker.NewGR(cnf, []string{redisURL}, 0),
+	}
+	for name, broker := range brokers {
+		t.Run(name, func(t *testing.T) {
+			broken, good := "machinery_broken_"+name, "machinery_good_"+name
+			client.Del(context.Background(), broken, good)
+			defer client.Del(context.Background(), broken, good)
+			broker.SetRegisteredTaskNames([]string{"add"})
+
+			// A message which can't be decoded fails the group of its queue
+			assert.NoError(t, client.RPush(context.Background(), broken, "not a task").Err())
+
+			processor := new(recordingProcessor)
+			queues := []brokersiface.ConsumedQueue{{Name: broken, Concurrency: 1}, {Name: good}}
+			done := make(chan error, 1)
+			go func() {
+				_, err := broker.(brokersiface.MultiQueueBroker).StartConsumingQueues("test_worker", 2, queues, processor)
+				done <- err
+			}()
+			defer broker.StopConsuming()
+
+			// The other groups stop too and the error is returned
+			select {
+			case err := <-done:
+				assert.Error(t, err)
+			case <-time.After(5 * time.Second):
+				t.Fatal("consuming did not stop once a queue group failed")
+			}
+
+			// Tasks sent afterwards are left in the queue for other workers
+			addTask := newAddTask(1, 2)
+			addTask.RoutingKey = good
+			assert.NoError(t, broker.Publish(context.Background(), addTask))
+			time.Sleep(300 * time.Millisecond)
+			assert.Equal(t, int64(1), client.LLen(context.Background(), good).Val())
+			processor.mu.Lock()
+			assert.Empty(t, processor.processed)
+			processor.mu.Unlock()
+		})
+	}
+}
+
+func TestRedisRedis_QueueWeights(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		t.Skip("REDIS_URL is not defined")
+	}
+
+	cnf := &config.Config{
+		DefaultQueue:    "machinery_tasks_queue_weights",
+		ResultsExpireIn: 3600,
+		Redis: &config.RedisConfig{
+			NormalTasksPollPeriod:  100,
+			DelayedTasksPollPeriod: 100,
+		},
+	}
+
+	brokers := map[string]brokersiface.Broker{
+		"redigo":   redisbroker.New(cnf, redisURL, "", "", "", 0),
+		"go-redis": redisbroker.NewGR(cnf, []string{redisURL}, 0),
+	}
+	for name, broker := range brokers {
+		t.Run(name, func(t *testing.T) {
+			server := machinery.NewServer(cnf, broker, redisbackend.NewGR(cnf, []string{redisURL}, 0), eagerlock.New())
+			registerTestTasks(server)
+
+			worker := server.NewWorker("test_worker", 1)
+			worker.SetQueueWeights(map[string]int{
+				"machinery_critical_" + name: 10,
+				"machinery_low_" + name:      1,
+			})
+			defer worker.Quit()
+			go worker.Launch()
+
+			// The low priority queue still makes progress
+			for _, routingKey := range []string{"machinery_critical_" + name, "machinery_low_" + name} {
+				addTask := newAddTask(1, 2)
+				addTask.RoutingKey = routingKey
+				asyncResult, err := server.SendTask(addTask)
+				if !assert.NoError(t, err) {
+					continue
+				}
+				results, err := asyncResult.GetWithTimeout(10*time.Second, 5*time.Millisecond)
+				if assert.NoError(t, err, routingKey) && assert.Len(t, results, 1) {
+					assert.Equal(t, int64(3), results[0].Interface())
+				}
+			}
+		})
+	}
+}
+
+func TestRedisRedis_PrefetchCount(t *testing.T) {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		t.Skip("REDIS_URL is not defined")
+	}
+
+	newBrokers := map[string]func(cnf *config.Config) brokersiface.Broker{
+		"redigo": func(cnf *config.Config) brokersiface.Broker {
+			return redisbroker.New(cnf, redisURL, "", "", "", 0)
+		},
+		"go-redis": func(cnf *config.Config) brokersiface.Broker {
+			return redisbroker.NewGR(cnf, []string{redisURL}, 0)
+		},
+	}
+	for name, newBroker := range newBrokers {
+		t.Run(name, func(t *testing.T) {
+			cnf := &config.Config{
+				DefaultQueue:    "machinery_tasks_prefetch_" + name,
+				ResultsExpireIn: 3600,
+				Redis: &config.RedisConfig{
+					NormalTasksPollPeriod:  100,
+					DelayedTasksPollPeriod: 100,
+				},
+			}
+			broker := newBroker(cnf)
+			server := machinery.NewServer(cnf, broker, redisbackend.NewGR(cnf, []string{redisURL}, 0), eagerlock.New())
+			registerTestTasks(server)
+			release := make(chan struct{})
+			assert.NoError(t, server.RegisterTask("blocking", func() error {
+				<-release
+				return nil
+			}))
+
+			worker := server.NewWorker("test_worker", 1)
+			assert.NoError(t, worker.SetPrefetchCount(0))
+			defer worker.Quit()
+			go worker.Launch()
+
+			blocking, err := server.SendTask(&tasks.Signature{Name: "blocking"})
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Eventually(t, func() bool {
+				state, err := server.GetBackend().GetState(blocking.Signature.UUID)
+				return err == nil && state.State == tasks.StateStarted
+			}, 10*time.Second, 5*time.Millisecond)
+
+			// The busy worker leaves the next task in the queue
+			asyncResult, err := server.SendTask(newAddTask(1, 2))
+			if !assert.NoError(t, err) {
+				return
+			}
+			time.Sleep(300 * time.Millisecond)
+			depth, err := broker.(brokersiface.QueueDepthBroker).QueueDepth("")
+			if assert.NoError(t, err) {
+				assert.Equal(t, 1, depth)
+			}
+
+			close(release)
+			results, err := asyncResult.GetWithTimeout(10*time.Second, 5*time.Millisecond)
+			if assert.NoError(t, err) && assert.Len(t, results, 1) {
+				assert.Equal(t, int64(3), results[0].Interface())
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package machinery_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2"
+	"github.com/RichardKnop/machinery/v2/log"
+	"github.com/RichardKnop/machinery/v2/tasks"
+	"github.com/RichardKnop/machinery/v2/tracing"
+)
+
+// recordingLogger keeps the lines logged to it
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) record(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, line)
+}
+
+func (l *recordingLogger) logged(substr string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.lines {
+		if strings.Contains(line, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *recordingLogger) Print(v ...interface{}) { l.record(fmt.Sprint(v...)) }
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.record(fmt.Sprintf(format, v...))
+}
+func (l *recordingLogger) Println(v ...interface{}) { l.record(fmt.Sprint(v...)) }
+func (l *recordingLogger) Fatal(v ...interface{})   { l.record(fmt.Sprint(v...)) }
+func (l *recordingLogger) Fatalf(format string, v ...interface{}) {
+	l.record(fmt.Sprintf(format, v...))
+}
+func (l *recordingLogger) Fatalln(v ...interface{}) { l.record(fmt.Sprint(v...)) }
+func (l *recordingLogger) Panic(v ...interface{})   { l.record(fmt.Sprint(v...)) }
+func (l *recordingLogger) Panicf(format string, v ...interface{}) {
+	l.record(fmt.Sprintf(format, v...))
+}
+func (l *recordingLogger) Panicln(v ...interface{}) { l.record(fmt.Sprint(v...)) }
+
+func TestIsolatedServers(t *testing.T) {
+	t.Parallel()
+
+	tracerA, tracerB := mocktracer.New(), mocktracer.New()
+	loggerA, loggerB := new(recordingLogger), new(recordingLogger)
+
+	serverA, brokerA := newRecordingServer(t)
+	serverA.SetTracer(tracerA)
+	serverA.SetLogger(log.New(loggerA))
+	serverB, brokerB := newRecordingServer(t)
+	serverB.SetTracer(tracerB)
+	serverB.SetLogger(log.New(loggerB))
+
+	assert.Equal(t, tracerA, serverA.GetTracer())
+	assert.Equal(t, tracerB, serverB.GetTracer())
+
+	for _, server := range []*machinery.Server{serverA, serverB} {
+		err := server.RegisterTask("fail", func() error { return errors.New("boom") })
+		assert.NoError(t, err)
+	}
+
+	signatureA := &tasks.Signature{Name: "fail"}
+	_, err := serverA.SendTask(signatureA)
+	assert.NoError(t, err)
+	drain(t, serverA.NewWorker("worker_a", 0), brokerA)
+
+	signatureB := &tasks.Signature{Name: "fail"}
+	_, err = serverB.SendTask(signatureB)
+	assert.NoError(t, err)
+	drain(t, serverB.NewWorker("worker_b", 0), brokerB)
+
+	// Each server traces and logs its own tasks only
+	operations := func(tracer *mocktracer.MockTracer) []string {
+		var names []string
+		for _, span := range tracer.FinishedSpans() {
+			names = append(names, span.OperationName)
+		}
+		return names
+	}
+	assert.ElementsMatch(t, []string{"SendTask", "fail"}, operations(tracerA))
+	assert.ElementsMatch(t, []string{"SendTask", "fail"}, operations(tracerB))
+
+	assert.True(t, loggerA.logged(signatureA.UUID))
+	assert.False(t, loggerA.logged(signatureB.UUID))
+	assert.True(t, loggerB.logged(signatureB.UUID))
+	assert.False(t, loggerB.logged(signatureA.UUID))
+}
+
+func TestIsolatedBrokerAndBackendLoggers(t *testing.T) {
+	t.Parallel()
+
+	loggerA, loggerB := new(recordingLogger), new(recordingLogger)
+
+	serverA, brokerA := newRecordingServer(t)
+	serverA.SetLogger(log.New(loggerA))
+	serverB, brokerB := newRecordingServer(t)
+	serverB.SetLogger(log.New(loggerB))
+
+	brokerA.Logger().INFO.Print("broker a")
+	brokerB.Logger().INFO.Print("broker b")
+	backendA, ok := serverA.GetBackend().(interface{ Logger() *log.Logger })
+	assert.True(t, ok)
+	backendA.Logger().INFO.Print("backend a")
+
+	assert.True(t, loggerA.logged("broker a"))
+	assert.True(t, loggerA.logged("backend a"))
+	assert.False(t, loggerA.logged("broker b"))
+	assert.True(t, loggerB.logged("broker b"))
+	assert.False(t, loggerB.logged("broker a"))
+	assert.False(t, loggerB.logged("backend a"))
+
+	// The level of the server applies to its broker too
+	serverA.SetLogLevel(log.LevelError)
+	brokerA.Logger().INFO.Print("dropped")
+	assert.False(t, loggerA.logged("dropped"))
+}
+
+// mockPropagator reads and writes the trace headers of the mocktracer
+type mockPropagator struct{}
+
+func (mockPropagator) Extract(carrier opentracing.TextMapReader) (tracing.TraceContext, bool) {
+	return tracing.TraceContext{}, false
+}
+
+func (mockPropagator) Inject(traceContext tracing.TraceContext, carrier opentracing.TextMapWriter) {
+	carrier.Set("mockpfx-ids-traceid", strconv.FormatUint(binary.BigEndian.Uint64(traceContext.TraceID[8:]), 10))
+	carrier.Set("mockpfx-ids-spanid", strconv.FormatUint(binary.BigEndian.Uint64(traceContext.SpanID[:]), 10))
+	carrier.Set("mockpfx-ids-sampled", strconv.FormatBool(traceContext.Sampled))
+}
+
+func TestIsolatedPropagation(t *testing.T) {
+	t.Parallel()
+
+	tracerA, tracerB := mocktracer.New(), mocktracer.New()
+
+	serverA, _ := newRecordingServer(t)
+	serverA.SetTracer(tracerA)
+	serverA.SetPropagation(mockPropagator{}, tracing.Datadog)
+	serverB, _ := newRecordingServer(t)
+	serverB.SetTracer(tracerB)
+
+	datadog := func() *tasks.Signature {
+		return &tasks.Signature{
+			UUID: "task_" + uuid.New().String(),
+			Name: "noop",
+			Headers: tasks.Headers{
+				"x-datadog-trace-id":          "1234567890",
+				"x-datadog-parent-id":         "987654321",
+				"x-datadog-sampling-priority": "1",
+			},
+		}
+	}
+
+	for _, server := range []*machinery.Server{serverA, serverB} {
+		assert.NoError(t, server.RegisterTask("noop", func() error { return nil }))
+	}
+	assert.NoError(t, serverA.NewWorker("worker_a", 0).Process(datadog()))
+	assert.NoError(t, serverB.NewWorker("worker_b", 0).Process(datadog()))
+
+	// Only the server with the propagation continues the Datadog trace
+	spansA, spansB := tracerA.FinishedSpans(), tracerB.FinishedSpans()
+	if assert.Len(t, spansA, 1) {
+		assert.Equal(t, 1234567890, spansA[0].SpanContext.TraceID)
+		assert.Equal(t, 987654321, spansA[0].ParentID)
+	}
+	if assert.Len(t, spansB, 1) {
+		assert.Equal(t, 0, spansB[0].ParentID)
+	}
+}
@@ -0,0 +1,46 @@
+package machinery
+
+import (
+	"github.com/RichardKnop/machinery/v2/backends/events"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+// NewStateListener creates a worker which doesn't run tasks, but consumes
+// the state events published to the queue (see SetStateEvents) and passes
+// the task states to the handler, e.g. to build projections or send
+// notifications. It needs no registered tasks and calls the handler with up
+// to concurrency states at a time, so states of a task may be handled out
+// of order unless the concurrency is 1.
+func (server *Server) NewStateListener(consumerTag string, concurrency int, queue string, handler func(*tasks.TaskState)) *Worker {
+	return &Worker{
+		server:       server,
+		ConsumerTag:  consumerTag,
+		Concurrency:  concurrency,
+		Queue:        queue,
+		stateHandler: handler,
+	}
+}
+
+// processStateEvent passes the task state of the event to the handler, with
+// results decrypted and decompressed
+func (worker *Worker) processStateEvent(event *tasks.Signature) error {
+	taskState, err := events.DecodeEvent(event)
+	if err != nil {
+		worker.server.log().WARNING.Printf("Dropping task %s, listener %s only handles state events: %s", event.UUID, worker.ConsumerTag, err)
+		return nil
+	}
+
+	if decryptor := worker.server.decryptor(); decryptor != nil {
+		if taskState.Results, err = decryptor.DecryptResults(taskState.Results); err != nil {
+			worker.server.log().ERROR.Printf("Decrypt results of task %s error: %s", taskState.TaskUUID, err)
+			return nil
+		}
+	}
+	if taskState.Results, err = tasks.DecompressResults(taskState.Results); err != nil {
+		worker.server.log().ERROR.Printf("Decompress results of task %s error: %s", taskState.TaskUUID, err)
+		return nil
+	}
+
+	worker.stateHandler(taskState)
+	return nil
+}
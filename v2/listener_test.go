@@ -0,0 +1,69 @@
+package machinery_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestStateListener(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	server.SetStateEvents("machinery_events")
+	err := server.RegisterTask("add", func(a, b int64) (int64, error) { return a + b, nil })
+	assert.NoError(t, err)
+
+	var (
+		mu     sync.Mutex
+		states []*tasks.TaskState
+	)
+	listener := server.NewStateListener("test_listener", 1, "machinery_events", func(taskState *tasks.TaskState) {
+		mu.Lock()
+		defer mu.Unlock()
+		states = append(states, taskState)
+	})
+	assert.Equal(t, "machinery_events", listener.CustomQueue())
+
+	_, err = server.SendTask(&tasks.Signature{
+		Name: "add",
+		Args: []tasks.Arg{{Type: "int64", Value: 1}, {Type: "int64", Value: 2}},
+	})
+	assert.NoError(t, err)
+
+	worker := server.NewWorker("test_worker", 1)
+	for signature := broker.next(); signature != nil; signature = broker.next() {
+		if signature.Name == tasks.StateEventTaskName {
+			assert.NoError(t, listener.Process(signature))
+		} else {
+			assert.NoError(t, worker.Process(signature))
+		}
+	}
+
+	var names []string
+	for _, taskState := range states {
+		assert.Equal(t, "add", taskState.TaskName)
+		names = append(names, taskState.State)
+	}
+	assert.Equal(t, []string{tasks.StatePending, tasks.StateReceived, tasks.StateStarted, tasks.StateSuccess}, names)
+	if assert.Len(t, states, 4) && assert.Len(t, states[3].Results, 1) {
+		assert.EqualValues(t, 3, states[3].Results[0].Value)
+	}
+
+	// Listeners don't run tasks
+	assert.NoError(t, listener.Process(&tasks.Signature{Name: "add"}))
+	assert.Len(t, states, 4)
+
+	// Turning events off stops publishing them
+	server.SetStateEvents("")
+	_, err = server.SendTask(&tasks.Signature{Name: "add"})
+	assert.NoError(t, err)
+	signature := broker.next()
+	if assert.NotNil(t, signature) {
+		assert.Equal(t, "add", signature.Name)
+	}
+	assert.Nil(t, broker.next())
+}
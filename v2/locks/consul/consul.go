@@ -0,0 +1,134 @@
+package consul
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/RichardKnop/machinery/v2/config"
+)
+
+const (
+	// keyPrefix is the directory of all lock keys
+	keyPrefix = "machinery/locks/"
+
+	// minSessionTTL is the shortest session TTL Consul accepts
+	minSessionTTL = 10 * time.Second
+)
+
+var (
+	ErrConsulLockFailed = errors.New("consul lock: failed to acquire lock")
+)
+
+// Lock is held by a Consul session which acquired the lock's key. Sessions
+// are not renewed, Consul deletes the key once the session's TTL runs out.
+// Session TTLs are at least 10 seconds and Consul may take up to twice as
+// long to invalidate a session, so the key holds the time the lock is to be
+// released and a session holding the lock for longer is destroyed.
+type Lock struct {
+	client   *api.Client
+	retries  int
+	interval time.Duration
+}
+
+// New creates Lock instance talking to the Consul agent at address
+func New(cnf *config.Config, address string, retries int) (*Lock, error) {
+	clientConfig := api.DefaultConfig()
+	clientConfig.Address = address
+	if cnf.TLSConfig != nil {
+		clientConfig.Scheme = "https"
+		clientConfig.Transport.TLSClientConfig = cnf.TLSConfig
+	}
+
+	client, err := api.NewClient(clientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Lock{
+		client:   client,
+		retries:  retries,
+		interval: time.Second,
+	}, nil
+}
+
+func (l *Lock) LockWithRetries(key string, unixTsToExpireNs int64) error {
+	for i := 0; i <= l.retries; i++ {
+		err := l.Lock(key, unixTsToExpireNs)
+		if err == nil {
+			return nil
+		}
+
+		time.Sleep(l.interval)
+	}
+	return ErrConsulLockFailed
+}
+
+func (l *Lock) Lock(key string, unixTsToExpireNs int64) error {
+	now := time.Now().UnixNano()
+	ttl := time.Duration(unixTsToExpireNs - now).Round(time.Second)
+	if ttl < minSessionTTL {
+		ttl = minSessionTTL
+	}
+
+	sessionID, _, err := l.client.Session().Create(&api.SessionEntry{
+		Name:     "machinery lock " + key,
+		TTL:      ttl.String(),
+		Behavior: api.SessionBehaviorDelete,
+		// The key may be acquired again right after the session is gone
+		LockDelay: time.Millisecond,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	pair := &api.KVPair{
+		Key:     keyPrefix + key,
+		Value:   []byte(strconv.FormatInt(unixTsToExpireNs, 10)),
+		Session: sessionID,
+	}
+	acquired, _, err := l.client.KV().Acquire(pair, nil)
+	if err == nil && !acquired {
+		// The holder's session may outlive the lock, release it if so
+		var released bool
+		if released, err = l.releaseExpired(pair.Key, now); err == nil && released {
+			acquired, _, err = l.client.KV().Acquire(pair, nil)
+		}
+	}
+	if err != nil || !acquired {
+		l.client.Session().Destroy(sessionID, nil)
+		if err != nil {
+			return err
+		}
+		return ErrConsulLockFailed
+	}
+
+	return nil
+}
+
+// releaseExpired destroys the session holding the key if the lock was to be
+// released before now
+func (l *Lock) releaseExpired(key string, now int64) (bool, error) {
+	pair, _, err := l.client.KV().Get(key, nil)
+	if err != nil {
+		return false, err
+	}
+	if pair == nil || pair.Session == "" {
+		return true, nil
+	}
+
+	timeout, err := strconv.ParseInt(string(pair.Value), 10, 64)
+	if err != nil {
+		return false, err
+	}
+	if now <= timeout {
+		return false, nil
+	}
+
+	if _, err := l.client.Session().Destroy(pair.Session, nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,60 @@
+package consul_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/locks/consul"
+	lockiface "github.com/RichardKnop/machinery/v2/locks/iface"
+	"github.com/RichardKnop/machinery/v2/utils"
+)
+
+func getConsul(t *testing.T) *consul.Lock {
+	// host:port of a Consul agent
+	consulURL := os.Getenv("CONSUL_URL")
+	if consulURL == "" {
+		t.Skip()
+	}
+
+	lock, err := consul.New(new(config.Config), consulURL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lock
+}
+
+func TestNew(t *testing.T) {
+	lock := getConsul(t)
+	assert.Implements(t, (*lockiface.Lock)(nil), lock)
+}
+
+func TestLock(t *testing.T) {
+	lock := getConsul(t)
+	keyName := utils.GetPureUUID()
+
+	err := lock.Lock(keyName, time.Now().Add(25*time.Second).UnixNano())
+	assert.NoError(t, err)
+
+	err = lock.Lock(keyName, time.Now().Add(25*time.Second).UnixNano())
+	assert.Equal(t, consul.ErrConsulLockFailed, err)
+}
+
+func TestLockExpires(t *testing.T) {
+	lock := getConsul(t)
+	keyName := utils.GetPureUUID()
+
+	// The session outlives the lock, it is destroyed once the lock expired
+	err := lock.Lock(keyName, time.Now().Add(time.Second).UnixNano())
+	assert.NoError(t, err)
+
+	err = lock.Lock(keyName, time.Now().Add(25*time.Second).UnixNano())
+	assert.Equal(t, consul.ErrConsulLockFailed, err)
+
+	time.Sleep(1500 * time.Millisecond)
+	err = lock.Lock(keyName, time.Now().Add(25*time.Second).UnixNano())
+	assert.NoError(t, err)
+}
@@ -0,0 +1,143 @@
+//go:build !machinery_no_aws
+// +build !machinery_no_aws
+
+package dynamodb
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/log"
+)
+
+const (
+	// DefaultLocksTable is the locks table unless configured otherwise
+	DefaultLocksTable = "locks"
+	// DefaultTTLAttribute is the attribute DynamoDB TTL expires locks by
+	// unless configured otherwise
+	DefaultTTLAttribute = "TTL"
+)
+
+var (
+	ErrDynamoDBLockFailed = errors.New("dynamodb lock: failed to acquire lock")
+)
+
+// Lock is an item in the locks table, put only if there is none for its key
+// or the existing one expired. Items hold the time the lock is to be
+// released in ExpiresAt, in nanoseconds, and in the TTL attribute, in
+// seconds, so DynamoDB TTL deletes expired locks.
+type Lock struct {
+	cnf      *config.Config
+	client   dynamodbiface.DynamoDBAPI
+	retries  int
+	interval time.Duration
+}
+
+// New creates Lock instance, the locks table needs LockKey as its primary key
+func New(cnf *config.Config, retries int) *Lock {
+	lock := &Lock{
+		cnf:      cnf,
+		retries:  retries,
+		interval: time.Second,
+	}
+
+	if cnf.DynamoDB != nil && cnf.DynamoDB.Client != nil {
+		lock.client = cnf.DynamoDB.Client
+	} else {
+		sess := session.Must(session.NewSessionWithOptions(session.Options{
+			SharedConfigState: session.SharedConfigEnable,
+		}))
+		lock.client = dynamodb.New(sess)
+	}
+
+	if cnf.DynamoDB != nil && cnf.DynamoDB.EnableTTL {
+		if err := lock.enableTTL(); err != nil {
+			log.ERROR.Printf("Failed to enable TTL. Error: %v", err)
+		}
+	}
+	return lock
+}
+
+func (l *Lock) LockWithRetries(key string, unixTsToExpireNs int64) error {
+	for i := 0; i <= l.retries; i++ {
+		err := l.Lock(key, unixTsToExpireNs)
+		if err == nil {
+			return nil
+		}
+
+		time.Sleep(l.interval)
+	}
+	return ErrDynamoDBLockFailed
+}
+
+func (l *Lock) Lock(key string, unixTsToExpireNs int64) error {
+	// TTL is in seconds, rounded up so the item outlives the lock
+	ttl := (unixTsToExpireNs + int64(time.Second) - 1) / int64(time.Second)
+
+	_, err := l.client.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(l.locksTable()),
+		Item: map[string]*dynamodb.AttributeValue{
+			"LockKey":        {S: aws.String(key)},
+			"ExpiresAt":      {N: aws.String(strconv.FormatInt(unixTsToExpireNs, 10))},
+			l.ttlAttribute(): {N: aws.String(strconv.FormatInt(ttl, 10))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(LockKey) OR ExpiresAt < :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {N: aws.String(strconv.FormatInt(time.Now().UnixNano(), 10))},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return ErrDynamoDBLockFailed
+	}
+	return err
+}
+
+// locksTable returns the name of the locks table in the namespace
+func (l *Lock) locksTable() string {
+	table := DefaultLocksTable
+	if l.cnf.DynamoDB != nil && l.cnf.DynamoDB.LocksTable != "" {
+		table = l.cnf.DynamoDB.LocksTable
+	}
+	return l.cnf.Namespaced(table)
+}
+
+func (l *Lock) ttlAttribute() string {
+	if l.cnf.DynamoDB != nil && l.cnf.DynamoDB.TTLAttribute != "" {
+		return l.cnf.DynamoDB.TTLAttribute
+	}
+	return DefaultTTLAttribute
+}
+
+// enableTTL turns on TTL for the locks table unless it is on already
+func (l *Lock) enableTTL() error {
+	result, err := l.client.DescribeTimeToLive(&dynamodb.DescribeTimeToLiveInput{
+		TableName: aws.String(l.locksTable()),
+	})
+	if err != nil {
+		return err
+	}
+
+	if description := result.TimeToLiveDescription; description != nil {
+		switch aws.StringValue(description.TimeToLiveStatus) {
+		case dynamodb.TimeToLiveStatusEnabled, dynamodb.TimeToLiveStatusEnabling:
+			return nil
+		}
+	}
+
+	_, err = l.client.UpdateTimeToLive(&dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(l.locksTable()),
+		TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+			AttributeName: aws.String(l.ttlAttribute()),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	return err
+}
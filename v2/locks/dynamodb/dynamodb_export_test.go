@@ -0,0 +1,20 @@
+//go:build !machinery_no_aws
+// +build !machinery_no_aws
+
+package dynamodb
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+
+	"github.com/RichardKnop/machinery/v2/config"
+)
+
+func NewTestLock(cnf *config.Config, client dynamodbiface.DynamoDBAPI) *Lock {
+	return &Lock{
+		cnf:      cnf,
+		client:   client,
+		interval: time.Millisecond,
+	}
+}
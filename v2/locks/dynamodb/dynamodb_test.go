@@ -0,0 +1,89 @@
+//go:build !machinery_no_aws
+// +build !machinery_no_aws
+
+package dynamodb_test
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	awsdynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/locks/dynamodb"
+	lockiface "github.com/RichardKnop/machinery/v2/locks/iface"
+)
+
+// fakeDynamoDB keeps items in memory and evaluates the lock's condition
+type fakeDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	mu    sync.Mutex
+	items map[string]map[string]*awsdynamodb.AttributeValue
+	table string
+}
+
+func newFakeDynamoDB() *fakeDynamoDB {
+	return &fakeDynamoDB{items: make(map[string]map[string]*awsdynamodb.AttributeValue)}
+}
+
+func (f *fakeDynamoDB) PutItem(input *awsdynamodb.PutItemInput) (*awsdynamodb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.table = *input.TableName
+
+	key := *input.Item["LockKey"].S
+	if existing, ok := f.items[key]; ok {
+		expiresAt, _ := strconv.ParseInt(*existing["ExpiresAt"].N, 10, 64)
+		now, _ := strconv.ParseInt(*input.ExpressionAttributeValues[":now"].N, 10, 64)
+		if expiresAt >= now {
+			return nil, awserr.New(awsdynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+		}
+	}
+	f.items[key] = input.Item
+	return &awsdynamodb.PutItemOutput{}, nil
+}
+
+func TestNew(t *testing.T) {
+	lock := dynamodb.NewTestLock(new(config.Config), newFakeDynamoDB())
+	assert.Implements(t, (*lockiface.Lock)(nil), lock)
+}
+
+func TestLock(t *testing.T) {
+	t.Parallel()
+
+	client := newFakeDynamoDB()
+	lock := dynamodb.NewTestLock(&config.Config{Namespace: "staging"}, client)
+	expiresAt := time.Now().Add(25 * time.Second)
+
+	assert.NoError(t, lock.Lock("test_lock", expiresAt.UnixNano()))
+	assert.Equal(t, dynamodb.ErrDynamoDBLockFailed, lock.Lock("test_lock", expiresAt.UnixNano()))
+	assert.Equal(t, dynamodb.ErrDynamoDBLockFailed, lock.LockWithRetries("test_lock", expiresAt.UnixNano()))
+	assert.NoError(t, lock.Lock("other_lock", expiresAt.UnixNano()))
+
+	assert.Equal(t, "staging_locks", client.table)
+	item := client.items["test_lock"]
+	assert.Equal(t, strconv.FormatInt(expiresAt.UnixNano(), 10), aws.StringValue(item["ExpiresAt"].N))
+	// TTL is rounded up to whole seconds
+	assert.Equal(t, strconv.FormatInt(expiresAt.Unix()+1, 10), aws.StringValue(item["TTL"].N))
+}
+
+func TestLockExpires(t *testing.T) {
+	t.Parallel()
+
+	cnf := &config.Config{DynamoDB: &config.DynamoDBConfig{LocksTable: "custom_locks", TTLAttribute: "expires"}}
+	client := newFakeDynamoDB()
+	lock := dynamodb.NewTestLock(cnf, client)
+
+	assert.NoError(t, lock.Lock("test_lock", time.Now().Add(50*time.Millisecond).UnixNano()))
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, lock.Lock("test_lock", time.Now().Add(25*time.Second).UnixNano()))
+
+	assert.Equal(t, "custom_locks", client.table)
+	assert.NotNil(t, client.items["test_lock"]["expires"])
+}
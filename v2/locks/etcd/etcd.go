@@ -0,0 +1,140 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+
+	"github.com/RichardKnop/machinery/v2/config"
+
+	lockiface "github.com/RichardKnop/machinery/v2/locks/iface"
+)
+
+// keyPrefix is the directory of all lock keys
+const keyPrefix = "/machinery/locks/"
+
+var (
+	ErrEtcdLockFailed = errors.New("etcd lock: failed to acquire lock")
+)
+
+// Lock is held by an etcd session whose lease expires at the time the lock
+// is to be released. The lease is not kept alive, so etcd releases the lock
+// on its own even if the process holding it goes away. Leases of locks
+// acquired by LockWithLease are kept alive until they are unlocked.
+type Lock struct {
+	client   *clientv3.Client
+	retries  int
+	interval time.Duration
+}
+
+// New creates Lock instance
+func New(cnf *config.Config, endpoints []string, retries int) (*Lock, error) {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: 10 * time.Second,
+		TLS:         cnf.TLSConfig,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Lock{
+		client:   client,
+		retries:  retries,
+		interval: time.Second,
+	}, nil
+}
+
+func (l *Lock) LockWithRetries(key string, unixTsToExpireNs int64) error {
+	for i := 0; i <= l.retries; i++ {
+		err := l.Lock(key, unixTsToExpireNs)
+		if err == nil {
+			return nil
+		}
+
+		time.Sleep(l.interval)
+	}
+	return ErrEtcdLockFailed
+}
+
+func (l *Lock) Lock(key string, unixTsToExpireNs int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	session, _, err := l.lock(ctx, ctx, key, time.Duration(unixTsToExpireNs-time.Now().UnixNano()))
+	if err != nil {
+		return err
+	}
+
+	// Stop keeping the lease alive, it expires with the lock
+	session.Orphan()
+	return nil
+}
+
+// LockWithLease acquires the lock with a session whose lease etcd keeps
+// alive until it is unlocked
+func (l *Lock) LockWithLease(key string, ttl time.Duration) (lockiface.Lease, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	session, mutex, err := l.lock(context.Background(), ctx, key, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &lease{session: session, mutex: mutex}, nil
+}
+
+// lock acquires the lock with a new session whose lease has the ttl, the
+// session is kept alive until its context is done
+func (l *Lock) lock(sessionCtx, ctx context.Context, key string, ttl time.Duration) (*concurrency.Session, *concurrency.Mutex, error) {
+	// Lease TTLs are in whole seconds, the lock is rather held a bit longer
+	// than released too early
+	ttlSeconds := int(math.Ceil(ttl.Seconds()))
+	if ttlSeconds < 1 {
+		ttlSeconds = 1
+	}
+
+	session, err := concurrency.NewSession(l.client, concurrency.WithTTL(ttlSeconds), concurrency.WithContext(sessionCtx))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mutex := concurrency.NewMutex(session, keyPrefix+key)
+	if err := mutex.TryLock(ctx); err != nil {
+		// Revoke the lease right away instead of waiting for it to expire
+		session.Close()
+		if err == concurrency.ErrLocked {
+			return nil, nil, ErrEtcdLockFailed
+		}
+		return nil, nil, err
+	}
+	return session, mutex, nil
+}
+
+// lease is a lock held by a session which is kept alive
+type lease struct {
+	session *concurrency.Session
+	mutex   *concurrency.Mutex
+}
+
+// Unlock releases the lock and revokes the lease of the session
+func (l *lease) Unlock() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := l.mutex.Unlock(ctx)
+	if closeErr := l.session.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Lost is closed once the session's lease can't be kept alive, and once it
+// is unlocked
+func (l *lease) Lost() <-chan struct{} {
+	return l.session.Done()
+}
@@ -0,0 +1,80 @@
+package etcd_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/locks/etcd"
+	lockiface "github.com/RichardKnop/machinery/v2/locks/iface"
+	"github.com/RichardKnop/machinery/v2/utils"
+)
+
+func getEtcd(t *testing.T) *etcd.Lock {
+	// host1:port1,host2:port2
+	etcdURL := os.Getenv("ETCD_URL")
+	if etcdURL == "" {
+		t.Skip()
+	}
+
+	lock, err := etcd.New(new(config.Config), strings.Split(etcdURL, ","), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lock
+}
+
+func TestNew(t *testing.T) {
+	lock := getEtcd(t)
+	assert.Implements(t, (*lockiface.LeaseLock)(nil), lock)
+}
+
+func TestLock(t *testing.T) {
+	lock := getEtcd(t)
+	keyName := utils.GetPureUUID()
+
+	err := lock.Lock(keyName, time.Now().Add(25*time.Second).UnixNano())
+	assert.NoError(t, err)
+
+	err = lock.Lock(keyName, time.Now().Add(25*time.Second).UnixNano())
+	assert.Equal(t, etcd.ErrEtcdLockFailed, err)
+}
+
+func TestLockExpires(t *testing.T) {
+	lock := getEtcd(t)
+	keyName := utils.GetPureUUID()
+
+	err := lock.Lock(keyName, time.Now().Add(time.Second).UnixNano())
+	assert.NoError(t, err)
+
+	// The lease is not kept alive, etcd may round its TTL up
+	assert.Eventually(t, func() bool {
+		return lock.Lock(keyName, time.Now().Add(25*time.Second).UnixNano()) == nil
+	}, 10*time.Second, 500*time.Millisecond)
+}
+
+func TestLockWithLease(t *testing.T) {
+	lock := getEtcd(t)
+	keyName := utils.GetPureUUID()
+
+	lease, err := lock.LockWithLease(keyName, time.Second)
+	assert.NoError(t, err)
+
+	// Kept alive beyond its ttl
+	time.Sleep(3 * time.Second)
+	err = lock.Lock(keyName, time.Now().Add(25*time.Second).UnixNano())
+	assert.Equal(t, etcd.ErrEtcdLockFailed, err)
+	select {
+	case <-lease.Lost():
+		t.Error("Lease was lost")
+	default:
+	}
+
+	assert.NoError(t, lease.Unlock())
+	err = lock.Lock(keyName, time.Now().Add(25*time.Second).UnixNano())
+	assert.NoError(t, err)
+}
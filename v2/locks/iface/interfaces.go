@@ -1,5 +1,7 @@
 package iface
 
+import "time"
+
 type Lock interface {
 	//Acquire the lock with retry
 	//key: the name of the lock,
@@ -11,3 +13,22 @@ type Lock interface {
 	//value: at the nanosecond timestamp that lock needs to be released automatically
 	Lock(key string, value int64) error
 }
+
+// LeaseLock is implemented by locks able to hold a lock for as long as its
+// holder needs it, e.g. for the whole run of a long-running task
+type LeaseLock interface {
+	Lock
+
+	//Acquire the lock once for the ttl, the lease is renewed in the
+	//background until it is unlocked
+	LockWithLease(key string, ttl time.Duration) (Lease, error)
+}
+
+// Lease is a lock acquired with LockWithLease
+type Lease interface {
+	// Unlock stops renewing the lease and releases the lock
+	Unlock() error
+	// Lost is closed once renewing the lease failed, another holder may have
+	// acquired the lock since
+	Lost() <-chan struct{}
+}
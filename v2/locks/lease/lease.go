@@ -0,0 +1,71 @@
+package lease
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/RichardKnop/machinery/v2/log"
+)
+
+// ErrLeaseLost is returned by renew funcs if the lock is no longer held by
+// the lease
+var ErrLeaseLost = errors.New("lease: lock is no longer held")
+
+// Lease renews a lock in the background every third of its ttl until it is
+// unlocked, for locks which don't renew leases on their own
+type Lease struct {
+	renew   func() error
+	release func() error
+	stop    chan struct{}
+	lost    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// Start starts renewing the lock which was just acquired for the ttl. The
+// renew func extends the lock by the ttl, or returns ErrLeaseLost if it is
+// no longer held. The release func releases it.
+func Start(ttl time.Duration, renew func() error, release func() error) *Lease {
+	l := &Lease{
+		renew:   renew,
+		release: release,
+		stop:    make(chan struct{}),
+		lost:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go l.run(ttl / 3)
+	return l
+}
+
+func (l *Lease) run(interval time.Duration) {
+	defer close(l.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if err := l.renew(); err != nil {
+				log.ERROR.Printf("Failed to renew lock lease: %s", err)
+				close(l.lost)
+				return
+			}
+		}
+	}
+}
+
+// Unlock stops renewing the lease and releases the lock
+func (l *Lease) Unlock() error {
+	l.once.Do(func() { close(l.stop) })
+	<-l.done
+	return l.release()
+}
+
+// Lost is closed once renewing the lease failed
+func (l *Lease) Lost() <-chan struct{} {
+	return l.lost
+}
@@ -0,0 +1,60 @@
+package lease_test
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	lockiface "github.com/RichardKnop/machinery/v2/locks/iface"
+	"github.com/RichardKnop/machinery/v2/locks/lease"
+)
+
+func TestRenewUntilUnlocked(t *testing.T) {
+	t.Parallel()
+
+	var renewed, released int32
+	l := lease.Start(30*time.Millisecond, func() error {
+		atomic.AddInt32(&renewed, 1)
+		return nil
+	}, func() error {
+		atomic.AddInt32(&released, 1)
+		return nil
+	})
+	assert.Implements(t, (*lockiface.Lease)(nil), l)
+
+	time.Sleep(55 * time.Millisecond)
+	assert.NoError(t, l.Unlock())
+	assert.True(t, atomic.LoadInt32(&renewed) >= 2)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&released))
+
+	// Nothing is renewed once unlocked
+	renewedBefore := atomic.LoadInt32(&renewed)
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, renewedBefore, atomic.LoadInt32(&renewed))
+
+	select {
+	case <-l.Lost():
+		t.Error("Unlocked lease was lost")
+	default:
+	}
+}
+
+func TestLost(t *testing.T) {
+	t.Parallel()
+
+	l := lease.Start(30*time.Millisecond, func() error {
+		return lease.ErrLeaseLost
+	}, func() error {
+		return errors.New("not held")
+	})
+
+	select {
+	case <-l.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("Lease was not lost")
+	}
+	assert.Error(t, l.Unlock())
+}
@@ -0,0 +1,93 @@
+package memory
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	lockiface "github.com/RichardKnop/machinery/v2/locks/iface"
+	"github.com/RichardKnop/machinery/v2/locks/lease"
+)
+
+var (
+	ErrMemoryLockFailed = errors.New("memory lock: failed to acquire lock")
+)
+
+// Lock is held in memory, so it only excludes callers within the process.
+// It lets single-instance services register periodic tasks without a lock
+// store, unlike the eager lock it doesn't wait between retries.
+type Lock struct {
+	mu    sync.Mutex
+	locks map[string]held
+}
+
+// held is a lock held until it expires, leases hold it with their token
+type held struct {
+	expiresAt int64
+	token     string
+}
+
+// New creates Lock instance
+func New() *Lock {
+	return &Lock{locks: make(map[string]held)}
+}
+
+// LockWithRetries acquires the lock once, retrying within the process is
+// pointless as nothing but its own holder releases it
+func (l *Lock) LockWithRetries(key string, unixTsToExpireNs int64) error {
+	return l.Lock(key, unixTsToExpireNs)
+}
+
+func (l *Lock) Lock(key string, unixTsToExpireNs int64) error {
+	return l.acquire(key, held{expiresAt: unixTsToExpireNs})
+}
+
+// LockWithLease acquires the lock for the ttl and renews it until it is
+// unlocked
+func (l *Lock) LockWithLease(key string, ttl time.Duration) (lockiface.Lease, error) {
+	token := uuid.New().String()
+	if err := l.acquire(key, held{expiresAt: time.Now().Add(ttl).UnixNano(), token: token}); err != nil {
+		return nil, err
+	}
+
+	renew := func() error {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.locks[key].token != token {
+			return lease.ErrLeaseLost
+		}
+		l.locks[key] = held{expiresAt: time.Now().Add(ttl).UnixNano(), token: token}
+		return nil
+	}
+	release := func() error {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.locks[key].token != token {
+			return lease.ErrLeaseLost
+		}
+		delete(l.locks, key)
+		return nil
+	}
+	return lease.Start(ttl, renew, release), nil
+}
+
+func (l *Lock) acquire(key string, lock held) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	if current, ok := l.locks[key]; ok && now <= current.expiresAt {
+		return ErrMemoryLockFailed
+	}
+
+	// Forget expired locks, so keys of removed periodic tasks don't pile up
+	for lockKey, current := range l.locks {
+		if now > current.expiresAt {
+			delete(l.locks, lockKey)
+		}
+	}
+	l.locks[key] = lock
+	return nil
+}
@@ -0,0 +1,85 @@
+package memory_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	lockiface "github.com/RichardKnop/machinery/v2/locks/iface"
+	"github.com/RichardKnop/machinery/v2/locks/memory"
+	"github.com/RichardKnop/machinery/v2/utils"
+)
+
+func TestNew(t *testing.T) {
+	assert.Implements(t, (*lockiface.Lock)(nil), memory.New())
+	assert.Implements(t, (*lockiface.LeaseLock)(nil), memory.New())
+}
+
+func TestLock(t *testing.T) {
+	lock := memory.New()
+	keyName := utils.GetPureUUID()
+
+	err := lock.Lock(keyName, time.Now().Add(25*time.Second).UnixNano())
+	assert.NoError(t, err)
+
+	err = lock.LockWithRetries(keyName, time.Now().Add(25*time.Second).UnixNano())
+	assert.Equal(t, memory.ErrMemoryLockFailed, err)
+
+	err = lock.Lock(utils.GetPureUUID(), time.Now().Add(25*time.Second).UnixNano())
+	assert.NoError(t, err)
+}
+
+func TestLockExpires(t *testing.T) {
+	lock := memory.New()
+	keyName := utils.GetPureUUID()
+
+	err := lock.Lock(keyName, time.Now().Add(10*time.Millisecond).UnixNano())
+	assert.NoError(t, err)
+
+	time.Sleep(20 * time.Millisecond)
+	err = lock.Lock(keyName, time.Now().Add(25*time.Second).UnixNano())
+	assert.NoError(t, err)
+}
+
+func TestLockConcurrently(t *testing.T) {
+	lock := memory.New()
+	keyName := utils.GetPureUUID()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		acquired int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if lock.Lock(keyName, time.Now().Add(25*time.Second).UnixNano()) == nil {
+				mu.Lock()
+				acquired++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, acquired)
+}
+
+func TestLockWithLease(t *testing.T) {
+	lock := memory.New()
+	keyName := utils.GetPureUUID()
+
+	lease, err := lock.LockWithLease(keyName, 30*time.Millisecond)
+	assert.NoError(t, err)
+
+	// Renewed beyond its ttl
+	time.Sleep(100 * time.Millisecond)
+	err = lock.Lock(keyName, time.Now().Add(25*time.Second).UnixNano())
+	assert.Equal(t, memory.ErrMemoryLockFailed, err)
+
+	assert.NoError(t, lease.Unlock())
+	err = lock.Lock(keyName, time.Now().Add(25*time.Second).UnixNano())
+	assert.NoError(t, err)
+}
@@ -0,0 +1,91 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"hash/fnv"
+	"time"
+
+	"github.com/RichardKnop/machinery/v2/log"
+)
+
+var (
+	ErrPostgresLockFailed = errors.New("postgres lock: failed to acquire lock")
+)
+
+// Lock is a PostgreSQL session level advisory lock. The connection which
+// acquired it is taken out of the pool and holds the lock until the time it
+// is to be released, or until it is lost.
+type Lock struct {
+	db       *sql.DB
+	retries  int
+	interval time.Duration
+}
+
+// New creates Lock instance on the database, opened with any PostgreSQL
+// driver using $1 placeholders, e.g. github.com/lib/pq or pgx
+func New(db *sql.DB, retries int) *Lock {
+	return &Lock{
+		db:       db,
+		retries:  retries,
+		interval: time.Second,
+	}
+}
+
+func (l *Lock) LockWithRetries(key string, unixTsToExpireNs int64) error {
+	for i := 0; i <= l.retries; i++ {
+		err := l.Lock(key, unixTsToExpireNs)
+		if err == nil {
+			return nil
+		}
+
+		time.Sleep(l.interval)
+	}
+	return ErrPostgresLockFailed
+}
+
+func (l *Lock) Lock(key string, unixTsToExpireNs int64) error {
+	ctx := context.Background()
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	id := lockID(key)
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", id).Scan(&acquired); err != nil {
+		conn.Close()
+		return err
+	}
+	if !acquired {
+		conn.Close()
+		return ErrPostgresLockFailed
+	}
+
+	time.AfterFunc(time.Until(time.Unix(0, unixTsToExpireNs)), func() {
+		l.release(conn, key, id)
+	})
+	return nil
+}
+
+// release unlocks the lock and returns the connection to the pool, or
+// discards the connection if unlocking fails so the lock goes with it
+func (l *Lock) release(conn *sql.Conn, key string, id int64) {
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", id); err != nil {
+		log.WARNING.Printf("Failed to release postgres lock %s, closing its connection: %s", key, err)
+		conn.Raw(func(interface{}) error {
+			return driver.ErrBadConn
+		})
+	}
+}
+
+// lockID maps the lock name to the 64-bit key of an advisory lock
+func lockID(key string) int64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	return int64(hash.Sum64())
+}
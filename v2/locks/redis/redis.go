@@ -10,12 +10,24 @@ import (
 	"github.com/redis/go-redis/v9"
 
 	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/locks/lease"
+
+	lockiface "github.com/RichardKnop/machinery/v2/locks/iface"
 )
 
 var (
 	ErrRedisLockFailed = errors.New("redis lock: failed to acquire lock")
 )
 
+// renewScript extends the lock by the ttl if it still has the value it was
+// last set to by its lease
+var renewScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("set", KEYS[1], ARGV[2], "px", ARGV[3])
+end
+return false
+`)
+
 type Lock struct {
 	rclient  redis.UniversalClient
 	retries  int
@@ -117,3 +129,42 @@ func (r Lock) Lock(key string, unixTsToExpireNs int64) error {
 
 	return nil
 }
+
+// LockWithLease acquires the lock for the ttl and renews it until it is
+// unlocked. Like locks acquired by Lock its value is the time it expires.
+func (r Lock) LockWithLease(key string, ttl time.Duration) (lockiface.Lease, error) {
+	ctx := context.Background()
+	value := strconv.FormatInt(time.Now().Add(ttl).UnixNano(), 10)
+
+	success, err := r.rclient.SetNX(ctx, key, value, ttl).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !success {
+		return nil, ErrRedisLockFailed
+	}
+
+	renew := func() error {
+		next := strconv.FormatInt(time.Now().Add(ttl).UnixNano(), 10)
+		err := renewScript.Run(ctx, r.rclient, []string{key}, value, next, ttl.Milliseconds()).Err()
+		if err == redis.Nil {
+			return lease.ErrLeaseLost
+		}
+		if err != nil {
+			return err
+		}
+		value = next
+		return nil
+	}
+	release := func() error {
+		deleted, err := unlockScript.Run(ctx, r.rclient, []string{key}, value).Int()
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return lease.ErrLeaseLost
+		}
+		return nil
+	}
+	return lease.Start(ttl, renew, release), nil
+}
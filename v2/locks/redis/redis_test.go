@@ -0,0 +1,50 @@
+package redis_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/config"
+	lockiface "github.com/RichardKnop/machinery/v2/locks/iface"
+	"github.com/RichardKnop/machinery/v2/locks/redis"
+	"github.com/RichardKnop/machinery/v2/utils"
+)
+
+func getRedis(t *testing.T) redis.Lock {
+	// host:port
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		t.Skip()
+	}
+	return redis.New(new(config.Config), []string{redisURL}, 0, 1)
+}
+
+func TestNew(t *testing.T) {
+	lock := getRedis(t)
+	assert.Implements(t, (*lockiface.LeaseLock)(nil), lock)
+}
+
+func TestLockWithLease(t *testing.T) {
+	lock := getRedis(t)
+	keyName := utils.GetPureUUID()
+
+	lease, err := lock.LockWithLease(keyName, 300*time.Millisecond)
+	assert.NoError(t, err)
+
+	// Renewed beyond its ttl
+	time.Sleep(time.Second)
+	err = lock.Lock(keyName, time.Now().Add(25*time.Second).UnixNano())
+	assert.Equal(t, redis.ErrRedisLockFailed, err)
+	select {
+	case <-lease.Lost():
+		t.Error("Lease was lost")
+	default:
+	}
+
+	assert.NoError(t, lease.Unlock())
+	err = lock.Lock(keyName, time.Now().Add(25*time.Second).UnixNano())
+	assert.NoError(t, err)
+}
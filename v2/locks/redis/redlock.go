@@ -0,0 +1,109 @@
+package redis
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/log"
+)
+
+// redlockTimeout bounds a lock attempt on a single instance, so an instance
+// which is down doesn't eat up the validity of the lock
+const redlockTimeout = 50 * time.Millisecond
+
+// unlockScript deletes the lock only if it is still held with the token
+var unlockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`)
+
+// Redlock acquires the lock on a majority of independent Redis instances, so
+// a failover of a single instance doesn't let a second holder acquire it
+type Redlock struct {
+	clients  []*redis.Client
+	retries  int
+	interval time.Duration
+}
+
+// NewRedlock creates Redlock instance locking on every Redis instance at the
+// addresses, in the [password@]host:port format. The instances must not be
+// replicas of each other.
+func NewRedlock(cnf *config.Config, addrs []string, db, retries int) *Redlock {
+	lock := &Redlock{retries: retries, interval: time.Second}
+	for _, addr := range addrs {
+		var password string
+		if i := strings.LastIndex(addr, "@"); i >= 0 {
+			password, addr = addr[:i], addr[i+1:]
+		}
+		lock.clients = append(lock.clients, redis.NewClient(&redis.Options{
+			Addr:     addr,
+			DB:       db,
+			Password: password,
+		}))
+	}
+	return lock
+}
+
+func (r *Redlock) LockWithRetries(key string, unixTsToExpireNs int64) error {
+	for i := 0; i <= r.retries; i++ {
+		err := r.Lock(key, unixTsToExpireNs)
+		if err == nil {
+			return nil
+		}
+
+		if i < r.retries {
+			time.Sleep(r.interval)
+		}
+	}
+	return ErrRedisLockFailed
+}
+
+// Lock acquires the lock if a majority of the instances grant it before it
+// is about to expire, otherwise it is released on every instance again
+func (r *Redlock) Lock(key string, unixTsToExpireNs int64) error {
+	start := time.Now()
+	ttl := time.Duration(unixTsToExpireNs - start.UnixNano())
+	if ttl < time.Millisecond {
+		ttl = time.Millisecond
+	}
+	token := uuid.New().String()
+
+	var acquired int
+	for _, client := range r.clients {
+		ctx, cancel := context.WithTimeout(context.Background(), redlockTimeout)
+		ok, err := client.SetNX(ctx, key, token, ttl).Result()
+		cancel()
+		if err != nil {
+			log.WARNING.Printf("Redis lock on %s failed: %s", client.Options().Addr, err)
+			continue
+		}
+		if ok {
+			acquired++
+		}
+	}
+
+	// Allow for clock drift between the instances
+	drift := ttl/100 + 2*time.Millisecond
+	if acquired > len(r.clients)/2 && time.Since(start)+drift < ttl {
+		return nil
+	}
+
+	r.unlock(key, token)
+	return ErrRedisLockFailed
+}
+
+// unlock releases the lock held with the token on every instance
+func (r *Redlock) unlock(key, token string) {
+	for _, client := range r.clients {
+		ctx, cancel := context.WithTimeout(context.Background(), redlockTimeout)
+		unlockScript.Run(ctx, client, []string{key}, token)
+		cancel()
+	}
+}
@@ -0,0 +1,67 @@
+package redis_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/config"
+	lockiface "github.com/RichardKnop/machinery/v2/locks/iface"
+	"github.com/RichardKnop/machinery/v2/locks/redis"
+	"github.com/RichardKnop/machinery/v2/utils"
+)
+
+func getRedlockAddrs(t *testing.T) []string {
+	// host1:port1,host2:port2,host3:port3, independent instances
+	redlockURLs := os.Getenv("REDLOCK_URLS")
+	if redlockURLs == "" {
+		t.Skip()
+	}
+	return strings.Split(redlockURLs, ",")
+}
+
+func TestNewRedlock(t *testing.T) {
+	lock := redis.NewRedlock(new(config.Config), []string{"localhost:6379"}, 0, 0)
+	assert.Implements(t, (*lockiface.Lock)(nil), lock)
+}
+
+func TestRedlock(t *testing.T) {
+	lock := redis.NewRedlock(new(config.Config), getRedlockAddrs(t), 0, 0)
+	keyName := utils.GetPureUUID()
+
+	err := lock.Lock(keyName, time.Now().Add(25*time.Second).UnixNano())
+	assert.NoError(t, err)
+
+	err = lock.Lock(keyName, time.Now().Add(25*time.Second).UnixNano())
+	assert.Equal(t, redis.ErrRedisLockFailed, err)
+}
+
+func TestRedlockExpires(t *testing.T) {
+	lock := redis.NewRedlock(new(config.Config), getRedlockAddrs(t), 0, 1)
+	keyName := utils.GetPureUUID()
+
+	err := lock.Lock(keyName, time.Now().Add(500*time.Millisecond).UnixNano())
+	assert.NoError(t, err)
+
+	err = lock.LockWithRetries(keyName, time.Now().Add(25*time.Second).UnixNano())
+	assert.NoError(t, err)
+}
+
+func TestRedlockQuorum(t *testing.T) {
+	addrs := getRedlockAddrs(t)
+	keyName := utils.GetPureUUID()
+
+	// A majority of instances down, nothing on them to connect to
+	down := []string{"127.0.0.1:1", "127.0.0.1:2"}
+	lock := redis.NewRedlock(new(config.Config), append(addrs[:1:1], down...), 0, 0)
+	err := lock.Lock(keyName, time.Now().Add(25*time.Second).UnixNano())
+	assert.Equal(t, redis.ErrRedisLockFailed, err)
+
+	// The failed attempt released the lock on the instance which granted it
+	lock = redis.NewRedlock(new(config.Config), addrs, 0, 0)
+	err = lock.Lock(keyName, time.Now().Add(25*time.Second).UnixNano())
+	assert.NoError(t, err)
+}
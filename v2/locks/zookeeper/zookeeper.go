@@ -0,0 +1,115 @@
+package zookeeper
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/log"
+)
+
+// rootPath is the parent znode of all lock znodes
+const rootPath = "/machinery/locks"
+
+var (
+	ErrZookeeperLockFailed = errors.New("zookeeper lock: failed to acquire lock")
+)
+
+// Lock is held by an ephemeral znode holding the time the lock is to be
+// released. ZooKeeper deletes the znode if the session of the process
+// holding the lock ends, otherwise the next process trying to acquire the
+// lock after that time deletes it.
+type Lock struct {
+	conn     *zk.Conn
+	retries  int
+	interval time.Duration
+}
+
+// New creates Lock instance connected to the ZooKeeper ensemble
+func New(cnf *config.Config, servers []string, retries int) (*Lock, error) {
+	conn, _, err := zk.Connect(servers, 10*time.Second, zk.WithLogger(log.INFO))
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the parent znodes of the locks
+	path := ""
+	for _, node := range []string{"machinery", "locks"} {
+		path += "/" + node
+		_, err := conn.Create(path, nil, 0, zk.WorldACL(zk.PermAll))
+		if err != nil && err != zk.ErrNodeExists {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return &Lock{
+		conn:     conn,
+		retries:  retries,
+		interval: time.Second,
+	}, nil
+}
+
+func (l *Lock) LockWithRetries(key string, unixTsToExpireNs int64) error {
+	for i := 0; i <= l.retries; i++ {
+		err := l.Lock(key, unixTsToExpireNs)
+		if err == nil {
+			return nil
+		}
+
+		time.Sleep(l.interval)
+	}
+	return ErrZookeeperLockFailed
+}
+
+func (l *Lock) Lock(key string, unixTsToExpireNs int64) error {
+	// Keys contain cron specs, slashes would nest znodes
+	path := rootPath + "/" + url.QueryEscape(key)
+	value := []byte(strconv.FormatInt(unixTsToExpireNs, 10))
+
+	err := l.create(path, value)
+	if err != ErrZookeeperLockFailed {
+		return err
+	}
+
+	data, stat, err := l.conn.Get(path)
+	if err == zk.ErrNoNode {
+		// Released in the meantime
+		return l.create(path, value)
+	}
+	if err != nil {
+		return err
+	}
+
+	timeout, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return err
+	}
+	if time.Now().UnixNano() <= timeout {
+		return ErrZookeeperLockFailed
+	}
+
+	// The lock expired, delete the znode unless another process did so and
+	// acquired the lock first
+	err = l.conn.Delete(path, stat.Version)
+	if err == zk.ErrBadVersion || err == zk.ErrNoNode {
+		return ErrZookeeperLockFailed
+	}
+	if err != nil {
+		return err
+	}
+	return l.create(path, value)
+}
+
+// create creates the ephemeral znode of a lock, it fails if it exists
+func (l *Lock) create(path string, value []byte) error {
+	_, err := l.conn.Create(path, value, zk.FlagEphemeral, zk.WorldACL(zk.PermAll))
+	if err == zk.ErrNodeExists {
+		return ErrZookeeperLockFailed
+	}
+	return err
+}
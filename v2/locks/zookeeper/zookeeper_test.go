@@ -0,0 +1,58 @@
+package zookeeper_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/config"
+	lockiface "github.com/RichardKnop/machinery/v2/locks/iface"
+	"github.com/RichardKnop/machinery/v2/locks/zookeeper"
+	"github.com/RichardKnop/machinery/v2/utils"
+)
+
+func getZookeeper(t *testing.T) *zookeeper.Lock {
+	// host1:port1,host2:port2
+	zookeeperURL := os.Getenv("ZOOKEEPER_URL")
+	if zookeeperURL == "" {
+		t.Skip()
+	}
+
+	lock, err := zookeeper.New(new(config.Config), strings.Split(zookeeperURL, ","), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lock
+}
+
+func TestNew(t *testing.T) {
+	lock := getZookeeper(t)
+	assert.Implements(t, (*lockiface.Lock)(nil), lock)
+}
+
+func TestLock(t *testing.T) {
+	lock := getZookeeper(t)
+	// Lock names end with a cron spec
+	keyName := utils.GetPureUUID() + "*/5 * * * *"
+
+	err := lock.Lock(keyName, time.Now().Add(25*time.Second).UnixNano())
+	assert.NoError(t, err)
+
+	err = lock.Lock(keyName, time.Now().Add(25*time.Second).UnixNano())
+	assert.Equal(t, zookeeper.ErrZookeeperLockFailed, err)
+}
+
+func TestLockExpires(t *testing.T) {
+	lock := getZookeeper(t)
+	keyName := utils.GetPureUUID()
+
+	err := lock.Lock(keyName, time.Now().Add(time.Second).UnixNano())
+	assert.NoError(t, err)
+
+	time.Sleep(1500 * time.Millisecond)
+	err = lock.Lock(keyName, time.Now().Add(25*time.Second).UnixNano())
+	assert.NoError(t, err)
+}
@@ -0,0 +1,107 @@
+package log
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/RichardKnop/logging"
+)
+
+// Level is the level of log lines, a logger filtered with Filter drops the
+// lines below its level
+type Level int32
+
+const (
+	// LevelDebug logs every line
+	LevelDebug Level = iota
+	// LevelInfo drops DEBUG lines
+	LevelInfo
+	// LevelWarning drops DEBUG and INFO lines
+	LevelWarning
+	// LevelError only logs ERROR and FATAL lines
+	LevelError
+	// LevelFatal only logs FATAL lines
+	LevelFatal
+)
+
+var levelNames = []string{"DEBUG", "INFO", "WARNING", "ERROR", "FATAL"}
+
+// String returns the name of the level, e.g. INFO
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelFatal {
+		return fmt.Sprintf("Level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level of the name, e.g. "info" or "WARNING"
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return LevelDebug, fmt.Errorf("Unknown log level %q", name)
+}
+
+// LevelVar is a level which can be switched while loggers filtered with it
+// log, its zero value is LevelDebug
+type LevelVar struct {
+	level int32
+}
+
+// Level returns the current level
+func (v *LevelVar) Level() Level {
+	return Level(atomic.LoadInt32(&v.level))
+}
+
+// Set switches the level
+func (v *LevelVar) Set(level Level) {
+	atomic.StoreInt32(&v.level, int32(level))
+}
+
+// Filter returns a Logger dropping the lines logged below the level, as it
+// is when the line is logged. FATAL lines and the Fatal and Panic methods
+// of every level are never dropped, as callers rely on them to stop.
+func Filter(logger *Logger, level *LevelVar) *Logger {
+	return &Logger{
+		DEBUG:   &filtered{LoggerInterface: logger.DEBUG, lineLevel: LevelDebug, level: level},
+		INFO:    &filtered{LoggerInterface: logger.INFO, lineLevel: LevelInfo, level: level},
+		WARNING: &filtered{LoggerInterface: logger.WARNING, lineLevel: LevelWarning, level: level},
+		ERROR:   &filtered{LoggerInterface: logger.ERROR, lineLevel: LevelError, level: level},
+		FATAL:   logger.FATAL,
+	}
+}
+
+// filtered drops the lines of a level below the current level
+type filtered struct {
+	logging.LoggerInterface
+	lineLevel Level
+	level     *LevelVar
+}
+
+func (f *filtered) enabled() bool {
+	return f.lineLevel >= f.level.Level()
+}
+
+// Print logs the arguments unless the level is above the line's
+func (f *filtered) Print(v ...interface{}) {
+	if f.enabled() {
+		f.LoggerInterface.Print(v...)
+	}
+}
+
+// Printf logs the formatted arguments unless the level is above the line's
+func (f *filtered) Printf(format string, v ...interface{}) {
+	if f.enabled() {
+		f.LoggerInterface.Printf(format, v...)
+	}
+}
+
+// Println logs the arguments unless the level is above the line's
+func (f *filtered) Println(v ...interface{}) {
+	if f.enabled() {
+		f.LoggerInterface.Println(v...)
+	}
+}
@@ -0,0 +1,57 @@
+package log_test
+
+import (
+	"bytes"
+	stdlog "log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/log"
+)
+
+func TestParseLevel(t *testing.T) {
+	t.Parallel()
+
+	level, err := log.ParseLevel("info")
+	assert.NoError(t, err)
+	assert.Equal(t, log.LevelInfo, level)
+	assert.Equal(t, "INFO", level.String())
+
+	level, err = log.ParseLevel("WARNING")
+	assert.NoError(t, err)
+	assert.Equal(t, log.LevelWarning, level)
+
+	_, err = log.ParseLevel("verbose")
+	assert.Error(t, err)
+}
+
+func TestFilter(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	var level log.LevelVar
+	logger := log.Filter(log.New(stdlog.New(&buf, "", 0)), &level)
+
+	logger.DEBUG.Print("debug line")
+	assert.Contains(t, buf.String(), "debug line")
+
+	// The level is read as lines are logged
+	level.Set(log.LevelWarning)
+	buf.Reset()
+	logger.DEBUG.Print("debug line")
+	logger.INFO.Printf("info %s", "line")
+	logger.WARNING.Println("warning line")
+	logger.ERROR.Print("error line")
+	assert.NotContains(t, buf.String(), "debug line")
+	assert.NotContains(t, buf.String(), "info line")
+	assert.Contains(t, buf.String(), "warning line")
+	assert.Contains(t, buf.String(), "error line")
+
+	level.Set(log.LevelFatal)
+	buf.Reset()
+	logger.ERROR.Print("error line")
+	logger.FATAL.Print("fatal line")
+	assert.NotContains(t, buf.String(), "error line")
+	assert.Contains(t, buf.String(), "fatal line")
+}
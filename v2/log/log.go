@@ -52,3 +52,23 @@ func SetError(l logging.LoggerInterface) {
 func SetFatal(l logging.LoggerInterface) {
 	FATAL = l
 }
+
+// Logger holds a logger per level, e.g. so servers in the same process log
+// apart, see machinery.Server.SetLogger
+type Logger struct {
+	DEBUG   logging.LoggerInterface
+	INFO    logging.LoggerInterface
+	WARNING logging.LoggerInterface
+	ERROR   logging.LoggerInterface
+	FATAL   logging.LoggerInterface
+}
+
+// New returns a Logger logging every level to l
+func New(l logging.LoggerInterface) *Logger {
+	return &Logger{DEBUG: l, INFO: l, WARNING: l, ERROR: l, FATAL: l}
+}
+
+// Default returns a Logger of the package level loggers as they are set now
+func Default() *Logger {
+	return &Logger{DEBUG: DEBUG, INFO: INFO, WARNING: WARNING, ERROR: ERROR, FATAL: FATAL}
+}
@@ -0,0 +1,25 @@
+package machinery
+
+import (
+	"os"
+	"os/signal"
+)
+
+// watchDebugLoggingSignals toggles DEBUG logging of the server on SIGUSR2,
+// once however many workers of the server run
+func (server *Server) watchDebugLoggingSignals() {
+	if len(debugLoggingSignals) == 0 {
+		return
+	}
+
+	server.debugToggle.watch.Do(func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, debugLoggingSignals...)
+		go func() {
+			for s := range sig {
+				level := server.toggleDebugLogging()
+				server.log().WARNING.Printf("Signal received: %v, logging at %s level", s, level)
+			}
+		}()
+	})
+}
@@ -0,0 +1,10 @@
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris)
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package machinery
+
+import "os"
+
+// debugLoggingSignals are not available on this platform, DEBUG logging is
+// only switched with Server.SetLogLevel
+var debugLoggingSignals []os.Signal
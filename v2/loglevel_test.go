@@ -0,0 +1,43 @@
+package machinery_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/log"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestSetLogLevel(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	logger := new(recordingLogger)
+	server.SetLogger(log.New(logger))
+	assert.Equal(t, log.LevelDebug, server.LogLevel())
+
+	err := server.RegisterTask("noop", func() error { return nil })
+	assert.NoError(t, err)
+
+	// Task results are logged at DEBUG level
+	first := &tasks.Signature{Name: "noop"}
+	_, err = server.SendTask(first)
+	assert.NoError(t, err)
+	drain(t, server.NewWorker("test_worker", 1), broker)
+	assert.True(t, logger.logged(first.UUID))
+
+	server.SetLogLevel(log.LevelWarning)
+	assert.Equal(t, log.LevelWarning, server.LogLevel())
+	second := &tasks.Signature{Name: "noop"}
+	_, err = server.SendTask(second)
+	assert.NoError(t, err)
+	drain(t, server.NewWorker("test_worker", 1), broker)
+	assert.False(t, logger.logged(second.UUID))
+
+	// Packages serving the server's tasks log through it
+	server.Logger().DEBUG.Print("gateway debug")
+	server.Logger().ERROR.Print("gateway error")
+	assert.False(t, logger.logged("gateway debug"))
+	assert.True(t, logger.logged("gateway error"))
+}
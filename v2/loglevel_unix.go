@@ -0,0 +1,12 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package machinery
+
+import (
+	"os"
+	"syscall"
+)
+
+// debugLoggingSignals toggle DEBUG logging of servers running workers
+var debugLoggingSignals = []os.Signal{syscall.SIGUSR2}
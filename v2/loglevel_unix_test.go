@@ -0,0 +1,33 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package machinery_test
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/log"
+)
+
+func TestToggleDebugLoggingWithSignal(t *testing.T) {
+	server, _ := newRecordingServer(t)
+	server.SetLogger(log.New(new(recordingLogger)))
+	server.SetLogLevel(log.LevelError)
+
+	// Workers of the same server don't toggle twice
+	server.WatchDebugLoggingSignals()
+	server.WatchDebugLoggingSignals()
+
+	toggle := func(expected log.Level) {
+		assert.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGUSR2))
+		assert.Eventually(t, func() bool {
+			return server.LogLevel() == expected
+		}, time.Second, time.Millisecond)
+	}
+	toggle(log.LevelDebug)
+	toggle(log.LevelError)
+}
@@ -0,0 +1,41 @@
+package machinery
+
+import (
+	"context"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+// TaskHandler runs a task with the context and returns its results
+type TaskHandler func(ctx context.Context, signature *tasks.Signature) ([]*tasks.TaskResult, error)
+
+// TaskMiddleware wraps the runs of tasks, e.g. to restore an auth context
+// from the headers of the task, record metrics or handle errors. It calls
+// next to run the task, it may change the context it passes on, and the
+// results or the error it returns.
+type TaskMiddleware func(next TaskHandler) TaskHandler
+
+// UseTaskMiddleware wraps every run of a registered task by the workers of
+// the server with the middleware. Middleware added first runs first. Must be
+// called before workers are launched.
+func (server *Server) UseTaskMiddleware(middleware ...TaskMiddleware) {
+	server.taskMiddleware = append(server.taskMiddleware, middleware...)
+}
+
+// withTaskMiddleware wraps the handler in the middleware of the server
+func (server *Server) withTaskMiddleware(handler TaskHandler) TaskHandler {
+	for i := len(server.taskMiddleware) - 1; i >= 0; i-- {
+		handler = server.taskMiddleware[i](handler)
+	}
+	return handler
+}
+
+// callWithMiddleware calls the task through the middleware of the server,
+// with the timeouts of the task applied to the call itself
+func (worker *Worker) callWithMiddleware(signature *tasks.Signature, task *tasks.Task, timeouts *TaskTimeouts) ([]*tasks.TaskResult, error) {
+	handler := worker.server.withTaskMiddleware(func(ctx context.Context, signature *tasks.Signature) ([]*tasks.TaskResult, error) {
+		task.Context = ctx
+		return worker.callWithTimeouts(signature, task, timeouts)
+	})
+	return handler(task.Context, signature)
+}
@@ -0,0 +1,72 @@
+package machinery_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+type userKey struct{}
+
+func TestTaskMiddleware(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	var user string
+	err := server.RegisterTasks(map[string]interface{}{
+		"whoami": func(ctx context.Context) (string, error) {
+			user, _ = ctx.Value(userKey{}).(string)
+			return user, nil
+		},
+		"fail": func() error { return errors.New("boom") },
+	})
+	assert.NoError(t, err)
+
+	var calls []string
+	trace := func(name string) machinery.TaskMiddleware {
+		return func(next machinery.TaskHandler) machinery.TaskHandler {
+			return func(ctx context.Context, signature *tasks.Signature) ([]*tasks.TaskResult, error) {
+				calls = append(calls, name+" "+signature.Name)
+				return next(ctx, signature)
+			}
+		}
+	}
+	restoreUser := func(next machinery.TaskHandler) machinery.TaskHandler {
+		return func(ctx context.Context, signature *tasks.Signature) ([]*tasks.TaskResult, error) {
+			user, _ := signature.Headers["user"].(string)
+			return next(context.WithValue(ctx, userKey{}, user), signature)
+		}
+	}
+	wrapErrors := func(next machinery.TaskHandler) machinery.TaskHandler {
+		return func(ctx context.Context, signature *tasks.Signature) ([]*tasks.TaskResult, error) {
+			results, err := next(ctx, signature)
+			if err != nil {
+				return nil, tasks.NewErrRetryTaskLater("wrapped: "+err.Error(), 0)
+			}
+			return results, nil
+		}
+	}
+	server.UseTaskMiddleware(trace("outer"), trace("inner"), restoreUser)
+	server.UseTaskMiddleware(wrapErrors)
+	worker := server.NewWorker("test_worker", 0)
+
+	// Middleware runs in the order it was added, and may change the context
+	signature := &tasks.Signature{UUID: "task_1", Name: "whoami", Headers: tasks.Headers{"user": "alice"}}
+	assert.NoError(t, worker.Process(signature))
+	assert.Equal(t, []string{"outer whoami", "inner whoami"}, calls)
+	assert.Equal(t, "alice", user)
+	state, err := server.GetBackend().GetState(signature.UUID)
+	if assert.NoError(t, err) && assert.Len(t, state.Results, 1) {
+		assert.Equal(t, "alice", state.Results[0].Value)
+	}
+
+	// Or the error
+	signature = &tasks.Signature{UUID: "task_2", Name: "fail"}
+	assert.NoError(t, worker.Process(signature))
+	assert.NotNil(t, broker.next(), "the task is retried later")
+}
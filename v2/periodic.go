@@ -0,0 +1,461 @@
+package machinery
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+
+	backendsiface "github.com/RichardKnop/machinery/v2/backends/iface"
+	brokersiface "github.com/RichardKnop/machinery/v2/brokers/iface"
+	lockiface "github.com/RichardKnop/machinery/v2/locks/iface"
+)
+
+const (
+	// singletonLeaseTTL is how long the lock of a singleton run outlives a
+	// worker which died while running it
+	singletonLeaseTTL = 30 * time.Second
+	// singletonWaitInterval is how often a singleton run waiting for the
+	// previous run checks whether it is done
+	singletonWaitInterval = 5 * time.Second
+	// maxCatchUpRuns caps how many missed runs CatchUpAll sends
+	maxCatchUpRuns = 100
+)
+
+// CatchUpPolicy controls what happens to runs of a periodic task or workflow
+// which were missed while no server was running its scheduler
+type CatchUpPolicy string
+
+const (
+	// CatchUpSkip skips them
+	CatchUpSkip CatchUpPolicy = ""
+	// CatchUpOnce sends a single run for all of them
+	CatchUpOnce CatchUpPolicy = "once"
+	// CatchUpAll sends every one of them, up to 100
+	CatchUpAll CatchUpPolicy = "all"
+)
+
+// periodicSpecParser parses the specs of periodic tasks and workflows.
+// Besides standard 5 field specs it takes a leading seconds field and
+// descriptors such as @hourly or @every 10m.
+var periodicSpecParser = cron.NewParser(
+	cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
+)
+
+// parsePeriodicSpec parses the spec of a periodic task or workflow. A
+// CRON_TZ= (or TZ=) prefix naming an IANA time zone, e.g.
+// "CRON_TZ=Europe/Prague 0 9 * * MON-FRI", runs it by the local time of the
+// zone, daylight saving time included, instead of the local time of the
+// server. Specs containing RRULE: are iCalendar recurrence rules.
+func parsePeriodicSpec(spec string) (cron.Schedule, error) {
+	if isRRuleSpec(spec) {
+		schedule, err := parseRRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		return schedule, nil
+	}
+	return periodicSpecParser.Parse(spec)
+}
+
+// PeriodicPolicy declares when runs of a periodic task or workflow are
+// skipped, so runs don't pile up while workers fall behind
+type PeriodicPolicy struct {
+	// MaxQueueDepth skips runs while more tasks than it wait in the queue
+	// of the (first) task of the run, zero disables the check
+	MaxQueueDepth int
+	// SkipIfRunning skips runs while the previous run sent by this server
+	// hasn't completed
+	SkipIfRunning bool
+	// Overlap, if set, makes each run of a periodic task (not workflow)
+	// hold a lock while it runs, and controls what happens to runs which
+	// are received while the previous run is still running. The server's
+	// lock must implement LeaseLock.
+	Overlap tasks.OverlapPolicy
+	// LockDuration is how long the lock which keeps other servers from
+	// sending the same run is held, it defaults to just before the next run.
+	// Set it when clocks of the servers drift apart by more than the time
+	// between runs.
+	LockDuration time.Duration
+	// CatchUp, if set, makes servers store when the periodic task or
+	// workflow last ran, and controls what happens to runs missed since
+	// when it is registered. The server's backend must implement
+	// ScheduleBackend, and the policy must be set before it is registered.
+	CatchUp CatchUpPolicy
+	// Jitter delays each run by a random duration up to it, so periodic
+	// tasks scheduled at the same times don't send their runs at once. It
+	// must be shorter than the time between runs, and the policy must be
+	// set before the periodic task or workflow is registered.
+	Jitter time.Duration
+}
+
+// jitteredSchedule delays the runs of a schedule by a random duration
+type jitteredSchedule struct {
+	schedule cron.Schedule
+	jitter   time.Duration
+
+	mu     sync.Mutex
+	random *rand.Rand
+}
+
+// newJitteredSchedule returns the schedule with its runs delayed by up to the
+// jitter
+func newJitteredSchedule(schedule cron.Schedule, jitter time.Duration) *jitteredSchedule {
+	return &jitteredSchedule{
+		schedule: schedule,
+		jitter:   jitter,
+		// Seeded here, servers started at the same time must not draw the
+		// same jitter
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Next returns the next run of the schedule after the time, delayed
+func (s *jitteredSchedule) Next(t time.Time) time.Time {
+	s.mu.Lock()
+	delay := time.Duration(s.random.Int63n(int64(s.jitter)))
+	s.mu.Unlock()
+	return s.schedule.Next(t).Add(delay)
+}
+
+// periodicRuns keeps policies of periodic tasks and workflows, and the tasks
+// whose completion completes their last run
+type periodicRuns struct {
+	mu       sync.Mutex
+	policies map[string]*PeriodicPolicy
+	last     map[string][]string
+}
+
+// SetPeriodicPolicy sets the policy of the periodic task or workflow
+// registered with the name, nil removes it
+func (server *Server) SetPeriodicPolicy(name string, policy *PeriodicPolicy) {
+	server.periodic.mu.Lock()
+	defer server.periodic.mu.Unlock()
+
+	if server.periodic.policies == nil {
+		server.periodic.policies = make(map[string]*PeriodicPolicy)
+	}
+	if policy == nil {
+		delete(server.periodic.policies, name)
+		return
+	}
+	server.periodic.policies[name] = policy
+}
+
+// skipPeriodic returns true if the run of the periodic task or workflow
+// starting with the signature should be skipped
+func (server *Server) skipPeriodic(name string, signature *tasks.Signature) bool {
+	server.periodic.mu.Lock()
+	policy := server.periodic.policies[name]
+	last := server.periodic.last[name]
+	server.periodic.mu.Unlock()
+
+	if policy == nil {
+		return false
+	}
+
+	if policy.SkipIfRunning {
+		for _, taskUUID := range last {
+			// A state which expired or can't be read doesn't hold runs back
+			taskState, err := server.backend.GetState(taskUUID)
+			if err == nil && !taskState.IsCompleted() {
+				server.log().WARNING.Printf("Skipping periodic task %s, its previous run is still in progress", name)
+				return true
+			}
+		}
+	}
+
+	if policy.MaxQueueDepth > 0 {
+		queue := server.queueOf(signature)
+		depth, err := server.queueDepth(queue)
+		if err != nil {
+			server.log().WARNING.Printf("Failed to get depth of queue %s for periodic task %s: %s", queue, name, err)
+			return false
+		}
+		if depth > policy.MaxQueueDepth {
+			server.log().WARNING.Printf("Skipping periodic task %s, %d tasks are waiting in queue %s", name, depth, queue)
+			return true
+		}
+	}
+
+	return false
+}
+
+// periodicPolicy returns the policy of the periodic task or workflow, nil if
+// it has none
+func (server *Server) periodicPolicy(name string) *PeriodicPolicy {
+	server.periodic.mu.Lock()
+	defer server.periodic.mu.Unlock()
+	return server.periodic.policies[name]
+}
+
+// schedulePeriodic catches up runs of the periodic task or workflow missed
+// since it last ran, and schedules its runs. send sends a run.
+func (server *Server) schedulePeriodic(spec, name string, schedule cron.Schedule, send func() error) {
+	server.catchUpPeriodic(spec, name, schedule, send)
+
+	// Locks and singletons keep following the schedule, a delayed run must
+	// not hold the lock of the next run
+	entrySchedule := schedule
+	if policy := server.periodicPolicy(name); policy != nil && policy.Jitter > 0 {
+		entrySchedule = newJitteredSchedule(schedule, policy.Jitter)
+	}
+	server.scheduler.Schedule(entrySchedule, cron.FuncJob(func() {
+		//get lock
+		if !server.lockPeriodic(name, spec, schedule) {
+			return
+		}
+		server.runPeriodic(name, send)
+	}))
+}
+
+// runPeriodic sends a run of the periodic task or workflow and stores when it
+// ran, it returns false if the run couldn't be sent
+func (server *Server) runPeriodic(name string, send func() error) bool {
+	if err := send(); err != nil {
+		server.log().ERROR.Printf("periodic task failed. task name is: %s. error is %s", name, err.Error())
+		return false
+	}
+	server.storeLastRun(name, time.Now())
+	return true
+}
+
+// storeLastRun stores when the periodic task or workflow last ran, if its
+// policy catches up missed runs
+func (server *Server) storeLastRun(name string, lastRun time.Time) {
+	policy := server.periodicPolicy(name)
+	if policy == nil || policy.CatchUp == CatchUpSkip {
+		return
+	}
+	scheduleBackend, ok := server.baseBackend().(backendsiface.ScheduleBackend)
+	if !ok {
+		return
+	}
+	if err := scheduleBackend.SetLastRun(name, lastRun); err != nil {
+		server.log().WARNING.Printf("Failed to store last run of periodic task %s: %s", name, err)
+	}
+}
+
+// catchUpPeriodic sends the runs of the periodic task or workflow which were
+// missed since it last ran, as its policy says
+func (server *Server) catchUpPeriodic(spec, name string, schedule cron.Schedule, send func() error) {
+	policy := server.periodicPolicy(name)
+	if policy == nil || policy.CatchUp == CatchUpSkip {
+		return
+	}
+	scheduleBackend, ok := server.baseBackend().(backendsiface.ScheduleBackend)
+	if !ok {
+		server.log().WARNING.Printf("Backend can't store last runs, missed runs of periodic task %s are not caught up", name)
+		return
+	}
+	lastRun, err := scheduleBackend.LastRun(name)
+	if err != nil {
+		server.log().WARNING.Printf("Failed to get last run of periodic task %s, missed runs are not caught up: %s", name, err)
+		return
+	}
+
+	now := time.Now()
+	if lastRun.IsZero() {
+		// Runs missed from now on are caught up even if it never runs
+		server.storeLastRun(name, now)
+		return
+	}
+	missed := 0
+	for next := schedule.Next(lastRun); !next.After(now) && missed < maxCatchUpRuns; next = schedule.Next(next) {
+		missed++
+	}
+	if missed == 0 {
+		return
+	}
+
+	// Servers registering it at once read the same last run, the one
+	// acquiring the lock of the last run catches up
+	lockName := server.lockName(name, spec) + "_catch_up_" + strconv.FormatInt(lastRun.UnixNano(), 10)
+	if err := server.lock.Lock(lockName, schedule.Next(now).UnixNano()-1); err != nil {
+		return
+	}
+
+	runs := missed
+	if policy.CatchUp == CatchUpOnce {
+		runs = 1
+	}
+	server.log().INFO.Printf("Periodic task %s missed %d runs since %s, sending %d of them", name, missed, lastRun, runs)
+	for i := 0; i < runs; i++ {
+		if !server.runPeriodic(name, send) {
+			return
+		}
+	}
+}
+
+// lockPeriodic acquires the lock keyed by the name and spec of the periodic
+// task or workflow, so of all servers registering it only one sends each run
+func (server *Server) lockPeriodic(name, spec string, schedule cron.Schedule) bool {
+	policy := server.periodicPolicy(name)
+
+	now := time.Now()
+	expiration := schedule.Next(now).UnixNano() - 1
+	if policy != nil && policy.LockDuration > 0 {
+		expiration = now.Add(policy.LockDuration).UnixNano()
+	}
+
+	if err := server.lock.LockWithRetries(server.lockName(name, spec), expiration); err != nil {
+		server.log().DEBUG.Printf("Periodic task %s is sent by another server: %s", name, err)
+		return false
+	}
+	return true
+}
+
+// singleton returns the mark which keeps the run of the periodic task from
+// overlapping other runs, if its policy says so
+func (server *Server) singleton(name string, schedule cron.Schedule) *tasks.Singleton {
+	policy := server.periodicPolicy(name)
+	if policy == nil || policy.Overlap == tasks.OverlapAllow {
+		return nil
+	}
+	return &tasks.Singleton{
+		Key:     server.GetConfig().Namespaced("machinery_singleton_" + name),
+		Overlap: policy.Overlap,
+		NextRun: schedule.Next(time.Now()),
+	}
+}
+
+// recordPeriodicRun remembers the tasks whose completion completes the run
+// of the periodic task or workflow which was just sent
+func (server *Server) recordPeriodicRun(name string, taskUUIDs ...string) {
+	server.periodic.mu.Lock()
+	defer server.periodic.mu.Unlock()
+
+	if server.periodic.last == nil {
+		server.periodic.last = make(map[string][]string)
+	}
+	server.periodic.last[name] = taskUUIDs
+}
+
+// queueDepth returns the number of tasks waiting in the queue, read one by
+// one if the broker can't count them
+func (server *Server) queueDepth(queue string) (int, error) {
+	if depthBroker, ok := server.broker.(brokersiface.QueueDepthBroker); ok {
+		return depthBroker.QueueDepth(queue)
+	}
+
+	pending, err := server.broker.GetPendingTasks(queue)
+	if err != nil {
+		return 0, err
+	}
+	return len(pending), nil
+}
+
+// lockSingleton acquires the lock of a singleton run. If the previous run
+// holds it, the run is dropped or sent again to wait as its overlap policy
+// says, and false is returned.
+func (worker *Worker) lockSingleton(signature *tasks.Signature) (lockiface.Lease, bool, error) {
+	singleton := signature.Singleton
+	leaseLock, ok := worker.server.lock.(lockiface.LeaseLock)
+	if !ok {
+		worker.server.log().WARNING.Printf("Runs of task %s may overlap, the lock doesn't support leases", signature.Name)
+		return nil, true, nil
+	}
+
+	lease, err := leaseLock.LockWithLease(singleton.Key, singletonLeaseTTL)
+	if err == nil {
+		if singleton.Overlap == tasks.OverlapReplace {
+			return worker.holdSingleton(signature, lease), true, nil
+		}
+		return lease, true, nil
+	}
+
+	if singleton.Overlap == tasks.OverlapReplace {
+		worker.replaceSingleton(signature)
+	}
+
+	wait := singleton.Overlap == tasks.OverlapQueue ||
+		(singleton.Overlap == tasks.OverlapLatest || singleton.Overlap == tasks.OverlapReplace) && time.Now().Before(singleton.NextRun)
+	if wait {
+		worker.server.log().INFO.Printf("Task %s waits for the previous run of task %s", signature.UUID, signature.Name)
+		eta := time.Now().UTC().Add(singletonWaitInterval)
+		signature.ETA = &eta
+		_, err := worker.server.SendTask(signature)
+		return nil, false, err
+	}
+
+	worker.server.log().WARNING.Printf("Skipping task %s, the previous run of task %s is still running", signature.UUID, signature.Name)
+	if err := worker.server.GetBackend().SetStateFailure(signature, tasks.ErrRunOverlaps.Error()); err != nil {
+		return nil, false, fmt.Errorf("Set state to 'failure' for task %s returned error: %s", signature.UUID, err)
+	}
+	return nil, false, nil
+}
+
+// singletonLease is the lease of a singleton run which other runs may
+// replace, unlocking it forgets the run holds the lock
+type singletonLease struct {
+	lockiface.Lease
+	release func()
+}
+
+// Unlock forgets the run holds the lock and releases the lock
+func (l *singletonLease) Unlock() error {
+	l.release()
+	return l.Lease.Unlock()
+}
+
+// holderKey is the key the UUID of the singleton run holding the lock is
+// stored under, so runs replacing it know which task to cancel
+func holderKey(singleton *tasks.Singleton) string {
+	return singleton.Key + "_holder"
+}
+
+// holdSingleton records that the run holds the lock of its singleton, the
+// record is removed along with the lock
+func (worker *Worker) holdSingleton(signature *tasks.Signature, lease lockiface.Lease) lockiface.Lease {
+	idempotencyBackend, ok := worker.server.baseBackend().(backendsiface.IdempotencyBackend)
+	if !ok {
+		worker.server.log().WARNING.Printf("Run %s of task %s can't be replaced, the result backend can't record it", signature.UUID, signature.Name)
+		return lease
+	}
+
+	// The lock is ours, a run recorded as holding it died without removing
+	// the record
+	key := holderKey(signature.Singleton)
+	if holder, err := idempotencyBackend.IdempotencyKeyOwner(key); err == nil && holder != "" && holder != signature.UUID {
+		idempotencyBackend.ReleaseIdempotencyKey(key, holder)
+	}
+	record := &tasks.Signature{UUID: signature.UUID, Name: signature.Name, IdempotencyKey: key, ResultsExpireIn: signature.ResultsExpireIn}
+	if _, err := idempotencyBackend.ClaimIdempotencyKey(record); err != nil {
+		worker.server.log().WARNING.Printf("Failed to record run %s of task %s, it can't be replaced: %s", signature.UUID, signature.Name, err)
+		return lease
+	}
+
+	return &singletonLease{Lease: lease, release: func() {
+		if err := idempotencyBackend.ReleaseIdempotencyKey(key, signature.UUID); err != nil {
+			worker.server.log().WARNING.Printf("Failed to forget run %s of task %s: %s", signature.UUID, signature.Name, err)
+		}
+	}}
+}
+
+// replaceSingleton cancels the run holding the lock of the singleton
+func (worker *Worker) replaceSingleton(signature *tasks.Signature) {
+	idempotencyBackend, ok := worker.server.baseBackend().(backendsiface.IdempotencyBackend)
+	if !ok {
+		return
+	}
+
+	holder, err := idempotencyBackend.IdempotencyKeyOwner(holderKey(signature.Singleton))
+	if err != nil {
+		worker.server.log().WARNING.Printf("Failed to find the previous run of task %s: %s", signature.Name, err)
+		return
+	}
+	if holder == "" || holder == signature.UUID {
+		return
+	}
+
+	if err := worker.server.CancelTask(holder); err != nil {
+		worker.server.log().WARNING.Printf("Failed to cancel the previous run %s of task %s: %s", holder, signature.Name, err)
+		return
+	}
+	worker.server.log().WARNING.Printf("Task %s replaces the previous run %s of task %s", signature.UUID, holder, signature.Name)
+}
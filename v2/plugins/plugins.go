@@ -0,0 +1,104 @@
+// Package plugins loads task handlers from Go plugins, so tasks can be
+// deployed without rebuilding the worker binary.
+//
+// A plugin is a main package built with -buildmode=plugin which exports its
+// tasks by name, either as a variable or as a function returning them:
+//
+//	var Tasks = map[string]interface{}{
+//		"add": func(a, b int64) (int64, error) { return a + b, nil },
+//	}
+//
+// Tasks follow the same rules as tasks registered with the server: they are
+// functions taking the supported argument types, optionally a
+// context.Context first, and returning an error last. The plugin has to be
+// built with the same Go version and versions of shared packages as the
+// worker.
+//
+// Only Go plugins are supported, the package doesn't load WASM modules.
+package plugins
+
+import (
+	"fmt"
+	"path/filepath"
+	"plugin"
+	"sort"
+
+	"github.com/RichardKnop/machinery/v2/log"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+// Symbol is the name plugins export their tasks as
+const Symbol = "Tasks"
+
+// Registry registers tasks, e.g. *machinery.Server
+type Registry interface {
+	RegisterTasks(namedTaskFuncs map[string]interface{}) error
+}
+
+// Open opens a Go plugin and returns the tasks it exports
+func Open(path string) (map[string]interface{}, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Open plugin error: %s", err)
+	}
+	symbol, err := p.Lookup(Symbol)
+	if err != nil {
+		return nil, fmt.Errorf("Plugin %s error: %s", path, err)
+	}
+
+	var namedTaskFuncs map[string]interface{}
+	switch exported := symbol.(type) {
+	case *map[string]interface{}:
+		namedTaskFuncs = *exported
+	case func() map[string]interface{}:
+		namedTaskFuncs = exported()
+	default:
+		return nil, fmt.Errorf("Plugin %s error: %s is a %T, not map[string]interface{}", path, Symbol, symbol)
+	}
+
+	for name, taskFunc := range namedTaskFuncs {
+		if err := tasks.ValidateTask(taskFunc); err != nil {
+			return nil, fmt.Errorf("Plugin %s error: task %s: %s", path, name, err)
+		}
+	}
+	return namedTaskFuncs, nil
+}
+
+// Load opens the Go plugins matching the pattern, e.g. "plugins/*.so", and
+// registers their tasks. Nothing is registered if a plugin fails to open or
+// two plugins export a task with the same name. It returns the names of the
+// registered tasks.
+func Load(registry Registry, pattern string) ([]string, error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	namedTaskFuncs := make(map[string]interface{})
+	origins := make(map[string]string)
+	for _, path := range paths {
+		pluginTasks, err := Open(path)
+		if err != nil {
+			return nil, err
+		}
+		for name, taskFunc := range pluginTasks {
+			if origin, ok := origins[name]; ok {
+				return nil, fmt.Errorf("Task %s is exported by plugins %s and %s", name, origin, path)
+			}
+			namedTaskFuncs[name] = taskFunc
+			origins[name] = path
+		}
+		log.INFO.Printf("Loaded %d tasks from plugin %s", len(pluginTasks), path)
+	}
+
+	if err := registry.RegisterTasks(namedTaskFuncs); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(namedTaskFuncs))
+	for name := range namedTaskFuncs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,55 @@
+package plugins_test
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/plugins"
+
+	backend "github.com/RichardKnop/machinery/v2/backends/eager"
+	broker "github.com/RichardKnop/machinery/v2/brokers/eager"
+	lock "github.com/RichardKnop/machinery/v2/locks/eager"
+)
+
+// buildPlugin builds the plugin in testdata/tasks into dir
+func buildPlugin(t *testing.T, dir string) string {
+	path := filepath.Join(dir, "tasks.so")
+	cmd := exec.Command("go", "build", "-buildmode=plugin", "-o", path, "./testdata/tasks")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("Go plugins are not supported: %s", output)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	buildPlugin(t, dir)
+
+	server := machinery.NewServer(new(config.Config), broker.New(), backend.New(), lock.New())
+	names, err := plugins.Load(server, filepath.Join(dir, "*.so"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"add", "greet"}, names)
+	assert.True(t, server.IsTaskRegistered("add"))
+	assert.True(t, server.IsTaskRegistered("greet"))
+
+	taskFunc, err := server.GetRegisteredTask("add")
+	if assert.NoError(t, err) {
+		sum, err := taskFunc.(func(int64, int64) (int64, error))(1, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), sum)
+	}
+}
+
+func TestOpenNotAPlugin(t *testing.T) {
+	t.Parallel()
+
+	_, err := plugins.Open(filepath.Join("testdata", "tasks", "main.go"))
+	assert.Error(t, err)
+}
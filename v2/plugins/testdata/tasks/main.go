@@ -0,0 +1,15 @@
+package main
+
+import "context"
+
+// Tasks are loaded by the plugins tests
+var Tasks = map[string]interface{}{
+	"add": func(a, b int64) (int64, error) {
+		return a + b, nil
+	},
+	"greet": func(ctx context.Context, name string) (string, error) {
+		return "Hello " + name, nil
+	},
+}
+
+func main() {}
@@ -0,0 +1,175 @@
+package machinery
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+	"github.com/RichardKnop/machinery/v2/tracing"
+)
+
+// defaultPreemptionInterval is how often the queue of high-priority tasks is
+// checked if the policy doesn't say
+const defaultPreemptionInterval = time.Second
+
+// PreemptionPolicy makes a worker cancel low-priority tasks it is running
+// while too many high-priority tasks wait, and send them back to the queue,
+// so the high-priority tasks get the worker's slots during bursts. Only
+// tasks whose function takes a context can be preempted, they must return
+// once it is cancelled. A preempted task which succeeds anyway is not sent
+// back.
+type PreemptionPolicy struct {
+	// Queue is the queue of the high-priority tasks, its tasks are never
+	// preempted
+	Queue string
+	// Threshold preempts tasks while more tasks than it wait in Queue
+	Threshold int
+	// MaxPriority is the highest priority of tasks which can be preempted
+	MaxPriority uint8
+	// Interval is how often the queue is checked, every second if zero
+	Interval time.Duration
+}
+
+// preemptible is a task being run which can be preempted
+type preemptible struct {
+	signature *tasks.Signature
+	started   time.Time
+	cancel    context.CancelFunc
+	preempted bool
+}
+
+// preemptor keeps the preemptible tasks a worker runs and preempts them as
+// its policy says
+type preemptor struct {
+	policy PreemptionPolicy
+	server *Server
+
+	mu      sync.Mutex
+	running map[*preemptible]struct{}
+
+	stopOnce sync.Once
+	stopChan chan struct{}
+}
+
+// SetPreemptionPolicy makes the worker preempt low-priority tasks as the
+// policy says, nil turns preemption off. Must be called before Launch.
+func (worker *Worker) SetPreemptionPolicy(policy *PreemptionPolicy) {
+	if policy == nil {
+		worker.preemptor = nil
+		return
+	}
+	worker.preemptor = &preemptor{
+		policy:   *policy,
+		server:   worker.server,
+		running:  make(map[*preemptible]struct{}),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// run checks the queue of high-priority tasks every interval until stopped
+func (p *preemptor) run() {
+	interval := p.policy.Interval
+	if interval <= 0 {
+		interval = defaultPreemptionInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.check()
+		case <-p.stopChan:
+			return
+		}
+	}
+}
+
+func (p *preemptor) stop() {
+	p.stopOnce.Do(func() { close(p.stopChan) })
+}
+
+// begin makes the task preemptible if it is eligible, the task must be
+// passed to end once it returns
+func (p *preemptor) begin(signature *tasks.Signature, task *tasks.Task) *preemptible {
+	if p == nil || !task.UseContext || signature.Priority > p.policy.MaxPriority ||
+		p.server.queueOf(signature) == p.policy.Queue {
+		return nil
+	}
+
+	running := &preemptible{signature: signature, started: time.Now()}
+	task.Context, running.cancel = context.WithCancel(task.Context)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.running[running] = struct{}{}
+	return running
+}
+
+// end forgets the task and returns true if it was preempted
+func (p *preemptor) end(running *preemptible) bool {
+	if running == nil {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.running, running)
+	running.cancel()
+	return running.preempted
+}
+
+// check preempts a task for every high-priority task waiting beyond the
+// threshold, lowest priority first and among those the latest started, as
+// it loses the least work
+func (p *preemptor) check() {
+	depth, err := p.server.queueDepth(p.policy.Queue)
+	if err != nil {
+		p.server.log().WARNING.Printf("Failed to get depth of queue %s for preemption: %s", p.policy.Queue, err)
+		return
+	}
+	if depth <= p.policy.Threshold {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	candidates := make([]*preemptible, 0, len(p.running))
+	for running := range p.running {
+		if !running.preempted {
+			candidates = append(candidates, running)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].signature.Priority != candidates[j].signature.Priority {
+			return candidates[i].signature.Priority < candidates[j].signature.Priority
+		}
+		return candidates[i].started.After(candidates[j].started)
+	})
+
+	for i := 0; i < depth-p.policy.Threshold && i < len(candidates); i++ {
+		p.server.log().WARNING.Printf("Preempting task %s, %d tasks are waiting in queue %s", candidates[i].signature.UUID, depth, p.policy.Queue)
+		candidates[i].preempted = true
+		candidates[i].cancel()
+	}
+}
+
+// requeuePreempted sends a preempted task back to the queue, it doesn't
+// count as a retry
+func (worker *Worker) requeuePreempted(span opentracing.Span, signature *tasks.Signature) error {
+	if err := worker.server.GetBackend().SetStateRetry(signature); err != nil {
+		return fmt.Errorf("Set state to 'retry' for task %s returned error: %s", signature.UUID, err)
+	}
+	tracing.LogStateTransition(span, tasks.StateRetry)
+
+	worker.server.log().WARNING.Printf("Task %s was preempted, sending it back to the queue", signature.UUID)
+
+	_, err := worker.server.SendTask(signature)
+	return err
+}
@@ -0,0 +1,83 @@
+package machinery_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2"
+	"github.com/RichardKnop/machinery/v2/backends/memory"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestPreemption(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	// Tasks run concurrently
+	server.SetBackend(memory.New(new(config.Config)))
+	err := server.RegisterTasks(map[string]interface{}{
+		"report": func(ctx context.Context) error {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(200 * time.Millisecond):
+				return nil
+			}
+		},
+		"checkout": func() error { return nil },
+	})
+	assert.NoError(t, err)
+
+	worker := server.NewWorker("test_worker", 2)
+	worker.SetPreemptionPolicy(&machinery.PreemptionPolicy{Queue: "checkout", MaxPriority: 1})
+
+	low := &tasks.Signature{UUID: "task_low", Name: "report", Priority: 1}
+	high := &tasks.Signature{UUID: "task_high", Name: "report", Priority: 2}
+	var wg sync.WaitGroup
+	for _, signature := range []*tasks.Signature{low, high} {
+		wg.Add(1)
+		go func(signature *tasks.Signature) {
+			defer wg.Done()
+			assert.NoError(t, worker.Process(signature))
+		}(signature)
+	}
+	assert.Eventually(t, func() bool {
+		for _, taskUUID := range []string{low.UUID, high.UUID} {
+			state, err := server.GetBackend().GetState(taskUUID)
+			if err != nil || state.State != tasks.StateStarted {
+				return false
+			}
+		}
+		return true
+	}, time.Second, time.Millisecond)
+
+	// Nothing waits, nothing is preempted
+	worker.CheckPreemption()
+
+	_, err = server.SendTask(&tasks.Signature{Name: "checkout", RoutingKey: "checkout"})
+	assert.NoError(t, err)
+	worker.CheckPreemption()
+	wg.Wait()
+
+	// The low priority task is sent back, the other one completed
+	state, err := server.GetBackend().GetState(low.UUID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, tasks.StatePending, state.State)
+	}
+	state, err = server.GetBackend().GetState(high.UUID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, tasks.StateSuccess, state.State)
+	}
+
+	var requeued []string
+	for signature := broker.next(); signature != nil; signature = broker.next() {
+		requeued = append(requeued, signature.UUID)
+	}
+	assert.Contains(t, requeued, low.UUID)
+	assert.NotContains(t, requeued, high.UUID)
+}
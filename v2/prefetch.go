@@ -0,0 +1,37 @@
+package machinery
+
+import (
+	"errors"
+
+	"github.com/RichardKnop/machinery/v2/brokers/iface"
+)
+
+// SetPrefetchCount makes the worker take at most count tasks from the queue
+// ahead of the ones it is running, whatever its Concurrency, e.g. 0 for slow
+// tasks so a busy worker doesn't hoard tasks idle workers could be running.
+// With AMQP the QoS prefetch count becomes the concurrency plus count,
+// overriding the configured one, with Redis count is the number of messages
+// popped ahead and with SQS the number of messages received ahead. The
+// broker takes the count of every worker consuming through it apart. A
+// negative count leaves the broker's default. Must be called before Launch.
+func (worker *Worker) SetPrefetchCount(count int) error {
+	if count < 0 {
+		worker.prefetchCount = nil
+		return nil
+	}
+
+	if prefetchBroker, ok := worker.server.GetBroker().(iface.PrefetchBroker); !ok || !prefetchBroker.SupportsPrefetch() {
+		return errors.New("Broker does not support setting the prefetch count")
+	}
+	worker.prefetchCount = &count
+	return nil
+}
+
+// PrefetchCount returns the prefetch count set by SetPrefetchCount, false if
+// none was set. Brokers ask it every time the worker starts consuming.
+func (worker *Worker) PrefetchCount() (int, bool) {
+	if worker.prefetchCount == nil {
+		return 0, false
+	}
+	return *worker.prefetchCount, true
+}
@@ -0,0 +1,68 @@
+package machinery_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2"
+	"github.com/RichardKnop/machinery/v2/brokers/iface"
+	"github.com/RichardKnop/machinery/v2/common"
+	"github.com/RichardKnop/machinery/v2/config"
+
+	backend "github.com/RichardKnop/machinery/v2/backends/eager"
+	lock "github.com/RichardKnop/machinery/v2/locks/eager"
+)
+
+// prefetchBroker records the prefetch count of the last consumer started
+type prefetchBroker struct {
+	recordingBroker
+	prefetchCount *int
+}
+
+func (b *prefetchBroker) SupportsPrefetch() bool {
+	return true
+}
+
+func (b *prefetchBroker) StartConsuming(consumerTag string, concurrency int, p iface.TaskProcessor) (bool, error) {
+	b.prefetchCount = nil
+	if count, ok := p.(iface.PrefetchProcessor).PrefetchCount(); ok {
+		b.prefetchCount = &count
+	}
+	return false, nil
+}
+
+func TestPrefetchCount(t *testing.T) {
+	t.Parallel()
+
+	cnf := &config.Config{DefaultQueue: "default", NoUnixSignals: true}
+
+	// Brokers unable to prefetch can't be set a prefetch count
+	server := machinery.NewServer(cnf, &recordingBroker{Broker: common.NewBroker(cnf)}, backend.New(), lock.New())
+	worker := server.NewWorker("test_worker", 4)
+	assert.Error(t, worker.SetPrefetchCount(0))
+	assert.NoError(t, worker.SetPrefetchCount(-1))
+
+	// The broker keeps its default without a prefetch count
+	broker := &prefetchBroker{recordingBroker: recordingBroker{Broker: common.NewBroker(cnf)}}
+	server = machinery.NewServer(cnf, broker, backend.New(), lock.New())
+	worker = server.NewWorker("test_worker", 4)
+	assert.NoError(t, worker.Launch())
+	assert.Nil(t, broker.prefetchCount)
+
+	assert.NoError(t, worker.SetPrefetchCount(0))
+	assert.NoError(t, worker.Launch())
+	if assert.NotNil(t, broker.prefetchCount) {
+		assert.Equal(t, 0, *broker.prefetchCount)
+	}
+
+	// Other workers sharing the broker keep their own prefetch count
+	other := server.NewWorker("other_worker", 4)
+	assert.NoError(t, other.Launch())
+	assert.Nil(t, broker.prefetchCount)
+
+	// A negative count restores the default of the broker
+	assert.NoError(t, worker.SetPrefetchCount(-1))
+	assert.NoError(t, worker.Launch())
+	assert.Nil(t, broker.prefetchCount)
+}
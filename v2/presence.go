@@ -0,0 +1,140 @@
+package machinery
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/RichardKnop/machinery/v2/log"
+	"github.com/RichardKnop/machinery/v2/tasks"
+
+	backendsiface "github.com/RichardKnop/machinery/v2/backends/iface"
+)
+
+// presenceTTLIntervals is how many heartbeat intervals the heartbeat of a
+// worker outlives it, so a worker which died disappears soon after
+const presenceTTLIntervals = 3
+
+// presenceHeartbeat writes the heartbeat of a worker to the backend every
+// interval, so the fleet can be listed
+type presenceHeartbeat struct {
+	workerID string
+	interval time.Duration
+	backend  backendsiface.PresenceBackend
+	logger   *log.Logger
+	// info returns the state of the worker to write
+	info func() *tasks.WorkerInfo
+
+	stopOnce sync.Once
+	stopChan chan struct{}
+	doneChan chan struct{}
+}
+
+// ListWorkers returns the workers of the fleet which are alive, as last
+// reported by workers with a heartbeat, see Worker.SetHeartbeat
+func (server *Server) ListWorkers() ([]*tasks.WorkerInfo, error) {
+	presenceBackend, ok := server.baseBackend().(backendsiface.PresenceBackend)
+	if !ok {
+		return nil, errors.New("Result backend does not support worker heartbeats")
+	}
+	return presenceBackend.Workers()
+}
+
+// SetHeartbeat makes the worker write a heartbeat with its hostname, queues,
+// concurrency and the tasks it is running to the backend every interval,
+// see Server.ListWorkers. The heartbeat is removed once the worker quits,
+// or expires after three intervals if it dies. Zero turns heartbeats off.
+// Must be called before Launch.
+func (worker *Worker) SetHeartbeat(interval time.Duration) error {
+	if interval <= 0 {
+		worker.presence = nil
+		return nil
+	}
+
+	presenceBackend, ok := worker.server.baseBackend().(backendsiface.PresenceBackend)
+	if !ok {
+		return errors.New("Result backend does not support worker heartbeats")
+	}
+	worker.presence = &presenceHeartbeat{
+		workerID: worker.id(),
+		interval: interval,
+		backend:  presenceBackend,
+		logger:   worker.server.log(),
+		info:     worker.info,
+		stopChan: make(chan struct{}),
+	}
+	return nil
+}
+
+// id returns the ID of the worker, unique unlike its consumer tag
+func (worker *Worker) id() string {
+	worker.idOnce.Do(func() {
+		worker.workerID = fmt.Sprintf("%s_%s", worker.ConsumerTag, uuid.New().String())
+	})
+	return worker.workerID
+}
+
+// info returns the current state of the worker
+func (worker *Worker) info() *tasks.WorkerInfo {
+	hostname, _ := os.Hostname()
+	return &tasks.WorkerInfo{
+		ID:          worker.id(),
+		ConsumerTag: worker.ConsumerTag,
+		Hostname:    hostname,
+		PID:         os.Getpid(),
+		Queues:      worker.queues(),
+		Concurrency: worker.concurrency(),
+		Paused:      worker.paused(),
+		Running:     worker.drain.snapshot(),
+		StartedAt:   worker.launchedAt,
+		HeartbeatAt: time.Now().UTC(),
+	}
+}
+
+// start starts writing heartbeats
+func (h *presenceHeartbeat) start() {
+	h.doneChan = make(chan struct{})
+	go h.run()
+}
+
+// run writes a heartbeat every interval until stopped, then removes it
+func (h *presenceHeartbeat) run() {
+	defer close(h.doneChan)
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	h.report()
+	for {
+		select {
+		case <-ticker.C:
+			h.report()
+		case <-h.stopChan:
+			if err := h.backend.ReportWorker(h.workerID, nil, 0); err != nil {
+				h.logger.WARNING.Printf("Failed to remove heartbeat of worker %s: %s", h.workerID, err)
+			}
+			return
+		}
+	}
+}
+
+// stop stops writing heartbeats and waits for the heartbeat to be removed,
+// if it was started
+func (h *presenceHeartbeat) stop() {
+	h.stopOnce.Do(func() {
+		close(h.stopChan)
+		if h.doneChan != nil {
+			<-h.doneChan
+		}
+	})
+}
+
+func (h *presenceHeartbeat) report() {
+	if err := h.backend.ReportWorker(h.workerID, h.info(), presenceTTLIntervals*h.interval); err != nil {
+		h.logger.WARNING.Printf("Failed to write heartbeat of worker %s: %s", h.workerID, err)
+	}
+}
@@ -0,0 +1,71 @@
+package machinery_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/backends/memory"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestHeartbeat(t *testing.T) {
+	t.Parallel()
+
+	server, _ := newRecordingServer(t)
+	release := make(chan struct{})
+	err := server.RegisterTask("wait", func() error {
+		<-release
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// The eager backend can't keep heartbeats
+	worker := server.NewCustomQueueWorker("test_worker", 2, "test_queue")
+	assert.Error(t, worker.SetHeartbeat(time.Minute))
+	_, err = server.ListWorkers()
+	assert.Error(t, err)
+
+	server.SetBackend(memory.New(new(config.Config)))
+	assert.NoError(t, worker.SetHeartbeat(time.Minute))
+	worker.StartHeartbeat()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, worker.Process(&tasks.Signature{UUID: "task_1", Name: "wait"}))
+	}()
+
+	var workers []*tasks.WorkerInfo
+	assert.Eventually(t, func() bool {
+		worker.ReportHeartbeat()
+		workers, err = server.ListWorkers()
+		return err == nil && len(workers) == 1 && len(workers[0].Running) == 1
+	}, time.Second, time.Millisecond)
+
+	hostname, _ := os.Hostname()
+	assert.Equal(t, "test_worker", workers[0].ConsumerTag)
+	assert.Equal(t, hostname, workers[0].Hostname)
+	assert.Equal(t, os.Getpid(), workers[0].PID)
+	assert.Equal(t, []string{"test_queue"}, workers[0].Queues)
+	assert.Equal(t, 2, workers[0].Concurrency)
+	assert.Equal(t, "task_1", workers[0].Running[0].UUID)
+	assert.Equal(t, "wait", workers[0].Running[0].Name)
+
+	close(release)
+	<-done
+	worker.ReportHeartbeat()
+	workers, err = server.ListWorkers()
+	if assert.NoError(t, err) && assert.Len(t, workers, 1) {
+		assert.Empty(t, workers[0].Running)
+	}
+
+	// Workers which quit are removed
+	worker.Quit()
+	workers, err = server.ListWorkers()
+	assert.NoError(t, err)
+	assert.Empty(t, workers)
+}
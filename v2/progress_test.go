@@ -0,0 +1,46 @@
+package machinery_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/backends/memory"
+	"github.com/RichardKnop/machinery/v2/backends/result"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestProgress(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	server.SetBackend(memory.New(new(config.Config)))
+
+	var asyncResult *result.AsyncResult
+	var reported *tasks.Progress
+	err := server.RegisterTask("import", func(ctx context.Context) error {
+		reporter := tasks.ProgressReporterFromContext(ctx)
+		if err := reporter.ReportProgress(60, "imported 600 of 1000 rows"); err != nil {
+			return err
+		}
+		progress, err := asyncResult.Progress()
+		reported = progress
+		return err
+	})
+	assert.NoError(t, err)
+
+	asyncResult, err = server.SendTask(&tasks.Signature{Name: "import"})
+	assert.NoError(t, err)
+	progress, err := asyncResult.Progress()
+	assert.NoError(t, err)
+	assert.Nil(t, progress)
+
+	worker := server.NewWorker("test_worker", 1)
+	assert.NoError(t, worker.Process(broker.next()))
+	if assert.NotNil(t, reported) {
+		assert.Equal(t, 60.0, reported.Percent)
+		assert.Equal(t, "imported 600 of 1000 rows", reported.Message)
+	}
+}
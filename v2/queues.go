@@ -0,0 +1,93 @@
+package machinery
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/RichardKnop/machinery/v2/brokers/iface"
+)
+
+// SetQueueConcurrency makes the worker consume the queues instead of its
+// Queue, each of them running at most its number of tasks at once, e.g. 20
+// for "default" and 2 for "heavy_exports", so slow tasks of a queue don't
+// take the slots of the others. Queues with a zero number share the
+// worker's Concurrency. Nil consumes Queue again. Needs a broker able to
+// consume several queues, Launch fails otherwise. Must be called before
+// Launch.
+func (worker *Worker) SetQueueConcurrency(queues map[string]int) {
+	worker.queueConcurrency = queues
+}
+
+// SetQueueWeights makes the worker consume the queues instead of its Queue,
+// popping from them by weight, e.g. 6 for "critical", 3 for "default" and 1
+// for "low", so the critical queue is drained first most of the time while
+// the others keep making progress. A zero weight counts as 1. Weights apply
+// to the queues sharing the worker's Concurrency, queues may also be given
+// a concurrency of their own by SetQueueConcurrency. Nil consumes Queue
+// again if no queue concurrency is set either. Needs a broker able to
+// consume several queues, Launch fails otherwise. Must be called before
+// Launch.
+func (worker *Worker) SetQueueWeights(queues map[string]int) {
+	worker.queueWeights = queues
+}
+
+// consumedQueues returns the queues the worker consumes along with their
+// concurrency and weight, sorted by name, or nil if it consumes its Queue
+// only
+func (worker *Worker) consumedQueues() []iface.ConsumedQueue {
+	if len(worker.queueConcurrency) == 0 && len(worker.queueWeights) == 0 {
+		return nil
+	}
+
+	names := make(map[string]struct{}, len(worker.queueConcurrency)+len(worker.queueWeights))
+	for name := range worker.queueConcurrency {
+		names[name] = struct{}{}
+	}
+	for name := range worker.queueWeights {
+		names[name] = struct{}{}
+	}
+
+	queues := make([]iface.ConsumedQueue, 0, len(names))
+	for name := range names {
+		queues = append(queues, iface.ConsumedQueue{
+			Name:        name,
+			Concurrency: worker.queueConcurrency[name],
+			Weight:      worker.queueWeights[name],
+		})
+	}
+	sort.Slice(queues, func(i, j int) bool { return queues[i].Name < queues[j].Name })
+	return queues
+}
+
+// startConsuming consumes the worker's queues until the broker stops
+func (worker *Worker) startConsuming(broker iface.Broker) (bool, error) {
+	queues := worker.consumedQueues()
+	if queues == nil {
+		return broker.StartConsuming(worker.ConsumerTag, worker.Concurrency, worker)
+	}
+
+	multiQueueBroker, ok := broker.(iface.MultiQueueBroker)
+	if !ok {
+		return false, errors.New("Broker does not support consuming several queues")
+	}
+	return multiQueueBroker.StartConsumingQueues(worker.ConsumerTag, worker.Concurrency, queues, worker)
+}
+
+// queues returns the names of the queues the worker consumes
+func (worker *Worker) queues() []string {
+	if queues := worker.consumedQueues(); queues != nil {
+		names := make([]string, len(queues))
+		for i, queue := range queues {
+			names[i] = queue.Name
+			if names[i] == "" {
+				names[i] = worker.server.GetConfig().DefaultQueue
+			}
+		}
+		return names
+	}
+
+	if worker.Queue != "" {
+		return []string{worker.Queue}
+	}
+	return []string{worker.server.GetConfig().DefaultQueue}
+}
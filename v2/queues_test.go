@@ -0,0 +1,77 @@
+package machinery_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2"
+	"github.com/RichardKnop/machinery/v2/brokers/iface"
+	"github.com/RichardKnop/machinery/v2/common"
+	"github.com/RichardKnop/machinery/v2/config"
+
+	backend "github.com/RichardKnop/machinery/v2/backends/eager"
+	lock "github.com/RichardKnop/machinery/v2/locks/eager"
+)
+
+// multiQueueBroker records the queues it is asked to consume
+type multiQueueBroker struct {
+	recordingBroker
+	concurrency int
+	queues      []iface.ConsumedQueue
+}
+
+func (b *multiQueueBroker) StartConsumingQueues(consumerTag string, concurrency int, queues []iface.ConsumedQueue, p iface.TaskProcessor) (bool, error) {
+	b.concurrency = concurrency
+	b.queues = queues
+	return false, nil
+}
+
+func TestQueueConcurrency(t *testing.T) {
+	t.Parallel()
+
+	cnf := &config.Config{DefaultQueue: "default", NoUnixSignals: true}
+	queues := map[string]int{"heavy_exports": 2, "default": 20, "emails": 0}
+
+	// Brokers consuming a single queue can't launch the worker
+	server := machinery.NewServer(cnf, &recordingBroker{Broker: common.NewBroker(cnf)}, backend.New(), lock.New())
+	worker := server.NewWorker("test_worker", 4)
+	worker.SetQueueConcurrency(queues)
+	assert.Error(t, worker.Launch())
+
+	broker := &multiQueueBroker{recordingBroker: recordingBroker{Broker: common.NewBroker(cnf)}}
+	server = machinery.NewServer(cnf, broker, backend.New(), lock.New())
+	worker = server.NewWorker("test_worker", 4)
+	worker.SetQueueConcurrency(queues)
+	assert.NoError(t, worker.Launch())
+	assert.Equal(t, 4, broker.concurrency)
+	assert.Equal(t, []iface.ConsumedQueue{
+		{Name: "default", Concurrency: 20},
+		{Name: "emails"},
+		{Name: "heavy_exports", Concurrency: 2},
+	}, broker.queues)
+
+	// Nil consumes the worker's queue again
+	broker.queues = nil
+	worker.SetQueueConcurrency(nil)
+	assert.NoError(t, worker.Launch())
+	assert.Nil(t, broker.queues)
+}
+
+func TestQueueWeights(t *testing.T) {
+	t.Parallel()
+
+	cnf := &config.Config{DefaultQueue: "default", NoUnixSignals: true}
+	broker := &multiQueueBroker{recordingBroker: recordingBroker{Broker: common.NewBroker(cnf)}}
+	server := machinery.NewServer(cnf, broker, backend.New(), lock.New())
+	worker := server.NewWorker("test_worker", 4)
+	worker.SetQueueWeights(map[string]int{"critical": 6, "default": 3, "low": 0})
+	worker.SetQueueConcurrency(map[string]int{"heavy_exports": 2, "default": 0})
+	assert.NoError(t, worker.Launch())
+	assert.Equal(t, []iface.ConsumedQueue{
+		{Name: "critical", Weight: 6},
+		{Name: "default", Weight: 3},
+		{Name: "heavy_exports", Concurrency: 2},
+		{Name: "low"},
+	}, broker.queues)
+}
@@ -0,0 +1,217 @@
+package machinery
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+
+	lockiface "github.com/RichardKnop/machinery/v2/locks/iface"
+)
+
+const (
+	defaultRateLimitInterval = time.Second
+
+	// rateLimitLockPrefix names the locks counting the runs of a task across
+	// workers
+	rateLimitLockPrefix = "machinery_rate_limit_"
+)
+
+// RateLimit caps how often a worker runs a task, e.g. to stay within the
+// quota of a third-party API. Runs take tokens from a bucket holding up to
+// Burst tokens, refilled with Limit tokens every Interval. A run finding the
+// bucket empty is sent back to the queue to run once a token is available,
+// without counting as a retry. Buckets are kept by each worker unless
+// Distributed is set, then Limit runs are let through every Interval across
+// all workers sharing the lock of the server.
+type RateLimit struct {
+	// Limit is how many runs are let through every Interval
+	Limit int
+	// Interval defaults to 1 second
+	Interval time.Duration
+	// Burst is how many runs may be let through at once, defaults to Limit.
+	// Distributed limits ignore it.
+	Burst int
+	// Distributed counts runs across workers, in fixed windows of Interval,
+	// with a lock per run
+	Distributed bool
+}
+
+// tokenBucket limits the runs of a task kept by the worker
+type tokenBucket struct {
+	mu     sync.Mutex
+	limit  RateLimit
+	tokens float64
+	filled time.Time
+}
+
+// lockWindow limits the runs of a task across workers, each run holds one of
+// Limit locks of the lock of the server until the window ends
+type lockWindow struct {
+	mu    sync.Mutex
+	limit RateLimit
+	lock  lockiface.Lock
+	name  string
+	start time.Time
+	// probe is the first lock of the window which isn't known to be held
+	probe int
+}
+
+// rateLimiter lets runs of a task through or returns when they may run
+type rateLimiter interface {
+	take(now time.Time) time.Time
+}
+
+// rateLimiters keeps the rate limiters of the tasks of a worker
+type rateLimiters struct {
+	mu       sync.Mutex
+	limits   map[string]*RateLimit
+	fallback *RateLimit
+	limiters map[string]rateLimiter
+	lock     lockiface.Lock
+	lockName func(name string) string
+}
+
+// SetRateLimit caps how often the worker runs the tasks with the names, each
+// of them on its own, of every task if no names are given. A nil limit
+// removes the caps. Must be called before Launch.
+func (worker *Worker) SetRateLimit(limit *RateLimit, names ...string) {
+	if worker.rateLimiters == nil {
+		worker.rateLimiters = &rateLimiters{
+			limits:   make(map[string]*RateLimit),
+			limiters: make(map[string]rateLimiter),
+			lock:     worker.server.lock,
+			lockName: func(name string) string {
+				return worker.server.GetConfig().Namespaced(rateLimitLockPrefix + name)
+			},
+		}
+	}
+
+	var normalized *RateLimit
+	if limit != nil {
+		normalized = limit.withDefaults()
+	}
+
+	r := worker.rateLimiters
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(names) == 0 {
+		r.fallback = normalized
+		r.limiters = make(map[string]rateLimiter)
+		return
+	}
+	for _, name := range names {
+		r.limits[name] = normalized
+		delete(r.limiters, name)
+	}
+}
+
+// withDefaults returns a copy of the limit with defaults for unset fields
+func (l *RateLimit) withDefaults() *RateLimit {
+	normalized := *l
+	if normalized.Limit <= 0 {
+		normalized.Limit = 1
+	}
+	if normalized.Interval <= 0 {
+		normalized.Interval = defaultRateLimitInterval
+	}
+	if normalized.Burst <= 0 {
+		normalized.Burst = normalized.Limit
+	}
+	return &normalized
+}
+
+// limiter returns the rate limiter of the task, nil if its runs aren't
+// limited
+func (r *rateLimiters) limiter(name string) rateLimiter {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if limiter, ok := r.limiters[name]; ok {
+		return limiter
+	}
+	limit, ok := r.limits[name]
+	if !ok {
+		limit = r.fallback
+	}
+	if limit == nil {
+		return nil
+	}
+
+	var limiter rateLimiter
+	if limit.Distributed && r.lock != nil {
+		limiter = &lockWindow{limit: *limit, lock: r.lock, name: r.lockName(name)}
+	} else {
+		limiter = &tokenBucket{limit: *limit, tokens: float64(limit.Burst)}
+	}
+	r.limiters[name] = limiter
+	return limiter
+}
+
+// take lets the run of the task through unless its rate limit is reached,
+// in which case it returns when the task may run again
+func (r *rateLimiters) take(name string, now time.Time) time.Time {
+	limiter := r.limiter(name)
+	if limiter == nil {
+		return time.Time{}
+	}
+	return limiter.take(now)
+}
+
+// take takes a token from the bucket, or returns when one is available
+func (b *tokenBucket) take(now time.Time) time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	perToken := b.limit.Interval / time.Duration(b.limit.Limit)
+	if !b.filled.IsZero() && perToken > 0 {
+		b.tokens += float64(now.Sub(b.filled)) / float64(perToken)
+		if b.tokens > float64(b.limit.Burst) {
+			b.tokens = float64(b.limit.Burst)
+		}
+	}
+	b.filled = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return time.Time{}
+	}
+	return now.Add(time.Duration((1 - b.tokens) * float64(perToken)))
+}
+
+// take acquires one of the locks of the current window, or returns when the
+// next window starts
+func (w *lockWindow) take(now time.Time) time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	start := now.Truncate(w.limit.Interval)
+	if !start.Equal(w.start) {
+		w.start, w.probe = start, 0
+	}
+	end := start.Add(w.limit.Interval)
+
+	// Locks are held until the window ends, those which failed once stay
+	// held, so probing starts after them
+	prefix := w.name + "_" + strconv.FormatInt(start.UnixNano(), 10) + "_"
+	for ; w.probe < w.limit.Limit; w.probe++ {
+		if err := w.lock.Lock(prefix+strconv.Itoa(w.probe), end.UnixNano()); err == nil {
+			w.probe++
+			return time.Time{}
+		}
+	}
+	return end
+}
+
+// rateLimited sends a task whose rate limit is reached back to the queue to
+// run at retryAt, it doesn't count as a retry
+func (worker *Worker) rateLimited(span opentracing.Span, signature *tasks.Signature, retryAt time.Time) error {
+	worker.server.log().INFO.Printf("Rate limit of task %s is reached, task %s is going to run in %.1f seconds", signature.Name, signature.UUID, time.Until(retryAt).Seconds())
+	return worker.sendBack(span, signature, retryAt)
+}
@@ -0,0 +1,101 @@
+package machinery_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestRateLimit(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	var calls int32
+	err := server.RegisterTasks(map[string]interface{}{
+		"call_api": func() error {
+			atomic.AddInt32(&calls, 1)
+			return nil
+		},
+		"resize_image": func() error { return nil },
+	})
+	assert.NoError(t, err)
+
+	worker := server.NewWorker("test_worker", 0)
+	worker.SetRateLimit(&machinery.RateLimit{Limit: 10, Interval: time.Second, Burst: 2}, "call_api")
+
+	// Runs are let through until the bucket is empty
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, worker.Process(&tasks.Signature{UUID: "task_api", Name: "call_api"}))
+	}
+	assert.Nil(t, broker.next())
+
+	// Then they are sent back to run once a token is available, without
+	// counting as a retry
+	signature := &tasks.Signature{UUID: "task_1", Name: "call_api", RetryCount: 3}
+	assert.NoError(t, worker.Process(signature))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	requeued := broker.next()
+	if assert.NotNil(t, requeued) {
+		assert.Equal(t, 3, requeued.RetryCount)
+		assert.WithinDuration(t, time.Now().Add(100*time.Millisecond), *requeued.ETA, 100*time.Millisecond)
+	}
+	state, err := server.GetBackend().GetState(signature.UUID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, tasks.StatePending, state.State)
+	}
+
+	// Only tasks with a limit are limited
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, worker.Process(&tasks.Signature{UUID: "task_resize", Name: "resize_image"}))
+	}
+	assert.Nil(t, broker.next())
+
+	// The bucket is refilled over time
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, worker.Process(&tasks.Signature{UUID: "task_2", Name: "call_api"}))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// Removing the limit lets every run through
+	worker.SetRateLimit(nil, "call_api")
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, worker.Process(&tasks.Signature{UUID: "task_api", Name: "call_api"}))
+	}
+	assert.Equal(t, int32(8), atomic.LoadInt32(&calls))
+	assert.Nil(t, broker.next())
+}
+
+func TestDistributedRateLimit(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	var calls int32
+	err := server.RegisterTask("call_api", func() error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// Workers sharing the lock share the limit
+	limit := &machinery.RateLimit{Limit: 2, Interval: time.Hour, Distributed: true}
+	workers := []*machinery.Worker{server.NewWorker("worker_1", 0), server.NewWorker("worker_2", 0)}
+	for _, worker := range workers {
+		worker.SetRateLimit(limit)
+	}
+
+	assert.NoError(t, workers[0].Process(&tasks.Signature{UUID: "task_1", Name: "call_api"}))
+	assert.NoError(t, workers[1].Process(&tasks.Signature{UUID: "task_2", Name: "call_api"}))
+	assert.NoError(t, workers[0].Process(&tasks.Signature{UUID: "task_3", Name: "call_api"}))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// Runs over the limit wait for the next window
+	requeued := broker.next()
+	if assert.NotNil(t, requeued) {
+		assert.Equal(t, "task_3", requeued.UUID)
+		assert.Equal(t, time.Now().Truncate(time.Hour).Add(time.Hour).UTC(), *requeued.ETA)
+	}
+}
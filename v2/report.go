@@ -0,0 +1,274 @@
+package machinery
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RichardKnop/machinery/v2/log"
+)
+
+// durationSamples caps how many task durations are kept per task name and
+// interval to estimate the 95th percentile
+const durationSamples = 1024
+
+// RunReport summarises the tasks a worker processed during an interval
+type RunReport struct {
+	ConsumerTag string
+	Start       time.Time
+	End         time.Time
+	// InFlight is the number of tasks being processed when the report was made
+	InFlight int
+	// DeadLetters is the approximate number of messages in the dead-letter
+	// queue of the worker's queue when the report was made, if the broker
+	// has one
+	DeadLetters int
+	// FleetInFlight is the approximate number of tasks in flight per task
+	// name across the fleet, if the backend sums in-flight heartbeats
+	FleetInFlight map[string]int
+	// Tasks holds a summary per task name
+	Tasks map[string]*TaskRunReport
+}
+
+// TaskRunReport summarises processed tasks with the same name
+type TaskRunReport struct {
+	Processed   int
+	Succeeded   int
+	Failed      int
+	Retried     int
+	P95Duration time.Duration
+	// Redelivered counts tasks the broker delivered more than once, e.g.
+	// redriven from a dead-letter queue
+	Redelivered int
+}
+
+// SuccessRate returns the share of processed tasks which succeeded
+func (r *TaskRunReport) SuccessRate() float64 {
+	if r.Processed == 0 {
+		return 0
+	}
+	return float64(r.Succeeded) / float64(r.Processed)
+}
+
+// LogRunReport is the default run report handler
+func LogRunReport(report *RunReport) {
+	logRunReport(log.Default(), report)
+}
+
+// logRunReport logs the report to the logger
+func logRunReport(logger *log.Logger, report *RunReport) {
+	processed := 0
+	names := make([]string, 0, len(report.Tasks))
+	for name, task := range report.Tasks {
+		processed += task.Processed
+		names = append(names, name)
+	}
+
+	logger.INFO.Printf("Worker %s processed %d tasks in the last %s, %d tasks in flight",
+		report.ConsumerTag, processed, report.End.Sub(report.Start).Round(time.Second), report.InFlight)
+	if report.DeadLetters > 0 {
+		logger.WARNING.Printf("Worker %s has %d messages in the dead-letter queue", report.ConsumerTag, report.DeadLetters)
+	}
+	if len(report.FleetInFlight) > 0 {
+		fleetNames := make([]string, 0, len(report.FleetInFlight))
+		for name := range report.FleetInFlight {
+			fleetNames = append(fleetNames, name)
+		}
+		sort.Strings(fleetNames)
+		inFlight := make([]string, len(fleetNames))
+		for i, name := range fleetNames {
+			inFlight[i] = fmt.Sprintf("%s=%d", name, report.FleetInFlight[name])
+		}
+		logger.INFO.Printf("Tasks in flight across the fleet: %s", strings.Join(inFlight, ", "))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		task := report.Tasks[name]
+		logger.INFO.Printf("- %s: processed %d, success rate %.1f%%, retried %d, redelivered %d, p95 duration %s",
+			name, task.Processed, 100*task.SuccessRate(), task.Retried, task.Redelivered, task.P95Duration)
+	}
+}
+
+// outcome of processing a task
+type outcome int
+
+const (
+	outcomeFailed outcome = iota
+	outcomeSucceeded
+	outcomeRetried
+)
+
+// taskStats accumulates the runs of a task name until the next report
+type taskStats struct {
+	report    TaskRunReport
+	durations []time.Duration
+}
+
+// reporter collects statistics of processed tasks and hands a report to the
+// handler every interval
+type reporter struct {
+	consumerTag string
+	interval    time.Duration
+	handler     func(*RunReport)
+	logger      *log.Logger
+	// deadLetterDepth returns the depth of the dead-letter queue, if any
+	deadLetterDepth func() (int, error)
+	// fleetInFlight returns the in-flight tasks of the fleet, if known
+	fleetInFlight func() (map[string]int, error)
+
+	mu       sync.Mutex
+	start    time.Time
+	inFlight int
+	tasks    map[string]*taskStats
+
+	stopOnce sync.Once
+	stopChan chan struct{}
+	doneChan chan struct{}
+}
+
+func newReporter(consumerTag string, interval time.Duration, handler func(*RunReport), logger *log.Logger) *reporter {
+	if handler == nil {
+		handler = func(report *RunReport) { logRunReport(logger, report) }
+	}
+	return &reporter{
+		consumerTag: consumerTag,
+		interval:    interval,
+		handler:     handler,
+		logger:      logger,
+		start:       time.Now().UTC(),
+		tasks:       make(map[string]*taskStats),
+		stopChan:    make(chan struct{}),
+		doneChan:    make(chan struct{}),
+	}
+}
+
+// run emits reports until stopped, the last one covers the time since the
+// previous report
+func (r *reporter) run() {
+	defer close(r.doneChan)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.handler(r.report())
+		case <-r.stopChan:
+			r.handler(r.report())
+			return
+		}
+	}
+}
+
+// stop emits the last report and waits for the handler to return
+func (r *reporter) stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopChan)
+		<-r.doneChan
+	})
+}
+
+func (r *reporter) begin() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inFlight++
+}
+
+func (r *reporter) end(name string, result outcome, redelivered bool, duration time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inFlight--
+
+	stats, ok := r.tasks[name]
+	if !ok {
+		stats = new(taskStats)
+		r.tasks[name] = stats
+	}
+
+	stats.report.Processed++
+	switch result {
+	case outcomeSucceeded:
+		stats.report.Succeeded++
+	case outcomeRetried:
+		stats.report.Retried++
+	default:
+		stats.report.Failed++
+	}
+	if redelivered {
+		stats.report.Redelivered++
+	}
+
+	// Reservoir sampling keeps memory bounded on busy workers
+	if len(stats.durations) < durationSamples {
+		stats.durations = append(stats.durations, duration)
+	} else if i := rand.Intn(stats.report.Processed); i < durationSamples {
+		stats.durations[i] = duration
+	}
+}
+
+// report returns the statistics collected since the previous report and
+// starts a new interval
+func (r *reporter) report() *RunReport {
+	// Asking the broker may take a while, tasks keep ending meanwhile
+	deadLetters := 0
+	if r.deadLetterDepth != nil {
+		depth, err := r.deadLetterDepth()
+		if err != nil {
+			r.logger.WARNING.Printf("Failed to get the depth of the dead-letter queue: %s", err)
+		}
+		deadLetters = depth
+	}
+	var fleetInFlight map[string]int
+	if r.fleetInFlight != nil {
+		counts, err := r.fleetInFlight()
+		if err != nil {
+			r.logger.WARNING.Printf("Failed to get the tasks in flight across the fleet: %s", err)
+		}
+		fleetInFlight = counts
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+	report := &RunReport{
+		ConsumerTag:   r.consumerTag,
+		Start:         r.start,
+		End:           now,
+		InFlight:      r.inFlight,
+		DeadLetters:   deadLetters,
+		FleetInFlight: fleetInFlight,
+		Tasks:         make(map[string]*TaskRunReport, len(r.tasks)),
+	}
+	for name, stats := range r.tasks {
+		taskReport := stats.report
+		taskReport.P95Duration = percentile(stats.durations, 0.95)
+		report.Tasks[name] = &taskReport
+	}
+
+	r.start = now
+	r.tasks = make(map[string]*taskStats)
+	return report
+}
+
+// percentile returns the nearest-rank percentile p of durations
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+
+	sorted := make([]time.Duration, len(durations))
+	copy(sorted, durations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
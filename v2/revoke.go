@@ -0,0 +1,203 @@
+package machinery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	opentracing_log "github.com/opentracing/opentracing-go/log"
+
+	"github.com/RichardKnop/machinery/v2/log"
+	"github.com/RichardKnop/machinery/v2/tasks"
+	"github.com/RichardKnop/machinery/v2/tracing"
+
+	backendsiface "github.com/RichardKnop/machinery/v2/backends/iface"
+)
+
+// revocable is a task being run which is cancelled if it gets revoked
+type revocable struct {
+	signature *tasks.Signature
+	cancel    context.CancelFunc
+	revoked   bool
+}
+
+// revocationWatch keeps the revocable tasks a worker runs and cancels them
+// once they are revoked
+type revocationWatch struct {
+	interval time.Duration
+	backend  backendsiface.RevokeBackend
+	logger   *log.Logger
+
+	mu      sync.Mutex
+	running map[*revocable]struct{}
+
+	stopOnce sync.Once
+	stopChan chan struct{}
+}
+
+// CancelTask revokes the task, workers fail it with tasks.ErrTaskRevoked
+// instead of running it. Tasks already running are cancelled only if their
+// function takes a context and the worker watches revocations, see
+// Worker.SetRevocationWatch.
+func (server *Server) CancelTask(taskUUID string) error {
+	revokeBackend, ok := server.baseBackend().(backendsiface.RevokeBackend)
+	if !ok {
+		return errors.New("Result backend does not support revoking tasks")
+	}
+	return revokeBackend.RevokeTask(taskUUID)
+}
+
+// isRevoked returns true if the task was revoked, tasks are never revoked if
+// the backend doesn't support it
+func (server *Server) isRevoked(taskUUID string) (bool, error) {
+	revokeBackend, ok := server.baseBackend().(backendsiface.RevokeBackend)
+	if !ok {
+		return false, nil
+	}
+	return revokeBackend.IsRevoked(taskUUID)
+}
+
+// SetRevocationWatch makes the worker check every interval whether the tasks
+// it runs were revoked and cancel their context if they were, so they can
+// stop early. Zero turns watching off. Must be called before Launch.
+func (worker *Worker) SetRevocationWatch(interval time.Duration) error {
+	if interval <= 0 {
+		worker.revocations = nil
+		return nil
+	}
+
+	revokeBackend, ok := worker.server.baseBackend().(backendsiface.RevokeBackend)
+	if !ok {
+		return errors.New("Result backend does not support revoking tasks")
+	}
+	worker.revocations = &revocationWatch{
+		interval: interval,
+		backend:  revokeBackend,
+		logger:   worker.server.log(),
+		running:  make(map[*revocable]struct{}),
+		stopChan: make(chan struct{}),
+	}
+	return nil
+}
+
+// run checks the running tasks every interval until stopped
+func (w *revocationWatch) run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.check()
+		case <-w.stopChan:
+			return
+		}
+	}
+}
+
+func (w *revocationWatch) stop() {
+	w.stopOnce.Do(func() { close(w.stopChan) })
+}
+
+// begin makes the task revocable if its function takes a context, the task
+// must be passed to end once it returns
+func (w *revocationWatch) begin(signature *tasks.Signature, task *tasks.Task) *revocable {
+	if w == nil || !task.UseContext {
+		return nil
+	}
+
+	running := &revocable{signature: signature}
+	task.Context, running.cancel = context.WithCancel(task.Context)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.running[running] = struct{}{}
+	return running
+}
+
+// end forgets the task and returns true if it was revoked
+func (w *revocationWatch) end(running *revocable) bool {
+	if running == nil {
+		return false
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.running, running)
+	running.cancel()
+	return running.revoked
+}
+
+// check cancels the running tasks which were revoked
+func (w *revocationWatch) check() {
+	w.mu.Lock()
+	candidates := make([]*revocable, 0, len(w.running))
+	for running := range w.running {
+		if !running.revoked {
+			candidates = append(candidates, running)
+		}
+	}
+	w.mu.Unlock()
+
+	// The backend is asked without holding the mutex, so tasks can begin
+	// and end meanwhile
+	for _, running := range candidates {
+		revoked, err := w.backend.IsRevoked(running.signature.UUID)
+		if err != nil {
+			w.logger.WARNING.Printf("Failed to check whether task %s was revoked: %s", running.signature.UUID, err)
+			continue
+		}
+		if !revoked {
+			continue
+		}
+
+		w.logger.WARNING.Printf("Task %s was revoked, cancelling it", running.signature.UUID)
+		w.mu.Lock()
+		running.revoked = true
+		running.cancel()
+		w.mu.Unlock()
+	}
+}
+
+// taskRevoked fails the revoked task without triggering its error callbacks,
+// the tasks waiting for it are skipped
+func (worker *Worker) taskRevoked(span opentracing.Span, signature *tasks.Signature) error {
+	if err := worker.server.GetBackend().SetStateFailure(signature, tasks.ErrTaskRevoked.Error()); err != nil {
+		return fmt.Errorf("Set state to 'failure' for task %s returned error: %s", signature.UUID, err)
+	}
+	tracing.LogStateTransition(span, tasks.StateFailure, opentracing_log.Error(tasks.ErrTaskRevoked))
+	worker.server.releaseUniqueKey(signature)
+
+	worker.server.log().WARNING.Printf("Task %s was revoked", signature.UUID)
+
+	worker.skipFollowing(signature, tasks.ErrTaskRevoked)
+	worker.chordFailed(span, signature)
+	return nil
+}
+
+// revokeGroup revokes the tasks in the group of the failed task which haven't
+// completed, if the policy of the group fails fast
+func (worker *Worker) revokeGroup(signature *tasks.Signature) {
+	if signature.GroupUUID == "" || !signature.GroupFailurePolicy.FailsFast() {
+		return
+	}
+
+	taskStates, err := worker.server.GetBackend().GroupTaskStates(signature.GroupUUID, signature.GroupTaskCount)
+	if err != nil {
+		worker.server.log().ERROR.Printf("Getting task states of group %s returned error: %s", signature.GroupUUID, err)
+		return
+	}
+	for _, taskState := range taskStates {
+		if taskState.IsCompleted() {
+			continue
+		}
+		if err := worker.server.CancelTask(taskState.TaskUUID); err != nil {
+			worker.server.log().ERROR.Printf("Failed to revoke tasks of group %s: %s", signature.GroupUUID, err)
+			return
+		}
+	}
+	worker.server.log().WARNING.Printf("Task %s of group %s failed, revoked the tasks which haven't completed", signature.UUID, signature.GroupUUID)
+}
@@ -0,0 +1,88 @@
+package machinery_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/backends/memory"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestCancelTask(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	server.SetBackend(memory.New(new(config.Config)))
+	called := false
+	err := server.RegisterTasks(map[string]interface{}{
+		"add": func(a, b int64) (int64, error) {
+			called = true
+			return a + b, nil
+		},
+	})
+	assert.NoError(t, err)
+
+	callback := &tasks.Signature{Name: "add", Args: []tasks.Arg{{Type: "int64", Value: 1}}}
+	signature := &tasks.Signature{
+		Name:      "add",
+		Args:      []tasks.Arg{{Type: "int64", Value: 1}, {Type: "int64", Value: 2}},
+		OnSuccess: []*tasks.Signature{callback},
+	}
+	asyncResult, err := server.SendTask(signature)
+	assert.NoError(t, err)
+	assert.NoError(t, server.CancelTask(signature.UUID))
+
+	worker := server.NewWorker("test_worker", 1)
+	assert.NoError(t, worker.Process(broker.next()))
+	assert.False(t, called)
+	assert.Nil(t, broker.next(), "callbacks of revoked tasks are not sent")
+
+	_, err = asyncResult.Touch()
+	assert.EqualError(t, err, tasks.ErrTaskRevoked.Error())
+}
+
+func TestRevocationWatch(t *testing.T) {
+	t.Parallel()
+
+	server, _ := newRecordingServer(t)
+	server.SetBackend(memory.New(new(config.Config)))
+	err := server.RegisterTask("report", func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+			return nil
+		}
+	})
+	assert.NoError(t, err)
+
+	worker := server.NewWorker("test_worker", 1)
+	assert.NoError(t, worker.SetRevocationWatch(time.Minute))
+
+	signature := &tasks.Signature{UUID: "task_report", Name: "report"}
+	done := make(chan error)
+	go func() { done <- worker.Process(signature) }()
+	assert.Eventually(t, func() bool {
+		state, err := server.GetBackend().GetState(signature.UUID)
+		return err == nil && state.State == tasks.StateStarted
+	}, time.Second, time.Millisecond)
+
+	assert.NoError(t, server.CancelTask(signature.UUID))
+	worker.CheckRevocations()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("the revoked task was not cancelled")
+	}
+
+	state, err := server.GetBackend().GetState(signature.UUID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, tasks.StateFailure, state.State)
+		assert.Equal(t, tasks.ErrTaskRevoked.Error(), state.Error)
+	}
+}
@@ -0,0 +1,63 @@
+package machinery
+
+import (
+	"fmt"
+	"path"
+	"sync"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+// routingRule routes the tasks it matches to the routing key
+type routingRule struct {
+	match      func(*tasks.Signature) bool
+	routingKey string
+}
+
+// routingRules are the rules of a server, in the order they were added
+type routingRules struct {
+	mu    sync.RWMutex
+	rules []routingRule
+}
+
+// RouteTasks routes tasks whose name matches the pattern, e.g. "email.*",
+// to the routing key, unless their signature sets one. Patterns use the
+// syntax of path.Match. The first matching rule wins, in the order rules
+// were added.
+func (server *Server) RouteTasks(pattern, routingKey string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("Routing pattern %q error: %s", pattern, err)
+	}
+
+	server.RouteTasksFunc(func(signature *tasks.Signature) bool {
+		matched, _ := path.Match(pattern, signature.Name)
+		return matched
+	}, routingKey)
+	return nil
+}
+
+// RouteTasksFunc routes tasks the function matches, e.g. by their headers,
+// to the routing key, unless their signature sets one. The first matching
+// rule wins, in the order rules were added.
+func (server *Server) RouteTasksFunc(match func(*tasks.Signature) bool, routingKey string) {
+	server.routing.mu.Lock()
+	defer server.routing.mu.Unlock()
+	server.routing.rules = append(server.routing.rules, routingRule{match: match, routingKey: routingKey})
+}
+
+// route sets the routing key of the first rule matching the task, unless
+// the signature sets one
+func (server *Server) route(signature *tasks.Signature) {
+	if signature.RoutingKey != "" {
+		return
+	}
+
+	server.routing.mu.RLock()
+	defer server.routing.mu.RUnlock()
+	for _, rule := range server.routing.rules {
+		if rule.match(signature) {
+			signature.RoutingKey = rule.routingKey
+			return
+		}
+	}
+}
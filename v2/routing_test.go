@@ -0,0 +1,50 @@
+package machinery_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestRoutingRules(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	assert.NoError(t, server.RouteTasks("email.*", "emails"))
+	server.RouteTasksFunc(func(signature *tasks.Signature) bool {
+		return signature.Headers["tenant"] == "acme"
+	}, "acme")
+	assert.NoError(t, server.RouteTasks("*", "default"))
+	assert.Error(t, server.RouteTasks("[", "broken"))
+
+	send := func(signature *tasks.Signature) string {
+		_, err := server.SendTask(signature)
+		assert.NoError(t, err)
+		if sent := broker.next(); assert.NotNil(t, sent) {
+			return sent.RoutingKey
+		}
+		return ""
+	}
+
+	// The first matching rule wins
+	assert.Equal(t, "emails", send(&tasks.Signature{Name: "email.welcome", Headers: tasks.Headers{"tenant": "acme"}}))
+	assert.Equal(t, "acme", send(&tasks.Signature{Name: "report", Headers: tasks.Headers{"tenant": "acme"}}))
+	assert.Equal(t, "default", send(&tasks.Signature{Name: "report"}))
+
+	// Routing keys set on signatures are kept
+	assert.Equal(t, "urgent", send(&tasks.Signature{Name: "email.reset", RoutingKey: "urgent"}))
+
+	// Tasks of groups are routed as well
+	group, err := tasks.NewGroup(&tasks.Signature{Name: "email.digest"}, &tasks.Signature{Name: "report"})
+	assert.NoError(t, err)
+	_, err = server.SendGroupWithContext(context.Background(), group, 0)
+	assert.NoError(t, err)
+	routingKeys := []string{}
+	for sent := broker.next(); sent != nil; sent = broker.next() {
+		routingKeys = append(routingKeys, sent.RoutingKey)
+	}
+	assert.ElementsMatch(t, []string{"emails", "default"}, routingKeys)
+}
@@ -0,0 +1,561 @@
+package machinery
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rruleHorizon is how far after the time Next searches for the next run of a
+// recurrence rule, rules without runs within it never run again
+const rruleHorizon = 10 * 366 * 24 * time.Hour
+
+// rruleMaxPeriods caps how many periods Next expands, e.g. to stop a
+// secondly rule which matches once a year
+const rruleMaxPeriods = 1 << 20
+
+// frequency is the FREQ of a recurrence rule
+type frequency int
+
+const (
+	yearly frequency = iota
+	monthly
+	weekly
+	daily
+	hourly
+	minutely
+	secondly
+)
+
+var frequencies = map[string]frequency{
+	"YEARLY":   yearly,
+	"MONTHLY":  monthly,
+	"WEEKLY":   weekly,
+	"DAILY":    daily,
+	"HOURLY":   hourly,
+	"MINUTELY": minutely,
+	"SECONDLY": secondly,
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// weekdayNum is a BYDAY entry, e.g. -1FR for the last Friday. A zero n
+// matches every such weekday.
+type weekdayNum struct {
+	n       int
+	weekday time.Weekday
+}
+
+// rruleSchedule runs at the occurrences of an iCalendar (RFC 5545)
+// recurrence rule, e.g. on the last business day of every month
+type rruleSchedule struct {
+	freq      frequency
+	interval  int
+	count     int
+	until     time.Time
+	start     time.Time
+	weekStart time.Weekday
+
+	byMonth    []int
+	byMonthDay []int
+	byDay      []weekdayNum
+	byHour     []int
+	byMinute   []int
+	bySecond   []int
+	bySetPos   []int
+}
+
+// isRRuleSpec returns true if the spec is a recurrence rule rather than a
+// cron spec
+func isRRuleSpec(spec string) bool {
+	return strings.Contains(strings.ToUpper(spec), "RRULE:")
+}
+
+// parseRRule parses a recurrence rule, optionally preceded by its DTSTART on
+// the same or its own line, e.g.
+// "DTSTART;TZID=Europe/Prague:20260105T090000 RRULE:FREQ=WEEKLY;BYDAY=MO".
+// Without DTSTART the rule starts at midnight of 1 January 1970, local time.
+func parseRRule(spec string) (*rruleSchedule, error) {
+	schedule := &rruleSchedule{
+		interval:  1,
+		weekStart: time.Monday,
+		start:     time.Date(1970, time.January, 1, 0, 0, 0, 0, time.Local),
+	}
+
+	var rule string
+	for _, field := range strings.Fields(spec) {
+		upper := strings.ToUpper(field)
+		switch {
+		case strings.HasPrefix(upper, "DTSTART"):
+			start, err := parseRRuleTime(field[len("DTSTART"):], time.Local)
+			if err != nil {
+				return nil, fmt.Errorf("Parse DTSTART error: %s", err)
+			}
+			schedule.start = start
+		case strings.HasPrefix(upper, "RRULE:"):
+			rule = field[len("RRULE:"):]
+		default:
+			return nil, fmt.Errorf("Unexpected %q in recurrence rule", field)
+		}
+	}
+	if rule == "" {
+		return nil, fmt.Errorf("Recurrence rule %q has no RRULE", spec)
+	}
+
+	hasFreq := false
+	for _, part := range strings.Split(rule, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("Invalid recurrence rule part %q", part)
+		}
+		var err error
+		switch strings.ToUpper(name) {
+		case "FREQ":
+			schedule.freq, hasFreq = frequencies[strings.ToUpper(value)]
+			if !hasFreq {
+				err = fmt.Errorf("unknown frequency")
+			}
+		case "INTERVAL":
+			schedule.interval, err = strconv.Atoi(value)
+			if err == nil && schedule.interval < 1 {
+				err = fmt.Errorf("interval must be positive")
+			}
+		case "COUNT":
+			schedule.count, err = strconv.Atoi(value)
+			if err == nil && schedule.count < 1 {
+				err = fmt.Errorf("count must be positive")
+			}
+		case "UNTIL":
+			schedule.until, err = parseRRuleTime(":"+value, schedule.start.Location())
+		case "WKST":
+			var ok bool
+			if schedule.weekStart, ok = weekdays[strings.ToUpper(value)]; !ok {
+				err = fmt.Errorf("unknown weekday")
+			}
+		case "BYMONTH":
+			schedule.byMonth, err = parseRRuleInts(value, 1, 12, false)
+		case "BYMONTHDAY":
+			schedule.byMonthDay, err = parseRRuleInts(value, 1, 31, true)
+		case "BYDAY":
+			schedule.byDay, err = parseRRuleWeekdays(value)
+		case "BYHOUR":
+			schedule.byHour, err = parseRRuleInts(value, 0, 23, false)
+		case "BYMINUTE":
+			schedule.byMinute, err = parseRRuleInts(value, 0, 59, false)
+		case "BYSECOND":
+			schedule.bySecond, err = parseRRuleInts(value, 0, 59, false)
+		case "BYSETPOS":
+			schedule.bySetPos, err = parseRRuleInts(value, 1, 366, true)
+		default:
+			err = fmt.Errorf("not supported")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid recurrence rule part %q: %s", part, err)
+		}
+	}
+	if !hasFreq {
+		return nil, fmt.Errorf("Recurrence rule %q has no FREQ", rule)
+	}
+	if schedule.count > 0 && !schedule.until.IsZero() {
+		return nil, fmt.Errorf("Recurrence rule %q has both COUNT and UNTIL", rule)
+	}
+	for _, day := range schedule.byDay {
+		if day.n != 0 && schedule.freq != monthly && schedule.freq != yearly {
+			return nil, fmt.Errorf("Recurrence rule %q numbers weekdays of a frequency other than MONTHLY or YEARLY", rule)
+		}
+	}
+	return schedule, nil
+}
+
+// parseRRuleTime parses the parameters and value of DTSTART or UNTIL, e.g.
+// ";TZID=Europe/Prague:20260105T090000", ":20260105T090000Z" or
+// ";VALUE=DATE:20260105"
+func parseRRuleTime(field string, loc *time.Location) (time.Time, error) {
+	params, value, ok := strings.Cut(field, ":")
+	if !ok {
+		return time.Time{}, fmt.Errorf("%q has no value", field)
+	}
+	for _, param := range strings.Split(params, ";") {
+		if name, zone, ok := strings.Cut(param, "="); ok && strings.EqualFold(name, "TZID") {
+			var err error
+			if loc, err = time.LoadLocation(zone); err != nil {
+				return time.Time{}, err
+			}
+		}
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse("20060102T150405Z", value)
+	}
+	if len(value) == len("20060102") {
+		return time.ParseInLocation("20060102", value, loc)
+	}
+	return time.ParseInLocation("20060102T150405", value, loc)
+}
+
+// parseRRuleInts parses a comma separated list of numbers between min and
+// max, or between -max and -min if negative numbers are allowed
+func parseRRuleInts(value string, min, max int, negative bool) ([]int, error) {
+	numbers := make([]int, 0)
+	for _, field := range strings.Split(value, ",") {
+		number, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		abs := number
+		if negative && number < 0 {
+			abs = -number
+		}
+		if abs < min || abs > max {
+			return nil, fmt.Errorf("%d is out of range", number)
+		}
+		numbers = append(numbers, number)
+	}
+	return numbers, nil
+}
+
+// parseRRuleWeekdays parses a BYDAY list, e.g. "MO,TU" or "2TU,-1FR"
+func parseRRuleWeekdays(value string) ([]weekdayNum, error) {
+	days := make([]weekdayNum, 0)
+	for _, field := range strings.Split(strings.ToUpper(value), ",") {
+		if len(field) < 2 {
+			return nil, fmt.Errorf("unknown weekday %q", field)
+		}
+		weekday, ok := weekdays[field[len(field)-2:]]
+		if !ok {
+			return nil, fmt.Errorf("unknown weekday %q", field)
+		}
+		day := weekdayNum{weekday: weekday}
+		if prefix := field[:len(field)-2]; prefix != "" {
+			n, err := strconv.Atoi(prefix)
+			if err != nil || n == 0 || n > 53 || n < -53 {
+				return nil, fmt.Errorf("invalid weekday %q", field)
+			}
+			day.n = n
+		}
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+// Next returns the first occurrence of the rule after the time, the zero time
+// if there is none
+func (s *rruleSchedule) Next(t time.Time) time.Time {
+	// Occurrences are counted from the start, rules without a count start
+	// with the period of the time
+	first := 0
+	if s.count == 0 && t.After(s.start) {
+		first = s.periodIndex(t) / s.interval * s.interval
+	}
+
+	horizon := t.Add(rruleHorizon)
+	occurrences := 0
+	for i, p := 0, first; i < rruleMaxPeriods; i, p = i+1, p+s.interval {
+		periodStart := s.periodStart(p)
+		if periodStart.After(horizon) {
+			break
+		}
+		for _, occurrence := range s.expand(periodStart) {
+			if occurrence.Before(s.start) {
+				continue
+			}
+			if !s.until.IsZero() && occurrence.After(s.until) {
+				return time.Time{}
+			}
+			occurrences++
+			if s.count > 0 && occurrences > s.count {
+				return time.Time{}
+			}
+			if occurrence.After(t) {
+				return occurrence
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// periodIndex returns the index of the period the time falls into, counted
+// from the period of the start
+func (s *rruleSchedule) periodIndex(t time.Time) int {
+	t = t.In(s.start.Location())
+	switch s.freq {
+	case yearly:
+		return t.Year() - s.start.Year()
+	case monthly:
+		return (t.Year()-s.start.Year())*12 + int(t.Month()) - int(s.start.Month())
+	case weekly:
+		return daysBetween(s.weekOf(s.start), t) / 7
+	case daily:
+		return daysBetween(s.start, t)
+	}
+	return int(t.Sub(s.periodStart(0)) / s.unit())
+}
+
+// periodStart returns when the period with the index starts
+func (s *rruleSchedule) periodStart(p int) time.Time {
+	y, m, d := s.start.Date()
+	loc := s.start.Location()
+	switch s.freq {
+	case yearly:
+		return time.Date(y+p, time.January, 1, 0, 0, 0, 0, loc)
+	case monthly:
+		return time.Date(y, m+time.Month(p), 1, 0, 0, 0, 0, loc)
+	case weekly:
+		return s.weekOf(s.start).AddDate(0, 0, 7*p)
+	case daily:
+		return time.Date(y, m, d+p, 0, 0, 0, 0, loc)
+	case hourly:
+		return time.Date(y, m, d, s.start.Hour(), 0, 0, 0, loc).Add(time.Duration(p) * time.Hour)
+	case minutely:
+		return time.Date(y, m, d, s.start.Hour(), s.start.Minute(), 0, 0, loc).Add(time.Duration(p) * time.Minute)
+	}
+	return s.start.Truncate(time.Second).Add(time.Duration(p) * time.Second)
+}
+
+// unit returns the length of periods shorter than a day
+func (s *rruleSchedule) unit() time.Duration {
+	switch s.freq {
+	case hourly:
+		return time.Hour
+	case minutely:
+		return time.Minute
+	}
+	return time.Second
+}
+
+// weekOf returns the midnight starting the week of the time
+func (s *rruleSchedule) weekOf(t time.Time) time.Time {
+	y, m, d := t.Date()
+	offset := (int(t.Weekday()) - int(s.weekStart) + 7) % 7
+	return time.Date(y, m, d-offset, 0, 0, 0, 0, t.Location())
+}
+
+// daysBetween returns the number of calendar days from a to b
+func daysBetween(a, b time.Time) int {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return int(time.Date(by, bm, bd, 0, 0, 0, 0, time.UTC).Sub(time.Date(ay, am, ad, 0, 0, 0, 0, time.UTC)) / (24 * time.Hour))
+}
+
+// expand returns the occurrences within the period, in order
+func (s *rruleSchedule) expand(periodStart time.Time) []time.Time {
+	var days []time.Time
+	switch s.freq {
+	case yearly:
+		days = s.yearDays(periodStart.Year())
+	case monthly:
+		if s.matchesMonth(periodStart) {
+			days = s.monthDays(periodStart.Year(), periodStart.Month())
+		}
+	case weekly:
+		for i := 0; i < 7; i++ {
+			day := periodStart.AddDate(0, 0, i)
+			if s.matchesMonth(day) && s.matchesWeekday(day, s.start.Weekday()) {
+				days = append(days, day)
+			}
+		}
+	case daily:
+		if s.matchesDay(periodStart) {
+			days = []time.Time{periodStart}
+		}
+	default:
+		if !s.matchesDay(periodStart) || !matches(s.byHour, periodStart.Hour()) {
+			return nil
+		}
+		if s.freq == secondly {
+			if !matches(s.byMinute, periodStart.Minute()) || !matches(s.bySecond, periodStart.Second()) {
+				return nil
+			}
+			return []time.Time{periodStart}
+		}
+	}
+
+	var occurrences []time.Time
+	if s.freq >= hourly {
+		minutes := []int{periodStart.Minute()}
+		if s.freq == hourly {
+			minutes = orDefault(s.byMinute, s.start.Minute())
+		} else if !matches(s.byMinute, periodStart.Minute()) {
+			return nil
+		}
+		for _, minute := range minutes {
+			for _, second := range orDefault(s.bySecond, s.start.Second()) {
+				occurrence := periodStart.Add(time.Duration(minute-periodStart.Minute())*time.Minute + time.Duration(second)*time.Second)
+				occurrences = append(occurrences, occurrence)
+			}
+		}
+	} else {
+		for _, day := range days {
+			y, m, d := day.Date()
+			for _, hour := range orDefault(s.byHour, s.start.Hour()) {
+				for _, minute := range orDefault(s.byMinute, s.start.Minute()) {
+					for _, second := range orDefault(s.bySecond, s.start.Second()) {
+						occurrences = append(occurrences, time.Date(y, m, d, hour, minute, second, 0, day.Location()))
+					}
+				}
+			}
+		}
+	}
+
+	sort.Slice(occurrences, func(i, j int) bool { return occurrences[i].Before(occurrences[j]) })
+	return s.setPositions(occurrences)
+}
+
+// yearDays returns the days of the year the rule matches
+func (s *rruleSchedule) yearDays(year int) []time.Time {
+	loc := s.start.Location()
+
+	// Numbered weekdays without months are numbered within the year
+	if len(s.byMonth) == 0 && len(s.byMonthDay) == 0 && len(s.byDay) > 0 {
+		first := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+		total := daysBetween(first, time.Date(year+1, time.January, 1, 0, 0, 0, 0, loc))
+		days := make([]time.Time, 0)
+		for i := 0; i < total; i++ {
+			day := first.AddDate(0, 0, i)
+			if matchesNumberedWeekday(s.byDay, day.Weekday(), i+1, total) {
+				days = append(days, day)
+			}
+		}
+		return days
+	}
+
+	months := s.byMonth
+	if len(months) == 0 {
+		months = []int{int(s.start.Month())}
+		if len(s.byMonthDay) > 0 {
+			months = []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+		}
+	}
+	days := make([]time.Time, 0)
+	for _, month := range months {
+		days = append(days, s.monthDays(year, time.Month(month))...)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days
+}
+
+// monthDays returns the days of the month the rule matches, weekdays are
+// numbered within the month
+func (s *rruleSchedule) monthDays(year int, month time.Month) []time.Time {
+	loc := s.start.Location()
+	total := time.Date(year, month+1, 0, 0, 0, 0, 0, loc).Day()
+	days := make([]time.Time, 0)
+	for d := 1; d <= total; d++ {
+		day := time.Date(year, month, d, 0, 0, 0, 0, loc)
+		switch {
+		case len(s.byMonthDay) == 0 && len(s.byDay) == 0:
+			if d != s.start.Day() {
+				continue
+			}
+		case len(s.byMonthDay) > 0 && !matchesMonthDay(s.byMonthDay, d, total):
+			continue
+		case len(s.byDay) > 0 && !matchesNumberedWeekday(s.byDay, day.Weekday(), d, total):
+			continue
+		}
+		days = append(days, day)
+	}
+	return days
+}
+
+// matchesMonth returns true if the month of the day matches BYMONTH
+func (s *rruleSchedule) matchesMonth(day time.Time) bool {
+	return matches(s.byMonth, int(day.Month()))
+}
+
+// matchesWeekday returns true if the weekday of the day matches BYDAY, or the
+// default weekday without it
+func (s *rruleSchedule) matchesWeekday(day time.Time, fallback time.Weekday) bool {
+	if len(s.byDay) == 0 {
+		return day.Weekday() == fallback
+	}
+	return matchesNumberedWeekday(s.byDay, day.Weekday(), 1, 1)
+}
+
+// matchesDay returns true if the day matches BYMONTH, BYMONTHDAY and BYDAY,
+// for frequencies of a day or shorter
+func (s *rruleSchedule) matchesDay(day time.Time) bool {
+	total := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, day.Location()).Day()
+	if !s.matchesMonth(day) {
+		return false
+	}
+	if len(s.byMonthDay) > 0 && !matchesMonthDay(s.byMonthDay, day.Day(), total) {
+		return false
+	}
+	return len(s.byDay) == 0 || matchesNumberedWeekday(s.byDay, day.Weekday(), 1, 1)
+}
+
+// setPositions picks the occurrences of the period at the BYSETPOS positions
+func (s *rruleSchedule) setPositions(occurrences []time.Time) []time.Time {
+	if len(s.bySetPos) == 0 {
+		return occurrences
+	}
+	picked := make([]time.Time, 0, len(s.bySetPos))
+	for _, pos := range s.bySetPos {
+		i := pos - 1
+		if pos < 0 {
+			i = len(occurrences) + pos
+		}
+		if i >= 0 && i < len(occurrences) {
+			picked = append(picked, occurrences[i])
+		}
+	}
+	sort.Slice(picked, func(i, j int) bool { return picked[i].Before(picked[j]) })
+	return picked
+}
+
+// matches returns true if the values are empty or contain the value
+func matches(values []int, value int) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// orDefault returns the values, or the default if there are none
+func orDefault(values []int, fallback int) []int {
+	if len(values) == 0 {
+		return []int{fallback}
+	}
+	return values
+}
+
+// matchesMonthDay returns true if the day of a month of total days matches
+// one of the month days, negative ones count from the end
+func matchesMonthDay(monthDays []int, day, total int) bool {
+	for _, monthDay := range monthDays {
+		if monthDay == day || monthDay == day-total-1 {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesNumberedWeekday returns true if the weekday, which is the index-th
+// day of total days, matches one of the days, numbered ones counted in weeks
+// from the start or the end
+func matchesNumberedWeekday(days []weekdayNum, weekday time.Weekday, index, total int) bool {
+	for _, day := range days {
+		if day.weekday != weekday {
+			continue
+		}
+		if day.n == 0 || day.n == (index-1)/7+1 || day.n == -((total-index)/7+1) {
+			return true
+		}
+	}
+	return false
+}
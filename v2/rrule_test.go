@@ -0,0 +1,73 @@
+package machinery_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestRRuleSpecs(t *testing.T) {
+	t.Parallel()
+
+	prague, err := time.LoadLocation("Europe/Prague")
+	if !assert.NoError(t, err) {
+		return
+	}
+	utc := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	}
+
+	lastBusinessDay := "DTSTART;TZID=Europe/Prague:20260101T180000 RRULE:FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1"
+	testCases := []struct {
+		spec     string
+		after    time.Time
+		expected time.Time
+	}{
+		// 31 January 2026 is a Saturday
+		{lastBusinessDay, time.Date(2026, 1, 15, 0, 0, 0, 0, prague), time.Date(2026, 1, 30, 18, 0, 0, 0, prague)},
+		{lastBusinessDay, time.Date(2026, 1, 30, 18, 0, 0, 0, prague), time.Date(2026, 2, 27, 18, 0, 0, 0, prague)},
+		{lastBusinessDay, time.Date(2026, 3, 31, 12, 0, 0, 0, prague), time.Date(2026, 3, 31, 18, 0, 0, 0, prague)},
+		// Every 2nd Tuesday of the month
+		{"RRULE:FREQ=MONTHLY;BYDAY=2TU;BYHOUR=9", time.Date(2026, 1, 14, 0, 0, 0, 0, time.Local), time.Date(2026, 2, 10, 9, 0, 0, 0, time.Local)},
+		// Every other Monday
+		{"DTSTART:20260105T090000Z\nRRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=MO", utc(2026, 1, 5, 9, 0), utc(2026, 1, 19, 9, 0)},
+		{"DTSTART:20260105T090000Z\nRRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=MO", utc(2026, 1, 13, 0, 0), utc(2026, 1, 19, 9, 0)},
+		{"DTSTART:20260101T000000Z RRULE:FREQ=DAILY;COUNT=3", utc(2026, 1, 2, 0, 0), utc(2026, 1, 3, 0, 0)},
+		{"DTSTART:20260101T000000Z RRULE:FREQ=DAILY;COUNT=3", utc(2026, 1, 3, 0, 0), time.Time{}},
+		{"DTSTART:20260101T000000Z RRULE:FREQ=DAILY;UNTIL=20260102T000000Z", utc(2026, 1, 2, 0, 0), time.Time{}},
+		{"DTSTART:20240229T120000Z RRULE:FREQ=YEARLY", utc(2024, 3, 1, 0, 0), utc(2028, 2, 29, 12, 0)},
+		{"DTSTART:20260101T000000Z RRULE:FREQ=HOURLY;INTERVAL=6;BYMINUTE=15", utc(2026, 1, 1, 7, 0), utc(2026, 1, 1, 12, 15)},
+		{"DTSTART:20260101T000000Z RRULE:FREQ=MINUTELY;INTERVAL=15;BYHOUR=9", utc(2026, 1, 1, 10, 0), utc(2026, 1, 2, 9, 0)},
+		// The last Friday of the year
+		{"DTSTART:20260101T000000Z RRULE:FREQ=YEARLY;BYDAY=-1FR", utc(2026, 1, 1, 0, 0), utc(2026, 12, 25, 0, 0)},
+		{"DTSTART:20260101T000000Z RRULE:FREQ=YEARLY;BYMONTH=1,7;BYMONTHDAY=-1", utc(2026, 2, 1, 0, 0), utc(2026, 7, 31, 0, 0)},
+		// Clocks in Prague move forward on 29 March 2026
+		{"DTSTART;TZID=Europe/Prague:20260301T090000 RRULE:FREQ=DAILY", time.Date(2026, 3, 28, 9, 0, 0, 0, prague), utc(2026, 3, 29, 7, 0)},
+	}
+	for _, testCase := range testCases {
+		next, err := machinery.NextPeriodicRun(testCase.spec, testCase.after)
+		assert.NoError(t, err, testCase.spec)
+		assert.True(t, testCase.expected.Equal(next), "%s after %s: expected %s, got %s", testCase.spec, testCase.after, testCase.expected, next)
+	}
+
+	for _, spec := range []string{
+		"RRULE:FREQ=SOMETIMES",
+		"RRULE:INTERVAL=2",
+		"RRULE:FREQ=DAILY;BYWEEKNO=1",
+		"RRULE:FREQ=WEEKLY;BYDAY=2TU",
+		"RRULE:FREQ=MONTHLY;BYMONTHDAY=32",
+		"RRULE:FREQ=DAILY;COUNT=2;UNTIL=20260101T000000Z",
+		"DTSTART:yesterday RRULE:FREQ=DAILY",
+		"DTSTART;TZID=Nowhere/Special:20260101T000000 RRULE:FREQ=DAILY",
+	} {
+		_, err := machinery.NextPeriodicRun(spec, time.Now())
+		assert.Error(t, err, spec)
+	}
+
+	server, _ := newPeriodicServer(t)
+	assert.NoError(t, server.RegisterPeriodicTask(lastBusinessDay, "month-end", &tasks.Signature{Name: "close_books"}))
+}
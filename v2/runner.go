@@ -0,0 +1,309 @@
+package machinery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/RichardKnop/machinery/v2/log"
+)
+
+// httpShutdownTimeout is how long an HTTP component waits for requests in
+// progress when it is stopped
+const httpShutdownTimeout = 30 * time.Second
+
+// ErrRunnerStoppedAbruptly is returned by Run when a second signal is
+// received while the components are stopping
+var ErrRunnerStoppedAbruptly = errors.New("Runner stopped abruptly")
+
+// Component is a part of an application which a Runner starts and stops,
+// e.g. a worker or an HTTP server
+type Component interface {
+	// Start starts the component and returns once it is ready. The
+	// component sends at most one value to errorsChan if it stops by
+	// itself, nil if it stopped cleanly, which stops the runner.
+	Start(errorsChan chan<- error) error
+	// Stop stops the component and returns once it stopped
+	Stop() error
+}
+
+// ComponentFuncs adapts functions to a Component, e.g. to check a broker is
+// reachable before workers start, either function may be nil
+type ComponentFuncs struct {
+	StartFunc func() error
+	StopFunc  func() error
+}
+
+// Start calls StartFunc
+func (c ComponentFuncs) Start(errorsChan chan<- error) error {
+	if c.StartFunc == nil {
+		return nil
+	}
+	return c.StartFunc()
+}
+
+// Stop calls StopFunc
+func (c ComponentFuncs) Stop() error {
+	if c.StopFunc == nil {
+		return nil
+	}
+	return c.StopFunc()
+}
+
+// managedComponent is a component added to a runner
+type managedComponent struct {
+	name      string
+	component Component
+	dependsOn []string
+}
+
+// Runner starts the components of an application after the components they
+// depend on, and stops them in reverse order once Stop is called, a signal
+// is received or any component stops by itself. The first error of a
+// component is returned by Run.
+type Runner struct {
+	// NoUnixSignals stops the runner from handling SIGINT and SIGTERM,
+	// otherwise the first signal stops the components gracefully and the
+	// second one makes Run return without waiting for them
+	NoUnixSignals bool
+
+	components []*managedComponent
+	stopOnce   sync.Once
+	stopChan   chan struct{}
+}
+
+// NewRunner creates Runner instance
+func NewRunner() *Runner {
+	return &Runner{stopChan: make(chan struct{})}
+}
+
+// Add adds the component, it is started after the components it depends on
+// and stopped before them. Must be called before Run.
+func (r *Runner) Add(name string, component Component, dependsOn ...string) error {
+	for _, managed := range r.components {
+		if managed.name == name {
+			return fmt.Errorf("Component %s was already added", name)
+		}
+	}
+	r.components = append(r.components, &managedComponent{name: name, component: component, dependsOn: dependsOn})
+	return nil
+}
+
+// Stop makes Run stop the components, it doesn't wait for them
+func (r *Runner) Stop() {
+	r.stopOnce.Do(func() { close(r.stopChan) })
+}
+
+// Run starts the components and blocks until they stopped. If a component
+// fails to start, the components started before it are stopped.
+func (r *Runner) Run() error {
+	order, err := r.startOrder()
+	if err != nil {
+		return err
+	}
+
+	// Every component sends at most once, so components never block
+	// sending, even once Run returned
+	errorsChan := make(chan error, len(order))
+
+	var sig chan os.Signal
+	if !r.NoUnixSignals {
+		sig = make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(sig)
+	}
+
+	for i, managed := range order {
+		log.INFO.Printf("Starting component %s", managed.name)
+		if err := managed.component.Start(errorsChan); err != nil {
+			err = fmt.Errorf("Start component %s error: %s", managed.name, err)
+			if stopErr := r.stopComponents(order[:i]); stopErr != nil {
+				log.ERROR.Print(stopErr)
+			}
+			return err
+		}
+	}
+
+	var runErr error
+	select {
+	case runErr = <-errorsChan:
+		if runErr != nil {
+			log.ERROR.Printf("Component failed, stopping: %s", runErr)
+		}
+	case s := <-sig:
+		log.WARNING.Printf("Signal received: %v", s)
+	case <-r.stopChan:
+	}
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- r.stopComponents(order) }()
+	select {
+	case stopErr := <-stopped:
+		if runErr == nil {
+			runErr = stopErr
+		}
+	case s := <-sig:
+		log.WARNING.Printf("Signal received: %v", s)
+		return ErrRunnerStoppedAbruptly
+	}
+	return runErr
+}
+
+// stopComponents stops the components in reverse order, all of them are
+// stopped even if some fail and the first error is returned
+func (r *Runner) stopComponents(started []*managedComponent) error {
+	var firstErr error
+	for i := len(started) - 1; i >= 0; i-- {
+		managed := started[i]
+		log.INFO.Printf("Stopping component %s", managed.name)
+		if err := managed.component.Stop(); err != nil {
+			err = fmt.Errorf("Stop component %s error: %s", managed.name, err)
+			log.ERROR.Print(err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// startOrder sorts the components so each one comes after its
+// dependencies, otherwise keeping the order they were added in
+func (r *Runner) startOrder() ([]*managedComponent, error) {
+	byName := make(map[string]*managedComponent, len(r.components))
+	for _, managed := range r.components {
+		byName[managed.name] = managed
+	}
+
+	order := make([]*managedComponent, 0, len(r.components))
+	visited := make(map[string]bool, len(r.components))
+	visiting := make(map[string]bool)
+	var visit func(managed *managedComponent) error
+	visit = func(managed *managedComponent) error {
+		if visited[managed.name] {
+			return nil
+		}
+		if visiting[managed.name] {
+			return fmt.Errorf("Dependencies of component %s form a cycle", managed.name)
+		}
+		visiting[managed.name] = true
+		for _, name := range managed.dependsOn {
+			dependency, ok := byName[name]
+			if !ok {
+				return fmt.Errorf("Component %s depends on unknown component %s", managed.name, name)
+			}
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		visiting[managed.name] = false
+		visited[managed.name] = true
+		order = append(order, managed)
+		return nil
+	}
+
+	for _, managed := range r.components {
+		if err := visit(managed); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// workerComponent launches a worker, signals are handled by the runner
+type workerComponent struct {
+	worker   *Worker
+	quitOnce sync.Once
+}
+
+// WorkerComponent returns a component which launches the worker and quits it
+// once stopped, waiting for its running tasks to finish, or until the
+// ShutdownTimeout of the config
+func WorkerComponent(worker *Worker) Component {
+	return &workerComponent{worker: worker}
+}
+
+// Start launches the worker
+func (c *workerComponent) Start(errorsChan chan<- error) error {
+	c.worker.noUnixSignals = true
+	c.worker.LaunchAsync(errorsChan)
+	return nil
+}
+
+// Stop quits the worker
+func (c *workerComponent) Stop() error {
+	var err error
+	c.quitOnce.Do(func() { err = c.worker.stopGracefully() })
+	return err
+}
+
+// schedulerComponent runs the scheduler of periodic tasks
+type schedulerComponent struct {
+	server *Server
+}
+
+// SchedulerComponent returns a component which runs the scheduler of the
+// server's periodic tasks and stops it, waiting for periodic tasks being
+// sent. Servers start their scheduler once created, so this only makes sure
+// periodic tasks aren't sent while the components they depend on are
+// stopping.
+func SchedulerComponent(server *Server) Component {
+	return &schedulerComponent{server: server}
+}
+
+// Start starts the scheduler unless it runs
+func (c *schedulerComponent) Start(errorsChan chan<- error) error {
+	c.server.scheduler.Start()
+	return nil
+}
+
+// Stop stops the scheduler
+func (c *schedulerComponent) Stop() error {
+	<-c.server.scheduler.Stop().Done()
+	return nil
+}
+
+// httpComponent serves an HTTP server
+type httpComponent struct {
+	server *http.Server
+}
+
+// HTTPComponent returns a component which serves the HTTP server, e.g. for
+// monitoring, and shuts it down gracefully once stopped
+func HTTPComponent(server *http.Server) Component {
+	return &httpComponent{server: server}
+}
+
+// Start listens on the server's address, so it fails if the address is in
+// use, and serves requests in the background
+func (c *httpComponent) Start(errorsChan chan<- error) error {
+	addr := c.server.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := c.server.Serve(listener); err != http.ErrServerClosed {
+			errorsChan <- err
+		}
+	}()
+	return nil
+}
+
+// Stop shuts the server down, waiting for requests in progress
+func (c *httpComponent) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	return c.server.Shutdown(ctx)
+}
@@ -0,0 +1,147 @@
+package machinery_test
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2"
+)
+
+// recordingComponent records when it starts and stops in a log shared by
+// the components of a runner
+type recordingComponent struct {
+	name     string
+	log      *eventLog
+	startErr error
+	failChan chan error
+}
+
+type eventLog struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *eventLog) add(event string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+}
+
+func (l *eventLog) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string{}, l.events...)
+}
+
+func (c *recordingComponent) Start(errorsChan chan<- error) error {
+	if c.startErr != nil {
+		return c.startErr
+	}
+	c.log.add("start " + c.name)
+	if c.failChan != nil {
+		go func() { errorsChan <- <-c.failChan }()
+	}
+	return nil
+}
+
+func (c *recordingComponent) Stop() error {
+	c.log.add("stop " + c.name)
+	return nil
+}
+
+func TestRunner(t *testing.T) {
+	t.Parallel()
+
+	events := new(eventLog)
+	runner := machinery.NewRunner()
+	runner.NoUnixSignals = true
+	assert.NoError(t, runner.Add("worker", &recordingComponent{name: "worker", log: events}, "broker", "backend"))
+	assert.NoError(t, runner.Add("broker", &recordingComponent{name: "broker", log: events}))
+	assert.NoError(t, runner.Add("backend", &recordingComponent{name: "backend", log: events}, "broker"))
+	assert.Error(t, runner.Add("broker", &recordingComponent{name: "broker", log: events}))
+
+	done := make(chan error)
+	go func() { done <- runner.Run() }()
+	assert.Eventually(t, func() bool { return len(events.get()) == 3 }, time.Second, time.Millisecond)
+	runner.Stop()
+	assert.NoError(t, <-done)
+
+	assert.Equal(t, []string{
+		"start broker", "start backend", "start worker",
+		"stop worker", "stop backend", "stop broker",
+	}, events.get())
+}
+
+func TestRunnerComponentFails(t *testing.T) {
+	t.Parallel()
+
+	events := new(eventLog)
+	failChan := make(chan error)
+	runner := machinery.NewRunner()
+	runner.NoUnixSignals = true
+	assert.NoError(t, runner.Add("broker", &recordingComponent{name: "broker", log: events}))
+	assert.NoError(t, runner.Add("worker", &recordingComponent{name: "worker", log: events, failChan: failChan}, "broker"))
+
+	done := make(chan error)
+	go func() { done <- runner.Run() }()
+	failChan <- errors.New("connection lost")
+	assert.EqualError(t, <-done, "connection lost")
+	assert.Equal(t, []string{"start broker", "start worker", "stop worker", "stop broker"}, events.get())
+}
+
+func TestRunnerStartFails(t *testing.T) {
+	t.Parallel()
+
+	events := new(eventLog)
+	runner := machinery.NewRunner()
+	runner.NoUnixSignals = true
+	assert.NoError(t, runner.Add("broker", &recordingComponent{name: "broker", log: events}))
+	assert.NoError(t, runner.Add("worker", &recordingComponent{name: "worker", log: events, startErr: errors.New("no tasks")}, "broker"))
+	assert.NoError(t, runner.Add("monitoring", &recordingComponent{name: "monitoring", log: events}))
+
+	// The components started before the worker are stopped
+	err := runner.Run()
+	assert.EqualError(t, err, "Start component worker error: no tasks")
+	assert.Equal(t, []string{"start broker", "stop broker"}, events.get())
+}
+
+func TestRunnerDependencyErrors(t *testing.T) {
+	t.Parallel()
+
+	runner := machinery.NewRunner()
+	runner.NoUnixSignals = true
+	assert.NoError(t, runner.Add("worker", machinery.ComponentFuncs{}, "broker"))
+	assert.EqualError(t, runner.Run(), "Component worker depends on unknown component broker")
+
+	runner = machinery.NewRunner()
+	runner.NoUnixSignals = true
+	assert.NoError(t, runner.Add("a", machinery.ComponentFuncs{}, "b"))
+	assert.NoError(t, runner.Add("b", machinery.ComponentFuncs{}, "a"))
+	assert.Error(t, runner.Run())
+}
+
+func TestRunnerWorker(t *testing.T) {
+	t.Parallel()
+
+	server, _ := newRecordingServer(t)
+	runner := machinery.NewRunner()
+	runner.NoUnixSignals = true
+	assert.NoError(t, runner.Add("scheduler", machinery.SchedulerComponent(server)))
+	assert.NoError(t, runner.Add("monitoring", machinery.HTTPComponent(&http.Server{Addr: "127.0.0.1:0"})))
+	assert.NoError(t, runner.Add("worker", machinery.WorkerComponent(server.NewWorker("test_worker", 1)), "scheduler"))
+
+	// The recording broker stops consuming at once, which stops the runner
+	done := make(chan error)
+	go func() { done <- runner.Run() }()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the runner didn't stop once the worker stopped")
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"github.com/google/uuid"
 	"github.com/robfig/cron/v3"
 
+	"github.com/RichardKnop/machinery/v2/backends/encrypted"
+	"github.com/RichardKnop/machinery/v2/backends/events"
 	"github.com/RichardKnop/machinery/v2/backends/result"
 	"github.com/RichardKnop/machinery/v2/config"
 	"github.com/RichardKnop/machinery/v2/log"
@@ -33,6 +35,42 @@ type Server struct {
 	lock              lockiface.Lock
 	scheduler         *cron.Cron
 	prePublishHandler func(*tasks.Signature)
+	encryptor         *tasks.Encryptor
+	queueEncryptors   map[string]*tasks.Encryptor
+	defaultHeaders    tasks.Headers
+	propagatedHeaders []string
+	periodic          periodicRuns
+	stateEventsQueue  string
+	codec             tasks.Codec
+	taskMiddleware    []TaskMiddleware
+	// logger and tracer replace the package level logger and the global
+	// tracer if set, so servers in the same process don't mix up, and so
+	// does propagation, see SetPropagation
+	logger      *log.Logger
+	tracer      opentracing.Tracer
+	propagation *tracing.Propagation
+	// logLevel filters the lines the server and its workers log, see
+	// SetLogLevel
+	logLevel log.LevelVar
+	// debugToggle remembers the level to switch back to once DEBUG logging
+	// is toggled off, see toggleDebugLogging
+	debugToggle struct {
+		sync.Mutex
+		on       bool
+		previous log.Level
+		watch    sync.Once
+	}
+	// registerMu serialises registering and unregistering tasks, so the
+	// broker's task names match the registered tasks
+	registerMu sync.Mutex
+	// taskVersions keeps the handlers of versions of tasks, versionRoutes
+	// the versions tasks published for a version run with and sendVersions
+	// the versions tasks are published for, see RegisterTaskVersion
+	taskVersions  sync.Map
+	versionRoutes sync.Map
+	sendVersions  sync.Map
+	// routing keeps the rules routing tasks to queues, see RouteTasks
+	routing routingRules
 }
 
 // NewServer creates Server instance
@@ -79,7 +117,16 @@ func (server *Server) GetBroker() brokersiface.Broker {
 
 // SetBroker sets broker
 func (server *Server) SetBroker(broker brokersiface.Broker) {
+	if codecBroker, ok := broker.(brokersiface.CodecBroker); ok && server.codec != nil {
+		codecBroker.SetCodec(server.codec)
+	}
+	if loggerBroker, ok := broker.(brokersiface.LoggerBroker); ok && server.logger != nil {
+		loggerBroker.SetLogger(server.componentLogger())
+	}
 	server.broker = broker
+	if server.stateEventsQueue != "" {
+		server.SetBackend(server.baseBackend())
+	}
 }
 
 // GetBackend returns backend
@@ -89,7 +136,205 @@ func (server *Server) GetBackend() backendsiface.Backend {
 
 // SetBackend sets backend
 func (server *Server) SetBackend(backend backendsiface.Backend) {
+	if codecBackend, ok := backend.(backendsiface.CodecBackend); ok && server.codec != nil {
+		codecBackend.SetCodec(server.codec)
+	}
+	if server.stateEventsQueue != "" && backend != nil {
+		backend = events.New(backend, server.broker, server.stateEventsQueue)
+	}
+	if decryptor := server.decryptor(); decryptor != nil && backend != nil {
+		backend = encrypted.NewWithPolicy(backend, decryptor, server.encryptorFor)
+	}
 	server.backend = backend
+	if loggerBackend, ok := server.innerBackend().(backendsiface.LoggerBackend); ok && server.logger != nil {
+		loggerBackend.SetLogger(server.componentLogger())
+	}
+}
+
+// SetEncryption enables encryption of task arguments and results with data
+// keys wrapped by the key provider. Both the senders and the workers must
+// use the same keys, so enable it everywhere before sending tasks.
+func (server *Server) SetEncryption(keys tasks.KeyProvider) {
+	backend := server.baseBackend()
+
+	server.encryptor = nil
+	if keys != nil {
+		server.encryptor = &tasks.Encryptor{Keys: keys}
+	}
+	server.SetBackend(backend)
+}
+
+// SetQueueEncryption overrides SetEncryption for tasks routed to the queue,
+// e.g. to keep a legacy queue unencrypted while a new one is encrypted. Nil
+// keys turn encryption off on the queue. Workers decrypt tasks and results
+// with the keys of any queue.
+func (server *Server) SetQueueEncryption(queue string, keys tasks.KeyProvider) {
+	backend := server.baseBackend()
+
+	if server.queueEncryptors == nil {
+		server.queueEncryptors = make(map[string]*tasks.Encryptor)
+	}
+	server.queueEncryptors[queue] = nil
+	if keys != nil {
+		server.queueEncryptors[queue] = &tasks.Encryptor{Keys: keys}
+	}
+	server.SetBackend(backend)
+}
+
+// SetStateEvents enables publishing an event to the queue every time the
+// state of a task changes, for listeners created with NewStateListener. An
+// empty queue turns it off. Results are published as they are stored, i.e.
+// encrypted if encryption is enabled.
+func (server *Server) SetStateEvents(queue string) {
+	backend := server.baseBackend()
+	server.stateEventsQueue = queue
+	server.SetBackend(backend)
+}
+
+// SetCodec makes the broker and the result backend encode tasks and task
+// states with the codec instead of the one of the configured wire format,
+// e.g. to use msgpack. Senders and workers must all be able to decode what
+// the others encode, so set it everywhere before sending tasks. Nil switches
+// back to the configured wire format. Brokers and backends with a format of
+// their own, e.g. MongoDB, ignore it.
+func (server *Server) SetCodec(codec tasks.Codec) {
+	server.codec = codec
+	if codecBroker, ok := server.broker.(brokersiface.CodecBroker); ok {
+		codecBroker.SetCodec(codec)
+	}
+	if codecBackend, ok := server.baseBackend().(backendsiface.CodecBackend); ok {
+		codecBackend.SetCodec(codec)
+	}
+}
+
+// GetCodec returns the codec set with SetCodec, nil if the configured wire
+// format is used
+func (server *Server) GetCodec() tasks.Codec {
+	return server.codec
+}
+
+// innerBackend returns the backend without the encryption of results
+func (server *Server) innerBackend() backendsiface.Backend {
+	if encryptedBackend, ok := server.backend.(*encrypted.Backend); ok {
+		return encryptedBackend.Unwrap()
+	}
+	return server.backend
+}
+
+// baseBackend returns the backend without the encryption of results and the
+// publishing of state events
+func (server *Server) baseBackend() backendsiface.Backend {
+	backend := server.innerBackend()
+	if eventsBackend, ok := backend.(*events.Backend); ok {
+		return eventsBackend.Unwrap()
+	}
+	return backend
+}
+
+// SetLogger makes the server, its workers, the broker and the result backend
+// log to the logger instead of the package level loggers of the log package,
+// e.g. to tell apart servers of different clusters in the same process.
+// Brokers and backends not embedding common.Broker or common.Backend keep
+// logging to the package level loggers. Nil logs to them again.
+func (server *Server) SetLogger(logger *log.Logger) {
+	server.logger = logger
+	if loggerBroker, ok := server.broker.(brokersiface.LoggerBroker); ok {
+		loggerBroker.SetLogger(server.componentLogger())
+	}
+	if loggerBackend, ok := server.innerBackend().(backendsiface.LoggerBackend); ok {
+		loggerBackend.SetLogger(server.componentLogger())
+	}
+}
+
+// componentLogger returns the logger of the broker and the result backend,
+// filtered by the server's level, nil without SetLogger
+func (server *Server) componentLogger() *log.Logger {
+	if server.logger == nil {
+		return nil
+	}
+	return log.Filter(server.logger, &server.logLevel)
+}
+
+// SetLogLevel makes the server and its workers drop the lines logged below
+// the level. It may be called while workers run, e.g. to debug an incident,
+// see also toggleDebugLogging. Every line is logged by default.
+func (server *Server) SetLogLevel(level log.Level) {
+	server.debugToggle.Lock()
+	defer server.debugToggle.Unlock()
+	server.debugToggle.on = false
+	server.logLevel.Set(level)
+}
+
+// LogLevel returns the level below which lines are dropped
+func (server *Server) LogLevel() log.Level {
+	return server.logLevel.Level()
+}
+
+// toggleDebugLogging switches the server to logging every line, or back to
+// the level it logged at before
+func (server *Server) toggleDebugLogging() log.Level {
+	server.debugToggle.Lock()
+	defer server.debugToggle.Unlock()
+
+	if server.debugToggle.on {
+		server.logLevel.Set(server.debugToggle.previous)
+	} else {
+		server.debugToggle.previous = server.logLevel.Level()
+		server.logLevel.Set(log.LevelDebug)
+	}
+	server.debugToggle.on = !server.debugToggle.on
+	return server.logLevel.Level()
+}
+
+// Logger returns the logger set with SetLogger, the package level loggers
+// otherwise, filtered by the server's level, e.g. for packages serving the
+// server's tasks to log with it
+func (server *Server) Logger() *log.Logger {
+	return server.log()
+}
+
+// log returns the logger of the server
+func (server *Server) log() *log.Logger {
+	logger := server.logger
+	if logger == nil {
+		logger = log.Default()
+	}
+	if server.logLevel.Level() == log.LevelDebug {
+		return logger
+	}
+	return log.Filter(logger, &server.logLevel)
+}
+
+// SetTracer makes the server and its workers trace sent and processed tasks
+// with the tracer instead of the global tracer
+func (server *Server) SetTracer(tracer opentracing.Tracer) {
+	server.tracer = tracer
+}
+
+// GetTracer returns the tracer of the server, the global tracer unless
+// SetTracer set one
+func (server *Server) GetTracer() opentracing.Tracer {
+	if server.tracer != nil {
+		return server.tracer
+	}
+	return opentracing.GlobalTracer()
+}
+
+// SetPropagation makes workers of the server understand trace headers of
+// other vendors, see tracing.Propagation. The target must match the format
+// of the server's tracer. Call without arguments to disable translation.
+func (server *Server) SetPropagation(target tracing.Propagator, sources ...tracing.Propagator) {
+	if target == nil {
+		server.propagation = nil
+		return
+	}
+	server.propagation = &tracing.Propagation{Target: target, Sources: sources}
+}
+
+// GetPropagation returns the propagation set by SetPropagation, nil if
+// there is none
+func (server *Server) GetPropagation() *tracing.Propagation {
+	return server.propagation
 }
 
 // GetConfig returns connection object
@@ -107,13 +352,31 @@ func (server *Server) SetPreTaskHandler(handler func(*tasks.Signature)) {
 	server.prePublishHandler = handler
 }
 
-// RegisterTasks registers all tasks at once
+// SetDefaultHeaders sets headers added to every task the server sends, e.g.
+// the name and deploy version of the sending service. Headers already set on
+// a task are kept.
+func (server *Server) SetDefaultHeaders(headers tasks.Headers) {
+	server.defaultHeaders = headers
+}
+
+// SetPropagatedHeaders sets names of headers copied from a task to the tasks
+// it spawns, i.e. tasks sent with the task's context and its callbacks, for
+// end-to-end provenance. Headers already set on a spawned task are kept.
+func (server *Server) SetPropagatedHeaders(names ...string) {
+	server.propagatedHeaders = names
+}
+
+// RegisterTasks registers all tasks at once. Tasks may be registered while
+// workers are running, they consume them from then on.
 func (server *Server) RegisterTasks(namedTaskFuncs map[string]interface{}) error {
 	for _, task := range namedTaskFuncs {
 		if err := tasks.ValidateTask(task); err != nil {
 			return err
 		}
 	}
+
+	server.registerMu.Lock()
+	defer server.registerMu.Unlock()
 	for k, v := range namedTaskFuncs {
 		server.registeredTasks.Store(k, v)
 	}
@@ -126,15 +389,29 @@ func (server *Server) RegisterTask(name string, taskFunc interface{}) error {
 	if err := tasks.ValidateTask(taskFunc); err != nil {
 		return err
 	}
+
+	server.registerMu.Lock()
+	defer server.registerMu.Unlock()
 	server.registeredTasks.Store(name, taskFunc)
 	server.broker.SetRegisteredTaskNames(server.GetRegisteredTaskNames())
 	return nil
 }
 
-// IsTaskRegistered returns true if the task name is registered with this broker
+// UnregisterTask unregisters a task, running workers stop consuming it and
+// leave new deliveries to workers which still have it registered. Runs of
+// the task already in progress complete.
+func (server *Server) UnregisterTask(name string) {
+	server.registerMu.Lock()
+	defer server.registerMu.Unlock()
+	server.registeredTasks.Delete(name)
+	server.broker.SetRegisteredTaskNames(server.GetRegisteredTaskNames())
+}
+
+// IsTaskRegistered returns true if the task name is registered with this
+// broker, or a version of it
 func (server *Server) IsTaskRegistered(name string) bool {
 	_, ok := server.registeredTasks.Load(name)
-	return ok
+	return ok || server.isTaskVersioned(name)
 }
 
 // GetRegisteredTask returns registered task by name
@@ -148,9 +425,13 @@ func (server *Server) GetRegisteredTask(name string) (interface{}, error) {
 
 // SendTaskWithContext will inject the trace context in the signature headers before publishing it
 func (server *Server) SendTaskWithContext(ctx context.Context, signature *tasks.Signature) (*result.AsyncResult, error) {
-	span, _ := opentracing.StartSpanFromContext(ctx, "SendTask", tracing.ProducerOption(), tracing.MachineryTag)
+	span, _ := opentracing.StartSpanFromContextWithTracer(ctx, server.GetTracer(), "SendTask", tracing.ProducerOption(), tracing.MachineryTag)
 	defer span.Finish()
 
+	server.applyHeaders(ctx, signature)
+	server.stampVersion(signature)
+	server.route(signature)
+
 	// tag the span with some info about the signature
 	signature.Headers = tracing.HeadersWithSpan(signature.Headers, span)
 
@@ -159,14 +440,54 @@ func (server *Server) SendTaskWithContext(ctx context.Context, signature *tasks.
 		return nil, errors.New("Result backend required")
 	}
 
+	if signature.RetryPolicy != nil {
+		if err := signature.RetryPolicy.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
 	// Auto generate a UUID if not set already
 	if signature.UUID == "" {
 		taskID := uuid.New().String()
 		signature.UUID = fmt.Sprintf("task_%v", taskID)
 	}
 
+	// Unique keys hash the arguments before they are compressed or
+	// encrypted
+	if err := signature.SetUniqueKey(); err != nil {
+		return nil, err
+	}
+
+	if err := server.encodeArgs(signature); err != nil {
+		return nil, err
+	}
+
+	// Tasks sharing an idempotency key are only sent once
+	if signature.IdempotencyKey != "" {
+		duplicate, err := server.claimIdempotencyKey(signature)
+		if err != nil || duplicate != nil {
+			return duplicate, err
+		}
+	}
+
+	// Only one task with a unique key is pending or running at a time
+	if signature.UniqueKey != "" {
+		existing, err := server.claimUniqueKey(signature)
+		if err != nil || existing != nil {
+			server.releaseIdempotencyKey(signature)
+			return existing, err
+		}
+	}
+
+	// Retries are sent again by the worker which ran the task, so a new
+	// run starts
+	enqueuedAt := time.Now().UTC()
+	signature.EnqueuedAt, signature.StartedAt = &enqueuedAt, nil
+
 	// Set initial task state to PENDING
 	if err := server.backend.SetStatePending(signature); err != nil {
+		server.releaseIdempotencyKey(signature)
+		server.releaseUniqueKey(signature)
 		return nil, fmt.Errorf("Set state pending error: %s", err)
 	}
 
@@ -175,6 +496,8 @@ func (server *Server) SendTaskWithContext(ctx context.Context, signature *tasks.
 	}
 
 	if err := server.broker.Publish(ctx, signature); err != nil {
+		server.releaseIdempotencyKey(signature)
+		server.releaseUniqueKey(signature)
 		return nil, fmt.Errorf("Publish message error: %s", err)
 	}
 
@@ -188,17 +511,33 @@ func (server *Server) SendTask(signature *tasks.Signature) (*result.AsyncResult,
 
 // SendChainWithContext will inject the trace context in all the signature headers before publishing it
 func (server *Server) SendChainWithContext(ctx context.Context, chain *tasks.Chain) (*result.ChainAsyncResult, error) {
-	span, _ := opentracing.StartSpanFromContext(ctx, "SendChain", tracing.ProducerOption(), tracing.MachineryTag, tracing.WorkflowChainTag)
+	span, _ := opentracing.StartSpanFromContextWithTracer(ctx, server.GetTracer(), "SendChain", tracing.ProducerOption(), tracing.MachineryTag, tracing.WorkflowChainTag)
 	defer span.Finish()
 
 	tracing.AnnotateSpanWithChainInfo(span, chain)
 
-	return server.SendChain(chain)
+	_, err := server.SendTaskWithContext(ctx, chain.Tasks[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return result.NewChainAsyncResult(chain.Tasks, server.backend), nil
 }
 
 // SendChain triggers a chain of tasks
 func (server *Server) SendChain(chain *tasks.Chain) (*result.ChainAsyncResult, error) {
-	_, err := server.SendTask(chain.Tasks[0])
+	return server.SendChainWithContext(context.Background(), chain)
+}
+
+// SendSagaWithContext will inject the trace context in all the signature headers before publishing it
+func (server *Server) SendSagaWithContext(ctx context.Context, saga *tasks.Saga) (*result.ChainAsyncResult, error) {
+	span, _ := opentracing.StartSpanFromContextWithTracer(ctx, server.GetTracer(), "SendSaga", tracing.ProducerOption(), tracing.MachineryTag, tracing.WorkflowSagaTag)
+	defer span.Finish()
+
+	chain := &tasks.Chain{Tasks: saga.Tasks()}
+	tracing.AnnotateSpanWithChainInfo(span, chain)
+
+	_, err := server.SendTaskWithContext(ctx, chain.Tasks[0])
 	if err != nil {
 		return nil, err
 	}
@@ -206,9 +545,95 @@ func (server *Server) SendChain(chain *tasks.Chain) (*result.ChainAsyncResult, e
 	return result.NewChainAsyncResult(chain.Tasks, server.backend), nil
 }
 
+// SendSaga triggers a saga of tasks, compensating the completed steps if a
+// step fails
+func (server *Server) SendSaga(saga *tasks.Saga) (*result.ChainAsyncResult, error) {
+	return server.SendSagaWithContext(context.Background(), saga)
+}
+
+// SendMapReduceWithContext will inject the trace context in all the signature headers before publishing it
+func (server *Server) SendMapReduceWithContext(ctx context.Context, mapReduce *tasks.MapReduce) (*result.AsyncResult, error) {
+	span, _ := opentracing.StartSpanFromContextWithTracer(ctx, server.GetTracer(), "SendMapReduce", tracing.ProducerOption(), tracing.MachineryTag, tracing.WorkflowMapReduceTag)
+	defer span.Finish()
+
+	for _, signature := range []*tasks.Signature{mapReduce.Source, mapReduce.Mapper, mapReduce.Reducer} {
+		signature.Headers = tracing.HeadersWithSpan(signature.Headers, span)
+	}
+
+	_, err := server.SendTaskWithContext(ctx, mapReduce.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.NewAsyncResult(mapReduce.Reducer, server.backend), nil
+}
+
+// SendMapReduce triggers a map/reduce, the result is the reducer's
+func (server *Server) SendMapReduce(mapReduce *tasks.MapReduce) (*result.AsyncResult, error) {
+	return server.SendMapReduceWithContext(context.Background(), mapReduce)
+}
+
+// SendFlowWithContext will inject the trace context in all the signature headers before publishing it
+func (server *Server) SendFlowWithContext(ctx context.Context, flow *tasks.Flow) ([]*result.AsyncResult, error) {
+	span, _ := opentracing.StartSpanFromContextWithTracer(ctx, server.GetTracer(), "SendFlow", tracing.ProducerOption(), tracing.MachineryTag, tracing.WorkflowFlowTag)
+	defer span.Finish()
+
+	tracing.AnnotateSpanWithFlowInfo(span, flow)
+
+	// Make sure result backend is defined
+	if server.backend == nil {
+		return nil, errors.New("Result backend required")
+	}
+
+	// Tasks of a group are sent by different tasks of the flow, so groups
+	// are stored before any of them may complete
+	for _, group := range flow.Groups {
+		if err := server.initGroupPending(group); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, signature := range flow.Starts {
+		if _, err := server.SendTaskWithContext(ctx, signature); err != nil {
+			return nil, err
+		}
+	}
+
+	asyncResults := make([]*result.AsyncResult, len(flow.Ends))
+	for i, signature := range flow.Ends {
+		asyncResults[i] = result.NewAsyncResult(signature, server.backend)
+	}
+	return asyncResults, nil
+}
+
+// SendFlow triggers a nested workflow, the results are those of the tasks
+// ending it
+func (server *Server) SendFlow(flow *tasks.Flow) ([]*result.AsyncResult, error) {
+	return server.SendFlowWithContext(context.Background(), flow)
+}
+
+// initGroupPending stores the group meta data and Pending states of the
+// tasks, in a single call if the backend supports batching
+func (server *Server) initGroupPending(group *tasks.Group) error {
+	if batchBackend, ok := server.innerBackend().(backendsiface.BatchBackend); ok {
+		return batchBackend.InitGroupPending(group.GroupUUID, group.Tasks)
+	}
+
+	if err := server.backend.InitGroup(group.GroupUUID, group.GetUUIDs()); err != nil {
+		return err
+	}
+
+	for _, signature := range group.Tasks {
+		if err := server.backend.SetStatePending(signature); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // SendGroupWithContext will inject the trace context in all the signature headers before publishing it
 func (server *Server) SendGroupWithContext(ctx context.Context, group *tasks.Group, sendConcurrency int) ([]*result.AsyncResult, error) {
-	span, _ := opentracing.StartSpanFromContext(ctx, "SendGroup", tracing.ProducerOption(), tracing.MachineryTag, tracing.WorkflowGroupTag)
+	span, _ := opentracing.StartSpanFromContextWithTracer(ctx, server.GetTracer(), "SendGroup", tracing.ProducerOption(), tracing.MachineryTag, tracing.WorkflowGroupTag)
 	defer span.Finish()
 
 	tracing.AnnotateSpanWithGroupInfo(span, group, sendConcurrency)
@@ -222,17 +647,33 @@ func (server *Server) SendGroupWithContext(ctx context.Context, group *tasks.Gro
 
 	var wg sync.WaitGroup
 	wg.Add(len(group.Tasks))
-	errorsChan := make(chan error, len(group.Tasks)*2)
+	errorsChan := make(chan error, len(group.Tasks)*2+1)
 
-	// Init group
-	server.backend.InitGroup(group.GroupUUID, group.GetUUIDs())
+	if group.FailurePolicy != nil {
+		if err := group.FailurePolicy.Validate(); err != nil {
+			return nil, err
+		}
+	}
 
-	// Init the tasks Pending state first
+	// Tasks are encoded before any of them is stored or published, a group
+	// with a task which can't be sent isn't sent at all
+	enqueuedAt := time.Now().UTC()
 	for _, signature := range group.Tasks {
-		if err := server.backend.SetStatePending(signature); err != nil {
-			errorsChan <- err
-			continue
+		signature.EnqueuedAt = &enqueuedAt
+		if group.FailurePolicy != nil {
+			signature.GroupFailurePolicy = group.FailurePolicy
 		}
+		server.applyHeaders(ctx, signature)
+		server.stampVersion(signature)
+		server.route(signature)
+		if err := server.encodeArgs(signature); err != nil {
+			return nil, err
+		}
+	}
+
+	// Init group and the tasks Pending state first
+	if err := server.initGroupPending(group); err != nil {
+		return nil, err
 	}
 
 	pool := make(chan struct{}, sendConcurrency)
@@ -289,16 +730,36 @@ func (server *Server) SendGroup(group *tasks.Group, sendConcurrency int) ([]*res
 
 // SendChordWithContext will inject the trace context in all the signature headers before publishing it
 func (server *Server) SendChordWithContext(ctx context.Context, chord *tasks.Chord, sendConcurrency int) (*result.ChordAsyncResult, error) {
-	span, _ := opentracing.StartSpanFromContext(ctx, "SendChord", tracing.ProducerOption(), tracing.MachineryTag, tracing.WorkflowChordTag)
+	span, _ := opentracing.StartSpanFromContextWithTracer(ctx, server.GetTracer(), "SendChord", tracing.ProducerOption(), tracing.MachineryTag, tracing.WorkflowChordTag)
 	defer span.Finish()
 
 	tracing.AnnotateSpanWithChordInfo(span, chord, sendConcurrency)
 
+	if chord.Group.Timeout > 0 && chord.Callback == nil {
+		return nil, errors.New("Group timeout requires a chord callback")
+	}
+
 	_, err := server.SendGroupWithContext(ctx, chord.Group, sendConcurrency)
 	if err != nil {
 		return nil, err
 	}
 
+	if chord.Group.Timeout > 0 {
+		if err := server.sendGroupTimeout(ctx, chord); err != nil {
+			return nil, err
+		}
+	}
+
+	if chord.Continuation != nil {
+		// Wait for the last stage of a multi-stage workflow
+		lastStage := chord.LastStage()
+		return result.NewChordAsyncResult(
+			lastStage.Group.Tasks,
+			lastStage.Callback,
+			server.backend,
+		), nil
+	}
+
 	return result.NewChordAsyncResult(
 		chord.Group.Tasks,
 		chord.Callback,
@@ -311,6 +772,210 @@ func (server *Server) SendChord(chord *tasks.Chord, sendConcurrency int) (*resul
 	return server.SendChordWithContext(context.Background(), chord, sendConcurrency)
 }
 
+// sendGroupTimeout sends a task triggering the chord callback once the group
+// of the chord timed out, unless the group completes before
+func (server *Server) sendGroupTimeout(ctx context.Context, chord *tasks.Chord) error {
+	eta := time.Now().UTC().Add(chord.Group.Timeout)
+	_, err := server.SendTaskWithContext(ctx, &tasks.Signature{
+		Name: tasks.GroupTimeoutTaskName,
+		Args: []tasks.Arg{
+			{Type: "string", Value: chord.Group.GroupUUID},
+			{Type: "int", Value: len(chord.Group.Tasks)},
+		},
+		RoutingKey:    chord.Callback.RoutingKey,
+		ETA:           &eta,
+		ChordCallback: chord.Callback,
+	})
+	if err != nil {
+		return fmt.Errorf("Send group timeout error: %s", err)
+	}
+	return nil
+}
+
+// setStateTimedOut updates task state to TIMED_OUT, or to FAILURE if the
+// result backend is unable to store it
+func (server *Server) setStateTimedOut(signature *tasks.Signature) error {
+	if timeoutBackend, ok := server.innerBackend().(backendsiface.TimeoutBackend); ok {
+		return timeoutBackend.SetStateTimedOut(signature)
+	}
+	return server.backend.SetStateFailure(signature, tasks.ErrGroupTimedOut.Error())
+}
+
+// ListStates returns the stored task states matching the filter, newest
+// first, e.g. to find tasks which failed in the last hour. Not every result
+// backend supports it.
+func (server *Server) ListStates(filter *tasks.StateFilter) ([]*tasks.TaskState, error) {
+	query, ok := server.backend.(backendsiface.QueryBackend)
+	if !ok {
+		return nil, errors.New("Result backend does not support listing task states")
+	}
+	if filter == nil {
+		filter = new(tasks.StateFilter)
+	}
+	return query.ListStates(filter)
+}
+
+// lockName returns the name of the lock of a periodic task in the namespace
+func (server *Server) lockName(name, spec string) string {
+	return server.GetConfig().Namespaced(utils.GetLockName(name, spec))
+}
+
+// applyHeaders adds headers propagated from the task sending the signature,
+// if it is sent from within a task, and the default headers to the signature
+func (server *Server) applyHeaders(ctx context.Context, signature *tasks.Signature) {
+	server.inheritHeaders(tasks.SignatureFromContext(ctx), signature)
+	for name, value := range server.defaultHeaders {
+		setHeaderIfMissing(signature, name, value)
+	}
+}
+
+// inheritHeaders copies the propagated headers of the parent task to the
+// signature spawned by it
+func (server *Server) inheritHeaders(parent, signature *tasks.Signature) {
+	if parent == nil {
+		return
+	}
+	for _, name := range server.propagatedHeaders {
+		if value, ok := parent.Headers[name]; ok {
+			setHeaderIfMissing(signature, name, value)
+		}
+	}
+}
+
+func setHeaderIfMissing(signature *tasks.Signature, name string, value interface{}) {
+	if _, ok := signature.Headers[name]; ok {
+		return
+	}
+	if signature.Headers == nil {
+		signature.Headers = make(tasks.Headers)
+	}
+	signature.Headers[name] = value
+}
+
+// retryPolicy returns the retry policy of the signature, the configured one
+// if it has none, nil if retries are delayed by the Fibonacci sequence
+func (server *Server) retryPolicy(signature *tasks.Signature) *tasks.RetryPolicy {
+	if signature.RetryPolicy != nil {
+		return signature.RetryPolicy
+	}
+	cnf := server.GetConfig().RetryPolicy
+	if cnf == nil {
+		return nil
+	}
+	return &tasks.RetryPolicy{
+		Base:       time.Duration(cnf.Base) * time.Second,
+		Multiplier: cnf.Multiplier,
+		Max:        time.Duration(cnf.Max) * time.Second,
+		Jitter:     cnf.Jitter,
+	}
+}
+
+// queueOf returns the queue whose policies apply to the task, the default
+// queue unless it has a routing key
+func (server *Server) queueOf(signature *tasks.Signature) string {
+	if signature.RoutingKey != "" {
+		return signature.RoutingKey
+	}
+	return server.GetConfig().DefaultQueue
+}
+
+// compressor returns the compressor configured for arguments and results of
+// the task, nil if compression is disabled
+func (server *Server) compressor(signature *tasks.Signature) *tasks.Compressor {
+	cnf := server.compressionConfig(signature)
+	if cnf == nil || cnf.Algorithm == "" {
+		return nil
+	}
+
+	return &tasks.Compressor{Algorithm: cnf.Algorithm, Threshold: cnf.Threshold}
+}
+
+// compressionConfig returns the compression configuration of the task's
+// queue, nil if there is none
+func (server *Server) compressionConfig(signature *tasks.Signature) *config.CompressionConfig {
+	if queueCnf, ok := server.GetConfig().QueueCompression[server.queueOf(signature)]; ok {
+		return queueCnf
+	}
+	return server.GetConfig().Compression
+}
+
+// encryptorFor returns the encryptor of arguments and results of the task,
+// nil if encryption is disabled
+func (server *Server) encryptorFor(signature *tasks.Signature) *tasks.Encryptor {
+	if encryptor, ok := server.queueEncryptors[server.queueOf(signature)]; ok {
+		return encryptor
+	}
+	return server.encryptor
+}
+
+// decryptor returns an encryptor able to decrypt arguments and results of
+// tasks of every queue, nil if encryption is disabled everywhere
+func (server *Server) decryptor() *tasks.Encryptor {
+	var keys tasks.KeyProviders
+	if server.encryptor != nil {
+		keys = append(keys, server.encryptor.Keys)
+	}
+	for _, encryptor := range server.queueEncryptors {
+		if encryptor != nil {
+			keys = append(keys, encryptor.Keys)
+		}
+	}
+
+	switch len(keys) {
+	case 0:
+		return nil
+	case 1:
+		return &tasks.Encryptor{Keys: keys[0]}
+	}
+	return &tasks.Encryptor{Keys: keys}
+}
+
+// encodeArgs marks the signature as sent by a client able to decompress
+// results, and compresses its large arguments if the queue opted in, if
+// compression is enabled, then encrypts arguments of the signature and its
+// callbacks, if encryption is enabled, as configured for the task's queue
+func (server *Server) encodeArgs(signature *tasks.Signature) error {
+	// Arguments added to a callback encrypted along with its parent are
+	// compressed and encrypted together with the original ones
+	if decryptor := server.decryptor(); decryptor != nil {
+		if err := decryptor.DecryptArgs(signature); err != nil {
+			return err
+		}
+	}
+
+	if compressor := server.compressor(signature); compressor != nil {
+		tasks.AcceptEncoding(signature)
+		if server.compressionConfig(signature).Args {
+			if err := compressor.CompressArgs(signature); err != nil {
+				return fmt.Errorf("Compress arguments error: %s", err)
+			}
+		}
+	}
+
+	if encryptor := server.encryptorFor(signature); encryptor != nil {
+		if err := encryptor.EncryptArgs(signature); err != nil {
+			return fmt.Errorf("Encrypt arguments error: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// decodeArgs restores arguments encoded by encodeArgs of the sender
+func (server *Server) decodeArgs(signature *tasks.Signature) error {
+	if signature.Headers[tasks.ArgsEncryptionHeader] != nil {
+		decryptor := server.decryptor()
+		if decryptor == nil {
+			return fmt.Errorf("Arguments of task %s are encrypted but encryption is not enabled", signature.UUID)
+		}
+		if err := decryptor.DecryptArgs(signature); err != nil {
+			return err
+		}
+	}
+
+	return tasks.DecompressArgs(signature)
+}
+
 // GetRegisteredTaskNames returns slice of registered task names
 func (server *Server) GetRegisteredTaskNames() []string {
 	taskNames := make([]string, 0)
@@ -319,119 +984,107 @@ func (server *Server) GetRegisteredTaskNames() []string {
 		taskNames = append(taskNames, key.(string))
 		return true
 	})
-	return taskNames
+	// Tasks only registered with versions are consumed as well
+	return append(taskNames, server.versionedTaskNames()...)
 }
 
 // RegisterPeriodicTask register a periodic task which will be triggered periodically
 func (server *Server) RegisterPeriodicTask(spec, name string, signature *tasks.Signature) error {
 	//check spec
-	schedule, err := cron.ParseStandard(spec)
+	schedule, err := parsePeriodicSpec(spec)
 	if err != nil {
 		return err
 	}
 
-	f := func() {
-		//get lock
-		err := server.lock.LockWithRetries(utils.GetLockName(name, spec), schedule.Next(time.Now()).UnixNano()-1)
-		if err != nil {
-			return
+	server.schedulePeriodic(spec, name, schedule, func() error {
+		task := tasks.CopySignature(signature)
+		if server.skipPeriodic(name, task) {
+			return nil
 		}
+		task.Singleton = server.singleton(name, schedule)
 
 		//send task
-		_, err = server.SendTask(tasks.CopySignature(signature))
-		if err != nil {
-			log.ERROR.Printf("periodic task failed. task name is: %s. error is %s", name, err.Error())
+		if _, err := server.SendTask(task); err != nil {
+			return err
 		}
-	}
-
-	_, err = server.scheduler.AddFunc(spec, f)
-	return err
+		server.recordPeriodicRun(name, task.UUID)
+		return nil
+	})
+	return nil
 }
 
 // RegisterPeriodicChain register a periodic chain which will be triggered periodically
 func (server *Server) RegisterPeriodicChain(spec, name string, signatures ...*tasks.Signature) error {
 	//check spec
-	schedule, err := cron.ParseStandard(spec)
+	schedule, err := parsePeriodicSpec(spec)
 	if err != nil {
 		return err
 	}
 
-	f := func() {
+	server.schedulePeriodic(spec, name, schedule, func() error {
 		// new chain
 		chain, _ := tasks.NewChain(tasks.CopySignatures(signatures...)...)
-
-		//get lock
-		err := server.lock.LockWithRetries(utils.GetLockName(name, spec), schedule.Next(time.Now()).UnixNano()-1)
-		if err != nil {
-			return
+		if server.skipPeriodic(name, chain.Tasks[0]) {
+			return nil
 		}
 
 		//send task
-		_, err = server.SendChain(chain)
-		if err != nil {
-			log.ERROR.Printf("periodic task failed. task name is: %s. error is %s", name, err.Error())
+		if _, err := server.SendChain(chain); err != nil {
+			return err
 		}
-	}
-
-	_, err = server.scheduler.AddFunc(spec, f)
-	return err
+		server.recordPeriodicRun(name, chain.Tasks[len(chain.Tasks)-1].UUID)
+		return nil
+	})
+	return nil
 }
 
 // RegisterPeriodicGroup register a periodic group which will be triggered periodically
 func (server *Server) RegisterPeriodicGroup(spec, name string, sendConcurrency int, signatures ...*tasks.Signature) error {
 	//check spec
-	schedule, err := cron.ParseStandard(spec)
+	schedule, err := parsePeriodicSpec(spec)
 	if err != nil {
 		return err
 	}
 
-	f := func() {
+	server.schedulePeriodic(spec, name, schedule, func() error {
 		// new group
 		group, _ := tasks.NewGroup(tasks.CopySignatures(signatures...)...)
-
-		//get lock
-		err := server.lock.LockWithRetries(utils.GetLockName(name, spec), schedule.Next(time.Now()).UnixNano()-1)
-		if err != nil {
-			return
+		if server.skipPeriodic(name, group.Tasks[0]) {
+			return nil
 		}
 
 		//send task
-		_, err = server.SendGroup(group, sendConcurrency)
-		if err != nil {
-			log.ERROR.Printf("periodic task failed. task name is: %s. error is %s", name, err.Error())
+		if _, err := server.SendGroup(group, sendConcurrency); err != nil {
+			return err
 		}
-	}
-
-	_, err = server.scheduler.AddFunc(spec, f)
-	return err
+		server.recordPeriodicRun(name, group.GetUUIDs()...)
+		return nil
+	})
+	return nil
 }
 
 // RegisterPeriodicChord register a periodic chord which will be triggered periodically
 func (server *Server) RegisterPeriodicChord(spec, name string, sendConcurrency int, callback *tasks.Signature, signatures ...*tasks.Signature) error {
 	//check spec
-	schedule, err := cron.ParseStandard(spec)
+	schedule, err := parsePeriodicSpec(spec)
 	if err != nil {
 		return err
 	}
 
-	f := func() {
+	server.schedulePeriodic(spec, name, schedule, func() error {
 		// new chord
 		group, _ := tasks.NewGroup(tasks.CopySignatures(signatures...)...)
 		chord, _ := tasks.NewChord(group, tasks.CopySignature(callback))
-
-		//get lock
-		err := server.lock.LockWithRetries(utils.GetLockName(name, spec), schedule.Next(time.Now()).UnixNano()-1)
-		if err != nil {
-			return
+		if server.skipPeriodic(name, group.Tasks[0]) {
+			return nil
 		}
 
 		//send task
-		_, err = server.SendChord(chord, sendConcurrency)
-		if err != nil {
-			log.ERROR.Printf("periodic task failed. task name is: %s. error is %s", name, err.Error())
+		if _, err := server.SendChord(chord, sendConcurrency); err != nil {
+			return err
 		}
-	}
-
-	_, err = server.scheduler.AddFunc(spec, f)
-	return err
+		server.recordPeriodicRun(name, chord.Callback.UUID)
+		return nil
+	})
+	return nil
 }
@@ -1,16 +1,27 @@
 package machinery_test
 
 import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/RichardKnop/machinery/v2"
+	"github.com/RichardKnop/machinery/v2/backends/iface"
+	"github.com/RichardKnop/machinery/v2/backends/memory"
+	"github.com/RichardKnop/machinery/v2/common"
 	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/tasks"
 
 	backend "github.com/RichardKnop/machinery/v2/backends/eager"
 	broker "github.com/RichardKnop/machinery/v2/brokers/eager"
 	lock "github.com/RichardKnop/machinery/v2/locks/eager"
+	lockiface "github.com/RichardKnop/machinery/v2/locks/iface"
+	memorylock "github.com/RichardKnop/machinery/v2/locks/memory"
 )
 
 func TestRegisterTasks(t *testing.T) {
@@ -59,6 +70,44 @@ func TestGetRegisteredTaskNames(t *testing.T) {
 	assert.Equal(t, taskName, taskNames[0])
 }
 
+func TestUnregisterTask(t *testing.T) {
+	t.Parallel()
+
+	server := getTestServer(t)
+	err := server.RegisterTasks(map[string]interface{}{
+		"test_task":  func() error { return nil },
+		"other_task": func() error { return nil },
+	})
+	assert.NoError(t, err)
+
+	server.UnregisterTask("test_task")
+	assert.False(t, server.IsTaskRegistered("test_task"))
+	assert.False(t, server.GetBroker().IsTaskRegistered("test_task"))
+	assert.True(t, server.GetBroker().IsTaskRegistered("other_task"))
+}
+
+func TestRegisterTaskConcurrently(t *testing.T) {
+	t.Parallel()
+
+	server := getTestServer(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			assert.NoError(t, server.RegisterTask(name, func() error { return nil }))
+			assert.NoError(t, server.RegisterTask(name+"_unregistered", func() error { return nil }))
+			server.UnregisterTask(name + "_unregistered")
+		}(fmt.Sprintf("test_task_%d", i))
+	}
+	wg.Wait()
+
+	// The broker doesn't miss any change
+	assert.ElementsMatch(t, server.GetRegisteredTaskNames(), server.GetBroker().(*broker.Broker).GetRegisteredTaskNames())
+	assert.Len(t, server.GetRegisteredTaskNames(), 20)
+}
+
 func TestNewWorker(t *testing.T) {
 	t.Parallel()
 
@@ -77,6 +126,516 @@ func TestNewCustomQueueWorker(t *testing.T) {
 	assert.NoError(t, nil)
 }
 
+// batchingBackend records batched group initializations and pending states
+// set one by one
+type batchingBackend struct {
+	iface.Backend
+	batches   [][]string
+	unbatched int
+}
+
+func (b *batchingBackend) InitGroupPending(groupUUID string, signatures []*tasks.Signature) error {
+	taskUUIDs := make([]string, len(signatures))
+	for i, signature := range signatures {
+		taskUUIDs[i] = signature.UUID
+		if err := b.Backend.SetStatePending(signature); err != nil {
+			return err
+		}
+	}
+	b.batches = append(b.batches, taskUUIDs)
+	return b.InitGroup(groupUUID, taskUUIDs)
+}
+
+func (b *batchingBackend) SetStatePending(signature *tasks.Signature) error {
+	b.unbatched++
+	return b.Backend.SetStatePending(signature)
+}
+
+func TestSendGroupBatchesPendingStates(t *testing.T) {
+	t.Parallel()
+
+	server, _ := newRecordingServer(t)
+	batching := &batchingBackend{Backend: backend.New()}
+	server.SetBackend(batching)
+
+	group, err := tasks.NewGroup(&tasks.Signature{Name: "foo"}, &tasks.Signature{Name: "foo"})
+	assert.NoError(t, err)
+	_, err = server.SendGroup(group, 0)
+	assert.NoError(t, err)
+
+	assert.Equal(t, [][]string{group.GetUUIDs()}, batching.batches)
+	assert.Equal(t, 0, batching.unbatched)
+	for _, taskUUID := range group.GetUUIDs() {
+		state, err := batching.GetState(taskUUID)
+		if assert.NoError(t, err) {
+			assert.Equal(t, tasks.StatePending, state.State)
+		}
+	}
+}
+
+func TestListStates(t *testing.T) {
+	t.Parallel()
+
+	server, _ := newRecordingServer(t)
+	_, err := server.ListStates(nil)
+	assert.Error(t, err, "the eager backend does not support listing task states")
+
+	server.SetBackend(memory.New(nil))
+	asyncResult, err := server.SendTask(&tasks.Signature{Name: "foo"})
+	assert.NoError(t, err)
+
+	taskStates, err := server.ListStates(&tasks.StateFilter{States: []string{tasks.StatePending}})
+	if assert.NoError(t, err) && assert.Len(t, taskStates, 1) {
+		assert.Equal(t, asyncResult.Signature.UUID, taskStates[0].TaskUUID)
+		assert.Equal(t, "foo", taskStates[0].TaskName)
+	}
+}
+
+// countingCodec counts the tasks and task states it encodes
+type countingCodec struct {
+	tasks.Codec
+	signatures, states int32
+}
+
+func (c *countingCodec) EncodeSignature(signature *tasks.Signature) ([]byte, error) {
+	atomic.AddInt32(&c.signatures, 1)
+	return c.Codec.EncodeSignature(signature)
+}
+
+func (c *countingCodec) EncodeTaskState(taskState *tasks.TaskState) ([]byte, error) {
+	atomic.AddInt32(&c.states, 1)
+	return c.Codec.EncodeTaskState(taskState)
+}
+
+func TestSetCodec(t *testing.T) {
+	t.Parallel()
+
+	eagerBroker := broker.New()
+	server := machinery.NewServer(new(config.Config), eagerBroker, memory.New(nil), lock.New())
+	eagerBroker.(broker.Mode).AssignWorker(server.NewWorker("test_worker", 1))
+	assert.NoError(t, server.RegisterTask("add", func(a, b int64) (int64, error) { return a + b, nil }))
+
+	codec := &countingCodec{Codec: tasks.NewWireFormatCodec(tasks.WireFormatProtobuf)}
+	server.SetCodec(codec)
+	assert.Equal(t, codec, server.GetCodec())
+
+	asyncResult, err := server.SendTask(&tasks.Signature{
+		Name: "add",
+		Args: []tasks.Arg{{Type: "int64", Value: 1}, {Type: "int64", Value: 2}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := asyncResult.Get(time.Millisecond)
+	if assert.NoError(t, err) && assert.Len(t, results, 1) {
+		assert.Equal(t, int64(3), results[0].Int())
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&codec.signatures))
+	assert.NotZero(t, atomic.LoadInt32(&codec.states))
+
+	// Backends set later get the codec too
+	states := atomic.LoadInt32(&codec.states)
+	server.SetBackend(memory.New(nil))
+	assert.NoError(t, server.GetBackend().SetStatePending(&tasks.Signature{UUID: "task_1", Name: "add"}))
+	assert.Equal(t, states+1, atomic.LoadInt32(&codec.states))
+}
+
 func getTestServer(t *testing.T) *machinery.Server {
 	return machinery.NewServer(&config.Config{}, broker.New(), backend.New(), lock.New())
 }
+
+// freeLock grants every lock, so periodic tasks can run more than once per
+// schedule
+type freeLock struct{}
+
+func (freeLock) LockWithRetries(key string, value int64) error { return nil }
+
+func (freeLock) Lock(key string, value int64) error { return nil }
+
+func newPeriodicServer(t *testing.T) (*machinery.Server, *recordingBroker) {
+	recorder := &recordingBroker{Broker: common.NewBroker(new(config.Config))}
+	server := machinery.NewServer(new(config.Config), recorder, memory.New(new(config.Config)), freeLock{})
+	return server, recorder
+}
+
+func TestPeriodicPolicySkipIfRunning(t *testing.T) {
+	t.Parallel()
+
+	server, recorder := newPeriodicServer(t)
+	err := server.RegisterTask("test_task", func() error { return nil })
+	assert.NoError(t, err)
+
+	server.SetPeriodicPolicy("periodic", &machinery.PeriodicPolicy{SkipIfRunning: true})
+	err = server.RegisterPeriodicTask("0 * * * *", "periodic", &tasks.Signature{Name: "test_task"})
+	assert.NoError(t, err)
+
+	server.RunPeriodic()
+	server.RunPeriodic()
+	signature := recorder.next()
+	assert.NotNil(t, signature)
+	assert.Nil(t, recorder.next())
+
+	assert.NoError(t, server.NewWorker("test_worker", 0).Process(signature))
+	server.RunPeriodic()
+	assert.NotNil(t, recorder.next())
+}
+
+func TestPeriodicPolicyMaxQueueDepth(t *testing.T) {
+	t.Parallel()
+
+	server, recorder := newPeriodicServer(t)
+
+	server.SetPeriodicPolicy("periodic", &machinery.PeriodicPolicy{MaxQueueDepth: 2})
+	err := server.RegisterPeriodicChain("0 * * * *", "periodic", &tasks.Signature{Name: "first"}, &tasks.Signature{Name: "second"})
+	assert.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		server.RunPeriodic()
+	}
+	depth, err := recorder.QueueDepth("")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, depth)
+
+	// Without a policy runs pile up
+	server.SetPeriodicPolicy("periodic", nil)
+	server.RunPeriodic()
+	depth, err = recorder.QueueDepth("")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, depth)
+}
+
+func TestPeriodicSpecs(t *testing.T) {
+	t.Parallel()
+
+	prague, err := time.LoadLocation("Europe/Prague")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	testCases := []struct {
+		spec     string
+		after    time.Time
+		expected time.Time
+	}{
+		// Clocks in Prague move forward on 29 March 2026
+		{
+			spec:     "CRON_TZ=Europe/Prague 0 9 * * *",
+			after:    time.Date(2026, 3, 28, 9, 0, 0, 0, prague),
+			expected: time.Date(2026, 3, 29, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			spec:     "TZ=Europe/Prague 30 2 * * *",
+			after:    time.Date(2026, 3, 28, 3, 0, 0, 0, prague),
+			expected: time.Date(2026, 3, 30, 0, 30, 0, 0, time.UTC),
+		},
+		{
+			spec:     "*/15 * * * * *",
+			after:    time.Date(2026, 1, 1, 12, 0, 1, 0, time.UTC),
+			expected: time.Date(2026, 1, 1, 12, 0, 15, 0, time.UTC),
+		},
+		{
+			spec:     "0 6 * * ?",
+			after:    time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC),
+			expected: time.Date(2026, 1, 2, 6, 0, 0, 0, time.UTC),
+		},
+		{
+			spec:     "CRON_TZ=UTC @hourly",
+			after:    time.Date(2026, 1, 1, 12, 30, 0, 0, time.UTC),
+			expected: time.Date(2026, 1, 1, 13, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, testCase := range testCases {
+		next, err := machinery.NextPeriodicRun(testCase.spec, testCase.after)
+		assert.NoError(t, err, testCase.spec)
+		assert.True(t, testCase.expected.Equal(next), "%s: expected %s, got %s", testCase.spec, testCase.expected, next)
+	}
+
+	_, err = machinery.NextPeriodicRun("CRON_TZ=Nowhere/Special 0 9 * * *", time.Now())
+	assert.Error(t, err)
+
+	server, _ := newPeriodicServer(t)
+	assert.NoError(t, server.RegisterPeriodicTask("CRON_TZ=Europe/Prague 0 0 9 * * MON-FRI", "periodic", &tasks.Signature{Name: "test_task"}))
+	assert.Error(t, server.RegisterPeriodicTask("0 0 0 9 * * MON-FRI", "periodic", &tasks.Signature{Name: "test_task"}))
+}
+
+func TestPeriodicLock(t *testing.T) {
+	t.Parallel()
+
+	// Replicas of a service share the lock
+	sharedLock := memorylock.New()
+	recorder := &recordingBroker{Broker: common.NewBroker(new(config.Config))}
+	servers := make([]*machinery.Server, 3)
+	for i := range servers {
+		servers[i] = machinery.NewServer(new(config.Config), recorder, memory.New(new(config.Config)), sharedLock)
+		assert.NoError(t, servers[i].RegisterPeriodicTask("0 * * * *", "periodic-task", &tasks.Signature{Name: "task"}))
+		assert.NoError(t, servers[i].RegisterPeriodicChain("0 * * * *", "periodic-chain", &tasks.Signature{Name: "first"}, &tasks.Signature{Name: "second"}))
+		assert.NoError(t, servers[i].RegisterPeriodicGroup("0 * * * *", "periodic-group", 0, &tasks.Signature{Name: "first"}, &tasks.Signature{Name: "second"}))
+		assert.NoError(t, servers[i].RegisterPeriodicChord("0 * * * *", "periodic-chord", 0, &tasks.Signature{Name: "callback"}, &tasks.Signature{Name: "first"}, &tasks.Signature{Name: "second"}))
+	}
+
+	for _, server := range servers {
+		server.RunPeriodic()
+	}
+	// The task, the first task of the chain, and the tasks of the group and
+	// of the chord are sent once
+	depth, err := recorder.QueueDepth("")
+	assert.NoError(t, err)
+	assert.Equal(t, 6, depth)
+
+	// A short lock lets the next run through once it expired
+	for _, server := range servers {
+		server.SetPeriodicPolicy("periodic-short", &machinery.PeriodicPolicy{LockDuration: 10 * time.Millisecond})
+		assert.NoError(t, server.RegisterPeriodicTask("0 0 1 1 *", "periodic-short", &tasks.Signature{Name: "short"}))
+	}
+	for _, server := range servers {
+		server.RunPeriodic()
+	}
+	depth, err = recorder.QueueDepth("")
+	assert.NoError(t, err)
+	assert.Equal(t, 7, depth)
+
+	time.Sleep(20 * time.Millisecond)
+	servers[0].RunPeriodic()
+	depth, err = recorder.QueueDepth("")
+	assert.NoError(t, err)
+	assert.Equal(t, 8, depth)
+}
+
+func TestPeriodicCatchUp(t *testing.T) {
+	t.Parallel()
+
+	backend := memory.New(new(config.Config))
+	scheduleBackend := backend.(iface.ScheduleBackend)
+	recorder := &recordingBroker{Broker: common.NewBroker(new(config.Config))}
+	sharedLock := memorylock.New()
+	register := func(name string, catchUp machinery.CatchUpPolicy) {
+		server := machinery.NewServer(new(config.Config), recorder, backend, sharedLock)
+		server.SetPeriodicPolicy(name, &machinery.PeriodicPolicy{CatchUp: catchUp})
+		assert.NoError(t, server.RegisterPeriodicTask("0 * * * *", name, &tasks.Signature{Name: name}))
+	}
+	sent := func(name string) int {
+		count := 0
+		for signature := recorder.next(); signature != nil; signature = recorder.next() {
+			assert.Equal(t, name, signature.Name)
+			count++
+		}
+		return count
+	}
+
+	// A task registered for the first time has no missed runs, when it was
+	// registered is stored instead
+	register("all", machinery.CatchUpAll)
+	assert.Equal(t, 0, sent("all"))
+	lastRun, err := scheduleBackend.LastRun("all")
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), lastRun, time.Minute)
+
+	// The server was down for three hours
+	assert.NoError(t, scheduleBackend.SetLastRun("all", time.Now().Add(-3*time.Hour)))
+	register("all", machinery.CatchUpAll)
+	assert.Equal(t, 3, sent("all"))
+	register("all", machinery.CatchUpAll)
+	assert.Equal(t, 0, sent("all"))
+
+	assert.NoError(t, scheduleBackend.SetLastRun("once", time.Now().Add(-3*time.Hour)))
+	register("once", machinery.CatchUpOnce)
+	assert.Equal(t, 1, sent("once"))
+
+	assert.NoError(t, scheduleBackend.SetLastRun("skip", time.Now().Add(-3*time.Hour)))
+	register("skip", machinery.CatchUpSkip)
+	assert.Equal(t, 0, sent("skip"))
+}
+
+func TestPeriodicJitter(t *testing.T) {
+	t.Parallel()
+
+	server, _ := newPeriodicServer(t)
+	server.SetPeriodicPolicy("jittered", &machinery.PeriodicPolicy{Jitter: 30 * time.Second})
+	assert.NoError(t, server.RegisterPeriodicTask("*/5 * * * *", "jittered", &tasks.Signature{Name: "task"}))
+
+	after := time.Date(2026, 1, 1, 12, 1, 0, 0, time.UTC)
+	scheduled := time.Date(2026, 1, 1, 12, 5, 0, 0, time.UTC)
+	runs := make(map[time.Time]bool)
+	for i := 0; i < 20; i++ {
+		next := server.NextPeriodicRuns(after)[0]
+		assert.False(t, next.Before(scheduled), next)
+		assert.True(t, next.Before(scheduled.Add(30*time.Second)), next)
+		runs[next] = true
+	}
+	assert.Greater(t, len(runs), 1)
+
+	server, _ = newPeriodicServer(t)
+	assert.NoError(t, server.RegisterPeriodicTask("*/5 * * * *", "exact", &tasks.Signature{Name: "task"}))
+	assert.Equal(t, scheduled, server.NextPeriodicRuns(after)[0].UTC())
+}
+
+// leasingLock grants every lock like freeLock, but a lease only to a single
+// holder
+type leasingLock struct {
+	freeLock
+	leases *memorylock.Lock
+}
+
+func (l leasingLock) LockWithLease(key string, ttl time.Duration) (lockiface.Lease, error) {
+	return l.leases.LockWithLease(key, ttl)
+}
+
+func TestPeriodicPolicyOverlap(t *testing.T) {
+	t.Parallel()
+
+	for _, overlap := range []tasks.OverlapPolicy{tasks.OverlapSkip, tasks.OverlapQueue, tasks.OverlapLatest} {
+		overlap := overlap
+		t.Run(string(overlap), func(t *testing.T) {
+			t.Parallel()
+
+			recorder := &recordingBroker{Broker: common.NewBroker(new(config.Config))}
+			server := machinery.NewServer(new(config.Config), recorder, memory.New(new(config.Config)), leasingLock{leases: memorylock.New()})
+			started, release := make(chan struct{}, 2), make(chan struct{})
+			err := server.RegisterTask("slow", func() error {
+				started <- struct{}{}
+				<-release
+				return nil
+			})
+			assert.NoError(t, err)
+
+			server.SetPeriodicPolicy("periodic", &machinery.PeriodicPolicy{Overlap: overlap})
+			err = server.RegisterPeriodicTask("0 * * * *", "periodic", &tasks.Signature{Name: "slow"})
+			assert.NoError(t, err)
+
+			server.RunPeriodic()
+			server.RunPeriodic()
+			first, second := recorder.next(), recorder.next()
+			if !assert.NotNil(t, second) || !assert.NotNil(t, second.Singleton) {
+				return
+			}
+
+			worker := server.NewWorker("test_worker", 0)
+			done := make(chan error)
+			go func() { done <- worker.Process(first) }()
+			<-started
+
+			assert.NoError(t, worker.Process(second))
+			waiting := recorder.next()
+			switch overlap {
+			case tasks.OverlapSkip:
+				assert.Nil(t, waiting)
+				state, err := server.GetBackend().GetState(second.UUID)
+				if assert.NoError(t, err) {
+					assert.Equal(t, tasks.ErrRunOverlaps.Error(), state.Error)
+				}
+			case tasks.OverlapLatest:
+				// Once the next run is due, the waiting run is dropped
+				if assert.NotNil(t, waiting) {
+					waiting.Singleton.NextRun = time.Now()
+					assert.NoError(t, worker.Process(waiting))
+					assert.Nil(t, recorder.next())
+				}
+			case tasks.OverlapQueue:
+				if assert.NotNil(t, waiting) && assert.NotNil(t, waiting.ETA) {
+					close(release)
+					assert.NoError(t, <-done)
+					assert.NoError(t, worker.Process(waiting))
+					state, err := server.GetBackend().GetState(second.UUID)
+					if assert.NoError(t, err) {
+						assert.True(t, state.IsSuccess())
+					}
+					return
+				}
+			}
+			close(release)
+			assert.NoError(t, <-done)
+		})
+	}
+}
+
+func TestPeriodicPolicyReplace(t *testing.T) {
+	t.Parallel()
+
+	recorder := &recordingBroker{Broker: common.NewBroker(new(config.Config))}
+	server := machinery.NewServer(new(config.Config), recorder, memory.New(new(config.Config)), leasingLock{leases: memorylock.New()})
+	started, cancelled := make(chan string, 2), make(chan string, 2)
+	err := server.RegisterTask("slow", func(ctx context.Context) error {
+		signature := tasks.SignatureFromContext(ctx)
+		started <- signature.UUID
+		<-ctx.Done()
+		cancelled <- signature.UUID
+		return ctx.Err()
+	})
+	assert.NoError(t, err)
+
+	server.SetPeriodicPolicy("periodic", &machinery.PeriodicPolicy{Overlap: tasks.OverlapReplace})
+	err = server.RegisterPeriodicTask("0 * * * *", "periodic", &tasks.Signature{Name: "slow"})
+	assert.NoError(t, err)
+
+	server.RunPeriodic()
+	server.RunPeriodic()
+	first, second := recorder.next(), recorder.next()
+	if !assert.NotNil(t, second) || !assert.NotNil(t, second.Singleton) {
+		return
+	}
+
+	worker := server.NewWorker("test_worker", 0)
+	assert.NoError(t, worker.SetRevocationWatch(time.Minute))
+
+	done := make(chan error)
+	go func() { done <- worker.Process(first) }()
+	assert.Equal(t, first.UUID, <-started)
+
+	// The second run cancels the context of the first one and waits for it
+	assert.NoError(t, worker.Process(second))
+	worker.CheckRevocations()
+	select {
+	case uuid := <-cancelled:
+		assert.Equal(t, first.UUID, uuid)
+	case <-time.After(time.Second):
+		t.Fatal("the context of the previous run was not cancelled")
+	}
+	assert.NoError(t, <-done)
+	state, err := server.GetBackend().GetState(first.UUID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, tasks.ErrTaskRevoked.Error(), state.Error)
+	}
+
+	// Then it runs
+	waiting := recorder.next()
+	if assert.NotNil(t, waiting) {
+		go func() { done <- worker.Process(waiting) }()
+		assert.Equal(t, second.UUID, <-started)
+		assert.NoError(t, server.CancelTask(second.UUID))
+		worker.CheckRevocations()
+		assert.NoError(t, <-done)
+	}
+}
+
+func TestSendGroupEncodeError(t *testing.T) {
+	t.Parallel()
+
+	keys, err := tasks.NewStaticKeyProvider("k1", map[string][]byte{"k1": make([]byte, 32)})
+	assert.NoError(t, err)
+
+	for _, backend := range []iface.Backend{backend.New(), &batchingBackend{Backend: backend.New()}} {
+		server, broker := newRecordingServer(t)
+		server.SetBackend(backend)
+		server.SetEncryption(keys)
+
+		// The second task claims encrypted arguments which can't be decrypted
+		group, err := tasks.NewGroup(&tasks.Signature{Name: "foo"}, &tasks.Signature{
+			Name:    "foo",
+			Headers: tasks.Headers{tasks.ArgsEncryptionHeader: tasks.EncryptionAESGCM},
+			Args:    []tasks.Arg{{Type: "encrypted:" + tasks.EncryptionAESGCM, Value: "garbage"}},
+		})
+		assert.NoError(t, err)
+		_, err = server.SendGroup(group, 0)
+		assert.Error(t, err)
+
+		// Nothing was stored or published
+		_, err = backend.GroupTaskStates(group.GroupUUID, len(group.Tasks))
+		assert.Error(t, err)
+		for _, taskUUID := range group.GetUUIDs() {
+			_, err := backend.GetState(taskUUID)
+			assert.Error(t, err)
+		}
+		assert.Nil(t, broker.next())
+	}
+}
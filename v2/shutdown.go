@@ -0,0 +1,146 @@
+package machinery
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+// drainable is a task being run which is sent back to the queue if it is
+// still running once the worker's shutdown deadline hits
+type drainable struct {
+	signature *tasks.Signature
+	started   time.Time
+	cancel    context.CancelFunc
+	requeued  bool
+}
+
+// drain keeps the tasks a worker runs, so Shutdown can send back the ones
+// which don't finish in time. The zero value is ready to use.
+type drain struct {
+	mu      sync.Mutex
+	running map[*drainable]struct{}
+}
+
+// Shutdown stops the worker consuming and waits for the tasks it is running
+// to finish until ctx is done. Tasks still running then are sent back to the
+// queue without counting as a retry and their context is cancelled, if
+// their function takes one, so e.g. a Kubernetes pod stopped with a grace
+// period doesn't lose them. A task sent back may run twice if it completes
+// anyway or the broker delivers its message again, its outcome is ignored
+// by this worker. Returns ctx.Err() if the deadline hit.
+func (worker *Worker) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		worker.Quit()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	for _, running := range worker.drain.abandon() {
+		worker.server.log().WARNING.Printf("Task %s is still running at the shutdown deadline, sending it back to the queue", running.signature.UUID)
+		if err := worker.requeueDrained(running.signature); err != nil {
+			worker.server.log().ERROR.Printf("Failed to send task %s back to the queue: %s", running.signature.UUID, err)
+		}
+	}
+	return ctx.Err()
+}
+
+// stopGracefully shuts the worker down within the configured shutdown
+// timeout, or quits it waiting for all running tasks without one
+func (worker *Worker) stopGracefully() error {
+	timeout := time.Duration(worker.server.GetConfig().ShutdownTimeout) * time.Second
+	if timeout <= 0 {
+		worker.Quit()
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return worker.Shutdown(ctx)
+}
+
+// requeueDrained sends a task the worker abandoned back to the queue, it
+// doesn't count as a retry
+func (worker *Worker) requeueDrained(signature *tasks.Signature) error {
+	// The abandoned run keeps using the signature until it returns
+	requeued := tasks.CopySignature(signature)
+	if err := worker.server.GetBackend().SetStateRetry(requeued); err != nil {
+		return err
+	}
+	_, err := worker.server.SendTask(requeued)
+	return err
+}
+
+// begin keeps the task until it is passed to end, its context is made
+// cancellable if its function takes one
+func (d *drain) begin(signature *tasks.Signature, task *tasks.Task) *drainable {
+	running := &drainable{signature: signature, started: time.Now().UTC(), cancel: func() {}}
+	if task.UseContext {
+		task.Context, running.cancel = context.WithCancel(task.Context)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.running == nil {
+		d.running = make(map[*drainable]struct{})
+	}
+	d.running[running] = struct{}{}
+	return running
+}
+
+// end forgets the task and returns true if it was sent back to the queue
+func (d *drain) end(running *drainable) bool {
+	if running == nil {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.running, running)
+	running.cancel()
+	return running.requeued
+}
+
+// abandon marks the running tasks as sent back, cancels them and returns
+// them
+func (d *drain) abandon() []*drainable {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	abandoned := make([]*drainable, 0, len(d.running))
+	for running := range d.running {
+		if running.requeued {
+			continue
+		}
+		running.requeued = true
+		running.cancel()
+		abandoned = append(abandoned, running)
+	}
+	return abandoned
+}
+
+// snapshot returns the running tasks, oldest first
+func (d *drain) snapshot() []*tasks.RunningTask {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	running := make([]*tasks.RunningTask, 0, len(d.running))
+	for task := range d.running {
+		running = append(running, &tasks.RunningTask{
+			UUID:      task.signature.UUID,
+			Name:      task.signature.Name,
+			StartedAt: task.started,
+		})
+	}
+	sort.Slice(running, func(i, j int) bool { return running[i].StartedAt.Before(running[j].StartedAt) })
+	return running
+}
@@ -0,0 +1,130 @@
+package machinery_test
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2"
+	"github.com/RichardKnop/machinery/v2/backends/memory"
+	"github.com/RichardKnop/machinery/v2/common"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/tasks"
+
+	lock "github.com/RichardKnop/machinery/v2/locks/eager"
+)
+
+// consumingBroker waits for the tasks it is processing once it stops
+// consuming, like the real brokers do
+type consumingBroker struct {
+	recordingBroker
+	processing sync.WaitGroup
+}
+
+func (b *consumingBroker) StopConsuming() {
+	b.processing.Wait()
+}
+
+// deliver processes the task in the background
+func (b *consumingBroker) deliver(t *testing.T, worker *machinery.Worker, signature *tasks.Signature) {
+	b.processing.Add(1)
+	go func() {
+		defer b.processing.Done()
+		assert.NoError(t, worker.Process(signature))
+	}()
+}
+
+func newConsumingServer(t *testing.T) (*machinery.Server, *consumingBroker) {
+	cnf := new(config.Config)
+	broker := &consumingBroker{recordingBroker: recordingBroker{Broker: common.NewBroker(cnf)}}
+	server := machinery.NewServer(cnf, broker, memory.New(cnf), lock.New())
+	return server, broker
+}
+
+func TestShutdown(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newConsumingServer(t)
+	release := make(chan struct{})
+	err := server.RegisterTasks(map[string]interface{}{
+		"quick": func() error {
+			time.Sleep(20 * time.Millisecond)
+			return nil
+		},
+		"cancellable": func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+		"stubborn": func() error {
+			<-release
+			return nil
+		},
+	})
+	assert.NoError(t, err)
+
+	worker := server.NewWorker("test_worker", 3)
+	signatures := []*tasks.Signature{
+		{UUID: "task_quick", Name: "quick"},
+		{UUID: "task_cancellable", Name: "cancellable"},
+		{UUID: "task_stubborn", Name: "stubborn"},
+	}
+	for _, signature := range signatures {
+		broker.deliver(t, worker, signature)
+	}
+	assert.Eventually(t, func() bool {
+		for _, signature := range signatures[1:] {
+			state, err := server.GetBackend().GetState(signature.UUID)
+			if err != nil || state.State != tasks.StateStarted {
+				return false
+			}
+		}
+		return true
+	}, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, worker.Shutdown(ctx))
+
+	// The tasks still running at the deadline are sent back
+	var requeued []string
+	for signature := broker.next(); signature != nil; signature = broker.next() {
+		requeued = append(requeued, signature.UUID)
+	}
+	sort.Strings(requeued)
+	assert.Equal(t, []string{"task_cancellable", "task_stubborn"}, requeued)
+
+	// Their outcome is ignored once they return
+	close(release)
+	broker.processing.Wait()
+	expected := map[string]string{
+		"task_quick":       tasks.StateSuccess,
+		"task_cancellable": tasks.StatePending,
+		"task_stubborn":    tasks.StatePending,
+	}
+	for taskUUID, expectedState := range expected {
+		state, err := server.GetBackend().GetState(taskUUID)
+		if assert.NoError(t, err) {
+			assert.Equal(t, expectedState, state.State, taskUUID)
+		}
+	}
+
+	// Workers whose tasks finish in time shut down cleanly
+	server, broker = newConsumingServer(t)
+	err = server.RegisterTask("quick", func() error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+	assert.NoError(t, err)
+	worker = server.NewWorker("test_worker", 1)
+	broker.deliver(t, worker, &tasks.Signature{UUID: "task_in_time", Name: "quick"})
+	assert.NoError(t, worker.Shutdown(context.Background()))
+	state, err := server.GetBackend().GetState("task_in_time")
+	if assert.NoError(t, err) {
+		assert.Equal(t, tasks.StateSuccess, state.State)
+	}
+	assert.Nil(t, broker.next())
+}
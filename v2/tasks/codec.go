@@ -0,0 +1,60 @@
+package tasks
+
+// Codec serializes the signatures brokers publish and the task states result
+// backends store. The built-in codecs are created with NewWireFormatCodec,
+// implement Codec to plug in another format, e.g. msgpack or encrypted JSON,
+// and set it with Server.SetCodec. Codecs must be safe for concurrent use.
+type Codec interface {
+	// ContentType returns the MIME type of the encoded data, e.g. set on
+	// AMQP messages
+	ContentType() string
+	// EncodeSignature encodes a signature to publish
+	EncodeSignature(signature *Signature) ([]byte, error)
+	// DecodeSignature decodes a received signature. In strict mode fields
+	// the signature doesn't know should be rejected, callers validate the
+	// signature itself.
+	DecodeSignature(data []byte, strict bool) (*Signature, error)
+	// EncodeTaskState encodes a task state to store
+	EncodeTaskState(taskState *TaskState) ([]byte, error)
+	// DecodeTaskState decodes a stored task state
+	DecodeTaskState(data []byte) (*TaskState, error)
+}
+
+// wireFormatCodec encodes in a built-in wire format and decodes both
+type wireFormatCodec string
+
+// NewWireFormatCodec returns the codec of the built-in wire format, JSON if
+// empty, see WireFormatJSON and WireFormatProtobuf. It decodes data of either
+// format, so workers keep reading tasks and states written before switching.
+// Encoding fails if the format is unknown.
+func NewWireFormatCodec(format string) Codec {
+	return wireFormatCodec(format)
+}
+
+// ContentType returns the MIME type of the wire format
+func (c wireFormatCodec) ContentType() string {
+	if c == WireFormatProtobuf {
+		return "application/x-protobuf"
+	}
+	return "application/json"
+}
+
+// EncodeSignature encodes the signature in the wire format
+func (c wireFormatCodec) EncodeSignature(signature *Signature) ([]byte, error) {
+	return EncodeSignature(signature, string(c))
+}
+
+// DecodeSignature decodes a signature of either wire format
+func (c wireFormatCodec) DecodeSignature(data []byte, strict bool) (*Signature, error) {
+	return decodeWireSignature(data, strict)
+}
+
+// EncodeTaskState encodes the task state in the wire format
+func (c wireFormatCodec) EncodeTaskState(taskState *TaskState) ([]byte, error) {
+	return EncodeTaskState(taskState, string(c))
+}
+
+// DecodeTaskState decodes a task state of either wire format
+func (c wireFormatCodec) DecodeTaskState(data []byte) (*TaskState, error) {
+	return DecodeTaskState(data)
+}
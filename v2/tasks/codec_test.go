@@ -0,0 +1,62 @@
+package tasks_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestWireFormatCodec(t *testing.T) {
+	t.Parallel()
+
+	signature := &tasks.Signature{UUID: "task_1", Name: "add", Args: []tasks.Arg{{Type: "int64", Value: 1}}}
+	taskState := tasks.NewPendingTaskState(signature)
+
+	jsonCodec := tasks.NewWireFormatCodec("")
+	protobufCodec := tasks.NewWireFormatCodec(tasks.WireFormatProtobuf)
+	assert.Equal(t, "application/json", jsonCodec.ContentType())
+	assert.Equal(t, "application/x-protobuf", protobufCodec.ContentType())
+
+	// Either codec decodes both formats, so switching is safe
+	for _, encoder := range []tasks.Codec{jsonCodec, protobufCodec} {
+		encoded, err := encoder.EncodeSignature(signature)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decoder := range []tasks.Codec{jsonCodec, protobufCodec} {
+			decoded, err := decoder.DecodeSignature(encoded, true)
+			if assert.NoError(t, err) {
+				assert.Equal(t, "add", decoded.Name)
+				assert.Len(t, decoded.Args, 1)
+			}
+		}
+
+		encoded, err = encoder.EncodeTaskState(taskState)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decoder := range []tasks.Codec{jsonCodec, protobufCodec} {
+			decoded, err := decoder.DecodeTaskState(encoded)
+			if assert.NoError(t, err) {
+				assert.Equal(t, taskState.TaskUUID, decoded.TaskUUID)
+				assert.Equal(t, tasks.StatePending, decoded.State)
+			}
+		}
+	}
+
+	// Codecs leave validating signatures to their callers
+	decoded, err := jsonCodec.DecodeSignature([]byte(`{"UUID":"task 1"}`), true)
+	if assert.NoError(t, err) {
+		assert.Error(t, decoded.Validate())
+	}
+	_, err = jsonCodec.DecodeSignature([]byte(`{"UUID":"task_1","Unknown":true}`), true)
+	assert.Error(t, err)
+
+	xmlCodec := tasks.NewWireFormatCodec("xml")
+	_, err = xmlCodec.EncodeSignature(signature)
+	assert.Error(t, err)
+	_, err = xmlCodec.EncodeTaskState(taskState)
+	assert.Error(t, err)
+}
@@ -0,0 +1,204 @@
+package tasks
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported compression algorithms
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+const (
+	// AcceptEncodingHeader lists compression algorithms the sender of a task
+	// is able to decompress, workers compress results only if it is set
+	AcceptEncodingHeader = "machinery-accept-encoding"
+	// ArgsEncodingHeader names the algorithm the arguments are compressed with
+	ArgsEncodingHeader = "machinery-args-encoding"
+
+	// compressedTypePrefix marks the type of a single argument or result
+	// holding the compressed JSON of the original ones
+	compressedTypePrefix = "compressed:"
+)
+
+// Compressor compresses task arguments and results whose JSON encoding is at
+// least Threshold bytes long
+type Compressor struct {
+	Algorithm string
+	Threshold int
+}
+
+// AcceptEncoding marks the signature as sent by a client able to decompress
+// results
+func AcceptEncoding(signature *Signature) {
+	if signature.Headers == nil {
+		signature.Headers = make(Headers)
+	}
+	signature.Headers[AcceptEncodingHeader] = strings.Join([]string{CompressionGzip, CompressionZstd}, ",")
+}
+
+// AcceptsEncoding returns true if the sender of the task is able to
+// decompress results compressed with the algorithm
+func AcceptsEncoding(signature *Signature, algorithm string) bool {
+	accepted, _ := signature.Headers[AcceptEncodingHeader].(string)
+	for _, value := range strings.Split(accepted, ",") {
+		if strings.TrimSpace(value) == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// CompressArgs replaces arguments of the signature with a single compressed
+// argument if they are large enough
+func (c *Compressor) CompressArgs(signature *Signature) error {
+	if len(signature.Args) == 0 || signature.Headers[ArgsEncodingHeader] != nil {
+		return nil
+	}
+
+	encoded, ok, err := c.compress(signature.Args)
+	if err != nil || !ok {
+		return err
+	}
+
+	if signature.Headers == nil {
+		signature.Headers = make(Headers)
+	}
+	signature.Headers[ArgsEncodingHeader] = c.Algorithm
+	signature.Args = []Arg{{Type: compressedTypePrefix + c.Algorithm, Value: encoded}}
+	return nil
+}
+
+// CompressResults returns the results replaced with a single compressed
+// result if they are large enough
+func (c *Compressor) CompressResults(results []*TaskResult) ([]*TaskResult, error) {
+	encoded, ok, err := c.compress(results)
+	if err != nil || !ok {
+		return results, err
+	}
+
+	return []*TaskResult{{Type: compressedTypePrefix + c.Algorithm, Value: encoded}}, nil
+}
+
+// DecompressArgs restores the original arguments of a signature compressed
+// with CompressArgs, other signatures are left alone
+func DecompressArgs(signature *Signature) error {
+	algorithm, _ := signature.Headers[ArgsEncodingHeader].(string)
+	if algorithm == "" || len(signature.Args) != 1 {
+		return nil
+	}
+
+	var args []Arg
+	if err := decompress(signature.Args[0].Type, signature.Args[0].Value, &args); err != nil {
+		return err
+	}
+
+	signature.Args = args
+	delete(signature.Headers, ArgsEncodingHeader)
+	return nil
+}
+
+// DecompressResults restores the original results compressed with
+// CompressResults, other results are returned unchanged
+func DecompressResults(results []*TaskResult) ([]*TaskResult, error) {
+	if len(results) != 1 || !strings.HasPrefix(results[0].Type, compressedTypePrefix) {
+		return results, nil
+	}
+
+	var decompressed []*TaskResult
+	if err := decompress(results[0].Type, results[0].Value, &decompressed); err != nil {
+		return nil, err
+	}
+
+	return decompressed, nil
+}
+
+// compress returns base64 encoded compressed JSON of v, the second return
+// value is false if the JSON is shorter than the threshold
+func (c *Compressor) compress(v interface{}) (string, bool, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return "", false, fmt.Errorf("JSON marshal error: %s", err)
+	}
+
+	if len(encoded) < c.Threshold {
+		return "", false, nil
+	}
+
+	var buf bytes.Buffer
+	switch c.Algorithm {
+	case CompressionGzip:
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(encoded); err != nil {
+			return "", false, err
+		}
+		if err := writer.Close(); err != nil {
+			return "", false, err
+		}
+	case CompressionZstd:
+		writer, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return "", false, err
+		}
+		if _, err := writer.Write(encoded); err != nil {
+			return "", false, err
+		}
+		if err := writer.Close(); err != nil {
+			return "", false, err
+		}
+	default:
+		return "", false, fmt.Errorf("Unsupported compression algorithm: %s", c.Algorithm)
+	}
+
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), true, nil
+}
+
+// decompress decodes a value produced by compress into v
+func decompress(typeName string, value interface{}, v interface{}) error {
+	encoded, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("Compressed value should be a string, got %T", value)
+	}
+
+	compressed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+
+	var decompressed []byte
+	switch strings.TrimPrefix(typeName, compressedTypePrefix) {
+	case CompressionGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		if decompressed, err = ioutil.ReadAll(reader); err != nil {
+			return err
+		}
+	case CompressionZstd:
+		reader, err := zstd.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		if decompressed, err = ioutil.ReadAll(reader); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Unsupported compression algorithm: %s", typeName)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(decompressed))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
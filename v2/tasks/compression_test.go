@@ -0,0 +1,91 @@
+package tasks_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestCompressArgs(t *testing.T) {
+	t.Parallel()
+
+	large := strings.Repeat("machinery", 100)
+
+	for _, algorithm := range []string{tasks.CompressionGzip, tasks.CompressionZstd} {
+		compressor := &tasks.Compressor{Algorithm: algorithm, Threshold: 100}
+		signature := &tasks.Signature{
+			Name: "foo",
+			Args: []tasks.Arg{
+				{Type: "string", Value: large},
+				{Type: "int64", Value: 1},
+			},
+		}
+
+		assert.NoError(t, compressor.CompressArgs(signature))
+		assert.Equal(t, algorithm, signature.Headers[tasks.ArgsEncodingHeader])
+		if assert.Len(t, signature.Args, 1) {
+			assert.True(t, len(signature.Args[0].Value.(string)) < len(large))
+		}
+
+		assert.NoError(t, tasks.DecompressArgs(signature))
+		assert.Nil(t, signature.Headers[tasks.ArgsEncodingHeader])
+		if assert.Len(t, signature.Args, 2) {
+			assert.Equal(t, large, signature.Args[0].Value)
+			assert.Equal(t, "1", signature.Args[1].Value.(interface{ String() string }).String())
+		}
+	}
+}
+
+func TestCompressBelowThreshold(t *testing.T) {
+	t.Parallel()
+
+	compressor := &tasks.Compressor{Algorithm: tasks.CompressionGzip, Threshold: 1024}
+	signature := &tasks.Signature{Name: "foo", Args: []tasks.Arg{{Type: "string", Value: "bar"}}}
+
+	assert.NoError(t, compressor.CompressArgs(signature))
+	assert.Nil(t, signature.Headers)
+	assert.Equal(t, "bar", signature.Args[0].Value)
+
+	results := []*tasks.TaskResult{{Type: "string", Value: "bar"}}
+	compressed, err := compressor.CompressResults(results)
+	assert.NoError(t, err)
+	assert.Equal(t, results, compressed)
+}
+
+func TestCompressResults(t *testing.T) {
+	t.Parallel()
+
+	large := strings.Repeat("machinery", 100)
+	compressor := &tasks.Compressor{Algorithm: tasks.CompressionZstd}
+
+	compressed, err := compressor.CompressResults([]*tasks.TaskResult{
+		{Type: "string", Value: large},
+		{Type: "bool", Value: true},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, compressed, 1)
+
+	values, err := tasks.ReflectTaskResults(compressed)
+	if assert.NoError(t, err) && assert.Len(t, values, 2) {
+		assert.Equal(t, large, values[0].Interface())
+		assert.Equal(t, true, values[1].Interface())
+	}
+
+	_, err = (&tasks.Compressor{Algorithm: "lz4"}).CompressResults(compressed)
+	assert.Error(t, err)
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	t.Parallel()
+
+	signature := &tasks.Signature{Name: "foo"}
+	assert.False(t, tasks.AcceptsEncoding(signature, tasks.CompressionGzip))
+
+	tasks.AcceptEncoding(signature)
+	assert.True(t, tasks.AcceptsEncoding(signature, tasks.CompressionGzip))
+	assert.True(t, tasks.AcceptsEncoding(signature, tasks.CompressionZstd))
+	assert.False(t, tasks.AcceptsEncoding(signature, "lz4"))
+}
@@ -0,0 +1,53 @@
+package tasks
+
+const (
+	// ControlPause - workers stop running tasks until resumed, tasks they
+	// already run complete
+	ControlPause = "pause"
+	// ControlResume - paused workers run tasks again
+	ControlResume = "resume"
+	// ControlSetConcurrency - workers run at most Concurrency tasks at once,
+	// zero restores the concurrency they were launched with
+	ControlSetConcurrency = "set_concurrency"
+	// ControlSetLogLevel - workers drop the lines logged below LogLevel
+	ControlSetLogLevel = "set_log_level"
+	// ControlStats - workers only reply
+	ControlStats = "stats"
+)
+
+// ControlMessage is a command broadcast to the workers of a fleet, see
+// machinery.Server.Broadcast
+type ControlMessage struct {
+	// ID is set by the backend once the message is published
+	ID      int64  `bson:"id"`
+	Command string `bson:"command"`
+	// Destination are the consumer tags or IDs of the workers the message is
+	// addressed to, all workers if empty
+	Destination []string `bson:"destination"`
+	// Concurrency is the argument of ControlSetConcurrency
+	Concurrency int `bson:"concurrency"`
+	// LogLevel is the argument of ControlSetLogLevel, e.g. "DEBUG"
+	LogLevel string `bson:"log_level"`
+}
+
+// ControlReply is the reply of a worker to a control message
+type ControlReply struct {
+	MessageID int64 `bson:"message_id"`
+	// Worker is the state of the worker once it handled the message
+	Worker *WorkerInfo `bson:"worker"`
+	// Error is set if the worker couldn't handle the message
+	Error string `bson:"error"`
+}
+
+// AddressedTo returns true if the message is addressed to the worker
+func (m *ControlMessage) AddressedTo(consumerTag, workerID string) bool {
+	if len(m.Destination) == 0 {
+		return true
+	}
+	for _, destination := range m.Destination {
+		if destination == consumerTag || destination == workerID {
+			return true
+		}
+	}
+	return false
+}
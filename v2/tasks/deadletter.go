@@ -0,0 +1,90 @@
+package tasks
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// DeadLetterExhausted - the task failed with no retries remaining
+	DeadLetterExhausted = "EXHAUSTED"
+	// DeadLetterUndecodable - the message couldn't be decoded into a task,
+	// or the arguments of the task couldn't be decoded
+	DeadLetterUndecodable = "UNDECODABLE"
+	// DeadLetterPermanent - the task failed with an error wrapped with
+	// Permanent, its retries were skipped
+	DeadLetterPermanent = "PERMANENT"
+)
+
+// DeadLetter is the message of a task which failed for good, kept in the
+// dead-letter queue along with why it failed so it isn't lost
+type DeadLetter struct {
+	// ID is the UUID of the task, or a new UUID if the message couldn't be
+	// decoded
+	ID       string `bson:"_id"`
+	TaskName string `bson:"task_name"`
+	// RoutingKey is the queue the task was consumed from
+	RoutingKey string `bson:"routing_key"`
+	// Reason is DeadLetterExhausted, DeadLetterUndecodable or
+	// DeadLetterPermanent
+	Reason   string    `bson:"reason"`
+	Error    string    `bson:"error"`
+	FailedAt time.Time `bson:"failed_at"`
+	// Message is the task as it was delivered, before its arguments were
+	// decompressed or decrypted, or the raw message if it couldn't be
+	// decoded
+	Message []byte `bson:"message"`
+}
+
+// DeadLetterFilter selects dead letters listed by backends keeping them.
+// Zero fields match any dead letter.
+type DeadLetterFilter struct {
+	Reason     string
+	TaskName   string
+	RoutingKey string
+	// FailedAfter and FailedBefore limit the time the task failed at
+	FailedAfter  time.Time
+	FailedBefore time.Time
+	// Offset and Limit page through the matching dead letters, which are
+	// sorted newest first. A zero Limit returns all of them.
+	Offset int
+	Limit  int
+}
+
+// Match returns true if the dead letter passes the filter, ignoring the
+// pagination
+func (filter *DeadLetterFilter) Match(deadLetter *DeadLetter) bool {
+	if filter.Reason != "" && deadLetter.Reason != filter.Reason {
+		return false
+	}
+	if filter.TaskName != "" && deadLetter.TaskName != filter.TaskName {
+		return false
+	}
+	if filter.RoutingKey != "" && deadLetter.RoutingKey != filter.RoutingKey {
+		return false
+	}
+	if !filter.FailedAfter.IsZero() && !deadLetter.FailedAt.After(filter.FailedAfter) {
+		return false
+	}
+	if !filter.FailedBefore.IsZero() && !deadLetter.FailedAt.Before(filter.FailedBefore) {
+		return false
+	}
+	return true
+}
+
+// Page sorts matching dead letters newest first and returns the requested
+// page
+func (filter *DeadLetterFilter) Page(deadLetters []*DeadLetter) []*DeadLetter {
+	sort.SliceStable(deadLetters, func(i, j int) bool {
+		return deadLetters[i].FailedAt.After(deadLetters[j].FailedAt)
+	})
+
+	if filter.Offset >= len(deadLetters) {
+		return []*DeadLetter{}
+	}
+	deadLetters = deadLetters[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(deadLetters) {
+		deadLetters = deadLetters[:filter.Limit]
+	}
+	return deadLetters
+}
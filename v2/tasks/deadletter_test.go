@@ -0,0 +1,58 @@
+package tasks_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetterFilterMatch(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	deadLetter := &tasks.DeadLetter{
+		ID:         "task_1",
+		TaskName:   "add",
+		RoutingKey: "machinery_tasks",
+		Reason:     tasks.DeadLetterExhausted,
+		FailedAt:   now,
+	}
+
+	assert.True(t, new(tasks.DeadLetterFilter).Match(deadLetter))
+	assert.True(t, (&tasks.DeadLetterFilter{Reason: tasks.DeadLetterExhausted}).Match(deadLetter))
+	assert.False(t, (&tasks.DeadLetterFilter{Reason: tasks.DeadLetterUndecodable}).Match(deadLetter))
+	assert.True(t, (&tasks.DeadLetterFilter{TaskName: "add"}).Match(deadLetter))
+	assert.False(t, (&tasks.DeadLetterFilter{TaskName: "multiply"}).Match(deadLetter))
+	assert.True(t, (&tasks.DeadLetterFilter{RoutingKey: "machinery_tasks"}).Match(deadLetter))
+	assert.False(t, (&tasks.DeadLetterFilter{RoutingKey: "emails"}).Match(deadLetter))
+	assert.True(t, (&tasks.DeadLetterFilter{FailedAfter: now.Add(-time.Hour), FailedBefore: now.Add(time.Hour)}).Match(deadLetter))
+	assert.False(t, (&tasks.DeadLetterFilter{FailedAfter: now}).Match(deadLetter))
+	assert.False(t, (&tasks.DeadLetterFilter{FailedBefore: now}).Match(deadLetter))
+}
+
+func TestDeadLetterFilterPage(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	deadLetters := func() []*tasks.DeadLetter {
+		return []*tasks.DeadLetter{
+			{ID: "oldest", FailedAt: now.Add(-2 * time.Minute)},
+			{ID: "newest", FailedAt: now},
+			{ID: "middle", FailedAt: now.Add(-time.Minute)},
+		}
+	}
+	ids := func(deadLetters []*tasks.DeadLetter) []string {
+		result := make([]string, len(deadLetters))
+		for i, deadLetter := range deadLetters {
+			result[i] = deadLetter.ID
+		}
+		return result
+	}
+
+	assert.Equal(t, []string{"newest", "middle", "oldest"}, ids(new(tasks.DeadLetterFilter).Page(deadLetters())))
+	assert.Equal(t, []string{"newest", "middle"}, ids((&tasks.DeadLetterFilter{Limit: 2}).Page(deadLetters())))
+	assert.Equal(t, []string{"middle", "oldest"}, ids((&tasks.DeadLetterFilter{Offset: 1, Limit: 5}).Page(deadLetters())))
+	assert.Empty(t, (&tasks.DeadLetterFilter{Offset: 3}).Page(deadLetters()))
+}
@@ -0,0 +1,130 @@
+package tasks
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxUUIDLength bounds task UUIDs, generated ones are far shorter
+const maxUUIDLength = 255
+
+// DecodeSignature decodes a signature received from a broker. In strict mode
+// fields the signature doesn't know are rejected, as the sender may rely on
+// them, and the signature is validated, so malformed messages, e.g. sent
+// from other languages, fail with a readable error before a worker runs them.
+// Otherwise unknown fields are ignored and the signature is not validated.
+// Signatures are decoded from either wire format, see EncodeSignature.
+func DecodeSignature(data []byte, strict bool) (*Signature, error) {
+	signature, err := decodeWireSignature(data, strict)
+	if err != nil {
+		return nil, err
+	}
+	if strict {
+		if err := signature.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return signature, nil
+}
+
+// decodeWireSignature decodes a signature of either wire format without
+// validating it, rejecting unknown fields in strict mode
+func decodeWireSignature(data []byte, strict bool) (*Signature, error) {
+	if !isJSON(data) {
+		return decodeSignature(data, strict)
+	}
+
+	signature := new(Signature)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(signature); err != nil {
+		return nil, err
+	}
+	if strict && decoder.More() {
+		return nil, errors.New("unexpected data after the signature")
+	}
+	return signature, nil
+}
+
+// Validate checks the signature and the callbacks travelling inside it have
+// a name, well-formed UUIDs and ETAs, and arguments of supported types
+// holding values of their type
+func (s *Signature) Validate() error {
+	if s.UUID == "" {
+		return errors.New("signature has no UUID")
+	}
+	return s.validate()
+}
+
+func (s *Signature) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("signature %s has no name", s.UUID)
+	}
+	// Callbacks get their UUID once they are sent, unless set up front
+	if s.UUID != "" {
+		if err := validateUUID(s.UUID); err != nil {
+			return err
+		}
+	}
+	if s.ETA != nil && s.ETA.IsZero() {
+		return fmt.Errorf("signature %s has a zero ETA", s.UUID)
+	}
+	if s.RetryPolicy != nil {
+		if err := s.RetryPolicy.Validate(); err != nil {
+			return fmt.Errorf("signature %s: %s", s.UUID, err)
+		}
+	}
+
+	for i, arg := range s.Args {
+		if err := validateArg(arg); err != nil {
+			return fmt.Errorf("argument %d of signature %s: %s", i, s.UUID, err)
+		}
+	}
+
+	for chord := s.ChordContinuation; chord != nil; chord = chord.Continuation {
+		if chord.Group == nil {
+			return fmt.Errorf("signature %s continues with a chord without a group", s.UUID)
+		}
+	}
+	for _, nested := range nestedSignatures(s) {
+		if nested == nil {
+			return fmt.Errorf("signature %s has a nil callback", s.UUID)
+		}
+		if err := nested.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateUUID allows the characters of generated UUIDs and their prefixes,
+// e.g. task_b4c0ffee-..., so a UUID is safe to use in keys and logs
+func validateUUID(uuid string) error {
+	if len(uuid) > maxUUIDLength {
+		return fmt.Errorf("UUID %.16s... is longer than %d characters", uuid, maxUUIDLength)
+	}
+	for _, r := range uuid {
+		valid := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+			r == '_' || r == '-' || r == '.' || r == ':'
+		if !valid {
+			return fmt.Errorf("UUID %q contains %q", uuid, r)
+		}
+	}
+	return nil
+}
+
+// validateArg checks the value fits the type, compressed and encrypted values
+// are checked once they are decoded
+func validateArg(arg Arg) error {
+	if strings.HasPrefix(arg.Type, compressedTypePrefix) || strings.HasPrefix(arg.Type, encryptedTypePrefix) {
+		return nil
+	}
+	_, err := ReflectValue(arg.Type, arg.Value)
+	return err
+}
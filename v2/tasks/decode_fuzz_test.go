@@ -0,0 +1,34 @@
+//go:build go1.18
+// +build go1.18
+
+package tasks_test
+
+import (
+	"testing"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func FuzzDecodeSignature(f *testing.F) {
+	f.Add([]byte(`{"UUID":"task_1","Name":"add","Args":[{"Type":"int64","Value":1},{"Type":"[]string","Value":["a"]}]}`))
+	f.Add([]byte(`{"UUID":"task_1","Name":"add","ETA":"2026-01-02T15:04:05Z","OnSuccess":[{"Name":"notify"}]}`))
+	f.Add([]byte(`{"UUID":"task_1","Name":"add","ChordContinuation":{"Group":{"Tasks":[null]}}}`))
+	f.Add([]byte(`{"UUID":"task_1","Name":"add","Args":[{"Type":"[]float32","Value":[1e300]}]}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		strict, strictErr := tasks.DecodeSignature(data, true)
+		_, compatErr := tasks.DecodeSignature(data, false)
+
+		if strictErr != nil {
+			return
+		}
+		// Strict mode only rejects more messages than compat mode
+		if compatErr != nil {
+			t.Fatalf("compat mode rejected a signature strict mode accepted: %s", compatErr)
+		}
+		// Arguments of a valid signature can be reflected
+		if _, err := tasks.New(func(...interface{}) error { return nil }, strict.Args); err != nil {
+			t.Fatalf("valid signature has arguments which can't be reflected: %s", err)
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package tasks_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestDecodeSignature(t *testing.T) {
+	t.Parallel()
+
+	valid := `{"UUID":"task_1","Name":"add","Args":[{"Type":"int64","Value":1},{"Type":"[]string","Value":["a"]}],` +
+		`"ETA":"2026-01-02T15:04:05Z","OnSuccess":[{"Name":"notify"}]}`
+	signature, err := tasks.DecodeSignature([]byte(valid), true)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "add", signature.Name)
+		assert.Len(t, signature.Args, 2)
+		assert.Equal(t, "notify", signature.OnSuccess[0].Name)
+	}
+
+	for name, message := range map[string]string{
+		"unknown field":       `{"UUID":"task_1","Name":"add","Priorty":5}`,
+		"no UUID":             `{"Name":"add"}`,
+		"malformed UUID":      `{"UUID":"task 1","Name":"add"}`,
+		"no name":             `{"UUID":"task_1"}`,
+		"zero ETA":            `{"UUID":"task_1","Name":"add","ETA":"0001-01-01T00:00:00Z"}`,
+		"unsupported type":    `{"UUID":"task_1","Name":"add","Args":[{"Type":"complex128","Value":1}]}`,
+		"value of wrong type": `{"UUID":"task_1","Name":"add","Args":[{"Type":"int64","Value":"one"}]}`,
+		"nil callback":        `{"UUID":"task_1","Name":"add","OnError":[null]}`,
+		"invalid callback":    `{"UUID":"task_1","Name":"add","OnSuccess":[{"Name":"notify","Args":[{"Type":"bool","Value":"no"}]}]}`,
+		"chord without group": `{"UUID":"task_1","Name":"add","ChordContinuation":{}}`,
+		"trailing data":       `{"UUID":"task_1","Name":"add"} {}`,
+	} {
+		_, err := tasks.DecodeSignature([]byte(message), true)
+		assert.Error(t, err, name)
+	}
+
+	// Compat mode ignores unknown fields and doesn't validate
+	signature, err = tasks.DecodeSignature([]byte(`{"Name":"add","Priorty":5,"Args":[{"Type":"int64","Value":"one"}]}`), false)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "add", signature.Name)
+	}
+
+	// Malformed ETAs and JSON fail in both modes
+	for _, strict := range []bool{true, false} {
+		_, err = tasks.DecodeSignature([]byte(`{"UUID":"task_1","Name":"add","ETA":"tomorrow"}`), strict)
+		assert.Error(t, err)
+		_, err = tasks.DecodeSignature([]byte(`{"UUID":`), strict)
+		assert.Error(t, err)
+	}
+}
+
+func TestValidateEncodedArgs(t *testing.T) {
+	t.Parallel()
+
+	// Compressed and encrypted arguments are checked once they are decoded
+	signature := &tasks.Signature{
+		UUID: "task_1",
+		Name: "add",
+		Args: []tasks.Arg{{Type: "compressed:gzip", Value: "H4sI"}},
+	}
+	assert.NoError(t, signature.Validate())
+}
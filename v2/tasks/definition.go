@@ -0,0 +1,246 @@
+package tasks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Definition declares a workflow, so it can be written in YAML or JSON
+// instead of Go. A definition is either a task, a chain of steps or a group
+// of members, which are definitions themselves, e.g.
+//
+//	chain:
+//	  - task: fetch
+//	    args:
+//	      - type: string
+//	        value: https://example.com/images
+//	    retry_count: 3
+//	  - group:
+//	      - task: resize
+//	      - chain: [{task: thumbnail}, {task: upload}]
+//	    callback:
+//	      task: notify
+//
+// A group with a callback is a chord, the callback is called with results
+// of the group once all of its members completed.
+type Definition struct {
+	// Task is the name of the task, it is set along with the fields below
+	// up to Chain
+	Task         string        `json:"task,omitempty"`
+	Args         []Arg         `json:"args,omitempty"`
+	RoutingKey   string        `json:"routing_key,omitempty"`
+	Priority     uint8         `json:"priority,omitempty"`
+	RetryCount   int           `json:"retry_count,omitempty"`
+	RetryTimeout int           `json:"retry_timeout,omitempty"`
+	Immutable    bool          `json:"immutable,omitempty"`
+	OnError      []*Definition `json:"on_error,omitempty"`
+
+	Chain    []*Definition `json:"chain,omitempty"`
+	Group    []*Definition `json:"group,omitempty"`
+	Callback *Definition   `json:"callback,omitempty"`
+}
+
+// LoadDefinition reads the workflow definition from a YAML or JSON file
+func LoadDefinition(path string) (*Definition, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Read from file error: %s", err)
+	}
+	return ParseDefinition(data)
+}
+
+// ParseDefinition parses the workflow definition from YAML, or JSON which is
+// YAML as well. Fields definitions don't have are rejected, so typos don't
+// go unnoticed, and arguments are checked against their types.
+func ParseDefinition(data []byte) (*Definition, error) {
+	var document interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("Unmarshal YAML error: %s", err)
+	}
+
+	// Decoded as JSON, values of arguments are the same as in received
+	// signatures, e.g. numbers are json.Number
+	document, err := jsonCompatible(document)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := json.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("JSON marshal error: %s", err)
+	}
+
+	definition := new(Definition)
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(definition); err != nil {
+		return nil, fmt.Errorf("Workflow definition error: %s", err)
+	}
+
+	if err := definition.validate("workflow"); err != nil {
+		return nil, err
+	}
+	return definition, nil
+}
+
+// Flow builds a new flow of the definition, each call returns new
+// signatures so the workflow can be sent again
+func (d *Definition) Flow() (*Flow, error) {
+	if err := d.validate("workflow"); err != nil {
+		return nil, err
+	}
+	return d.flow()
+}
+
+func (d *Definition) flow() (*Flow, error) {
+	switch {
+	case d.Task != "":
+		return d.signature().flow(), nil
+	case len(d.Chain) > 0:
+		steps, err := definitionFlows(d.Chain)
+		if err != nil {
+			return nil, err
+		}
+		return NewFlowChain(steps...)
+	default:
+		members, err := definitionFlows(d.Group)
+		if err != nil {
+			return nil, err
+		}
+		group, err := NewFlowGroup(members...)
+		if err != nil || d.Callback == nil {
+			return group, err
+		}
+		callback, err := d.Callback.flow()
+		if err != nil {
+			return nil, err
+		}
+		return NewFlowChain(group, callback)
+	}
+}
+
+func definitionFlows(definitions []*Definition) ([]FlowStep, error) {
+	flows := make([]FlowStep, len(definitions))
+	for i, definition := range definitions {
+		flow, err := definition.flow()
+		if err != nil {
+			return nil, err
+		}
+		flows[i] = flow
+	}
+	return flows, nil
+}
+
+// signature returns a new signature of the task definition
+func (d *Definition) signature() *Signature {
+	signature := &Signature{
+		Name:         d.Task,
+		Args:         append([]Arg{}, d.Args...),
+		RoutingKey:   d.RoutingKey,
+		Priority:     d.Priority,
+		RetryCount:   d.RetryCount,
+		RetryTimeout: d.RetryTimeout,
+		Immutable:    d.Immutable,
+	}
+	for _, onError := range d.OnError {
+		signature.OnError = append(signature.OnError, onError.signature())
+	}
+	return signature
+}
+
+// validate checks the definition is either a task, a chain or a group, the
+// path locates it in errors
+func (d *Definition) validate(path string) error {
+	if d == nil {
+		return fmt.Errorf("%s is empty", path)
+	}
+
+	kinds := 0
+	for _, set := range []bool{d.Task != "", len(d.Chain) > 0, len(d.Group) > 0} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return fmt.Errorf("%s must have exactly one of task, chain or group", path)
+	}
+
+	taskOnly := len(d.Args) > 0 || d.RoutingKey != "" || d.Priority > 0 || d.RetryCount > 0 ||
+		d.RetryTimeout > 0 || d.Immutable || len(d.OnError) > 0
+	if d.Task == "" && taskOnly {
+		return fmt.Errorf("%s sets fields of a task but has no task", path)
+	}
+	if d.Callback != nil && len(d.Group) == 0 {
+		return fmt.Errorf("%s has a callback but no group", path)
+	}
+
+	for i := range d.Args {
+		// Values are normalised to their type, e.g. int64 rather than a
+		// JSON number, so the signature is the same as one built in Go
+		value, err := ReflectValue(d.Args[i].Type, d.Args[i].Value)
+		if err != nil {
+			return fmt.Errorf("%s.args[%d]: %s", path, i, err)
+		}
+		d.Args[i].Value = value.Interface()
+	}
+
+	for i, onError := range d.OnError {
+		onErrorPath := fmt.Sprintf("%s.on_error[%d]", path, i)
+		if onError == nil || onError.Task == "" {
+			return fmt.Errorf("%s must be a task", onErrorPath)
+		}
+		if err := onError.validate(onErrorPath); err != nil {
+			return err
+		}
+	}
+	for i, step := range d.Chain {
+		if err := step.validate(fmt.Sprintf("%s.chain[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	for i, member := range d.Group {
+		if err := member.validate(fmt.Sprintf("%s.group[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	if d.Callback != nil {
+		return d.Callback.validate(path + ".callback")
+	}
+	return nil
+}
+
+// jsonCompatible converts maps decoded from YAML, whose keys may be of any
+// type, to maps which can be encoded to JSON
+func jsonCompatible(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			name, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("Workflow definition error: key %v is not a string", key)
+			}
+			item, err := jsonCompatible(item)
+			if err != nil {
+				return nil, err
+			}
+			converted[name] = item
+		}
+		return converted, nil
+	case []interface{}:
+		converted := make([]interface{}, len(value))
+		for i, item := range value {
+			item, err := jsonCompatible(item)
+			if err != nil {
+				return nil, err
+			}
+			converted[i] = item
+		}
+		return converted, nil
+	}
+	return value, nil
+}
@@ -0,0 +1,113 @@
+package tasks_test
+
+import (
+	"testing"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDefinition(t *testing.T) {
+	t.Parallel()
+
+	definition, err := tasks.ParseDefinition([]byte(`
+chain:
+  - task: fetch
+    args:
+      - type: string
+        value: https://example.com/images
+      - type: "[]int64"
+        value: [1, 2]
+    retry_count: 3
+    on_error:
+      - task: alert
+  - group:
+      - task: resize
+      - chain: [{task: thumbnail}, {task: upload, routing_key: uploads}]
+    callback:
+      task: notify
+      immutable: true
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	fetch := definition.Chain[0]
+	assert.Equal(t, []tasks.Arg{
+		{Type: "string", Value: "https://example.com/images"},
+		{Type: "[]int64", Value: []int64{1, 2}},
+	}, fetch.Args)
+
+	flow, err := definition.Flow()
+	if !assert.NoError(t, err) || !assert.Len(t, flow.Starts, 1) {
+		return
+	}
+
+	// fetch fans out to resize and thumbnail, which is followed by upload
+	start := flow.Starts[0]
+	assert.Equal(t, "fetch", start.Name)
+	assert.Equal(t, 3, start.RetryCount)
+	if assert.Len(t, start.OnError, 1) {
+		assert.Equal(t, "alert", start.OnError[0].Name)
+	}
+	if assert.Len(t, start.OnSuccess, 2) {
+		resize, thumbnail := start.OnSuccess[0], start.OnSuccess[1]
+		assert.Equal(t, "resize", resize.Name)
+		if assert.Len(t, thumbnail.OnSuccess, 1) {
+			upload := thumbnail.OnSuccess[0]
+			assert.Equal(t, "uploads", upload.RoutingKey)
+			assert.Equal(t, resize.GroupUUID, upload.GroupUUID)
+			assert.Equal(t, resize.ChordCallback, upload.ChordCallback)
+		}
+	}
+	if assert.Len(t, flow.Ends, 1) {
+		assert.Equal(t, "notify", flow.Ends[0].Name)
+		assert.True(t, flow.Ends[0].Immutable)
+	}
+
+	// Every flow of the definition is new
+	again, err := definition.Flow()
+	assert.NoError(t, err)
+	assert.NotEqual(t, start.UUID, again.Starts[0].UUID)
+}
+
+func TestParseDefinitionJSON(t *testing.T) {
+	t.Parallel()
+
+	definition, err := tasks.ParseDefinition([]byte(`{"group": [{"task": "add", "args": [{"type": "int64", "value": 1}]}, {"task": "add"}]}`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(1), definition.Group[0].Args[0].Value)
+
+		flow, err := definition.Flow()
+		assert.NoError(t, err)
+		assert.Len(t, flow.Starts, 2)
+		assert.Len(t, flow.Ends, 2)
+	}
+}
+
+func TestParseDefinitionErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name, data, err string
+	}{
+		{name: "empty", data: ``, err: "workflow must have exactly one of task, chain or group"},
+		{name: "unknown field", data: `{task: add, retries: 3}`, err: "unknown field"},
+		{name: "task and chain", data: `{task: add, chain: [{task: add}]}`, err: "workflow must have"},
+		{name: "args without task", data: `{group: [{task: add}], args: [{type: int64, value: 1}]}`, err: "has no task"},
+		{name: "callback without group", data: `{task: add, callback: {task: add}}`, err: "has a callback but no group"},
+		{name: "bad arg", data: `{chain: [{task: add, args: [{type: int64, value: one}]}]}`, err: "workflow.chain[0].args[0]"},
+		{name: "bad on_error", data: `{task: add, on_error: [{chain: [{task: add}]}]}`, err: "workflow.on_error[0] must be a task"},
+		{name: "nil step", data: `{chain: [{task: add}, null]}`, err: "workflow.chain[1] is empty"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := tasks.ParseDefinition([]byte(tc.data))
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tc.err)
+			}
+		})
+	}
+}
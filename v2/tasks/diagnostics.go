@@ -0,0 +1,51 @@
+package tasks
+
+import (
+	"context"
+	"time"
+
+	"github.com/RichardKnop/logging"
+
+	"github.com/RichardKnop/machinery/v2/log"
+)
+
+// Diagnostics is a snapshot of the environment a task failed in, stored
+// along with its state to shorten postmortems, see
+// machinery.Worker.EnableDiagnostics
+type Diagnostics struct {
+	// Signature is the signature of the failed run, with its headers. Its
+	// arguments are left out when encryption is enabled.
+	Signature  *Signature `bson:"signature"`
+	WorkerID   string     `bson:"worker_id"`
+	Hostname   string     `bson:"hostname"`
+	GoVersion  string     `bson:"go_version"`
+	CapturedAt time.Time  `bson:"captured_at"`
+	// Logs are the last lines the task logged with the logger of its
+	// context, see LoggerFromContext
+	Logs []string `bson:"logs"`
+}
+
+type loggerCtxType struct{}
+
+var loggerCtx loggerCtxType
+
+// WithLogger returns a copy of the context carrying the logger
+func WithLogger(ctx context.Context, logger logging.LoggerInterface) context.Context {
+	return context.WithValue(ctx, loggerCtx, logger)
+}
+
+// LoggerFromContext gets the logger of the task from its context. Workers
+// prefix the lines with the task's UUID and keep the last ones for
+// diagnostics. It never returns nil, if the context carries no logger the
+// lines are logged at INFO level.
+func LoggerFromContext(ctx context.Context) logging.LoggerInterface {
+	if ctx == nil {
+		return log.INFO
+	}
+
+	logger, ok := ctx.Value(loggerCtx).(logging.LoggerInterface)
+	if !ok {
+		return log.INFO
+	}
+	return logger
+}
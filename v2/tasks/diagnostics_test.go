@@ -0,0 +1,22 @@
+package tasks_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/RichardKnop/logging"
+	"github.com/RichardKnop/machinery/v2/log"
+	"github.com/RichardKnop/machinery/v2/tasks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoggerFromContext(t *testing.T) {
+	t.Parallel()
+
+	// Without a logger, lines are logged at INFO level
+	assert.Equal(t, log.INFO, tasks.LoggerFromContext(context.Background()))
+
+	logger := logging.New(nil, nil, new(logging.ColouredFormatter))[logging.DEBUG]
+	ctx := tasks.WithLogger(context.Background(), logger)
+	assert.Equal(t, logger, tasks.LoggerFromContext(ctx))
+}
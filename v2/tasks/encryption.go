@@ -0,0 +1,343 @@
+package tasks
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// EncryptionAESGCM is the only supported encryption algorithm
+	EncryptionAESGCM = "aes-gcm"
+
+	// ArgsEncryptionHeader names the algorithm the arguments are encrypted with
+	ArgsEncryptionHeader = "machinery-args-encryption"
+
+	// encryptedTypePrefix marks the type of a single argument or result
+	// holding the encrypted JSON of the original ones
+	encryptedTypePrefix = "encrypted:"
+
+	// dataKeySize is the size of AES-256 data keys
+	dataKeySize = 32
+)
+
+var (
+	// ErrUnknownKey is returned by key providers asked to unwrap a data key
+	// wrapped with a key they do not have
+	ErrUnknownKey = errors.New("Unknown key encryption key")
+)
+
+// KeyProvider wraps and unwraps data keys with a key encryption key, e.g.
+// one stored in a KMS. Every payload is encrypted with a new data key which
+// travels wrapped next to the ciphertext.
+type KeyProvider interface {
+	WrapKey(dataKey []byte) ([]byte, error)
+	UnwrapKey(wrappedKey []byte) ([]byte, error)
+}
+
+// StaticKeyProvider wraps data keys with AES-GCM using keys held in memory.
+// Keys are identified by ID so they can be rotated: new data keys are
+// wrapped with the current key, older ones are unwrapped as long as their
+// key is still present.
+type StaticKeyProvider struct {
+	currentKeyID string
+	keys         map[string]cipher.AEAD
+}
+
+// NewStaticKeyProvider creates StaticKeyProvider instance, keys must be 16,
+// 24 or 32 bytes long
+func NewStaticKeyProvider(currentKeyID string, keys map[string][]byte) (*StaticKeyProvider, error) {
+	if _, ok := keys[currentKeyID]; !ok {
+		return nil, fmt.Errorf("Current key %s not found", currentKeyID)
+	}
+
+	provider := &StaticKeyProvider{
+		currentKeyID: currentKeyID,
+		keys:         make(map[string]cipher.AEAD, len(keys)),
+	}
+	for keyID, key := range keys {
+		if len(keyID) > 255 {
+			return nil, fmt.Errorf("Key ID %s is too long", keyID)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("Key %s error: %s", keyID, err)
+		}
+		provider.keys[keyID] = aead
+	}
+
+	return provider, nil
+}
+
+// WrapKey encrypts the data key with the current key, the result is
+// prefixed with the key ID
+func (p *StaticKeyProvider) WrapKey(dataKey []byte) ([]byte, error) {
+	sealed, err := seal(p.keys[p.currentKeyID], dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped := append([]byte{byte(len(p.currentKeyID))}, p.currentKeyID...)
+	return append(wrapped, sealed...), nil
+}
+
+// UnwrapKey decrypts a data key wrapped by WrapKey
+func (p *StaticKeyProvider) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	if len(wrappedKey) == 0 || len(wrappedKey) < 1+int(wrappedKey[0]) {
+		return nil, errors.New("Wrapped key is truncated")
+	}
+
+	keyID := string(wrappedKey[1 : 1+wrappedKey[0]])
+	aead, ok := p.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	return open(aead, wrappedKey[1+wrappedKey[0]:])
+}
+
+// KeyProviders wraps data keys with the first key provider and unwraps them
+// with whichever is able to, e.g. to decrypt tasks of several queues
+// encrypted with different keys
+type KeyProviders []KeyProvider
+
+// WrapKey wraps the data key with the first key provider
+func (p KeyProviders) WrapKey(dataKey []byte) ([]byte, error) {
+	if len(p) == 0 {
+		return nil, errors.New("No key provider")
+	}
+	return p[0].WrapKey(dataKey)
+}
+
+// UnwrapKey unwraps the data key with the first key provider able to
+func (p KeyProviders) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	err := ErrUnknownKey
+	for _, provider := range p {
+		var dataKey []byte
+		if dataKey, err = provider.UnwrapKey(wrappedKey); err == nil {
+			return dataKey, nil
+		}
+	}
+	return nil, err
+}
+
+// Encryptor encrypts task arguments and results with AES-GCM using data keys
+// wrapped by the key provider
+type Encryptor struct {
+	Keys KeyProvider
+}
+
+// EncryptArgs replaces arguments of the signature and of the callbacks it
+// carries with a single encrypted argument. Arguments added to an already
+// encrypted signature, e.g. results passed to a callback, are encrypted
+// together with the original ones.
+func (e *Encryptor) EncryptArgs(signature *Signature) error {
+	if err := e.DecryptArgs(signature); err != nil {
+		return err
+	}
+
+	if len(signature.Args) > 0 {
+		encrypted, err := e.encrypt(signature.Args)
+		if err != nil {
+			return err
+		}
+
+		if signature.Headers == nil {
+			signature.Headers = make(Headers)
+		}
+		signature.Headers[ArgsEncryptionHeader] = EncryptionAESGCM
+		signature.Args = []Arg{{Type: encryptedTypePrefix + EncryptionAESGCM, Value: encrypted}}
+	}
+
+	for _, callback := range nestedSignatures(signature) {
+		if err := e.EncryptArgs(callback); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DecryptArgs restores the original arguments of a signature encrypted with
+// EncryptArgs, keeping any arguments added around the encrypted one, e.g.
+// the error passed to an error callback. Other signatures are left alone.
+func (e *Encryptor) DecryptArgs(signature *Signature) error {
+	if signature.Headers[ArgsEncryptionHeader] == nil {
+		return nil
+	}
+
+	args := make([]Arg, 0, len(signature.Args))
+	for _, arg := range signature.Args {
+		if !strings.HasPrefix(arg.Type, encryptedTypePrefix) {
+			args = append(args, arg)
+			continue
+		}
+
+		var decrypted []Arg
+		if err := e.decrypt(arg.Type, arg.Value, &decrypted); err != nil {
+			return fmt.Errorf("Decrypt arguments of task %s error: %s", signature.UUID, err)
+		}
+		args = append(args, decrypted...)
+	}
+
+	signature.Args = args
+	delete(signature.Headers, ArgsEncryptionHeader)
+	return nil
+}
+
+// EncryptResults returns the results replaced with a single encrypted result
+func (e *Encryptor) EncryptResults(results []*TaskResult) ([]*TaskResult, error) {
+	if len(results) == 0 {
+		return results, nil
+	}
+
+	encrypted, err := e.encrypt(results)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*TaskResult{{Type: encryptedTypePrefix + EncryptionAESGCM, Value: encrypted}}, nil
+}
+
+// DecryptResults restores the original results encrypted with
+// EncryptResults, other results are returned unchanged
+func (e *Encryptor) DecryptResults(results []*TaskResult) ([]*TaskResult, error) {
+	if len(results) != 1 || !strings.HasPrefix(results[0].Type, encryptedTypePrefix) {
+		return results, nil
+	}
+
+	var decrypted []*TaskResult
+	if err := e.decrypt(results[0].Type, results[0].Value, &decrypted); err != nil {
+		return nil, err
+	}
+
+	return decrypted, nil
+}
+
+// encrypt returns base64 encoded envelope of JSON of v, made of the length
+// of the wrapped data key, the wrapped data key and the sealed JSON
+func (e *Encryptor) encrypt(v interface{}) (string, error) {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("JSON marshal error: %s", err)
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+
+	wrappedKey, err := e.Keys.WrapKey(dataKey)
+	if err != nil {
+		return "", fmt.Errorf("Wrap data key error: %s", err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(aead, plaintext)
+	if err != nil {
+		return "", err
+	}
+
+	envelope := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(wrappedKey)+len(sealed))
+	envelope = envelope[:binary.PutUvarint(envelope, uint64(len(wrappedKey)))]
+	envelope = append(envelope, wrappedKey...)
+	envelope = append(envelope, sealed...)
+
+	return base64.StdEncoding.EncodeToString(envelope), nil
+}
+
+// decrypt opens an envelope produced by encrypt into v
+func (e *Encryptor) decrypt(typeName string, value interface{}, v interface{}) error {
+	if algorithm := strings.TrimPrefix(typeName, encryptedTypePrefix); algorithm != EncryptionAESGCM {
+		return fmt.Errorf("Unsupported encryption algorithm: %s", algorithm)
+	}
+
+	encoded, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("Encrypted value should be a string, got %T", value)
+	}
+
+	envelope, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+
+	keyLen, n := binary.Uvarint(envelope)
+	if n <= 0 || uint64(len(envelope)-n) < keyLen {
+		return errors.New("Encrypted value is truncated")
+	}
+
+	dataKey, err := e.Keys.UnwrapKey(envelope[n : n+int(keyLen)])
+	if err != nil {
+		return fmt.Errorf("Unwrap data key error: %s", err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+	plaintext, err := open(aead, envelope[n+int(keyLen):])
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(plaintext))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// nestedSignatures returns the callbacks travelling inside the signature
+func nestedSignatures(signature *Signature) []*Signature {
+	nested := append([]*Signature{}, signature.OnSuccess...)
+	nested = append(nested, signature.OnError...)
+	nested = append(nested, signature.ChainOnError...)
+	if signature.ChordCallback != nil {
+		nested = append(nested, signature.ChordCallback)
+	}
+	if signature.ChordErrorCallback != nil {
+		nested = append(nested, signature.ChordErrorCallback)
+	}
+	for chord := signature.ChordContinuation; chord != nil; chord = chord.Continuation {
+		nested = append(nested, chord.Group.Tasks...)
+		if chord.Callback != nil {
+			nested = append(nested, chord.Callback)
+		}
+	}
+	return nested
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with a random nonce prepended to the result
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open decrypts a value produced by seal
+func open(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("Encrypted value is truncated")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
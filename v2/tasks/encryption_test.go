@@ -0,0 +1,122 @@
+package tasks_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func newEncryptor(t *testing.T, currentKeyID string, keys map[string][]byte) *tasks.Encryptor {
+	provider, err := tasks.NewStaticKeyProvider(currentKeyID, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tasks.Encryptor{Keys: provider}
+}
+
+func TestEncryptArgs(t *testing.T) {
+	t.Parallel()
+
+	encryptor := newEncryptor(t, "k1", map[string][]byte{"k1": []byte(strings.Repeat("k", 32))})
+	callback := &tasks.Signature{Name: "callback", Args: []tasks.Arg{{Type: "string", Value: "callback secret"}}}
+	signature := &tasks.Signature{
+		Name:      "foo",
+		Args:      []tasks.Arg{{Type: "string", Value: "secret"}},
+		OnSuccess: []*tasks.Signature{callback},
+	}
+
+	assert.NoError(t, encryptor.EncryptArgs(signature))
+	encoded, err := json.Marshal(signature)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(encoded), "secret")
+
+	assert.NoError(t, encryptor.DecryptArgs(signature))
+	assert.Equal(t, []tasks.Arg{{Type: "string", Value: "secret"}}, signature.Args)
+	assert.Nil(t, signature.Headers[tasks.ArgsEncryptionHeader])
+
+	// Arguments added to the encrypted callback are kept in place
+	callback.Args = append([]tasks.Arg{{Type: "string", Value: "error"}}, callback.Args...)
+	callback.Args = append(callback.Args, tasks.Arg{Type: "int64", Value: 1})
+	assert.NoError(t, encryptor.DecryptArgs(callback))
+	assert.Equal(t, []tasks.Arg{
+		{Type: "string", Value: "error"},
+		{Type: "string", Value: "callback secret"},
+		{Type: "int64", Value: 1},
+	}, callback.Args)
+}
+
+func TestEncryptResults(t *testing.T) {
+	t.Parallel()
+
+	keys := map[string][]byte{
+		"old": []byte(strings.Repeat("o", 16)),
+		"new": []byte(strings.Repeat("n", 32)),
+	}
+	legacy := newEncryptor(t, "old", keys)
+	rotated := newEncryptor(t, "new", keys)
+
+	encrypted, err := legacy.EncryptResults([]*tasks.TaskResult{{Type: "string", Value: "secret"}})
+	assert.NoError(t, err)
+	if assert.Len(t, encrypted, 1) {
+		assert.NotContains(t, encrypted[0].Value, "secret")
+	}
+
+	// Results encrypted with a rotated key are still readable
+	results, err := rotated.DecryptResults(encrypted)
+	if assert.NoError(t, err) && assert.Len(t, results, 1) {
+		assert.Equal(t, "secret", results[0].Value)
+	}
+
+	// Plain results are returned unchanged
+	plain := []*tasks.TaskResult{{Type: "string", Value: "plain"}}
+	results, err = rotated.DecryptResults(plain)
+	assert.NoError(t, err)
+	assert.Equal(t, plain, results)
+
+	_, err = newEncryptor(t, "other", map[string][]byte{"other": keys["new"]}).DecryptResults(encrypted)
+	assert.Error(t, err)
+
+	tampered := *encrypted[0]
+	envelope, err := base64.StdEncoding.DecodeString(tampered.Value.(string))
+	assert.NoError(t, err)
+	envelope[len(envelope)-1] ^= 0xff
+	tampered.Value = base64.StdEncoding.EncodeToString(envelope)
+	_, err = rotated.DecryptResults([]*tasks.TaskResult{&tampered})
+	assert.Error(t, err)
+}
+
+func TestNewStaticKeyProvider(t *testing.T) {
+	t.Parallel()
+
+	_, err := tasks.NewStaticKeyProvider("missing", map[string][]byte{"k1": make([]byte, 32)})
+	assert.Error(t, err)
+
+	_, err = tasks.NewStaticKeyProvider("k1", map[string][]byte{"k1": make([]byte, 10)})
+	assert.Error(t, err)
+}
+
+func TestKeyProviders(t *testing.T) {
+	t.Parallel()
+
+	first := newEncryptor(t, "k1", map[string][]byte{"k1": []byte(strings.Repeat("1", 32))})
+	second := newEncryptor(t, "k2", map[string][]byte{"k2": []byte(strings.Repeat("2", 32))})
+	both := &tasks.Encryptor{Keys: tasks.KeyProviders{first.Keys, second.Keys}}
+
+	signature := &tasks.Signature{Name: "foo", Args: []tasks.Arg{{Type: "string", Value: "secret"}}}
+	assert.NoError(t, second.EncryptArgs(signature))
+	assert.Error(t, first.DecryptArgs(signature))
+	assert.NoError(t, both.DecryptArgs(signature))
+	assert.Equal(t, []tasks.Arg{{Type: "string", Value: "secret"}}, signature.Args)
+
+	// Data keys are wrapped with the first key provider
+	assert.NoError(t, both.EncryptArgs(signature))
+	assert.NoError(t, first.DecryptArgs(signature))
+
+	_, err := tasks.KeyProviders{}.UnwrapKey([]byte("key"))
+	assert.Equal(t, tasks.ErrUnknownKey, err)
+}
@@ -1,14 +1,20 @@
 package tasks
 
 import (
+	"errors"
 	"fmt"
 	"time"
 )
 
+// Backoff returns how long to wait before the retry attempt, counting from
+// 0, e.g. to retry a task along a curve of its own
+type Backoff func(attempt int) time.Duration
+
 // ErrRetryTaskLater ...
 type ErrRetryTaskLater struct {
 	name, msg string
 	retryIn   time.Duration
+	backoff   Backoff
 }
 
 // RetryIn returns time.Duration from now when task should be retried
@@ -16,8 +22,23 @@ func (e ErrRetryTaskLater) RetryIn() time.Duration {
 	return e.retryIn
 }
 
+// RetryInAttempt returns how long to wait before the retry attempt, as the
+// backoff says if the error has one, RetryIn otherwise
+func (e ErrRetryTaskLater) RetryInAttempt(attempt int) time.Duration {
+	if e.backoff == nil {
+		return e.retryIn
+	}
+	if retryIn := e.backoff(attempt); retryIn > 0 {
+		return retryIn
+	}
+	return 0
+}
+
 // Error implements the error interface
 func (e ErrRetryTaskLater) Error() string {
+	if e.backoff != nil {
+		return fmt.Sprintf("Task error: %s Will retry with backoff", e.msg)
+	}
 	return fmt.Sprintf("Task error: %s Will retry in: %s", e.msg, e.retryIn)
 }
 
@@ -26,7 +47,80 @@ func NewErrRetryTaskLater(msg string, retryIn time.Duration) ErrRetryTaskLater {
 	return ErrRetryTaskLater{msg: msg, retryIn: retryIn}
 }
 
+// NewErrRetryTaskLaterWithBackoff returns new ErrRetryTaskLater instance
+// retrying the task after the delay the backoff returns for the retry
+// attempt, see RetryAttemptFromContext
+func NewErrRetryTaskLaterWithBackoff(msg string, backoff Backoff) ErrRetryTaskLater {
+	return ErrRetryTaskLater{msg: msg, backoff: backoff}
+}
+
 // Retriable is interface that retriable errors should implement
 type Retriable interface {
 	RetryIn() time.Duration
 }
+
+// ErrPermanent matches errors wrapped with Permanent, e.g.
+// errors.Is(err, ErrPermanent)
+var ErrPermanent = errors.New("Permanent task error")
+
+// permanentError is an error retrying the task can't fix
+type permanentError struct {
+	err error
+}
+
+// Permanent wraps an error retrying the task can't fix, e.g. invalid
+// arguments, so the task fails right away instead of using up its retries
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// Error implements the error interface
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the wrapped error
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// Is makes permanent errors match ErrPermanent
+func (e permanentError) Is(target error) bool {
+	return target == ErrPermanent
+}
+
+// ErrRetryable is an error returned by a task which should be retried after
+// a delay of its own, see Retryable
+type ErrRetryable struct {
+	err   error
+	after time.Duration
+}
+
+// Retryable wraps an error the task should be retried after the delay for,
+// e.g. the Retry-After of a rate-limited API, instead of the delay of the
+// retry policy. Retries still count against the RetryCount of the signature,
+// a zero delay keeps the delay of the retry policy.
+func Retryable(err error, after time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return ErrRetryable{err: err, after: after}
+}
+
+// RetryAfter returns the delay the task should be retried after
+func (e ErrRetryable) RetryAfter() time.Duration {
+	return e.after
+}
+
+// Error implements the error interface
+func (e ErrRetryable) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the wrapped error
+func (e ErrRetryable) Unwrap() error {
+	return e.err
+}
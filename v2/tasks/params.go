@@ -0,0 +1,195 @@
+package tasks
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ParamsFunc is a task function along with the names of its parameters, so
+// signatures may pass arguments to it by name. Create it with WithParams.
+type ParamsFunc struct {
+	Func   interface{}
+	Params []string
+}
+
+// WithParams names the parameters of the task function, except a leading
+// context, so signatures may pass arguments to them by name, e.g. to add an
+// optional parameter without breaking tasks already sent. Register the
+// returned value instead of the function.
+func WithParams(taskFunc interface{}, params ...string) *ParamsFunc {
+	return &ParamsFunc{Func: taskFunc, Params: params}
+}
+
+// validate checks the names match the parameters of the function
+func (p *ParamsFunc) validate() error {
+	if _, ok := p.Func.(*ParamsFunc); ok {
+		return ErrTaskMustBeFunc
+	}
+	if err := ValidateTask(p.Func); err != nil {
+		return err
+	}
+
+	funcType := reflect.TypeOf(p.Func)
+	if funcType.IsVariadic() {
+		return fmt.Errorf("Variadic task functions can't have named parameters")
+	}
+	count := funcType.NumIn()
+	if count > 0 && IsContextType(funcType.In(0)) {
+		count--
+	}
+	if len(p.Params) != count {
+		return fmt.Errorf("Task function takes %d parameters, %d names given", count, len(p.Params))
+	}
+
+	seen := make(map[string]bool, len(p.Params))
+	for _, name := range p.Params {
+		if name == "" || seen[name] {
+			return fmt.Errorf("Parameter names must be unique and not empty, got %q", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// unwrapTaskFunc returns the function of a ParamsFunc along with the names of
+// its parameters, or the task function as it is
+func unwrapTaskFunc(taskFunc interface{}) (interface{}, []string) {
+	if paramsFunc, ok := taskFunc.(*ParamsFunc); ok {
+		return paramsFunc.Func, paramsFunc.Params
+	}
+	return taskFunc, nil
+}
+
+// hasNamedArgs returns true if any of the arguments is passed by name
+func hasNamedArgs(args []Arg) bool {
+	for _, arg := range args {
+		if arg.Name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ReflectNamedArgs converts arguments, some of them passed by name, to the
+// parameters of the task. Arguments without a name fill the parameters in
+// order, named ones the parameter with their name, parameters left are zero
+// values. Without parameter names, named arguments set the fields of the
+// struct the task takes after the positional ones, matched like JSON does.
+func (t *Task) ReflectNamedArgs(args []Arg, params []string) error {
+	funcType := t.TaskFunc.Type()
+	if funcType.IsVariadic() {
+		return fmt.Errorf("named arguments can't be passed to variadic tasks")
+	}
+	paramTypes := make([]reflect.Type, 0, funcType.NumIn())
+	for i := 0; i < funcType.NumIn(); i++ {
+		paramTypes = append(paramTypes, funcType.In(i))
+	}
+	if t.UseContext {
+		paramTypes = paramTypes[1:]
+	}
+
+	values := make([]reflect.Value, len(paramTypes))
+	positional := 0
+	named := make([]Arg, 0, len(args))
+	for _, arg := range args {
+		if arg.Name != "" {
+			named = append(named, arg)
+			continue
+		}
+		if positional >= len(values) {
+			return fmt.Errorf("expected at most %d arguments, got more", len(values))
+		}
+		value, err := ReflectValue(arg.Type, arg.Value)
+		if err != nil {
+			return err
+		}
+		values[positional] = value
+		positional++
+	}
+
+	if params == nil {
+		if positional != len(paramTypes)-1 || paramTypes[positional].Kind() != reflect.Struct {
+			return fmt.Errorf("named arguments need named parameters or a struct parameter")
+		}
+		value, err := reflectStructArgs(paramTypes[positional], named)
+		if err != nil {
+			return err
+		}
+		values[positional] = value
+		t.Args = values
+		return nil
+	}
+
+	for _, arg := range named {
+		index := -1
+		for i, param := range params {
+			if param == arg.Name {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return fmt.Errorf("task has no parameter %s", arg.Name)
+		}
+		if values[index].IsValid() {
+			return fmt.Errorf("parameter %s is passed twice", arg.Name)
+		}
+		value, err := ReflectValue(arg.Type, arg.Value)
+		if err != nil {
+			return err
+		}
+		values[index] = value
+	}
+
+	// Parameters added since the task was sent get their zero value
+	for i, value := range values {
+		if !value.IsValid() {
+			values[i] = reflect.Zero(paramTypes[i])
+		}
+	}
+	t.Args = values
+	return nil
+}
+
+// reflectStructArgs sets the fields of a new struct of the type to the named
+// arguments
+func reflectStructArgs(structType reflect.Type, args []Arg) (reflect.Value, error) {
+	value := reflect.New(structType).Elem()
+	for _, arg := range args {
+		field, ok := argField(structType, arg.Name)
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("%s has no field %s", structType, arg.Name)
+		}
+		argValue, err := ReflectValue(arg.Type, arg.Value)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if !argValue.Type().AssignableTo(field.Type) {
+			return reflect.Value{}, fmt.Errorf("argument %s is %s, expected %s", arg.Name, argValue.Type(), field.Type)
+		}
+		value.FieldByIndex(field.Index).Set(argValue)
+	}
+	return value, nil
+}
+
+// argField returns the exported field of the struct the named argument sets,
+// named by its JSON tag or its name, case-insensitively
+func argField(structType reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		fieldName := field.Name
+		if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			fieldName = tag
+		}
+		if strings.EqualFold(fieldName, name) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
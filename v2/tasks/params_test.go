@@ -0,0 +1,111 @@
+package tasks_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithParamsValidate(t *testing.T) {
+	t.Parallel()
+
+	sendEmail := func(ctx context.Context, to, locale string) error { return nil }
+	assert.NoError(t, tasks.ValidateTask(tasks.WithParams(sendEmail, "to", "locale")))
+	assert.Error(t, tasks.ValidateTask(tasks.WithParams(sendEmail, "to")))
+	assert.Error(t, tasks.ValidateTask(tasks.WithParams(sendEmail, "to", "to")))
+	assert.Error(t, tasks.ValidateTask(tasks.WithParams(func(names ...string) error { return nil }, "names")))
+	assert.Equal(t, tasks.ErrTaskMustBeFunc, tasks.ValidateTask(tasks.WithParams(tasks.WithParams(sendEmail, "to", "locale"))))
+}
+
+func TestNamedArgs(t *testing.T) {
+	t.Parallel()
+
+	var to, locale string
+	var retries int64
+	sendEmail := tasks.WithParams(func(ctx context.Context, t, l string, r int64) error {
+		to, locale, retries = t, l, r
+		return nil
+	}, "to", "locale", "retries")
+	call := func(args ...tasks.Arg) error {
+		task, err := tasks.NewWithSignature(sendEmail, &tasks.Signature{Name: "send_email", Args: args})
+		if err != nil {
+			return err
+		}
+		_, err = task.Call()
+		return err
+	}
+
+	// Named arguments are passed to the parameter with their name, in any
+	// order, after positional ones
+	err := call(
+		tasks.Arg{Type: "string", Value: "alice@example.com"},
+		tasks.Arg{Name: "retries", Type: "int64", Value: int64(3)},
+		tasks.Arg{Name: "locale", Type: "string", Value: "fr"},
+	)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "alice@example.com", to)
+		assert.Equal(t, "fr", locale)
+		assert.Equal(t, int64(3), retries)
+	}
+
+	// Parameters without an argument get their zero value
+	err = call(tasks.Arg{Name: "to", Type: "string", Value: "bob@example.com"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "bob@example.com", to)
+		assert.Equal(t, "", locale)
+		assert.Equal(t, int64(0), retries)
+	}
+
+	// Positional arguments only are passed as they are
+	assert.Error(t, call(tasks.Arg{Type: "string", Value: "bob@example.com"}))
+
+	assert.Error(t, call(tasks.Arg{Name: "cc", Type: "string", Value: "carol@example.com"}))
+	assert.Error(t, call(
+		tasks.Arg{Type: "string", Value: "alice@example.com"},
+		tasks.Arg{Name: "to", Type: "string", Value: "bob@example.com"},
+	))
+	assert.Error(t, call(tasks.Arg{Name: "retries", Type: "string", Value: "three"}))
+}
+
+type emailArgs struct {
+	To      string
+	Locale  string `json:"lang"`
+	Retries int64  `json:"-"`
+	secret  string
+}
+
+func TestNamedArgsStruct(t *testing.T) {
+	t.Parallel()
+
+	var got emailArgs
+	sendEmail := func(ctx context.Context, args emailArgs) error {
+		got = args
+		return nil
+	}
+
+	task, err := tasks.NewWithSignature(sendEmail, &tasks.Signature{Name: "send_email", Args: []tasks.Arg{
+		{Name: "to", Type: "string", Value: "alice@example.com"},
+		{Name: "lang", Type: "string", Value: "fr"},
+	}})
+	if assert.NoError(t, err) {
+		_, err = task.Call()
+		assert.NoError(t, err)
+		assert.Equal(t, emailArgs{To: "alice@example.com", Locale: "fr"}, got)
+	}
+
+	for _, name := range []string{"Locale", "Retries", "secret", "cc"} {
+		_, err = tasks.NewWithSignature(sendEmail, &tasks.Signature{Name: "send_email", Args: []tasks.Arg{
+			{Name: name, Type: "string", Value: "x"},
+		}})
+		assert.Error(t, err, name)
+	}
+
+	// Functions without a struct or parameter names don't take named
+	// arguments
+	_, err = tasks.NewWithSignature(func(to string) error { return nil }, &tasks.Signature{Name: "send_email", Args: []tasks.Arg{
+		{Name: "to", Type: "string", Value: "alice@example.com"},
+	}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,31 @@
+package tasks
+
+import (
+	"time"
+)
+
+// WorkerInfo is the heartbeat of a live worker, listed by backends keeping
+// the presence of workers, see machinery.Server.ListWorkers
+type WorkerInfo struct {
+	// ID is unique per launched worker, ConsumerTag may be shared
+	ID          string   `bson:"id"`
+	ConsumerTag string   `bson:"consumer_tag"`
+	Hostname    string   `bson:"hostname"`
+	PID         int      `bson:"pid"`
+	Queues      []string `bson:"queues"`
+	// Concurrency is how many tasks the worker runs at once, Paused is set
+	// if it was paused, see tasks.ControlPause
+	Concurrency int  `bson:"concurrency"`
+	Paused      bool `bson:"paused"`
+	// Running are the tasks the worker was running at the heartbeat
+	Running     []*RunningTask `bson:"running"`
+	StartedAt   time.Time      `bson:"started_at"`
+	HeartbeatAt time.Time      `bson:"heartbeat_at"`
+}
+
+// RunningTask is a task a worker was running at its heartbeat
+type RunningTask struct {
+	UUID      string    `bson:"uuid"`
+	Name      string    `bson:"name"`
+	StartedAt time.Time `bson:"started_at"`
+}
@@ -0,0 +1,68 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Progress is the progress a running task reported last, e.g. to show it in
+// a UI while a long import runs
+type Progress struct {
+	// Percent is between 0 and 100
+	Percent   float64   `bson:"percent"`
+	Message   string    `bson:"message"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// ProgressReporter reports the progress of a running task, get it with
+// ProgressReporterFromContext
+type ProgressReporter interface {
+	ReportProgress(percent float64, message string) error
+}
+
+// ProgressReporterFunc adapts a function to a ProgressReporter
+type ProgressReporterFunc func(percent float64, message string) error
+
+// ReportProgress calls the function
+func (f ProgressReporterFunc) ReportProgress(percent float64, message string) error {
+	return f(percent, message)
+}
+
+type progressReporterCtxType struct{}
+
+var progressReporterCtx progressReporterCtxType
+
+// noProgressReporter drops the progress of tasks run by workers whose
+// backend can't store it
+var noProgressReporter = ProgressReporterFunc(func(percent float64, message string) error {
+	return nil
+})
+
+// WithProgressReporter returns a copy of the context carrying the reporter
+func WithProgressReporter(ctx context.Context, reporter ProgressReporter) context.Context {
+	return context.WithValue(ctx, progressReporterCtx, reporter)
+}
+
+// ProgressReporterFromContext gets the progress reporter of the task from
+// its context. It never returns nil, if the context carries no reporter the
+// progress is dropped.
+func ProgressReporterFromContext(ctx context.Context) ProgressReporter {
+	if ctx == nil {
+		return noProgressReporter
+	}
+
+	reporter, ok := ctx.Value(progressReporterCtx).(ProgressReporter)
+	if !ok {
+		return noProgressReporter
+	}
+	return reporter
+}
+
+// NewProgress returns the progress, checking its percentage
+func NewProgress(percent float64, message string) (*Progress, error) {
+	if percent < 0 || percent > 100 {
+		return nil, fmt.Errorf("Progress %v is not between 0 and 100 percent", percent)
+	}
+	return &Progress{Percent: percent, Message: message, UpdatedAt: time.Now().UTC()}, nil
+}
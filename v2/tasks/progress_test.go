@@ -0,0 +1,41 @@
+package tasks_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressReporterFromContext(t *testing.T) {
+	t.Parallel()
+
+	// Without a reporter, progress is dropped
+	reporter := tasks.ProgressReporterFromContext(context.Background())
+	assert.NoError(t, reporter.ReportProgress(50, "halfway"))
+
+	var reported []float64
+	ctx := tasks.WithProgressReporter(context.Background(), tasks.ProgressReporterFunc(func(percent float64, message string) error {
+		reported = append(reported, percent)
+		return nil
+	}))
+	assert.NoError(t, tasks.ProgressReporterFromContext(ctx).ReportProgress(10, "started"))
+	assert.Equal(t, []float64{10}, reported)
+}
+
+func TestNewProgress(t *testing.T) {
+	t.Parallel()
+
+	progress, err := tasks.NewProgress(42.5, "importing rows")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 42.5, progress.Percent)
+		assert.Equal(t, "importing rows", progress.Message)
+		assert.False(t, progress.UpdatedAt.IsZero())
+	}
+
+	_, err = tasks.NewProgress(101, "")
+	assert.Error(t, err)
+	_, err = tasks.NewProgress(-1, "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,890 @@
+package tasks
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// WireFormatJSON encodes signatures and task states as JSON, the default
+	WireFormatJSON = "json"
+	// WireFormatProtobuf encodes signatures and task states with the
+	// messages of tasks.proto, smaller than JSON and readable from other
+	// languages with generated code
+	WireFormatProtobuf = "protobuf"
+)
+
+// errUnknownField is returned by field decoders for fields they don't know
+var errUnknownField = errors.New("unknown field")
+
+// EncodeSignature encodes the signature in the wire format, JSON if empty
+func EncodeSignature(signature *Signature, format string) ([]byte, error) {
+	switch format {
+	case "", WireFormatJSON:
+		return json.Marshal(signature)
+	case WireFormatProtobuf:
+		return appendSignature(nil, signature)
+	}
+	return nil, fmt.Errorf("Unknown wire format %q", format)
+}
+
+// EncodeTaskState encodes the task state in the wire format, JSON if empty
+func EncodeTaskState(taskState *TaskState, format string) ([]byte, error) {
+	switch format {
+	case "", WireFormatJSON:
+		return json.Marshal(taskState)
+	case WireFormatProtobuf:
+		return appendTaskState(nil, taskState)
+	}
+	return nil, fmt.Errorf("Unknown wire format %q", format)
+}
+
+// DecodeTaskState decodes a task state encoded in either wire format
+func DecodeTaskState(data []byte) (*TaskState, error) {
+	if isJSON(data) {
+		taskState := new(TaskState)
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(taskState); err != nil {
+			return nil, err
+		}
+		return taskState, nil
+	}
+	return decodeTaskState(data, false)
+}
+
+// isJSON tells JSON objects from protobuf messages, which never start with
+// '{' as it is the tag of a deprecated group with field number 15. Messages
+// with a UUID start with '\n', the tag of field 1, rather than JSON
+// whitespace. Empty data is left to the JSON decoder to reject.
+func isJSON(data []byte) bool {
+	if len(data) > 0 && data[0] == '\n' {
+		return false
+	}
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	return len(trimmed) == 0 || trimmed[0] == '{'
+}
+
+func appendString(b []byte, num protowire.Number, value string) []byte {
+	if value == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, value)
+}
+
+func appendBytes(b []byte, num protowire.Number, value []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, value)
+}
+
+func appendInt(b []byte, num protowire.Number, value int64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(value))
+}
+
+func appendBool(b []byte, num protowire.Number, value bool) []byte {
+	if !value {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, protowire.EncodeBool(value))
+}
+
+func appendDouble(b []byte, num protowire.Number, value float64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(value))
+}
+
+// appendTime appends a google.protobuf.Timestamp
+func appendTime(b []byte, num protowire.Number, value time.Time) []byte {
+	var timestamp []byte
+	timestamp = appendInt(timestamp, 1, value.Unix())
+	timestamp = appendInt(timestamp, 2, int64(value.Nanosecond()))
+	return appendBytes(b, num, timestamp)
+}
+
+func appendTimePtr(b []byte, num protowire.Number, value *time.Time) []byte {
+	if value == nil {
+		return b
+	}
+	return appendTime(b, num, *value)
+}
+
+// appendJSON appends the value JSON encoded, values of arguments, headers
+// and results are only typed by the type names travelling with them
+func appendJSON(b []byte, num protowire.Number, value interface{}) ([]byte, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return appendBytes(b, num, encoded), nil
+}
+
+func appendSignatures(b []byte, num protowire.Number, signatures ...*Signature) ([]byte, error) {
+	for _, signature := range signatures {
+		encoded, err := appendSignature(nil, signature)
+		if err != nil {
+			return nil, err
+		}
+		b = appendBytes(b, num, encoded)
+	}
+	return b, nil
+}
+
+func appendSignature(b []byte, s *Signature) ([]byte, error) {
+	if s == nil {
+		return nil, errors.New("cannot encode a nil signature")
+	}
+
+	var err error
+	b = appendString(b, 1, s.UUID)
+	b = appendString(b, 2, s.Name)
+	b = appendString(b, 3, s.RoutingKey)
+	b = appendTimePtr(b, 4, s.ETA)
+	b = appendString(b, 5, s.GroupUUID)
+	b = appendInt(b, 6, int64(s.GroupTaskCount))
+	for _, arg := range s.Args {
+		var encoded []byte
+		encoded = appendString(encoded, 1, arg.Name)
+		encoded = appendString(encoded, 2, arg.Type)
+		if encoded, err = appendJSON(encoded, 3, arg.Value); err != nil {
+			return nil, fmt.Errorf("argument %s of signature %s: %s", arg.Name, s.UUID, err)
+		}
+		b = appendBytes(b, 7, encoded)
+	}
+	if s.Headers != nil {
+		if b, err = appendJSON(b, 8, s.Headers); err != nil {
+			return nil, fmt.Errorf("headers of signature %s: %s", s.UUID, err)
+		}
+	}
+	b = appendInt(b, 9, int64(s.Priority))
+	b = appendBool(b, 10, s.Immutable)
+	b = appendInt(b, 11, int64(s.RetryCount))
+	b = appendInt(b, 12, int64(s.RetryTimeout))
+	if b, err = appendSignatures(b, 13, s.OnSuccess...); err != nil {
+		return nil, err
+	}
+	if b, err = appendSignatures(b, 14, s.OnError...); err != nil {
+		return nil, err
+	}
+	if s.ChordCallback != nil {
+		if b, err = appendSignatures(b, 15, s.ChordCallback); err != nil {
+			return nil, err
+		}
+	}
+	if b, err = appendSignatures(b, 16, s.ChainOnError...); err != nil {
+		return nil, err
+	}
+	if s.ChordErrorCallback != nil {
+		if b, err = appendSignatures(b, 17, s.ChordErrorCallback); err != nil {
+			return nil, err
+		}
+	}
+	if s.GroupFailurePolicy != nil {
+		b = appendBytes(b, 18, appendGroupFailurePolicy(nil, s.GroupFailurePolicy))
+	}
+	if s.ChordContinuation != nil {
+		encoded, err := appendChord(nil, s.ChordContinuation)
+		if err != nil {
+			return nil, err
+		}
+		b = appendBytes(b, 19, encoded)
+	}
+	if s.Compensation != nil {
+		if b, err = appendSignatures(b, 20, s.Compensation); err != nil {
+			return nil, err
+		}
+	}
+	if s.Singleton != nil {
+		var encoded []byte
+		encoded = appendString(encoded, 1, s.Singleton.Key)
+		encoded = appendString(encoded, 2, string(s.Singleton.Overlap))
+		if !s.Singleton.NextRun.IsZero() {
+			encoded = appendTime(encoded, 3, s.Singleton.NextRun)
+		}
+		b = appendBytes(b, 21, encoded)
+	}
+	b = appendString(b, 22, s.BrokerMessageGroupId)
+	b = appendString(b, 23, s.SQSReceiptHandle)
+	b = appendBool(b, 24, s.StopTaskDeletionOnError)
+	b = appendBool(b, 25, s.IgnoreWhenTaskNotRegistered)
+	b = appendInt(b, 26, int64(s.Timeout))
+	b = appendInt(b, 27, int64(s.ResultsExpireIn))
+	b = appendString(b, 28, string(s.ResultArgs))
+	if len(s.ResultIndexes) > 0 {
+		var packed []byte
+		for _, index := range s.ResultIndexes {
+			packed = protowire.AppendVarint(packed, uint64(index))
+		}
+		b = appendBytes(b, 29, packed)
+	}
+	b = appendString(b, 30, s.IdempotencyKey)
+	b = appendTimePtr(b, 31, s.ExpiresAt)
+	b = appendTimePtr(b, 32, s.EnqueuedAt)
+	if s.RetryPolicy != nil {
+		var encoded []byte
+		encoded = appendInt(encoded, 1, int64(s.RetryPolicy.Base))
+		encoded = appendDouble(encoded, 2, s.RetryPolicy.Multiplier)
+		encoded = appendInt(encoded, 3, int64(s.RetryPolicy.Max))
+		encoded = appendBool(encoded, 4, s.RetryPolicy.Jitter)
+		b = appendBytes(b, 33, encoded)
+	}
+	b = appendInt(b, 34, int64(s.RetryAttempt))
+	b = appendString(b, 35, s.UniqueKey)
+	b = appendBool(b, 36, s.UniqueByArgs)
+	b = appendBool(b, 37, s.UniqueReject)
+	b = appendString(b, 38, s.Version)
+	return b, nil
+}
+
+func appendGroupFailurePolicy(b []byte, policy *GroupFailurePolicy) []byte {
+	b = appendInt(b, 1, int64(policy.Mode))
+	return appendInt(b, 2, int64(policy.Threshold))
+}
+
+func appendGroup(b []byte, group *Group) ([]byte, error) {
+	var err error
+	b = appendString(b, 1, group.GroupUUID)
+	if b, err = appendSignatures(b, 2, group.Tasks...); err != nil {
+		return nil, err
+	}
+	b = appendInt(b, 3, int64(group.Timeout))
+	if group.FailurePolicy != nil {
+		b = appendBytes(b, 4, appendGroupFailurePolicy(nil, group.FailurePolicy))
+	}
+	return b, nil
+}
+
+func appendChord(b []byte, chord *Chord) ([]byte, error) {
+	if chord.Group != nil {
+		encoded, err := appendGroup(nil, chord.Group)
+		if err != nil {
+			return nil, err
+		}
+		b = appendBytes(b, 1, encoded)
+	}
+	var err error
+	if chord.Callback != nil {
+		if b, err = appendSignatures(b, 2, chord.Callback); err != nil {
+			return nil, err
+		}
+	}
+	if chord.Continuation != nil {
+		encoded, err := appendChord(nil, chord.Continuation)
+		if err != nil {
+			return nil, err
+		}
+		b = appendBytes(b, 3, encoded)
+	}
+	if chord.ErrorCallback != nil {
+		if b, err = appendSignatures(b, 4, chord.ErrorCallback); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func appendTaskState(b []byte, s *TaskState) ([]byte, error) {
+	var err error
+	b = appendString(b, 1, s.TaskUUID)
+	b = appendString(b, 2, s.TaskName)
+	b = appendString(b, 3, s.State)
+	for _, result := range s.Results {
+		var encoded []byte
+		if result != nil {
+			encoded = appendString(encoded, 1, result.Type)
+			if encoded, err = appendJSON(encoded, 2, result.Value); err != nil {
+				return nil, fmt.Errorf("result of task %s: %s", s.TaskUUID, err)
+			}
+		}
+		b = appendBytes(b, 4, encoded)
+	}
+	b = appendString(b, 5, s.Error)
+	if !s.CreatedAt.IsZero() {
+		b = appendTime(b, 6, s.CreatedAt)
+	}
+	b = appendInt(b, 7, s.TTL)
+	if s.Progress != nil {
+		var encoded []byte
+		encoded = appendDouble(encoded, 1, s.Progress.Percent)
+		encoded = appendString(encoded, 2, s.Progress.Message)
+		if !s.Progress.UpdatedAt.IsZero() {
+			encoded = appendTime(encoded, 3, s.Progress.UpdatedAt)
+		}
+		b = appendBytes(b, 8, encoded)
+	}
+	b = appendTimePtr(b, 9, s.EnqueuedAt)
+	b = appendTimePtr(b, 10, s.StartedAt)
+	b = appendTimePtr(b, 11, s.FinishedAt)
+	b = appendInt(b, 12, int64(s.QueueWait))
+	b = appendInt(b, 13, int64(s.Duration))
+	if s.Diagnostics != nil {
+		var encoded []byte
+		if s.Diagnostics.Signature != nil {
+			if encoded, err = appendSignatures(encoded, 1, s.Diagnostics.Signature); err != nil {
+				return nil, err
+			}
+		}
+		encoded = appendString(encoded, 2, s.Diagnostics.WorkerID)
+		encoded = appendString(encoded, 3, s.Diagnostics.Hostname)
+		encoded = appendString(encoded, 4, s.Diagnostics.GoVersion)
+		if !s.Diagnostics.CapturedAt.IsZero() {
+			encoded = appendTime(encoded, 5, s.Diagnostics.CapturedAt)
+		}
+		for _, line := range s.Diagnostics.Logs {
+			encoded = protowire.AppendTag(encoded, 6, protowire.BytesType)
+			encoded = protowire.AppendString(encoded, line)
+		}
+		b = appendBytes(b, 14, encoded)
+	}
+	return b, nil
+}
+
+// protoField is a field of a protobuf message being decoded
+type protoField struct {
+	num     protowire.Number
+	typ     protowire.Type
+	varint  uint64
+	fixed64 uint64
+	bytes   []byte
+}
+
+func (f protoField) wrongType() error {
+	return fmt.Errorf("field %d has unexpected wire type %d", f.num, f.typ)
+}
+
+func (f protoField) string() (string, error) {
+	if f.typ != protowire.BytesType {
+		return "", f.wrongType()
+	}
+	return string(f.bytes), nil
+}
+
+func (f protoField) int() (int64, error) {
+	if f.typ != protowire.VarintType {
+		return 0, f.wrongType()
+	}
+	return int64(f.varint), nil
+}
+
+func (f protoField) bool() (bool, error) {
+	if f.typ != protowire.VarintType {
+		return false, f.wrongType()
+	}
+	return protowire.DecodeBool(f.varint), nil
+}
+
+func (f protoField) double() (float64, error) {
+	if f.typ != protowire.Fixed64Type {
+		return 0, f.wrongType()
+	}
+	return math.Float64frombits(f.fixed64), nil
+}
+
+func (f protoField) message() ([]byte, error) {
+	if f.typ != protowire.BytesType {
+		return nil, f.wrongType()
+	}
+	return f.bytes, nil
+}
+
+// ints decodes repeated integers, packed or not
+func (f protoField) ints() ([]int, error) {
+	switch f.typ {
+	case protowire.VarintType:
+		return []int{int(int64(f.varint))}, nil
+	case protowire.BytesType:
+		var values []int
+		for data := f.bytes; len(data) > 0; {
+			value, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			values = append(values, int(int64(value)))
+			data = data[n:]
+		}
+		return values, nil
+	}
+	return nil, f.wrongType()
+}
+
+// time decodes a google.protobuf.Timestamp
+func (f protoField) time(strict bool) (time.Time, error) {
+	data, err := f.message()
+	if err != nil {
+		return time.Time{}, err
+	}
+	var seconds, nanos int64
+	err = decodeFields(data, strict, func(field protoField) (err error) {
+		switch field.num {
+		case 1:
+			seconds, err = field.int()
+		case 2:
+			nanos, err = field.int()
+		default:
+			return errUnknownField
+		}
+		return err
+	})
+	return time.Unix(seconds, nanos).UTC(), err
+}
+
+func (f protoField) timePtr(strict bool) (*time.Time, error) {
+	value, err := f.time(strict)
+	if err != nil {
+		return nil, err
+	}
+	return &value, nil
+}
+
+// json decodes a JSON encoded value, numbers as json.Number like signatures
+// and states received as JSON
+func (f protoField) json(value interface{}) error {
+	data, err := f.message()
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(value)
+}
+
+// decodeFields calls decode with the fields of the message. Fields decode
+// doesn't know are ignored, in strict mode they are rejected.
+func decodeFields(data []byte, strict bool, decode func(field protoField) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		field := protoField{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			field.varint, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			field.fixed64, n = protowire.ConsumeFixed64(data)
+		case protowire.BytesType:
+			field.bytes, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		err := decode(field)
+		if err == errUnknownField {
+			if strict {
+				return fmt.Errorf("unknown field %d", num)
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeSignature(data []byte, strict bool) (*Signature, error) {
+	s := new(Signature)
+	err := decodeFields(data, strict, func(f protoField) (err error) {
+		var value int64
+		switch f.num {
+		case 1:
+			s.UUID, err = f.string()
+		case 2:
+			s.Name, err = f.string()
+		case 3:
+			s.RoutingKey, err = f.string()
+		case 4:
+			s.ETA, err = f.timePtr(strict)
+		case 5:
+			s.GroupUUID, err = f.string()
+		case 6:
+			value, err = f.int()
+			s.GroupTaskCount = int(value)
+		case 7:
+			var arg Arg
+			arg, err = decodeArg(f, strict)
+			s.Args = append(s.Args, arg)
+		case 8:
+			err = f.json(&s.Headers)
+		case 9:
+			value, err = f.int()
+			s.Priority = uint8(value)
+		case 10:
+			s.Immutable, err = f.bool()
+		case 11:
+			value, err = f.int()
+			s.RetryCount = int(value)
+		case 12:
+			value, err = f.int()
+			s.RetryTimeout = int(value)
+		case 13:
+			var signature *Signature
+			signature, err = decodeNestedSignature(f, strict)
+			s.OnSuccess = append(s.OnSuccess, signature)
+		case 14:
+			var signature *Signature
+			signature, err = decodeNestedSignature(f, strict)
+			s.OnError = append(s.OnError, signature)
+		case 15:
+			s.ChordCallback, err = decodeNestedSignature(f, strict)
+		case 16:
+			var signature *Signature
+			signature, err = decodeNestedSignature(f, strict)
+			s.ChainOnError = append(s.ChainOnError, signature)
+		case 17:
+			s.ChordErrorCallback, err = decodeNestedSignature(f, strict)
+		case 18:
+			s.GroupFailurePolicy, err = decodeGroupFailurePolicy(f, strict)
+		case 19:
+			s.ChordContinuation, err = decodeChord(f, strict)
+		case 20:
+			s.Compensation, err = decodeNestedSignature(f, strict)
+		case 21:
+			s.Singleton, err = decodeSingleton(f, strict)
+		case 22:
+			s.BrokerMessageGroupId, err = f.string()
+		case 23:
+			s.SQSReceiptHandle, err = f.string()
+		case 24:
+			s.StopTaskDeletionOnError, err = f.bool()
+		case 25:
+			s.IgnoreWhenTaskNotRegistered, err = f.bool()
+		case 26:
+			value, err = f.int()
+			s.Timeout = time.Duration(value)
+		case 27:
+			value, err = f.int()
+			s.ResultsExpireIn = int(value)
+		case 28:
+			var resultArgs string
+			resultArgs, err = f.string()
+			s.ResultArgs = ResultArgs(resultArgs)
+		case 29:
+			var indexes []int
+			indexes, err = f.ints()
+			s.ResultIndexes = append(s.ResultIndexes, indexes...)
+		case 30:
+			s.IdempotencyKey, err = f.string()
+		case 31:
+			s.ExpiresAt, err = f.timePtr(strict)
+		case 32:
+			s.EnqueuedAt, err = f.timePtr(strict)
+		case 33:
+			s.RetryPolicy, err = decodeRetryPolicy(f, strict)
+		case 34:
+			value, err = f.int()
+			s.RetryAttempt = int(value)
+		case 35:
+			s.UniqueKey, err = f.string()
+		case 36:
+			s.UniqueByArgs, err = f.bool()
+		case 37:
+			s.UniqueReject, err = f.bool()
+		case 38:
+			s.Version, err = f.string()
+		default:
+			return errUnknownField
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func decodeNestedSignature(f protoField, strict bool) (*Signature, error) {
+	data, err := f.message()
+	if err != nil {
+		return nil, err
+	}
+	return decodeSignature(data, strict)
+}
+
+func decodeArg(f protoField, strict bool) (Arg, error) {
+	var arg Arg
+	data, err := f.message()
+	if err != nil {
+		return arg, err
+	}
+	err = decodeFields(data, strict, func(f protoField) (err error) {
+		switch f.num {
+		case 1:
+			arg.Name, err = f.string()
+		case 2:
+			arg.Type, err = f.string()
+		case 3:
+			err = f.json(&arg.Value)
+		default:
+			return errUnknownField
+		}
+		return err
+	})
+	return arg, err
+}
+
+func decodeRetryPolicy(f protoField, strict bool) (*RetryPolicy, error) {
+	data, err := f.message()
+	if err != nil {
+		return nil, err
+	}
+	policy := new(RetryPolicy)
+	err = decodeFields(data, strict, func(f protoField) (err error) {
+		var value int64
+		switch f.num {
+		case 1:
+			value, err = f.int()
+			policy.Base = time.Duration(value)
+		case 2:
+			policy.Multiplier, err = f.double()
+		case 3:
+			value, err = f.int()
+			policy.Max = time.Duration(value)
+		case 4:
+			policy.Jitter, err = f.bool()
+		default:
+			return errUnknownField
+		}
+		return err
+	})
+	return policy, err
+}
+
+func decodeGroupFailurePolicy(f protoField, strict bool) (*GroupFailurePolicy, error) {
+	data, err := f.message()
+	if err != nil {
+		return nil, err
+	}
+	policy := new(GroupFailurePolicy)
+	err = decodeFields(data, strict, func(f protoField) (err error) {
+		var value int64
+		switch f.num {
+		case 1:
+			value, err = f.int()
+			policy.Mode = GroupFailureMode(value)
+		case 2:
+			value, err = f.int()
+			policy.Threshold = int(value)
+		default:
+			return errUnknownField
+		}
+		return err
+	})
+	return policy, err
+}
+
+func decodeSingleton(f protoField, strict bool) (*Singleton, error) {
+	data, err := f.message()
+	if err != nil {
+		return nil, err
+	}
+	singleton := new(Singleton)
+	err = decodeFields(data, strict, func(f protoField) (err error) {
+		switch f.num {
+		case 1:
+			singleton.Key, err = f.string()
+		case 2:
+			var overlap string
+			overlap, err = f.string()
+			singleton.Overlap = OverlapPolicy(overlap)
+		case 3:
+			singleton.NextRun, err = f.time(strict)
+		default:
+			return errUnknownField
+		}
+		return err
+	})
+	return singleton, err
+}
+
+func decodeGroup(f protoField, strict bool) (*Group, error) {
+	data, err := f.message()
+	if err != nil {
+		return nil, err
+	}
+	group := new(Group)
+	err = decodeFields(data, strict, func(f protoField) (err error) {
+		switch f.num {
+		case 1:
+			group.GroupUUID, err = f.string()
+		case 2:
+			var signature *Signature
+			signature, err = decodeNestedSignature(f, strict)
+			group.Tasks = append(group.Tasks, signature)
+		case 3:
+			var value int64
+			value, err = f.int()
+			group.Timeout = time.Duration(value)
+		case 4:
+			group.FailurePolicy, err = decodeGroupFailurePolicy(f, strict)
+		default:
+			return errUnknownField
+		}
+		return err
+	})
+	return group, err
+}
+
+func decodeChord(f protoField, strict bool) (*Chord, error) {
+	data, err := f.message()
+	if err != nil {
+		return nil, err
+	}
+	chord := new(Chord)
+	err = decodeFields(data, strict, func(f protoField) (err error) {
+		switch f.num {
+		case 1:
+			chord.Group, err = decodeGroup(f, strict)
+		case 2:
+			chord.Callback, err = decodeNestedSignature(f, strict)
+		case 3:
+			chord.Continuation, err = decodeChord(f, strict)
+		case 4:
+			chord.ErrorCallback, err = decodeNestedSignature(f, strict)
+		default:
+			return errUnknownField
+		}
+		return err
+	})
+	return chord, err
+}
+
+func decodeTaskState(data []byte, strict bool) (*TaskState, error) {
+	s := new(TaskState)
+	err := decodeFields(data, strict, func(f protoField) (err error) {
+		var value int64
+		switch f.num {
+		case 1:
+			s.TaskUUID, err = f.string()
+		case 2:
+			s.TaskName, err = f.string()
+		case 3:
+			s.State, err = f.string()
+		case 4:
+			var result *TaskResult
+			result, err = decodeTaskResult(f, strict)
+			s.Results = append(s.Results, result)
+		case 5:
+			s.Error, err = f.string()
+		case 6:
+			s.CreatedAt, err = f.time(strict)
+		case 7:
+			s.TTL, err = f.int()
+		case 8:
+			s.Progress, err = decodeProgress(f, strict)
+		case 9:
+			s.EnqueuedAt, err = f.timePtr(strict)
+		case 10:
+			s.StartedAt, err = f.timePtr(strict)
+		case 11:
+			s.FinishedAt, err = f.timePtr(strict)
+		case 12:
+			value, err = f.int()
+			s.QueueWait = time.Duration(value)
+		case 13:
+			value, err = f.int()
+			s.Duration = time.Duration(value)
+		case 14:
+			s.Diagnostics, err = decodeDiagnostics(f, strict)
+		default:
+			return errUnknownField
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func decodeTaskResult(f protoField, strict bool) (*TaskResult, error) {
+	data, err := f.message()
+	if err != nil {
+		return nil, err
+	}
+	result := new(TaskResult)
+	err = decodeFields(data, strict, func(f protoField) (err error) {
+		switch f.num {
+		case 1:
+			result.Type, err = f.string()
+		case 2:
+			err = f.json(&result.Value)
+		default:
+			return errUnknownField
+		}
+		return err
+	})
+	return result, err
+}
+
+func decodeProgress(f protoField, strict bool) (*Progress, error) {
+	data, err := f.message()
+	if err != nil {
+		return nil, err
+	}
+	progress := new(Progress)
+	err = decodeFields(data, strict, func(f protoField) (err error) {
+		switch f.num {
+		case 1:
+			progress.Percent, err = f.double()
+		case 2:
+			progress.Message, err = f.string()
+		case 3:
+			progress.UpdatedAt, err = f.time(strict)
+		default:
+			return errUnknownField
+		}
+		return err
+	})
+	return progress, err
+}
+
+func decodeDiagnostics(f protoField, strict bool) (*Diagnostics, error) {
+	data, err := f.message()
+	if err != nil {
+		return nil, err
+	}
+	diagnostics := new(Diagnostics)
+	err = decodeFields(data, strict, func(f protoField) (err error) {
+		switch f.num {
+		case 1:
+			diagnostics.Signature, err = decodeNestedSignature(f, strict)
+		case 2:
+			diagnostics.WorkerID, err = f.string()
+		case 3:
+			diagnostics.Hostname, err = f.string()
+		case 4:
+			diagnostics.GoVersion, err = f.string()
+		case 5:
+			diagnostics.CapturedAt, err = f.time(strict)
+		case 6:
+			var line string
+			line, err = f.string()
+			diagnostics.Logs = append(diagnostics.Logs, line)
+		default:
+			return errUnknownField
+		}
+		return err
+	})
+	return diagnostics, err
+}
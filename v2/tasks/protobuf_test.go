@@ -0,0 +1,222 @@
+package tasks_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func newFullSignature() *tasks.Signature {
+	eta := time.Date(2026, 1, 2, 15, 4, 5, 6, time.UTC)
+	enqueuedAt := eta.Add(-time.Minute)
+	callback := &tasks.Signature{Name: "notify", Args: []tasks.Arg{{Type: "string", Value: "done"}}}
+
+	return &tasks.Signature{
+		UUID:           "task_1",
+		Name:           "add",
+		RoutingKey:     "queue",
+		ETA:            &eta,
+		GroupUUID:      "group_1",
+		GroupTaskCount: 2,
+		Args: []tasks.Arg{
+			{Name: "a", Type: "int64", Value: 1},
+			{Type: "[]string", Value: []string{"x", "y"}},
+			{Type: "float64", Value: -1.5},
+			{Type: "map[string]interface{}", Value: map[string]interface{}{"k": true}},
+		},
+		Headers:            tasks.Headers{"trace": "abc", "count": 3},
+		Priority:           7,
+		Immutable:          true,
+		RetryCount:         3,
+		RetryTimeout:       10,
+		OnSuccess:          []*tasks.Signature{callback},
+		OnError:            []*tasks.Signature{{Name: "alert"}},
+		ChordCallback:      &tasks.Signature{Name: "sum"},
+		ChainOnError:       []*tasks.Signature{{Name: "rollback"}},
+		ChordErrorCallback: &tasks.Signature{Name: "chord_failed"},
+		GroupFailurePolicy: &tasks.GroupFailurePolicy{Mode: tasks.GroupThreshold, Threshold: 2},
+		ChordContinuation: &tasks.Chord{
+			Group: &tasks.Group{
+				GroupUUID:     "group_2",
+				Tasks:         []*tasks.Signature{{Name: "mul"}, {Name: "mul"}},
+				Timeout:       time.Minute,
+				FailurePolicy: &tasks.GroupFailurePolicy{Mode: tasks.GroupFailFast},
+			},
+			Callback:      &tasks.Signature{Name: "sum"},
+			Continuation:  &tasks.Chord{Group: &tasks.Group{Tasks: []*tasks.Signature{{Name: "div"}}}},
+			ErrorCallback: &tasks.Signature{Name: "cleanup"},
+		},
+		Compensation: &tasks.Signature{Name: "undo"},
+		Singleton: &tasks.Singleton{
+			Key:     "report",
+			Overlap: tasks.OverlapSkip,
+			NextRun: eta.Add(time.Hour),
+		},
+		BrokerMessageGroupId:        "message_group",
+		SQSReceiptHandle:            "receipt",
+		StopTaskDeletionOnError:     true,
+		IgnoreWhenTaskNotRegistered: true,
+		Timeout:                     30 * time.Second,
+		ResultsExpireIn:             60,
+		ResultArgs:                  tasks.ResultArgsReplace,
+		ResultIndexes:               []int{0, 2},
+		IdempotencyKey:              "key",
+		ExpiresAt:                   &eta,
+		EnqueuedAt:                  &enqueuedAt,
+		RetryPolicy:                 &tasks.RetryPolicy{Base: time.Second, Multiplier: 1.5, Max: time.Minute, Jitter: true},
+		RetryAttempt:                2,
+		UniqueKey:                   "customer_42",
+		UniqueByArgs:                true,
+		UniqueReject:                true,
+		Version:                     "2",
+	}
+}
+
+func TestSignatureProtobuf(t *testing.T) {
+	t.Parallel()
+
+	signature := newFullSignature()
+
+	encodedJSON, err := tasks.EncodeSignature(signature, tasks.WireFormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := tasks.EncodeSignature(signature, tasks.WireFormatProtobuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Less(t, len(encoded), len(encodedJSON))
+
+	// Both formats decode to the same signature
+	fromJSON, err := tasks.DecodeSignature(encodedJSON, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := tasks.DecodeSignature(encoded, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, fromJSON, decoded)
+
+	// A signature with nothing set is encoded as an empty message
+	encoded, err = tasks.EncodeSignature(new(tasks.Signature), tasks.WireFormatProtobuf)
+	if assert.NoError(t, err) {
+		assert.Empty(t, encoded)
+	}
+
+	_, err = tasks.EncodeSignature(&tasks.Signature{OnSuccess: []*tasks.Signature{nil}}, tasks.WireFormatProtobuf)
+	assert.Error(t, err)
+	_, err = tasks.EncodeSignature(signature, "xml")
+	assert.Error(t, err)
+}
+
+func TestDecodeSignatureProtobuf(t *testing.T) {
+	t.Parallel()
+
+	encoded, err := tasks.EncodeSignature(&tasks.Signature{UUID: "task_1", Name: "add"}, tasks.WireFormatProtobuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fields of newer senders are only rejected in strict mode
+	unknown := protowire.AppendTag(append([]byte{}, encoded...), 100, protowire.VarintType)
+	unknown = protowire.AppendVarint(unknown, 1)
+	_, err = tasks.DecodeSignature(unknown, true)
+	assert.Error(t, err)
+	signature, err := tasks.DecodeSignature(unknown, false)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "add", signature.Name)
+	}
+
+	// Repeated integers are decoded packed or not
+	unpacked := protowire.AppendTag(append([]byte{}, encoded...), 29, protowire.VarintType)
+	unpacked = protowire.AppendVarint(unpacked, 3)
+	signature, err = tasks.DecodeSignature(unpacked, true)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []int{3}, signature.ResultIndexes)
+	}
+
+	// Strict mode validates the signature
+	encoded, err = tasks.EncodeSignature(&tasks.Signature{UUID: "task 1", Name: "add"}, tasks.WireFormatProtobuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tasks.DecodeSignature(encoded, true)
+	assert.Error(t, err)
+	_, err = tasks.DecodeSignature(encoded, false)
+	assert.NoError(t, err)
+
+	for name, message := range map[string][]byte{
+		"truncated":  encoded[:len(encoded)-1],
+		"wrong type": protowire.AppendVarint(protowire.AppendTag(nil, 2, protowire.VarintType), 1),
+		"empty":      {},
+	} {
+		_, err := tasks.DecodeSignature(message, false)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestTaskStateProtobuf(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	startedAt := createdAt.Add(time.Second)
+	finishedAt := startedAt.Add(time.Minute)
+	taskState := &tasks.TaskState{
+		TaskUUID: "task_1",
+		TaskName: "add",
+		State:    tasks.StateFailure,
+		Results: []*tasks.TaskResult{
+			{Type: "int64", Value: 3},
+			{Type: "[]string", Value: []string{"a"}},
+		},
+		Error:     "boom",
+		CreatedAt: createdAt,
+		TTL:       3600,
+		Progress: &tasks.Progress{
+			Percent:   42.5,
+			Message:   "halfway",
+			UpdatedAt: startedAt,
+		},
+		EnqueuedAt: &createdAt,
+		StartedAt:  &startedAt,
+		FinishedAt: &finishedAt,
+		QueueWait:  time.Second,
+		Duration:   time.Minute,
+		Diagnostics: &tasks.Diagnostics{
+			Signature:  newFullSignature(),
+			WorkerID:   "worker_1",
+			Hostname:   "host",
+			GoVersion:  "go1.18",
+			CapturedAt: finishedAt,
+			Logs:       []string{"one", "two"},
+		},
+	}
+
+	encodedJSON, err := tasks.EncodeTaskState(taskState, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := tasks.EncodeTaskState(taskState, tasks.WireFormatProtobuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Less(t, len(encoded), len(encodedJSON))
+
+	fromJSON, err := tasks.DecodeTaskState(encodedJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := tasks.DecodeTaskState(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, fromJSON, decoded)
+
+	_, err = tasks.DecodeTaskState(encoded[:len(encoded)-1])
+	assert.Error(t, err)
+}
@@ -69,6 +69,10 @@ func (e ErrUnsupportedType) Error() string {
 
 // ReflectValue converts interface{} to reflect.Value based on string type
 func ReflectValue(valueType string, value interface{}) (reflect.Value, error) {
+	if theType, ok := jsonType(valueType); ok {
+		return reflectJSONValue(theType, value)
+	}
+
 	if strings.HasPrefix(valueType, "[]") {
 		return reflectValues(valueType, value)
 	}
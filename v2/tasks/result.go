@@ -1,19 +1,115 @@
 package tasks
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
 	"strings"
 )
 
+// ObjectResultType is the type of results of tasks returning a struct whose
+// type isn't registered with RegisterType, they are stored as JSON objects
+const ObjectResultType = "map[string]interface{}"
+
 // TaskResult represents an actual return value of a processed task
 type TaskResult struct {
 	Type  string      `bson:"type"`
 	Value interface{} `bson:"value"`
 }
 
+// NewTaskResult returns the task result of a value a task returned. Structs,
+// or pointers to them, whose type isn't registered with RegisterType are
+// converted to JSON objects, so any worker and sender can read them.
+func NewTaskResult(value interface{}) (*TaskResult, error) {
+	theType := reflect.TypeOf(value)
+	if theType != nil && theType.Kind() == reflect.Ptr {
+		theType = theType.Elem()
+	}
+	if theType == nil || theType.Kind() != reflect.Struct {
+		return &TaskResult{Type: ArgType(value), Value: value}, nil
+	}
+	if _, registered := jsonTypeName(reflect.TypeOf(value)); registered {
+		return &TaskResult{Type: ArgType(value), Value: value}, nil
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("Encode %s result error: %s", theType, err)
+	}
+	var object map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		return nil, fmt.Errorf("Encode %s result error: %s", theType, err)
+	}
+	return &TaskResult{Type: ObjectResultType, Value: object}, nil
+}
+
+// DecodeResults decodes the results of a task into the value v points to. A
+// single result, e.g. a struct, is decoded into it as a whole, as JSON if it
+// doesn't have its type. Several results set the exported fields of the
+// struct v points to in order.
+func DecodeResults(results []reflect.Value, v interface{}) error {
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return errors.New("Results must be decoded into a non-nil pointer")
+	}
+	target = target.Elem()
+
+	if len(results) == 1 {
+		return decodeResult(results[0], target)
+	}
+
+	if len(results) == 0 {
+		return nil
+	}
+	if target.Kind() != reflect.Struct {
+		return fmt.Errorf("%d results must be decoded into a struct, not %s", len(results), target.Type())
+	}
+	fields := make([]reflect.Value, 0, target.NumField())
+	for i := 0; i < target.NumField(); i++ {
+		if target.Type().Field(i).PkgPath == "" {
+			fields = append(fields, target.Field(i))
+		}
+	}
+	if len(fields) < len(results) {
+		return fmt.Errorf("%d results can't be decoded into %s, it has %d exported fields", len(results), target.Type(), len(fields))
+	}
+	for i, result := range results {
+		if err := decodeResult(result, fields[i]); err != nil {
+			return fmt.Errorf("Decode result %d error: %s", i, err)
+		}
+	}
+	return nil
+}
+
+// decodeResult sets the target to the result, decoding it as JSON unless it
+// is assignable
+func decodeResult(result, target reflect.Value) error {
+	if result.Type().AssignableTo(target.Type()) {
+		target.Set(result)
+		return nil
+	}
+
+	encoded, err := json.Marshal(result.Interface())
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(encoded, target.Addr().Interface()); err != nil {
+		return fmt.Errorf("%v is not %s: %s", result.Interface(), target.Type(), err)
+	}
+	return nil
+}
+
 // ReflectTaskResults ...
 func ReflectTaskResults(taskResults []*TaskResult) ([]reflect.Value, error) {
+	taskResults, err := DecompressResults(taskResults)
+	if err != nil {
+		return nil, err
+	}
+
 	resultValues := make([]reflect.Value, len(taskResults))
 	for i, taskResult := range taskResults {
 		resultValue, err := ReflectValue(taskResult.Type, taskResult.Value)
@@ -1,6 +1,8 @@
 package tasks_test
 
 import (
+	"encoding/json"
+	"reflect"
 	"testing"
 
 	"github.com/RichardKnop/machinery/v2/tasks"
@@ -25,3 +27,76 @@ func TestReflectTaskResults(t *testing.T) {
 		assert.Equal(t, "o", results[0].Index(2).String())
 	}
 }
+
+type dimensions struct {
+	Width  int64  `json:"width"`
+	Height int64  `json:"height"`
+	Format string `json:"format,omitempty"`
+}
+
+func TestStructResults(t *testing.T) {
+	t.Parallel()
+
+	// Tasks returning a struct store it as a JSON object
+	task, err := tasks.New(func() (*dimensions, error) {
+		return &dimensions{Width: 640, Height: 480}, nil
+	}, []tasks.Arg{})
+	assert.NoError(t, err)
+	taskResults, err := task.Call()
+	if assert.NoError(t, err) && assert.Len(t, taskResults, 1) {
+		assert.Equal(t, tasks.ObjectResultType, taskResults[0].Type)
+		assert.Equal(t, map[string]interface{}{"width": json.Number("640"), "height": json.Number("480")}, taskResults[0].Value)
+	}
+
+	// Which is decoded into a struct of the caller
+	results, err := tasks.ReflectTaskResults(taskResults)
+	assert.NoError(t, err)
+	var decoded struct {
+		Width  int
+		Height int
+	}
+	if assert.NoError(t, tasks.DecodeResults(results, &decoded)) {
+		assert.Equal(t, 640, decoded.Width)
+		assert.Equal(t, 480, decoded.Height)
+	}
+
+	// Other results keep their type
+	taskResult, err := tasks.NewTaskResult(int64(2))
+	if assert.NoError(t, err) {
+		assert.Equal(t, &tasks.TaskResult{Type: "int64", Value: int64(2)}, taskResult)
+	}
+}
+
+func TestDecodeResults(t *testing.T) {
+	t.Parallel()
+
+	results := []reflect.Value{reflect.ValueOf(int64(640)), reflect.ValueOf("png")}
+
+	// Several results set the exported fields in order
+	var image struct {
+		Size   int64
+		hidden bool
+		Format string
+	}
+	if assert.NoError(t, tasks.DecodeResults(results, &image)) {
+		assert.Equal(t, int64(640), image.Size)
+		assert.Equal(t, "png", image.Format)
+	}
+
+	// A single result is decoded as a whole
+	var size int
+	if assert.NoError(t, tasks.DecodeResults(results[:1], &size)) {
+		assert.Equal(t, 640, size)
+	}
+
+	assert.NoError(t, tasks.DecodeResults(nil, &size))
+	assert.Error(t, tasks.DecodeResults(results, image))
+	assert.Error(t, tasks.DecodeResults(results, &size))
+	var small struct{ Size int64 }
+	assert.Error(t, tasks.DecodeResults(results, &small))
+	var wrong struct {
+		Size   string
+		Format string
+	}
+	assert.Error(t, tasks.DecodeResults(results, &wrong))
+}
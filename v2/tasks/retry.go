@@ -0,0 +1,77 @@
+package tasks
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRetryBase       = time.Second
+	defaultRetryMultiplier = 2
+)
+
+// RetryPolicy spaces out the retries of a failed task exponentially: retry
+// n, counting from 0, is delayed by Base * Multiplier^n, capped at Max. With
+// Jitter the delay is picked at random between 0 and that ("full jitter"),
+// so tasks failing together, e.g. because a downstream service is down,
+// don't all retry at the same moment.
+type RetryPolicy struct {
+	// Base is the delay of the first retry, defaults to 1 second
+	Base time.Duration
+	// Multiplier grows the delay of each retry, defaults to 2
+	Multiplier float64
+	// Max caps the delay, it is not capped if zero
+	Max time.Duration
+	// Jitter picks the delay at random up to the exponential delay
+	Jitter bool
+}
+
+var (
+	retryRandMu sync.Mutex
+	retryRand   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Validate checks the policy doesn't have negative delays or shrink them
+func (p *RetryPolicy) Validate() error {
+	if p.Base < 0 || p.Max < 0 {
+		return errors.New("Retry policy delays must not be negative")
+	}
+	if p.Multiplier != 0 && p.Multiplier < 1 {
+		return errors.New("Retry policy multiplier must be at least 1")
+	}
+	return nil
+}
+
+// Delay returns the delay of the retry, counting retries from 0
+func (p *RetryPolicy) Delay(attempt int) time.Duration {
+	base, multiplier := p.Base, p.Multiplier
+	if base <= 0 {
+		base = defaultRetryBase
+	}
+	if multiplier < 1 {
+		multiplier = defaultRetryMultiplier
+	}
+	if attempt < 0 {
+		attempt = 0
+	}
+
+	limit := float64(math.MaxInt64)
+	if p.Max > 0 {
+		limit = float64(p.Max)
+	}
+	delay := time.Duration(math.Min(float64(base)*math.Pow(multiplier, float64(attempt)), limit))
+	if delay < 0 {
+		// Only float64(math.MaxInt64) rounded up overflows
+		delay = math.MaxInt64
+	}
+
+	if p.Jitter && delay > 0 {
+		retryRandMu.Lock()
+		delay = time.Duration(retryRand.Int63n(int64(delay)))
+		retryRandMu.Unlock()
+	}
+	return delay
+}
@@ -0,0 +1,57 @@
+package tasks_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	t.Parallel()
+
+	policy := &tasks.RetryPolicy{Base: 100 * time.Millisecond, Multiplier: 3, Max: 2 * time.Second}
+	for attempt, expected := range []time.Duration{
+		100 * time.Millisecond,
+		300 * time.Millisecond,
+		900 * time.Millisecond,
+		2 * time.Second,
+		2 * time.Second,
+	} {
+		assert.Equal(t, expected, policy.Delay(attempt), "attempt %d", attempt)
+	}
+
+	// Defaults to doubling from 1 second, without a cap
+	policy = new(tasks.RetryPolicy)
+	assert.Equal(t, time.Second, policy.Delay(0))
+	assert.Equal(t, 8*time.Second, policy.Delay(3))
+	assert.Equal(t, time.Duration(math.MaxInt64), policy.Delay(1000))
+
+	// Full jitter spreads delays up to the exponential delay
+	policy = &tasks.RetryPolicy{Base: time.Second, Max: 10 * time.Second, Jitter: true}
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		delay := policy.Delay(5)
+		assert.True(t, delay >= 0 && delay < 10*time.Second, delay)
+		seen[delay] = true
+	}
+	assert.Greater(t, len(seen), 1)
+	assert.NotPanics(t, func() { (&tasks.RetryPolicy{Jitter: true}).Delay(1000) })
+}
+
+func TestRetryPolicyValidate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, new(tasks.RetryPolicy).Validate())
+	assert.NoError(t, (&tasks.RetryPolicy{Base: time.Second, Multiplier: 1}).Validate())
+	assert.Error(t, (&tasks.RetryPolicy{Base: -time.Second}).Validate())
+	assert.Error(t, (&tasks.RetryPolicy{Max: -time.Second}).Validate())
+	assert.Error(t, (&tasks.RetryPolicy{Multiplier: 0.5}).Validate())
+
+	// Strict decoding rejects signatures with invalid policies
+	_, err := tasks.DecodeSignature([]byte(`{"UUID":"task_1","Name":"foo","RetryPolicy":{"Multiplier":0.5}}`), true)
+	assert.Error(t, err)
+}
@@ -1,6 +1,10 @@
 package tasks
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/RichardKnop/machinery/v2/utils"
 	"time"
@@ -42,6 +46,53 @@ func (h Headers) ForeachKey(handler func(key, val string) error) error {
 	return nil
 }
 
+// ResultArgs controls how results of preceding tasks, i.e. the previous
+// task of a chain or the tasks of a chord's group, are passed to a task
+type ResultArgs string
+
+const (
+	// ResultArgsAppend appends the results after the task's own arguments
+	ResultArgsAppend ResultArgs = ""
+	// ResultArgsReplace passes the results instead of the task's own arguments
+	ResultArgsReplace ResultArgs = "replace"
+	// ResultArgsIgnore does not pass the results
+	ResultArgsIgnore ResultArgs = "ignore"
+)
+
+// OverlapPolicy controls what happens to a run of a periodic task which is
+// received while its previous run is still running
+type OverlapPolicy string
+
+const (
+	// OverlapAllow runs it concurrently with the previous run
+	OverlapAllow OverlapPolicy = ""
+	// OverlapSkip drops it
+	OverlapSkip OverlapPolicy = "skip"
+	// OverlapQueue runs it once the previous run is done
+	OverlapQueue OverlapPolicy = "queue"
+	// OverlapLatest runs it once the previous run is done, unless the next
+	// run is due by then, i.e. only the latest waiting run is kept. The
+	// previous run isn't interrupted.
+	OverlapLatest OverlapPolicy = "latest"
+	// OverlapReplace cancels the previous run, like Server.CancelTask, and
+	// runs it once the previous run stopped. Waiting runs are dropped once
+	// the next run is due, like with OverlapLatest.
+	OverlapReplace OverlapPolicy = "replace"
+)
+
+// ErrRunOverlaps is the error of a run of a periodic task which was dropped
+// because the previous run was still running
+var ErrRunOverlaps = errors.New("Previous run of the periodic task is still running")
+
+// Singleton marks a run of a periodic task which holds a lock while it runs,
+// so it doesn't run concurrently with other runs of the task
+type Singleton struct {
+	Key     string
+	Overlap OverlapPolicy
+	// NextRun is when the next run of the task is due
+	NextRun time.Time
+}
+
 // Signature represents a single task invocation
 type Signature struct {
 	UUID           string
@@ -59,16 +110,84 @@ type Signature struct {
 	OnSuccess      []*Signature
 	OnError        []*Signature
 	ChordCallback  *Signature
+	// ChainOnError are sent once any step of the chain the task belongs to
+	// fails, see NewChainWithOnError
+	ChainOnError []*Signature
+	// ChordErrorCallback is sent instead of ChordCallback if any task in the
+	// group failed, once all of them have completed. The UUIDs and errors of
+	// the failed tasks are passed to it as its first two arguments.
+	ChordErrorCallback *Signature
+	// GroupFailurePolicy is the failure policy of the group, see
+	// Group.FailurePolicy
+	GroupFailurePolicy *GroupFailurePolicy
+	// ChordContinuation is a group (or a chord) triggered instead of
+	// ChordCallback once all tasks in the group have completed
+	ChordContinuation *Chord
+	// Compensation is sent once the task fails, without the error passed to
+	// it unlike OnError callbacks, to undo the steps of a saga completed
+	// before the task
+	Compensation *Signature
+	// Singleton, if set, keeps the task from running concurrently with
+	// other runs of the periodic task it was sent by
+	Singleton *Singleton
 	//MessageGroupId for Broker, e.g. SQS
 	BrokerMessageGroupId string
 	//ReceiptHandle of SQS Message
 	SQSReceiptHandle string
+	// ReceiveCount is how many times the broker delivered the task, if it
+	// knows, e.g. more than once after SQS redrove it from a dead-letter queue
+	ReceiveCount int `json:"-"`
 	// StopTaskDeletionOnError used with sqs when we want to send failed messages to dlq,
 	// and don't want machinery to delete from source queue
 	StopTaskDeletionOnError bool
 	// IgnoreWhenTaskNotRegistered auto removes the request when there is no handeler available
 	// When this is true a task with no handler will be ignored and not placed back in the queue
 	IgnoreWhenTaskNotRegistered bool
+	// Timeout limits how long a single run of the task may take, the task's
+	// context gets a deadline of ETA (or the time it is received) + Timeout
+	Timeout time.Duration
+	// ResultsExpireIn overrides the global ResultsExpireIn setting for the
+	// states and results of this task, in seconds
+	ResultsExpireIn int
+	// ResultArgs controls how results of preceding tasks are passed to this
+	// task, they are appended to its arguments by default
+	ResultArgs ResultArgs
+	// ResultIndexes picks which results of preceding tasks are passed to this
+	// task and in which order, e.g. []int{1} passes only the second result
+	// of a task returning two values. All results are passed if it is empty.
+	ResultIndexes []int
+	// IdempotencyKey, if set, makes the server send only the first of the
+	// tasks sharing the key until results expire, the others get the result
+	// of the first one. Workers don't run the task again once a task with
+	// the key succeeded.
+	IdempotencyKey string
+	// ExpiresAt, if set, is the point in time after which the task is not
+	// run anymore, workers receiving it later fail it with ErrTaskExpired
+	ExpiresAt *time.Time
+	// EnqueuedAt is the point in time the task was last sent at, set by the
+	// server
+	EnqueuedAt *time.Time
+	// RetryPolicy, if set, delays the retries of the task exponentially
+	// instead of by the Fibonacci sequence, overriding the configured policy
+	RetryPolicy *RetryPolicy
+	// RetryAttempt counts the retries of the task so far, set by workers
+	RetryAttempt int
+	// UniqueKey, if set, makes the server send only one task of the same
+	// name with the key at a time, until it completed. Sending another one
+	// meanwhile returns the result of the pending or running task, or
+	// ErrTaskNotUnique if UniqueReject is set.
+	UniqueKey string
+	// UniqueByArgs makes the task unique by its arguments, the server sets
+	// UniqueKey to a hash of them unless it is set
+	UniqueByArgs bool
+	// UniqueReject rejects a unique task while another one is pending or
+	// running instead of returning the result of that one
+	UniqueReject bool
+	// Version is the version of the task the signature was published for,
+	// workers run it with the handler registered for that version
+	Version string
+	// StartedAt is the point in time the worker started running the task
+	StartedAt *time.Time `json:"-"`
 }
 
 // NewSignature creates a new task signature
@@ -81,6 +200,101 @@ func NewSignature(name string, args []Arg) (*Signature, error) {
 	}, nil
 }
 
+// NewImmutableSignature creates a new task signature which is called with
+// args only, results of preceding tasks in a workflow are not passed to it
+func NewImmutableSignature(name string, args []Arg) (*Signature, error) {
+	signature, err := NewSignature(name, args)
+	if err != nil {
+		return nil, err
+	}
+	signature.Immutable = true
+	return signature, nil
+}
+
+// AddResultArgs passes results of preceding tasks to the task as its
+// ResultIndexes and ResultArgs mode say, unless the task is immutable. It
+// returns an error if an index is out of range of the results.
+func (s *Signature) AddResultArgs(results []Arg) error {
+	if s.Immutable {
+		return nil
+	}
+
+	if len(s.ResultIndexes) > 0 {
+		picked := make([]Arg, len(s.ResultIndexes))
+		for i, index := range s.ResultIndexes {
+			if index < 0 || index >= len(results) {
+				return fmt.Errorf("Task %s picks result %d of %d results", s.Name, index, len(results))
+			}
+			picked[i] = results[index]
+		}
+		results = picked
+	}
+
+	switch s.ResultArgs {
+	case ResultArgsIgnore:
+	case ResultArgsReplace:
+		s.Args = append([]Arg(nil), results...)
+	default:
+		s.Args = append(s.Args, results...)
+	}
+	return nil
+}
+
+// Deadline returns the point in time by which a run of the task must finish.
+// The budget starts at the ETA of delayed tasks (so a task picked up late has
+// less time left) or now otherwise. The second return value is false if the
+// signature declares no timeout.
+func (s *Signature) Deadline() (time.Time, bool) {
+	if s.Timeout <= 0 {
+		return time.Time{}, false
+	}
+
+	start := time.Now()
+	if s.ETA != nil {
+		start = *s.ETA
+	}
+
+	return start.Add(s.Timeout), true
+}
+
+// QueueWait returns how long the task waited to be started since it was
+// sent, or since its ETA for delayed tasks. It is zero until the task started.
+func (s *Signature) QueueWait() time.Duration {
+	if s.EnqueuedAt == nil || s.StartedAt == nil {
+		return 0
+	}
+
+	due := *s.EnqueuedAt
+	if s.ETA != nil && s.ETA.After(due) {
+		due = *s.ETA
+	}
+	if wait := s.StartedAt.Sub(due); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Expired returns true if the task expired, i.e. it may not run anymore
+func (s *Signature) Expired() bool {
+	return s.ExpiresAt != nil && time.Now().After(*s.ExpiresAt)
+}
+
+// SetUniqueKey sets UniqueKey to a hash of the arguments of a task unique by
+// them, unless it is set
+func (s *Signature) SetUniqueKey() error {
+	if s.UniqueKey != "" || !s.UniqueByArgs {
+		return nil
+	}
+
+	encoded, err := json.Marshal(s.Args)
+	if err != nil {
+		return fmt.Errorf("Hash arguments of task %s error: %s", s.Name, err)
+	}
+	sum := sha256.Sum256(encoded)
+	s.UniqueKey = hex.EncodeToString(sum[:])
+	return nil
+}
+
 func CopySignatures(signatures ...*Signature) []*Signature {
 	var sigs = make([]*Signature, len(signatures))
 	for index, signature := range signatures {
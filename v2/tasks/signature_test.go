@@ -0,0 +1,122 @@
+package tasks_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddResultArgs(t *testing.T) {
+	t.Parallel()
+
+	own := tasks.Arg{Type: "string", Value: "own"}
+	result := tasks.Arg{Type: "int64", Value: int64(1)}
+
+	for mode, expected := range map[tasks.ResultArgs][]tasks.Arg{
+		tasks.ResultArgsAppend:  {own, result},
+		tasks.ResultArgsReplace: {result},
+		tasks.ResultArgsIgnore:  {own},
+	} {
+		signature := &tasks.Signature{Args: []tasks.Arg{own}, ResultArgs: mode}
+		assert.NoError(t, signature.AddResultArgs([]tasks.Arg{result}))
+		assert.Equal(t, expected, signature.Args, "mode %q", mode)
+	}
+}
+
+func TestAddResultArgsIndexes(t *testing.T) {
+	t.Parallel()
+
+	first := tasks.Arg{Type: "string", Value: "first"}
+	second := tasks.Arg{Type: "int64", Value: int64(2)}
+
+	signature := &tasks.Signature{ResultIndexes: []int{1, 0}}
+	assert.NoError(t, signature.AddResultArgs([]tasks.Arg{first, second}))
+	assert.Equal(t, []tasks.Arg{second, first}, signature.Args)
+
+	signature = &tasks.Signature{Name: "foo", ResultIndexes: []int{2}}
+	err := signature.AddResultArgs([]tasks.Arg{first, second})
+	if assert.Error(t, err) {
+		assert.Equal(t, "Task foo picks result 2 of 2 results", err.Error())
+	}
+	assert.Empty(t, signature.Args)
+}
+
+func TestNewImmutableSignature(t *testing.T) {
+	t.Parallel()
+
+	own := tasks.Arg{Type: "string", Value: "own"}
+	signature, err := tasks.NewImmutableSignature("foo", []tasks.Arg{own})
+	if assert.NoError(t, err) {
+		assert.True(t, signature.Immutable)
+		assert.NotEmpty(t, signature.UUID)
+
+		signature.AddResultArgs([]tasks.Arg{{Type: "int64", Value: int64(1)}})
+		assert.Equal(t, []tasks.Arg{own}, signature.Args)
+	}
+}
+
+func TestSignatureQueueWait(t *testing.T) {
+	t.Parallel()
+
+	enqueuedAt := time.Now()
+	startedAt := enqueuedAt.Add(time.Minute)
+	signature := &tasks.Signature{Name: "foo", EnqueuedAt: &enqueuedAt}
+	assert.Zero(t, signature.QueueWait())
+
+	signature.StartedAt = &startedAt
+	assert.Equal(t, time.Minute, signature.QueueWait())
+
+	// Delayed tasks wait from their ETA
+	eta := enqueuedAt.Add(40 * time.Second)
+	signature.ETA = &eta
+	assert.Equal(t, 20*time.Second, signature.QueueWait())
+
+	eta = startedAt.Add(time.Second)
+	assert.Zero(t, signature.QueueWait())
+}
+
+func TestSignatureExpired(t *testing.T) {
+	t.Parallel()
+
+	signature := &tasks.Signature{Name: "foo"}
+	assert.False(t, signature.Expired())
+
+	future := time.Now().Add(time.Minute)
+	signature.ExpiresAt = &future
+	assert.False(t, signature.Expired())
+
+	past := time.Now().Add(-time.Minute)
+	signature.ExpiresAt = &past
+	assert.True(t, signature.Expired())
+}
+
+func TestSignatureSetUniqueKey(t *testing.T) {
+	t.Parallel()
+
+	newSignature := func(value int64) *tasks.Signature {
+		return &tasks.Signature{
+			Name:         "foo",
+			Args:         []tasks.Arg{{Type: "int64", Value: value}},
+			UniqueByArgs: true,
+		}
+	}
+
+	a, b, c := newSignature(1), newSignature(1), newSignature(2)
+	for _, signature := range []*tasks.Signature{a, b, c} {
+		assert.NoError(t, signature.SetUniqueKey())
+	}
+	assert.NotEmpty(t, a.UniqueKey)
+	assert.Equal(t, a.UniqueKey, b.UniqueKey)
+	assert.NotEqual(t, a.UniqueKey, c.UniqueKey)
+
+	keyed := newSignature(1)
+	keyed.UniqueKey = "customer_1"
+	assert.NoError(t, keyed.SetUniqueKey())
+	assert.Equal(t, "customer_1", keyed.UniqueKey)
+
+	notUnique := &tasks.Signature{Name: "foo"}
+	assert.NoError(t, notUnique.SetUniqueKey())
+	assert.Empty(t, notUnique.UniqueKey)
+}
@@ -1,6 +1,10 @@
 package tasks
 
-import "time"
+import (
+	"errors"
+	"sort"
+	"time"
+)
 
 const (
 	// StatePending - initial state of a task
@@ -15,8 +19,36 @@ const (
 	StateSuccess = "SUCCESS"
 	// StateFailure - when processing of the task fails
 	StateFailure = "FAILURE"
+	// StateTimedOut - when the task didn't complete before its group timed
+	// out and the chord was triggered without it
+	StateTimedOut = "TIMED_OUT"
 )
 
+// StateEventTaskName is the name of the events published to listeners when
+// the state of a task changes, see backends/events
+const StateEventTaskName = "machinery_state_event"
+
+// ErrGroupTimedOut is the error of tasks which didn't complete before their
+// group timed out
+var ErrGroupTimedOut = errors.New("Group timed out before the task completed")
+
+// ErrTaskRevoked is the error of tasks which were revoked, see
+// Server.CancelTask
+var ErrTaskRevoked = errors.New("Task was revoked")
+
+// ErrTaskExpired is the error of tasks which were received after they
+// expired, see Signature.ExpiresAt
+var ErrTaskExpired = errors.New("Task expired before it was run")
+
+// ErrTaskTimedOut is the error of runs the worker stopped waiting for once
+// their hard timeout passed, see Worker.SetTaskTimeouts
+var ErrTaskTimedOut = errors.New("Task did not return before its hard timeout")
+
+// ErrTaskNotUnique is returned when a task rejecting duplicates is sent while
+// another task with its unique key is pending or running, see
+// Signature.UniqueKey
+var ErrTaskNotUnique = errors.New("Another task with the unique key is pending or running")
+
 // TaskState represents a state of a task
 type TaskState struct {
 	TaskUUID  string        `bson:"_id"`
@@ -26,6 +58,20 @@ type TaskState struct {
 	Error     string        `bson:"error"`
 	CreatedAt time.Time     `bson:"created_at"`
 	TTL       int64         `bson:"ttl,omitempty"`
+	// Progress is the progress the task reported while it was running
+	Progress *Progress `bson:"progress,omitempty"`
+	// EnqueuedAt, StartedAt and FinishedAt are the points in time the last
+	// run of the task was sent, started and completed at, if known
+	EnqueuedAt *time.Time `bson:"enqueued_at,omitempty"`
+	StartedAt  *time.Time `bson:"started_at,omitempty"`
+	FinishedAt *time.Time `bson:"finished_at,omitempty"`
+	// QueueWait is how long the task waited to be started, see
+	// Signature.QueueWait, and Duration how long it ran
+	QueueWait time.Duration `bson:"queue_wait,omitempty"`
+	Duration  time.Duration `bson:"duration,omitempty"`
+	// Diagnostics is the snapshot of the environment the task failed in,
+	// if the worker captured one
+	Diagnostics *Diagnostics `bson:"diagnostics,omitempty"`
 }
 
 // GroupMeta stores useful metadata about tasks within the same group
@@ -42,60 +88,93 @@ type GroupMeta struct {
 
 // NewPendingTaskState ...
 func NewPendingTaskState(signature *Signature) *TaskState {
-	return &TaskState{
+	taskState := &TaskState{
 		TaskUUID:  signature.UUID,
 		TaskName:  signature.Name,
 		State:     StatePending,
 		CreatedAt: time.Now().UTC(),
 	}
+	return taskState.withTiming(signature, false)
 }
 
 // NewReceivedTaskState ...
 func NewReceivedTaskState(signature *Signature) *TaskState {
-	return &TaskState{
+	taskState := &TaskState{
 		TaskUUID: signature.UUID,
 		State:    StateReceived,
 	}
+	return taskState.withTiming(signature, false)
 }
 
 // NewStartedTaskState ...
 func NewStartedTaskState(signature *Signature) *TaskState {
-	return &TaskState{
+	taskState := &TaskState{
 		TaskUUID: signature.UUID,
 		State:    StateStarted,
 	}
+	return taskState.withTiming(signature, false)
 }
 
 // NewSuccessTaskState ...
 func NewSuccessTaskState(signature *Signature, results []*TaskResult) *TaskState {
-	return &TaskState{
+	taskState := &TaskState{
 		TaskUUID: signature.UUID,
 		State:    StateSuccess,
 		Results:  results,
 	}
+	return taskState.withTiming(signature, true)
 }
 
 // NewFailureTaskState ...
 func NewFailureTaskState(signature *Signature, err string) *TaskState {
-	return &TaskState{
+	taskState := &TaskState{
 		TaskUUID: signature.UUID,
 		State:    StateFailure,
 		Error:    err,
 	}
+	return taskState.withTiming(signature, true)
 }
 
 // NewRetryTaskState ...
 func NewRetryTaskState(signature *Signature) *TaskState {
-	return &TaskState{
+	taskState := &TaskState{
 		TaskUUID: signature.UUID,
 		State:    StateRetry,
 	}
+	return taskState.withTiming(signature, true)
 }
 
-// IsCompleted returns true if state is SUCCESS or FAILURE,
-// i.e. the task has finished processing and either succeeded or failed.
+// NewTimedOutTaskState ...
+func NewTimedOutTaskState(signature *Signature) *TaskState {
+	taskState := &TaskState{
+		TaskUUID: signature.UUID,
+		State:    StateTimedOut,
+		Error:    ErrGroupTimedOut.Error(),
+	}
+	return taskState.withTiming(signature, true)
+}
+
+// withTiming sets the timing fields known from the signature, finished is
+// true if the run of the task completed
+func (taskState *TaskState) withTiming(signature *Signature, finished bool) *TaskState {
+	taskState.EnqueuedAt = signature.EnqueuedAt
+	taskState.StartedAt = signature.StartedAt
+	taskState.QueueWait = signature.QueueWait()
+	if finished {
+		now := time.Now().UTC()
+		taskState.FinishedAt = &now
+		if signature.StartedAt != nil {
+			taskState.Duration = now.Sub(*signature.StartedAt)
+		}
+	}
+	return taskState
+}
+
+// IsCompleted returns true if state is SUCCESS, FAILURE or TIMED_OUT,
+// i.e. the task has finished processing and either succeeded or failed,
+// or its group gave up waiting for it.
 func (taskState *TaskState) IsCompleted() bool {
-	return taskState.IsSuccess() || taskState.IsFailure()
+	return taskState.IsSuccess() || taskState.IsFailure() || taskState.IsTimedOut()
 }
 
 // IsSuccess returns true if state is SUCCESS
@@ -107,3 +186,71 @@ func (taskState *TaskState) IsSuccess() bool {
 func (taskState *TaskState) IsFailure() bool {
 	return taskState.State == StateFailure
 }
+
+// IsTimedOut returns true if state is TIMED_OUT
+func (taskState *TaskState) IsTimedOut() bool {
+	return taskState.State == StateTimedOut
+}
+
+// StateFilter selects task states listed by backends supporting queries.
+// Zero fields match any task state.
+type StateFilter struct {
+	// States lists the matching states, e.g. StateFailure
+	States   []string
+	TaskName string
+	// CreatedAfter and CreatedBefore limit the time the task was sent at
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// MinDuration, if set, only matches tasks which ran at least that long
+	MinDuration time.Duration
+	// Offset and Limit page through the matching task states, which are
+	// sorted newest first. A zero Limit returns all of them.
+	Offset int
+	Limit  int
+}
+
+// Match returns true if the task state passes the filter, ignoring the
+// pagination
+func (filter *StateFilter) Match(taskState *TaskState) bool {
+	if len(filter.States) > 0 {
+		matched := false
+		for _, state := range filter.States {
+			if taskState.State == state {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if filter.TaskName != "" && taskState.TaskName != filter.TaskName {
+		return false
+	}
+	if !filter.CreatedAfter.IsZero() && !taskState.CreatedAt.After(filter.CreatedAfter) {
+		return false
+	}
+	if !filter.CreatedBefore.IsZero() && !taskState.CreatedAt.Before(filter.CreatedBefore) {
+		return false
+	}
+	if filter.MinDuration > 0 && taskState.Duration < filter.MinDuration {
+		return false
+	}
+	return true
+}
+
+// Page sorts matching task states newest first and returns the requested page
+func (filter *StateFilter) Page(taskStates []*TaskState) []*TaskState {
+	sort.SliceStable(taskStates, func(i, j int) bool {
+		return taskStates[i].CreatedAt.After(taskStates[j].CreatedAt)
+	})
+
+	if filter.Offset >= len(taskStates) {
+		return []*TaskState{}
+	}
+	taskStates = taskStates[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(taskStates) {
+		taskStates = taskStates[:filter.Limit]
+	}
+	return taskStates
+}
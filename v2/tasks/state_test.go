@@ -2,6 +2,7 @@ package tasks_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/RichardKnop/machinery/v2/tasks"
 	"github.com/stretchr/testify/assert"
@@ -28,4 +29,84 @@ func TestTaskStateIsCompleted(t *testing.T) {
 
 	taskState.State = tasks.StateFailure
 	assert.True(t, taskState.IsCompleted())
+
+	taskState.State = tasks.StateTimedOut
+	assert.True(t, taskState.IsCompleted())
+	assert.True(t, taskState.IsTimedOut())
+}
+
+func TestStateFilterMatch(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	taskState := &tasks.TaskState{TaskName: "add", State: tasks.StateFailure, CreatedAt: now}
+
+	assert.True(t, new(tasks.StateFilter).Match(taskState))
+	assert.True(t, (&tasks.StateFilter{States: []string{tasks.StateSuccess, tasks.StateFailure}}).Match(taskState))
+	assert.False(t, (&tasks.StateFilter{States: []string{tasks.StateSuccess}}).Match(taskState))
+	assert.True(t, (&tasks.StateFilter{TaskName: "add"}).Match(taskState))
+	assert.False(t, (&tasks.StateFilter{TaskName: "multiply"}).Match(taskState))
+	assert.True(t, (&tasks.StateFilter{CreatedAfter: now.Add(-time.Hour), CreatedBefore: now.Add(time.Hour)}).Match(taskState))
+	assert.False(t, (&tasks.StateFilter{CreatedAfter: now}).Match(taskState))
+	assert.False(t, (&tasks.StateFilter{CreatedBefore: now}).Match(taskState))
+
+	taskState.Duration = time.Second
+	assert.True(t, (&tasks.StateFilter{MinDuration: time.Second}).Match(taskState))
+	assert.False(t, (&tasks.StateFilter{MinDuration: time.Minute}).Match(taskState))
+}
+
+func TestTaskStateTiming(t *testing.T) {
+	t.Parallel()
+
+	enqueuedAt := time.Now().Add(-time.Minute)
+	signature := &tasks.Signature{UUID: "taskUUID", EnqueuedAt: &enqueuedAt}
+
+	pending := tasks.NewPendingTaskState(signature)
+	assert.Equal(t, &enqueuedAt, pending.EnqueuedAt)
+	assert.Nil(t, pending.StartedAt)
+	assert.Nil(t, pending.FinishedAt)
+	assert.Zero(t, pending.QueueWait)
+
+	startedAt := enqueuedAt.Add(10 * time.Second)
+	signature.StartedAt = &startedAt
+	started := tasks.NewStartedTaskState(signature)
+	assert.Equal(t, &startedAt, started.StartedAt)
+	assert.Equal(t, 10*time.Second, started.QueueWait)
+	assert.Zero(t, started.Duration)
+
+	succeeded := tasks.NewSuccessTaskState(signature, nil)
+	assert.Equal(t, 10*time.Second, succeeded.QueueWait)
+	if assert.NotNil(t, succeeded.FinishedAt) {
+		assert.Equal(t, succeeded.FinishedAt.Sub(startedAt), succeeded.Duration)
+	}
+
+	// Tasks failed without running have no duration
+	failed := tasks.NewFailureTaskState(&tasks.Signature{UUID: "taskUUID"}, "boom")
+	assert.NotNil(t, failed.FinishedAt)
+	assert.Zero(t, failed.Duration)
+}
+
+func TestStateFilterPage(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	taskStates := func() []*tasks.TaskState {
+		return []*tasks.TaskState{
+			{TaskUUID: "oldest", CreatedAt: now.Add(-2 * time.Minute)},
+			{TaskUUID: "newest", CreatedAt: now},
+			{TaskUUID: "middle", CreatedAt: now.Add(-time.Minute)},
+		}
+	}
+	uuids := func(taskStates []*tasks.TaskState) []string {
+		result := make([]string, len(taskStates))
+		for i, taskState := range taskStates {
+			result[i] = taskState.TaskUUID
+		}
+		return result
+	}
+
+	assert.Equal(t, []string{"newest", "middle", "oldest"}, uuids(new(tasks.StateFilter).Page(taskStates())))
+	assert.Equal(t, []string{"newest", "middle"}, uuids((&tasks.StateFilter{Limit: 2}).Page(taskStates())))
+	assert.Equal(t, []string{"middle", "oldest"}, uuids((&tasks.StateFilter{Offset: 1, Limit: 5}).Page(taskStates())))
+	assert.Empty(t, (&tasks.StateFilter{Offset: 3}).Page(taskStates()))
 }
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"reflect"
 	"runtime/debug"
+	"time"
 
 	opentracing "github.com/opentracing/opentracing-go"
 	opentracing_ext "github.com/opentracing/opentracing-go/ext"
@@ -24,12 +25,22 @@ type Task struct {
 	UseContext bool
 	Context    context.Context
 	Args       []reflect.Value
+	cancel     context.CancelFunc
 }
 
 type signatureCtxType struct{}
 
 var signatureCtx signatureCtxType
 
+// RetryAttemptFromContext returns how many times the running task was
+// retried so far, 0 on its first run or if the context isn't of a task
+func RetryAttemptFromContext(ctx context.Context) int {
+	if signature := SignatureFromContext(ctx); signature != nil {
+		return signature.RetryAttempt
+	}
+	return 0
+}
+
 // SignatureFromContext gets the signature from the context
 func SignatureFromContext(ctx context.Context) *Signature {
 	if ctx == nil {
@@ -45,8 +56,25 @@ func SignatureFromContext(ctx context.Context) *Signature {
 	return signature
 }
 
+// RemainingBudget returns how much time a task has left before its context
+// deadline, e.g. to configure timeouts of HTTP clients used inside the task.
+// The second return value is false if the context has no deadline.
+func RemainingBudget(ctx context.Context) (time.Duration, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+
+	return time.Until(deadline), true
+}
+
 // NewWithSignature is the same as New but injects the signature
 func NewWithSignature(taskFunc interface{}, signature *Signature) (*Task, error) {
+	taskFunc, params := unwrapTaskFunc(taskFunc)
 	args := signature.Args
 	ctx := context.Background()
 	ctx = context.WithValue(ctx, signatureCtx, signature)
@@ -55,6 +83,11 @@ func NewWithSignature(taskFunc interface{}, signature *Signature) (*Task, error)
 		Context:  ctx,
 	}
 
+	// Limit the task run by the deadline declared in the signature
+	if deadline, ok := signature.Deadline(); ok {
+		task.Context, task.cancel = context.WithDeadline(ctx, deadline)
+	}
+
 	taskFuncType := reflect.TypeOf(taskFunc)
 	if taskFuncType.NumIn() > 0 {
 		arg0Type := taskFuncType.In(0)
@@ -63,16 +96,27 @@ func NewWithSignature(taskFunc interface{}, signature *Signature) (*Task, error)
 		}
 	}
 
-	if err := task.ReflectArgs(args); err != nil {
+	var err error
+	if hasNamedArgs(args) {
+		err = task.ReflectNamedArgs(args, params)
+	} else {
+		err = task.ReflectArgs(args)
+	}
+	if err != nil {
 		return nil, fmt.Errorf("Reflect task args error: %s", err)
 	}
 
+	if err := task.checkArgs(); err != nil {
+		return nil, fmt.Errorf("Task %s args error: %s", signature.Name, err)
+	}
+
 	return task, nil
 }
 
 // New tries to use reflection to convert the function and arguments
 // into a reflect.Value and prepare it for invocation
 func New(taskFunc interface{}, args []Arg) (*Task, error) {
+	taskFunc, _ = unwrapTaskFunc(taskFunc)
 	task := &Task{
 		TaskFunc: reflect.ValueOf(taskFunc),
 		Context:  context.Background(),
@@ -99,10 +143,13 @@ func New(taskFunc interface{}, args []Arg) (*Task, error) {
 // 1. The reflected function invocation panics (e.g. due to a mismatched
 //    argument list).
 // 2. The task func itself returns a non-nil error.
+//
+// The span of the task's context is left open so the caller can record what
+// happens to the task afterwards, e.g. a scheduled retry, and finish it.
 func (t *Task) Call() (taskResults []*TaskResult, err error) {
-	// retrieve the span from the task's context and finish it as soon as this function returns
-	if span := opentracing.SpanFromContext(t.Context); span != nil {
-		defer span.Finish()
+	// release resources of the deadline context once the task returns
+	if t.cancel != nil {
+		defer t.cancel()
 	}
 
 	defer func() {
@@ -170,20 +217,63 @@ func (t *Task) Call() (taskResults []*TaskResult, err error) {
 		return nil, lastResult.Interface().(error)
 	}
 
+	// Results of tasks returning them as task results, e.g. the results
+	// reported by external workers, are passed along as they are
+	if len(results) == 2 {
+		if reported, ok := results[0].Interface().([]*TaskResult); ok {
+			return reported, nil
+		}
+	}
+
 	// Convert reflect values to task results
 	taskResults = make([]*TaskResult, len(results)-1)
 	for i := 0; i < len(results)-1; i++ {
-		val := results[i].Interface()
-		typeStr := reflect.TypeOf(val).String()
-		taskResults[i] = &TaskResult{
-			Type:  typeStr,
-			Value: val,
+		taskResults[i], err = NewTaskResult(results[i].Interface())
+		if err != nil {
+			return nil, err
 		}
 	}
 
 	return taskResults, err
 }
 
+// checkArgs makes sure the arguments fit the parameters of the task func, so
+// a mismatch, e.g. in results passed along a chain, fails with a readable
+// error instead of a panic inside reflect
+func (t *Task) checkArgs() error {
+	funcType := t.TaskFunc.Type()
+
+	params := make([]reflect.Type, 0, funcType.NumIn())
+	for i := 0; i < funcType.NumIn(); i++ {
+		params = append(params, funcType.In(i))
+	}
+	if t.UseContext {
+		params = params[1:]
+	}
+
+	var variadic reflect.Type
+	if funcType.IsVariadic() {
+		variadic = params[len(params)-1].Elem()
+		params = params[:len(params)-1]
+		if len(t.Args) < len(params) {
+			return fmt.Errorf("expected at least %d arguments, got %d", len(params), len(t.Args))
+		}
+	} else if len(t.Args) != len(params) {
+		return fmt.Errorf("expected %d arguments, got %d", len(params), len(t.Args))
+	}
+
+	for i, arg := range t.Args {
+		param := variadic
+		if i < len(params) {
+			param = params[i]
+		}
+		if !arg.Type().AssignableTo(param) {
+			return fmt.Errorf("argument %d is %s, expected %s", i, arg.Type(), param)
+		}
+	}
+	return nil
+}
+
 // ReflectArgs converts []TaskArg to []reflect.Value
 func (t *Task) ReflectArgs(args []Arg) error {
 	argValues := make([]reflect.Value, len(args))
@@ -77,6 +77,37 @@ func TestTaskCallInvalidArgRobustnessError(t *testing.T) {
 	assert.Nil(t, results)
 }
 
+func TestNewWithSignatureChecksArgs(t *testing.T) {
+	t.Parallel()
+
+	f := func(ctx context.Context, x int64, rest ...string) error { return nil }
+	integer := tasks.Arg{Type: "int64", Value: int64(1)}
+	text := tasks.Arg{Type: "string", Value: "foo"}
+
+	for _, tc := range []struct {
+		args     []tasks.Arg
+		expected string
+	}{
+		{[]tasks.Arg{integer}, ""},
+		{[]tasks.Arg{integer, text, text}, ""},
+		{[]tasks.Arg{}, "Task foo args error: expected at least 1 arguments, got 0"},
+		{[]tasks.Arg{text}, "Task foo args error: argument 0 is string, expected int64"},
+		{[]tasks.Arg{integer, integer}, "Task foo args error: argument 1 is int64, expected string"},
+	} {
+		_, err := tasks.NewWithSignature(f, &tasks.Signature{Name: "foo", Args: tc.args})
+		if tc.expected == "" {
+			assert.NoError(t, err)
+		} else if assert.Error(t, err) {
+			assert.Equal(t, tc.expected, err.Error())
+		}
+	}
+
+	_, err := tasks.NewWithSignature(func(x int64) error { return nil }, &tasks.Signature{Name: "foo", Args: []tasks.Arg{integer, integer}})
+	if assert.Error(t, err) {
+		assert.Equal(t, "Task foo args error: expected 1 arguments, got 2", err.Error())
+	}
+}
+
 func TestTaskCallInterfaceValuedResult(t *testing.T) {
 	t.Parallel()
 
@@ -92,6 +123,20 @@ func TestTaskCallInterfaceValuedResult(t *testing.T) {
 	assert.Equal(t, math.Pi, taskResults[0].Value)
 }
 
+func TestTaskCallReportedResults(t *testing.T) {
+	t.Parallel()
+
+	reported := []*tasks.TaskResult{{Type: "int64", Value: 1}, {Type: "string", Value: "a"}}
+	f := func(values ...interface{}) ([]*tasks.TaskResult, error) { return reported, nil }
+
+	task, err := tasks.New(f, []tasks.Arg{{Type: "int64", Value: int64(1)}})
+	assert.NoError(t, err)
+
+	taskResults, err := task.Call()
+	assert.NoError(t, err)
+	assert.Equal(t, reported, taskResults)
+}
+
 func TestTaskCallWithContext(t *testing.T) {
 	t.Parallel()
 
@@ -127,3 +172,61 @@ func TestTaskCallWithSignatureInContext(t *testing.T) {
 	assert.Equal(t, "float64", taskResults[0].Type)
 	assert.Equal(t, math.Pi, taskResults[0].Value)
 }
+
+func TestTaskCallWithDeadline(t *testing.T) {
+	t.Parallel()
+
+	f := func(c context.Context) (interface{}, error) {
+		budget, ok := tasks.RemainingBudget(c)
+		assert.True(t, ok)
+		assert.True(t, budget > 0 && budget <= time.Minute)
+		return math.Pi, nil
+	}
+	signature, err := tasks.NewSignature("foo", []tasks.Arg{})
+	assert.NoError(t, err)
+	signature.Timeout = time.Minute
+
+	task, err := tasks.NewWithSignature(f, signature)
+	assert.NoError(t, err)
+	_, err = task.Call()
+	assert.NoError(t, err)
+
+	// the deadline context is released once the task returns
+	assert.Equal(t, context.Canceled, task.Context.Err())
+}
+
+func TestTaskCallWithDeadlineFromETA(t *testing.T) {
+	t.Parallel()
+
+	f := func(c context.Context) (interface{}, error) {
+		<-c.Done()
+		return nil, c.Err()
+	}
+	signature, err := tasks.NewSignature("foo", []tasks.Arg{})
+	assert.NoError(t, err)
+	eta := time.Now().Add(-time.Minute)
+	signature.ETA = &eta
+	signature.Timeout = time.Minute + 50*time.Millisecond
+
+	task, err := tasks.NewWithSignature(f, signature)
+	assert.NoError(t, err)
+	_, err = task.Call()
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestTaskCallWithoutDeadline(t *testing.T) {
+	t.Parallel()
+
+	f := func(c context.Context) (interface{}, error) {
+		_, ok := tasks.RemainingBudget(c)
+		assert.False(t, ok)
+		return math.Pi, nil
+	}
+	signature, err := tasks.NewSignature("foo", []tasks.Arg{})
+	assert.NoError(t, err)
+
+	task, err := tasks.NewWithSignature(f, signature)
+	assert.NoError(t, err)
+	_, err = task.Call()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,107 @@
+package tasks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var (
+	// jsonTypes are the types of arguments and results which travel JSON
+	// encoded, e.g. structs and maps, see RegisterType
+	jsonTypes = map[string]reflect.Type{
+		"map[string]interface{}": reflect.TypeOf(map[string]interface{}{}),
+		"map[string]string":      reflect.TypeOf(map[string]string{}),
+	}
+	jsonTypeNames = map[reflect.Type]string{
+		reflect.TypeOf(map[string]interface{}{}): "map[string]interface{}",
+		reflect.TypeOf(map[string]string{}):      "map[string]string",
+	}
+	jsonTypesMu sync.RWMutex
+)
+
+// RegisterType makes values of the type of value, e.g. a struct, usable as
+// task arguments and results of the type name. Slices of the type and maps
+// of strings to the type are registered as "[]name" and "map[string]name".
+// Values travel JSON encoded, so only their exported fields are passed.
+// Senders and workers must register the type under the same name.
+func RegisterType(name string, value interface{}) error {
+	if name == "" || value == nil {
+		return errors.New("Type must have a name and a value")
+	}
+	if _, ok := typesMap[name]; ok {
+		return fmt.Errorf("Type %s is a base type", name)
+	}
+
+	theType := reflect.TypeOf(value)
+	types := map[string]reflect.Type{
+		name:                 theType,
+		"[]" + name:          reflect.SliceOf(theType),
+		"map[string]" + name: reflect.MapOf(reflect.TypeOf(""), theType),
+	}
+
+	jsonTypesMu.Lock()
+	defer jsonTypesMu.Unlock()
+	for typeName, t := range types {
+		if registered, ok := jsonTypes[typeName]; ok && registered != t {
+			return fmt.Errorf("Type %s is registered as %s already", typeName, registered)
+		}
+	}
+	for typeName, t := range types {
+		jsonTypes[typeName] = t
+		jsonTypeNames[t] = typeName
+	}
+	return nil
+}
+
+// ArgType returns the type of the value as used for arguments and results,
+// the name it was registered under for types registered with RegisterType
+func ArgType(value interface{}) string {
+	theType := reflect.TypeOf(value)
+
+	jsonTypesMu.RLock()
+	defer jsonTypesMu.RUnlock()
+	if name, ok := jsonTypeNames[theType]; ok {
+		return name
+	}
+	return theType.String()
+}
+
+// jsonTypeName returns the name the type is registered under, if any
+func jsonTypeName(theType reflect.Type) (string, bool) {
+	jsonTypesMu.RLock()
+	defer jsonTypesMu.RUnlock()
+	name, ok := jsonTypeNames[theType]
+	return name, ok
+}
+
+// jsonType returns the type registered under the name, if any
+func jsonType(name string) (reflect.Type, bool) {
+	jsonTypesMu.RLock()
+	defer jsonTypesMu.RUnlock()
+	theType, ok := jsonTypes[name]
+	return theType, ok
+}
+
+// reflectJSONValue converts the value to the type, decoding it from JSON
+// unless it has the type already, e.g. when sent in the same process
+func reflectJSONValue(theType reflect.Type, value interface{}) (reflect.Value, error) {
+	if value == nil {
+		return reflect.Zero(theType), nil
+	}
+	if reflect.TypeOf(value) == theType {
+		return reflect.ValueOf(value), nil
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	theValue := reflect.New(theType)
+	if err := json.Unmarshal(encoded, theValue.Interface()); err != nil {
+		return reflect.Value{}, fmt.Errorf("%v is not %v: %s", value, theType, err)
+	}
+	return theValue.Elem(), nil
+}
@@ -0,0 +1,71 @@
+package tasks_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+	"github.com/stretchr/testify/assert"
+)
+
+type lineItem struct {
+	SKU      string `json:"sku"`
+	Quantity int    `json:"quantity"`
+}
+
+func TestRegisterType(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, tasks.RegisterType("LineItem", lineItem{}))
+	// Registering the same type again is fine, another one isn't
+	assert.NoError(t, tasks.RegisterType("LineItem", lineItem{}))
+	assert.Error(t, tasks.RegisterType("LineItem", struct{}{}))
+	assert.Error(t, tasks.RegisterType("int64", lineItem{}))
+	assert.Error(t, tasks.RegisterType("", lineItem{}))
+
+	assert.Equal(t, "LineItem", tasks.ArgType(lineItem{}))
+	assert.Equal(t, "[]LineItem", tasks.ArgType([]lineItem{}))
+	assert.Equal(t, "map[string]LineItem", tasks.ArgType(map[string]lineItem{}))
+	assert.Equal(t, "map[string]interface{}", tasks.ArgType(map[string]interface{}{}))
+	assert.Equal(t, "int64", tasks.ArgType(int64(1)))
+}
+
+func TestReflectRegisteredTypes(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, tasks.RegisterType("ReflectedLineItem", lineItem{}))
+
+	// Values decoded from a message
+	var decoded interface{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"sku": "A-1", "quantity": 3}`), &decoded))
+	value, err := tasks.ReflectValue("ReflectedLineItem", decoded)
+	if assert.NoError(t, err) {
+		assert.Equal(t, lineItem{SKU: "A-1", Quantity: 3}, value.Interface())
+	}
+
+	assert.NoError(t, json.Unmarshal([]byte(`[{"sku": "A-1"}, {"sku": "B-2", "quantity": 1}]`), &decoded))
+	value, err = tasks.ReflectValue("[]ReflectedLineItem", decoded)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []lineItem{{SKU: "A-1"}, {SKU: "B-2", Quantity: 1}}, value.Interface())
+	}
+
+	assert.NoError(t, json.Unmarshal([]byte(`{"first": {"sku": "A-1"}}`), &decoded))
+	value, err = tasks.ReflectValue("map[string]ReflectedLineItem", decoded)
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]lineItem{"first": {SKU: "A-1"}}, value.Interface())
+	}
+
+	value, err = tasks.ReflectValue("map[string]string", map[string]interface{}{"region": "eu"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]string{"region": "eu"}, value.Interface())
+	}
+
+	// Values sent in the same process
+	value, err = tasks.ReflectValue("ReflectedLineItem", lineItem{SKU: "C-3"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, lineItem{SKU: "C-3"}, value.Interface())
+	}
+
+	_, err = tasks.ReflectValue("ReflectedLineItem", map[string]interface{}{"quantity": "many"})
+	assert.Error(t, err)
+}
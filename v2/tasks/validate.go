@@ -18,6 +18,10 @@ var (
 // it has a proper signature. Functions used as tasks must return at least a
 // single value and the last return type must be error
 func ValidateTask(task interface{}) error {
+	if paramsFunc, ok := task.(*ParamsFunc); ok {
+		return paramsFunc.validate()
+	}
+
 	v := reflect.ValueOf(task)
 	t := v.Type()
 
@@ -1,27 +1,177 @@
 package tasks
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+// GroupTimeoutTaskName is the name of the task sent along with a chord whose
+// group has a timeout, it triggers the chord once the group timed out. It is
+// processed by every worker without being registered.
+const GroupTimeoutTaskName = "machinery_group_timeout"
+
+// MapTaskName is the name of the task sent once the source of a map/reduce
+// succeeded, it fans the mapper out over the list the source returned. It is
+// processed by every worker without being registered.
+const MapTaskName = "machinery_map"
+
+// ForkTaskName is the name of the task a nested workflow sends once a group
+// completed if several tasks follow the group, it passes results of the group
+// to each of them. It is processed by every worker without being registered.
+const ForkTaskName = "machinery_fork"
+
 // Chain creates a chain of tasks to be executed one after another
 type Chain struct {
-	Tasks []*Signature
+	Tasks   []*Signature
+	OnError []*Signature
 }
 
 // Group creates a set of tasks to be executed in parallel
 type Group struct {
 	GroupUUID string
 	Tasks     []*Signature
+	// Timeout, if set, limits how long the chord callback waits for the
+	// tasks of the group. Once it passes, the callback is triggered with
+	// results of the tasks which succeeded so far and the tasks which didn't
+	// complete are marked TIMED_OUT.
+	Timeout time.Duration
+	// FailurePolicy, if set, controls what happens once a task of the group
+	// failed, the chord callback is triggered only if all tasks succeeded
+	// otherwise
+	FailurePolicy *GroupFailurePolicy
+}
+
+// GroupFailureMode is how a group handles tasks which failed, see
+// GroupFailurePolicy
+type GroupFailureMode int
+
+const (
+	// GroupAllOrNothing triggers the chord callback only if all tasks of the
+	// group succeeded
+	GroupAllOrNothing GroupFailureMode = iota
+	// GroupFailFast revokes the tasks of the group which haven't completed
+	// once one of them failed, the chord callback isn't triggered
+	GroupFailFast
+	// GroupContinueAndCollect triggers the chord callback with results of
+	// the tasks which succeeded once all tasks of the group completed
+	GroupContinueAndCollect
+	// GroupThreshold triggers the chord callback with results of the tasks
+	// which succeeded once all tasks of the group completed, if at least
+	// Threshold of them succeeded
+	GroupThreshold
+)
+
+// GroupFailurePolicy controls whether the chord callback of a group is
+// triggered with results of the tasks which succeeded if others failed, and
+// whether the tasks of the group which haven't completed are revoked once
+// one failed. The chord error callback, if any, is sent whenever the chord
+// callback isn't.
+type GroupFailurePolicy struct {
+	Mode GroupFailureMode
+	// Threshold is how many tasks must succeed with GroupThreshold
+	Threshold int
+}
+
+// Validate checks the policy is complete
+func (p *GroupFailurePolicy) Validate() error {
+	if p.Mode < GroupAllOrNothing || p.Mode > GroupThreshold {
+		return fmt.Errorf("Unknown group failure mode %d", p.Mode)
+	}
+	if p.Mode == GroupThreshold && p.Threshold <= 0 {
+		return errors.New("Group failure threshold must be above zero")
+	}
+	return nil
+}
+
+// Triggers returns true if the chord callback is triggered once all total
+// tasks of the group completed, given how many of them succeeded. A nil
+// policy triggers it only if all tasks succeeded.
+func (p *GroupFailurePolicy) Triggers(succeeded, total int) bool {
+	if p == nil {
+		return succeeded == total
+	}
+	switch p.Mode {
+	case GroupContinueAndCollect:
+		return true
+	case GroupThreshold:
+		return succeeded >= p.Threshold
+	default:
+		return succeeded == total
+	}
+}
+
+// AllowsPartialResults returns true if the chord callback may be triggered
+// even though tasks of the group failed
+func (p *GroupFailurePolicy) AllowsPartialResults() bool {
+	return p != nil && (p.Mode == GroupContinueAndCollect || p.Mode == GroupThreshold)
+}
+
+// FailsFast returns true if the tasks of the group which haven't completed
+// are revoked once one failed
+func (p *GroupFailurePolicy) FailsFast() bool {
+	return p != nil && p.Mode == GroupFailFast
 }
 
 // Chord adds an optional callback to the group to be executed
-// after all tasks in the group finished
+// after all tasks in the group finished. Instead of a single callback
+// the chord can continue with another group or chord (see Continuation).
 type Chord struct {
-	Group    *Group
-	Callback *Signature
+	Group        *Group
+	Callback     *Signature
+	Continuation *Chord
+	// ErrorCallback is sent instead of Callback if any task in the group
+	// failed, see NewChordWithErrorCallback
+	ErrorCallback *Signature
+}
+
+// SagaStep is a step of a saga along with the task undoing it
+type SagaStep struct {
+	Task *Signature
+	// Compensation, if set, undoes the step once a step after it fails
+	Compensation *Signature
+}
+
+// Saga creates a chain of steps which is undone if a step fails: the
+// compensations of the steps completed before it run one by one in
+// reverse order
+type Saga struct {
+	Steps []*SagaStep
+}
+
+// MapReduce fans the mapper out over the list returned by the source task,
+// whose length is only known once it ran, and passes results of all mapper
+// tasks to the reducer like a chord does
+type MapReduce struct {
+	Source  *Signature
+	Mapper  *Signature
+	Reducer *Signature
+	// ChunkSize, if above zero, passes the list to each mapper task in
+	// slices of up to ChunkSize items instead of item by item
+	ChunkSize int
+}
+
+// FlowStep is a step of a nested workflow, either a task signature or a flow
+type FlowStep interface {
+	flow() *Flow
+}
+
+// Flow is a workflow of chains and groups nested in one another, e.g. a chain
+// whose step is a group or a group whose members are chains, see NewFlowChain
+// and NewFlowGroup. A flow, like its signatures, is sent only once and can't
+// be nested in more than one flow.
+type Flow struct {
+	// Starts are the tasks which are sent to start the flow
+	Starts []*Signature
+	// Ends are the tasks whose completion completes the flow
+	Ends []*Signature
+	// Groups join parallel tasks of the flow before the step following them,
+	// their tasks are sent at different times so they are stored up front
+	Groups []*Group
+	// Tasks are all tasks of the flow, fork tasks included
+	Tasks []*Signature
 }
 
 // GetUUIDs returns slice of task UUIDS
@@ -55,6 +205,221 @@ func NewChain(signatures ...*Signature) (*Chain, error) {
 	return chain, nil
 }
 
+// NewChainWithOnError creates a new chain which sends the error handlers
+// once any of its steps fails, besides the step's own OnError callbacks.
+// The name, JSON encoded arguments and error of the failed step are passed
+// to the error handlers as three string arguments before their own.
+func NewChainWithOnError(onError []*Signature, signatures ...*Signature) (*Chain, error) {
+	chain, err := NewChain(signatures...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Add the error handlers to all steps
+	for _, signature := range signatures {
+		signature.ChainOnError = onError
+	}
+
+	chain.OnError = onError
+	return chain, nil
+}
+
+// NewSaga creates a new saga of steps to be processed one by one like a
+// chain. Compensations are immutable and run one after another, so a failed
+// compensation (after its retries) stops the ones left.
+func NewSaga(steps ...*SagaStep) (*Saga, error) {
+	signatures := make([]*Signature, len(steps))
+	for i, step := range steps {
+		if step == nil || step.Task == nil {
+			return nil, errors.New("Saga step must have a task")
+		}
+		signatures[i] = step.Task
+	}
+
+	if _, err := NewChain(signatures...); err != nil {
+		return nil, err
+	}
+
+	// Chain compensations in reverse order, every step starts undoing
+	// the saga with the compensation of the closest step before it
+	var undo *Signature
+	for _, step := range steps {
+		step.Task.Compensation = undo
+
+		if step.Compensation == nil {
+			continue
+		}
+		if step.Compensation.UUID == "" {
+			signatureID := uuid.New().String()
+			step.Compensation.UUID = fmt.Sprintf("task_%v", signatureID)
+		}
+		step.Compensation.Immutable = true
+		if undo != nil {
+			step.Compensation.OnSuccess = []*Signature{undo}
+		}
+		undo = step.Compensation
+	}
+
+	return &Saga{Steps: steps}, nil
+}
+
+// Tasks returns the tasks of the saga's steps
+func (saga *Saga) Tasks() []*Signature {
+	signatures := make([]*Signature, len(saga.Steps))
+	for i, step := range saga.Steps {
+		signatures[i] = step.Task
+	}
+	return signatures
+}
+
+// NewMapReduce creates a new map/reduce. The source must return a single
+// slice. Each mapper task is called with its own arguments followed by an
+// item (or a chunk) of the slice, and the reducer with the results of the
+// mapper tasks in the order of the slice. If the slice is empty, the reducer
+// is called without results.
+func NewMapReduce(source, mapper, reducer *Signature, chunkSize int) (*MapReduce, error) {
+	if source == nil || mapper == nil || reducer == nil {
+		return nil, errors.New("Map/reduce must have a source, a mapper and a reducer")
+	}
+
+	for _, signature := range []*Signature{source, reducer} {
+		if signature.UUID == "" {
+			signatureID := uuid.New().String()
+			signature.UUID = fmt.Sprintf("task_%v", signatureID)
+		}
+	}
+
+	// Once the source succeeded, the map task continues with a chord of
+	// the mapper tasks and the reducer
+	mapTask := &Signature{
+		UUID: fmt.Sprintf("task_%v", uuid.New().String()),
+		Name: MapTaskName,
+		Args: []Arg{{Type: "int64", Value: int64(chunkSize)}},
+		ChordContinuation: &Chord{
+			Group:    &Group{Tasks: []*Signature{mapper}},
+			Callback: reducer,
+		},
+	}
+	source.OnSuccess = []*Signature{mapTask}
+
+	return &MapReduce{
+		Source:    source,
+		Mapper:    mapper,
+		Reducer:   reducer,
+		ChunkSize: chunkSize,
+	}, nil
+}
+
+// NewFlowChain creates a new flow processing the steps one by one like a
+// chain. A step following parallel tasks, i.e. a group or a chain ending with
+// a group, is sent once all of them completed, with their results in order
+// like a chord callback. Results are passed to every task starting the step
+// unless it is immutable.
+func NewFlowChain(steps ...FlowStep) (*Flow, error) {
+	if len(steps) == 0 {
+		return nil, errors.New("Flow chain must have a step")
+	}
+
+	chain, err := flowOf(steps[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, step := range steps[1:] {
+		next, err := flowOf(step)
+		if err != nil {
+			return nil, err
+		}
+		chain = chain.then(next)
+	}
+	return chain, nil
+}
+
+// NewFlowGroup creates a new flow processing the members in parallel like a
+// group. The group completes once every member did, e.g. once the last step
+// of each chain completed.
+func NewFlowGroup(members ...FlowStep) (*Flow, error) {
+	if len(members) == 0 {
+		return nil, errors.New("Flow group must have a member")
+	}
+
+	group := new(Flow)
+	for _, member := range members {
+		flow, err := flowOf(member)
+		if err != nil {
+			return nil, err
+		}
+		group.Starts = append(group.Starts, flow.Starts...)
+		group.Ends = append(group.Ends, flow.Ends...)
+		group.Groups = append(group.Groups, flow.Groups...)
+		group.Tasks = append(group.Tasks, flow.Tasks...)
+	}
+	return group, nil
+}
+
+func flowOf(step FlowStep) (*Flow, error) {
+	var flow *Flow
+	if step != nil {
+		flow = step.flow()
+	}
+	if flow == nil {
+		return nil, errors.New("Flow step must not be nil")
+	}
+	return flow, nil
+}
+
+func (s *Signature) flow() *Flow {
+	if s == nil {
+		return nil
+	}
+	if s.UUID == "" {
+		signatureID := uuid.New().String()
+		s.UUID = fmt.Sprintf("task_%v", signatureID)
+	}
+	return &Flow{
+		Starts: []*Signature{s},
+		Ends:   []*Signature{s},
+		Tasks:  []*Signature{s},
+	}
+}
+
+func (f *Flow) flow() *Flow {
+	return f
+}
+
+// then returns the flow continuing with the next flow once this one
+// completed. A single task passes its results to the next flow like a chain,
+// parallel tasks are joined by a group whose chord callback is the next flow,
+// or a fork task sending it if it starts with several tasks.
+func (f *Flow) then(next *Flow) *Flow {
+	joined := &Flow{
+		Starts: f.Starts,
+		Ends:   next.Ends,
+		Groups: append(append([]*Group{}, f.Groups...), next.Groups...),
+		Tasks:  append(append([]*Signature{}, f.Tasks...), next.Tasks...),
+	}
+
+	if len(f.Ends) == 1 {
+		f.Ends[0].OnSuccess = append(f.Ends[0].OnSuccess, next.Starts...)
+		return joined
+	}
+
+	callback := next.Starts[0]
+	if len(next.Starts) > 1 {
+		callback = &Signature{
+			UUID:              fmt.Sprintf("task_%v", uuid.New().String()),
+			Name:              ForkTaskName,
+			ChordContinuation: &Chord{Group: &Group{Tasks: next.Starts}},
+		}
+		joined.Tasks = append(joined.Tasks, callback)
+	}
+
+	// Neither fails once task UUIDs are set
+	group, _ := NewGroup(f.Ends...)
+	NewChord(group, callback)
+	joined.Groups = append(joined.Groups, group)
+	return joined
+}
+
 // NewGroup creates a new group of tasks to be processed in parallel
 func NewGroup(signatures ...*Signature) (*Group, error) {
 	// Generate a group UUID
@@ -93,3 +458,52 @@ func NewChord(group *Group, callback *Signature) (*Chord, error) {
 
 	return &Chord{Group: group, Callback: callback}, nil
 }
+
+// NewChordWithErrorCallback creates a new chord which sends the error
+// callback instead of the callback if any task in the group failed, once
+// all of them have completed. The UUIDs and errors of the failed tasks are
+// passed to the error callback as two []string arguments before its own.
+func NewChordWithErrorCallback(group *Group, callback, errorCallback *Signature) (*Chord, error) {
+	chord, err := NewChord(group, callback)
+	if err != nil {
+		return nil, err
+	}
+
+	// Add a chord error callback to all tasks
+	for _, signature := range group.Tasks {
+		signature.ChordErrorCallback = errorCallback
+	}
+
+	chord.ErrorCallback = errorCallback
+	return chord, nil
+}
+
+// NewChordWithContinuation creates a new chord which, after all tasks in the
+// group have completed, continues with another workflow instead of a single
+// callback. The continuation is a chord itself: results of the group are
+// passed to every task of its group, and its own callback or continuation is
+// triggered once those tasks complete. A continuation without a callback is
+// a plain group. To continue with a chain, use NewChord with the first
+// signature of the chain as the callback.
+func NewChordWithContinuation(group *Group, continuation *Chord) (*Chord, error) {
+	if continuation == nil || continuation.Group == nil {
+		return nil, errors.New("Chord continuation must have a group")
+	}
+
+	// Add the continuation to all tasks
+	for _, signature := range group.Tasks {
+		signature.ChordContinuation = continuation
+	}
+
+	return &Chord{Group: group, Continuation: continuation}, nil
+}
+
+// LastStage returns the chord at the end of the continuation chain, i.e. the
+// last stage of a multi-stage workflow (or the chord itself)
+func (chord *Chord) LastStage() *Chord {
+	last := chord
+	for last.Continuation != nil {
+		last = last.Continuation
+	}
+	return last
+}
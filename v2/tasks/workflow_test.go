@@ -59,3 +59,208 @@ func TestNewChain(t *testing.T) {
 	assert.Equal(t, "bar", firstTask.OnSuccess[0].Name)
 	assert.Equal(t, "qux", firstTask.OnSuccess[0].OnSuccess[0].Name)
 }
+
+func TestNewChordWithErrorCallback(t *testing.T) {
+	t.Parallel()
+
+	group, _ := tasks.NewGroup(&tasks.Signature{Name: "foo"}, &tasks.Signature{Name: "bar"})
+	callback := &tasks.Signature{Name: "reduce"}
+	errorCallback := &tasks.Signature{Name: "alert"}
+	chord, err := tasks.NewChordWithErrorCallback(group, callback, errorCallback)
+	assert.NoError(t, err)
+
+	assert.Equal(t, callback, chord.Callback)
+	assert.Equal(t, errorCallback, chord.ErrorCallback)
+	for _, signature := range group.Tasks {
+		assert.Equal(t, callback, signature.ChordCallback)
+		assert.Equal(t, errorCallback, signature.ChordErrorCallback)
+	}
+}
+
+func TestNewChainWithOnError(t *testing.T) {
+	t.Parallel()
+
+	onError := []*tasks.Signature{{Name: "cleanup"}}
+	chain, err := tasks.NewChainWithOnError(onError, &tasks.Signature{Name: "foo"}, &tasks.Signature{Name: "bar"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, onError, chain.OnError)
+	for _, signature := range chain.Tasks {
+		assert.Equal(t, onError, signature.ChainOnError)
+	}
+	assert.Equal(t, chain.Tasks[1], chain.Tasks[0].OnSuccess[0])
+}
+
+func TestGroupFailurePolicy(t *testing.T) {
+	t.Parallel()
+
+	var policy *tasks.GroupFailurePolicy
+	assert.True(t, policy.Triggers(3, 3))
+	assert.False(t, policy.Triggers(2, 3))
+	assert.False(t, policy.AllowsPartialResults())
+	assert.False(t, policy.FailsFast())
+
+	policy = &tasks.GroupFailurePolicy{Mode: tasks.GroupFailFast}
+	assert.NoError(t, policy.Validate())
+	assert.False(t, policy.Triggers(2, 3))
+	assert.False(t, policy.AllowsPartialResults())
+	assert.True(t, policy.FailsFast())
+
+	policy = &tasks.GroupFailurePolicy{Mode: tasks.GroupContinueAndCollect}
+	assert.NoError(t, policy.Validate())
+	assert.True(t, policy.Triggers(0, 3))
+	assert.True(t, policy.AllowsPartialResults())
+
+	policy = &tasks.GroupFailurePolicy{Mode: tasks.GroupThreshold, Threshold: 2}
+	assert.NoError(t, policy.Validate())
+	assert.True(t, policy.Triggers(2, 3))
+	assert.False(t, policy.Triggers(1, 3))
+	assert.True(t, policy.AllowsPartialResults())
+
+	assert.Error(t, (&tasks.GroupFailurePolicy{Mode: tasks.GroupThreshold}).Validate())
+	assert.Error(t, (&tasks.GroupFailurePolicy{Mode: tasks.GroupFailureMode(42)}).Validate())
+}
+
+func TestNewChordWithContinuation(t *testing.T) {
+	t.Parallel()
+
+	stage3, _ := tasks.NewGroup(&tasks.Signature{Name: "qux"})
+	last, _ := tasks.NewChord(stage3, &tasks.Signature{Name: "reduce"})
+	stage2, _ := tasks.NewGroup(&tasks.Signature{Name: "bar"}, &tasks.Signature{Name: "baz"})
+	continuation, err := tasks.NewChordWithContinuation(stage2, last)
+	assert.NoError(t, err)
+	stage1, _ := tasks.NewGroup(&tasks.Signature{Name: "foo"})
+	chord, err := tasks.NewChordWithContinuation(stage1, continuation)
+	assert.NoError(t, err)
+
+	assert.Nil(t, chord.Callback)
+	assert.Equal(t, continuation, stage1.Tasks[0].ChordContinuation)
+	assert.Equal(t, last, stage2.Tasks[1].ChordContinuation)
+	assert.Equal(t, last, chord.LastStage())
+	assert.Equal(t, "reduce", chord.LastStage().Callback.Name)
+
+	_, err = tasks.NewChordWithContinuation(stage1, nil)
+	assert.Error(t, err)
+}
+
+func TestNewSaga(t *testing.T) {
+	t.Parallel()
+
+	reserve := &tasks.Signature{Name: "reserve"}
+	release := &tasks.Signature{Name: "release"}
+	charge := &tasks.Signature{Name: "charge"}
+	ship := &tasks.Signature{Name: "ship"}
+	refund := &tasks.Signature{Name: "refund"}
+	saga, err := tasks.NewSaga(
+		&tasks.SagaStep{Task: reserve, Compensation: release},
+		&tasks.SagaStep{Task: charge, Compensation: refund},
+		&tasks.SagaStep{Task: ship},
+	)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []*tasks.Signature{reserve, charge, ship}, saga.Tasks())
+	assert.Equal(t, charge, reserve.OnSuccess[0])
+	assert.Equal(t, ship, charge.OnSuccess[0])
+
+	// Every step undoes the steps before it, latest first
+	assert.Nil(t, reserve.Compensation)
+	assert.Equal(t, release, charge.Compensation)
+	assert.Equal(t, refund, ship.Compensation)
+	assert.Equal(t, release, refund.OnSuccess[0])
+	assert.Empty(t, release.OnSuccess)
+	assert.True(t, refund.Immutable)
+	assert.NotEmpty(t, refund.UUID)
+
+	_, err = tasks.NewSaga(&tasks.SagaStep{Compensation: release})
+	assert.Error(t, err)
+}
+
+func TestNewMapReduce(t *testing.T) {
+	t.Parallel()
+
+	source := &tasks.Signature{Name: "list"}
+	mapper := &tasks.Signature{Name: "map"}
+	reducer := &tasks.Signature{Name: "reduce"}
+	mapReduce, err := tasks.NewMapReduce(source, mapper, reducer, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, mapReduce.ChunkSize)
+	assert.NotEmpty(t, source.UUID)
+	assert.NotEmpty(t, reducer.UUID)
+
+	// The source continues with the map task, which carries the mapper and
+	// the reducer
+	if assert.Len(t, source.OnSuccess, 1) {
+		mapTask := source.OnSuccess[0]
+		assert.Equal(t, tasks.MapTaskName, mapTask.Name)
+		assert.Equal(t, []tasks.Arg{{Type: "int64", Value: int64(10)}}, mapTask.Args)
+		assert.Equal(t, []*tasks.Signature{mapper}, mapTask.ChordContinuation.Group.Tasks)
+		assert.Equal(t, reducer, mapTask.ChordContinuation.Callback)
+	}
+
+	_, err = tasks.NewMapReduce(source, mapper, nil, 0)
+	assert.Error(t, err)
+}
+
+func TestNewFlowChain(t *testing.T) {
+	t.Parallel()
+
+	a, b, c, d := &tasks.Signature{Name: "a"}, &tasks.Signature{Name: "b"}, &tasks.Signature{Name: "c"}, &tasks.Signature{Name: "d"}
+	group, err := tasks.NewFlowGroup(b, c)
+	assert.NoError(t, err)
+	flow, err := tasks.NewFlowChain(a, group, d)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []*tasks.Signature{a}, flow.Starts)
+	assert.Equal(t, []*tasks.Signature{d}, flow.Ends)
+	assert.Len(t, flow.Tasks, 4)
+
+	// The single task fans out to the group, which is joined before d
+	assert.Equal(t, []*tasks.Signature{b, c}, a.OnSuccess)
+	if assert.Len(t, flow.Groups, 1) {
+		assert.Equal(t, []string{b.UUID, c.UUID}, flow.Groups[0].GetUUIDs())
+	}
+	for _, signature := range []*tasks.Signature{b, c} {
+		assert.Equal(t, flow.Groups[0].GroupUUID, signature.GroupUUID)
+		assert.Equal(t, 2, signature.GroupTaskCount)
+		assert.Equal(t, d, signature.ChordCallback)
+	}
+
+	_, err = tasks.NewFlowChain()
+	assert.Error(t, err)
+	var missing *tasks.Signature
+	_, err = tasks.NewFlowChain(a, missing)
+	assert.Error(t, err)
+}
+
+func TestNewFlowGroup(t *testing.T) {
+	t.Parallel()
+
+	a, b, c, d := &tasks.Signature{Name: "a"}, &tasks.Signature{Name: "b"}, &tasks.Signature{Name: "c"}, &tasks.Signature{Name: "d"}
+	chain, err := tasks.NewFlowChain(a, b)
+	assert.NoError(t, err)
+	group, err := tasks.NewFlowGroup(chain, c)
+	assert.NoError(t, err)
+
+	// The group completes once the chain and c did
+	assert.Equal(t, []*tasks.Signature{a, c}, group.Starts)
+	assert.Equal(t, []*tasks.Signature{b, c}, group.Ends)
+	assert.Empty(t, group.Groups)
+
+	// Several tasks following the group are sent by a fork task
+	next, err := tasks.NewFlowGroup(d, &tasks.Signature{Name: "e"})
+	assert.NoError(t, err)
+	flow, err := tasks.NewFlowChain(group, next)
+	assert.NoError(t, err)
+
+	fork := b.ChordCallback
+	if assert.NotNil(t, fork) {
+		assert.Equal(t, tasks.ForkTaskName, fork.Name)
+		assert.Equal(t, next.Starts, fork.ChordContinuation.Group.Tasks)
+		assert.Equal(t, fork, c.ChordCallback)
+	}
+	assert.Len(t, flow.Groups, 1)
+	assert.Len(t, flow.Tasks, 6)
+
+	_, err = tasks.NewFlowGroup()
+	assert.Error(t, err)
+}
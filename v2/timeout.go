@@ -0,0 +1,110 @@
+package machinery
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+// TaskTimeouts limits how long a run of a task may take, so a hung call
+// can't block a worker forever. Once Soft passes the context of the task is
+// cancelled, tasks taking a context should return then. Once Hard passes the
+// worker stops waiting: the run fails with tasks.ErrTaskTimedOut, and is
+// retried if retries remain, while the function keeps running in the
+// background until it returns. Hard should be longer than Soft to give tasks
+// a chance to stop on their own. Zero durations don't limit runs.
+type TaskTimeouts struct {
+	Soft time.Duration
+	Hard time.Duration
+}
+
+// taskTimeouts keeps the timeouts of the tasks of a worker
+type taskTimeouts struct {
+	mu       sync.RWMutex
+	timeouts map[string]*TaskTimeouts
+	fallback *TaskTimeouts
+}
+
+// SetTaskTimeouts limits the runs of the tasks with the names, of every task
+// if no names are given. Nil timeouts remove the limits. Must be called
+// before Launch.
+func (worker *Worker) SetTaskTimeouts(timeouts *TaskTimeouts, names ...string) {
+	if worker.timeouts == nil {
+		worker.timeouts = &taskTimeouts{timeouts: make(map[string]*TaskTimeouts)}
+	}
+
+	var copied *TaskTimeouts
+	if timeouts != nil {
+		copied = new(TaskTimeouts)
+		*copied = *timeouts
+	}
+
+	t := worker.timeouts
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(names) == 0 {
+		t.fallback = copied
+		return
+	}
+	for _, name := range names {
+		t.timeouts[name] = copied
+	}
+}
+
+// of returns the timeouts of the task, nil if its runs aren't limited
+func (t *taskTimeouts) of(name string) *TaskTimeouts {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if timeouts, ok := t.timeouts[name]; ok {
+		return timeouts
+	}
+	return t.fallback
+}
+
+// callWithTimeouts calls the task, cancelling its context once the soft
+// timeout passes and giving up on it once the hard timeout passes
+func (worker *Worker) callWithTimeouts(signature *tasks.Signature, task *tasks.Task, timeouts *TaskTimeouts) ([]*tasks.TaskResult, error) {
+	if timeouts == nil || (timeouts.Soft <= 0 && timeouts.Hard <= 0) {
+		return task.Call()
+	}
+
+	// The context is cancelled as well once the worker gives up, so the
+	// task may still stop
+	var cancel context.CancelFunc
+	if timeouts.Soft > 0 {
+		task.Context, cancel = context.WithTimeout(task.Context, timeouts.Soft)
+	} else {
+		task.Context, cancel = context.WithCancel(task.Context)
+	}
+	defer cancel()
+
+	if timeouts.Hard <= 0 {
+		return task.Call()
+	}
+
+	type outcome struct {
+		results []*tasks.TaskResult
+		err     error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		results, err := task.Call()
+		done <- outcome{results: results, err: err}
+	}()
+
+	timer := time.NewTimer(timeouts.Hard)
+	defer timer.Stop()
+	select {
+	case called := <-done:
+		return called.results, called.err
+	case <-timer.C:
+		worker.server.log().WARNING.Printf("Task %s did not return within its hard timeout of %s, not waiting for it anymore", signature.UUID, timeouts.Hard)
+		return nil, tasks.ErrTaskTimedOut
+	}
+}
@@ -0,0 +1,61 @@
+package machinery_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestTaskTimeouts(t *testing.T) {
+	t.Parallel()
+
+	server, _ := newRecordingServer(t)
+	release := make(chan struct{})
+	defer close(release)
+	err := server.RegisterTasks(map[string]interface{}{
+		"fetch": func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+		"hang": func() error {
+			<-release
+			return nil
+		},
+		"quick": func() error { return nil },
+	})
+	assert.NoError(t, err)
+
+	worker := server.NewWorker("test_worker", 0)
+	worker.SetTaskTimeouts(&machinery.TaskTimeouts{Soft: 20 * time.Millisecond}, "fetch")
+	worker.SetTaskTimeouts(&machinery.TaskTimeouts{Hard: 20 * time.Millisecond}, "hang", "quick")
+
+	// The soft timeout cancels the context of the task
+	assert.NoError(t, worker.Process(&tasks.Signature{UUID: "task_fetch", Name: "fetch"}))
+	taskState, err := server.GetBackend().GetState("task_fetch")
+	if assert.NoError(t, err) {
+		assert.Equal(t, tasks.StateFailure, taskState.State)
+		assert.Equal(t, context.DeadlineExceeded.Error(), taskState.Error)
+	}
+
+	// The hard timeout frees the worker from a task ignoring its context
+	started := time.Now()
+	assert.NoError(t, worker.Process(&tasks.Signature{UUID: "task_hang", Name: "hang"}))
+	assert.Less(t, time.Since(started), time.Second)
+	taskState, err = server.GetBackend().GetState("task_hang")
+	if assert.NoError(t, err) {
+		assert.Equal(t, tasks.StateFailure, taskState.State)
+		assert.Equal(t, tasks.ErrTaskTimedOut.Error(), taskState.Error)
+	}
+
+	// Tasks returning in time aren't affected
+	assert.NoError(t, worker.Process(&tasks.Signature{UUID: "task_quick", Name: "quick"}))
+	taskState, err = server.GetBackend().GetState("task_quick")
+	if assert.NoError(t, err) {
+		assert.Equal(t, tasks.StateSuccess, taskState.State)
+	}
+}
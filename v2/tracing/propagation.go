@@ -0,0 +1,275 @@
+package tracing
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// Trace header names of the supported formats
+const (
+	W3CTraceParentHeader          = "traceparent"
+	DatadogTraceIDHeader          = "x-datadog-trace-id"
+	DatadogParentIDHeader         = "x-datadog-parent-id"
+	DatadogSamplingPriorityHeader = "x-datadog-sampling-priority"
+	DatadogTagsHeader             = "x-datadog-tags"
+	XRayTraceHeader               = "x-amzn-trace-id"
+)
+
+// TraceContext is a vendor neutral trace context, trace and span IDs use the
+// W3C sizes of 16 and 8 bytes
+type TraceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// Propagator reads and writes a trace context in the headers format of a
+// single tracing vendor
+type Propagator interface {
+	Extract(carrier opentracing.TextMapReader) (TraceContext, bool)
+	Inject(traceContext TraceContext, carrier opentracing.TextMapWriter)
+}
+
+// Ready-made propagators
+var (
+	W3C     Propagator = w3cPropagator{}
+	Datadog Propagator = datadogPropagator{}
+	XRay    Propagator = xrayPropagator{}
+)
+
+// Propagation makes StartSpanFromHeadersWithPropagation understand trace
+// headers of other vendors. The Target must match the format of the tracer,
+// e.g. W3C for tracers using traceparent headers. If the headers of a task
+// do not contain a Target trace context, the first of the Sources which
+// finds one is translated to the Target format before extracting.
+type Propagation struct {
+	Target  Propagator
+	Sources []Propagator
+}
+
+// TranslateHeaders adds a trace context in the target format to the headers
+// if they have none yet but one of the sources finds a trace context there
+func TranslateHeaders(headers tasks.Headers, target Propagator, sources ...Propagator) bool {
+	if headers == nil {
+		return false
+	}
+
+	if _, ok := target.Extract(headers); ok {
+		return false
+	}
+
+	for _, source := range sources {
+		if traceContext, ok := source.Extract(headers); ok {
+			target.Inject(traceContext, headers)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Translate applies the propagation to the headers, see TranslateHeaders.
+// A nil propagation or one without a Target leaves them as they are.
+func (p *Propagation) Translate(headers tasks.Headers) bool {
+	if p == nil || p.Target == nil {
+		return false
+	}
+
+	return TranslateHeaders(headers, p.Target, p.Sources...)
+}
+
+// lookup returns the value of a header, ignoring the case of its name
+func lookup(carrier opentracing.TextMapReader, name string) (string, bool) {
+	var (
+		value string
+		found bool
+	)
+	carrier.ForeachKey(func(key, val string) error {
+		if strings.EqualFold(key, name) {
+			value, found = val, true
+		}
+		return nil
+	})
+	return value, found
+}
+
+// w3cPropagator implements https://www.w3.org/TR/trace-context/
+type w3cPropagator struct{}
+
+func (w3cPropagator) Extract(carrier opentracing.TextMapReader) (TraceContext, bool) {
+	var traceContext TraceContext
+
+	value, ok := lookup(carrier, W3CTraceParentHeader)
+	if !ok {
+		return traceContext, false
+	}
+
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || parts[0] == "ff" {
+		return traceContext, false
+	}
+
+	if !decodeHex(traceContext.TraceID[:], parts[1]) || !decodeHex(traceContext.SpanID[:], parts[2]) {
+		return traceContext, false
+	}
+
+	var flags [1]byte
+	if !decodeHex(flags[:], parts[3]) {
+		return traceContext, false
+	}
+	traceContext.Sampled = flags[0]&0x01 == 0x01
+
+	return traceContext, traceContext.valid()
+}
+
+func (w3cPropagator) Inject(traceContext TraceContext, carrier opentracing.TextMapWriter) {
+	flags := "00"
+	if traceContext.Sampled {
+		flags = "01"
+	}
+
+	carrier.Set(W3CTraceParentHeader, fmt.Sprintf(
+		"00-%s-%s-%s",
+		hex.EncodeToString(traceContext.TraceID[:]),
+		hex.EncodeToString(traceContext.SpanID[:]),
+		flags,
+	))
+}
+
+// datadogPropagator implements the x-datadog-* headers. Trace IDs are
+// decimal lower 64 bits, the upper 64 bits travel in the _dd.p.tid tag.
+type datadogPropagator struct{}
+
+func (datadogPropagator) Extract(carrier opentracing.TextMapReader) (TraceContext, bool) {
+	var traceContext TraceContext
+
+	traceID, ok := lookup(carrier, DatadogTraceIDHeader)
+	if !ok {
+		return traceContext, false
+	}
+	parentID, ok := lookup(carrier, DatadogParentIDHeader)
+	if !ok {
+		return traceContext, false
+	}
+
+	low, err := strconv.ParseUint(traceID, 10, 64)
+	if err != nil {
+		return traceContext, false
+	}
+	span, err := strconv.ParseUint(parentID, 10, 64)
+	if err != nil {
+		return traceContext, false
+	}
+
+	binary.BigEndian.PutUint64(traceContext.TraceID[8:], low)
+	binary.BigEndian.PutUint64(traceContext.SpanID[:], span)
+
+	if tags, ok := lookup(carrier, DatadogTagsHeader); ok {
+		for _, tag := range strings.Split(tags, ",") {
+			if strings.HasPrefix(tag, "_dd.p.tid=") {
+				decodeHex(traceContext.TraceID[:8], strings.TrimPrefix(tag, "_dd.p.tid="))
+			}
+		}
+	}
+
+	// Priorities above zero keep the trace, missing priority defers the
+	// decision which is treated as sampled
+	priority, ok := lookup(carrier, DatadogSamplingPriorityHeader)
+	traceContext.Sampled = !ok
+	if ok {
+		if value, err := strconv.Atoi(priority); err == nil {
+			traceContext.Sampled = value > 0
+		}
+	}
+
+	return traceContext, traceContext.valid()
+}
+
+func (datadogPropagator) Inject(traceContext TraceContext, carrier opentracing.TextMapWriter) {
+	carrier.Set(DatadogTraceIDHeader, strconv.FormatUint(binary.BigEndian.Uint64(traceContext.TraceID[8:]), 10))
+	carrier.Set(DatadogParentIDHeader, strconv.FormatUint(binary.BigEndian.Uint64(traceContext.SpanID[:]), 10))
+
+	priority := "0"
+	if traceContext.Sampled {
+		priority = "1"
+	}
+	carrier.Set(DatadogSamplingPriorityHeader, priority)
+
+	if high := binary.BigEndian.Uint64(traceContext.TraceID[:8]); high != 0 {
+		carrier.Set(DatadogTagsHeader, "_dd.p.tid="+hex.EncodeToString(traceContext.TraceID[:8]))
+	}
+}
+
+// xrayPropagator implements the X-Amzn-Trace-Id header. The X-Ray root
+// "1-<8 hex epoch>-<24 hex>" maps to the 32 hex digits of a W3C trace ID.
+type xrayPropagator struct{}
+
+func (xrayPropagator) Extract(carrier opentracing.TextMapReader) (TraceContext, bool) {
+	var traceContext TraceContext
+
+	value, ok := lookup(carrier, XRayTraceHeader)
+	if !ok {
+		return traceContext, false
+	}
+
+	var root, parent bool
+	for _, part := range strings.Split(value, ";") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch kv[0] {
+		case "Root":
+			fields := strings.Split(kv[1], "-")
+			if len(fields) != 3 || fields[0] != "1" || len(fields[1]) != 8 {
+				return traceContext, false
+			}
+			root = decodeHex(traceContext.TraceID[:], fields[1]+fields[2])
+		case "Parent":
+			parent = decodeHex(traceContext.SpanID[:], kv[1])
+		case "Sampled":
+			traceContext.Sampled = kv[1] == "1"
+		}
+	}
+
+	return traceContext, root && parent && traceContext.valid()
+}
+
+func (xrayPropagator) Inject(traceContext TraceContext, carrier opentracing.TextMapWriter) {
+	traceID := hex.EncodeToString(traceContext.TraceID[:])
+
+	sampled := "0"
+	if traceContext.Sampled {
+		sampled = "1"
+	}
+
+	carrier.Set(XRayTraceHeader, fmt.Sprintf(
+		"Root=1-%s-%s;Parent=%s;Sampled=%s",
+		traceID[:8],
+		traceID[8:],
+		hex.EncodeToString(traceContext.SpanID[:]),
+		sampled,
+	))
+}
+
+// valid returns false for all zero trace or span IDs
+func (t TraceContext) valid() bool {
+	return t.TraceID != [16]byte{} && t.SpanID != [8]byte{}
+}
+
+// decodeHex decodes s into dst, s must have exactly two digits per byte
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != hex.EncodedLen(len(dst)) {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
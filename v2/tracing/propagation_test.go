@@ -0,0 +1,77 @@
+package tracing_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+	"github.com/RichardKnop/machinery/v2/tracing"
+)
+
+func TestPropagatorsRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	traceContext := tracing.TraceContext{
+		TraceID: [16]byte{0x5f, 0x47, 0x9a, 0x01, 0xbd, 0x86, 0x2e, 0x3f, 0xe1, 0xbe, 0x46, 0xa9, 0x94, 0x27, 0x27, 0x93},
+		SpanID:  [8]byte{0x53, 0x99, 0x5c, 0x3f, 0x42, 0xcd, 0x8a, 0xd8},
+		Sampled: true,
+	}
+
+	for name, propagator := range map[string]tracing.Propagator{
+		"w3c":     tracing.W3C,
+		"datadog": tracing.Datadog,
+		"xray":    tracing.XRay,
+	} {
+		headers := make(tasks.Headers)
+		propagator.Inject(traceContext, headers)
+
+		extracted, ok := propagator.Extract(headers)
+		if assert.True(t, ok, name) {
+			assert.Equal(t, traceContext, extracted, name)
+		}
+	}
+}
+
+func TestExtractVendorHeaders(t *testing.T) {
+	t.Parallel()
+
+	xray, ok := tracing.XRay.Extract(tasks.Headers{
+		"X-Amzn-Trace-Id": "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1",
+	})
+	if assert.True(t, ok) {
+		headers := make(tasks.Headers)
+		tracing.W3C.Inject(xray, headers)
+		assert.Equal(t, "00-5759e988bd862e3fe1be46a994272793-53995c3f42cd8ad8-01", headers["traceparent"])
+	}
+
+	datadog, ok := tracing.Datadog.Extract(tasks.Headers{
+		"x-datadog-trace-id":          "1234567890",
+		"x-datadog-parent-id":         "987654321",
+		"x-datadog-sampling-priority": "-1",
+	})
+	if assert.True(t, ok) {
+		headers := make(tasks.Headers)
+		tracing.W3C.Inject(datadog, headers)
+		assert.Equal(t, "00-000000000000000000000000499602d2-000000003ade68b1-00", headers["traceparent"])
+	}
+
+	_, ok = tracing.W3C.Extract(tasks.Headers{"traceparent": "00-00000000000000000000000000000000-000000003ade68b1-01"})
+	assert.False(t, ok)
+	_, ok = tracing.XRay.Extract(tasks.Headers{"X-Amzn-Trace-Id": "Root=1-5759e988-bd862e3fe1be46a994272793"})
+	assert.False(t, ok)
+}
+
+func TestTranslateHeaders(t *testing.T) {
+	t.Parallel()
+
+	headers := tasks.Headers{
+		"X-Amzn-Trace-Id": "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=0",
+	}
+	assert.True(t, tracing.TranslateHeaders(headers, tracing.W3C, tracing.Datadog, tracing.XRay))
+	assert.Equal(t, "00-5759e988bd862e3fe1be46a994272793-53995c3f42cd8ad8-00", headers["traceparent"])
+
+	// existing target headers are left alone
+	assert.False(t, tracing.TranslateHeaders(headers, tracing.W3C, tracing.XRay))
+	assert.False(t, tracing.TranslateHeaders(nil, tracing.W3C, tracing.XRay))
+}
@@ -2,6 +2,7 @@ package tracing
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/RichardKnop/machinery/v2/tasks"
 
@@ -12,21 +13,39 @@ import (
 
 // opentracing tags
 var (
-	MachineryTag     = opentracing.Tag{Key: string(opentracing_ext.Component), Value: "machinery"}
-	WorkflowGroupTag = opentracing.Tag{Key: "machinery.workflow", Value: "group"}
-	WorkflowChordTag = opentracing.Tag{Key: "machinery.workflow", Value: "chord"}
-	WorkflowChainTag = opentracing.Tag{Key: "machinery.workflow", Value: "chain"}
+	MachineryTag         = opentracing.Tag{Key: string(opentracing_ext.Component), Value: "machinery"}
+	WorkflowGroupTag     = opentracing.Tag{Key: "machinery.workflow", Value: "group"}
+	WorkflowChordTag     = opentracing.Tag{Key: "machinery.workflow", Value: "chord"}
+	WorkflowChainTag     = opentracing.Tag{Key: "machinery.workflow", Value: "chain"}
+	WorkflowSagaTag      = opentracing.Tag{Key: "machinery.workflow", Value: "saga"}
+	WorkflowMapReduceTag = opentracing.Tag{Key: "machinery.workflow", Value: "map_reduce"}
+	WorkflowFlowTag      = opentracing.Tag{Key: "machinery.workflow", Value: "flow"}
 )
 
 // StartSpanFromHeaders will extract a span from the signature headers
 // and start a new span with the given operation name.
 func StartSpanFromHeaders(headers tasks.Headers, operationName string) opentracing.Span {
+	return StartSpanFromHeadersWithTracer(opentracing.GlobalTracer(), headers, operationName)
+}
+
+// StartSpanFromHeadersWithTracer is the same as StartSpanFromHeaders but
+// uses the tracer instead of the global tracer
+func StartSpanFromHeadersWithTracer(tracer opentracing.Tracer, headers tasks.Headers, operationName string) opentracing.Span {
+	return StartSpanFromHeadersWithPropagation(tracer, nil, headers, operationName)
+}
+
+// StartSpanFromHeadersWithPropagation is the same as
+// StartSpanFromHeadersWithTracer but translates trace headers of other
+// vendors with the propagation first, unless it is nil
+func StartSpanFromHeadersWithPropagation(tracer opentracing.Tracer, propagation *Propagation, headers tasks.Headers, operationName string) opentracing.Span {
+	propagation.Translate(headers)
+
 	// Try to extract the span context from the carrier.
-	spanContext, err := opentracing.GlobalTracer().Extract(opentracing.TextMap, headers)
+	spanContext, err := tracer.Extract(opentracing.TextMap, headers)
 
 	// Create a new span from the span context if found or start a new trace with the function name.
 	// For clarity add the machinery component tag.
-	span := opentracing.StartSpan(
+	span := tracer.StartSpan(
 		operationName,
 		ConsumerOption(spanContext),
 		MachineryTag,
@@ -40,14 +59,15 @@ func StartSpanFromHeaders(headers tasks.Headers, operationName string) opentraci
 	return span
 }
 
-// HeadersWithSpan will inject a span into the signature headers
+// HeadersWithSpan will inject a span into the signature headers, using the
+// tracer which started the span
 func HeadersWithSpan(headers tasks.Headers, span opentracing.Span) tasks.Headers {
 	// check if the headers aren't nil
 	if headers == nil {
 		headers = make(tasks.Headers)
 	}
 
-	if err := opentracing.GlobalTracer().Inject(span.Context(), opentracing.TextMap, headers); err != nil {
+	if err := span.Tracer().Inject(span.Context(), opentracing.TextMap, headers); err != nil {
 		span.LogFields(opentracing_log.Error(err))
 	}
 
@@ -95,6 +115,10 @@ func AnnotateSpanWithSignatureInfo(span opentracing.Span, signature *tasks.Signa
 		span.SetTag("signature.chord.callback.uuid", signature.ChordCallback.UUID)
 		span.SetTag("signature.chord.callback.name", signature.ChordCallback.Name)
 	}
+
+	if signature.ChordContinuation != nil {
+		span.SetTag("signature.chord.continuation.group.uuid", signature.ChordContinuation.Group.GroupUUID)
+	}
 }
 
 // AnnotateSpanWithChainInfo ...
@@ -131,11 +155,65 @@ func AnnotateSpanWithGroupInfo(span opentracing.Span, group *tasks.Group, sendCo
 // AnnotateSpanWithChordInfo ...
 func AnnotateSpanWithChordInfo(span opentracing.Span, chord *tasks.Chord, sendConcurrency int) {
 	// tag the span with chord specific info
-	span.SetTag("chord.callback.uuid", chord.Callback.UUID)
+	if chord.Callback != nil {
+		span.SetTag("chord.callback.uuid", chord.Callback.UUID)
 
-	// inject the tracing span into the callback signature
-	chord.Callback.Headers = HeadersWithSpan(chord.Callback.Headers, span)
+		// inject the tracing span into the callback signature
+		chord.Callback.Headers = HeadersWithSpan(chord.Callback.Headers, span)
+	}
+
+	if chord.Continuation != nil {
+		span.SetTag("chord.continuation.group.uuid", chord.Continuation.Group.GroupUUID)
+		span.SetTag("chord.continuation.tasks.length", len(chord.Continuation.Group.Tasks))
+	}
 
 	// tag the span for the group part of the chord
 	AnnotateSpanWithGroupInfo(span, chord.Group, sendConcurrency)
 }
+
+// AnnotateSpanWithFlowInfo ...
+func AnnotateSpanWithFlowInfo(span opentracing.Span, flow *tasks.Flow) {
+	// tag the span with some info about the flow
+	span.SetTag("flow.tasks.length", len(flow.Tasks))
+	span.SetTag("flow.groups.length", len(flow.Groups))
+
+	groupUUIDs := make([]string, len(flow.Groups))
+	for i, group := range flow.Groups {
+		groupUUIDs[i] = group.GroupUUID
+	}
+	// encode the group uuids to json, if that fails just dump it in
+	if encoded, err := json.Marshal(groupUUIDs); err == nil {
+		span.SetTag("flow.groups", string(encoded))
+	} else {
+		span.SetTag("flow.groups", groupUUIDs)
+	}
+
+	// inject the tracing span into the tasks signature headers
+	for _, signature := range flow.Tasks {
+		signature.Headers = HeadersWithSpan(signature.Headers, span)
+	}
+}
+
+// LogStateTransition records a state transition of the task as an event of
+// its consumer span
+func LogStateTransition(span opentracing.Span, state string, fields ...opentracing_log.Field) {
+	span.LogFields(append([]opentracing_log.Field{
+		opentracing_log.String("event", "state"),
+		opentracing_log.String("task.state", state),
+	}, fields...)...)
+}
+
+// LogRetry records a retry scheduled with the given backoff as an event of
+// the consumer span
+func LogRetry(span opentracing.Span, signature *tasks.Signature, backoff time.Duration) {
+	fields := []opentracing_log.Field{
+		opentracing_log.String("event", "retry"),
+		opentracing_log.String("retry.backoff", backoff.String()),
+		opentracing_log.Int64("retry.backoff_ms", backoff.Milliseconds()),
+		opentracing_log.Int("retry.remaining", signature.RetryCount),
+	}
+	if signature.ETA != nil {
+		fields = append(fields, opentracing_log.String("retry.eta", signature.ETA.Format(time.RFC3339Nano)))
+	}
+	span.LogFields(fields...)
+}
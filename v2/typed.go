@@ -0,0 +1,113 @@
+package machinery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/RichardKnop/machinery/v2/backends/result"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+// TypedTask is a task registered with RegisterTaskFunc. Its arguments and
+// result travel JSON encoded, as a single string argument and result.
+type TypedTask[A, R any] struct {
+	Name   string
+	server *Server
+}
+
+// RegisterTaskFunc registers a task taking its arguments as a single value,
+// typically a struct, and returning a single result, so mismatches between
+// senders and the task are caught at compile time. Send the task with the
+// returned TypedTask.
+func RegisterTaskFunc[A, R any](server *Server, name string, taskFunc func(context.Context, A) (R, error)) (*TypedTask[A, R], error) {
+	handler := func(ctx context.Context, encodedArgs string) (string, error) {
+		var args A
+		if err := json.Unmarshal([]byte(encodedArgs), &args); err != nil {
+			return "", fmt.Errorf("Decode arguments of task %s error: %s", name, err)
+		}
+
+		res, err := taskFunc(ctx, args)
+		if err != nil {
+			return "", err
+		}
+
+		encodedResult, err := json.Marshal(res)
+		if err != nil {
+			return "", fmt.Errorf("Encode result of task %s error: %s", name, err)
+		}
+		return string(encodedResult), nil
+	}
+
+	if err := server.RegisterTask(name, handler); err != nil {
+		return nil, err
+	}
+	return &TypedTask[A, R]{Name: name, server: server}, nil
+}
+
+// Signature returns a signature of the task with the arguments encoded, e.g.
+// to set other fields of the signature or to use it in a workflow
+func (task *TypedTask[A, R]) Signature(args A) (*tasks.Signature, error) {
+	encodedArgs, err := json.Marshal(args)
+	if err != nil {
+		return nil, fmt.Errorf("Encode arguments of task %s error: %s", task.Name, err)
+	}
+
+	return &tasks.Signature{
+		Name: task.Name,
+		Args: []tasks.Arg{{Type: "string", Value: string(encodedArgs)}},
+	}, nil
+}
+
+// SendWithContext sends the task with the arguments
+func (task *TypedTask[A, R]) SendWithContext(ctx context.Context, args A) (*TypedResult[R], error) {
+	signature, err := task.Signature(args)
+	if err != nil {
+		return nil, err
+	}
+
+	asyncResult, err := task.server.SendTaskWithContext(ctx, signature)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedResult[R]{AsyncResult: asyncResult}, nil
+}
+
+// Send sends the task with the arguments
+func (task *TypedTask[A, R]) Send(args A) (*TypedResult[R], error) {
+	return task.SendWithContext(context.Background(), args)
+}
+
+// TypedResult is the result of a task sent with TypedTask
+type TypedResult[R any] struct {
+	*result.AsyncResult
+}
+
+// Get waits for the task to complete and returns its result (synchronous
+// blocking call)
+func (typedResult *TypedResult[R]) Get(sleepDuration time.Duration) (R, error) {
+	return decodeTypedResult[R](typedResult.AsyncResult.Get(sleepDuration))
+}
+
+// GetWithTimeout waits for the task to complete and returns its result with
+// a timeout (synchronous blocking call)
+func (typedResult *TypedResult[R]) GetWithTimeout(timeoutDuration, sleepDuration time.Duration) (R, error) {
+	return decodeTypedResult[R](typedResult.AsyncResult.GetWithTimeout(timeoutDuration, sleepDuration))
+}
+
+func decodeTypedResult[R any](results []reflect.Value, err error) (R, error) {
+	var res R
+	if err != nil {
+		return res, err
+	}
+
+	if len(results) != 1 || results[0].Kind() != reflect.String {
+		return res, fmt.Errorf("Expected a single encoded result, got %d results", len(results))
+	}
+	if err := json.Unmarshal([]byte(results[0].String()), &res); err != nil {
+		return res, fmt.Errorf("Decode result error: %s", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,77 @@
+package machinery_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+type resizeArgs struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+type resizeResult struct {
+	URL   string `json:"url"`
+	Bytes int64  `json:"bytes"`
+}
+
+func TestRegisterTaskFunc(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	resize, err := machinery.RegisterTaskFunc(server, "resize", func(ctx context.Context, args resizeArgs) (resizeResult, error) {
+		if args.Width <= 0 || args.Height <= 0 {
+			return resizeResult{}, errors.New("empty image")
+		}
+		return resizeResult{URL: args.URL + ".small", Bytes: int64(args.Width * args.Height)}, nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, server.IsTaskRegistered("resize"))
+
+	asyncResult, err := resize.Send(resizeArgs{URL: "cat.png", Width: 4, Height: 3})
+	assert.NoError(t, err)
+	failedResult, err := resize.Send(resizeArgs{URL: "cat.png"})
+	assert.NoError(t, err)
+
+	drain(t, server.NewWorker("test_worker", 1), broker)
+
+	res, err := asyncResult.GetWithTimeout(time.Second, time.Millisecond)
+	if assert.NoError(t, err) {
+		assert.Equal(t, resizeResult{URL: "cat.png.small", Bytes: 12}, res)
+	}
+
+	_, err = failedResult.GetWithTimeout(time.Second, time.Millisecond)
+	assert.EqualError(t, err, "empty image")
+}
+
+func TestRegisterTaskFuncMalformedArgs(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	_, err := machinery.RegisterTaskFunc(server, "resize", func(ctx context.Context, args resizeArgs) (resizeResult, error) {
+		return resizeResult{}, nil
+	})
+	assert.NoError(t, err)
+
+	// Sent without the typed task, e.g. by an old sender
+	asyncResult, err := server.SendTask(&tasks.Signature{
+		Name: "resize",
+		Args: []tasks.Arg{{Type: "string", Value: `{"width": "wide"}`}},
+	})
+	assert.NoError(t, err)
+
+	drain(t, server.NewWorker("test_worker", 1), broker)
+
+	_, err = asyncResult.GetWithTimeout(time.Second, time.Millisecond)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Decode arguments of task resize error")
+	}
+}
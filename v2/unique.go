@@ -0,0 +1,80 @@
+package machinery
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/RichardKnop/machinery/v2/backends/result"
+	"github.com/RichardKnop/machinery/v2/tasks"
+
+	backendsiface "github.com/RichardKnop/machinery/v2/backends/iface"
+)
+
+// uniqueKeyPrefix scopes unique keys, which are claimed as idempotency keys,
+// to the name of the task
+const uniqueKeyPrefix = "machinery_unique_"
+
+// uniqueClaimKey returns the idempotency key the unique key of the task is
+// claimed with
+func uniqueClaimKey(signature *tasks.Signature) string {
+	return uniqueKeyPrefix + signature.Name + "_" + signature.UniqueKey
+}
+
+// claimUniqueKey makes the task the only one with its unique key until it
+// completes, unless another task pending or running has the key, in which
+// case it returns the result of that task, or ErrTaskNotUnique if the task
+// rejects duplicates. Tasks sent again, e.g. retried, keep holding their key.
+// Keys of tasks which completed without releasing them, e.g. because their
+// worker crashed, are taken over.
+func (server *Server) claimUniqueKey(signature *tasks.Signature) (*result.AsyncResult, error) {
+	idempotencyBackend, ok := server.baseBackend().(backendsiface.IdempotencyBackend)
+	if !ok {
+		return nil, errors.New("Result backend does not support unique tasks")
+	}
+
+	// Keys are held at most until results expire, like idempotency keys
+	claim := &tasks.Signature{
+		UUID:            signature.UUID,
+		GroupUUID:       signature.GroupUUID,
+		ResultsExpireIn: signature.ResultsExpireIn,
+		IdempotencyKey:  uniqueClaimKey(signature),
+	}
+	owner, err := idempotencyBackend.ClaimIdempotencyKey(claim)
+	if err != nil {
+		return nil, fmt.Errorf("Claim unique key error: %s", err)
+	}
+	if owner != signature.UUID {
+		if ownerState, err := server.GetBackend().GetState(owner); err == nil && ownerState.IsCompleted() {
+			if err := idempotencyBackend.ReleaseIdempotencyKey(claim.IdempotencyKey, owner); err != nil {
+				return nil, fmt.Errorf("Release unique key error: %s", err)
+			}
+			if owner, err = idempotencyBackend.ClaimIdempotencyKey(claim); err != nil {
+				return nil, fmt.Errorf("Claim unique key error: %s", err)
+			}
+		}
+	}
+	if owner == signature.UUID {
+		return nil, nil
+	}
+
+	if signature.UniqueReject {
+		return nil, tasks.ErrTaskNotUnique
+	}
+	server.log().INFO.Printf("Task %s has the unique key of task %s, which is pending or running, not sending it", signature.UUID, owner)
+	duplicate := *signature
+	duplicate.UUID = owner
+	return result.NewAsyncResult(&duplicate, server.backend), nil
+}
+
+// releaseUniqueKey lets another task with the unique key of the task be sent
+// once the task completed, or if it wasn't sent
+func (server *Server) releaseUniqueKey(signature *tasks.Signature) {
+	idempotencyBackend, ok := server.baseBackend().(backendsiface.IdempotencyBackend)
+	if !ok || signature.UniqueKey == "" {
+		return
+	}
+
+	if err := idempotencyBackend.ReleaseIdempotencyKey(uniqueClaimKey(signature), signature.UUID); err != nil {
+		server.log().ERROR.Printf("Failed to release unique key of task %s: %s", signature.UUID, err)
+	}
+}
@@ -0,0 +1,89 @@
+package machinery_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/backends/memory"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestUniqueTasks(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	server.SetBackend(memory.New(new(config.Config)))
+	err := server.RegisterTasks(map[string]interface{}{
+		"rebuild_cache": func(customerID int64) error { return nil },
+		"flaky":         func() error { return errors.New("boom") },
+	})
+	assert.NoError(t, err)
+	worker := server.NewWorker("test_worker", 0)
+
+	newSignature := func(customerID int64) *tasks.Signature {
+		return &tasks.Signature{
+			Name:         "rebuild_cache",
+			Args:         []tasks.Arg{{Type: "int64", Value: customerID}},
+			UniqueByArgs: true,
+		}
+	}
+
+	// Tasks with the same arguments coalesce onto the pending one
+	first, err := server.SendTask(newSignature(42))
+	assert.NoError(t, err)
+	second, err := server.SendTask(newSignature(42))
+	assert.NoError(t, err)
+	assert.Equal(t, first.Signature.UUID, second.Signature.UUID)
+	other, err := server.SendTask(newSignature(7))
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.Signature.UUID, other.Signature.UUID)
+
+	// Or are rejected
+	rejected := newSignature(42)
+	rejected.UniqueReject = true
+	_, err = server.SendTask(rejected)
+	assert.Equal(t, tasks.ErrTaskNotUnique, err)
+
+	// Once the task completed another one may be sent
+	drain(t, worker, broker)
+	third, err := server.SendTask(newSignature(42))
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.Signature.UUID, third.Signature.UUID)
+	drain(t, worker, broker)
+
+	// Retries keep holding the key, failing for good releases it
+	flaky, err := server.SendTask(&tasks.Signature{Name: "flaky", UniqueKey: "flaky", RetryCount: 1})
+	assert.NoError(t, err)
+	assert.NoError(t, worker.Process(broker.next()))
+	duplicate, err := server.SendTask(&tasks.Signature{Name: "flaky", UniqueKey: "flaky"})
+	assert.NoError(t, err)
+	assert.Equal(t, flaky.Signature.UUID, duplicate.Signature.UUID)
+
+	assert.NoError(t, worker.Process(broker.next()))
+	assert.Nil(t, broker.next())
+	next, err := server.SendTask(&tasks.Signature{Name: "flaky", UniqueKey: "flaky"})
+	assert.NoError(t, err)
+	assert.NotEqual(t, flaky.Signature.UUID, next.Signature.UUID)
+}
+
+func TestUniqueKeyTakenOver(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	server.SetBackend(memory.New(new(config.Config)))
+	assert.NoError(t, server.RegisterTask("report", func() error { return nil }))
+
+	first, err := server.SendTask(&tasks.Signature{Name: "report", UniqueKey: "daily"})
+	assert.NoError(t, err)
+	broker.next()
+
+	// The worker running the task crashed after storing its state, before
+	// releasing the key
+	assert.NoError(t, server.GetBackend().SetStateSuccess(first.Signature, nil))
+	second, err := server.SendTask(&tasks.Signature{Name: "report", UniqueKey: "daily"})
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.Signature.UUID, second.Signature.UUID)
+}
@@ -0,0 +1,20 @@
+//go:build !machinery_no_aws
+// +build !machinery_no_aws
+
+package v1compat
+
+import (
+	dynamobackend "github.com/RichardKnop/machinery/v2/backends/dynamodb"
+	backendiface "github.com/RichardKnop/machinery/v2/backends/iface"
+	brokeriface "github.com/RichardKnop/machinery/v2/brokers/iface"
+	sqsbroker "github.com/RichardKnop/machinery/v2/brokers/sqs"
+	"github.com/RichardKnop/machinery/v2/config"
+)
+
+func newSQSBroker(cnf *config.Config) (brokeriface.Broker, error) {
+	return sqsbroker.New(cnf), nil
+}
+
+func newDynamoDBBackend(cnf *config.Config) (backendiface.Backend, error) {
+	return dynamobackend.New(cnf), nil
+}
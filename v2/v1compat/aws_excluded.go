@@ -0,0 +1,24 @@
+//go:build machinery_no_aws
+// +build machinery_no_aws
+
+package v1compat
+
+import (
+	"errors"
+
+	backendiface "github.com/RichardKnop/machinery/v2/backends/iface"
+	brokeriface "github.com/RichardKnop/machinery/v2/brokers/iface"
+	"github.com/RichardKnop/machinery/v2/config"
+)
+
+// errNoAWS is returned for the URLs of AWS services, which are left out of
+// builds with the machinery_no_aws tag
+var errNoAWS = errors.New("AWS services are not available, the build excludes them")
+
+func newSQSBroker(cnf *config.Config) (brokeriface.Broker, error) {
+	return nil, errNoAWS
+}
+
+func newDynamoDBBackend(cnf *config.Config) (backendiface.Backend, error) {
+	return nil, errNoAWS
+}
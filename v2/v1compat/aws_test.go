@@ -0,0 +1,28 @@
+//go:build !machinery_no_aws
+// +build !machinery_no_aws
+
+package v1compat_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	sqsbroker "github.com/RichardKnop/machinery/v2/brokers/sqs"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/v1compat"
+)
+
+func TestBrokerFactorySQS(t *testing.T) {
+	broker, err := v1compat.BrokerFactory(&config.Config{Broker: "https://sqs.us-east-2.amazonaws.com/123456789012"})
+	if assert.NoError(t, err) {
+		assert.IsType(t, new(sqsbroker.Broker), broker)
+	}
+
+	// Local SQS simulators are allowed with the environment variable
+	t.Setenv("DISABLE_STRICT_SQS_CHECK", "yes")
+	broker, err = v1compat.BrokerFactory(&config.Config{Broker: "http://localhost:5672/some-queue"})
+	if assert.NoError(t, err) {
+		assert.IsType(t, new(sqsbroker.Broker), broker)
+	}
+}
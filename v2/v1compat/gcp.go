@@ -0,0 +1,14 @@
+//go:build !machinery_no_gcp
+// +build !machinery_no_gcp
+
+package v1compat
+
+import (
+	gcppubsubbroker "github.com/RichardKnop/machinery/v2/brokers/gcppubsub"
+	brokeriface "github.com/RichardKnop/machinery/v2/brokers/iface"
+	"github.com/RichardKnop/machinery/v2/config"
+)
+
+func newGCPPubSubBroker(cnf *config.Config, projectID, subscriptionName string) (brokeriface.Broker, error) {
+	return gcppubsubbroker.New(cnf, projectID, subscriptionName)
+}
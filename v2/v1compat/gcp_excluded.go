@@ -0,0 +1,19 @@
+//go:build machinery_no_gcp
+// +build machinery_no_gcp
+
+package v1compat
+
+import (
+	"errors"
+
+	brokeriface "github.com/RichardKnop/machinery/v2/brokers/iface"
+	"github.com/RichardKnop/machinery/v2/config"
+)
+
+// errNoGCP is returned for the URLs of GCP services, which are left out of
+// builds with the machinery_no_gcp tag
+var errNoGCP = errors.New("GCP services are not available, the build excludes them")
+
+func newGCPPubSubBroker(cnf *config.Config, projectID, subscriptionName string) (brokeriface.Broker, error) {
+	return nil, errNoGCP
+}
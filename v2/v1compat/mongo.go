@@ -0,0 +1,14 @@
+//go:build !machinery_no_mongodb
+// +build !machinery_no_mongodb
+
+package v1compat
+
+import (
+	backendiface "github.com/RichardKnop/machinery/v2/backends/iface"
+	mongobackend "github.com/RichardKnop/machinery/v2/backends/mongo"
+	"github.com/RichardKnop/machinery/v2/config"
+)
+
+func newMongoBackend(cnf *config.Config) (backendiface.Backend, error) {
+	return mongobackend.New(cnf)
+}
@@ -0,0 +1,19 @@
+//go:build machinery_no_mongodb
+// +build machinery_no_mongodb
+
+package v1compat
+
+import (
+	"errors"
+
+	backendiface "github.com/RichardKnop/machinery/v2/backends/iface"
+	"github.com/RichardKnop/machinery/v2/config"
+)
+
+// errNoMongoDB is returned for MongoDB URLs, the MongoDB result backend is
+// left out of builds with the machinery_no_mongodb tag
+var errNoMongoDB = errors.New("MongoDB is not available, the build excludes it")
+
+func newMongoBackend(cnf *config.Config) (backendiface.Backend, error) {
+	return nil, errNoMongoDB
+}
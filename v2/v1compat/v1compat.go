@@ -0,0 +1,76 @@
+// Package v1compat eases migrating from machinery v1 to v2 one step at a
+// time. It provides the v1 constructors, which pick brokers, result
+// backends and locks from the URLs in the config, on top of v2, so code
+// written against v1 keeps working after changing its imports while it
+// adopts v2 features.
+//
+// Every function of the package is deprecated: each logs a warning naming
+// its v2 replacement the first time it is called, and is flagged by linters
+// recognising "Deprecated:" comments.
+package v1compat
+
+import (
+	"sync"
+
+	"github.com/RichardKnop/machinery/v2"
+	backendsiface "github.com/RichardKnop/machinery/v2/backends/iface"
+	"github.com/RichardKnop/machinery/v2/brokers/eager"
+	brokersiface "github.com/RichardKnop/machinery/v2/brokers/iface"
+	"github.com/RichardKnop/machinery/v2/config"
+	lockiface "github.com/RichardKnop/machinery/v2/locks/iface"
+	"github.com/RichardKnop/machinery/v2/log"
+)
+
+// warned holds the names of the deprecated functions called so far
+var warned sync.Map
+
+// deprecated logs a warning the first time the function is called
+func deprecated(name, replacement string) {
+	if _, loaded := warned.LoadOrStore(name, true); loaded {
+		return
+	}
+	log.WARNING.Printf("v1compat.%s is deprecated, use %s instead", name, replacement)
+}
+
+// NewServer creates a server with the broker, result backend and lock the
+// URLs of the config point to, as v1's NewServer does. The result backend
+// is optional, the server has none if the URL is not supported. In eager
+// mode tasks are processed as soon as they are sent, without launching a
+// worker.
+//
+// Deprecated: create the broker, result backend and lock with the
+// constructors of their packages and pass them to machinery.NewServer.
+func NewServer(cnf *config.Config) (*machinery.Server, error) {
+	deprecated("NewServer", "machinery.NewServer")
+
+	broker, err := brokerFactory(cnf)
+	if err != nil {
+		return nil, err
+	}
+
+	// Backend is optional so we ignore the error
+	backend, _ := backendFactory(cnf)
+
+	lock, err := lockFactory(cnf)
+	if err != nil {
+		return nil, err
+	}
+
+	server := machinery.NewServer(cnf, broker, backend, lock)
+
+	// init for eager-mode
+	if eagerBroker, ok := broker.(eager.Mode); ok {
+		eagerBroker.AssignWorker(server.NewWorker("eager", 0))
+	}
+
+	return server, nil
+}
+
+// NewServerWithBrokerBackendLock creates a server with the broker, result
+// backend and lock
+//
+// Deprecated: use machinery.NewServer, which takes the same arguments.
+func NewServerWithBrokerBackendLock(cnf *config.Config, broker brokersiface.Broker, backend backendsiface.Backend, lock lockiface.Lock) *machinery.Server {
+	deprecated("NewServerWithBrokerBackendLock", "machinery.NewServer")
+	return machinery.NewServer(cnf, broker, backend, lock)
+}
@@ -0,0 +1,137 @@
+package machinery
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+// versionRequeueDelay is how long a task published for a version the worker
+// doesn't have is delayed before it is sent back to the queue, so workers
+// having the version get a chance to consume it
+const versionRequeueDelay = time.Second
+
+// taskVersion keys a version of a task in the registry
+type taskVersion struct {
+	name    string
+	version string
+}
+
+// RegisterTaskVersion registers the handler of a version of the task, e.g.
+// to keep running tasks published for the old version during a rolling
+// deploy which changes its arguments. Workers run tasks published for the
+// version with it, the task registered with RegisterTask runs tasks
+// published without a version.
+func (server *Server) RegisterTaskVersion(name, version string, taskFunc interface{}) error {
+	if version == "" {
+		return server.RegisterTask(name, taskFunc)
+	}
+	if err := tasks.ValidateTask(taskFunc); err != nil {
+		return err
+	}
+
+	server.registerMu.Lock()
+	defer server.registerMu.Unlock()
+	server.taskVersions.Store(taskVersion{name, version}, taskFunc)
+	server.broker.SetRegisteredTaskNames(server.GetRegisteredTaskNames())
+	return nil
+}
+
+// UnregisterTaskVersion unregisters the handler of a version of the task
+func (server *Server) UnregisterTaskVersion(name, version string) {
+	if version == "" {
+		server.UnregisterTask(name)
+		return
+	}
+
+	server.registerMu.Lock()
+	defer server.registerMu.Unlock()
+	server.taskVersions.Delete(taskVersion{name, version})
+	server.broker.SetRegisteredTaskNames(server.GetRegisteredTaskNames())
+}
+
+// RouteTaskVersion makes workers run tasks published for the version with
+// the handler of the target version, e.g. to run tasks of every patch
+// version with the same handler, or tasks published before the task was
+// versioned, with an empty version, with a versioned handler. An empty
+// target routes them to the task registered with RegisterTask.
+func (server *Server) RouteTaskVersion(name, version, target string) {
+	server.versionRoutes.Store(taskVersion{name, version}, target)
+}
+
+// SetTaskVersion sets the version the server publishes tasks with the name
+// for, unless their signature sets one. An empty version publishes them
+// without a version.
+func (server *Server) SetTaskVersion(name, version string) {
+	if version == "" {
+		server.sendVersions.Delete(name)
+		return
+	}
+	server.sendVersions.Store(name, version)
+}
+
+// GetRegisteredTaskVersion returns the handler which runs tasks published
+// for the version, following version routes
+func (server *Server) GetRegisteredTaskVersion(name, version string) (interface{}, error) {
+	if target, ok := server.versionRoutes.Load(taskVersion{name, version}); ok {
+		version = target.(string)
+	}
+	if version == "" {
+		return server.GetRegisteredTask(name)
+	}
+
+	taskFunc, ok := server.taskVersions.Load(taskVersion{name, version})
+	if !ok {
+		return nil, fmt.Errorf("Task not registered error: %s version %s", name, version)
+	}
+	return taskFunc, nil
+}
+
+// isTaskVersioned returns true if a version of the task is registered
+func (server *Server) isTaskVersioned(name string) bool {
+	versioned := false
+	server.taskVersions.Range(func(key, value interface{}) bool {
+		versioned = key.(taskVersion).name == name
+		return !versioned
+	})
+	return versioned
+}
+
+// versionedTaskNames returns the names of the tasks only registered with
+// versions
+func (server *Server) versionedTaskNames() []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	server.taskVersions.Range(func(key, value interface{}) bool {
+		name := key.(taskVersion).name
+		if _, registered := server.registeredTasks.Load(name); !registered && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+		return true
+	})
+	return names
+}
+
+// stampVersion sets the version the server publishes the task for, unless
+// the signature sets one
+func (server *Server) stampVersion(signature *tasks.Signature) {
+	if signature.Version != "" {
+		return
+	}
+	if version, ok := server.sendVersions.Load(signature.Name); ok {
+		signature.Version = version.(string)
+	}
+}
+
+// requeueVersion sends a task published for a version the worker has no
+// handler for back to the queue, so a worker which has it runs it
+func (worker *Worker) requeueVersion(signature *tasks.Signature) error {
+	worker.server.log().INFO.Printf("Version %q of task %s is not registered with this worker, sending task %s back", signature.Version, signature.Name, signature.UUID)
+
+	eta := time.Now().UTC().Add(versionRequeueDelay)
+	signature.ETA = &eta
+	_, err := worker.server.SendTask(signature)
+	return err
+}
@@ -0,0 +1,68 @@
+package machinery_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/RichardKnop/machinery/v2/tasks"
+)
+
+func TestTaskVersions(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	var ran []string
+	handler := func(version string) func() error {
+		return func() error {
+			ran = append(ran, version)
+			return nil
+		}
+	}
+	assert.NoError(t, server.RegisterTask("send_email", handler("unversioned")))
+	assert.NoError(t, server.RegisterTaskVersion("send_email", "1", handler("1")))
+	assert.NoError(t, server.RegisterTaskVersion("send_email", "2", handler("2")))
+	assert.NoError(t, server.RegisterTaskVersion("charge", "1", handler("charge 1")))
+	assert.ElementsMatch(t, []string{"send_email", "charge"}, server.GetRegisteredTaskNames())
+	assert.True(t, server.IsTaskRegistered("charge"))
+	worker := server.NewWorker("test_worker", 0)
+
+	// Tasks are published for the version set on the server, unless their
+	// signature sets one
+	server.SetTaskVersion("send_email", "2")
+	_, err := server.SendTask(&tasks.Signature{Name: "send_email"})
+	assert.NoError(t, err)
+	_, err = server.SendTask(&tasks.Signature{Name: "send_email", Version: "1"})
+	assert.NoError(t, err)
+	server.SetTaskVersion("send_email", "")
+	_, err = server.SendTask(&tasks.Signature{Name: "send_email"})
+	assert.NoError(t, err)
+	drain(t, worker, broker)
+	assert.Equal(t, []string{"2", "1", "unversioned"}, ran)
+
+	// Versions may be routed to the handler of another version
+	ran = nil
+	server.RouteTaskVersion("send_email", "1.1", "1")
+	server.RouteTaskVersion("charge", "", "1")
+	assert.NoError(t, worker.Process(&tasks.Signature{UUID: "task_1", Name: "send_email", Version: "1.1"}))
+	assert.NoError(t, worker.Process(&tasks.Signature{UUID: "task_2", Name: "charge"}))
+	assert.Equal(t, []string{"1", "charge 1"}, ran)
+
+	// Tasks published for a version the worker doesn't have are sent back
+	// for workers which have it
+	ran = nil
+	assert.NoError(t, worker.Process(&tasks.Signature{UUID: "task_3", Name: "send_email", Version: "3"}))
+	assert.Empty(t, ran)
+	if requeued := broker.next(); assert.NotNil(t, requeued) {
+		assert.Equal(t, "task_3", requeued.UUID)
+		assert.Equal(t, "3", requeued.Version)
+		assert.WithinDuration(t, time.Now().Add(time.Second), *requeued.ETA, time.Second)
+	}
+
+	// Unregistering the last version stops consuming the task
+	server.UnregisterTaskVersion("charge", "1")
+	assert.False(t, server.IsTaskRegistered("charge"))
+	assert.NoError(t, worker.Process(&tasks.Signature{UUID: "task_4", Name: "charge"}))
+	assert.Nil(t, broker.next())
+}
@@ -1,23 +1,31 @@
 package machinery
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"math/rand"
 	"net/url"
 	"os"
 	"os/signal"
+	"reflect"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/opentracing/opentracing-go"
-	
+	opentracing_log "github.com/opentracing/opentracing-go/log"
+
 	"github.com/RichardKnop/machinery/v2/backends/amqp"
 	"github.com/RichardKnop/machinery/v2/brokers/errs"
-	"github.com/RichardKnop/machinery/v2/log"
+	"github.com/RichardKnop/machinery/v2/brokers/iface"
 	"github.com/RichardKnop/machinery/v2/retry"
 	"github.com/RichardKnop/machinery/v2/tasks"
 	"github.com/RichardKnop/machinery/v2/tracing"
+
+	backendsiface "github.com/RichardKnop/machinery/v2/backends/iface"
 )
 
 // Worker represents a single worker process
@@ -30,6 +38,39 @@ type Worker struct {
 	preTaskHandler    func(*tasks.Signature)
 	postTaskHandler   func(*tasks.Signature)
 	preConsumeHandler func(*Worker) bool
+	reportInterval    time.Duration
+	reportHandler     func(*RunReport)
+	reporter          *reporter
+	stateHandler      func(*tasks.TaskState)
+	startAborted      chan struct{}
+	preemptor         *preemptor
+	inFlight          *inFlightHeartbeat
+	revocations       *revocationWatch
+	breakers          *breakers
+	timeouts          *taskTimeouts
+	rateLimiters      *rateLimiters
+	// diagnostics is set if the worker stores diagnostics of failed tasks,
+	// see EnableDiagnostics
+	diagnostics         bool
+	diagnosticsLogLines int
+	// noUnixSignals is set for workers managed by a Runner, which handles
+	// signals itself
+	noUnixSignals bool
+	// drain keeps the running tasks, see Shutdown
+	drain    drain
+	presence *presenceHeartbeat
+	control  *controlWatch
+	// workerID is the ID of the worker, see id, and launchedAt when it was
+	// launched
+	workerID   string
+	idOnce     sync.Once
+	launchedAt time.Time
+	// queueConcurrency and queueWeights are the queues the worker consumes
+	// instead of Queue, see SetQueueConcurrency and SetQueueWeights
+	queueConcurrency map[string]int
+	queueWeights     map[string]int
+	// prefetchCount is set by SetPrefetchCount
+	prefetchCount *int
 }
 
 var (
@@ -53,44 +94,109 @@ func (worker *Worker) Launch() error {
 func (worker *Worker) LaunchAsync(errorsChan chan<- error) {
 	cnf := worker.server.GetConfig()
 	broker := worker.server.GetBroker()
+	worker.launchedAt = time.Now().UTC()
 
 	// Log some useful information about worker configuration
-	log.INFO.Printf("Launching a worker with the following settings:")
-	log.INFO.Printf("- Broker: %s", RedactURL(cnf.Broker))
-	if worker.Queue == "" {
-		log.INFO.Printf("- DefaultQueue: %s", cnf.DefaultQueue)
+	worker.server.log().INFO.Printf("Launching a worker with the following settings:")
+	worker.server.log().INFO.Printf("- Broker: %s", RedactURL(cnf.Broker))
+	if queues := worker.consumedQueues(); queues != nil {
+		for _, queue := range queues {
+			worker.server.log().INFO.Printf("- Queue: %s (concurrency %d, weight %d)", queue.Name, queue.Concurrency, queue.Weight)
+		}
+	} else if worker.Queue == "" {
+		worker.server.log().INFO.Printf("- DefaultQueue: %s", cnf.DefaultQueue)
 	} else {
-		log.INFO.Printf("- CustomQueue: %s", worker.Queue)
+		worker.server.log().INFO.Printf("- CustomQueue: %s", worker.Queue)
 	}
-	log.INFO.Printf("- ResultBackend: %s", RedactURL(cnf.ResultBackend))
+	worker.server.log().INFO.Printf("- ResultBackend: %s", RedactURL(cnf.ResultBackend))
 	if cnf.AMQP != nil {
-		log.INFO.Printf("- AMQP: %s", cnf.AMQP.Exchange)
-		log.INFO.Printf("  - Exchange: %s", cnf.AMQP.Exchange)
-		log.INFO.Printf("  - ExchangeType: %s", cnf.AMQP.ExchangeType)
-		log.INFO.Printf("  - BindingKey: %s", cnf.AMQP.BindingKey)
-		log.INFO.Printf("  - PrefetchCount: %d", cnf.AMQP.PrefetchCount)
+		worker.server.log().INFO.Printf("- AMQP: %s", cnf.AMQP.Exchange)
+		worker.server.log().INFO.Printf("  - Exchange: %s", cnf.AMQP.Exchange)
+		worker.server.log().INFO.Printf("  - ExchangeType: %s", cnf.AMQP.ExchangeType)
+		worker.server.log().INFO.Printf("  - BindingKey: %s", cnf.AMQP.BindingKey)
+		worker.server.log().INFO.Printf("  - PrefetchCount: %d", cnf.AMQP.PrefetchCount)
+	}
+	if worker.prefetchCount != nil {
+		worker.server.log().INFO.Printf("- PrefetchCount: %d", *worker.prefetchCount)
+	}
+
+	if worker.reportInterval > 0 {
+		worker.reporter = newReporter(worker.ConsumerTag, worker.reportInterval, worker.reportHandler, worker.server.log())
+		if deadLetterBroker, ok := broker.(iface.DeadLetterBroker); ok {
+			worker.reporter.deadLetterDepth = func() (int, error) {
+				return deadLetterBroker.DeadLetterDepth(worker.Queue)
+			}
+		}
+		if _, ok := worker.server.baseBackend().(backendsiface.InFlightBackend); ok {
+			worker.reporter.fleetInFlight = worker.server.InFlight
+		}
+		go worker.reporter.run()
+	}
+
+	if worker.preemptor != nil {
+		go worker.preemptor.run()
+	}
+
+	if worker.inFlight != nil {
+		worker.inFlight.start()
+	}
+
+	if worker.presence != nil {
+		worker.presence.start()
+	}
+
+	if worker.control != nil {
+		go worker.control.run()
+	}
+
+	if worker.revocations != nil {
+		go worker.revocations.run()
+	}
+
+	startDelay := worker.startDelay()
+	if startDelay > 0 {
+		worker.server.log().INFO.Printf("- StartDelay: %s", startDelay)
+		worker.startAborted = make(chan struct{})
 	}
 
 	var signalWG sync.WaitGroup
 	// Goroutine to start broker consumption and handle retries when broker connection dies
 	go func() {
+		// The worker quitting before it started consuming stops like one
+		// whose broker stopped consuming
+		if startDelay > 0 {
+			select {
+			case <-time.After(startDelay):
+			case <-worker.startAborted:
+				signalWG.Wait()
+				errorsChan <- nil
+				return
+			}
+		}
+
 		for {
-			retry, err := broker.StartConsuming(worker.ConsumerTag, worker.Concurrency, worker)
+			retry, err := worker.startConsuming(broker)
 
 			if retry {
 				if worker.errorHandler != nil {
 					worker.errorHandler(err)
 				} else {
-					log.WARNING.Printf("Broker failed with error: %s", err)
+					worker.server.log().WARNING.Printf("Broker failed with error: %s", err)
 				}
 			} else {
 				signalWG.Wait()
+				if worker.reporter != nil {
+					worker.reporter.stop()
+				}
 				errorsChan <- err // stop the goroutine
 				return
 			}
 		}
 	}()
 	if !cnf.NoUnixSignals {
+		worker.server.watchDebugLoggingSignals()
+	}
+	if !cnf.NoUnixSignals && !worker.noUnixSignals {
 		sig := make(chan os.Signal, 1)
 		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
 		var signalsReceived uint
@@ -98,15 +204,15 @@ func (worker *Worker) LaunchAsync(errorsChan chan<- error) {
 		// Goroutine Handle SIGINT and SIGTERM signals
 		go func() {
 			for s := range sig {
-				log.WARNING.Printf("Signal received: %v", s)
+				worker.server.log().WARNING.Printf("Signal received: %v", s)
 				signalsReceived++
 
 				if signalsReceived < 2 {
 					// After first Ctrl+C start quitting the worker gracefully
-					log.WARNING.Print("Waiting for running tasks to finish before shutting down")
+					worker.server.log().WARNING.Print("Waiting for running tasks to finish before shutting down")
 					signalWG.Add(1)
 					go func() {
-						worker.Quit()
+						worker.stopGracefully()
 						errorsChan <- ErrWorkerQuitGracefully
 						signalWG.Done()
 					}()
@@ -119,6 +225,20 @@ func (worker *Worker) LaunchAsync(errorsChan chan<- error) {
 	}
 }
 
+// startDelay returns how long the worker waits before it starts consuming,
+// with a random jitter so workers launched at once start one after another
+func (worker *Worker) startDelay() time.Duration {
+	cnf := worker.server.GetConfig()
+	delay := time.Duration(cnf.StartDelay) * time.Second
+	if cnf.StartJitter > 0 {
+		// Seeded here, workers launched at the same time must not draw the
+		// same jitter
+		random := rand.New(rand.NewSource(time.Now().UnixNano()))
+		delay += time.Duration(random.Int63n(int64(cnf.StartJitter) * int64(time.Second)))
+	}
+	return delay
+}
+
 // CustomQueue returns Custom Queue of the running worker process
 func (worker *Worker) CustomQueue() string {
 	return worker.Queue
@@ -126,47 +246,209 @@ func (worker *Worker) CustomQueue() string {
 
 // Quit tears down the running worker process
 func (worker *Worker) Quit() {
+	if worker.startAborted != nil {
+		select {
+		case <-worker.startAborted:
+		default:
+			close(worker.startAborted)
+		}
+	}
+
+	if worker.preemptor != nil {
+		worker.preemptor.stop()
+	}
+	if worker.inFlight != nil {
+		worker.inFlight.stop()
+	}
+	if worker.presence != nil {
+		worker.presence.stop()
+	}
+	if worker.revocations != nil {
+		worker.revocations.stop()
+	}
+	if worker.control != nil {
+		worker.control.stop()
+	}
+
 	worker.server.GetBroker().StopConsuming()
+
+	// Report tasks processed since the last report
+	if worker.reporter != nil {
+		worker.reporter.stop()
+	}
 }
 
 // Process handles received tasks and triggers success/error callbacks
 func (worker *Worker) Process(signature *tasks.Signature) error {
+	// Listeners don't run tasks, they only handle state events
+	if worker.stateHandler != nil {
+		return worker.processStateEvent(signature)
+	}
+
+	// Tasks machinery sends itself are processed by every worker
+	taskFunc, internal := worker.internalTask(signature.Name)
+
 	// If the task is not registered with this worker, do not continue
 	// but only return nil as we do not want to restart the worker process
-	if !worker.server.IsTaskRegistered(signature.Name) {
+	if !internal && !worker.server.IsTaskRegistered(signature.Name) {
 		return nil
 	}
 
-	taskFunc, err := worker.server.GetRegisteredTask(signature.Name)
-	if err != nil {
-		return nil
+	var err error
+	if !internal {
+		taskFunc, err = worker.server.GetRegisteredTaskVersion(signature.Name, signature.Version)
+		if err != nil {
+			// Tasks published for a version this worker doesn't have are
+			// left to workers which have it, e.g. during a rolling deploy
+			return worker.requeueVersion(signature)
+		}
+	}
+
+	// Tasks delayed longer than the broker holds them are delayed again
+	if worker.deliveredEarly(signature) {
+		return worker.delayAgain(signature)
+	}
+
+	// Paused workers and workers running as many tasks as their concurrency
+	// limit hold tasks back, e.g. tasks brokers pushed before the pause
+	if !internal {
+		if !worker.control.acquire() {
+			return worker.requeuePaused(signature)
+		}
+		defer worker.control.release()
+
+		worker.inFlight.begin(signature.Name)
+		defer worker.inFlight.end(signature.Name)
+	}
+
+	// Runs of a singleton periodic task don't overlap
+	if signature.Singleton != nil {
+		lease, proceed, err := worker.lockSingleton(signature)
+		if !proceed {
+			return err
+		}
+		if lease != nil {
+			defer lease.Unlock()
+		}
+	}
+
+	// Anything but success or retry counts as a failure in run reports
+	result := outcomeFailed
+	if worker.reporter != nil {
+		worker.reporter.begin()
+		started := time.Now()
+		defer func() {
+			worker.reporter.end(signature.Name, result, signature.ReceiveCount > 1, time.Since(started))
+		}()
+	}
+
+	// try to extract trace span from headers and add it to the function context
+	// so it can be used inside the function if it has context.Context as the first
+	// argument. Start a new span if it isn't found.
+	taskSpan := tracing.StartSpanFromHeadersWithPropagation(worker.server.GetTracer(), worker.server.GetPropagation(), signature.Headers, signature.Name)
+	defer taskSpan.Finish()
+	tracing.AnnotateSpanWithSignatureInfo(taskSpan, signature)
+
+	// A task delivered again, e.g. redriven from a dead-letter queue, is
+	// retried
+	if signature.ReceiveCount > 1 {
+		worker.server.log().WARNING.Printf("Task %s was delivered %d times, retrying it", signature.UUID, signature.ReceiveCount)
+		if err = worker.server.GetBackend().SetStateRetry(signature); err != nil {
+			return fmt.Errorf("Set state to 'retry' for task %s returned error: %s", signature.UUID, err)
+		}
+		tracing.LogStateTransition(taskSpan, tasks.StateRetry)
+	}
+
+	// Revoked tasks are failed instead of being run
+	if !internal {
+		revoked, err := worker.server.isRevoked(signature.UUID)
+		if err != nil {
+			worker.server.log().WARNING.Printf("Failed to check whether task %s was revoked: %s", signature.UUID, err)
+		}
+		if revoked {
+			return worker.taskRevoked(taskSpan, signature)
+		}
+	}
+
+	// Expired tasks are failed instead of being run
+	if !internal && signature.Expired() {
+		return worker.taskExpired(taskSpan, signature)
+	}
+
+	// Tasks whose idempotency key belongs to a task which succeeded are not
+	// run again, e.g. when the broker delivers them twice
+	if !internal && signature.IdempotencyKey != "" {
+		ownerState, err := worker.server.succeededDuplicate(signature)
+		if err != nil {
+			worker.server.log().WARNING.Printf("Failed to check idempotency key of task %s: %s", signature.UUID, err)
+		}
+		if ownerState != nil {
+			result = outcomeSucceeded
+			return worker.skipDuplicate(taskSpan, signature, ownerState)
+		}
+	}
+
+	// Tasks whose circuit is open are sent back to run once it may close
+	var run *circuitRun
+	if !internal {
+		var retryAt time.Time
+		run, retryAt = worker.breakers.begin(signature.Name, time.Now())
+		if !retryAt.IsZero() {
+			result = outcomeRetried
+			return worker.shortCircuit(taskSpan, signature, retryAt)
+		}
+		defer run.release()
+
+		// Tasks whose rate limit is reached are sent back to run once it
+		// lets them through
+		if retryAt = worker.rateLimiters.take(signature.Name, time.Now()); !retryAt.IsZero() {
+			result = outcomeRetried
+			return worker.rateLimited(taskSpan, signature, retryAt)
+		}
 	}
 
 	// Update task state to RECEIVED
 	if err = worker.server.GetBackend().SetStateReceived(signature); err != nil {
 		return fmt.Errorf("Set state to 'received' for task %s returned error: %s", signature.UUID, err)
 	}
+	tracing.LogStateTransition(taskSpan, tasks.StateReceived)
+
+	// Keep the task as it was delivered in case it fails for good
+	var delivered []byte
+	if !internal {
+		delivered = worker.encodeDelivered(signature)
+	}
+
+	// Restore arguments compressed or encrypted by the sender
+	if err = worker.server.decodeArgs(signature); err != nil {
+		worker.taskFailed(taskSpan, signature, err)
+		worker.deadLetter(signature, delivered, tasks.DeadLetterUndecodable, err)
+		return err
+	}
 
 	// Prepare task for processing
 	task, err := tasks.NewWithSignature(taskFunc, signature)
 	// if this failed, it means the task is malformed, probably has invalid
 	// signature, go directly to task failed without checking whether to retry
 	if err != nil {
-		worker.taskFailed(signature, err)
+		worker.taskFailed(taskSpan, signature, err)
+		worker.deadLetter(signature, delivered, tasks.DeadLetterUndecodable, err)
 		return err
 	}
-
-	// try to extract trace span from headers and add it to the function context
-	// so it can be used inside the function if it has context.Context as the first
-	// argument. Start a new span if it isn't found.
-	taskSpan := tracing.StartSpanFromHeaders(signature.Headers, signature.Name)
-	tracing.AnnotateSpanWithSignatureInfo(taskSpan, signature)
 	task.Context = opentracing.ContextWithSpan(task.Context, taskSpan)
+	if progressBackend, ok := worker.server.baseBackend().(backendsiface.ProgressBackend); ok {
+		task.Context = tasks.WithProgressReporter(task.Context, newProgressReporter(progressBackend, signature))
+	}
+	logger := worker.newTaskLogger(signature)
+	task.Context = tasks.WithLogger(task.Context, logger)
 
 	// Update task state to STARTED
+	startedAt := time.Now().UTC()
+	signature.StartedAt = &startedAt
 	if err = worker.server.GetBackend().SetStateStarted(signature); err != nil {
 		return fmt.Errorf("Set state to 'started' for task %s returned error: %s", signature.UUID, err)
 	}
+	tracing.LogStateTransition(taskSpan, tasks.StateStarted)
 
 	//Run handler before the task is called
 	if worker.preTaskHandler != nil {
@@ -179,45 +461,125 @@ func (worker *Worker) Process(signature *tasks.Signature) error {
 	}
 
 	// Call the task
-	results, err := task.Call()
+	var (
+		running  *preemptible
+		watched  *revocable
+		draining *drainable
+		timeouts *TaskTimeouts
+	)
+	if !internal {
+		running = worker.preemptor.begin(signature, task)
+		watched = worker.revocations.begin(signature, task)
+		draining = worker.drain.begin(signature, task)
+		timeouts = worker.timeouts.of(signature.Name)
+	}
+	var results []*tasks.TaskResult
+	if internal {
+		results, err = task.Call()
+	} else {
+		results, err = worker.callWithMiddleware(signature, task, timeouts)
+	}
+	// Tasks sent back at the shutdown deadline run again elsewhere
+	if worker.drain.end(draining) {
+		worker.revocations.end(watched)
+		worker.preemptor.end(running)
+		result = outcomeRetried
+		return nil
+	}
+	if worker.revocations.end(watched) && err != nil {
+		worker.preemptor.end(running)
+		return worker.taskRevoked(taskSpan, signature)
+	}
+	if worker.preemptor.end(running) && err != nil {
+		result = outcomeRetried
+		return worker.requeuePreempted(taskSpan, signature)
+	}
+	run.record(err != nil, time.Now())
 	if err != nil {
+		// Errors retrying can't fix fail the task right away
+		if errors.Is(err, tasks.ErrPermanent) {
+			failErr := worker.taskFailed(taskSpan, signature, err)
+			worker.captureDiagnostics(signature, logger)
+			worker.deadLetter(signature, delivered, tasks.DeadLetterPermanent, err)
+			return failErr
+		}
+
 		// If a tasks.ErrRetryTaskLater was returned from the task,
 		// retry the task after specified duration
 		retriableErr, ok := interface{}(err).(tasks.ErrRetryTaskLater)
 		if ok {
-			return worker.retryTaskIn(signature, retriableErr.RetryIn())
+			result = outcomeRetried
+			return worker.retryTaskIn(taskSpan, signature, retriableErr.RetryInAttempt(signature.RetryAttempt))
 		}
 
 		// Otherwise, execute default retry logic based on signature.RetryCount
-		// and signature.RetryTimeout values
+		// and signature.RetryTimeout values, retryable errors may set the
+		// delay
 		if signature.RetryCount > 0 {
-			return worker.taskRetry(signature)
+			result = outcomeRetried
+			var retryable tasks.ErrRetryable
+			var retryAfter time.Duration
+			if errors.As(err, &retryable) {
+				retryAfter = retryable.RetryAfter()
+			}
+			return worker.taskRetry(taskSpan, signature, retryAfter)
 		}
 
-		return worker.taskFailed(signature, err)
+		failErr := worker.taskFailed(taskSpan, signature, err)
+		worker.captureDiagnostics(signature, logger)
+		worker.deadLetter(signature, delivered, tasks.DeadLetterExhausted, err)
+		return failErr
 	}
 
-	return worker.taskSucceeded(signature, results)
+	result = outcomeSucceeded
+	return worker.taskSucceeded(taskSpan, signature, results)
 }
 
-// retryTask decrements RetryCount counter and republishes the task to the queue
-func (worker *Worker) taskRetry(signature *tasks.Signature) error {
+// newProgressReporter returns the reporter storing the progress of the task
+// in the backend
+func newProgressReporter(backend backendsiface.ProgressBackend, signature *tasks.Signature) tasks.ProgressReporter {
+	return tasks.ProgressReporterFunc(func(percent float64, message string) error {
+		progress, err := tasks.NewProgress(percent, message)
+		if err != nil {
+			return err
+		}
+		return backend.SetProgress(signature, progress)
+	})
+}
+
+// retryTask decrements RetryCount counter and republishes the task to the
+// queue, after retryAfter unless it is zero
+func (worker *Worker) taskRetry(span opentracing.Span, signature *tasks.Signature, retryAfter time.Duration) error {
 	// Update task state to RETRY
 	if err := worker.server.GetBackend().SetStateRetry(signature); err != nil {
 		return fmt.Errorf("Set state to 'retry' for task %s returned error: %s", signature.UUID, err)
 	}
+	tracing.LogStateTransition(span, tasks.StateRetry)
 
 	// Decrement the retry counter, when it reaches 0, we won't retry again
 	signature.RetryCount--
 
-	// Increase retry timeout
-	signature.RetryTimeout = retry.FibonacciNext(signature.RetryTimeout)
+	// Increase retry timeout, exponentially with a retry policy and by the
+	// Fibonacci sequence otherwise
+	var retryIn time.Duration
+	if retryAfter > 0 {
+		retryIn = retryAfter
+		signature.RetryTimeout = int(math.Ceil(retryIn.Seconds()))
+	} else if policy := worker.server.retryPolicy(signature); policy != nil {
+		retryIn = policy.Delay(signature.RetryAttempt)
+		signature.RetryTimeout = int(math.Ceil(retryIn.Seconds()))
+	} else {
+		signature.RetryTimeout = retry.FibonacciNext(signature.RetryTimeout)
+		retryIn = time.Second * time.Duration(signature.RetryTimeout)
+	}
+	signature.RetryAttempt++
 
-	// Delay task by signature.RetryTimeout seconds
-	eta := time.Now().UTC().Add(time.Second * time.Duration(signature.RetryTimeout))
+	// Delay task by retryIn
+	eta := time.Now().UTC().Add(retryIn)
 	signature.ETA = &eta
+	tracing.LogRetry(span, signature, retryIn)
 
-	log.WARNING.Printf("Task %s failed. Going to retry in %d seconds.", signature.UUID, signature.RetryTimeout)
+	worker.server.log().WARNING.Printf("Task %s failed. Going to retry in %.0f seconds.", signature.UUID, retryIn.Seconds())
 
 	// Send the task back to the queue
 	_, err := worker.server.SendTask(signature)
@@ -225,17 +587,20 @@ func (worker *Worker) taskRetry(signature *tasks.Signature) error {
 }
 
 // taskRetryIn republishes the task to the queue with ETA of now + retryIn.Seconds()
-func (worker *Worker) retryTaskIn(signature *tasks.Signature, retryIn time.Duration) error {
+func (worker *Worker) retryTaskIn(span opentracing.Span, signature *tasks.Signature, retryIn time.Duration) error {
 	// Update task state to RETRY
 	if err := worker.server.GetBackend().SetStateRetry(signature); err != nil {
 		return fmt.Errorf("Set state to 'retry' for task %s returned error: %s", signature.UUID, err)
 	}
+	tracing.LogStateTransition(span, tasks.StateRetry)
 
 	// Delay task by retryIn duration
+	signature.RetryAttempt++
 	eta := time.Now().UTC().Add(retryIn)
 	signature.ETA = &eta
+	tracing.LogRetry(span, signature, retryIn)
 
-	log.WARNING.Printf("Task %s failed. Going to retry in %.0f seconds.", signature.UUID, retryIn.Seconds())
+	worker.server.log().WARNING.Printf("Task %s failed. Going to retry in %.0f seconds.", signature.UUID, retryIn.Seconds())
 
 	// Send the task back to the queue
 	_, err := worker.server.SendTask(signature)
@@ -244,35 +609,55 @@ func (worker *Worker) retryTaskIn(signature *tasks.Signature, retryIn time.Durat
 
 // taskSucceeded updates the task state and triggers success callbacks or a
 // chord callback if this was the last task of a group with a chord callback
-func (worker *Worker) taskSucceeded(signature *tasks.Signature, taskResults []*tasks.TaskResult) error {
+func (worker *Worker) taskSucceeded(span opentracing.Span, signature *tasks.Signature, taskResults []*tasks.TaskResult) error {
+	// Compress large results if the sender is able to decompress them
+	backendResults := taskResults
+	if compressor := worker.server.compressor(signature); compressor != nil && tasks.AcceptsEncoding(signature, compressor.Algorithm) {
+		compressed, err := compressor.CompressResults(taskResults)
+		if err != nil {
+			worker.server.log().WARNING.Printf("Failed to compress results of task %s: %s", signature.UUID, err)
+		} else {
+			backendResults = compressed
+		}
+	}
+
 	// Update task state to SUCCESS
-	if err := worker.server.GetBackend().SetStateSuccess(signature, taskResults); err != nil {
+	if err := worker.server.GetBackend().SetStateSuccess(signature, backendResults); err != nil {
 		return fmt.Errorf("Set state to 'success' for task %s returned error: %s", signature.UUID, err)
 	}
+	tracing.LogStateTransition(span, tasks.StateSuccess)
+	worker.server.releaseUniqueKey(signature)
 
 	// Log human readable results of the processed task
 	var debugResults = "[]"
 	results, err := tasks.ReflectTaskResults(taskResults)
 	if err != nil {
-		log.WARNING.Print(err)
+		worker.server.log().WARNING.Print(err)
 	} else {
 		debugResults = tasks.HumanReadableResults(results)
 	}
-	log.DEBUG.Printf("Processed task %s. Results = %s", signature.UUID, debugResults)
+	worker.server.log().DEBUG.Printf("Processed task %s. Results = %s", signature.UUID, debugResults)
 
 	// Trigger success callbacks
 
+	resultArgs := make([]tasks.Arg, len(taskResults))
+	for i, taskResult := range taskResults {
+		resultArgs[i] = tasks.Arg{
+			Type:  taskResult.Type,
+			Value: taskResult.Value,
+		}
+	}
 	for _, successTask := range signature.OnSuccess {
-		if signature.Immutable == false {
-			// Pass results of the task to success callbacks
-			for _, taskResult := range taskResults {
-				successTask.Args = append(successTask.Args, tasks.Arg{
-					Type:  taskResult.Type,
-					Value: taskResult.Value,
-				})
+		// Pass results of the task to success callbacks, unless either the
+		// task or the callback is immutable
+		if !signature.Immutable {
+			if err := successTask.AddResultArgs(resultArgs); err != nil {
+				worker.rejectResultArgs(span, successTask, err)
+				continue
 			}
 		}
 
+		worker.server.inheritHeaders(signature, successTask)
 		worker.server.SendTask(successTask)
 	}
 
@@ -281,11 +666,18 @@ func (worker *Worker) taskSucceeded(signature *tasks.Signature, taskResults []*t
 		return nil
 	}
 
-	// There is no chord callback, just return
-	if signature.ChordCallback == nil {
+	// There is no chord callback nor continuation, just return
+	if signature.ChordCallback == nil && signature.ChordContinuation == nil {
 		return nil
 	}
 
+	return worker.triggerChord(span, signature)
+}
+
+// triggerChord triggers the chord callback, continuation or error callback
+// once all tasks in the group of the task completed, unless it was triggered
+// already
+func (worker *Worker) triggerChord(span opentracing.Span, signature *tasks.Signature) error {
 	// Check if all task in the group has completed
 	groupCompleted, err := worker.server.GetBackend().GroupCompleted(
 		signature.GroupUUID,
@@ -322,7 +714,7 @@ func (worker *Worker) taskSucceeded(signature *tasks.Signature, taskResults []*t
 		signature.GroupTaskCount,
 	)
 	if err != nil {
-		log.ERROR.Printf(
+		worker.server.log().ERROR.Printf(
 			"Failed to get tasks states for group:[%s]. Task count:[%d]. The chord may not be triggered. Error:[%s]",
 			signature.GroupUUID,
 			signature.GroupTaskCount,
@@ -331,24 +723,46 @@ func (worker *Worker) taskSucceeded(signature *tasks.Signature, taskResults []*t
 		return nil
 	}
 
-	// Append group tasks' return values to chord task if it's not immutable
+	// Collect group tasks' return values to be passed to the chord callback
+	var chordArgs []tasks.Arg
+	succeeded := 0
 	for _, taskState := range taskStates {
 		if !taskState.IsSuccess() {
-			return nil
+			continue
 		}
+		succeeded++
 
-		if signature.ChordCallback.Immutable == false {
-			// Pass results of the task to the chord callback
-			for _, taskResult := range taskState.Results {
-				signature.ChordCallback.Args = append(signature.ChordCallback.Args, tasks.Arg{
-					Type:  taskResult.Type,
-					Value: taskResult.Value,
-				})
-			}
+		taskResults, err := tasks.DecompressResults(taskState.Results)
+		if err != nil {
+			return fmt.Errorf("Decompress results of task %s returned error: %s", taskState.TaskUUID, err)
+		}
+
+		for _, taskResult := range taskResults {
+			chordArgs = append(chordArgs, tasks.Arg{
+				Type:  taskResult.Type,
+				Value: taskResult.Value,
+			})
 		}
 	}
 
+	// Unless its policy says otherwise, the group only succeeds if all of
+	// its tasks did
+	if !signature.GroupFailurePolicy.Triggers(succeeded, len(taskStates)) {
+		return worker.sendChordErrorCallback(signature, taskStates)
+	}
+
+	if signature.ChordContinuation != nil {
+		return worker.sendChordContinuation(signature, chordArgs)
+	}
+
+	// Pass group tasks' return values to chord task if it's not immutable
+	if err := signature.ChordCallback.AddResultArgs(chordArgs); err != nil {
+		worker.rejectResultArgs(span, signature.ChordCallback, err)
+		return nil
+	}
+
 	// Send the chord task
+	worker.server.inheritHeaders(signature, signature.ChordCallback)
 	_, err = worker.server.SendTask(signature.ChordCallback)
 	if err != nil {
 		return err
@@ -357,17 +771,322 @@ func (worker *Worker) taskSucceeded(signature *tasks.Signature, taskResults []*t
 	return nil
 }
 
+// chordFailed triggers the chord of the failed task once all tasks in its
+// group completed, if the chord has an error callback or the policy of the
+// group allows partial results
+func (worker *Worker) chordFailed(span opentracing.Span, signature *tasks.Signature) {
+	if signature.GroupUUID == "" || (signature.ChordCallback == nil && signature.ChordContinuation == nil) {
+		return
+	}
+	if signature.ChordErrorCallback == nil && !signature.GroupFailurePolicy.AllowsPartialResults() {
+		return
+	}
+	if err := worker.triggerChord(span, signature); err != nil {
+		worker.server.log().ERROR.Print(err)
+	}
+}
+
+// sendChordErrorCallback passes the UUIDs and errors of the failed tasks of
+// a completed group to the chord error callback and sends it, if there is one
+func (worker *Worker) sendChordErrorCallback(signature *tasks.Signature, taskStates []*tasks.TaskState) error {
+	errorCallback := signature.ChordErrorCallback
+	if errorCallback == nil {
+		return nil
+	}
+
+	failedUUIDs := make([]string, 0, len(taskStates))
+	failedErrors := make([]string, 0, len(taskStates))
+	for _, taskState := range taskStates {
+		if taskState.IsSuccess() {
+			continue
+		}
+		failedUUIDs = append(failedUUIDs, taskState.TaskUUID)
+		failedErrors = append(failedErrors, taskState.Error)
+	}
+
+	// Pass the failed tasks as the first arguments to the error callback
+	errorCallback.Args = append([]tasks.Arg{
+		{Type: "[]string", Value: failedUUIDs},
+		{Type: "[]string", Value: failedErrors},
+	}, errorCallback.Args...)
+	worker.server.inheritHeaders(signature, errorCallback)
+	_, err := worker.server.SendTask(errorCallback)
+	return err
+}
+
+// sendChordContinuation passes results of a completed group to every task of
+// the continuation group and sends it, as a chord if it has a next stage
+func (worker *Worker) sendChordContinuation(signature *tasks.Signature, chordArgs []tasks.Arg) error {
+	continuation := signature.ChordContinuation
+
+	// Continue the trace of the workflow which published the completed group
+	span := tracing.StartSpanFromHeadersWithPropagation(worker.server.GetTracer(), worker.server.GetPropagation(), signature.Headers, "ChordContinuation")
+	defer span.Finish()
+	tracing.AnnotateSpanWithSignatureInfo(span, signature)
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+
+	// The group is sent as a whole, so if results don't fit any of its tasks
+	// none of them is sent
+	for _, groupTask := range continuation.Group.Tasks {
+		if err := groupTask.AddResultArgs(chordArgs); err != nil {
+			for _, rejectedTask := range continuation.Group.Tasks {
+				worker.rejectResultArgs(span, rejectedTask, err)
+			}
+			return nil
+		}
+		worker.server.inheritHeaders(signature, groupTask)
+	}
+
+	if continuation.Callback == nil && continuation.Continuation == nil {
+		_, err := worker.server.SendGroupWithContext(ctx, continuation.Group, 0)
+		return err
+	}
+
+	_, err := worker.server.SendChordWithContext(ctx, continuation, 0)
+	return err
+}
+
+// internalTask returns the task func of a task machinery sends itself
+func (worker *Worker) internalTask(name string) (interface{}, bool) {
+	switch name {
+	case tasks.GroupTimeoutTaskName:
+		return worker.groupTimedOut, true
+	case tasks.MapTaskName:
+		return worker.mapItems, true
+	case tasks.ForkTaskName:
+		return worker.fork, true
+	}
+	return nil, false
+}
+
+// groupTimedOut triggers the chord callback of a group which timed out with
+// results of the tasks which succeeded so far, unless the chord has been
+// triggered already. Tasks which didn't complete are marked TIMED_OUT.
+func (worker *Worker) groupTimedOut(ctx context.Context, groupUUID string, groupTaskCount int) error {
+	callback := tasks.SignatureFromContext(ctx).ChordCallback
+	if callback == nil {
+		return fmt.Errorf("Timeout of group %s has no chord callback", groupUUID)
+	}
+
+	shouldTrigger, err := worker.server.GetBackend().TriggerChord(groupUUID)
+	if err != nil {
+		return fmt.Errorf("Triggering chord for group %s returned error: %s", groupUUID, err)
+	}
+
+	// The group completed in time
+	if !shouldTrigger {
+		return nil
+	}
+
+	taskStates, err := worker.server.GetBackend().GroupTaskStates(groupUUID, groupTaskCount)
+	if err != nil {
+		return fmt.Errorf("Getting task states of group %s returned error: %s", groupUUID, err)
+	}
+
+	var chordArgs []tasks.Arg
+	for _, taskState := range taskStates {
+		if !taskState.IsCompleted() {
+			worker.server.log().WARNING.Printf("Task %s of group %s timed out", taskState.TaskUUID, groupUUID)
+			timedOut := &tasks.Signature{UUID: taskState.TaskUUID, Name: taskState.TaskName}
+			if err := worker.server.setStateTimedOut(timedOut); err != nil {
+				return fmt.Errorf("Set state to 'timed out' for task %s returned error: %s", taskState.TaskUUID, err)
+			}
+			continue
+		}
+		if !taskState.IsSuccess() {
+			continue
+		}
+
+		taskResults, err := tasks.DecompressResults(taskState.Results)
+		if err != nil {
+			return fmt.Errorf("Decompress results of task %s returned error: %s", taskState.TaskUUID, err)
+		}
+		for _, taskResult := range taskResults {
+			chordArgs = append(chordArgs, tasks.Arg{
+				Type:  taskResult.Type,
+				Value: taskResult.Value,
+			})
+		}
+	}
+
+	if err := callback.AddResultArgs(chordArgs); err != nil {
+		worker.rejectResultArgs(opentracing.SpanFromContext(ctx), callback, err)
+		return nil
+	}
+
+	_, err = worker.server.SendTask(callback)
+	return err
+}
+
+// mapItems sends a mapper task for every item, or chunk of items, of the list
+// returned by the source of a map/reduce, as a chord with the reducer
+func (worker *Worker) mapItems(ctx context.Context, chunkSize int64, items interface{}) error {
+	signature := tasks.SignatureFromContext(ctx)
+	continuation := signature.ChordContinuation
+	if continuation == nil || len(continuation.Group.Tasks) != 1 || continuation.Callback == nil {
+		return errors.New("Map task has no mapper and reducer")
+	}
+	mapper, reducer := continuation.Group.Tasks[0], continuation.Callback
+
+	list := reflect.ValueOf(items)
+	if list.Kind() != reflect.Slice {
+		return fmt.Errorf("Map/reduce source returned %T, expected a slice", items)
+	}
+
+	var itemArgs []tasks.Arg
+	if chunkSize > 0 {
+		for i := 0; i < list.Len(); i += int(chunkSize) {
+			end := i + int(chunkSize)
+			if end > list.Len() {
+				end = list.Len()
+			}
+			itemArgs = append(itemArgs, tasks.Arg{Type: list.Type().String(), Value: list.Slice(i, end).Interface()})
+		}
+	} else {
+		for i := 0; i < list.Len(); i++ {
+			itemArgs = append(itemArgs, tasks.Arg{Type: list.Type().Elem().String(), Value: list.Index(i).Interface()})
+		}
+	}
+
+	worker.server.inheritHeaders(signature, reducer)
+
+	// There is nothing to map, a chord of no tasks would never complete
+	if len(itemArgs) == 0 {
+		_, err := worker.server.SendTaskWithContext(ctx, reducer)
+		return err
+	}
+
+	mappers := make([]*tasks.Signature, len(itemArgs))
+	for i, itemArg := range itemArgs {
+		mappers[i] = tasks.CopySignature(mapper)
+		mappers[i].UUID = ""
+		mappers[i].Args = append(mappers[i].Args, itemArg)
+		worker.server.inheritHeaders(signature, mappers[i])
+	}
+
+	group, err := tasks.NewGroup(mappers...)
+	if err != nil {
+		return err
+	}
+	chord, err := tasks.NewChord(group, reducer)
+	if err != nil {
+		return err
+	}
+	_, err = worker.server.SendChordWithContext(ctx, chord, 0)
+	return err
+}
+
+// fork passes results of a group of a nested workflow to each task of the
+// step following the group and sends them
+func (worker *Worker) fork(ctx context.Context, _ ...interface{}) error {
+	signature := tasks.SignatureFromContext(ctx)
+	continuation := signature.ChordContinuation
+	if continuation == nil || continuation.Group == nil {
+		return errors.New("Fork task has no tasks to send")
+	}
+
+	for _, next := range continuation.Group.Tasks {
+		if err := next.AddResultArgs(signature.Args); err != nil {
+			worker.rejectResultArgs(opentracing.SpanFromContext(ctx), next, err)
+			continue
+		}
+		worker.server.inheritHeaders(signature, next)
+		if _, err := worker.server.SendTaskWithContext(ctx, next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipFollowing fails the tasks following a failed task which groups of a
+// nested workflow wait for, as they won't be sent anymore, so the groups
+// complete
+func (worker *Worker) skipFollowing(signature *tasks.Signature, taskErr error) {
+	following := append([]*tasks.Signature{}, signature.OnSuccess...)
+	if signature.ChordCallback != nil {
+		following = append(following, signature.ChordCallback)
+	}
+	if signature.Name == tasks.ForkTaskName && signature.ChordContinuation != nil && signature.ChordContinuation.Group != nil {
+		following = append(following, signature.ChordContinuation.Group.Tasks...)
+	}
+
+	for _, next := range following {
+		if next.GroupUUID != "" {
+			skipErr := fmt.Sprintf("Skipped, task %s failed: %s", signature.UUID, taskErr)
+			if err := worker.server.GetBackend().SetStateFailure(next, skipErr); err != nil {
+				worker.server.log().ERROR.Printf("Set state to 'failure' for task %s returned error: %s", next.UUID, err)
+			}
+		}
+		worker.skipFollowing(next, taskErr)
+	}
+}
+
+// sendChainOnError passes the name, arguments and error of the failed step
+// to the error handlers of its chain and sends them
+func (worker *Worker) sendChainOnError(signature *tasks.Signature, taskErr error) error {
+	if len(signature.ChainOnError) == 0 {
+		return nil
+	}
+
+	stepArgs, err := json.Marshal(signature.Args)
+	if err != nil {
+		return fmt.Errorf("Encoding arguments of task %s returned error: %s", signature.UUID, err)
+	}
+
+	for _, errorTask := range signature.ChainOnError {
+		errorTask.Args = append([]tasks.Arg{
+			{Type: "string", Value: signature.Name},
+			{Type: "string", Value: string(stepArgs)},
+			{Type: "string", Value: taskErr.Error()},
+		}, errorTask.Args...)
+		worker.server.inheritHeaders(signature, errorTask)
+		worker.server.SendTask(errorTask)
+	}
+	return nil
+}
+
+// taskExpired fails the expired task without triggering its error callbacks,
+// the tasks waiting for it are skipped
+func (worker *Worker) taskExpired(span opentracing.Span, signature *tasks.Signature) error {
+	if err := worker.server.GetBackend().SetStateFailure(signature, tasks.ErrTaskExpired.Error()); err != nil {
+		return fmt.Errorf("Set state to 'failure' for task %s returned error: %s", signature.UUID, err)
+	}
+	tracing.LogStateTransition(span, tasks.StateFailure, opentracing_log.Error(tasks.ErrTaskExpired))
+	worker.server.releaseUniqueKey(signature)
+
+	worker.server.log().WARNING.Printf("Task %s expired at %s, not running it", signature.UUID, signature.ExpiresAt.Format(time.RFC3339))
+
+	worker.skipFollowing(signature, tasks.ErrTaskExpired)
+	worker.chordFailed(span, signature)
+	return nil
+}
+
+// rejectResultArgs fails a task which can't take the results of the tasks
+// preceding it instead of sending it, so the mismatch surfaces as the task's
+// error rather than a reflection error once a worker calls it
+func (worker *Worker) rejectResultArgs(span opentracing.Span, signature *tasks.Signature, rejectErr error) {
+	rejectSpan := span.Tracer().StartSpan("RejectResultArgs", opentracing.ChildOf(span.Context()))
+	defer rejectSpan.Finish()
+	tracing.AnnotateSpanWithSignatureInfo(rejectSpan, signature)
+
+	if err := worker.taskFailed(rejectSpan, signature, rejectErr); err != nil && err != errs.ErrStopTaskDeletion {
+		worker.server.log().ERROR.Print(err)
+	}
+}
+
 // taskFailed updates the task state and triggers error callbacks
-func (worker *Worker) taskFailed(signature *tasks.Signature, taskErr error) error {
+func (worker *Worker) taskFailed(span opentracing.Span, signature *tasks.Signature, taskErr error) error {
 	// Update task state to FAILURE
 	if err := worker.server.GetBackend().SetStateFailure(signature, taskErr.Error()); err != nil {
 		return fmt.Errorf("Set state to 'failure' for task %s returned error: %s", signature.UUID, err)
 	}
+	tracing.LogStateTransition(span, tasks.StateFailure, opentracing_log.Error(taskErr))
+	worker.server.releaseUniqueKey(signature)
 
 	if worker.errorHandler != nil {
 		worker.errorHandler(taskErr)
 	} else {
-		log.ERROR.Printf("Failed processing task %s. Error = %v", signature.UUID, taskErr)
+		worker.server.log().ERROR.Printf("Failed processing task %s. Error = %v", signature.UUID, taskErr)
 	}
 
 	// Trigger error callbacks
@@ -378,9 +1097,25 @@ func (worker *Worker) taskFailed(signature *tasks.Signature, taskErr error) erro
 			Value: taskErr.Error(),
 		}}, errorTask.Args...)
 		errorTask.Args = args
+		worker.server.inheritHeaders(signature, errorTask)
 		worker.server.SendTask(errorTask)
 	}
 
+	if err := worker.sendChainOnError(signature, taskErr); err != nil {
+		worker.server.log().ERROR.Print(err)
+	}
+
+	// Undo the saga steps completed before the task
+	if signature.Compensation != nil {
+		worker.server.inheritHeaders(signature, signature.Compensation)
+		worker.server.SendTask(signature.Compensation)
+	}
+
+	worker.revokeGroup(signature)
+	worker.chordFailed(span, signature)
+
+	worker.skipFollowing(signature, taskErr)
+
 	if signature.StopTaskDeletionOnError {
 		return errs.ErrStopTaskDeletion
 	}
@@ -390,38 +1125,51 @@ func (worker *Worker) taskFailed(signature *tasks.Signature, taskErr error) erro
 
 // Returns true if the worker uses AMQP backend
 func (worker *Worker) hasAMQPBackend() bool {
-	_, ok := worker.server.GetBackend().(*amqp.Backend)
+	_, ok := worker.server.baseBackend().(*amqp.Backend)
 	return ok
 }
 
+// SetRunReportHandler makes the worker summarise the tasks it processed
+// every interval, e.g. to log them or export them as metrics. Reports are
+// logged by LogRunReport if handler is nil. Must be called before Launch.
+func (worker *Worker) SetRunReportHandler(interval time.Duration, handler func(*RunReport)) {
+	worker.reportInterval = interval
+	worker.reportHandler = handler
+}
+
 // SetErrorHandler sets a custom error handler for task errors
 // A default behavior is just to log the error after all the retry attempts fail
 func (worker *Worker) SetErrorHandler(handler func(err error)) {
 	worker.errorHandler = handler
 }
 
-//SetPreTaskHandler sets a custom handler func before a job is started
+// SetPreTaskHandler sets a custom handler func before a job is started
 func (worker *Worker) SetPreTaskHandler(handler func(*tasks.Signature)) {
 	worker.preTaskHandler = handler
 }
 
-//SetPostTaskHandler sets a custom handler for the end of a job
+// SetPostTaskHandler sets a custom handler for the end of a job
 func (worker *Worker) SetPostTaskHandler(handler func(*tasks.Signature)) {
 	worker.postTaskHandler = handler
 }
 
-//SetPreConsumeHandler sets a custom handler for the end of a job
+// SetPreConsumeHandler sets a custom handler for the end of a job
 func (worker *Worker) SetPreConsumeHandler(handler func(*Worker) bool) {
 	worker.preConsumeHandler = handler
 }
 
-//GetServer returns server
+// GetServer returns server
 func (worker *Worker) GetServer() *Server {
 	return worker.server
 }
 
-//
+// PreConsumeHandler returns false while the worker is paused or the handler
+// set with SetPreConsumeHandler returns false, brokers don't fetch tasks then
 func (worker *Worker) PreConsumeHandler() bool {
+	if worker.paused() {
+		return false
+	}
+
 	if worker.preConsumeHandler == nil {
 		return true
 	}
@@ -1,11 +1,30 @@
 package machinery_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/RichardKnop/machinery/v2"
+	"github.com/RichardKnop/machinery/v2/backends/memory"
+	"github.com/RichardKnop/machinery/v2/brokers/iface"
+	"github.com/RichardKnop/machinery/v2/common"
+	"github.com/RichardKnop/machinery/v2/config"
+	"github.com/RichardKnop/machinery/v2/tasks"
+
+	backend "github.com/RichardKnop/machinery/v2/backends/eager"
+	lock "github.com/RichardKnop/machinery/v2/locks/eager"
 )
 
 func TestRedactURL(t *testing.T) {
@@ -28,3 +47,1361 @@ func TestPreConsumeHandler(t *testing.T) {
 func SamplePreConsumeHandler(w *machinery.Worker) bool {
 	return true
 }
+
+func TestWorkerStartDelay(t *testing.T) {
+	t.Parallel()
+
+	broker := &recordingBroker{Broker: common.NewBroker(&config.Config{})}
+	cnf := &config.Config{StartDelay: 1, StartJitter: 2, NoUnixSignals: true}
+	server := machinery.NewServer(cnf, broker, backend.New(), lock.New())
+	worker := server.NewWorker("test_worker", 1)
+
+	for i := 0; i < 10; i++ {
+		delay := worker.StartDelay()
+		assert.True(t, delay >= time.Second && delay < 3*time.Second, delay)
+	}
+
+	// The worker doesn't consume until the delay passed, unless it quits
+	errorsChan := make(chan error, 1)
+	worker.LaunchAsync(errorsChan)
+	select {
+	case err := <-errorsChan:
+		t.Fatalf("worker stopped before its start delay passed: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	worker.Quit()
+	select {
+	case err := <-errorsChan:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("worker didn't quit during its start delay")
+	}
+}
+
+// recordingBroker collects published signatures so tests can process them
+// one by one, simulating the round trip through a real broker
+type recordingBroker struct {
+	common.Broker
+	mu        sync.Mutex
+	published []*tasks.Signature
+}
+
+func (b *recordingBroker) StartConsuming(consumerTag string, concurrency int, p iface.TaskProcessor) (bool, error) {
+	return false, nil
+}
+
+func (b *recordingBroker) StopConsuming() {}
+
+func (b *recordingBroker) Publish(ctx context.Context, signature *tasks.Signature) error {
+	message, err := json.Marshal(signature)
+	if err != nil {
+		return err
+	}
+
+	decoded := new(tasks.Signature)
+	decoder := json.NewDecoder(bytes.NewReader(message))
+	decoder.UseNumber()
+	if err := decoder.Decode(decoded); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.published = append(b.published, decoded)
+	return nil
+}
+
+// QueueDepth counts the published signatures
+func (b *recordingBroker) QueueDepth(queue string) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.published), nil
+}
+
+// peek returns the oldest published signature without popping it
+func (b *recordingBroker) peek() *tasks.Signature {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.published) == 0 {
+		return nil
+	}
+	return b.published[0]
+}
+
+// next pops the oldest published signature
+func (b *recordingBroker) next() *tasks.Signature {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.published) == 0 {
+		return nil
+	}
+	signature := b.published[0]
+	b.published = b.published[1:]
+	return signature
+}
+
+func newRecordingServer(t *testing.T) (*machinery.Server, *recordingBroker) {
+	broker := &recordingBroker{Broker: common.NewBroker(&config.Config{})}
+	server := machinery.NewServer(&config.Config{}, broker, backend.New(), lock.New())
+	return server, broker
+}
+
+// drain processes published tasks until the broker has nothing left
+func drain(t *testing.T, worker *machinery.Worker, broker *recordingBroker) {
+	for signature := broker.next(); signature != nil; signature = broker.next() {
+		assert.NoError(t, worker.Process(signature))
+	}
+}
+
+func TestChordContinuation(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	err := server.RegisterTasks(map[string]interface{}{
+		"value": func(n int64) (int64, error) { return n, nil },
+		"sum": func(values ...int64) (int64, error) {
+			var sum int64
+			for _, value := range values {
+				sum += value
+			}
+			return sum, nil
+		},
+	})
+	assert.NoError(t, err)
+
+	value := func(n int64) *tasks.Signature {
+		return &tasks.Signature{Name: "value", Args: []tasks.Arg{{Type: "int64", Value: n}}}
+	}
+
+	// Stage 1 maps to values 1 and 2, stage 2 sums them twice in parallel and
+	// the final callback sums the partial sums
+	stage2, _ := tasks.NewGroup(&tasks.Signature{Name: "sum"}, &tasks.Signature{Name: "sum"})
+	continuation, _ := tasks.NewChord(stage2, &tasks.Signature{Name: "sum"})
+	stage1, _ := tasks.NewGroup(value(1), value(2))
+	chord, err := tasks.NewChordWithContinuation(stage1, continuation)
+	assert.NoError(t, err)
+
+	_, err = server.SendChord(chord, 1)
+	assert.NoError(t, err)
+
+	drain(t, server.NewWorker("test_worker", 1), broker)
+
+	state, err := server.GetBackend().GetState(continuation.Callback.UUID)
+	if assert.NoError(t, err) && assert.True(t, state.IsSuccess()) {
+		assert.EqualValues(t, "6", fmt.Sprintf("%v", state.Results[0].Value))
+	}
+}
+
+func TestTaskStateDurations(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	server.SetBackend(memory.New(new(config.Config)))
+	err := server.RegisterTask("sleep", func() error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	asyncResult, err := server.SendTask(&tasks.Signature{Name: "sleep"})
+	assert.NoError(t, err)
+
+	taskState := asyncResult.GetState()
+	assert.Equal(t, tasks.StatePending, taskState.State)
+	assert.NotNil(t, taskState.EnqueuedAt)
+	assert.Nil(t, taskState.StartedAt)
+
+	drain(t, server.NewWorker("test_worker", 1), broker)
+
+	taskState, err = server.GetBackend().GetState(asyncResult.Signature.UUID)
+	if assert.NoError(t, err) && assert.NotNil(t, taskState.StartedAt) && assert.NotNil(t, taskState.FinishedAt) {
+		assert.False(t, taskState.StartedAt.Before(*taskState.EnqueuedAt))
+		assert.Equal(t, taskState.StartedAt.Sub(*taskState.EnqueuedAt), taskState.QueueWait)
+		assert.True(t, taskState.Duration >= 20*time.Millisecond)
+		assert.False(t, taskState.FinishedAt.Before(taskState.StartedAt.Add(taskState.Duration)))
+	}
+
+	queueWait, duration, err := asyncResult.Durations()
+	assert.NoError(t, err)
+	assert.Equal(t, taskState.QueueWait, queueWait)
+	assert.Equal(t, taskState.Duration, duration)
+
+	slow, err := server.ListStates(&tasks.StateFilter{MinDuration: 20 * time.Millisecond})
+	assert.NoError(t, err)
+	assert.Len(t, slow, 1)
+}
+
+type order struct {
+	ID    string           `json:"id"`
+	Items map[string]int64 `json:"items"`
+}
+
+type quote struct {
+	OrderID string `json:"order_id"`
+	Total   int64  `json:"total"`
+}
+
+func TestStructArgs(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, tasks.RegisterType("Order", order{}))
+	assert.NoError(t, tasks.RegisterType("Quote", quote{}))
+
+	server, broker := newRecordingServer(t)
+	var invoiced []quote
+	err := server.RegisterTasks(map[string]interface{}{
+		"price": func(o order, prices map[string]interface{}) (quote, error) {
+			q := quote{OrderID: o.ID}
+			for sku, quantity := range o.Items {
+				price, err := prices[sku].(json.Number).Int64()
+				if err != nil {
+					return quote{}, err
+				}
+				q.Total += quantity * price
+			}
+			return q, nil
+		},
+		"invoice": func(quotes []quote, q quote) error {
+			invoiced = append(quotes, q)
+			return nil
+		},
+	})
+	assert.NoError(t, err)
+
+	chain, err := tasks.NewChain(
+		&tasks.Signature{Name: "price", Args: []tasks.Arg{
+			{Type: "Order", Value: order{ID: "o-1", Items: map[string]int64{"apple": 3, "pear": 1}}},
+			{Type: "map[string]interface{}", Value: map[string]interface{}{"apple": 2, "pear": 5}},
+		}},
+		// The quote is appended to the arguments
+		&tasks.Signature{Name: "invoice", Args: []tasks.Arg{
+			{Type: "[]Quote", Value: []quote{{OrderID: "o-0", Total: 1}}},
+		}},
+	)
+	assert.NoError(t, err)
+	_, err = server.SendChain(chain)
+	assert.NoError(t, err)
+
+	drain(t, server.NewWorker("test_worker", 1), broker)
+
+	assert.Equal(t, []quote{{OrderID: "o-0", Total: 1}, {OrderID: "o-1", Total: 11}}, invoiced)
+}
+
+func TestChainOnError(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	var (
+		calls                      int
+		stepName, stepArgs, errMsg string
+		note                       string
+	)
+	err := server.RegisterTasks(map[string]interface{}{
+		"double": func(n int64) (int64, error) {
+			return 2 * n, nil
+		},
+		"check": func(n int64) error {
+			if n > 3 {
+				return fmt.Errorf("value %d too large", n)
+			}
+			return nil
+		},
+		"cleanup": func(name, args, errText, text string) error {
+			calls++
+			stepName, stepArgs, errMsg, note = name, args, errText, text
+			return nil
+		},
+	})
+	assert.NoError(t, err)
+
+	chain, err := tasks.NewChainWithOnError(
+		[]*tasks.Signature{{Name: "cleanup", Args: []tasks.Arg{{Type: "string", Value: "doubling"}}}},
+		&tasks.Signature{Name: "double", Args: []tasks.Arg{{Type: "int64", Value: 1}}},
+		&tasks.Signature{Name: "double"},
+		&tasks.Signature{Name: "check"},
+	)
+	assert.NoError(t, err)
+
+	_, err = server.SendChain(chain)
+	assert.NoError(t, err)
+
+	drain(t, server.NewWorker("test_worker", 1), broker)
+
+	// Only the failed step sends the error handlers
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, "check", stepName)
+	var args []tasks.Arg
+	if assert.NoError(t, json.Unmarshal([]byte(stepArgs), &args)) && assert.Len(t, args, 1) {
+		assert.Equal(t, "int64", args[0].Type)
+		assert.EqualValues(t, 4, args[0].Value)
+	}
+	assert.Equal(t, "value 4 too large", errMsg)
+	assert.Equal(t, "doubling", note)
+}
+
+func TestChordErrorCallback(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	var (
+		failedUUIDs, failedErrors []string
+		note                      string
+		reduced                   bool
+	)
+	err := server.RegisterTasks(map[string]interface{}{
+		"value": func(n int64) (int64, error) {
+			if n < 0 {
+				return 0, fmt.Errorf("negative value %d", n)
+			}
+			return n, nil
+		},
+		"sum": func(values ...int64) (int64, error) {
+			reduced = true
+			return 0, nil
+		},
+		"alert": func(uuids, errs []string, text string) error {
+			failedUUIDs, failedErrors, note = uuids, errs, text
+			return nil
+		},
+	})
+	assert.NoError(t, err)
+
+	value := func(n int64) *tasks.Signature {
+		return &tasks.Signature{Name: "value", Args: []tasks.Arg{{Type: "int64", Value: n}}}
+	}
+
+	// The failing task isn't the last one of the group to complete
+	group, _ := tasks.NewGroup(value(1), value(-2), value(3))
+	chord, err := tasks.NewChordWithErrorCallback(
+		group,
+		&tasks.Signature{Name: "sum"},
+		&tasks.Signature{Name: "alert", Args: []tasks.Arg{{Type: "string", Value: "sum failed"}}},
+	)
+	assert.NoError(t, err)
+
+	_, err = server.SendChord(chord, 1)
+	assert.NoError(t, err)
+
+	drain(t, server.NewWorker("test_worker", 1), broker)
+
+	assert.False(t, reduced)
+	assert.Equal(t, []string{group.Tasks[1].UUID}, failedUUIDs)
+	assert.Equal(t, []string{"negative value -2"}, failedErrors)
+	assert.Equal(t, "sum failed", note)
+
+	// The failing task is the last one of the group to complete
+	failedUUIDs, failedErrors = nil, nil
+	group, _ = tasks.NewGroup(value(1), value(-3))
+	chord, err = tasks.NewChordWithErrorCallback(group, &tasks.Signature{Name: "sum"}, &tasks.Signature{Name: "alert", Args: []tasks.Arg{{Type: "string", Value: "again"}}})
+	assert.NoError(t, err)
+
+	_, err = server.SendChord(chord, 1)
+	assert.NoError(t, err)
+
+	drain(t, server.NewWorker("test_worker", 1), broker)
+
+	assert.False(t, reduced)
+	assert.Equal(t, []string{group.Tasks[1].UUID}, failedUUIDs)
+	assert.Equal(t, []string{"negative value -3"}, failedErrors)
+}
+
+func TestGroupFailurePolicy(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	server.SetBackend(memory.New(new(config.Config)))
+	var (
+		sum         int64
+		reduced     bool
+		failedUUIDs []string
+		ran         []int64
+	)
+	err := server.RegisterTasks(map[string]interface{}{
+		"value": func(n int64) (int64, error) {
+			ran = append(ran, n)
+			if n < 0 {
+				return 0, fmt.Errorf("negative value %d", n)
+			}
+			return n, nil
+		},
+		"sum": func(values ...int64) (int64, error) {
+			reduced = true
+			sum = 0
+			for _, value := range values {
+				sum += value
+			}
+			return sum, nil
+		},
+		"alert": func(uuids, errs []string) error {
+			failedUUIDs = uuids
+			return nil
+		},
+	})
+	assert.NoError(t, err)
+
+	value := func(n int64) *tasks.Signature {
+		return &tasks.Signature{Name: "value", Args: []tasks.Arg{{Type: "int64", Value: n}}}
+	}
+	run := func(policy *tasks.GroupFailurePolicy, values ...int64) *tasks.Group {
+		reduced, failedUUIDs, ran = false, nil, nil
+		signatures := make([]*tasks.Signature, len(values))
+		for i, n := range values {
+			signatures[i] = value(n)
+		}
+		group, _ := tasks.NewGroup(signatures...)
+		group.FailurePolicy = policy
+		chord, err := tasks.NewChordWithErrorCallback(group, &tasks.Signature{Name: "sum"}, &tasks.Signature{Name: "alert"})
+		assert.NoError(t, err)
+		_, err = server.SendChord(chord, 1)
+		assert.NoError(t, err)
+		drain(t, server.NewWorker("test_worker", 1), broker)
+		return group
+	}
+
+	// The callback gets results of the tasks which succeeded
+	run(&tasks.GroupFailurePolicy{Mode: tasks.GroupContinueAndCollect}, 1, -2, 3)
+	assert.True(t, reduced)
+	assert.Equal(t, int64(4), sum)
+	assert.Nil(t, failedUUIDs)
+
+	run(&tasks.GroupFailurePolicy{Mode: tasks.GroupThreshold, Threshold: 2}, 1, -2, 3)
+	assert.True(t, reduced)
+	assert.Equal(t, int64(4), sum)
+
+	group := run(&tasks.GroupFailurePolicy{Mode: tasks.GroupThreshold, Threshold: 3}, 1, -2, 3)
+	assert.False(t, reduced)
+	assert.Equal(t, []string{group.Tasks[1].UUID}, failedUUIDs)
+
+	// Tasks which haven't run are revoked once one failed
+	group = run(&tasks.GroupFailurePolicy{Mode: tasks.GroupFailFast}, -1, 2, 3)
+	assert.False(t, reduced)
+	assert.Equal(t, []int64{-1}, ran)
+	assert.Equal(t, []string{group.Tasks[0].UUID, group.Tasks[1].UUID, group.Tasks[2].UUID}, failedUUIDs)
+	state, err := server.GetBackend().GetState(group.Tasks[2].UUID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, tasks.ErrTaskRevoked.Error(), state.Error)
+	}
+
+	_, err = server.SendGroup(&tasks.Group{FailurePolicy: &tasks.GroupFailurePolicy{Mode: tasks.GroupThreshold}}, 1)
+	assert.Error(t, err)
+}
+
+func TestChordContinuationGroup(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	err := server.RegisterTasks(map[string]interface{}{
+		"value": func(n int64) (int64, error) { return n, nil },
+		"sum": func(values ...int64) (int64, error) {
+			var sum int64
+			for _, value := range values {
+				sum += value
+			}
+			return sum, nil
+		},
+	})
+	assert.NoError(t, err)
+
+	stage2, _ := tasks.NewGroup(&tasks.Signature{Name: "sum"}, &tasks.Signature{Name: "sum", Immutable: true})
+	stage1, _ := tasks.NewGroup(&tasks.Signature{Name: "value", Args: []tasks.Arg{{Type: "int64", Value: 5}}})
+	chord, err := tasks.NewChordWithContinuation(stage1, &tasks.Chord{Group: stage2})
+	assert.NoError(t, err)
+
+	_, err = server.SendChord(chord, 1)
+	assert.NoError(t, err)
+
+	drain(t, server.NewWorker("test_worker", 1), broker)
+
+	for i, expected := range []string{"5", "0"} {
+		state, err := server.GetBackend().GetState(stage2.Tasks[i].UUID)
+		if assert.NoError(t, err) && assert.True(t, state.IsSuccess()) {
+			assert.Equal(t, expected, fmt.Sprintf("%v", state.Results[0].Value))
+		}
+	}
+}
+
+func TestGroupTimeout(t *testing.T) {
+	t.Parallel()
+
+	// The eager backend triggers chords more than once
+	server, broker := newRecordingServer(t)
+	server.SetBackend(memory.New(new(config.Config)))
+	err := server.RegisterTasks(map[string]interface{}{
+		"value": func(n int64) (int64, error) { return n, nil },
+		"sum": func(values ...int64) (int64, error) {
+			var sum int64
+			for _, value := range values {
+				sum += value
+			}
+			return sum, nil
+		},
+	})
+	assert.NoError(t, err)
+
+	group, _ := tasks.NewGroup(
+		&tasks.Signature{Name: "value", Args: []tasks.Arg{{Type: "int64", Value: 1}}},
+		&tasks.Signature{Name: "value", Args: []tasks.Arg{{Type: "int64", Value: 2}}},
+		&tasks.Signature{Name: "value", Args: []tasks.Arg{{Type: "int64", Value: 3}}},
+	)
+	group.Timeout = time.Minute
+	chord, _ := tasks.NewChord(group, &tasks.Signature{Name: "sum"})
+	_, err = server.SendChord(chord, 1)
+	assert.NoError(t, err)
+
+	// The task adding 3 is stuck until the group timed out
+	worker := server.NewWorker("test_worker", 0)
+	var stuck *tasks.Signature
+	for signature := broker.next(); signature != nil; signature = broker.next() {
+		if signature.UUID == group.Tasks[2].UUID {
+			stuck = signature
+			continue
+		}
+		if signature.Name == tasks.GroupTimeoutTaskName {
+			assert.NotNil(t, signature.ETA)
+		}
+		assert.NoError(t, worker.Process(signature))
+	}
+
+	state, err := server.GetBackend().GetState(chord.Callback.UUID)
+	if assert.NoError(t, err) && assert.True(t, state.IsSuccess()) {
+		assert.Equal(t, "3", fmt.Sprintf("%v", state.Results[0].Value))
+	}
+	state, err = server.GetBackend().GetState(group.Tasks[2].UUID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, tasks.StateTimedOut, state.State)
+	}
+
+	// Completing late doesn't trigger the chord again
+	if assert.NotNil(t, stuck) {
+		assert.NoError(t, worker.Process(stuck))
+	}
+	assert.Nil(t, broker.next())
+}
+func TestSaga(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	var (
+		mu     sync.Mutex
+		undone []string
+	)
+	undo := func(step string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		undone = append(undone, step)
+		return nil
+	}
+	err := server.RegisterTasks(map[string]interface{}{
+		"step": func(step string) error { return nil },
+		"fail": func() error { return errors.New("out of stock") },
+		"undo": undo,
+	})
+	assert.NoError(t, err)
+
+	step := func(name string, compensate bool) *tasks.SagaStep {
+		args := []tasks.Arg{{Type: "string", Value: name}}
+		sagaStep := &tasks.SagaStep{Task: &tasks.Signature{Name: "step", Args: args}}
+		if compensate {
+			sagaStep.Compensation = &tasks.Signature{Name: "undo", Args: args}
+		}
+		return sagaStep
+	}
+	failing := &tasks.SagaStep{Task: &tasks.Signature{Name: "fail"}, Compensation: &tasks.Signature{Name: "undo"}}
+	saga, err := tasks.NewSaga(step("reserve", true), step("charge", false), step("pack", true), failing, step("ship", true))
+	assert.NoError(t, err)
+
+	_, err = server.SendSaga(saga)
+	assert.NoError(t, err)
+
+	drain(t, server.NewWorker("test_worker", 1), broker)
+
+	// Only steps completed before the failed one are undone, latest first
+	assert.Equal(t, []string{"pack", "reserve"}, undone)
+	state, err := server.GetBackend().GetState(failing.Task.UUID)
+	if assert.NoError(t, err) {
+		assert.True(t, state.IsFailure())
+	}
+}
+
+func TestMapReduce(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name      string
+		size      int64
+		chunkSize int
+		expected  int64
+	}{
+		{name: "items", size: 5, expected: 2 * (1 + 2 + 3 + 4 + 5)},
+		{name: "chunks", size: 5, chunkSize: 2, expected: 2 * (1 + 2 + 3 + 4 + 5)},
+		{name: "empty", size: 0, expected: 0},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			server, broker := newRecordingServer(t)
+			sum := func(values ...int64) (int64, error) {
+				var sum int64
+				for _, value := range values {
+					sum += value
+				}
+				return sum, nil
+			}
+			err := server.RegisterTasks(map[string]interface{}{
+				"list": func(size int64) ([]int64, error) {
+					list := []int64{}
+					for i := int64(1); i <= size; i++ {
+						list = append(list, i)
+					}
+					return list, nil
+				},
+				"scale": func(factor, n int64) (int64, error) { return factor * n, nil },
+				"scaleChunk": func(factor int64, chunk []int64) (int64, error) {
+					chunkSum, _ := sum(chunk...)
+					return factor * chunkSum, nil
+				},
+				"sum": sum,
+			})
+			assert.NoError(t, err)
+
+			mapper := &tasks.Signature{Name: "scale", Args: []tasks.Arg{{Type: "int64", Value: 2}}}
+			if tc.chunkSize > 0 {
+				mapper.Name = "scaleChunk"
+			}
+			mapReduce, err := tasks.NewMapReduce(
+				&tasks.Signature{Name: "list", Args: []tasks.Arg{{Type: "int64", Value: tc.size}}},
+				mapper,
+				&tasks.Signature{Name: "sum"},
+				tc.chunkSize,
+			)
+			assert.NoError(t, err)
+
+			asyncResult, err := server.SendMapReduce(mapReduce)
+			assert.NoError(t, err)
+
+			var mapped int
+			worker := server.NewWorker("test_worker", 1)
+			for signature := broker.next(); signature != nil; signature = broker.next() {
+				if signature.Name == mapper.Name {
+					mapped++
+				}
+				assert.NoError(t, worker.Process(signature))
+			}
+
+			expectedMapped := int(tc.size)
+			if tc.chunkSize > 0 {
+				expectedMapped = (expectedMapped + tc.chunkSize - 1) / tc.chunkSize
+			}
+			assert.Equal(t, expectedMapped, mapped)
+
+			state, err := server.GetBackend().GetState(asyncResult.Signature.UUID)
+			if assert.NoError(t, err) && assert.True(t, state.IsSuccess(), state.Error) {
+				assert.Equal(t, fmt.Sprintf("%d", tc.expected), fmt.Sprintf("%v", state.Results[0].Value))
+			}
+		})
+	}
+}
+
+func TestFlow(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	err := server.RegisterTasks(map[string]interface{}{
+		"value": func(n int64) (int64, error) { return n, nil },
+		"sum": func(values ...int64) (int64, error) {
+			var sum int64
+			for _, value := range values {
+				sum += value
+			}
+			return sum, nil
+		},
+		"fail": func() error { return errors.New("failed") },
+	})
+	assert.NoError(t, err)
+
+	sum := func(values ...int64) *tasks.Signature {
+		signature := &tasks.Signature{Name: "sum"}
+		for _, value := range values {
+			signature.Args = append(signature.Args, tasks.Arg{Type: "int64", Value: value})
+		}
+		return signature
+	}
+	value := func(n int64) *tasks.Signature {
+		return &tasks.Signature{Name: "value", Args: []tasks.Arg{{Type: "int64", Value: n}}}
+	}
+	mustChain := func(steps ...tasks.FlowStep) *tasks.Flow {
+		flow, err := tasks.NewFlowChain(steps...)
+		assert.NoError(t, err)
+		return flow
+	}
+	mustGroup := func(members ...tasks.FlowStep) *tasks.Flow {
+		flow, err := tasks.NewFlowGroup(members...)
+		assert.NoError(t, err)
+		return flow
+	}
+	worker := server.NewWorker("test_worker", 1)
+
+	t.Run("chain of groups of chains", func(t *testing.T) {
+		// 1 -> (1+10 -> 11+100, (1+1000, 1+2)) -> 111+1001+3 -> (1115+1, 1115+2)
+		flow := mustChain(
+			value(1),
+			mustGroup(mustChain(sum(10), sum(100)), mustGroup(sum(1000), sum(2))),
+			sum(),
+			mustGroup(sum(1), sum(2)),
+		)
+		asyncResults, err := server.SendFlow(flow)
+		assert.NoError(t, err)
+		drain(t, worker, broker)
+
+		if assert.Len(t, asyncResults, 2) {
+			for i, expected := range []string{"1116", "1117"} {
+				state, err := server.GetBackend().GetState(asyncResults[i].Signature.UUID)
+				if assert.NoError(t, err) && assert.True(t, state.IsSuccess(), state.Error) {
+					assert.Equal(t, expected, fmt.Sprintf("%v", state.Results[0].Value))
+				}
+			}
+		}
+	})
+
+	t.Run("group followed by a group", func(t *testing.T) {
+		// (1, 2) -> (10+1+2, 20+1+2) -> 13+23
+		flow := mustChain(mustGroup(value(1), value(2)), mustGroup(sum(10), sum(20)), sum())
+		asyncResults, err := server.SendFlow(flow)
+		assert.NoError(t, err)
+		drain(t, worker, broker)
+
+		state, err := server.GetBackend().GetState(asyncResults[0].Signature.UUID)
+		if assert.NoError(t, err) && assert.True(t, state.IsSuccess(), state.Error) {
+			assert.Equal(t, "36", fmt.Sprintf("%v", state.Results[0].Value))
+		}
+	})
+
+	t.Run("failed step", func(t *testing.T) {
+		skipped, last := value(1), sum()
+		flow := mustChain(mustGroup(mustChain(&tasks.Signature{Name: "fail"}, skipped), value(2)), last)
+		_, err := server.SendFlow(flow)
+		assert.NoError(t, err)
+		drain(t, worker, broker)
+
+		// The chain's last task won't run, it fails so the group completes
+		state, err := server.GetBackend().GetState(skipped.UUID)
+		if assert.NoError(t, err) {
+			assert.Equal(t, tasks.StateFailure, state.State)
+			assert.Contains(t, state.Error, "Skipped")
+		}
+		completed, err := server.GetBackend().GroupCompleted(skipped.GroupUUID, 2)
+		assert.NoError(t, err)
+		assert.True(t, completed)
+
+		_, err = server.GetBackend().GetState(last.UUID)
+		assert.Error(t, err)
+	})
+}
+
+func TestChainResultArgs(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	err := server.RegisterTasks(map[string]interface{}{
+		"value": func(n int64) (int64, error) { return n, nil },
+		"sum": func(values ...int64) (int64, error) {
+			var sum int64
+			for _, value := range values {
+				sum += value
+			}
+			return sum, nil
+		},
+	})
+	assert.NoError(t, err)
+
+	value := func(n int64) tasks.Arg { return tasks.Arg{Type: "int64", Value: n} }
+	steps := []*tasks.Signature{
+		{Name: "value", Args: []tasks.Arg{value(1)}},
+		{Name: "sum", Args: []tasks.Arg{value(10)}},
+		{Name: "sum", Args: []tasks.Arg{value(100)}, ResultArgs: tasks.ResultArgsReplace},
+		{Name: "sum", Args: []tasks.Arg{value(1000)}, ResultArgs: tasks.ResultArgsIgnore},
+		{Name: "sum", Args: []tasks.Arg{value(10000)}, Immutable: true},
+		{Name: "sum", Args: []tasks.Arg{value(100000)}},
+	}
+	chain, err := tasks.NewChain(steps...)
+	assert.NoError(t, err)
+
+	_, err = server.SendChain(chain)
+	assert.NoError(t, err)
+
+	drain(t, server.NewWorker("test_worker", 1), broker)
+
+	// An immutable step doesn't pass its own results on either
+	for i, expected := range []string{"1", "11", "11", "1000", "10000", "100000"} {
+		state, err := server.GetBackend().GetState(steps[i].UUID)
+		if assert.NoError(t, err) && assert.True(t, state.IsSuccess()) {
+			assert.Equal(t, expected, fmt.Sprintf("%v", state.Results[0].Value), "step %d", i)
+		}
+	}
+}
+
+func TestChainResultIndexes(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	err := server.RegisterTasks(map[string]interface{}{
+		"divmod": func(a, b int64) (int64, int64, error) { return a / b, a % b, nil },
+		"double": func(n int64) (int64, error) { return 2 * n, nil },
+	})
+	assert.NoError(t, err)
+
+	value := func(n int64) tasks.Arg { return tasks.Arg{Type: "int64", Value: n} }
+	steps := []*tasks.Signature{
+		{Name: "divmod", Args: []tasks.Arg{value(7), value(2)}},
+		{Name: "double", ResultIndexes: []int{1}},
+	}
+	chain, err := tasks.NewChain(steps...)
+	assert.NoError(t, err)
+	_, err = server.SendChain(chain)
+	assert.NoError(t, err)
+
+	drain(t, server.NewWorker("test_worker", 1), broker)
+
+	state, err := server.GetBackend().GetState(steps[1].UUID)
+	if assert.NoError(t, err) && assert.True(t, state.IsSuccess()) {
+		assert.Equal(t, "2", fmt.Sprintf("%v", state.Results[0].Value))
+	}
+
+	// Without picking, both results are passed and the step fails before
+	// it is called. Picking a result which doesn't exist fails the step
+	// before it is even sent.
+	for _, step := range []*tasks.Signature{
+		{Name: "double"},
+		{Name: "double", ResultIndexes: []int{2}},
+	} {
+		chain, err := tasks.NewChain(&tasks.Signature{Name: "divmod", Args: []tasks.Arg{value(7), value(2)}}, step)
+		assert.NoError(t, err)
+		_, err = server.SendChain(chain)
+		assert.NoError(t, err)
+
+		worker := server.NewWorker("test_worker", 1)
+		for signature := broker.next(); signature != nil; signature = broker.next() {
+			worker.Process(signature)
+		}
+
+		state, err := server.GetBackend().GetState(step.UUID)
+		if assert.NoError(t, err) && assert.True(t, state.IsFailure()) {
+			assert.Contains(t, state.Error, "double")
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	server.SetConfig(&config.Config{
+		Compression: &config.CompressionConfig{Algorithm: tasks.CompressionZstd, Threshold: 100, Args: true},
+	})
+	err := server.RegisterTask("echo", func(s string) (string, error) { return s, nil })
+	assert.NoError(t, err)
+
+	large := strings.Repeat("machinery", 100)
+	asyncResult, err := server.SendTask(&tasks.Signature{Name: "echo", Args: []tasks.Arg{{Type: "string", Value: large}}})
+	assert.NoError(t, err)
+
+	signature := broker.next()
+	if assert.NotNil(t, signature) {
+		assert.Equal(t, tasks.CompressionZstd, signature.Headers[tasks.ArgsEncodingHeader])
+		assert.Len(t, signature.Args, 1)
+		assert.NotEqual(t, "string", signature.Args[0].Type)
+		assert.NoError(t, server.NewWorker("test_worker", 0).Process(signature))
+	}
+
+	state, err := server.GetBackend().GetState(signature.UUID)
+	if assert.NoError(t, err) && assert.Len(t, state.Results, 1) {
+		assert.NotEqual(t, "string", state.Results[0].Type)
+	}
+
+	results, err := asyncResult.GetWithTimeout(time.Second, time.Millisecond)
+	if assert.NoError(t, err) && assert.Len(t, results, 1) {
+		assert.Equal(t, large, results[0].Interface())
+	}
+}
+
+func TestCompressionWithoutArgs(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	server.SetConfig(&config.Config{
+		Compression: &config.CompressionConfig{Algorithm: tasks.CompressionZstd, Threshold: 100},
+	})
+	err := server.RegisterTask("echo", func(s string) (string, error) { return s, nil })
+	assert.NoError(t, err)
+
+	// Arguments are left alone for workers unable to decompress them
+	large := strings.Repeat("machinery", 100)
+	asyncResult, err := server.SendTask(&tasks.Signature{Name: "echo", Args: []tasks.Arg{{Type: "string", Value: large}}})
+	assert.NoError(t, err)
+
+	signature := broker.next()
+	if assert.NotNil(t, signature) {
+		assert.Nil(t, signature.Headers[tasks.ArgsEncodingHeader])
+		assert.Equal(t, large, signature.Args[0].Value)
+		assert.True(t, tasks.AcceptsEncoding(signature, tasks.CompressionZstd))
+		assert.NoError(t, server.NewWorker("test_worker", 0).Process(signature))
+	}
+
+	// Results are still compressed for the sender asking for it
+	state, err := server.GetBackend().GetState(signature.UUID)
+	if assert.NoError(t, err) && assert.Len(t, state.Results, 1) {
+		assert.NotEqual(t, "string", state.Results[0].Type)
+	}
+
+	results, err := asyncResult.GetWithTimeout(time.Second, time.Millisecond)
+	if assert.NoError(t, err) && assert.Len(t, results, 1) {
+		assert.Equal(t, large, results[0].Interface())
+	}
+}
+
+func TestExpiredTask(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	server.SetBackend(memory.New(new(config.Config)))
+	called := false
+	err := server.RegisterTask("notify", func() error {
+		called = true
+		return nil
+	})
+	assert.NoError(t, err)
+
+	expiresAt := time.Now().Add(-time.Minute)
+	callback := &tasks.Signature{Name: "notify"}
+	signature := &tasks.Signature{
+		Name:      "notify",
+		ExpiresAt: &expiresAt,
+		OnSuccess: []*tasks.Signature{callback},
+		OnError:   []*tasks.Signature{callback},
+	}
+	asyncResult, err := server.SendTask(signature)
+	assert.NoError(t, err)
+
+	worker := server.NewWorker("test_worker", 1)
+	assert.NoError(t, worker.Process(broker.next()))
+	assert.False(t, called)
+	assert.Nil(t, broker.next(), "callbacks of expired tasks are not sent")
+
+	_, err = asyncResult.Touch()
+	assert.EqualError(t, err, tasks.ErrTaskExpired.Error())
+
+	// Tasks which haven't expired yet run
+	expiresAt = time.Now().Add(time.Minute)
+	_, err = server.SendTask(&tasks.Signature{Name: "notify", ExpiresAt: &expiresAt})
+	assert.NoError(t, err)
+	assert.NoError(t, worker.Process(broker.next()))
+	assert.True(t, called)
+}
+
+func TestSpanEvents(t *testing.T) {
+	// Not parallel, the global tracer is swapped for the test
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	server, broker := newRecordingServer(t)
+	err := server.RegisterTask("fail", func() error { return errors.New("boom") })
+	assert.NoError(t, err)
+
+	_, err = server.SendTask(&tasks.Signature{Name: "fail", RetryCount: 1})
+	assert.NoError(t, err)
+	drain(t, server.NewWorker("test_worker", 0), broker)
+
+	var events [][]string
+	for _, span := range tracer.FinishedSpans() {
+		if span.OperationName != "fail" {
+			continue
+		}
+		var spanEvents []string
+		for _, record := range span.Logs() {
+			fields := make(map[string]interface{})
+			for _, field := range record.Fields {
+				fields[field.Key] = field.ValueString
+			}
+			switch fields["event"] {
+			case "state":
+				spanEvents = append(spanEvents, fields["task.state"].(string))
+			case "retry":
+				assert.Equal(t, "1s", fields["retry.backoff"])
+				assert.Equal(t, "0", fields["retry.remaining"])
+				spanEvents = append(spanEvents, "retry scheduled")
+			}
+		}
+		events = append(events, spanEvents)
+	}
+
+	assert.Equal(t, [][]string{
+		{tasks.StateReceived, tasks.StateStarted, tasks.StateRetry, "retry scheduled"},
+		{tasks.StateReceived, tasks.StateStarted, tasks.StateFailure},
+	}, events)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	server.GetConfig().RetryPolicy = &config.RetryPolicyConfig{Base: 2, Multiplier: 3}
+	err := server.RegisterTask("fail", func() error { return errors.New("boom") })
+	assert.NoError(t, err)
+	worker := server.NewWorker("test_worker", 0)
+
+	// Retries are delayed exponentially by the configured policy
+	_, err = server.SendTask(&tasks.Signature{Name: "fail", RetryCount: 3})
+	assert.NoError(t, err)
+	var retried *tasks.Signature
+	for _, expected := range []int{2, 6} {
+		assert.NoError(t, worker.Process(broker.next()))
+		retried = broker.peek()
+		if !assert.NotNil(t, retried) {
+			return
+		}
+		assert.Equal(t, expected, retried.RetryTimeout)
+		assert.WithinDuration(t, time.Now().Add(time.Duration(expected)*time.Second), *retried.ETA, time.Second)
+	}
+	assert.Equal(t, 2, retried.RetryAttempt)
+
+	// Signatures override the configured policy
+	broker.next()
+	_, err = server.SendTask(&tasks.Signature{
+		Name:        "fail",
+		RetryCount:  2,
+		RetryPolicy: &tasks.RetryPolicy{Base: time.Minute, Max: 90 * time.Second},
+	})
+	assert.NoError(t, err)
+	for _, expected := range []int{60, 90} {
+		assert.NoError(t, worker.Process(broker.next()))
+		if retried = broker.peek(); assert.NotNil(t, retried) {
+			assert.Equal(t, expected, retried.RetryTimeout)
+		}
+	}
+
+	_, err = server.SendTask(&tasks.Signature{Name: "fail", RetryPolicy: &tasks.RetryPolicy{Multiplier: 0.5}})
+	assert.Error(t, err)
+}
+
+func TestPermanentAndRetryableErrors(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	err := server.RegisterTasks(map[string]interface{}{
+		"validate": func() error { return tasks.Permanent(errors.New("invalid email")) },
+		"call_api": func() error { return tasks.Retryable(errors.New("rate limited"), 90*time.Second) },
+	})
+	assert.NoError(t, err)
+	worker := server.NewWorker("test_worker", 0)
+
+	// Permanent errors fail the task right away
+	signature := &tasks.Signature{UUID: "task_1", Name: "validate", RetryCount: 3}
+	assert.NoError(t, worker.Process(signature))
+	assert.Nil(t, broker.next())
+	state, err := server.GetBackend().GetState(signature.UUID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, tasks.StateFailure, state.State)
+		assert.Equal(t, "invalid email", state.Error)
+	}
+
+	// Retryable errors are retried after their delay, while retries remain
+	signature = &tasks.Signature{UUID: "task_2", Name: "call_api", RetryCount: 1}
+	assert.NoError(t, worker.Process(signature))
+	retried := broker.next()
+	if assert.NotNil(t, retried) {
+		assert.Equal(t, 0, retried.RetryCount)
+		assert.Equal(t, 90, retried.RetryTimeout)
+		assert.WithinDuration(t, time.Now().Add(90*time.Second), *retried.ETA, time.Second)
+		assert.NoError(t, worker.Process(retried))
+	}
+	assert.Nil(t, broker.next())
+	state, err = server.GetBackend().GetState(signature.UUID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, tasks.StateFailure, state.State)
+	}
+
+	assert.True(t, errors.Is(tasks.Permanent(io.EOF), tasks.ErrPermanent))
+	assert.True(t, errors.Is(tasks.Permanent(io.EOF), io.EOF))
+	assert.False(t, errors.Is(io.EOF, tasks.ErrPermanent))
+	assert.True(t, errors.Is(tasks.Retryable(io.EOF, time.Second), io.EOF))
+	assert.Nil(t, tasks.Permanent(nil))
+	assert.Nil(t, tasks.Retryable(nil, time.Second))
+}
+
+func TestRetryTaskLaterWithBackoff(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	var attempts []int
+	err := server.RegisterTask("poll", func(ctx context.Context) error {
+		attempts = append(attempts, tasks.RetryAttemptFromContext(ctx))
+		return tasks.NewErrRetryTaskLaterWithBackoff("not ready", func(attempt int) time.Duration {
+			return time.Duration(attempt+1) * time.Minute
+		})
+	})
+	assert.NoError(t, err)
+	worker := server.NewWorker("test_worker", 0)
+
+	// The backoff gets the retry attempt, which tasks find in their context
+	_, err = server.SendTask(&tasks.Signature{Name: "poll"})
+	assert.NoError(t, err)
+	for _, expected := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute} {
+		assert.NoError(t, worker.Process(broker.next()))
+		retried := broker.peek()
+		if !assert.NotNil(t, retried) {
+			return
+		}
+		assert.WithinDuration(t, time.Now().Add(expected), *retried.ETA, time.Second)
+	}
+	assert.Equal(t, []int{0, 1, 2}, attempts)
+	assert.Equal(t, 0, tasks.RetryAttemptFromContext(context.Background()))
+}
+
+func TestEncryption(t *testing.T) {
+	t.Parallel()
+
+	keys, err := tasks.NewStaticKeyProvider("k1", map[string][]byte{"k1": make([]byte, 32)})
+	assert.NoError(t, err)
+
+	server, broker := newRecordingServer(t)
+	server.SetEncryption(keys)
+	err = server.RegisterTask("greet", func(name string) (string, error) { return "hello " + name, nil })
+	assert.NoError(t, err)
+	err = server.RegisterTask("shout", func(prefix, greeting string) (string, error) { return prefix + strings.ToUpper(greeting), nil })
+	assert.NoError(t, err)
+
+	chain, err := tasks.NewChain(
+		&tasks.Signature{Name: "greet", Args: []tasks.Arg{{Type: "string", Value: "alice"}}},
+		&tasks.Signature{Name: "shout", Args: []tasks.Arg{{Type: "string", Value: "> "}}},
+	)
+	assert.NoError(t, err)
+	chainResult, err := server.SendChain(chain)
+	assert.NoError(t, err)
+
+	worker := server.NewWorker("test_worker", 0)
+	for signature := broker.next(); signature != nil; signature = broker.next() {
+		encoded, err := json.Marshal(signature)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(encoded), "alice")
+		assert.NotContains(t, string(encoded), "hello")
+		assert.NoError(t, worker.Process(signature))
+	}
+
+	results, err := chainResult.GetWithTimeout(time.Second, time.Millisecond)
+	if assert.NoError(t, err) && assert.Len(t, results, 1) {
+		assert.Equal(t, "> HELLO ALICE", results[0].Interface())
+	}
+}
+
+func TestQueuePolicies(t *testing.T) {
+	t.Parallel()
+
+	keys, err := tasks.NewStaticKeyProvider("k1", map[string][]byte{"k1": make([]byte, 32)})
+	assert.NoError(t, err)
+
+	server, broker := newRecordingServer(t)
+	server.SetConfig(&config.Config{
+		DefaultQueue: "legacy",
+		Compression:  &config.CompressionConfig{Algorithm: tasks.CompressionZstd, Threshold: 100, Args: true},
+		QueueCompression: map[string]*config.CompressionConfig{
+			"legacy": {},
+		},
+	})
+	server.SetQueueEncryption("secure", keys)
+	err = server.RegisterTask("echo", func(s string) (string, error) { return s, nil })
+	assert.NoError(t, err)
+
+	large := strings.Repeat("machinery", 100)
+	legacyResult, err := server.SendTask(&tasks.Signature{Name: "echo", Args: []tasks.Arg{{Type: "string", Value: large}}})
+	assert.NoError(t, err)
+	secureResult, err := server.SendTask(&tasks.Signature{Name: "echo", RoutingKey: "secure", Args: []tasks.Arg{{Type: "string", Value: large}}})
+	assert.NoError(t, err)
+
+	worker := server.NewWorker("test_worker", 0)
+	for signature := broker.next(); signature != nil; signature = broker.next() {
+		if signature.RoutingKey == "secure" {
+			assert.Equal(t, tasks.CompressionZstd, signature.Headers[tasks.ArgsEncodingHeader])
+			assert.NotNil(t, signature.Headers[tasks.ArgsEncryptionHeader])
+		} else {
+			assert.Nil(t, signature.Headers[tasks.ArgsEncodingHeader])
+			assert.Nil(t, signature.Headers[tasks.ArgsEncryptionHeader])
+			assert.Equal(t, large, signature.Args[0].Value)
+		}
+		assert.NoError(t, worker.Process(signature))
+	}
+
+	state, err := server.GetBackend().GetState(legacyResult.Signature.UUID)
+	if assert.NoError(t, err) && assert.Len(t, state.Results, 1) {
+		assert.Equal(t, large, state.Results[0].Value)
+	}
+
+	results, err := legacyResult.GetWithTimeout(time.Second, time.Millisecond)
+	if assert.NoError(t, err) && assert.Len(t, results, 1) {
+		assert.Equal(t, large, results[0].Interface())
+	}
+	results, err = secureResult.GetWithTimeout(time.Second, time.Millisecond)
+	if assert.NoError(t, err) && assert.Len(t, results, 1) {
+		assert.Equal(t, large, results[0].Interface())
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	t.Parallel()
+
+	server, broker := newRecordingServer(t)
+	server.SetDefaultHeaders(tasks.Headers{"service": "billing"})
+	server.SetPropagatedHeaders("request-id")
+	err := server.RegisterTasks(map[string]interface{}{
+		"spawn": func(ctx context.Context) error {
+			_, err := server.SendTaskWithContext(ctx, &tasks.Signature{Name: "leaf"})
+			return err
+		},
+		"leaf": func() error { return nil },
+	})
+	assert.NoError(t, err)
+
+	_, err = server.SendTask(&tasks.Signature{
+		Name:      "spawn",
+		Headers:   tasks.Headers{"request-id": "request_1", "service": "checkout"},
+		OnSuccess: []*tasks.Signature{{Name: "leaf"}},
+	})
+	assert.NoError(t, err)
+
+	worker := server.NewWorker("test_worker", 0)
+	var leaves int
+	for signature := broker.next(); signature != nil; signature = broker.next() {
+		if signature.Name == "spawn" {
+			assert.Equal(t, "checkout", signature.Headers["service"])
+		} else {
+			leaves++
+			assert.Equal(t, "billing", signature.Headers["service"])
+		}
+		assert.Equal(t, "request_1", signature.Headers["request-id"])
+		assert.NoError(t, worker.Process(signature))
+	}
+	assert.Equal(t, 2, leaves)
+}
+
+// drainingBroker processes published tasks when the worker starts consuming
+type drainingBroker struct {
+	recordingBroker
+}
+
+func (b *drainingBroker) StartConsuming(consumerTag string, concurrency int, p iface.TaskProcessor) (bool, error) {
+	for signature := b.next(); signature != nil; signature = b.next() {
+		if err := p.Process(signature); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+func TestRunReport(t *testing.T) {
+	t.Parallel()
+
+	cnf := &config.Config{NoUnixSignals: true}
+	broker := &drainingBroker{recordingBroker{Broker: common.NewBroker(cnf)}}
+	server := machinery.NewServer(cnf, broker, backend.New(), lock.New())
+	err := server.RegisterTasks(map[string]interface{}{
+		"ok": func() error {
+			time.Sleep(10 * time.Millisecond)
+			return nil
+		},
+		"fail": func() error { return errors.New("boom") },
+	})
+	assert.NoError(t, err)
+
+	for _, signature := range []*tasks.Signature{{Name: "ok"}, {Name: "ok"}, {Name: "fail", RetryCount: 1}} {
+		_, err := server.SendTask(signature)
+		assert.NoError(t, err)
+	}
+
+	var reports []*machinery.RunReport
+	worker := server.NewWorker("test_worker", 0)
+	worker.SetRunReportHandler(time.Hour, func(report *machinery.RunReport) {
+		reports = append(reports, report)
+	})
+	assert.NoError(t, worker.Launch())
+
+	// The last report is emitted when the worker stops
+	if !assert.Len(t, reports, 1) {
+		return
+	}
+	report := reports[0]
+	assert.Equal(t, "test_worker", report.ConsumerTag)
+	assert.Equal(t, 0, report.InFlight)
+
+	ok := report.Tasks["ok"]
+	if assert.NotNil(t, ok) {
+		assert.Equal(t, 2, ok.Processed)
+		assert.Equal(t, 2, ok.Succeeded)
+		assert.Equal(t, 1.0, ok.SuccessRate())
+		assert.True(t, ok.P95Duration >= 10*time.Millisecond, "p95 duration %s", ok.P95Duration)
+	}
+
+	fail := report.Tasks["fail"]
+	if assert.NotNil(t, fail) {
+		assert.Equal(t, 2, fail.Processed)
+		assert.Equal(t, 1, fail.Retried)
+		assert.Equal(t, 1, fail.Failed)
+		assert.Equal(t, 0.0, fail.SuccessRate())
+	}
+}
+
+// redrivingBroker delivers tasks as if they were redriven from a dead-letter
+// queue which still holds some messages
+type redrivingBroker struct {
+	recordingBroker
+	deadLetters int
+}
+
+func (b *redrivingBroker) StartConsuming(consumerTag string, concurrency int, p iface.TaskProcessor) (bool, error) {
+	for signature := b.next(); signature != nil; signature = b.next() {
+		signature.ReceiveCount = 2
+		if err := p.Process(signature); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+func (b *redrivingBroker) DeadLetterDepth(queue string) (int, error) {
+	return b.deadLetters, nil
+}
+
+func TestRunReportRedriven(t *testing.T) {
+	t.Parallel()
+
+	cnf := &config.Config{NoUnixSignals: true}
+	broker := &redrivingBroker{recordingBroker: recordingBroker{Broker: common.NewBroker(cnf)}, deadLetters: 3}
+	server := machinery.NewServer(cnf, broker, backend.New(), lock.New())
+	assert.NoError(t, server.RegisterTask("ok", func() error { return nil }))
+
+	asyncResult, err := server.SendTask(&tasks.Signature{Name: "ok"})
+	assert.NoError(t, err)
+
+	var reports []*machinery.RunReport
+	worker := server.NewWorker("test_worker", 0)
+	worker.SetRunReportHandler(time.Hour, func(report *machinery.RunReport) {
+		reports = append(reports, report)
+	})
+	assert.NoError(t, worker.Launch())
+
+	if assert.Len(t, reports, 1) {
+		assert.Equal(t, 3, reports[0].DeadLetters)
+		ok := reports[0].Tasks["ok"]
+		if assert.NotNil(t, ok) {
+			assert.Equal(t, 1, ok.Succeeded)
+			assert.Equal(t, 1, ok.Redelivered)
+		}
+	}
+
+	// The redriven task still completes
+	taskState, err := server.GetBackend().GetState(asyncResult.Signature.UUID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, tasks.StateSuccess, taskState.State)
+	}
+}